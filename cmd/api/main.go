@@ -31,13 +31,18 @@ import (
 	"github.com/provideplatform/nchain/common"
 	"github.com/provideplatform/nchain/connector"
 	"github.com/provideplatform/nchain/contract"
+	"github.com/provideplatform/nchain/deadletter"
 	"github.com/provideplatform/nchain/filter"
 	"github.com/provideplatform/nchain/network"
 	"github.com/provideplatform/nchain/oracle"
+	"github.com/provideplatform/nchain/policy"
 	"github.com/provideplatform/nchain/prices"
+	"github.com/provideplatform/nchain/safe"
+	"github.com/provideplatform/nchain/schedule"
 	"github.com/provideplatform/nchain/token"
 	"github.com/provideplatform/nchain/tx"
 	"github.com/provideplatform/nchain/wallet"
+	"github.com/provideplatform/nchain/webhook"
 
 	pgputil "github.com/kthomas/go-pgputil"
 	redisutil "github.com/kthomas/go-redisutil"
@@ -147,14 +152,19 @@ func runAPI() {
 	r.Use(identcommon.RateLimitingMiddleware())
 
 	network.InstallNetworksAPI(r)
+	policy.InstallPoliciesAPI(r)
 	prices.InstallPricesAPI(r)
+	safe.InstallSafeAPI(r)
+	schedule.InstallScheduledTransactionsAPI(r)
 	connector.InstallConnectorsAPI(r)
 	contract.InstallContractsAPI(r)
+	deadletter.InstallDeadLettersAPI(r)
 	oracle.InstallOraclesAPI(r)
 	token.InstallTokensAPI(r)
 	tx.InstallTransactionsAPI(r)
 	wallet.InstallAccountsAPI(r)
 	wallet.InstallWalletsAPI(r)
+	webhook.InstallWebhooksAPI(r)
 
 	srv = &http.Server{
 		Addr:    util.ListenAddr,
@@ -31,8 +31,12 @@ import (
 	_ "github.com/provideplatform/nchain/connector"
 	_ "github.com/provideplatform/nchain/consumer"
 	_ "github.com/provideplatform/nchain/contract"
+	_ "github.com/provideplatform/nchain/deadletter"
 	_ "github.com/provideplatform/nchain/network"
+	_ "github.com/provideplatform/nchain/safe"
+	_ "github.com/provideplatform/nchain/schedule"
 	_ "github.com/provideplatform/nchain/tx"
+	_ "github.com/provideplatform/nchain/webhook"
 )
 
 const natsStreamingSubscriptionStatusTickerInterval = 5 * time.Second
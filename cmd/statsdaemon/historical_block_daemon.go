@@ -100,8 +100,10 @@ func EthereumHistoricalBlockDataSourceFactory(network *network.Network) *Histori
 
 		Poll: func(ch chan *provide.NetworkStatus) error {
 			// json rpc call to eth_getBlockByNumber
-			jsonRpcURL := network.RPCURL()
-			if jsonRpcURL == "" {
+			jsonRpcURL, err := network.RPCURL()
+			if err != nil {
+				return err
+			} else if jsonRpcURL == "" {
 				err := new(jsonRpcNotSupported)
 				return *err
 			}
@@ -416,7 +418,12 @@ func NewHistoricalBlockStatsDaemon(lg *logger.Logger, network *network.Network)
 
 	chainID := network.ChainID
 	if chainID == nil {
-		chn, err := providecrypto.EVMGetChainID(network.ID.String(), network.RPCURL())
+		rpcClientKey, rpcURL, err := network.RPCEndpoint()
+		if err != nil {
+			common.Log.Debugf("Error resolving RPC URL of %s network. Error: %s", network.ID.String(), err.Error())
+			return nil
+		}
+		chn, err := providecrypto.EVMGetChainID(rpcClientKey, rpcURL)
 		if err != nil {
 			common.Log.Debugf("Error getting chain ID of %s network. Error: %s", network.ID.String(), err.Error())
 			return nil
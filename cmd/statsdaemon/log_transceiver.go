@@ -81,8 +81,10 @@ func EthereumLogTransceiverFactory(network *network.Network) *LogTransceiver {
 			wsConn, _, err := wsDialer.Dial(websocketURL, nil)
 			if err != nil {
 				common.Log.Errorf("failed to establish network logs websocket connection to %s; %s", websocketURL, err.Error())
+				network.RecordEndpointFailure(websocketURL)
 			} else {
 				defer wsConn.Close()
+				defer network.WatchWebsocketFailover(websocketURL, wsConn)()
 				id, _ := uuid.NewV4()
 				payload := map[string]interface{}{
 					"method":  "eth_subscribe",
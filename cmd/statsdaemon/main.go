@@ -116,6 +116,7 @@ func monitorNetworkDaemonInstances() {
 					if evict {
 						common.Log.Debugf("evicting network statsdaemon and log transceiver: %s", networkID)
 						EvictNetworkLogTransceiver(currentLogTransceivers[networkID].Network)
+						network.EvictEndpointHealthChecker(currentNetworkStats[networkID].dataSource.Network)
 						EvictNetworkStatsDaemon(currentNetworkStats[networkID].dataSource.Network)
 					}
 				}
@@ -134,6 +135,7 @@ func requireNetworkDaemonInstances() []*network.Network {
 	dbconf.DatabaseConnection().Where("user_id IS NULL AND enabled IS TRUE").Find(&networks)
 
 	for _, ntwrk := range networks {
+		network.RequireEndpointHealthChecker(ntwrk)
 		RequireNetworkLogTransceiver(ntwrk)
 		RequireNetworkStatsDaemon(ntwrk)
 		//RequireHistoricalBlockStatsDaemon(ntwrk)
@@ -128,8 +128,10 @@ func BaseledgerNetworkStatsDataSourceFactory(network *network.Network) *NetworkS
 			wsConn, _, err := wsDialer.Dial(websocketURL, nil)
 			if err != nil {
 				common.Log.Errorf("Failed to establish network stats websocket connection to %s; %s", websocketURL, err.Error())
+				network.RecordEndpointFailure(websocketURL)
 			} else {
 				defer wsConn.Close()
+				defer network.WatchWebsocketFailover(websocketURL, wsConn)()
 				// { "jsonrpc": "2.0", "method": "subscribe", "params": ["tm.event='NewBlock'"], "id": 1 }
 				payload := map[string]interface{}{
 					"method":  "subscribe",
@@ -360,8 +362,10 @@ func EthereumNetworkStatsDataSourceFactory(network *network.Network) *NetworkSta
 			wsConn, _, err := wsDialer.Dial(websocketURL, nil)
 			if err != nil {
 				common.Log.Errorf("failed to establish network stats websocket connection to %s; %s", websocketURL, err.Error())
+				network.RecordEndpointFailure(websocketURL)
 			} else {
 				defer wsConn.Close()
+				defer network.WatchWebsocketFailover(websocketURL, wsConn)()
 				id, _ := uuid.NewV4()
 				payload := map[string]interface{}{
 					"method":  "eth_subscribe",
@@ -782,7 +786,12 @@ func NewNetworkStatsDaemon(lg *logger.Logger, network *network.Network) *StatsDa
 
 	chainID := network.ChainID
 	if chainID == nil {
-		chn, err := providecrypto.EVMGetChainID(network.ID.String(), network.RPCURL())
+		rpcClientKey, rpcURL, err := network.RPCEndpoint()
+		if err != nil {
+			common.Log.Warningf("failed to resolve rpc url for %s network. Error: %s", network.ID.String(), err.Error())
+			return nil
+		}
+		chn, err := providecrypto.EVMGetChainID(rpcClientKey, rpcURL)
 		if err != nil {
 			common.Log.Warningf("failed to retrieve chain id for %s network. Error: %s", network.ID.String(), err.Error())
 			return nil
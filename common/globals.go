@@ -33,6 +33,12 @@ import (
 )
 
 const defaultDockerhubOrganization = "provide"
+
+// KeyStorageVault stores key material in vault; signing requests are made to the vault API
+const KeyStorageVault = "vault"
+
+// KeyStorageKeystore stores key material locally, encrypted using Web3 Secret Storage (scrypt)
+const KeyStorageKeystore = "keystore"
 const reachabilityTimeout = time.Millisecond * 2500
 
 const refreshTokenTickInterval = 60000 * 45 * time.Millisecond  // 45 minutes
@@ -66,6 +72,12 @@ var (
 	// DefaultKey for this instance of nchain
 	DefaultKey *vault.Key
 
+	// DefaultKeyStorage is the key storage used for accounts and HD wallets which do not specify one (i.e., vault or keystore)
+	DefaultKeyStorage string
+
+	// KeystorePassphrase is the passphrase used to encrypt key material held in the local keystore
+	KeystorePassphrase string
+
 	// TxFilters contains in-memory Filter instances used for real-time stream processing
 	TxFilters = map[string][]interface{}{}
 
@@ -105,6 +117,12 @@ func init() {
 
 	DefaultAWSConfig = awsconf.GetConfig()
 	ConsumeNATSStreamingSubscriptions = strings.ToLower(os.Getenv("CONSUME_NATS_STREAMING_SUBSCRIPTIONS")) == "true"
+
+	DefaultKeyStorage = KeyStorageVault
+	if os.Getenv("KEY_STORAGE") != "" {
+		DefaultKeyStorage = strings.ToLower(os.Getenv("KEY_STORAGE"))
+	}
+	KeystorePassphrase = os.Getenv("KEYSTORE_PASSPHRASE")
 }
 
 func RequireInfrastructureSupport() {
@@ -207,6 +225,11 @@ func refreshPaymentsAccessToken() error {
 }
 
 func RequireVault() {
+	if DefaultKeyStorage == KeyStorageKeystore && os.Getenv("VAULT_REFRESH_TOKEN") == "" {
+		Log.Debug("vault not configured; key material will be held in the local keystore")
+		return
+	}
+
 	util.RequireVault()
 
 	vaults, err := vault.ListVaults(util.DefaultVaultAccessJWT, map[string]interface{}{})
@@ -20,17 +20,51 @@ import (
 	"crypto/ecdsa"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	ethcrypto "github.com/ethereum/go-ethereum/crypto"
 	pgputil "github.com/kthomas/go-pgputil"
+	"github.com/nats-io/nats.go"
 	bookie "github.com/provideplatform/provide-go/api/bookie"
+	providecrypto "github.com/provideplatform/provide-go/crypto"
 )
 
 var natsStreamingConnectionMutex sync.Mutex
 var natsStreamingConnectionDrainTimeout = 10 * time.Second
 
+// NakWithDelay negatively acknowledges the given message, asking the server to redeliver it
+// after the given delay; the vendored nats client predates NakWithDelay, so the nak is sent
+// as a raw ack reply
+func NakWithDelay(msg *nats.Msg, delay time.Duration) {
+	err := msg.Respond([]byte(fmt.Sprintf("-NAK {\"delay\": %d}", delay.Nanoseconds())))
+	if err != nil {
+		Log.Warningf("failed to nak NATS message on subject: %s with delay of %v; %s", msg.Subject, delay, err.Error())
+		msg.Nak()
+	}
+}
+
+// ParseMethodSelector returns the 4-byte method selector of the given selector (i.e., 0xa9059cbb)
+// or method signature (i.e., transfer(address,uint256))
+func ParseMethodSelector(method string) (string, error) {
+	if strings.HasPrefix(method, "0x") {
+		selector, err := hexutil.Decode(method)
+		if err != nil || len(selector) != 4 {
+			return "", fmt.Errorf("invalid method selector: %s", method)
+		}
+		return strings.ToLower(method), nil
+	}
+
+	if !strings.Contains(method, "(") || !strings.HasSuffix(method, ")") {
+		return "", fmt.Errorf("invalid method signature: %s", method)
+	}
+
+	return fmt.Sprintf("0x%s", providecrypto.EVMHashFunctionSelector(strings.ReplaceAll(method, " ", ""))), nil
+}
+
 // BroadcastTransaction attempts to broadcast arbitrary calldata to the specified recipient
 // using the Provide Payments API
 func BroadcastTransaction(to, calldata *string, params map[string]interface{}) (*string, error) {
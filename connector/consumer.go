@@ -27,6 +27,7 @@ import (
 	uuid "github.com/kthomas/go.uuid"
 	"github.com/nats-io/nats.go"
 	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/deadletter"
 )
 
 const defaultNatsStream = "nchain"
@@ -137,7 +138,7 @@ func createNatsConnectorDenormalizeConfigSubscriptions(wg *sync.WaitGroup) {
 func consumeConnectorProvisioningMsg(msg *nats.Msg) {
 	defer func() {
 		if r := recover(); r != nil {
-			msg.Term()
+			deadletter.Term(msg, fmt.Sprintf("recovered from panic; %s", r))
 		}
 	}()
 
@@ -154,7 +155,7 @@ func consumeConnectorProvisioningMsg(msg *nats.Msg) {
 	connectorID, connectorIDOk := params["connector_id"].(string)
 	if !connectorIDOk {
 		common.Log.Warningf("failed to provision connector; no connector id provided")
-		msg.Term()
+		deadletter.Term(msg, "failed to provision connector; no connector id provided")
 		return
 	}
 
@@ -164,7 +165,7 @@ func consumeConnectorProvisioningMsg(msg *nats.Msg) {
 	db.Where("id = ?", connectorID).Find(&connector)
 	if connector == nil || connector.ID == uuid.Nil {
 		common.Log.Warningf("failed to provision connector; no connector resolved for id: %s", connectorID)
-		msg.Term()
+		deadletter.Term(msg, fmt.Sprintf("failed to provision connector; no connector resolved for id: %s", connectorID))
 		return
 	}
 
@@ -181,7 +182,7 @@ func consumeConnectorProvisioningMsg(msg *nats.Msg) {
 func consumeConnectorDeprovisioningMsg(msg *nats.Msg) {
 	defer func() {
 		if r := recover(); r != nil {
-			msg.Term()
+			deadletter.Term(msg, fmt.Sprintf("recovered from panic; %s", r))
 		}
 	}()
 
@@ -198,7 +199,7 @@ func consumeConnectorDeprovisioningMsg(msg *nats.Msg) {
 	connectorID, connectorIDOk := params["connector_id"].(string)
 	if !connectorIDOk {
 		common.Log.Warningf("failed to deprovision connector; no connector id provided")
-		msg.Term()
+		deadletter.Term(msg, "failed to deprovision connector; no connector id provided")
 		return
 	}
 
@@ -208,7 +209,7 @@ func consumeConnectorDeprovisioningMsg(msg *nats.Msg) {
 	db.Where("id = ?", connectorID).Find(&connector)
 	if connector == nil || connector.ID == uuid.Nil {
 		common.Log.Warningf("failed to deprovision connector; no connector resolved for id: %s", connectorID)
-		msg.Term()
+		deadletter.Term(msg, fmt.Sprintf("failed to deprovision connector; no connector resolved for id: %s", connectorID))
 		return
 	}
 
@@ -225,7 +226,7 @@ func consumeConnectorDeprovisioningMsg(msg *nats.Msg) {
 func consumeConnectorDenormalizeConfigMsg(msg *nats.Msg) {
 	defer func() {
 		if r := recover(); r != nil {
-			msg.Term()
+			deadletter.Term(msg, fmt.Sprintf("recovered from panic; %s", r))
 		}
 	}()
 
@@ -242,7 +243,7 @@ func consumeConnectorDenormalizeConfigMsg(msg *nats.Msg) {
 	connectorID, connectorIDOk := params["connector_id"].(string)
 	if !connectorIDOk {
 		common.Log.Warningf("failed to denormalize connector config; no connector id provided")
-		msg.Term()
+		deadletter.Term(msg, "failed to denormalize connector config; no connector id provided")
 		return
 	}
 
@@ -269,7 +270,7 @@ func consumeConnectorDenormalizeConfigMsg(msg *nats.Msg) {
 func consumeConnectorResolveReachabilityMsg(msg *nats.Msg) {
 	defer func() {
 		if r := recover(); r != nil {
-			msg.Term()
+			deadletter.Term(msg, fmt.Sprintf("recovered from panic; %s", r))
 		}
 	}()
 
@@ -286,7 +287,7 @@ func consumeConnectorResolveReachabilityMsg(msg *nats.Msg) {
 	connectorID, connectorIDOk := params["connector_id"].(string)
 	if !connectorIDOk {
 		common.Log.Warningf("failed to resolve connector reachability; no connector id provided")
-		msg.Term()
+		deadletter.Term(msg, "failed to resolve connector reachability; no connector id provided")
 		return
 	}
 
@@ -296,7 +297,7 @@ func consumeConnectorResolveReachabilityMsg(msg *nats.Msg) {
 	db.Where("id = ?", connectorID).Find(&connector)
 	if connector == nil || connector.ID == uuid.Nil {
 		common.Log.Warningf("failed to resolve connector reachability; no connector resolved for id: %s", connectorID)
-		msg.Term()
+		deadletter.Term(msg, fmt.Sprintf("failed to resolve connector reachability; no connector resolved for id: %s", connectorID))
 		return
 	}
 
@@ -25,6 +25,7 @@ import (
 	"github.com/kthomas/go-natsutil"
 	"github.com/nats-io/nats.go"
 	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/deadletter"
 )
 
 const defaultNatsStream = "nchain"
@@ -106,32 +107,32 @@ func consumePacketFragmentIngestMsg(msg *nats.Msg) {
 
 	if fragment.Checksum == nil {
 		common.Log.Warning("failed to ingest packet fragment; nil checksum")
-		msg.Term()
+		deadletter.Term(msg, "failed to ingest packet fragment; nil checksum")
 		return
 	}
 
 	if fragment.Payload == nil {
 		common.Log.Warning("failed to ingest packet fragment; nil payload")
-		msg.Term()
+		deadletter.Term(msg, "failed to ingest packet fragment; nil payload")
 		return
 	}
 
 	if fragment.Cardinality == 0 {
 		common.Log.Warning("failed to ingest packet fragment; cardinality must be greater than zero")
-		msg.Term()
+		deadletter.Term(msg, "failed to ingest packet fragment; cardinality must be greater than zero")
 		return
 	}
 
 	if fragment.Index >= fragment.Cardinality {
 		common.Log.Warning("failed to ingest packet fragment; fragment index must be less than the packet cardinality")
-		msg.Term()
+		deadletter.Term(msg, "failed to ingest packet fragment; fragment index must be less than the packet cardinality")
 		return
 	}
 
 	if fragment.Index == 0 {
 		if fragment.Reassembly == nil {
 			common.Log.Warning("failed to ingest packet fragment; reassembly 'header' required within 'fragment 0' encapsulation")
-			msg.Term()
+			deadletter.Term(msg, "failed to ingest packet fragment; reassembly 'header' required within 'fragment 0' encapsulation")
 			return
 		}
 	}
@@ -183,19 +184,19 @@ func consumePacketReassembleMsg(msg *nats.Msg) {
 
 	if reassembly.Checksum == nil {
 		common.Log.Warning("failed to reassemble packet; nil checksum")
-		msg.Term()
+		deadletter.Term(msg, "failed to reassemble packet; nil checksum")
 		return
 	}
 
 	if reassembly.Next == nil {
 		common.Log.Warning("failed to reassemble packet; next hop not specified") // TODO-- relax this to support pure p2p file transfer
-		msg.Term()
+		deadletter.Term(msg, "failed to reassemble packet; next hop not specified")
 		return
 	}
 
 	if reassembly.Cardinality == 0 {
 		common.Log.Warning("failed to reassemble packet; cardinality must be greater than zero")
-		msg.Term()
+		deadletter.Term(msg, "failed to reassemble packet; cardinality must be greater than zero")
 		return
 	}
 
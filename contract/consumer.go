@@ -31,6 +31,7 @@ import (
 	uuid "github.com/kthomas/go.uuid"
 	"github.com/nats-io/nats.go"
 	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/deadletter"
 	"github.com/provideplatform/nchain/network"
 	"github.com/provideplatform/provide-go/api/nchain"
 )
@@ -207,7 +208,7 @@ func consumeEVMLogTransceiverEventMsg(ntwrk *network.Network, msg *nats.Msg, evt
 		abievt, err := contractABI.EventByID(eventID)
 		if err != nil {
 			common.Log.Warningf("failed to publish log emission event with id: %s; %s", eventIDHex, err.Error())
-			msg.Term()
+			deadletter.Term(msg, fmt.Sprintf("failed to publish log emission event with id: %s; %s", eventIDHex, err.Error()))
 			return
 		}
 
@@ -265,11 +266,11 @@ func consumeEVMLogTransceiverEventMsg(ntwrk *network.Network, msg *nats.Msg, evt
 			}
 		} else {
 			common.Log.Tracef("dropping %d-byte log emission event on the floor; contract not configured for pub/sub fanout", len(msg.Data))
-			msg.Term()
+			msg.Ack()
 		}
 	} else {
 		common.Log.Tracef("dropping anonymous %d-byte log emission event on the floor", len(msg.Data))
-		msg.Term()
+		msg.Ack()
 	}
 }
 
@@ -324,12 +325,12 @@ func consumeLogTransceiverEmitMsg(msg *nats.Msg) {
 
 	if evtmsg.Address == nil {
 		common.Log.Warningf("failed to process log transceiver event emission message; no contract address provided")
-		msg.Term()
+		deadletter.Term(msg, "failed to process log transceiver event emission message; no contract address provided")
 		return
 	}
 	if networkUUIDErr != nil {
 		common.Log.Warningf("failed to process log transceiver event emission message; invalid or no network id provided")
-		msg.Term()
+		deadletter.Term(msg, "failed to process log transceiver event emission message; invalid or no network id provided")
 		return
 	}
 
@@ -338,7 +339,7 @@ func consumeLogTransceiverEmitMsg(msg *nats.Msg) {
 	network := cachedNetwork(networkUUID)
 	if network == nil || network.ID == uuid.Nil {
 		common.Log.Warningf("failed to process log transceiver event emission message; network lookup failed for network id: %s", networkID)
-		msg.Term()
+		deadletter.Term(msg, fmt.Sprintf("failed to process log transceiver event emission message; network lookup failed for network id: %s", networkID))
 		return
 	}
 
@@ -346,7 +347,7 @@ func consumeLogTransceiverEmitMsg(msg *nats.Msg) {
 		consumeEVMLogTransceiverEventMsg(network, msg, evtmsg)
 	} else {
 		common.Log.Warningf("failed to process log transceiver event emission message; log events not supported for network: %s", networkID)
-		msg.Term()
+		deadletter.Term(msg, fmt.Sprintf("failed to process log transceiver event emission message; log events not supported for network: %s", networkID))
 		return
 	}
 }
@@ -371,22 +372,22 @@ func consumeNetworkContractCreateInvocationMsg(msg *nats.Msg) {
 
 	if !addrOk {
 		common.Log.Warningf("failed to create network contract; no contract address provided")
-		msg.Term()
+		deadletter.Term(msg, "failed to create network contract; no contract address provided")
 		return
 	}
 	if !networkIDOk || networkUUIDErr != nil {
 		common.Log.Warningf("failed to create network contract; invalid or no network id provided")
-		msg.Term()
+		deadletter.Term(msg, "failed to create network contract; invalid or no network id provided")
 		return
 	}
 	if !contractNameOk {
 		common.Log.Warningf("failed to create network contract; no contract name provided")
-		msg.Term()
+		deadletter.Term(msg, "failed to create network contract; no contract name provided")
 		return
 	}
 	if !abiOk {
 		common.Log.Warningf("failed to create network contract; no ABI provided")
-		msg.Term()
+		deadletter.Term(msg, "failed to create network contract; no ABI provided")
 		return
 	}
 
@@ -395,7 +396,7 @@ func consumeNetworkContractCreateInvocationMsg(msg *nats.Msg) {
 		organizationUUID, organizationUUIDErr := uuid.FromString(organizationID)
 		if organizationUUIDErr != nil {
 			common.Log.Warningf("failed to create network contract; invalid organization id provided")
-			msg.Term()
+			deadletter.Term(msg, "failed to create network contract; invalid organization id provided")
 			return
 		}
 		orgID = &organizationUUID
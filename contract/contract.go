@@ -141,6 +141,24 @@ func (c *Contract) ParseParams() map[string]interface{} {
 	return params
 }
 
+// FabricChaincode returns the name of the chaincode represented by the contract on a hyperledger
+// fabric network; the chaincode param takes precedence over the contract address
+func (c *Contract) FabricChaincode() *string {
+	if chaincode, chaincodeOk := c.ParseParams()["chaincode"].(string); chaincodeOk && chaincode != "" {
+		return &chaincode
+	}
+	return c.Address
+}
+
+// FabricChannel returns the channel on which the chaincode represented by the contract is
+// deployed, or nil if the default channel of the hyperledger fabric network should be used
+func (c *Contract) FabricChannel() *string {
+	if channel, channelOk := c.ParseParams()["channel"].(string); channelOk && channel != "" {
+		return &channel
+	}
+	return nil
+}
+
 // ExecuteEthereumContract execute an ethereum contract; returns the tx receipt or retvals,
 // depending on if the execution is asynchronous or not. If the method is non-constant, the
 // response is the tx receipt. When the method is constant, retvals are returned.
@@ -188,7 +206,67 @@ func (c *Contract) ExecuteEthereumContract(
 
 // ReadEthereumContractAbi is called from token
 func (c *Contract) ReadEthereumContractAbi() (*abi.ABI, error) {
-	var _abi *abi.ABI
+	entries, err := c.readEthereumContractAbiEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	// the vendored ABI parser predates custom errors; see ReadEthereumContractErrors
+	abientries := make([]map[string]interface{}, 0)
+	for _, entry := range entries {
+		if entryType, _ := entry["type"].(string); entryType != abiEntryTypeError {
+			abientries = append(abientries, entry)
+		}
+	}
+
+	abistr, err := json.Marshal(abientries)
+	if err != nil {
+		common.Log.Warningf("Failed to marshal ABI from contract params to json; %s", err.Error())
+		return nil, err
+	}
+
+	abival, err := abi.JSON(strings.NewReader(string(abistr)))
+	if err != nil {
+		common.Log.Warningf("Failed to initialize ABI from contract params to json; %s", err.Error())
+		return nil, err
+	}
+
+	return &abival, nil
+}
+
+// ReadEthereumContractErrors returns the custom errors declared in the contract ABI, keyed by selector
+func (c *Contract) ReadEthereumContractErrors() (map[string]*CustomError, error) {
+	entries, err := c.readEthereumContractAbiEntries()
+	if err != nil {
+		return nil, err
+	}
+
+	errs := map[string]*CustomError{}
+	for _, entry := range entries {
+		if entryType, _ := entry["type"].(string); entryType != abiEntryTypeError {
+			continue
+		}
+
+		raw, _ := json.Marshal(entry)
+		var customErr struct {
+			Name   string        `json:"name"`
+			Inputs abi.Arguments `json:"inputs"`
+		}
+		err := json.Unmarshal(raw, &customErr)
+		if err != nil {
+			common.Log.Warningf("Failed to parse custom error from ABI for contract: %s; %s", c.ID, err.Error())
+			continue
+		}
+
+		e := NewCustomError(customErr.Name, customErr.Inputs)
+		errs[hex.EncodeToString(e.ID)] = e
+	}
+
+	return errs, nil
+}
+
+// readEthereumContractAbiEntries returns the raw entries of the contract ABI
+func (c *Contract) readEthereumContractAbiEntries() ([]map[string]interface{}, error) {
 	params := c.ParseParams()
 	contractAbi, contractAbiOk := params["abi"]
 	if !contractAbiOk {
@@ -198,24 +276,24 @@ func (c *Contract) ReadEthereumContractAbi() (*abi.ABI, error) {
 		}
 	}
 
-	if contractAbi != nil {
-		abistr, err := json.Marshal(contractAbi)
-		if err != nil {
-			common.Log.Warningf("Failed to marshal ABI from contract params to json; %s", err.Error())
-			return nil, err
-		}
+	if contractAbi == nil {
+		return nil, fmt.Errorf("Failed to read ABI from params for contract: %s", c.ID)
+	}
 
-		abival, err := abi.JSON(strings.NewReader(string(abistr)))
-		if err != nil {
-			common.Log.Warningf("Failed to initialize ABI from contract params to json; %s", err.Error())
-			return nil, err
-		}
+	abistr, err := json.Marshal(contractAbi)
+	if err != nil {
+		common.Log.Warningf("Failed to marshal ABI from contract params to json; %s", err.Error())
+		return nil, err
+	}
 
-		_abi = &abival
-	} else {
-		return nil, fmt.Errorf("Failed to read ABI from params for contract: %s", c.ID)
+	var entries []map[string]interface{}
+	err = json.Unmarshal(abistr, &entries)
+	if err != nil {
+		common.Log.Warningf("Failed to initialize ABI from contract params to json; %s", err.Error())
+		return nil, err
 	}
-	return _abi, nil
+
+	return entries, nil
 }
 
 // ResolveCompiledDependencyArtifact returns the compiled artifact if matched to the given descriptor;
@@ -305,7 +383,8 @@ func (c *Contract) Create() bool {
 
 		if !db.NewRecord(c) {
 			success := rowsAffected > 0
-			if success && c.ContractID == nil { // when ContractID is non-nil, the deployment happened in a contract-internal tx
+			// fabric chaincode is installed and committed out-of-band, so no deployment tx is broadcast
+			if success && c.ContractID == nil && !c.isFabricChaincode() { // when ContractID is non-nil, the deployment happened in a contract-internal tx
 				compiledArtifact := c.CompiledArtifact()
 				if compiledArtifact != nil {
 					params := c.ParseParams()
@@ -387,6 +466,12 @@ func (c *Contract) Create() bool {
 	return false
 }
 
+// isFabricChaincode returns true if the contract represents chaincode on a hyperledger fabric network
+func (c *Contract) isFabricChaincode() bool {
+	ntwrk, err := c.GetNetwork()
+	return err == nil && ntwrk.IsHyperledgerFabricNetwork()
+}
+
 // pubsubSubjectPrefix returns a hash for use as the pub/sub subject prefix for the contract
 func (c *Contract) pubsubSubjectPrefix() *string {
 	if c.ApplicationID != nil {
@@ -446,6 +531,9 @@ func (c *Contract) ExecuteFromTx(
 	// params := execution.Params
 	gas := execution.Gas
 	gasPrice := execution.GasPrice
+	maxFeePerGas := execution.MaxFeePerGas
+	maxPriorityFeePerGas := execution.MaxPriorityFeePerGas
+	txType := execution.Type
 	nonce := execution.Nonce
 
 	//xxx add path to params
@@ -473,10 +561,34 @@ func (c *Contract) ExecuteFromTx(
 		txParams["gas_price"] = gasPrice
 	}
 
+	if maxFeePerGas != nil {
+		txParams["max_fee_per_gas"] = maxFeePerGas.String()
+	}
+
+	if maxPriorityFeePerGas != nil {
+		txParams["max_priority_fee_per_gas"] = maxPriorityFeePerGas.String()
+	}
+
+	if txType != nil {
+		txParams["type"] = *txType
+	}
+
 	if nonce != nil {
 		txParams["nonce"] = *nonce
 	}
 
+	if len(execution.PrivateFor) > 0 {
+		txParams["private_for"] = execution.PrivateFor
+	}
+
+	if execution.PrivateFrom != nil {
+		txParams["private_from"] = *execution.PrivateFrom
+	}
+
+	if execution.PrivacyFlag != nil {
+		txParams["privacy_flag"] = *execution.PrivacyFlag
+	}
+
 	// xxx add path to params
 	if path != nil {
 		txParams["hd_derivation_path"] = *path
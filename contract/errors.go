@@ -0,0 +1,74 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package contract
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const abiEntryTypeError = "error"
+
+// CustomError is a custom solidity error declared in a contract ABI, i.e. `error Name(...)`
+type CustomError struct {
+	Name   string
+	Inputs abi.Arguments
+	Sig    string // canonical signature, i.e. Name(type1,type2)
+	ID     []byte // selector, i.e. the first 4 bytes of keccak256(Sig)
+}
+
+// NewCustomError initializes a custom error with the given name and inputs
+func NewCustomError(name string, inputs abi.Arguments) *CustomError {
+	types := make([]string, len(inputs))
+	for i, input := range inputs {
+		types[i] = input.Type.String()
+	}
+
+	sig := fmt.Sprintf("%s(%s)", name, strings.Join(types, ","))
+	return &CustomError{
+		Name:   name,
+		Inputs: inputs,
+		Sig:    sig,
+		ID:     crypto.Keccak256([]byte(sig))[:4],
+	}
+}
+
+// Unpack decodes the given revert data, including the 4-byte selector, into the named error arguments
+func (e *CustomError) Unpack(data []byte) (map[string]interface{}, error) {
+	if len(data) < 4 {
+		return nil, fmt.Errorf("invalid %d-byte revert data for error: %s", len(data), e.Sig)
+	}
+
+	values, err := e.Inputs.UnpackValues(data[4:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpack revert data for error: %s; %s", e.Sig, err.Error())
+	}
+
+	args := map[string]interface{}{}
+	for i, input := range e.Inputs {
+		name := input.Name
+		if name == "" {
+			name = fmt.Sprintf("%d", i)
+		}
+		args[name] = values[i]
+	}
+
+	return args, nil
+}
@@ -48,13 +48,21 @@ type Execution struct {
 	HDPath   *string     `json:"hd_derivation_path"`
 
 	// Tx params
-	Gas       *float64      `json:"gas"`
-	GasPrice  *float64      `json:"gas_price"`
-	Nonce     *uint64       `json:"nonce"`
-	Method    string        `json:"method"`
-	Params    []interface{} `json:"params"`
-	Subsidize bool          `json:"subsidize"`
-	Value     *big.Int      `json:"value"`
+	Gas                  *float64      `json:"gas"`
+	GasPrice             *float64      `json:"gas_price"`
+	MaxFeePerGas         *json.Number  `json:"max_fee_per_gas"`          // wei; may be provided as a decimal string to preserve precision
+	MaxPriorityFeePerGas *json.Number  `json:"max_priority_fee_per_gas"` // wei; may be provided as a decimal string to preserve precision
+	Type                 *uint8        `json:"type"`                     // EIP-2718 tx type; 0 for legacy or 2 for EIP-1559 dynamic fee txs
+	Nonce                *uint64       `json:"nonce"`
+	PrivateFor           []string      `json:"private_for,omitempty"`  // base64-encoded transaction manager public keys of the recipients of a quorum private tx
+	PrivateFrom          *string       `json:"private_from,omitempty"` // base64-encoded transaction manager public key of the sender of a quorum private tx
+	PrivacyFlag          *uint64       `json:"privacy_flag,omitempty"` // quorum privacy flag; 0 for standard private, 1 for party protection or 3 for private state validation
+	Method               string        `json:"method"`
+	Params               []interface{} `json:"params"`
+	Subsidize            bool          `json:"subsidize"`
+	Value                *big.Int      `json:"value"`
+	DryRun               bool          `json:"dry_run"` // simulate the execution without signing or broadcasting a tx
+	Query                bool          `json:"query"`   // evaluate, rather than submit, a hyperledger fabric chaincode transaction
 
 	// Tx metadata/instrumentation
 	Ref         *string    `json:"ref"`
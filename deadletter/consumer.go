@@ -0,0 +1,167 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package deadletter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	natsutil "github.com/kthomas/go-natsutil"
+	"github.com/nats-io/nats.go"
+	"github.com/provideplatform/nchain/common"
+)
+
+// natsMaxDeliveriesAdvisorySubject is the subject on which JetStream publishes an advisory
+// when a message exhausts the max deliveries of a consumer
+const natsMaxDeliveriesAdvisorySubject = "$JS.EVENT.ADVISORY.CONSUMER.MAX_DELIVERIES.>"
+
+// natsMaxDeliveriesAdvisoryStream captures max deliveries advisories so they are not lost
+// while no dead letter consumer is subscribed; advisories are only published over core NATS
+const natsMaxDeliveriesAdvisoryStream = "nchain-max-deliveries-advisories"
+const natsMaxDeliveriesAdvisoryStreamMaxAge = time.Hour * 24 * 7
+const natsMaxDeliveriesAdvisoryConsumer = "nchain-deadletter"
+const natsMaxDeliveriesAdvisoryMaxInFlight = 256
+const natsMaxDeliveriesAdvisoryMaxDeliveries = 10
+const maxDeliveriesAdvisoryAckWait = time.Second * 30
+
+// maxDeliveriesAdvisory is published by JetStream when a message exhausts the max deliveries of a consumer
+type maxDeliveriesAdvisory struct {
+	Stream     string `json:"stream"`
+	Consumer   string `json:"consumer"`
+	StreamSeq  uint64 `json:"stream_seq"`
+	Deliveries uint64 `json:"deliveries"`
+}
+
+var waitGroup sync.WaitGroup
+
+func init() {
+	if !common.ConsumeNATSStreamingSubscriptions {
+		common.Log.Debug("Dead letter package consumer configured to skip NATS subscription setup")
+		return
+	}
+
+	natsutil.EstablishSharedNatsConnection(nil)
+
+	err := requireAdvisoryStream()
+	if err != nil {
+		common.Log.Panicf("failed to subscribe to NATS max deliveries advisories; %s", err.Error())
+	}
+
+	createNatsMaxDeliveriesAdvisorySubscriptions(&waitGroup)
+}
+
+// requireAdvisoryStream creates the stream which captures max deliveries advisories if it does not already exist
+func requireAdvisoryStream() error {
+	js, err := natsutil.GetSharedJetstreamContext(nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = js.StreamInfo(natsMaxDeliveriesAdvisoryStream)
+	if err == nats.ErrStreamNotFound {
+		_, err = js.AddStream(&nats.StreamConfig{
+			Name:     natsMaxDeliveriesAdvisoryStream,
+			Subjects: []string{natsMaxDeliveriesAdvisorySubject},
+			MaxAge:   natsMaxDeliveriesAdvisoryStreamMaxAge,
+			Discard:  nats.DiscardOld,
+		})
+	}
+
+	return err
+}
+
+func createNatsMaxDeliveriesAdvisorySubscriptions(wg *sync.WaitGroup) {
+	for i := uint64(0); i < natsutil.GetNatsConsumerConcurrency(); i++ {
+		natsutil.RequireNatsJetstreamSubscription(wg,
+			maxDeliveriesAdvisoryAckWait,
+			natsMaxDeliveriesAdvisorySubject,
+			natsMaxDeliveriesAdvisoryConsumer,
+			natsMaxDeliveriesAdvisoryConsumer,
+			consumeMaxDeliveriesAdvisoryMsg,
+			maxDeliveriesAdvisoryAckWait,
+			natsMaxDeliveriesAdvisoryMaxInFlight,
+			natsMaxDeliveriesAdvisoryMaxDeliveries,
+			nil,
+		)
+	}
+}
+
+// consumeMaxDeliveriesAdvisoryMsg dead-letters the message which exhausted its max deliveries;
+// the advisory is nacked for redelivery if the message cannot be dead-lettered
+func consumeMaxDeliveriesAdvisoryMsg(msg *nats.Msg) {
+	defer func() {
+		if r := recover(); r != nil {
+			common.Log.Warningf("recovered from panic during NATS max deliveries advisory handling; %s", r)
+			msg.Nak()
+		}
+	}()
+
+	var advisory *maxDeliveriesAdvisory
+	err := json.Unmarshal(msg.Data, &advisory)
+	if err != nil {
+		common.Log.Warningf("failed to unmarshal NATS max deliveries advisory; %s", err.Error())
+		msg.Term()
+		return
+	}
+
+	if strings.HasPrefix(advisory.Stream, natsDeadLetterStreamPrefix) || advisory.Stream == natsMaxDeliveriesAdvisoryStream {
+		msg.Ack()
+		return
+	}
+
+	js, err := natsutil.GetSharedJetstreamContext(nil)
+	if err != nil {
+		common.Log.Warningf("failed to dead-letter message %d in stream: %s; %s", advisory.StreamSeq, advisory.Stream, err.Error())
+		msg.Nak()
+		return
+	}
+
+	exhausted, err := js.GetMsg(advisory.Stream, advisory.StreamSeq)
+	if err != nil {
+		if IsNotFound(err) {
+			common.Log.Warningf("message %d in stream: %s which exhausted its max deliveries no longer exists; %s", advisory.StreamSeq, advisory.Stream, err.Error())
+			msg.Ack()
+			return
+		}
+
+		common.Log.Warningf("failed to dead-letter message %d in stream: %s; %s", advisory.StreamSeq, advisory.Stream, err.Error())
+		msg.Nak()
+		return
+	}
+
+	letter := &DeadLetter{
+		Subject:        exhausted.Subject,
+		Stream:         advisory.Stream,
+		Consumer:       advisory.Consumer,
+		StreamSequence: advisory.StreamSeq,
+		Reason:         fmt.Sprintf("exhausted %d deliveries", advisory.Deliveries),
+		Deliveries:     advisory.Deliveries,
+		DeadLetteredAt: time.Now(),
+		Data:           exhausted.Data,
+	}
+
+	err = letter.publish()
+	if err != nil {
+		common.Log.Warningf("failed to dead-letter message %d in stream: %s; %s", advisory.StreamSeq, advisory.Stream, err.Error())
+		msg.Nak()
+		return
+	}
+
+	msg.Ack()
+}
@@ -0,0 +1,290 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package deadletter
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	natsutil "github.com/kthomas/go-natsutil"
+	"github.com/nats-io/nats.go"
+	"github.com/provideplatform/nchain/common"
+)
+
+// natsDeadLetterSubjectPrefix is prepended to the subject of a message to resolve the subject of its dead letters
+const natsDeadLetterSubjectPrefix = "deadletter"
+
+// natsMsgNotFoundErrorDescription is the description of the error returned by JetStream for a missing or deleted message
+const natsMsgNotFoundErrorDescription = "no message found"
+
+// natsDeadLetterStreamPrefix is prepended to the sanitized subject of a message to resolve its dead-letter stream
+const natsDeadLetterStreamPrefix = "deadletter-"
+
+// natsDeadLetterStreamMaxAge is the maximum age of a dead letter, after which it is discarded
+const natsDeadLetterStreamMaxAge = time.Hour * 24 * 14
+
+// natsDeadLetterStreamMaxMsgs is the maximum number of dead letters retained per dead-letter stream;
+// the oldest dead letters are discarded when the limit is reached
+const natsDeadLetterStreamMaxMsgs = 100000
+
+// DeadLetter is a NATS message which was terminated by its consumer or exhausted its max deliveries
+type DeadLetter struct {
+	Sequence       uint64           `json:"sequence,omitempty"` // sequence of the dead letter within its dead-letter stream
+	Subject        string           `json:"subject"`
+	Stream         string           `json:"stream,omitempty"`
+	Consumer       string           `json:"consumer,omitempty"`
+	StreamSequence uint64           `json:"stream_sequence,omitempty"`
+	Reason         string           `json:"reason"`
+	Deliveries     uint64           `json:"deliveries"`
+	DeadLetteredAt time.Time        `json:"dead_lettered_at"`
+	Data           []byte           `json:"data"`
+	Payload        *json.RawMessage `json:"payload,omitempty"` // data, when it is valid JSON
+}
+
+// Queue summarizes the dead letters for a single subject
+type Queue struct {
+	Subject       string `json:"subject"`
+	Stream        string `json:"stream"`
+	Messages      uint64 `json:"messages"`
+	FirstSequence uint64 `json:"first_sequence"`
+	LastSequence  uint64 `json:"last_sequence"`
+}
+
+// streams caches the names of the dead-letter streams which are known to exist
+var streams sync.Map
+
+// Subject returns the subject to which dead letters for the given subject are published
+func Subject(subject string) string {
+	return fmt.Sprintf("%s.%s", natsDeadLetterSubjectPrefix, subject)
+}
+
+// StreamName returns the name of the dead-letter stream for the given subject
+func StreamName(subject string) string {
+	return fmt.Sprintf("%s%s", natsDeadLetterStreamPrefix, strings.NewReplacer(".", "-", "*", "_", ">", "_").Replace(subject))
+}
+
+// IsNotFound returns true if the given error indicates the requested dead letter or dead-letter stream does not exist
+func IsNotFound(err error) bool {
+	return err == nats.ErrStreamNotFound || (err != nil && strings.Contains(err.Error(), natsMsgNotFoundErrorDescription))
+}
+
+// Term dead-letters the given message with the given reason and terminates it, preventing
+// its redelivery; if the message cannot be dead-lettered it is nacked for redelivery instead
+func Term(msg *nats.Msg, reason string) {
+	letter := &DeadLetter{
+		Subject:        msg.Subject,
+		Reason:         reason,
+		Deliveries:     1,
+		DeadLetteredAt: time.Now(),
+		Data:           msg.Data,
+	}
+
+	meta, err := msg.Metadata()
+	if err == nil {
+		letter.Stream = meta.Stream
+		letter.Consumer = meta.Consumer
+		letter.StreamSequence = meta.Sequence.Stream
+		letter.Deliveries = meta.NumDelivered
+	}
+
+	err = letter.publish()
+	if err != nil {
+		common.Log.Warningf("failed to dead-letter %d-byte NATS message on subject: %s; %s", len(msg.Data), msg.Subject, err.Error())
+		msg.Nak()
+		return
+	}
+
+	msg.Term()
+}
+
+// publish the dead letter to the dead-letter stream for its subject
+func (l *DeadLetter) publish() error {
+	err := requireStream(l.Subject)
+	if err != nil {
+		return err
+	}
+
+	payload, _ := json.Marshal(l)
+	_, err = natsutil.NatsJetstreamPublish(Subject(l.Subject), payload)
+	if err != nil {
+		return fmt.Errorf("failed to publish dead letter; %s", err.Error())
+	}
+
+	common.Log.Debugf("dead-lettered %d-byte NATS message on subject: %s after %d deliveries; %s", len(l.Data), l.Subject, l.Deliveries, l.Reason)
+	return nil
+}
+
+// streamConfig returns the configuration of the dead-letter stream for the given subject
+func streamConfig(subject string) *nats.StreamConfig {
+	return &nats.StreamConfig{
+		Name:     StreamName(subject),
+		Subjects: []string{Subject(subject)},
+		MaxAge:   natsDeadLetterStreamMaxAge,
+		MaxMsgs:  natsDeadLetterStreamMaxMsgs,
+		Discard:  nats.DiscardOld,
+	}
+}
+
+// requireStream creates the dead-letter stream for the given subject if it does not already exist,
+// or updates an existing dead-letter stream to enforce the configured limits
+func requireStream(subject string) error {
+	name := StreamName(subject)
+	if _, ok := streams.Load(name); ok {
+		return nil
+	}
+
+	js, err := natsutil.GetSharedJetstreamContext(nil)
+	if err != nil {
+		return fmt.Errorf("failed to require dead-letter stream: %s; %s", name, err.Error())
+	}
+
+	cfg := streamConfig(subject)
+	_, err = js.StreamInfo(name)
+	if err == nats.ErrStreamNotFound {
+		_, err = js.AddStream(cfg)
+	} else if err == nil {
+		_, err = js.UpdateStream(cfg)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to require dead-letter stream: %s; %s", name, err.Error())
+	}
+
+	streams.Store(name, true)
+	return nil
+}
+
+// Queues returns a summary of the dead letters for each subject with a dead-letter stream
+func Queues() ([]*Queue, error) {
+	js, err := natsutil.GetSharedJetstreamContext(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	queues := make([]*Queue, 0)
+	for info := range js.StreamsInfo() {
+		if !strings.HasPrefix(info.Config.Name, natsDeadLetterStreamPrefix) || len(info.Config.Subjects) == 0 {
+			continue
+		}
+
+		queues = append(queues, &Queue{
+			Subject:       strings.TrimPrefix(info.Config.Subjects[0], fmt.Sprintf("%s.", natsDeadLetterSubjectPrefix)),
+			Stream:        info.Config.Name,
+			Messages:      info.State.Msgs,
+			FirstSequence: info.State.FirstSeq,
+			LastSequence:  info.State.LastSeq,
+		})
+	}
+
+	return queues, nil
+}
+
+// List returns at most limit dead letters for the given subject, starting after the given sequence
+func List(subject string, after uint64, limit int) ([]*DeadLetter, error) {
+	js, err := natsutil.GetSharedJetstreamContext(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := js.StreamInfo(StreamName(subject))
+	if err != nil {
+		return nil, err
+	}
+
+	seq := after + 1
+	if seq < info.State.FirstSeq {
+		seq = info.State.FirstSeq
+	}
+
+	letters := make([]*DeadLetter, 0)
+	for ; seq <= info.State.LastSeq && len(letters) < limit; seq++ {
+		letter, err := Get(subject, seq)
+		if err != nil {
+			if IsNotFound(err) {
+				continue // deleted
+			}
+			return nil, err
+		}
+		letters = append(letters, letter)
+	}
+
+	return letters, nil
+}
+
+// Get returns the dead letter with the given sequence for the given subject
+func Get(subject string, seq uint64) (*DeadLetter, error) {
+	js, err := natsutil.GetSharedJetstreamContext(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := js.GetMsg(StreamName(subject), seq)
+	if err != nil {
+		return nil, err
+	}
+
+	var letter *DeadLetter
+	err = json.Unmarshal(msg.Data, &letter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal dead letter %d for subject: %s; %s", seq, subject, err.Error())
+	}
+
+	letter.Sequence = msg.Sequence
+	if json.Valid(letter.Data) {
+		payload := json.RawMessage(letter.Data)
+		letter.Payload = &payload
+	}
+
+	return letter, nil
+}
+
+// Replay republishes the dead letter with the given sequence to its original subject and deletes it
+func Replay(subject string, seq uint64) (*DeadLetter, error) {
+	letter, err := Get(subject, seq)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = natsutil.NatsJetstreamPublish(letter.Subject, letter.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to replay dead letter %d for subject: %s; %s", seq, subject, err.Error())
+	}
+
+	common.Log.Debugf("replayed dead letter %d; republished %d-byte NATS message on subject: %s", seq, len(letter.Data), letter.Subject)
+	return letter, Delete(subject, seq)
+}
+
+// Delete the dead letter with the given sequence for the given subject
+func Delete(subject string, seq uint64) error {
+	js, err := natsutil.GetSharedJetstreamContext(nil)
+	if err != nil {
+		return err
+	}
+
+	return js.DeleteMsg(StreamName(subject), seq)
+}
+
+// Purge all dead letters for the given subject
+func Purge(subject string) error {
+	js, err := natsutil.GetSharedJetstreamContext(nil)
+	if err != nil {
+		return err
+	}
+
+	return js.PurgeStream(StreamName(subject))
+}
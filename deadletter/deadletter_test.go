@@ -0,0 +1,78 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package deadletter
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nats-io/nats.go"
+	identcommon "github.com/provideplatform/ident/common"
+)
+
+func TestStreamConfigBoundsDeadLetters(t *testing.T) {
+	cfg := streamConfig("nchain.tx.create")
+	if cfg.Name != "deadletter-nchain-tx-create" {
+		t.Errorf("expected dead-letter stream name; got %s", cfg.Name)
+	}
+	if len(cfg.Subjects) != 1 || cfg.Subjects[0] != "deadletter.nchain.tx.create" {
+		t.Errorf("expected dead-letter subject; got %v", cfg.Subjects)
+	}
+	if cfg.MaxAge != natsDeadLetterStreamMaxAge || cfg.MaxAge <= 0 {
+		t.Errorf("expected dead-letter stream max age; got %s", cfg.MaxAge)
+	}
+	if cfg.MaxMsgs != natsDeadLetterStreamMaxMsgs || cfg.MaxMsgs <= 0 {
+		t.Errorf("expected dead-letter stream max msgs; got %d", cfg.MaxMsgs)
+	}
+	if cfg.Discard != nats.DiscardOld {
+		t.Errorf("expected oldest dead letters to be discarded; got %s", cfg.Discard)
+	}
+}
+
+func authorizeAdminStatus(permissions interface{}) (bool, int) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("GET", "/api/v1/deadletters", nil)
+	if permissions != nil {
+		c.Set(contextPermissionsKey, permissions)
+	}
+
+	authorized := authorizeAdmin(c)
+	return authorized, w.Code
+}
+
+func TestAuthorizeAdminRequiresSudoPermission(t *testing.T) {
+	authorized, _ := authorizeAdminStatus(identcommon.Sudo)
+	if !authorized {
+		t.Error("expected sudo permission to be authorized")
+	}
+
+	authorized, status := authorizeAdminStatus(identcommon.DefaultApplicationResourcePermission)
+	if authorized || status != 403 {
+		t.Errorf("expected application permission to be forbidden; got status %d", status)
+	}
+
+	authorized, status = authorizeAdminStatus(nil)
+	if authorized || status != 401 {
+		t.Errorf("expected missing permissions to be unauthorized; got status %d", status)
+	}
+}
@@ -0,0 +1,203 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package deadletter
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	identcommon "github.com/provideplatform/ident/common"
+	provide "github.com/provideplatform/provide-go/common"
+)
+
+// contextPermissionsKey is the key of the permissions of the bearer token of the request, as set by the auth middleware
+const contextPermissionsKey = "permissions"
+
+const defaultDeadLettersRPP = 25
+const maxDeadLettersRPP = 500
+
+// InstallDeadLettersAPI installs the administrative dead letter handlers using the given gin Engine
+func InstallDeadLettersAPI(r *gin.Engine) {
+	r.GET("/api/v1/dead_letters", deadLetterQueuesListHandler)
+	r.GET("/api/v1/dead_letters/:subject", deadLettersListHandler)
+	r.DELETE("/api/v1/dead_letters/:subject", purgeDeadLettersHandler)
+	r.GET("/api/v1/dead_letters/:subject/:sequence", deadLetterDetailsHandler)
+	r.DELETE("/api/v1/dead_letters/:subject/:sequence", deleteDeadLetterHandler)
+	r.POST("/api/v1/dead_letters/:subject/:sequence/replay", replayDeadLetterHandler)
+}
+
+// authorizeAdmin renders an error and returns false if the request was not authorized using a
+// bearer token with the sudo permission
+func authorizeAdmin(c *gin.Context) bool {
+	permissions, ok := c.Get(contextPermissionsKey)
+	if !ok {
+		provide.RenderError("unauthorized", 401, c)
+		return false
+	}
+
+	if permission, ok := permissions.(identcommon.Permission); !ok || !permission.Has(identcommon.Sudo) {
+		provide.RenderError("forbidden", 403, c)
+		return false
+	}
+
+	return true
+}
+
+// parseSequence renders an error and returns false if the sequence param is invalid
+func parseSequence(c *gin.Context) (uint64, bool) {
+	seq, err := strconv.ParseUint(c.Param("sequence"), 10, 64)
+	if err != nil || seq == 0 {
+		provide.RenderError(fmt.Sprintf("invalid sequence: %s", c.Param("sequence")), 400, c)
+		return 0, false
+	}
+	return seq, true
+}
+
+// renderError renders the given dead letter error, using 404 if the dead letter or its stream does not exist
+func renderError(err error, c *gin.Context) {
+	if IsNotFound(err) {
+		provide.RenderError("dead letter not found", 404, c)
+		return
+	}
+	provide.RenderError(err.Error(), 500, c)
+}
+
+func deadLetterQueuesListHandler(c *gin.Context) {
+	if !authorizeAdmin(c) {
+		return
+	}
+
+	queues, err := Queues()
+	if err != nil {
+		provide.RenderError(err.Error(), 500, c)
+		return
+	}
+
+	provide.Render(queues, 200, c)
+}
+
+func deadLettersListHandler(c *gin.Context) {
+	if !authorizeAdmin(c) {
+		return
+	}
+
+	var after uint64
+	if c.Query("after") != "" {
+		_after, err := strconv.ParseUint(c.Query("after"), 10, 64)
+		if err != nil {
+			provide.RenderError(fmt.Sprintf("invalid after: %s", c.Query("after")), 400, c)
+			return
+		}
+		after = _after
+	}
+
+	rpp := defaultDeadLettersRPP
+	if c.Query("rpp") != "" {
+		_rpp, err := strconv.Atoi(c.Query("rpp"))
+		if err != nil || _rpp < 1 {
+			provide.RenderError(fmt.Sprintf("invalid rpp: %s", c.Query("rpp")), 400, c)
+			return
+		}
+		rpp = _rpp
+	}
+	if rpp > maxDeadLettersRPP {
+		rpp = maxDeadLettersRPP
+	}
+
+	letters, err := List(c.Param("subject"), after, rpp)
+	if err != nil {
+		renderError(err, c)
+		return
+	}
+
+	if len(letters) == rpp {
+		c.Header("x-next-cursor", strconv.FormatUint(letters[len(letters)-1].Sequence, 10))
+	}
+
+	provide.Render(letters, 200, c)
+}
+
+func deadLetterDetailsHandler(c *gin.Context) {
+	if !authorizeAdmin(c) {
+		return
+	}
+
+	seq, ok := parseSequence(c)
+	if !ok {
+		return
+	}
+
+	letter, err := Get(c.Param("subject"), seq)
+	if err != nil {
+		renderError(err, c)
+		return
+	}
+
+	provide.Render(letter, 200, c)
+}
+
+func replayDeadLetterHandler(c *gin.Context) {
+	if !authorizeAdmin(c) {
+		return
+	}
+
+	seq, ok := parseSequence(c)
+	if !ok {
+		return
+	}
+
+	letter, err := Replay(c.Param("subject"), seq)
+	if err != nil {
+		renderError(err, c)
+		return
+	}
+
+	provide.Render(letter, 202, c)
+}
+
+func deleteDeadLetterHandler(c *gin.Context) {
+	if !authorizeAdmin(c) {
+		return
+	}
+
+	seq, ok := parseSequence(c)
+	if !ok {
+		return
+	}
+
+	err := Delete(c.Param("subject"), seq)
+	if err != nil {
+		renderError(err, c)
+		return
+	}
+
+	provide.Render(nil, 204, c)
+}
+
+func purgeDeadLettersHandler(c *gin.Context) {
+	if !authorizeAdmin(c) {
+		return
+	}
+
+	err := Purge(c.Param("subject"))
+	if err != nil {
+		renderError(err, c)
+		return
+	}
+
+	provide.Render(nil, 204, c)
+}
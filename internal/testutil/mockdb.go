@@ -0,0 +1,50 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package testutil provides the fixtures shared by the unit tests of each package
+package testutil
+
+import (
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+)
+
+// NewMockDB returns a gorm connection backed by sqlmock; the expected queries are matched
+// as regular expressions, and unmet expectations fail the test when it completes
+func NewMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("failed to open mock db; %s", err.Error())
+	}
+
+	db, err := gorm.Open("postgres", conn)
+	if err != nil {
+		t.Fatalf("failed to open gorm connection to mock db; %s", err.Error())
+	}
+	db.LogMode(false)
+
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("unmet db expectations; %s", err.Error())
+		}
+		db.Close()
+	})
+
+	return db, mock
+}
@@ -0,0 +1,39 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package testutil
+
+import (
+	"os"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	redisutil "github.com/kthomas/go-redisutil"
+)
+
+// NewMockRedis configures the shared redis client to use an in-memory redis server
+func NewMockRedis(t *testing.T) *miniredis.Miniredis {
+	server := miniredis.RunT(t)
+
+	redisHosts := os.Getenv("REDIS_HOSTS")
+	os.Setenv("REDIS_HOSTS", server.Addr())
+	t.Cleanup(func() {
+		os.Setenv("REDIS_HOSTS", redisHosts)
+	})
+
+	redisutil.RequireRedis()
+	return server
+}
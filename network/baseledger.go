@@ -0,0 +1,283 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package network
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/bech32"
+	"github.com/provideplatform/nchain/common"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// networkConfigBaseledgerBech32Prefix is the human-readable part of account addresses on a baseledger network
+const networkConfigBaseledgerBech32Prefix = "bech32_prefix"
+
+// networkConfigBaseledgerDenom is the denomination in which fees and transfers are paid on a baseledger network;
+// defaults to the native currency of the network
+const networkConfigBaseledgerDenom = "denom"
+
+// networkConfigBaseledgerProofMsgTypeURL is the type url of the message used to anchor proofs on a baseledger network
+const networkConfigBaseledgerProofMsgTypeURL = "proof_msg_type_url"
+
+const defaultBaseledgerBech32Prefix = "baseledger"
+const defaultBaseledgerProofMsgTypeURL = "/Baseledger.baseledger.baseledger.MsgCreateBaseledgerTransaction"
+const baseledgerAccountQueryPath = "/cosmos.auth.v1beta1.Query/Account"
+const tendermintRPCTimeout = time.Second * 30
+
+// BaseledgerBech32Prefix returns the human-readable part of account addresses on the baseledger network
+func (n *Network) BaseledgerBech32Prefix() string {
+	if prefix, prefixOk := n.ParseConfig()[networkConfigBaseledgerBech32Prefix].(string); prefixOk && prefix != "" {
+		return prefix
+	}
+	return defaultBaseledgerBech32Prefix
+}
+
+// BaseledgerDenom returns the denomination in which fees and transfers are paid on the baseledger network
+func (n *Network) BaseledgerDenom() string {
+	cfg := n.ParseConfig()
+	if denom, denomOk := cfg[networkConfigBaseledgerDenom].(string); denomOk && denom != "" {
+		return denom
+	}
+	nativeCurrency, _ := cfg[networkConfigNativeCurrency].(string)
+	return strings.ToLower(nativeCurrency)
+}
+
+// BaseledgerProofMsgTypeURL returns the type url of the message used to anchor proofs on the baseledger network
+func (n *Network) BaseledgerProofMsgTypeURL() string {
+	if typeURL, typeURLOk := n.ParseConfig()[networkConfigBaseledgerProofMsgTypeURL].(string); typeURLOk && typeURL != "" {
+		return typeURL
+	}
+	return defaultBaseledgerProofMsgTypeURL
+}
+
+// BaseledgerAddress returns the bech32-encoded account address of the given hex-encoded secp256k1 public key on the baseledger network
+func (n *Network) BaseledgerAddress(publicKey string) (*string, error) {
+	pubkey, err := ParseSecp256k1PublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := bech32.ConvertBits(btcutil.Hash160(pubkey.SerializeCompressed()), 8, 5, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode baseledger address; %s", err.Error())
+	}
+
+	addr, err := bech32.Encode(n.BaseledgerBech32Prefix(), data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode baseledger address; %s", err.Error())
+	}
+
+	return common.StringOrNil(addr), nil
+}
+
+// TendermintRPCCall invokes the given tendermint JSON-RPC method on the baseledger network and unmarshals the result
+func (n *Network) TendermintRPCCall(result interface{}, method string, params map[string]interface{}) error {
+	if !n.IsBaseledgerNetwork() {
+		return fmt.Errorf("tendermint JSON-RPC invocation not supported by network %s", n.ID)
+	}
+
+	rpcURL, err := n.RPCURL()
+	if err != nil {
+		return err
+	} else if rpcURL == "" {
+		return fmt.Errorf("JSON-RPC invocation not supported by network %s", n.ID)
+	}
+
+	if params == nil {
+		params = map[string]interface{}{}
+	}
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      n.ID.String(),
+		"method":  method,
+		"params":  params,
+	})
+
+	client := &http.Client{Timeout: tendermintRPCTimeout}
+	resp, err := client.Post(rpcURL, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		n.RecordEndpointFailure(rpcURL)
+		return fmt.Errorf("failed to invoke JSON-RPC method %s on network %s; %s", method, n.ID, err.Error())
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read JSON-RPC method %s response; %s", method, err.Error())
+	}
+
+	if resp.StatusCode >= 500 {
+		n.RecordEndpointFailure(rpcURL)
+	}
+
+	var rpcResp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+			Data    string `json:"data"`
+		} `json:"error"`
+	}
+	err = json.Unmarshal(body, &rpcResp)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal JSON-RPC method %s response; %s", method, err.Error())
+	}
+
+	if rpcResp.Error != nil {
+		return fmt.Errorf("JSON-RPC method %s failed on network %s; %s; %s (code: %d)", method, n.ID, rpcResp.Error.Message, rpcResp.Error.Data, rpcResp.Error.Code)
+	}
+
+	if result != nil && len(rpcResp.Result) > 0 {
+		err = json.Unmarshal(rpcResp.Result, result)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal JSON-RPC method %s result; %s", method, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// TendermintChainID returns the chain id reported by the baseledger network
+func (n *Network) TendermintChainID() (string, error) {
+	var status struct {
+		NodeInfo struct {
+			Network string `json:"network"`
+		} `json:"node_info"`
+	}
+	err := n.TendermintRPCCall(&status, "status", nil)
+	if err != nil {
+		return "", err
+	}
+
+	if status.NodeInfo.Network == "" {
+		return "", fmt.Errorf("failed to resolve chain id of network: %s", n.ID)
+	}
+
+	return status.NodeInfo.Network, nil
+}
+
+// BaseledgerAccount returns the account number and sequence of the given account address, as reported by the
+// auth module of the baseledger network; an account does not exist until it has received funds
+func (n *Network) BaseledgerAccount(address string) (accountNumber, sequence uint64, err error) {
+	var req []byte
+	req = protowire.AppendTag(req, 1, protowire.BytesType)
+	req = protowire.AppendString(req, address)
+
+	var query struct {
+		Response struct {
+			Code  uint32 `json:"code"`
+			Log   string `json:"log"`
+			Value string `json:"value"`
+		} `json:"response"`
+	}
+	err = n.TendermintRPCCall(&query, "abci_query", map[string]interface{}{
+		"path":  baseledgerAccountQueryPath,
+		"data":  hex.EncodeToString(req),
+		"prove": false,
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to query account: %s; %s", address, err.Error())
+	}
+
+	if query.Response.Code != 0 {
+		return 0, 0, fmt.Errorf("failed to query account: %s; %s", address, query.Response.Log)
+	}
+
+	value, err := base64.StdEncoding.DecodeString(query.Response.Value)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to decode account: %s; %s", address, err.Error())
+	}
+
+	// QueryAccountResponse { google.protobuf.Any account = 1; }
+	anyAccount := consumeProtoBytesField(value, 1)
+	if anyAccount == nil {
+		return 0, 0, fmt.Errorf("failed to query account: %s; account not found", address)
+	}
+
+	// Any { string type_url = 1; bytes value = 2; }
+	account := consumeProtoBytesField(anyAccount, 2)
+
+	// BaseAccount { string address = 1; Any pub_key = 2; uint64 account_number = 3; uint64 sequence = 4; }
+	for len(account) > 0 {
+		num, typ, n := protowire.ConsumeTag(account)
+		if n < 0 {
+			return 0, 0, errors.New("failed to decode account; malformed protobuf")
+		}
+		account = account[n:]
+
+		if typ == protowire.VarintType && (num == 3 || num == 4) {
+			v, n := protowire.ConsumeVarint(account)
+			if n < 0 {
+				return 0, 0, errors.New("failed to decode account; malformed protobuf")
+			}
+			if num == 3 {
+				accountNumber = v
+			} else {
+				sequence = v
+			}
+			account = account[n:]
+			continue
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, account)
+		if n < 0 {
+			return 0, 0, errors.New("failed to decode account; malformed protobuf")
+		}
+		account = account[n:]
+	}
+
+	return accountNumber, sequence, nil
+}
+
+// consumeProtoBytesField returns the value of the first length-delimited field with the given number
+// in the given protobuf-encoded message, or nil if the message does not contain the field
+func consumeProtoBytesField(msg []byte, field protowire.Number) []byte {
+	for len(msg) > 0 {
+		num, typ, n := protowire.ConsumeTag(msg)
+		if n < 0 {
+			return nil
+		}
+		msg = msg[n:]
+
+		if num == field && typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(msg)
+			if n < 0 {
+				return nil
+			}
+			return v
+		}
+
+		n = protowire.ConsumeFieldValue(num, typ, msg)
+		if n < 0 {
+			return nil
+		}
+		msg = msg[n:]
+	}
+
+	return nil
+}
@@ -0,0 +1,297 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package network
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcutil"
+	"github.com/provideplatform/nchain/common"
+	providecrypto "github.com/provideplatform/provide-go/crypto"
+)
+
+// networkConfigBcoinAddressVersion is the hex-encoded version byte of P2PKH addresses on a bcoin network
+const networkConfigBcoinAddressVersion = "version"
+
+// networkConfigBcoinScriptHashVersion is the hex-encoded version byte of P2SH addresses on a bcoin network
+const networkConfigBcoinScriptHashVersion = "script_hash_version"
+
+// networkConfigBcoinBech32HRP is the human-readable part of segwit addresses on a bcoin network
+const networkConfigBcoinBech32HRP = "bech32_hrp"
+
+// networkConfigBcoinFeeRate is the fee rate, in satoshis per byte, used when the fee rate cannot be estimated
+const networkConfigBcoinFeeRate = "fee_rate"
+
+const defaultBcoinFeeRate = uint64(10)
+const bcoinHTTPTimeout = time.Second * 30
+
+// BcoinCoin is an unspent tx output, as returned by the bcoin http api
+type BcoinCoin struct {
+	Version  uint32 `json:"version"`
+	Height   int64  `json:"height"` // -1 if the coin is unconfirmed
+	Value    int64  `json:"value"`  // in satoshis
+	Script   string `json:"script"`
+	Address  string `json:"address"`
+	Coinbase bool   `json:"coinbase"`
+	Hash     string `json:"hash"`
+	Index    uint32 `json:"index"`
+}
+
+// BcoinChainParams returns the chain params of the bcoin network, resolved using the configured
+// chain; the address version bytes and segwit human-readable part can be overridden using the
+// network config, as bcoin networks do not always share the address encoding of btcd
+func (n *Network) BcoinChainParams() (*chaincfg.Params, error) {
+	if !n.IsBcoinNetwork() {
+		return nil, fmt.Errorf("network %s is not a bcoin network", n.ID)
+	}
+
+	cfg := n.ParseConfig()
+
+	var params chaincfg.Params
+	chain, _ := cfg[networkConfigChain].(string)
+	switch strings.ToLower(chain) {
+	case "test", "testnet", "testnet3":
+		params = chaincfg.TestNet3Params
+	case "regtest":
+		params = chaincfg.RegressionNetParams
+	case "simnet":
+		params = chaincfg.SimNetParams
+	default:
+		params = chaincfg.MainNetParams
+	}
+
+	for key, id := range map[string]*byte{
+		networkConfigBcoinAddressVersion:    &params.PubKeyHashAddrID,
+		networkConfigBcoinScriptHashVersion: &params.ScriptHashAddrID,
+	} {
+		if version, versionOk := cfg[key].(string); versionOk && version != "" {
+			versionBytes, err := hex.DecodeString(strings.TrimPrefix(version, "0x"))
+			if err != nil || len(versionBytes) != 1 {
+				return nil, fmt.Errorf("invalid %s for bcoin network: %s", key, n.ID)
+			}
+			*id = versionBytes[0]
+		}
+	}
+
+	if hrp, hrpOk := cfg[networkConfigBcoinBech32HRP].(string); hrpOk && hrp != "" {
+		params.Bech32HRPSegwit = hrp
+	}
+
+	return &params, nil
+}
+
+// BcoinAddress returns the P2PKH address of the given hex-encoded secp256k1 public key on the bcoin network
+func (n *Network) BcoinAddress(publicKey string) (*string, error) {
+	params, err := n.BcoinChainParams()
+	if err != nil {
+		return nil, err
+	}
+
+	pubkey, err := ParseSecp256k1PublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	addr, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160(pubkey.SerializeCompressed()), params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode P2PKH address for bcoin network: %s; %s", n.ID, err.Error())
+	}
+
+	return common.StringOrNil(addr.EncodeAddress()), nil
+}
+
+// ParseSecp256k1PublicKey parses the given hex-encoded secp256k1 public key
+func ParseSecp256k1PublicKey(publicKey string) (*btcec.PublicKey, error) {
+	raw, err := hex.DecodeString(strings.TrimPrefix(publicKey, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode secp256k1 public key; %s", err.Error())
+	}
+
+	if len(raw) == 64 {
+		// raw X || Y coordinates
+		raw = append([]byte{0x04}, raw...)
+	}
+
+	pubkey, err := btcec.ParsePubKey(raw, btcec.S256())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse secp256k1 public key; %s", err.Error())
+	}
+
+	return pubkey, nil
+}
+
+// BcoinRPCClientCall invokes the given JSON-RPC method on the bcoin network and unmarshals the result
+func (n *Network) BcoinRPCClientCall(result interface{}, method string, params ...interface{}) error {
+	if !n.IsBcoinNetwork() {
+		return fmt.Errorf("bcoin JSON-RPC invocation not supported by network %s", n.ID)
+	}
+
+	if params == nil {
+		params = make([]interface{}, 0)
+	}
+
+	rpcAPIUser, rpcAPIKey := n.bcoinRPCCredentials()
+	rpcClientKey, rpcURL, err := n.RPCEndpoint()
+	if err != nil {
+		return fmt.Errorf("failed to invoke JSON-RPC method %s on network %s; %s", method, n.ID, err.Error())
+	}
+
+	var resp struct {
+		Result json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	err = providecrypto.BcoinInvokeJsonRpcClient(rpcClientKey, rpcURL, rpcAPIUser, rpcAPIKey, method, params, &resp)
+	if err != nil {
+		n.RecordEndpointFailure(rpcURL)
+		return fmt.Errorf("failed to invoke JSON-RPC method %s on network %s; %s", method, n.ID, err.Error())
+	}
+
+	if resp.Error != nil {
+		return fmt.Errorf("JSON-RPC method %s failed on network %s; %s (code: %d)", method, n.ID, resp.Error.Message, resp.Error.Code)
+	}
+
+	if result != nil && len(resp.Result) > 0 {
+		err = json.Unmarshal(resp.Result, result)
+		if err != nil {
+			return fmt.Errorf("failed to unmarshal JSON-RPC method %s result; %s", method, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// BcoinCoins returns the unspent tx outputs of the given address, as reported by the bcoin
+// http api; the bcoin node must be run with the address index enabled (i.e., --index-address)
+func (n *Network) BcoinCoins(address string) ([]*BcoinCoin, error) {
+	if !n.IsBcoinNetwork() {
+		return nil, fmt.Errorf("network %s is not a bcoin network", n.ID)
+	}
+
+	rpcURL, err := n.RPCURL()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve coins for address: %s; %s", address, err.Error())
+	} else if rpcURL == "" {
+		return nil, fmt.Errorf("failed to resolve coins for address: %s; no rpc url configured for network: %s", address, n.ID)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf("%s/coin/address/%s", strings.TrimSuffix(rpcURL, "/"), address), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	rpcAPIUser, rpcAPIKey := n.bcoinRPCCredentials()
+	if rpcAPIKey != "" {
+		req.SetBasicAuth(rpcAPIUser, rpcAPIKey)
+	}
+
+	client := &http.Client{Timeout: bcoinHTTPTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		n.RecordEndpointFailure(rpcURL)
+		return nil, fmt.Errorf("failed to resolve coins for address: %s; %s", address, err.Error())
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read coins for address: %s; %s", address, err.Error())
+	}
+
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("failed to resolve coins for address: %s; bcoin responded with status: %d", address, resp.StatusCode)
+	}
+
+	coins := make([]*BcoinCoin, 0)
+	err = json.Unmarshal(body, &coins)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal coins for address: %s; %s", address, err.Error())
+	}
+
+	return coins, nil
+}
+
+// BcoinFeeRate returns the estimated fee rate, in satoshis per byte, for a tx to be included within
+// the given number of blocks; the configured fee rate is returned if the fee rate cannot be estimated
+// (i.e., on a regtest network, where there is no fee history)
+func (n *Network) BcoinFeeRate(blocks int) uint64 {
+	feeRate := defaultBcoinFeeRate
+	if rate, rateOk := n.ParseConfig()[networkConfigBcoinFeeRate].(float64); rateOk && rate > 0 {
+		feeRate = uint64(rate)
+	}
+
+	var estimate struct {
+		FeeRate float64 `json:"fee"` // in BTC per kB
+	}
+	err := n.BcoinRPCClientCall(&estimate, "estimatesmartfee", blocks)
+	if err != nil {
+		common.Log.Debugf("failed to estimate fee rate for bcoin network: %s; using fee rate of %d satoshis per byte; %s", n.ID, feeRate, err.Error())
+		return feeRate
+	}
+
+	if estimate.FeeRate <= 0 {
+		return feeRate
+	}
+
+	return uint64(math.Ceil(estimate.FeeRate * btcutil.SatoshiPerBitcoin / 1000))
+}
+
+// bcoinRPCCredentials returns the configured bcoin api user and key
+func (n *Network) bcoinRPCCredentials() (string, string) {
+	cfg := n.ParseConfig()
+	rpcAPIUser, _ := cfg[networkConfigRPCAPIUser].(string)
+	rpcAPIKey, _ := cfg[networkConfigRPCAPIKey].(string)
+	return rpcAPIUser, rpcAPIKey
+}
+
+// BcoinBlockHeight returns the height of the block with the given hash
+func (n *Network) BcoinBlockHeight(hash string) (uint64, error) {
+	var header map[string]interface{}
+	err := n.BcoinRPCClientCall(&header, "getblockheader", hash, true)
+	if err != nil {
+		return 0, err
+	}
+
+	height, heightOk := header["height"].(float64)
+	if !heightOk {
+		return 0, fmt.Errorf("failed to resolve height of block: %s", hash)
+	}
+
+	return uint64(height), nil
+}
+
+// BcoinChainHeight returns the height of the best block
+func (n *Network) BcoinChainHeight() (uint64, error) {
+	var height json.Number
+	err := n.BcoinRPCClientCall(&height, "getblockcount")
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(height.String(), 10, 64)
+}
@@ -27,6 +27,7 @@ import (
 	uuid "github.com/kthomas/go.uuid"
 	"github.com/nats-io/nats.go"
 	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/deadletter"
 	providego "github.com/provideplatform/provide-go/api"
 	provide "github.com/provideplatform/provide-go/crypto"
 )
@@ -58,9 +59,10 @@ const natsTxFinalizeSubject = "nchain.tx.finalize"
 type Block struct {
 	providego.Model
 
-	NetworkID uuid.UUID `sql:"type:uuid" json:"network_id"`
-	Block     int       `json:"block"`
-	Hash      string    `json:"hash"` // FIXME: should be blockhash
+	NetworkID  uuid.UUID `sql:"type:uuid" json:"network_id"`
+	Block      int       `json:"block"`
+	Hash       string    `json:"hash"` // FIXME: should be blockhash
+	ParentHash *string   `json:"parent_hash"`
 }
 
 type natsBlockFinalizedMsg struct {
@@ -157,7 +159,7 @@ func consumeBlockFinalizedMsg(msg *nats.Msg) {
 	defer func() {
 		if r := recover(); r != nil {
 			common.Log.Warningf("recovered from panic during NATS block finalized message handling; %s", r)
-			msg.Term()
+			deadletter.Term(msg, fmt.Sprintf("recovered from panic; %s", r))
 		}
 	}()
 
@@ -189,7 +191,14 @@ func consumeBlockFinalizedMsg(msg *nats.Msg) {
 		if err == nil {
 			if network.IsEthereumNetwork() {
 				if err == nil {
-					block, err := provide.EVMGetBlockByNumber(network.ID.String(), network.RPCURL(), blockFinalizedMsg.Block)
+					rpcClientKey, rpcURL, err := network.RPCEndpoint()
+					if err != nil {
+						common.Log.Warningf("failed to handle block finalized message; failed to resolve rpc url for network id: %s; %s", network.ID.String(), err.Error())
+						msg.Nak()
+						return
+					}
+
+					block, err := provide.EVMGetBlockByNumber(rpcClientKey, rpcURL, blockFinalizedMsg.Block)
 					if err != nil {
 						common.Log.Warningf("failed to handle block finalized message; failed to fetch block for network id: %s; %s", network.ID.String(), err.Error())
 						msg.Nak()
@@ -197,43 +206,59 @@ func consumeBlockFinalizedMsg(msg *nats.Msg) {
 					} else if result, resultOk := block.Result.(map[string]interface{}); resultOk {
 						blockTimestamp := time.Unix(int64(blockFinalizedMsg.Timestamp/1000), 0)
 						finalizedAt := time.Now()
+						parentHash, _ := result["parentHash"].(string)
 
-						// save the finalized block to the db
-						var minedBlock Block
-						minedBlock.NetworkID = network.ID
-						minedBlock.Block = int(blockFinalizedMsg.Block)
-						minedBlock.Hash = *blockFinalizedMsg.BlockHash
-						dbResult := db.Create(&minedBlock)
-						if dbResult.RowsAffected == 0 {
-							common.Log.Warningf("error saving block to db; error: %s", dbResult.Error.Error())
+						_, err = network.resolveReorg(db, blockFinalizedMsg.Block, *blockFinalizedMsg.BlockHash, parentHash)
+						if err != nil {
+							common.Log.Warningf("failed to handle block finalized message; failed to resolve reorg for network id: %s; %s", network.ID.String(), err.Error())
+							msg.Nak()
+							return
 						}
 
-						if txs, txsOk := result["transactions"].([]interface{}); txsOk {
-							for _, _tx := range txs {
-								txHash := _tx.(map[string]interface{})["hash"].(string)
-								common.Log.Tracef("setting tx block (%v) and finalized_at timestamp %s on tx: %s", blockFinalizedMsg.Block, finalizedAt, txHash)
-
-								params := map[string]interface{}{
-									"block":           blockFinalizedMsg.Block,
-									"block_timestamp": blockTimestamp,
-									"finalized_at":    finalizedAt,
-									"hash":            txHash,
-								}
-
-								msgPayload, _ := json.Marshal(params)
-								_, err = natsutil.NatsJetstreamPublish(natsTxFinalizeSubject, msgPayload)
-								if err != nil {
-									common.Log.Warningf("failed to handle block finalized message; failed publish tx finalized event on subject %s; network: %s; %s", natsTxFinalizeSubject, network.ID.String(), err.Error())
-									msg.Nak()
-									return
-								}
+						// save the finalized block to the db, unless it was previously seen
+						var seen int
+						db.Model(&Block{}).Where("network_id = ? AND block = ? AND hash = ?", network.ID, blockFinalizedMsg.Block, *blockFinalizedMsg.BlockHash).Count(&seen)
+						if seen == 0 {
+							var minedBlock Block
+							minedBlock.NetworkID = network.ID
+							minedBlock.Block = int(blockFinalizedMsg.Block)
+							minedBlock.Hash = *blockFinalizedMsg.BlockHash
+							minedBlock.ParentHash = common.StringOrNil(parentHash)
+							dbResult := db.Create(&minedBlock)
+							if dbResult.RowsAffected == 0 {
+								common.Log.Warningf("error saving block to db; error: %s", dbResult.Error.Error())
 							}
 						}
+
+						// txs are finalized once the configured number of blocks have been mined on top of them
+						finalizedBlock := blockFinalizedMsg.Block
+						if confirmations := network.Confirmations(); confirmations > 0 {
+							if finalizedBlock < confirmations {
+								msg.Ack()
+								return
+							}
+
+							finalizedBlock -= confirmations
+							result, err = network.canonicalBlock(finalizedBlock)
+							if err != nil || result == nil {
+								common.Log.Warningf("failed to handle block finalized message; failed to fetch confirmed block %d for network id: %s", finalizedBlock, network.ID.String())
+								msg.Nak()
+								return
+							}
+							blockTimestamp = parseBlockTimestamp(result)
+						}
+
+						err = network.publishFinalizedTxs(finalizedBlock, result, blockTimestamp, finalizedAt)
+						if err != nil {
+							common.Log.Warningf("failed to handle block finalized message; %s", err.Error())
+							msg.Nak()
+							return
+						}
 					}
 				}
 			} else {
 				common.Log.Warningf("received unhandled finalized block header; network id: %s", *blockFinalizedMsg.NetworkID)
-				msg.Term()
+				deadletter.Term(msg, fmt.Sprintf("received unhandled finalized block header; network id: %s", *blockFinalizedMsg.NetworkID))
 				return
 			}
 		}
@@ -242,10 +267,39 @@ func consumeBlockFinalizedMsg(msg *nats.Msg) {
 	msg.Ack()
 }
 
+// publishFinalizedTxs publishes a tx finalized event for each tx included in the given block
+func (n *Network) publishFinalizedTxs(height uint64, block map[string]interface{}, blockTimestamp, finalizedAt time.Time) error {
+	txs, txsOk := block["transactions"].([]interface{})
+	if !txsOk {
+		return nil
+	}
+
+	for _, _tx := range txs {
+		txHash := _tx.(map[string]interface{})["hash"].(string)
+		common.Log.Tracef("setting tx block (%v) and finalized_at timestamp %s on tx: %s", height, finalizedAt, txHash)
+
+		params := map[string]interface{}{
+			"block":           height,
+			"block_hash":      block["hash"],
+			"block_timestamp": blockTimestamp,
+			"finalized_at":    finalizedAt,
+			"hash":            txHash,
+		}
+
+		msgPayload, _ := json.Marshal(params)
+		_, err := natsutil.NatsJetstreamPublish(natsTxFinalizeSubject, msgPayload)
+		if err != nil {
+			return fmt.Errorf("failed publish tx finalized event on subject %s; network: %s; %s", natsTxFinalizeSubject, n.ID.String(), err.Error())
+		}
+	}
+
+	return nil
+}
+
 func consumeResolveNodePeerURLMsg(msg *nats.Msg) {
 	defer func() {
 		if r := recover(); r != nil {
-			msg.Term()
+			deadletter.Term(msg, fmt.Sprintf("recovered from panic; %s", r))
 		}
 	}()
 
@@ -263,7 +317,7 @@ func consumeResolveNodePeerURLMsg(msg *nats.Msg) {
 
 	if !nodeIDOk {
 		common.Log.Warningf("failed to resolve peer url for node; no node id provided")
-		msg.Term()
+		deadletter.Term(msg, "failed to resolve peer url for node; no node id provided")
 		return
 	}
 
@@ -273,14 +327,14 @@ func consumeResolveNodePeerURLMsg(msg *nats.Msg) {
 	db.Where("id = ?", nodeID).Find(&node)
 	if node == nil || node.ID == uuid.Nil {
 		common.Log.Warningf("failed to resolve node; no node resolved for id: %s", nodeID)
-		msg.Term()
+		deadletter.Term(msg, fmt.Sprintf("failed to resolve node; no node resolved for id: %s", nodeID))
 		return
 	}
 
 	err = node.resolvePeerURL(db)
 	if err != nil {
 		common.Log.Debugf("attempt to resolve node peer url did not succeed; %s", err.Error())
-		msg.Term()
+		deadletter.Term(msg, fmt.Sprintf("attempt to resolve node peer url did not succeed; %s", err.Error()))
 		return
 	}
 
@@ -296,7 +350,7 @@ func consumeResolveNodePeerURLMsg(msg *nats.Msg) {
 func consumeAddNodePeerMsg(msg *nats.Msg) {
 	defer func() {
 		if r := recover(); r != nil {
-			msg.Term()
+			deadletter.Term(msg, fmt.Sprintf("recovered from panic; %s", r))
 		}
 	}()
 
@@ -315,13 +369,13 @@ func consumeAddNodePeerMsg(msg *nats.Msg) {
 
 	if !nodeIDOk {
 		common.Log.Warningf("failed to add network peer; no node id provided")
-		msg.Term()
+		deadletter.Term(msg, "failed to add network peer; no node id provided")
 		return
 	}
 
 	if !peerURLOk {
 		common.Log.Warningf("failed to add network peer; no peer url provided")
-		msg.Term()
+		deadletter.Term(msg, "failed to add network peer; no peer url provided")
 		return
 	}
 
@@ -331,7 +385,7 @@ func consumeAddNodePeerMsg(msg *nats.Msg) {
 	db.Where("id = ?", nodeID).Find(&node)
 	if node == nil || node.ID == uuid.Nil {
 		common.Log.Warningf("failed to resolve node; no node resolved for id: %s", nodeID)
-		msg.Term()
+		deadletter.Term(msg, fmt.Sprintf("failed to resolve node; no node resolved for id: %s", nodeID))
 		return
 	}
 
@@ -348,7 +402,7 @@ func consumeAddNodePeerMsg(msg *nats.Msg) {
 func consumeRemoveNodePeerMsg(msg *nats.Msg) {
 	defer func() {
 		if r := recover(); r != nil {
-			msg.Term()
+			deadletter.Term(msg, fmt.Sprintf("recovered from panic; %s", r))
 		}
 	}()
 
@@ -367,13 +421,13 @@ func consumeRemoveNodePeerMsg(msg *nats.Msg) {
 
 	if !nodeIDOk {
 		common.Log.Warningf("failed to remove network peer; no node id provided")
-		msg.Term()
+		deadletter.Term(msg, "failed to remove network peer; no node id provided")
 		return
 	}
 
 	if !peerURLOk {
 		common.Log.Warningf("failed to remove network peer; no peer url provided")
-		msg.Term()
+		deadletter.Term(msg, "failed to remove network peer; no peer url provided")
 		return
 	}
 
@@ -383,7 +437,7 @@ func consumeRemoveNodePeerMsg(msg *nats.Msg) {
 	db.Where("id = ?", nodeID).Find(&node)
 	if node == nil || node.ID == uuid.Nil {
 		common.Log.Warningf("failed to resolve node; no node resolved for id: %s", nodeID)
-		msg.Term()
+		deadletter.Term(msg, fmt.Sprintf("failed to resolve node; no node resolved for id: %s", nodeID))
 		return
 	}
 
@@ -0,0 +1,583 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package network
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/ethclient"
+	ethrpc "github.com/ethereum/go-ethereum/rpc"
+	"github.com/gorilla/websocket"
+	dbconf "github.com/kthomas/go-db-config"
+	redisutil "github.com/kthomas/go-redisutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	providecrypto "github.com/provideplatform/provide-go/crypto"
+)
+
+// networkConfigJSONRPCEndpoints is an ordered list of JSON-RPC endpoints, i.e., [{"url": "https://...", "weight": 2}];
+// when configured, it is used instead of json_rpc_url
+const networkConfigJSONRPCEndpoints = "json_rpc_endpoints"
+
+// networkConfigWebsocketEndpoints is an ordered list of websocket endpoints; when configured, it is used instead of websocket_url
+const networkConfigWebsocketEndpoints = "websocket_endpoints"
+
+const endpointTypeRPC = "json_rpc"
+const endpointTypeWebsocket = "websocket"
+
+const defaultEndpointWeight = float64(1)
+
+// endpointHealthCheckInterval is the interval at which the health of each endpoint is checked
+const endpointHealthCheckInterval = time.Second * 15
+const endpointHealthCheckTimeout = time.Second * 5
+
+// endpointHealthTTL is the amount of time checked endpoint health is retained; when health has not been
+// checked recently (i.e., no health checker is running), endpoints are selected by order and weight
+const endpointHealthTTL = time.Minute
+
+// endpointHealthCacheTTL is the amount of time endpoint health is cached in-process between reads
+const endpointHealthCacheTTL = time.Second * 5
+
+// endpointErrorRateDecay weighs the error rate of an endpoint toward its most recent checks
+const endpointErrorRateDecay = 0.8
+
+// endpointMaxErrorRate is the error rate above which an endpoint is considered unhealthy
+const endpointMaxErrorRate = 0.5
+
+// endpointMaxBlockLag is the number of blocks an endpoint can trail the others before it is considered unhealthy
+const endpointMaxBlockLag = uint64(10)
+
+// endpointLatencyScale is the latency, in milliseconds, at which the score of an endpoint is halved
+const endpointLatencyScale = float64(250)
+
+// Endpoint is a weighted JSON-RPC or websocket endpoint of a network
+type Endpoint struct {
+	URL    string  `json:"url"`
+	Weight float64 `json:"weight"`
+}
+
+// EndpointHealth is the most recently checked health of a network endpoint
+type EndpointHealth struct {
+	Type      string    `json:"type"`
+	URL       string    `json:"url"`
+	Weight    float64   `json:"weight"`
+	Healthy   bool      `json:"healthy"`
+	Selected  bool      `json:"selected"`
+	Latency   *int64    `json:"latency_ms,omitempty"`
+	ErrorRate float64   `json:"error_rate"`
+	Block     *uint64   `json:"block,omitempty"`
+	BlockLag  uint64    `json:"block_lag"`
+	Error     *string   `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// cachedEndpointHealth is endpoint health read from the shared cache, keyed by url, and the
+// urls of the endpoints which failed in-process since it was read
+type cachedEndpointHealth struct {
+	health    map[string]*EndpointHealth
+	failed    map[string]bool
+	fetchedAt time.Time
+}
+
+// endpointHealthCache caches endpoint health in-process, keyed by network id
+var endpointHealthCache sync.Map
+
+// endpointHealthCheckers are the running health checkers, keyed by network id
+var endpointHealthCheckers = map[string]*endpointHealthChecker{}
+var endpointHealthCheckersMutex sync.Mutex
+
+// endpointHealthChecker periodically checks the health of each endpoint of a network
+type endpointHealthChecker struct {
+	networkID   uuid.UUID
+	cancelF     context.CancelFunc
+	shutdownCtx context.Context
+}
+
+// EndpointHealthKey returns the key for the given network id, which is guaranteed to be unique-per-network;
+// the key represents the namespace where the checked health of the network endpoints is cached
+func EndpointHealthKey(networkID uuid.UUID) string {
+	return fmt.Sprintf("network.%s.endpoints.health", networkID.String())
+}
+
+// EndpointFailuresKey returns the key of the counter of failed requests made using the given endpoint
+func EndpointFailuresKey(networkID uuid.UUID, url string) string {
+	digest := sha256.Sum256([]byte(url))
+	return fmt.Sprintf("network.%s.endpoints.%s.failures", networkID.String(), hex.EncodeToString(digest[:8]))
+}
+
+// RPCClientKey returns the key used to cache JSON-RPC clients for the given network endpoint
+func RPCClientKey(networkID uuid.UUID, url string) string {
+	return fmt.Sprintf("%s.%s", networkID.String(), url)
+}
+
+// parseEndpoints returns the ordered endpoints in the given config list, falling back to the given url
+func parseEndpoints(cfg map[string]interface{}, listKey, urlKey string) []*Endpoint {
+	endpoints := make([]*Endpoint, 0)
+	if cfg == nil {
+		return endpoints
+	}
+
+	if list, listOk := cfg[listKey].([]interface{}); listOk {
+		for _, item := range list {
+			switch endpoint := item.(type) {
+			case string:
+				endpoints = append(endpoints, &Endpoint{URL: endpoint, Weight: defaultEndpointWeight})
+			case map[string]interface{}:
+				url, urlOk := endpoint["url"].(string)
+				if !urlOk || url == "" {
+					continue
+				}
+				weight, weightOk := endpoint["weight"].(float64)
+				if !weightOk || weight <= 0 {
+					weight = defaultEndpointWeight
+				}
+				endpoints = append(endpoints, &Endpoint{URL: url, Weight: weight})
+			}
+		}
+	}
+
+	if len(endpoints) == 0 {
+		if url, urlOk := cfg[urlKey].(string); urlOk && url != "" {
+			endpoints = append(endpoints, &Endpoint{URL: url, Weight: defaultEndpointWeight})
+		}
+	}
+
+	return endpoints
+}
+
+// RPCEndpoints returns the ordered JSON-RPC endpoints configured for the network
+func (n *Network) RPCEndpoints() []*Endpoint {
+	return parseEndpoints(n.ParseConfig(), networkConfigJSONRPCEndpoints, networkConfigJSONRPCURL)
+}
+
+// WebsocketEndpoints returns the ordered websocket endpoints configured for the network
+func (n *Network) WebsocketEndpoints() []*Endpoint {
+	return parseEndpoints(n.ParseConfig(), networkConfigWebsocketEndpoints, networkConfigWebsocketURL)
+}
+
+// RPCEndpoint returns the key used to cache JSON-RPC clients for the healthiest JSON-RPC endpoint
+// of the network, and its url; JSON-RPC clients must be resolved using the returned key so each
+// endpoint has its own client and requests fail over when another endpoint is selected. An error
+// is returned if the network's rate limit does not permit a JSON-RPC call; see RPCURL
+func (n *Network) RPCEndpoint() (string, string, error) {
+	url, err := n.RPCURL()
+	if err != nil {
+		return "", "", err
+	}
+	return RPCClientKey(n.ID, url), url, nil
+}
+
+// EVMDialJSONRPC returns the JSON-RPC client of the healthiest JSON-RPC endpoint of the EVM-based
+// network; an error is returned if the network's rate limit does not permit a JSON-RPC call
+func (n *Network) EVMDialJSONRPC() (*ethclient.Client, error) {
+	rpcClientKey, rpcURL, err := n.RPCEndpoint()
+	if err != nil {
+		return nil, err
+	}
+	return providecrypto.EVMDialJsonRpc(rpcClientKey, rpcURL)
+}
+
+// readEndpointHealth returns the checked health of the network endpoints, keyed by url
+func readEndpointHealth(networkID uuid.UUID) map[string]*EndpointHealth {
+	health := map[string]*EndpointHealth{}
+
+	raw, err := redisutil.Get(EndpointHealthKey(networkID))
+	if err != nil || raw == nil {
+		return health
+	}
+
+	var checked []*EndpointHealth
+	err = json.Unmarshal([]byte(*raw), &checked)
+	if err != nil {
+		common.Log.Warningf("failed to unmarshal cached endpoint health for network: %s; %s", networkID, err.Error())
+		return health
+	}
+
+	for _, h := range checked {
+		health[h.URL] = h
+	}
+	return health
+}
+
+// cachedHealth returns the checked health of the network endpoints, reading it from the shared cache at most
+// once per endpointHealthCacheTTL
+func (n *Network) cachedHealth() *cachedEndpointHealth {
+	if cached, ok := endpointHealthCache.Load(n.ID.String()); ok {
+		if time.Since(cached.(*cachedEndpointHealth).fetchedAt) < endpointHealthCacheTTL {
+			return cached.(*cachedEndpointHealth)
+		}
+	}
+
+	cached := &cachedEndpointHealth{
+		health:    readEndpointHealth(n.ID),
+		failed:    map[string]bool{},
+		fetchedAt: time.Now(),
+	}
+	endpointHealthCache.Store(n.ID.String(), cached)
+	return cached
+}
+
+// score returns the score of the given endpoint; endpoints with higher scores are preferred
+func (e *Endpoint) score(h *EndpointHealth) float64 {
+	if h == nil {
+		return e.Weight
+	}
+
+	score := e.Weight * (1 - h.ErrorRate) / float64(1+h.BlockLag)
+	if h.Latency != nil {
+		score /= 1 + float64(*h.Latency)/endpointLatencyScale
+	}
+	return score
+}
+
+// selectEndpoint returns the url of the healthiest of the given ordered endpoints, excluding those which failed;
+// endpoints with equal scores are selected in order, and the first endpoint is selected if none is healthy
+func selectEndpoint(endpoints []*Endpoint, health map[string]*EndpointHealth, failed map[string]bool) string {
+	if len(endpoints) == 0 {
+		return ""
+	}
+	if len(endpoints) == 1 {
+		return endpoints[0].URL
+	}
+
+	var selected *Endpoint
+	var selectedScore float64
+	for _, endpoint := range endpoints {
+		h := health[endpoint.URL]
+		if (h != nil && !h.Healthy) || failed[endpoint.URL] {
+			continue
+		}
+
+		score := endpoint.score(h)
+		if selected == nil || score > selectedScore {
+			selected = endpoint
+			selectedScore = score
+		}
+	}
+
+	if selected == nil {
+		return endpoints[0].URL
+	}
+	return selected.URL
+}
+
+// RecordEndpointFailure records a failed request made using the given JSON-RPC or websocket endpoint of the network;
+// this process fails over to another endpoint until endpoint health is next read, and the failure counts toward the
+// error rate of the endpoint when its health is next checked
+func (n *Network) RecordEndpointFailure(url string) {
+	if url == "" {
+		return
+	}
+
+	if cached, ok := endpointHealthCache.Load(n.ID.String()); ok {
+		failed := map[string]bool{url: true}
+		for u := range cached.(*cachedEndpointHealth).failed {
+			failed[u] = true
+		}
+
+		endpointHealthCache.Store(n.ID.String(), &cachedEndpointHealth{
+			health:    cached.(*cachedEndpointHealth).health,
+			failed:    failed,
+			fetchedAt: cached.(*cachedEndpointHealth).fetchedAt,
+		})
+	}
+
+	_, err := redisutil.Increment(EndpointFailuresKey(n.ID, url))
+	if err != nil {
+		common.Log.Debugf("failed to record endpoint failure for network: %s; %s", n.ID, err.Error())
+	}
+}
+
+// EndpointHealth returns the checked health of each JSON-RPC and websocket endpoint of the network
+func (n *Network) EndpointHealth() []*EndpointHealth {
+	health := readEndpointHealth(n.ID)
+	cfg := n.ParseConfig()
+
+	endpoints := make([]*EndpointHealth, 0)
+	for _, endpointType := range []string{endpointTypeRPC, endpointTypeWebsocket} {
+		var configured []*Endpoint
+		if endpointType == endpointTypeRPC {
+			configured = parseEndpoints(cfg, networkConfigJSONRPCEndpoints, networkConfigJSONRPCURL)
+		} else {
+			configured = parseEndpoints(cfg, networkConfigWebsocketEndpoints, networkConfigWebsocketURL)
+		}
+
+		selected := selectEndpoint(configured, health, nil)
+		for _, endpoint := range configured {
+			h, hOk := health[endpoint.URL]
+			if !hOk {
+				h = &EndpointHealth{Type: endpointType, URL: endpoint.URL, Healthy: true}
+			}
+			h.Weight = endpoint.Weight
+			h.Selected = endpoint.URL == selected
+			endpoints = append(endpoints, h)
+		}
+	}
+
+	return endpoints
+}
+
+// WatchWebsocketFailover closes the given websocket connection to the given endpoint when another
+// websocket endpoint of the network becomes healthier, so the connection is reestablished using it;
+// the returned func stops watching and must be called once the connection is closed
+func (n *Network) WatchWebsocketFailover(websocketURL string, conn io.Closer) func() {
+	done := make(chan struct{})
+	go func() {
+		timer := time.NewTicker(endpointHealthCheckInterval)
+		defer timer.Stop()
+
+		for {
+			select {
+			case <-timer.C:
+				cached := n.cachedHealth()
+				url := selectEndpoint(n.WebsocketEndpoints(), cached.health, cached.failed)
+				if url != "" && url != websocketURL {
+					common.Log.Infof("failing over websocket connection for network: %s; %s is no longer the healthiest endpoint", n.ID, websocketURL)
+					conn.Close()
+					return
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() { close(done) })
+	}
+}
+
+// RequireEndpointHealthChecker ensures a single endpoint health checker is running for the given network
+func RequireEndpointHealthChecker(n *Network) {
+	endpointHealthCheckersMutex.Lock()
+	defer endpointHealthCheckersMutex.Unlock()
+
+	if _, ok := endpointHealthCheckers[n.ID.String()]; ok {
+		return
+	}
+
+	common.Log.Debugf("initializing endpoint health checker for network: %s", n.ID)
+	checker := &endpointHealthChecker{networkID: n.ID}
+	checker.shutdownCtx, checker.cancelF = context.WithCancel(context.Background())
+	endpointHealthCheckers[n.ID.String()] = checker
+	go checker.run()
+}
+
+// EvictEndpointHealthChecker stops the endpoint health checker for the given network
+func EvictEndpointHealthChecker(n *Network) {
+	endpointHealthCheckersMutex.Lock()
+	defer endpointHealthCheckersMutex.Unlock()
+
+	if checker, ok := endpointHealthCheckers[n.ID.String()]; ok {
+		common.Log.Debugf("evicting endpoint health checker for network: %s", n.ID)
+		checker.cancelF()
+		delete(endpointHealthCheckers, n.ID.String())
+	}
+}
+
+func (c *endpointHealthChecker) run() {
+	c.check()
+
+	timer := time.NewTicker(endpointHealthCheckInterval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			c.check()
+		case <-c.shutdownCtx.Done():
+			return
+		}
+	}
+}
+
+// check the health of each endpoint of the network and cache the results
+func (c *endpointHealthChecker) check() {
+	ntwrk := &Network{}
+	dbconf.DatabaseConnection().Where("id = ?", c.networkID).Find(&ntwrk)
+	if ntwrk == nil || ntwrk.ID == uuid.Nil {
+		return
+	}
+
+	previous := readEndpointHealth(ntwrk.ID)
+	checked := make([]*EndpointHealth, 0)
+
+	rpcHealth := make([]*EndpointHealth, 0)
+	var highestBlock uint64
+	for _, endpoint := range ntwrk.RPCEndpoints() {
+		h := ntwrk.checkRPCEndpoint(endpoint)
+		if h.Block != nil && *h.Block > highestBlock {
+			highestBlock = *h.Block
+		}
+		rpcHealth = append(rpcHealth, h)
+	}
+	for _, h := range rpcHealth {
+		if h.Block != nil {
+			h.BlockLag = highestBlock - *h.Block
+		}
+		checked = append(checked, h)
+	}
+
+	for _, endpoint := range ntwrk.WebsocketEndpoints() {
+		checked = append(checked, checkWebsocketEndpoint(endpoint))
+	}
+
+	for _, h := range checked {
+		ntwrk.scoreEndpointHealth(h, previous[h.URL])
+	}
+
+	payload, _ := json.Marshal(checked)
+	ttl := endpointHealthTTL
+	err := redisutil.Set(EndpointHealthKey(ntwrk.ID), string(payload), &ttl)
+	if err != nil {
+		common.Log.Warningf("failed to cache endpoint health for network: %s; %s", ntwrk.ID, err.Error())
+	}
+}
+
+// endpointFailuresTakeScript atomically reads and deletes the failure counter at KEYS[1], so failures
+// recorded concurrently by other replicas are counted toward the next check rather than lost
+const endpointFailuresTakeScript = `
+local failures = redis.call('GET', KEYS[1])
+redis.call('DEL', KEYS[1])
+return tonumber(failures) or 0
+`
+
+// takeEndpointFailures returns the number of failures recorded at the given key since it was last taken, and resets it
+func takeEndpointFailures(key string) (int64, error) {
+	result, err := redisEval(endpointFailuresTakeScript, []string{key})
+	if err != nil {
+		return 0, err
+	}
+
+	failures, ok := result.(int64)
+	if !ok {
+		return 0, fmt.Errorf("unexpected endpoint failures result: %v", result)
+	}
+
+	return failures, nil
+}
+
+// scoreEndpointHealth folds the given check, and any failures recorded since the previous check,
+// into the error rate of the endpoint and resolves whether it is healthy
+func (n *Network) scoreEndpointHealth(h, previous *EndpointHealth) {
+	failures := float64(0)
+	if h.Error != nil {
+		failures++
+	}
+
+	recorded, err := takeEndpointFailures(EndpointFailuresKey(n.ID, h.URL))
+	if err != nil {
+		common.Log.Debugf("failed to read recorded failures of endpoint: %s on network: %s; %s", h.URL, n.ID, err.Error())
+	}
+	failures += float64(recorded)
+
+	errorRate := float64(0)
+	if failures > 0 {
+		errorRate = 1
+	}
+	if previous != nil {
+		errorRate = endpointErrorRateDecay*previous.ErrorRate + (1-endpointErrorRateDecay)*errorRate
+	}
+
+	h.ErrorRate = errorRate
+	h.Healthy = h.Error == nil && h.ErrorRate < endpointMaxErrorRate && h.BlockLag <= endpointMaxBlockLag
+	h.CheckedAt = time.Now()
+}
+
+// checkRPCEndpoint checks the latency and, for EVM-based networks, the block height of the given JSON-RPC endpoint
+func (n *Network) checkRPCEndpoint(endpoint *Endpoint) *EndpointHealth {
+	h := &EndpointHealth{
+		Type:   endpointTypeRPC,
+		URL:    endpoint.URL,
+		Weight: endpoint.Weight,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), endpointHealthCheckTimeout)
+	defer cancel()
+
+	startedAt := time.Now()
+
+	if n.IsEthereumNetwork() {
+		client, err := ethrpc.DialContext(ctx, endpoint.URL)
+		if err != nil {
+			h.Error = common.StringOrNil(err.Error())
+			return h
+		}
+		defer client.Close()
+
+		var block hexutil.Uint64
+		err = client.CallContext(ctx, &block, "eth_blockNumber")
+		if err != nil {
+			h.Error = common.StringOrNil(err.Error())
+			return h
+		}
+
+		height := uint64(block)
+		h.Block = &height
+	} else {
+		// the endpoint is reachable if it responds to an empty JSON-RPC request
+		req, _ := http.NewRequestWithContext(ctx, http.MethodPost, endpoint.URL, bytes.NewReader([]byte("{}")))
+		req.Header.Set("content-type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			h.Error = common.StringOrNil(err.Error())
+			return h
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			h.Error = common.StringOrNil(fmt.Sprintf("JSON-RPC endpoint responded with status: %d", resp.StatusCode))
+			return h
+		}
+	}
+
+	latency := int64(time.Since(startedAt) / time.Millisecond)
+	h.Latency = &latency
+	return h
+}
+
+// checkWebsocketEndpoint checks the latency of establishing a connection to the given websocket endpoint
+func checkWebsocketEndpoint(endpoint *Endpoint) *EndpointHealth {
+	h := &EndpointHealth{
+		Type:   endpointTypeWebsocket,
+		URL:    endpoint.URL,
+		Weight: endpoint.Weight,
+	}
+
+	startedAt := time.Now()
+
+	wsDialer := websocket.Dialer{HandshakeTimeout: endpointHealthCheckTimeout}
+	wsConn, _, err := wsDialer.Dial(endpoint.URL, nil)
+	if err != nil {
+		h.Error = common.StringOrNil(err.Error())
+		return h
+	}
+	wsConn.Close()
+
+	latency := int64(time.Since(startedAt) / time.Millisecond)
+	h.Latency = &latency
+	return h
+}
@@ -0,0 +1,77 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package network
+
+import (
+	"testing"
+
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/internal/testutil"
+)
+
+func TestTakeEndpointFailuresResetsCounter(t *testing.T) {
+	testutil.NewMockRedis(t)
+	n := &Network{}
+	n.ID, _ = uuid.NewV4()
+	url := "https://rpc.example.com"
+
+	n.RecordEndpointFailure(url)
+	n.RecordEndpointFailure(url)
+
+	failures, err := takeEndpointFailures(EndpointFailuresKey(n.ID, url))
+	if err != nil {
+		t.Fatalf("failed to take endpoint failures; %s", err.Error())
+	}
+	if failures != 2 {
+		t.Errorf("expected 2 recorded failures; got %d", failures)
+	}
+
+	n.RecordEndpointFailure(url)
+	failures, _ = takeEndpointFailures(EndpointFailuresKey(n.ID, url))
+	if failures != 1 {
+		t.Errorf("expected failure recorded after the counter was taken to be retained; got %d", failures)
+	}
+
+	failures, _ = takeEndpointFailures(EndpointFailuresKey(n.ID, url))
+	if failures != 0 {
+		t.Errorf("expected no failures once taken; got %d", failures)
+	}
+}
+
+func TestScoreEndpointHealthCountsRecordedFailures(t *testing.T) {
+	testutil.NewMockRedis(t)
+	n := &Network{}
+	n.ID, _ = uuid.NewV4()
+	url := "https://rpc.example.com"
+
+	n.RecordEndpointFailure(url)
+
+	h := &EndpointHealth{URL: url}
+	n.scoreEndpointHealth(h, nil)
+	if h.ErrorRate == 0 {
+		t.Error("expected recorded failure to count toward the error rate")
+	}
+
+	h = &EndpointHealth{URL: url}
+	n.scoreEndpointHealth(h, nil)
+	if h.ErrorRate != 0 {
+		t.Errorf("expected recorded failure to be counted once; got error rate %v", h.ErrorRate)
+	}
+}
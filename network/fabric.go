@@ -0,0 +1,94 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package network
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/network/p2p"
+)
+
+// FabricConfig returns the configuration used to connect to the gateway of the fabric network;
+// the network config must include its channels and the msp of the fabric identity, which certifies
+// the signing account key using the enrollment certificate of the account or of the network
+func (n *Network) FabricConfig() (*p2p.FabricConfig, error) {
+	if !n.IsHyperledgerFabricNetwork() {
+		return nil, errors.New("network is not a hyperledger fabric network")
+	}
+	return p2p.ParseFabricConfig(n.ParseConfig())
+}
+
+// FabricGateway connects to the gateway of a peer on the fabric network on behalf of the given
+// signing identity; the caller is responsible for closing the returned gateway
+func (n *Network) FabricGateway(signer *p2p.FabricSigningIdentity) (*p2p.FabricGateway, *p2p.FabricConfig, error) {
+	cfg, err := n.FabricConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, rpcURL, err := n.RPCEndpoint()
+	if err != nil {
+		return nil, nil, err
+	} else if rpcURL == "" {
+		return nil, nil, errors.New("no fabric gateway endpoint configured")
+	}
+
+	gw, err := p2p.DialFabricGateway(rpcURL, cfg, signer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return gw, cfg, nil
+}
+
+// FabricAddress returns the address of the account with the given hex-encoded P-256 public key, which
+// is the subject key identifier used by fabric, i.e., the hex-encoded SHA-256 hash of the uncompressed public key
+func (n *Network) FabricAddress(publicKey string) (*string, error) {
+	pubkey, err := ParseP256PublicKey(publicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	ski := sha256.Sum256(elliptic.Marshal(elliptic.P256(), pubkey.X, pubkey.Y))
+	return common.StringOrNil(hex.EncodeToString(ski[:])), nil
+}
+
+// ParseP256PublicKey parses the given hex-encoded P-256 (secp256r1) public key, in compressed or uncompressed form
+func ParseP256PublicKey(publicKey string) (*ecdsa.PublicKey, error) {
+	raw, err := hex.DecodeString(strings.TrimPrefix(publicKey, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode secp256r1 public key; %s", err.Error())
+	}
+
+	curve := elliptic.P256()
+	x, y := elliptic.Unmarshal(curve, raw)
+	if x == nil {
+		x, y = elliptic.UnmarshalCompressed(curve, raw)
+	}
+	if x == nil {
+		return nil, errors.New("failed to parse secp256r1 public key")
+	}
+
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
@@ -19,6 +19,7 @@ package network
 import (
 	"encoding/json"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 
@@ -43,6 +44,8 @@ func InstallNetworksAPI(r *gin.Engine) {
 	r.GET("/api/v1/networks/:id/bridges", networkBridgesListHandler)
 	r.GET("/api/v1/networks/:id/connectors", networkConnectorsListHandler)
 	r.GET("/api/v1/networks/:id/status", networkStatusHandler)
+	r.GET("/api/v1/networks/:id/rpc_throttle", networkRPCThrottleHandler)
+	r.POST("/api/v1/networks/:id/rpc", networkRPCProxyHandler)
 
 	r.GET("/api/v1/networks/:id/load_balancers", loadBalancersListHandler)
 	r.GET("/api/v1/networks/:id/load_balancers/:loadBalancerId", loadBalancerDetailsHandler)
@@ -418,24 +421,108 @@ func networkStatusHandler(c *gin.Context) {
 		provide.RenderError("invalid network id provided", 400, c)
 		return
 	}
+	var network = &Network{}
+	dbconf.DatabaseConnection().Where("id = ?", networkID).Find(&network)
+	if network == nil || network.ID == uuid.Nil {
+		provide.RenderError("network not found", 404, c)
+		return
+	}
 	stats, err := Stats(networkID)
 	if err != nil {
-		var network = &Network{}
-		dbconf.DatabaseConnection().Where("id = ?", c.Param("id")).Find(&network)
-		if network == nil || network.ID == uuid.Nil {
-			provide.RenderError("network not found", 404, c)
-			return
-		}
-		provide.Render(&api.NetworkStatus{
+		stats = &api.NetworkStatus{
 			ChainID: network.ChainID,
 			State:   common.StringOrNil(networkStateGenesis),
 			Syncing: true,
-		}, 200, c)
-		return
+		}
+	}
+	if stats.Meta == nil {
+		stats.Meta = map[string]interface{}{}
 	}
+	stats.Meta["endpoints"] = network.EndpointHealth()
 	provide.Render(stats, 200, c)
 }
 
+func networkRPCThrottleHandler(c *gin.Context) {
+	var network = &Network{}
+	dbconf.DatabaseConnection().Where("id = ?", c.Param("id")).Find(&network)
+	if network == nil || network.ID == uuid.Nil {
+		provide.RenderError("network not found", 404, c)
+		return
+	}
+	provide.Render(network.RPCThrottleStats(), 200, c)
+}
+
+func networkRPCProxyHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	userID := util.AuthorizedSubjectID(c, "user")
+	if appID == nil && orgID == nil && userID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	var network = &Network{}
+	dbconf.DatabaseConnection().Where("id = ?", c.Param("id")).Find(&network)
+	if network == nil || network.ID == uuid.Nil || (network.Enabled != nil && !*network.Enabled) {
+		provide.RenderError("network not found", 404, c)
+		return
+	} else if network.ApplicationID != nil && (appID == nil || *network.ApplicationID != *appID) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	} else if network.ApplicationID == nil && network.UserID != nil && (userID == nil || *network.UserID != *userID) {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	requests, batch, errResponse := ParseJSONRPCRequests(buf)
+	if errResponse != nil {
+		provide.Render(errResponse, 200, c)
+		return
+	}
+
+	var subject string
+	if appID != nil {
+		subject = fmt.Sprintf("application.%s", appID.String())
+	} else if orgID != nil {
+		subject = fmt.Sprintf("organization.%s", orgID.String())
+	} else {
+		subject = fmt.Sprintf("user.%s", userID.String())
+	}
+
+	delay, err := network.AcquireRPCProxyCalls(subject, len(requests))
+	if err != nil {
+		if _, ok := err.(*ThrottleBurstExceededError); ok {
+			provide.Render(jsonRPCErrorResponse(nil, jsonRPCErrorInvalidRequest, fmt.Sprintf("invalid request; batch of %s", err.Error())), 200, c)
+			return
+		}
+
+		common.Log.Warningf("failed to apply JSON-RPC proxy rate limit for %s on network: %s; %s", subject, network.ID, err.Error())
+		provide.RenderError("rate limit unavailable", 503, c)
+		return
+	} else if delay > 0 {
+		c.Header("retry-after", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+		provide.RenderError("rate limit exceeded", 429, c)
+		return
+	}
+
+	response, err := network.ProxyJSONRPC(requests, batch)
+	if err != nil {
+		provide.RenderError(err.Error(), 502, c)
+		return
+	} else if response == nil {
+		provide.Render(nil, 204, c)
+		return
+	}
+
+	provide.Render(response, 200, c)
+}
+
 func networkOraclesListHandler(c *gin.Context) {
 	provide.RenderError("not implemented", 501, c)
 }
@@ -17,6 +17,7 @@
 package network
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"math/rand"
@@ -26,6 +27,7 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethrpc "github.com/ethereum/go-ethereum/rpc"
 	"github.com/jinzhu/gorm"
 	dbconf "github.com/kthomas/go-db-config"
 	natsutil "github.com/kthomas/go-natsutil"
@@ -55,6 +57,7 @@ const networkConfigChainspec = "chainspec"
 const networkConfigChainspecURL = "chainspec_url"
 const networkConfigChainspecABI = "chainspec_abi"
 const networkConfigChainspecABIURL = "chainspec_abi_url"
+const networkConfigConfirmations = "confirmations"
 const networkConfigEnv = "env"
 const networkConfigJSONRPCURL = "json_rpc_url"
 const networkConfigJSONRPCPort = "json_rpc_port"
@@ -72,6 +75,8 @@ const networkConfigIsHandshakeNetwork = "is_handshake_network"
 const networkConfigIsHyperledgerBesuNetwork = "is_hyperledger_besu_network"
 const networkConfigIsHyperledgerFabricNetwork = "is_hyperledger_fabric_network"
 const networkConfigIsQuorumNetwork = "is_quorum_network"
+const networkConfigTransactionManagerURL = "transaction_manager_url"
+const networkConfigTransactionManagerPublicKey = "transaction_manager_public_key"
 
 const networkConfigEnvBootnodes = "BOOTNODES"
 const networkConfigEnvClient = "CLIENT"
@@ -136,6 +141,8 @@ func Stats(networkID uuid.UUID) (*provideapi.NetworkStatus, error) {
 	rawstats, err := redisutil.Get(statsKey)
 	if err != nil {
 		return nil, fmt.Errorf("failed to retrieve cached network stats from key: %s; %s", statsKey, err.Error())
+	} else if rawstats == nil {
+		return nil, fmt.Errorf("failed to retrieve cached network stats from key: %s", statsKey)
 	}
 
 	stats := &provideapi.NetworkStatus{}
@@ -587,6 +594,14 @@ func (n *Network) Validate() bool {
 			}
 		}
 
+		if confirmations, confirmationsOk := config[networkConfigConfirmations]; confirmationsOk {
+			if _confirmations, ok := confirmations.(float64); !ok || _confirmations < 0 || _confirmations != float64(uint64(_confirmations)) {
+				n.Errors = append(n.Errors, &provide.Error{
+					Message: common.StringOrNil("confirmations should be a non-negative integer if provided"),
+				})
+			}
+		}
+
 		blockExplorerURL, blockExplorerURLOk := config["block_explorer_url"].(string)
 		if blockExplorerURLOk {
 			_, err := url.Parse(blockExplorerURL)
@@ -669,9 +684,27 @@ func (n *Network) ParseConfig() map[string]interface{} {
 	return config
 }
 
-// RPCURL retrieves a load-balanced RPC URL for the network
-func (n *Network) RPCURL() string {
+// RPCURL retrieves a load-balanced RPC URL for the network, or the URL of its healthiest configured
+// JSON-RPC endpoint, once the network's rate limit permits a JSON-RPC call; a JSON-RPC call is made
+// using the returned URL. An error is returned if the rate limit does not permit the call within
+// rpcThrottleMaxWait, and an empty URL is returned if the network has no configured RPC URL
+func (n *Network) RPCURL() (string, error) {
 	cfg := n.ParseConfig()
+	err := n.awaitRPC(cfg)
+	if err != nil {
+		return "", err
+	}
+	return n.rpcURL(cfg), nil
+}
+
+// HasRPCURL returns true if the network has a load-balanced RPC URL or a configured JSON-RPC endpoint;
+// unlike RPCURL, it does not count towards the network's rate limit
+func (n *Network) HasRPCURL() bool {
+	return n.rpcURL(n.ParseConfig()) != ""
+}
+
+// rpcURL retrieves a load-balanced RPC URL for the network, or the URL of its healthiest configured JSON-RPC endpoint
+func (n *Network) rpcURL(cfg map[string]interface{}) string {
 	balancers, _ := n.LoadBalancers(dbconf.DatabaseConnection(), nil, common.StringOrNil(loadBalancerTypeRPC))
 	if balancers != nil && len(balancers) > 0 {
 		balancer := balancers[rand.Intn(len(balancers))] // FIXME-- better would be to factor in geography of end user and/or give weight to balanced regions with more nodes
@@ -686,13 +719,16 @@ func (n *Network) RPCURL() string {
 			return url
 		}
 	}
-	if rpcURL, ok := cfg[networkConfigJSONRPCURL].(string); ok {
-		return rpcURL
+	endpoints := parseEndpoints(cfg, networkConfigJSONRPCEndpoints, networkConfigJSONRPCURL)
+	if len(endpoints) > 1 {
+		cached := n.cachedHealth()
+		return selectEndpoint(endpoints, cached.health, cached.failed)
 	}
-	return ""
+	return selectEndpoint(endpoints, nil, nil)
 }
 
-// WebsocketURL retrieves a load-balanced websocket URL for the network
+// WebsocketURL retrieves a load-balanced websocket URL for the network, or the URL of its healthiest
+// configured websocket endpoint
 func (n *Network) WebsocketURL() string {
 	cfg := n.ParseConfig()
 	balancers, _ := n.LoadBalancers(dbconf.DatabaseConnection(), nil, common.StringOrNil(loadBalancerTypeRPC))
@@ -709,10 +745,12 @@ func (n *Network) WebsocketURL() string {
 			return url
 		}
 	}
-	if websocketURL, ok := cfg[networkConfigWebsocketURL].(string); ok {
-		return websocketURL
+	endpoints := parseEndpoints(cfg, networkConfigWebsocketEndpoints, networkConfigWebsocketURL)
+	if len(endpoints) > 1 {
+		cached := n.cachedHealth()
+		return selectEndpoint(endpoints, cached.health, cached.failed)
 	}
-	return ""
+	return selectEndpoint(endpoints, nil, nil)
 }
 
 // addPeer adds the given peer url to the network topology and notifies other peers of the new peer's existence
@@ -777,9 +815,14 @@ func (n *Network) InvokeJSONRPC(method string, params []interface{}) (map[string
 		rpcAPIUser := cfg[networkConfigRPCAPIUser].(string)
 		rpcAPIKey := cfg[networkConfigRPCAPIKey].(string)
 		var resp map[string]interface{}
-		err := providecrypto.BcoinInvokeJsonRpcClient(n.ID.String(), n.RPCURL(), rpcAPIUser, rpcAPIKey, method, params, &resp)
+		rpcClientKey, rpcURL, err := n.RPCEndpoint()
+		if err != nil {
+			return nil, err
+		}
+		err = providecrypto.BcoinInvokeJsonRpcClient(rpcClientKey, rpcURL, rpcAPIUser, rpcAPIKey, method, params, &resp)
 		if err != nil {
 			common.Log.Warningf("Failed to invoke JSON-RPC method %s with params: %s; %s", method, params, err.Error())
+			n.RecordEndpointFailure(rpcURL)
 			return nil, err
 		}
 		result, _ := resp["result"].(map[string]interface{})
@@ -789,6 +832,30 @@ func (n *Network) InvokeJSONRPC(method string, params []interface{}) (map[string
 	return nil, fmt.Errorf("JSON-RPC invocation not supported by network %s", n.ID)
 }
 
+// EVMRPCClientCall invokes the given JSON-RPC method on the EVM-based network and unmarshals the result
+func (n *Network) EVMRPCClientCall(result interface{}, method string, args ...interface{}) error {
+	if !n.IsEthereumNetwork() {
+		return fmt.Errorf("EVM JSON-RPC invocation not supported by network %s", n.ID)
+	}
+
+	rpcClientKey, rpcURL, err := n.RPCEndpoint()
+	if err != nil {
+		return fmt.Errorf("failed to resolve JSON-RPC client for network %s; %s", n.ID, err.Error())
+	}
+	client, err := providecrypto.EVMResolveJsonRpcClient(rpcClientKey, rpcURL)
+	if err != nil {
+		n.RecordEndpointFailure(rpcURL)
+		return fmt.Errorf("failed to resolve JSON-RPC client for network %s; %s", n.ID, err.Error())
+	}
+
+	err = client.CallContext(context.TODO(), result, method, args...)
+	if _, isRPCErr := err.(ethrpc.Error); err != nil && !isRPCErr {
+		// the endpoint did not respond, as opposed to responding with a JSON-RPC error
+		n.RecordEndpointFailure(rpcURL)
+	}
+	return err
+}
+
 // BootnodesTxt retrieves the current bootnodes string for the network; this value can be used
 // to set peer/bootnodes list from which new network nodes are initialized
 func (n *Network) BootnodesTxt() (*string, error) {
@@ -900,6 +967,18 @@ func (n *Network) IsBcoinNetwork() bool {
 	return false
 }
 
+// Confirmations returns the number of blocks which must be mined on top of the block
+// which includes a tx before the tx is considered final; defaults to 0
+func (n *Network) Confirmations() uint64 {
+	cfg := n.ParseConfig()
+	if cfg != nil {
+		if confirmations, ok := cfg[networkConfigConfirmations].(float64); ok && confirmations > 0 {
+			return uint64(confirmations)
+		}
+	}
+	return 0
+}
+
 // IsEthereumNetwork returns true if the network is EVM-based
 func (n *Network) IsEthereumNetwork() bool {
 	cfg := n.ParseConfig()
@@ -922,6 +1001,52 @@ func (n *Network) IsHandshakeNetwork() bool {
 	return false
 }
 
+// IsHyperledgerFabricNetwork returns true if the network is hyperledger fabric-based
+func (n *Network) IsHyperledgerFabricNetwork() bool {
+	cfg := n.ParseConfig()
+	if cfg != nil {
+		if isHyperledgerFabricNetwork, ok := cfg[networkConfigIsHyperledgerFabricNetwork].(bool); ok {
+			return isHyperledgerFabricNetwork
+		}
+	}
+	return false
+}
+
+// IsQuorumNetwork returns true if the network is quorum-based
+func (n *Network) IsQuorumNetwork() bool {
+	cfg := n.ParseConfig()
+	if cfg != nil {
+		if isQuorumNetwork, ok := cfg[networkConfigIsQuorumNetwork].(bool); ok {
+			return isQuorumNetwork
+		}
+	}
+	return false
+}
+
+// TransactionManagerURL returns the url of the private transaction manager (i.e., tessera)
+// used to store the payloads of private txs on a quorum network, or nil if none is configured
+func (n *Network) TransactionManagerURL() *string {
+	cfg := n.ParseConfig()
+	if cfg != nil {
+		if tmURL, ok := cfg[networkConfigTransactionManagerURL].(string); ok && tmURL != "" {
+			return common.StringOrNil(strings.TrimSuffix(tmURL, "/"))
+		}
+	}
+	return nil
+}
+
+// TransactionManagerPublicKey returns the base64-encoded public key of the private transaction
+// manager, which is the default sender of private txs on a quorum network, or nil if none is configured
+func (n *Network) TransactionManagerPublicKey() *string {
+	cfg := n.ParseConfig()
+	if cfg != nil {
+		if publicKey, ok := cfg[networkConfigTransactionManagerPublicKey].(string); ok && publicKey != "" {
+			return common.StringOrNil(publicKey)
+		}
+	}
+	return nil
+}
+
 // P2PAPIClient returns an instance of the network's underlying p2p.API, if that is possible given the network config
 func (n *Network) P2PAPIClient() (p2p.API, error) {
 	cfg := n.ParseConfig()
@@ -929,8 +1054,10 @@ func (n *Network) P2PAPIClient() (p2p.API, error) {
 	if !clientOk {
 		return nil, fmt.Errorf("Failed to resolve p2p provider for network: %s; no configured client", n.ID)
 	}
-	rpcURL := n.RPCURL()
-	if rpcURL == "" {
+	rpcURL, err := n.RPCURL()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to resolve p2p provider for network: %s; %s", n.ID, err.Error())
+	} else if rpcURL == "" {
 		common.Log.Debugf("Resolving %s p2p provider for network which does not yet have a configured rpc url; network id: %s", client, n.ID)
 	}
 
@@ -17,9 +17,16 @@
 package p2p
 
 import (
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"math/big"
+	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	uuid "github.com/kthomas/go.uuid"
@@ -27,6 +34,8 @@ import (
 	provide "github.com/provideplatform/provide-go/api/nchain"
 )
 
+const baseledgerRPCTimeout = time.Second * 30
+
 // BaseledgerP2PProvider is a network.p2p.API implementing the baseledger API
 type BaseledgerP2PProvider struct {
 	rpcClientKey *string
@@ -72,14 +81,90 @@ func (p *BaseledgerP2PProvider) AddPeer(peerURL string) error {
 	return errors.New("not yet implemented")
 }
 
-// FetchTxReceipt fetch a transaction receipt given its hash
+// FetchTxReceipt fetch a transaction receipt given its hash; the receipt is resolved using the
+// tendermint /tx endpoint once the tx has been included in a block, and its status is 0 if the tx
+// was included but failed to execute (i.e., a non-zero result code)
 func (p *BaseledgerP2PProvider) FetchTxReceipt(signerAddress, hash string) (*provide.TxReceipt, error) {
-	return nil, errors.New("not yet implemented")
+	if p.rpcURL == nil || *p.rpcURL == "" {
+		return nil, errors.New("failed to fetch tx receipt; no rpc url configured")
+	}
+
+	hash = strings.TrimPrefix(strings.ToUpper(hash), "0X")
+	client := &http.Client{Timeout: baseledgerRPCTimeout}
+	resp, err := client.Get(fmt.Sprintf("%s/tx?hash=0x%s", strings.TrimSuffix(*p.rpcURL, "/"), hash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tx receipt for tx hash: %s; %s", hash, err.Error())
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tx receipt for tx hash: %s; %s", hash, err.Error())
+	}
+
+	var txResp struct {
+		Result *struct {
+			Hash     string `json:"hash"`
+			Height   string `json:"height"`
+			Index    uint   `json:"index"`
+			TxResult struct {
+				Code      uint32        `json:"code"`
+				Log       string        `json:"log"`
+				GasWanted string        `json:"gas_wanted"`
+				GasUsed   string        `json:"gas_used"`
+				Events    []interface{} `json:"events"`
+				Codespace string        `json:"codespace"`
+			} `json:"tx_result"`
+		} `json:"result"`
+		Error *struct {
+			Message string `json:"message"`
+			Data    string `json:"data"`
+		} `json:"error"`
+	}
+	err = json.Unmarshal(body, &txResp)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal tx receipt for tx hash: %s; %s", hash, err.Error())
+	}
+
+	if txResp.Error != nil {
+		return nil, fmt.Errorf("failed to fetch tx receipt for tx hash: %s; %s; %s", hash, txResp.Error.Message, txResp.Error.Data)
+	} else if txResp.Result == nil {
+		return nil, fmt.Errorf("failed to fetch tx receipt for tx hash: %s", hash)
+	}
+
+	height, err := strconv.ParseUint(txResp.Result.Height, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse block height of tx receipt for tx hash: %s; %s", hash, err.Error())
+	}
+
+	gasUsed, _ := strconv.ParseUint(txResp.Result.TxResult.GasUsed, 10, 64)
+	txHash, _ := hex.DecodeString(txResp.Result.Hash)
+
+	status := uint64(1)
+	if txResp.Result.TxResult.Code != 0 {
+		status = 0
+		common.Log.Debugf("baseledger tx %s failed with code %d (codespace: %s); %s", hash, txResp.Result.TxResult.Code, txResp.Result.TxResult.Codespace, txResp.Result.TxResult.Log)
+	}
+
+	logs := txResp.Result.TxResult.Events
+	if logs == nil {
+		logs = make([]interface{}, 0)
+	}
+
+	return &provide.TxReceipt{
+		TxHash:            txHash,
+		GasUsed:           gasUsed,
+		BlockNumber:       new(big.Int).SetUint64(height),
+		TransactionIndex:  txResp.Result.Index,
+		Status:            status,
+		CumulativeGasUsed: gasUsed,
+		Logs:              logs,
+	}, nil
 }
 
-// FetchTxTraces fetch transaction traces given its hash
+// FetchTxTraces fetch transaction traces given its hash; tendermint does not trace txs, so an empty trace is returned
 func (p *BaseledgerP2PProvider) FetchTxTraces(hash string) (*provide.TxTrace, error) {
-	return nil, errors.New("not yet implemented")
+	return &provide.TxTrace{}, nil
 }
 
 // FormatBootnodes formats the given peer urls as a valid bootnodes param
@@ -17,16 +17,309 @@
 package p2p
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"math/big"
 	"strings"
+	"time"
 
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/hyperledger/fabric-gateway/pkg/identity"
+	fabriccommon "github.com/hyperledger/fabric-protos-go-apiv2/common"
+	"github.com/hyperledger/fabric-protos-go-apiv2/peer"
 	"github.com/jinzhu/gorm"
 	uuid "github.com/kthomas/go.uuid"
 	"github.com/provideplatform/nchain/common"
 	provide "github.com/provideplatform/provide-go/api/nchain"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/proto"
 )
 
+const fabricGatewayTimeout = time.Second * 30
+
+// fabricQuerySystemChaincode is the system chaincode used to query the ledger of a channel
+const fabricQuerySystemChaincode = "qscc"
+
+// FabricIdentity is a fabric MSP identity; its signing key is never configured on the network, but
+// is instead held by the account which signs on behalf of the identity
+type FabricIdentity struct {
+	MspID       string `json:"msp_id"`
+	Certificate string `json:"certificate,omitempty"` // PEM-encoded x509 enrollment certificate used when the signing account has none
+}
+
+// FabricSigningIdentity is the account key used to sign proposals, transactions and commit status
+// requests on behalf of the fabric identity; the key must be the one certified by the identity
+type FabricSigningIdentity struct {
+	PublicKey   string                              // hex-encoded public key of the signing account
+	Certificate *string                             // PEM-encoded x509 enrollment certificate issued for the signing account key, if any
+	Sign        func(digest []byte) ([]byte, error) // signs the digest; the signature may be raw (r || s or r || s || v) or DER-encoded
+}
+
+// FabricConfig is the configuration used to connect to the gateway of a fabric peer
+type FabricConfig struct {
+	Channels         []string        `json:"channels"` // the first channel is the default channel
+	Identity         *FabricIdentity `json:"identity"`
+	TLSCACertificate *string         `json:"tls_ca_certificate,omitempty"` // PEM-encoded CA certificate of the peer
+	TLSServerName    *string         `json:"tls_server_name,omitempty"`
+}
+
+// ParseFabricConfig parses the fabric gateway configuration from the given network config
+func ParseFabricConfig(cfg map[string]interface{}) (*FabricConfig, error) {
+	cfgJSON, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal fabric network config; %s", err.Error())
+	}
+
+	fabricCfg := &FabricConfig{}
+	err = json.Unmarshal(cfgJSON, &fabricCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse fabric network config; %s", err.Error())
+	}
+
+	if len(fabricCfg.Channels) == 0 {
+		return nil, errors.New("invalid fabric network config; no channels configured")
+	}
+
+	if fabricCfg.Identity == nil || fabricCfg.Identity.MspID == "" {
+		return nil, errors.New("invalid fabric network config; identity requires an msp_id")
+	}
+
+	return fabricCfg, nil
+}
+
+// DefaultChannel returns the channel used when a chaincode does not specify one
+func (cfg *FabricConfig) DefaultChannel() string {
+	return cfg.Channels[0]
+}
+
+// HasChannel returns true if the given channel is configured
+func (cfg *FabricConfig) HasChannel(channel string) bool {
+	for _, ch := range cfg.Channels {
+		if ch == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// FabricGateway is a connection to the gateway service of a fabric peer
+type FabricGateway struct {
+	*client.Gateway
+	conn *grpc.ClientConn
+}
+
+// Close the gateway and its underlying grpc connection
+func (gw *FabricGateway) Close() error {
+	gw.Gateway.Close()
+	return gw.conn.Close()
+}
+
+// DialFabricGateway connects to the gateway of the fabric peer at the given endpoint; proposals,
+// transactions and commit status requests are signed by the given signing identity, which must
+// hold the key certified by its enrollment certificate or that of the configured fabric identity
+func DialFabricGateway(endpoint string, cfg *FabricConfig, signer *FabricSigningIdentity) (*FabricGateway, error) {
+	if signer == nil || signer.Sign == nil {
+		return nil, errors.New("failed to dial fabric gateway; no signing identity provided")
+	}
+
+	id, sign, err := signer.Identity(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	target, creds, err := fabricGatewayCredentials(endpoint, cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := grpc.Dial(target, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial fabric gateway: %s; %s", target, err.Error())
+	}
+
+	gw, err := client.Connect(
+		id,
+		client.WithSign(sign),
+		client.WithClientConnection(conn),
+		client.WithEvaluateTimeout(fabricGatewayTimeout),
+		client.WithEndorseTimeout(fabricGatewayTimeout),
+		client.WithSubmitTimeout(fabricGatewayTimeout),
+		client.WithCommitStatusTimeout(fabricGatewayTimeout),
+	)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to connect to fabric gateway: %s; %s", target, err.Error())
+	}
+
+	return &FabricGateway{
+		Gateway: gw,
+		conn:    conn,
+	}, nil
+}
+
+// Identity returns the fabric identity certified by the enrollment certificate of the signing account,
+// or by the certificate of the given fabric identity if the account has none, and the function used
+// to sign digests on its behalf; an error is returned if the signing key is not the certified key
+func (signer *FabricSigningIdentity) Identity(cfg *FabricConfig) (*identity.X509Identity, identity.Sign, error) {
+	certificate := cfg.Identity.Certificate
+	if signer.Certificate != nil && *signer.Certificate != "" {
+		certificate = *signer.Certificate
+	}
+	if certificate == "" {
+		return nil, nil, fmt.Errorf("no enrollment certificate issued for signing key by msp: %s", cfg.Identity.MspID)
+	}
+
+	cert, publicKey, err := ParseFabricCertificate(certificate)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !fabricPublicKeyMatches(publicKey, signer.PublicKey) {
+		return nil, nil, fmt.Errorf("signing key is not certified by fabric identity of msp: %s", cfg.Identity.MspID)
+	}
+
+	id, err := identity.NewX509Identity(cfg.Identity.MspID, cert)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to initialize fabric identity; %s", err.Error())
+	}
+
+	return id, fabricSign(signer, publicKey.Curve.Params().N), nil
+}
+
+// ParseFabricCertificate parses the given PEM-encoded x509 enrollment certificate and its ECDSA public key
+func ParseFabricCertificate(certificate string) (*x509.Certificate, *ecdsa.PublicKey, error) {
+	cert, err := identity.CertificateFromPEM([]byte(certificate))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse fabric identity certificate; %s", err.Error())
+	}
+
+	publicKey, publicKeyOk := cert.PublicKey.(*ecdsa.PublicKey)
+	if !publicKeyOk {
+		return nil, nil, fmt.Errorf("unsupported fabric identity certificate public key type: %T", cert.PublicKey)
+	}
+
+	return cert, publicKey, nil
+}
+
+// VerifyFabricCertificate returns an error if the given PEM-encoded x509 enrollment certificate does
+// not certify the given hex-encoded public key
+func VerifyFabricCertificate(certificate, publicKey string) error {
+	_, certified, err := ParseFabricCertificate(certificate)
+	if err != nil {
+		return err
+	}
+
+	if !fabricPublicKeyMatches(certified, publicKey) {
+		return errors.New("enrollment certificate does not certify the public key")
+	}
+
+	return nil
+}
+
+// fabricGatewayCredentials returns the grpc target and transport credentials for the given endpoint;
+// TLS is used when a CA certificate is configured or the endpoint scheme is grpcs or https
+func fabricGatewayCredentials(endpoint string, cfg *FabricConfig) (string, credentials.TransportCredentials, error) {
+	target := endpoint
+	useTLS := cfg.TLSCACertificate != nil
+	if idx := strings.Index(endpoint, "://"); idx != -1 {
+		scheme := strings.ToLower(endpoint[0:idx])
+		useTLS = useTLS || scheme == "grpcs" || scheme == "https"
+		target = endpoint[idx+3:]
+	}
+	target = strings.TrimSuffix(target, "/")
+
+	if !useTLS {
+		return target, insecure.NewCredentials(), nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if cfg.TLSCACertificate != nil {
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM([]byte(*cfg.TLSCACertificate)) {
+			return "", nil, errors.New("failed to parse fabric peer TLS CA certificate")
+		}
+		tlsConfig.RootCAs = certPool
+	}
+	if cfg.TLSServerName != nil {
+		tlsConfig.ServerName = *cfg.TLSServerName
+	}
+
+	return target, credentials.NewTLS(tlsConfig), nil
+}
+
+// fabricPublicKeyMatches returns true if the given hex-encoded public key, in compressed or
+// uncompressed form, is the certified public key
+func fabricPublicKeyMatches(certified *ecdsa.PublicKey, publicKey string) bool {
+	raw, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(publicKey), "0x"))
+	if err != nil {
+		return false
+	}
+
+	var x, y *big.Int
+	if len(raw) == 1+2*((certified.Curve.Params().BitSize+7)/8) {
+		x, y = elliptic.Unmarshal(certified.Curve, raw)
+	} else {
+		x, y = elliptic.UnmarshalCompressed(certified.Curve, raw)
+	}
+
+	return x != nil && x.Cmp(certified.X) == 0 && y.Cmp(certified.Y) == 0
+}
+
+// fabricSign returns a function which signs digests using the given signing identity
+func fabricSign(signer *FabricSigningIdentity, curveN *big.Int) identity.Sign {
+	return func(digest []byte) ([]byte, error) {
+		sig, err := signer.Sign(digest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign %d-byte digest on behalf of fabric identity; %s", len(digest), err.Error())
+		}
+
+		return canonicalFabricSignature(sig, curveN)
+	}
+}
+
+// canonicalFabricSignature returns the given raw (r || s), recoverable (r || s || v) or DER-encoded
+// ECDSA signature as a DER-encoded signature with a low s value, which fabric requires to prevent
+// malleability; the recovery id of a recoverable signature is not part of the fabric signature
+func canonicalFabricSignature(sig []byte, curveN *big.Int) ([]byte, error) {
+	var ecdsaSig struct {
+		R, S *big.Int
+	}
+
+	size := (curveN.BitLen() + 7) / 8
+	if len(sig) == size*2+1 {
+		// drop the recovery id, unless the signature happens to be DER-encoded
+		if rest, err := asn1.Unmarshal(sig, &ecdsaSig); err != nil || len(rest) > 0 {
+			sig = sig[0 : size*2]
+		}
+	}
+
+	if len(sig) == size*2 {
+		ecdsaSig.R = new(big.Int).SetBytes(sig[0:size])
+		ecdsaSig.S = new(big.Int).SetBytes(sig[size:])
+	} else if ecdsaSig.R == nil {
+		if _, err := asn1.Unmarshal(sig, &ecdsaSig); err != nil {
+			return nil, fmt.Errorf("failed to parse %d-byte ECDSA signature; %s", len(sig), err.Error())
+		}
+	}
+
+	halfOrder := new(big.Int).Rsh(curveN, 1)
+	if ecdsaSig.S.Cmp(halfOrder) == 1 {
+		ecdsaSig.S = new(big.Int).Sub(curveN, ecdsaSig.S)
+	}
+
+	return asn1.Marshal(ecdsaSig)
+}
+
 // HyperledgerFabricP2PProvider is a network.p2p.API implementing the hyperledger fabric API
 type HyperledgerFabricP2PProvider struct {
 	rpcClientKey *string
@@ -64,14 +357,88 @@ func (p *HyperledgerFabricP2PProvider) EnrichStartCommand(bootnodes []string) []
 	return cmd
 }
 
-// FetchTxReceipt fetch a transaction receipt given its hash
-func (p *HyperledgerFabricP2PProvider) FetchTxReceipt(hash, signerAddress string) (*provide.TxReceipt, error) {
-	return nil, errors.New("fabric does not impl FetchTxReceipt()")
+// FetchTxReceipt fetch a transaction receipt given its hash; the ledger queries used to resolve a
+// fabric tx receipt must be signed by the account which signed the tx, so FetchFabricTxReceipt is
+// used instead
+func (p *HyperledgerFabricP2PProvider) FetchTxReceipt(signerAddress, hash string) (*provide.TxReceipt, error) {
+	return nil, errors.New("fabric p2p provider does not impl FetchTxReceipt(); fabric tx receipts are fetched on behalf of the signing account")
+}
+
+// FetchFabricTxReceipt fetch a transaction receipt given its hash using the given gateway; the hash
+// of a fabric tx is its tx id, and its validation code and block number are resolved from the ledger
+// of each of the given channels
+func FetchFabricTxReceipt(gw *FabricGateway, channels []string, hash string) (*provide.TxReceipt, error) {
+	err := errors.New("no channels configured")
+	txID := strings.TrimPrefix(strings.ToLower(hash), "0x")
+	for _, channel := range channels {
+		qscc := gw.GetNetwork(channel).GetContract(fabricQuerySystemChaincode)
+
+		processedTxRaw, evalErr := qscc.EvaluateTransaction("GetTransactionByID", channel, txID)
+		if evalErr != nil {
+			err = evalErr
+			continue
+		}
+
+		processedTx := &peer.ProcessedTransaction{}
+		err = proto.Unmarshal(processedTxRaw, processedTx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal processed tx for tx id: %s; %s", txID, err.Error())
+		}
+
+		blockRaw, err := qscc.EvaluateTransaction("GetBlockByTxID", channel, txID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch block for tx id: %s; %s", txID, err.Error())
+		}
+
+		block := &fabriccommon.Block{}
+		err = proto.Unmarshal(blockRaw, block)
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal block for tx id: %s; %s", txID, err.Error())
+		}
+
+		validationCode := peer.TxValidationCode(processedTx.ValidationCode)
+		status := uint64(1)
+		if validationCode != peer.TxValidationCode_VALID {
+			status = 0
+			common.Log.Debugf("fabric tx %s on channel %s failed validation; %s", txID, channel, validationCode.String())
+		}
+
+		txHash, _ := hex.DecodeString(txID)
+		return &provide.TxReceipt{
+			TxHash:      txHash,
+			BlockHash:   fabricBlockHeaderHash(block.Header),
+			BlockNumber: new(big.Int).SetUint64(block.Header.Number),
+			Status:      status,
+			Logs: []interface{}{
+				map[string]interface{}{
+					"channel":         channel,
+					"validation_code": validationCode.String(),
+				},
+			},
+		}, nil
+	}
+
+	return nil, fmt.Errorf("failed to fetch tx receipt for tx id: %s; %s", txID, err.Error())
+}
+
+// fabricBlockHeaderHash returns the hash of the given block header, as computed by fabric
+func fabricBlockHeaderHash(header *fabriccommon.BlockHeader) []byte {
+	headerBytes, _ := asn1.Marshal(struct {
+		Number       *big.Int
+		PreviousHash []byte
+		DataHash     []byte
+	}{
+		Number:       new(big.Int).SetUint64(header.Number),
+		PreviousHash: header.PreviousHash,
+		DataHash:     header.DataHash,
+	})
+	digest := sha256.Sum256(headerBytes)
+	return digest[:]
 }
 
-// FetchTxTraces fetch transaction traces given its hash
-func (p *HyperledgerFabricP2PProvider) FetchTxTraces(string) (*provide.TxTrace, error) {
-	return nil, errors.New("fabric does not impl FetchTxTraces()")
+// FetchTxTraces fetch transaction traces given its hash; fabric does not trace txs, so an empty trace is returned
+func (p *HyperledgerFabricP2PProvider) FetchTxTraces(hash string) (*provide.TxTrace, error) {
+	return &provide.TxTrace{}, nil
 }
 
 // AcceptNonReservedPeers allows non-reserved peers to connect
@@ -0,0 +1,235 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package p2p
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// fabricTestIdentity returns a P-256 key and a fabric config whose identity certifies the key
+func fabricTestIdentity(t *testing.T) (*ecdsa.PrivateKey, *FabricConfig) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate fabric identity key; %s", err.Error())
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "user1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create fabric identity certificate; %s", err.Error())
+	}
+
+	return key, &FabricConfig{
+		Channels: []string{"mychannel"},
+		Identity: &FabricIdentity{
+			MspID:       "Org1MSP",
+			Certificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		},
+	}
+}
+
+func TestParseFabricConfigDoesNotAcceptSigningKeyFromNetworkConfig(t *testing.T) {
+	cfg, err := ParseFabricConfig(map[string]interface{}{
+		"channels": []interface{}{"mychannel"},
+		"identity": map[string]interface{}{
+			"msp_id":      "Org1MSP",
+			"certificate": "-----BEGIN CERTIFICATE-----",
+			"vault_id":    "00000000-0000-0000-0000-000000000001",
+			"key_id":      "00000000-0000-0000-0000-000000000002",
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to parse fabric config; %s", err.Error())
+	}
+	if cfg.Identity.MspID != "Org1MSP" {
+		t.Errorf("expected msp id to be parsed; got %s", cfg.Identity.MspID)
+	}
+
+	_, err = ParseFabricConfig(map[string]interface{}{
+		"channels": []interface{}{"mychannel"},
+		"identity": map[string]interface{}{"msp_id": "Org1MSP"},
+	})
+	if err != nil {
+		t.Errorf("expected fabric config without a network-wide identity certificate to be accepted; %s", err.Error())
+	}
+
+	_, err = ParseFabricConfig(map[string]interface{}{
+		"channels": []interface{}{"mychannel"},
+		"identity": map[string]interface{}{"certificate": "-----BEGIN CERTIFICATE-----"},
+	})
+	if err == nil {
+		t.Error("expected fabric config without an msp id to be rejected")
+	}
+}
+
+func TestDialFabricGatewayRequiresCertifiedSigningIdentity(t *testing.T) {
+	_, cfg := fabricTestIdentity(t)
+	other, _ := fabricTestIdentity(t)
+
+	sign := func(digest []byte) ([]byte, error) {
+		t.Error("expected no digest to be signed by an uncertified signing identity")
+		return nil, nil
+	}
+
+	if _, err := DialFabricGateway("localhost:7051", cfg, nil); err == nil {
+		t.Error("expected fabric gateway to not be dialed without a signing identity")
+	}
+
+	_, err := DialFabricGateway("localhost:7051", cfg, &FabricSigningIdentity{
+		PublicKey: hex.EncodeToString(elliptic.Marshal(elliptic.P256(), other.X, other.Y)),
+		Sign:      sign,
+	})
+	if err == nil {
+		t.Error("expected fabric gateway to not be dialed using a key which is not certified by the fabric identity")
+	}
+}
+
+func TestFabricPublicKeyMatches(t *testing.T) {
+	key, _ := fabricTestIdentity(t)
+	other, _ := fabricTestIdentity(t)
+
+	uncompressed := hex.EncodeToString(elliptic.Marshal(elliptic.P256(), key.X, key.Y))
+	compressed := hex.EncodeToString(elliptic.MarshalCompressed(elliptic.P256(), key.X, key.Y))
+
+	if !fabricPublicKeyMatches(&key.PublicKey, uncompressed) {
+		t.Error("expected uncompressed public key to match the certified key")
+	}
+	if !fabricPublicKeyMatches(&key.PublicKey, "0x"+compressed) {
+		t.Error("expected 0x-prefixed compressed public key to match the certified key")
+	}
+	if fabricPublicKeyMatches(&key.PublicKey, hex.EncodeToString(elliptic.Marshal(elliptic.P256(), other.X, other.Y))) {
+		t.Error("expected public key of another key to not match the certified key")
+	}
+	if fabricPublicKeyMatches(&key.PublicKey, "not hex") {
+		t.Error("expected malformed public key to not match the certified key")
+	}
+}
+
+func TestFabricSignReturnsLowSDERSignature(t *testing.T) {
+	key, _ := fabricTestIdentity(t)
+	curveN := elliptic.P256().Params().N
+	digest := sha256.Sum256([]byte("proposal"))
+
+	signer := &FabricSigningIdentity{
+		PublicKey: hex.EncodeToString(elliptic.Marshal(elliptic.P256(), key.X, key.Y)),
+		Sign: func(digest []byte) ([]byte, error) {
+			r, s, err := ecdsa.Sign(rand.Reader, key, digest)
+			if err != nil {
+				return nil, err
+			}
+			// return the malleated (high s) form of the signature as raw r || s
+			if s.Cmp(new(big.Int).Rsh(curveN, 1)) != 1 {
+				s = new(big.Int).Sub(curveN, s)
+			}
+			sig := make([]byte, 64)
+			r.FillBytes(sig[0:32])
+			s.FillBytes(sig[32:])
+			return sig, nil
+		},
+	}
+
+	der, err := fabricSign(signer, curveN)(digest[:])
+	if err != nil {
+		t.Fatalf("failed to sign digest; %s", err.Error())
+	}
+
+	var sig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		t.Fatalf("expected DER-encoded signature; %s", err.Error())
+	}
+	if sig.S.Cmp(new(big.Int).Rsh(curveN, 1)) == 1 {
+		t.Error("expected signature to have a low s value")
+	}
+	if !ecdsa.Verify(&key.PublicKey, digest[:], sig.R, sig.S) {
+		t.Error("expected canonical signature to verify using the certified key")
+	}
+}
+
+func TestFabricSigningIdentityUsesAccountCertificate(t *testing.T) {
+	_, cfg := fabricTestIdentity(t)
+	key, accountCfg := fabricTestIdentity(t)
+
+	signer := &FabricSigningIdentity{
+		PublicKey: hex.EncodeToString(elliptic.Marshal(elliptic.P256(), key.X, key.Y)),
+		Sign: func(digest []byte) ([]byte, error) {
+			return nil, nil
+		},
+	}
+
+	if _, _, err := signer.Identity(cfg); err == nil {
+		t.Error("expected signing key which is not certified by the network-wide certificate to be rejected")
+	}
+
+	signer.Certificate = &accountCfg.Identity.Certificate
+	id, _, err := signer.Identity(cfg)
+	if err != nil {
+		t.Fatalf("expected signing key certified by the enrollment certificate of the account to be accepted; %s", err.Error())
+	}
+	if id.MspID() != cfg.Identity.MspID {
+		t.Errorf("expected identity of msp %s; got %s", cfg.Identity.MspID, id.MspID())
+	}
+
+	cfg.Identity.Certificate = ""
+	signer.Certificate = nil
+	if _, _, err := signer.Identity(cfg); err == nil {
+		t.Error("expected signing identity without an enrollment certificate to be rejected")
+	}
+}
+
+func TestCanonicalFabricSignatureDropsRecoveryID(t *testing.T) {
+	key, _ := fabricTestIdentity(t)
+	curveN := elliptic.P256().Params().N
+	digest := sha256.Sum256([]byte("proposal"))
+
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		t.Fatalf("failed to sign digest; %s", err.Error())
+	}
+	raw := make([]byte, 65)
+	r.FillBytes(raw[0:32])
+	s.FillBytes(raw[32:64])
+	raw[64] = 1
+
+	der, err := canonicalFabricSignature(raw, curveN)
+	if err != nil {
+		t.Fatalf("expected r || s || v signature to be canonicalized; %s", err.Error())
+	}
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], der) {
+		t.Error("expected canonical signature to verify using the certified key")
+	}
+}
@@ -134,6 +134,16 @@ func (p *QuorumP2PProvider) FetchTxReceipt(signerAddress, hash string) (*provide
 		return nil, err
 	}
 
+	// the receipt of a private tx reflects the private state only if the node is a party to the tx
+	status := receipt.Status
+	err = p.checkPrivateTxPayload(hash)
+	if err == errPrivateTxPayloadUnavailable {
+		common.Log.Warningf("private payload for tx hash %s is not available to the node; failing tx as its receipt does not reflect the private state", hash)
+		status = types.ReceiptStatusFailed
+	} else if err != nil {
+		return nil, err
+	}
+
 	logs := make([]interface{}, 0)
 	for _, log := range receipt.Logs {
 		logs = append(logs, *log)
@@ -147,13 +157,72 @@ func (p *QuorumP2PProvider) FetchTxReceipt(signerAddress, hash string) (*provide
 		BlockNumber:       receipt.BlockNumber,
 		TransactionIndex:  receipt.TransactionIndex,
 		PostState:         receipt.PostState,
-		Status:            receipt.Status,
+		Status:            status,
 		CumulativeGasUsed: receipt.CumulativeGasUsed,
 		Bloom:             receipt.Bloom,
 		Logs:              logs,
 	}, nil
 }
 
+// invokeJSONRPC invokes the given JSON-RPC method and unmarshals its result; an error is
+// returned if the node responds with a JSON-RPC error
+func (p *QuorumP2PProvider) invokeJSONRPC(method string, params []interface{}, result interface{}) error {
+	var resp struct {
+		Result *json.RawMessage `json:"result"`
+		Error  *struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+
+	err := providecrypto.EVMInvokeJsonRpcClient(*p.rpcClientKey, *p.rpcURL, method, params, &resp)
+	if err != nil {
+		return err
+	} else if resp.Error != nil {
+		return fmt.Errorf("%s JSON-RPC invocation failed with code: %d; %s", method, resp.Error.Code, resp.Error.Message)
+	} else if resp.Result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(*resp.Result, result)
+}
+
+// errPrivateTxPayloadUnavailable is returned when the payload of a private tx cannot be retrieved from the
+// private transaction manager of the node (i.e., the node is not a party to the tx)
+var errPrivateTxPayloadUnavailable = errors.New("private tx payload not available to the node")
+
+// checkPrivateTxPayload returns errPrivateTxPayloadUnavailable if the tx with the given hash is a private tx and
+// its payload cannot be retrieved from the private transaction manager of the node, in which case the receipt
+// returned by the node does not reflect the private state; an error is returned if the payload cannot be checked
+func (p *QuorumP2PProvider) checkPrivateTxPayload(hash string) error {
+	var tx map[string]interface{}
+	err := p.invokeJSONRPC("eth_getTransactionByHash", []interface{}{hash}, &tx)
+	if err != nil {
+		return fmt.Errorf("failed to fetch tx for tx hash: %s; unable to check private tx payload; %s", hash, err.Error())
+	} else if tx == nil {
+		return fmt.Errorf("failed to fetch tx for tx hash: %s; unable to check private tx payload", hash)
+	}
+
+	v, _ := tx["v"].(string)
+	input, _ := tx["input"].(string)
+	if v != "0x25" && v != "0x26" {
+		return nil
+	} else if input == "" || input == "0x" {
+		return errPrivateTxPayloadUnavailable
+	}
+
+	var payload string
+	err = p.invokeJSONRPC("eth_getQuorumPayload", []interface{}{input}, &payload)
+	if err != nil {
+		return fmt.Errorf("failed to fetch private payload for tx hash: %s; %s", hash, err.Error())
+	} else if payload == "" || payload == "0x" {
+		return errPrivateTxPayloadUnavailable
+	}
+
+	common.Log.Debugf("fetched %d-byte private payload for tx hash: %s", len(payload)/2-1, hash)
+	return nil
+}
+
 // FetchTxTraces fetch transaction traces given its hash
 func (p *QuorumP2PProvider) FetchTxTraces(hash string) (*provide.TxTrace, error) {
 	traces, err := evmFetchTxTraces(p.networkID, *p.rpcURL, hash)
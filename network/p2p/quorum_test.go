@@ -0,0 +1,100 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package p2p
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newQuorumRPCServer returns a JSON-RPC server which responds to each method with the given result
+func newQuorumRPCServer(t *testing.T, results map[string]interface{}) *QuorumP2PProvider {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var req struct {
+			ID     json.RawMessage `json:"id"`
+			Method string          `json:"method"`
+		}
+		json.Unmarshal(body, &req)
+
+		result, ok := results[req.Method]
+		w.Header().Set("content-type", "application/json")
+		if !ok {
+			fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"error":{"code":-32601,"message":"method not found"}}`, req.ID)
+			return
+		}
+		raw, _ := json.Marshal(result)
+		fmt.Fprintf(w, `{"jsonrpc":"2.0","id":%s,"result":%s}`, req.ID, raw)
+	}))
+	t.Cleanup(server.Close)
+
+	rpcURL := server.URL
+	return InitQuorumP2PProvider(&rpcURL, "quorum", nil)
+}
+
+func TestCheckPrivateTxPayloadUnavailable(t *testing.T) {
+	p := newQuorumRPCServer(t, map[string]interface{}{
+		"eth_getTransactionByHash": map[string]interface{}{"v": "0x25", "input": "0x0102"},
+		"eth_getQuorumPayload":     "0x",
+	})
+
+	err := p.checkPrivateTxPayload("0x01")
+	if err != errPrivateTxPayloadUnavailable {
+		t.Errorf("expected private tx payload to be unavailable; got %v", err)
+	}
+}
+
+func TestCheckPrivateTxPayloadAvailable(t *testing.T) {
+	p := newQuorumRPCServer(t, map[string]interface{}{
+		"eth_getTransactionByHash": map[string]interface{}{"v": "0x26", "input": "0x0102"},
+		"eth_getQuorumPayload":     "0x60806040",
+	})
+
+	err := p.checkPrivateTxPayload("0x02")
+	if err != nil {
+		t.Errorf("expected private tx payload to be available; %s", err.Error())
+	}
+}
+
+func TestCheckPrivateTxPayloadPublicTx(t *testing.T) {
+	p := newQuorumRPCServer(t, map[string]interface{}{
+		"eth_getTransactionByHash": map[string]interface{}{"v": "0x1c", "input": "0x0102"},
+	})
+
+	err := p.checkPrivateTxPayload("0x03")
+	if err != nil {
+		t.Errorf("expected public tx not to be checked; %s", err.Error())
+	}
+}
+
+func TestCheckPrivateTxPayloadLookupFailure(t *testing.T) {
+	p := newQuorumRPCServer(t, map[string]interface{}{
+		"eth_getTransactionByHash": map[string]interface{}{"v": "0x25", "input": "0x0102"},
+	})
+
+	err := p.checkPrivateTxPayload("0x04")
+	if err == nil || err == errPrivateTxPayloadUnavailable {
+		t.Errorf("expected failed payload lookup to be retried rather than fail the tx; got %v", err)
+	}
+}
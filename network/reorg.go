@@ -0,0 +1,187 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package network
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/jinzhu/gorm"
+	natsutil "github.com/kthomas/go-natsutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	provide "github.com/provideplatform/provide-go/crypto"
+)
+
+const natsBlockReorgSubject = "nchain.block.reorg"
+
+// maxReorgDepth is the maximum number of blocks walked when resolving the blocks orphaned by a reorg
+const maxReorgDepth = 128
+
+// natsBlockReorgMsg is published when previously-seen blocks are orphaned by a chain reorg
+type natsBlockReorgMsg struct {
+	NetworkID string           `json:"network_id"`
+	Block     uint64           `json:"block"` // height of the canonical block which revealed the reorg
+	Hash      string           `json:"hash"`  // hash of the canonical block which revealed the reorg
+	Orphaned  []*orphanedBlock `json:"orphaned"`
+}
+
+// orphanedBlock is a block which is no longer part of the canonical chain
+type orphanedBlock struct {
+	Block uint64 `json:"block"`
+	Hash  string `json:"hash"`
+}
+
+// canonicalBlock returns the canonical block at the given height, or nil if no such block exists
+func (n *Network) canonicalBlock(height uint64) (map[string]interface{}, error) {
+	rpcClientKey, rpcURL, err := n.RPCEndpoint()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch block %d for network id: %s; %s", height, n.ID, err.Error())
+	}
+
+	resp, err := provide.EVMGetBlockByNumber(rpcClientKey, rpcURL, height)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch block %d for network id: %s; %s", height, n.ID, err.Error())
+	}
+
+	block, _ := resp.Result.(map[string]interface{})
+	return block, nil
+}
+
+// canonicalBlockHash returns the hash of the canonical block at the given height, or nil if no such block exists
+func (n *Network) canonicalBlockHash(height uint64) (*string, error) {
+	block, err := n.canonicalBlock(height)
+	if err != nil {
+		return nil, err
+	}
+
+	if hash, hashOk := block["hash"].(string); hashOk {
+		return &hash, nil
+	}
+	return nil, nil
+}
+
+// parseBlockTimestamp returns the timestamp of the given block, as returned by eth_getBlockByNumber
+func parseBlockTimestamp(block map[string]interface{}) time.Time {
+	if timestamp, timestampOk := block["timestamp"].(string); timestampOk {
+		if seconds, err := hexutil.DecodeUint64(timestamp); err == nil {
+			return time.Unix(int64(seconds), 0)
+		}
+	}
+	return time.Now()
+}
+
+// resolveReorg compares the blocks previously seen on the network against the canonical chain
+// ending in the given block; a reorg event is published so that txs included in the orphaned
+// blocks can be rolled back and re-resolved, and the blocks which were orphaned are then removed
+func (n *Network) resolveReorg(db *gorm.DB, height uint64, hash, parentHash string) ([]*Block, error) {
+	orphaned := make([]*Block, 0)
+
+	// blocks previously seen at this height which are not the canonical block
+	var replaced []*Block
+	db.Where("network_id = ? AND block = ? AND hash != ?", n.ID, height, hash).Find(&replaced)
+	orphaned = append(orphaned, replaced...)
+
+	// walk back from the parent until the previously-seen chain matches the canonical chain
+	canonicalHash := parentHash
+	for h := int64(height) - 1; h >= 0 && int64(height)-h <= maxReorgDepth; h-- {
+		var seen []*Block
+		db.Where("network_id = ? AND block = ?", n.ID, h).Find(&seen)
+		if len(seen) == 0 {
+			break
+		}
+
+		mismatched := make([]*Block, 0)
+		for _, block := range seen {
+			if !strings.EqualFold(block.Hash, canonicalHash) {
+				mismatched = append(mismatched, block)
+			}
+		}
+
+		if len(mismatched) == 0 {
+			break
+		}
+		orphaned = append(orphaned, mismatched...)
+
+		if h == 0 {
+			break
+		}
+
+		parent, err := n.canonicalBlockHash(uint64(h - 1))
+		if err != nil {
+			return nil, err
+		} else if parent == nil {
+			break
+		}
+		canonicalHash = *parent
+	}
+
+	if len(replaced) > 0 {
+		// the canonical chain may be shorter than the orphaned chain
+		var descendants []*Block
+		db.Where("network_id = ? AND block > ? AND block <= ?", n.ID, height, height+maxReorgDepth).Find(&descendants)
+		for _, block := range descendants {
+			canonical, err := n.canonicalBlockHash(uint64(block.Block))
+			if err != nil {
+				return nil, err
+			}
+
+			if canonical == nil || !strings.EqualFold(block.Hash, *canonical) {
+				orphaned = append(orphaned, block)
+			}
+		}
+	}
+
+	if len(orphaned) == 0 {
+		return orphaned, nil
+	}
+
+	reorgMsg := &natsBlockReorgMsg{
+		NetworkID: n.ID.String(),
+		Block:     height,
+		Hash:      hash,
+		Orphaned:  make([]*orphanedBlock, 0),
+	}
+
+	blockIDs := make([]uuid.UUID, 0)
+	for _, block := range orphaned {
+		blockIDs = append(blockIDs, block.ID)
+		reorgMsg.Orphaned = append(reorgMsg.Orphaned, &orphanedBlock{
+			Block: uint64(block.Block),
+			Hash:  block.Hash,
+		})
+	}
+
+	// the reorg is published before the orphaned blocks are removed, so the reorg is detected
+	// again (and republished) when the block finalized message is redelivered after a failure
+	payload, _ := json.Marshal(reorgMsg)
+	_, err := natsutil.NatsJetstreamPublish(natsBlockReorgSubject, payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to publish reorg event on subject %s; network: %s; %s", natsBlockReorgSubject, n.ID, err.Error())
+	}
+
+	result := db.Where("id IN (?)", blockIDs).Delete(&Block{})
+	if result.Error != nil {
+		return nil, fmt.Errorf("failed to remove %d orphaned block(s) for network id: %s; %s", len(orphaned), n.ID, result.Error.Error())
+	}
+
+	common.Log.Debugf("resolved reorg of %d block(s) on network %s at block %d (%s)", len(orphaned), n.ID, height, hash)
+	return orphaned, nil
+}
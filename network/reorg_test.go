@@ -0,0 +1,82 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package network
+
+import (
+	"strings"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/internal/testutil"
+)
+
+func TestResolveReorgRetainsOrphanedBlocksWhenPublishFails(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+
+	ntwrk := &Network{}
+	ntwrk.ID, _ = uuid.NewV4()
+	orphanedID, _ := uuid.NewV4()
+
+	// a block was previously seen at the reorged height
+	mock.ExpectQuery(`SELECT \* FROM "blocks" WHERE \(network_id = \$1 AND block = \$2 AND hash != \$3\)`).
+		WithArgs(ntwrk.ID, 100, "0xcanonical").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "network_id", "block", "hash"}).AddRow(orphanedID, ntwrk.ID, 100, "0xorphaned"))
+
+	// no blocks were seen below the reorged height
+	mock.ExpectQuery(`SELECT \* FROM "blocks" WHERE \(network_id = \$1 AND block = \$2\)`).
+		WithArgs(ntwrk.ID, 99).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	// no blocks were seen above the reorged height
+	mock.ExpectQuery(`SELECT \* FROM "blocks" WHERE \(network_id = \$1 AND block > \$2 AND block <= \$3\)`).
+		WithArgs(ntwrk.ID, 100, 100+maxReorgDepth).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	// the reorg event cannot be published without a NATS connection, so the orphaned
+	// blocks must not be removed; the unexpected DELETE would fail the mock expectations
+	_, err := ntwrk.resolveReorg(db, 100, "0xcanonical", "0xparent")
+	if err == nil || !strings.Contains(err.Error(), "failed to publish reorg event") {
+		t.Fatalf("expected reorg resolution to fail to publish the reorg event before removing orphaned blocks; %v", err)
+	}
+}
+
+func TestResolveReorgWithoutOrphanedBlocks(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+
+	ntwrk := &Network{}
+	ntwrk.ID, _ = uuid.NewV4()
+
+	mock.ExpectQuery(`SELECT \* FROM "blocks" WHERE \(network_id = \$1 AND block = \$2 AND hash != \$3\)`).
+		WithArgs(ntwrk.ID, 100, "0xcanonical").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	mock.ExpectQuery(`SELECT \* FROM "blocks" WHERE \(network_id = \$1 AND block = \$2\)`).
+		WithArgs(ntwrk.ID, 99).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "network_id", "block", "hash"}).AddRow(uuid.Nil, ntwrk.ID, 99, "0xPARENT"))
+
+	orphaned, err := ntwrk.resolveReorg(db, 100, "0xcanonical", "0xparent")
+	if err != nil {
+		t.Fatalf("failed to resolve reorg; %s", err.Error())
+	}
+	if len(orphaned) != 0 {
+		t.Errorf("resolved %d orphaned block(s); expected none", len(orphaned))
+	}
+}
@@ -0,0 +1,485 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package network
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+)
+
+// networkConfigRPCMethodAllowlist is the list of JSON-RPC methods which can be invoked using the JSON-RPC proxy;
+// an entry ending in * allows every method with the given prefix. When configured, it replaces defaultRPCMethodAllowlist
+const networkConfigRPCMethodAllowlist = "rpc_method_allowlist"
+
+// networkConfigRPCProxyRateLimit is the max sustained number of JSON-RPC calls per second each tenant can make using the JSON-RPC proxy
+const networkConfigRPCProxyRateLimit = "rpc_proxy_rate_limit"
+
+// networkConfigRPCProxyBurst is the max number of JSON-RPC calls each tenant can make at once using the JSON-RPC proxy
+const networkConfigRPCProxyBurst = "rpc_proxy_burst"
+
+// networkConfigRPCProxyMaxLogsBlockRange is the max number of blocks which can be queried by an eth_getLogs call made using the JSON-RPC proxy
+const networkConfigRPCProxyMaxLogsBlockRange = "rpc_proxy_max_logs_block_range"
+
+const defaultRPCProxyRateLimit = float64(10)
+const defaultRPCProxyMaxLogsBlockRange = uint64(1000)
+const defaultRPCProxyBurst = float64(100)
+
+const jsonRPCVersion = "2.0"
+const jsonRPCErrorParse = -32700
+const jsonRPCErrorInvalidRequest = -32600
+const jsonRPCErrorMethodNotFound = -32601
+const jsonRPCErrorInvalidParams = -32602
+const jsonRPCErrorInternal = -32603
+
+// maxRPCProxyBatchSize is the max number of calls in a JSON-RPC batch request made using the JSON-RPC proxy
+const maxRPCProxyBatchSize = 100
+
+const rpcProxyTimeout = time.Second * 30
+
+// defaultRPCMethodAllowlist are the read-only JSON-RPC methods which can be invoked using the JSON-RPC proxy
+// unless the network configures an allowlist; stateful filter methods are excluded, as subsequent calls
+// are not guaranteed to reach the same endpoint. eth_getLogs calls are limited to a max block range
+var defaultRPCMethodAllowlist = map[string]bool{
+	"eth_blockNumber":                         true,
+	"eth_call":                                true,
+	"eth_chainId":                             true,
+	"eth_estimateGas":                         true,
+	"eth_feeHistory":                          true,
+	"eth_gasPrice":                            true,
+	"eth_getBalance":                          true,
+	"eth_getBlockByHash":                      true,
+	"eth_getBlockByNumber":                    true,
+	"eth_getBlockTransactionCountByHash":      true,
+	"eth_getBlockTransactionCountByNumber":    true,
+	"eth_getCode":                             true,
+	"eth_getLogs":                             true,
+	"eth_getProof":                            true,
+	"eth_getStorageAt":                        true,
+	"eth_getTransactionByBlockHashAndIndex":   true,
+	"eth_getTransactionByBlockNumberAndIndex": true,
+	"eth_getTransactionByHash":                true,
+	"eth_getTransactionCount":                 true,
+	"eth_getTransactionReceipt":               true,
+	"eth_getUncleByBlockHashAndIndex":         true,
+	"eth_getUncleByBlockNumberAndIndex":       true,
+	"eth_getUncleCountByBlockHash":            true,
+	"eth_getUncleCountByBlockNumber":          true,
+	"eth_maxPriorityFeePerGas":                true,
+	"eth_protocolVersion":                     true,
+	"eth_syncing":                             true,
+	"net_listening":                           true,
+	"net_peerCount":                           true,
+	"net_version":                             true,
+	"web3_clientVersion":                      true,
+	"web3_sha3":                               true,
+}
+
+// JSONRPCRequest is a JSON-RPC 2.0 request; a request without an id is a notification
+type JSONRPCRequest struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method"`
+	Params  *json.RawMessage `json:"params,omitempty"`
+}
+
+// JSONRPCResponse is a JSON-RPC 2.0 response
+type JSONRPCResponse struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id"`
+	Result  *json.RawMessage `json:"result,omitempty"`
+	Error   *JSONRPCError    `json:"error,omitempty"`
+}
+
+// JSONRPCError is a JSON-RPC 2.0 error
+type JSONRPCError struct {
+	Code    int              `json:"code"`
+	Message string           `json:"message"`
+	Data    *json.RawMessage `json:"data,omitempty"`
+}
+
+// jsonRPCErrorResponse returns a JSON-RPC error response to the request with the given id
+func jsonRPCErrorResponse(id *json.RawMessage, code int, message string) *JSONRPCResponse {
+	return &JSONRPCResponse{
+		JSONRPC: jsonRPCVersion,
+		ID:      id,
+		Error: &JSONRPCError{
+			Code:    code,
+			Message: message,
+		},
+	}
+}
+
+// ParseJSONRPCRequests parses the given JSON-RPC 2.0 request or batch request; batch is true if
+// a batch request was given. If the requests cannot be parsed, the error response is returned
+func ParseJSONRPCRequests(body []byte) (requests []*JSONRPCRequest, batch bool, errResponse *JSONRPCResponse) {
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		batch = true
+		err := json.Unmarshal(body, &requests)
+		if err != nil {
+			return nil, batch, jsonRPCErrorResponse(nil, jsonRPCErrorParse, fmt.Sprintf("parse error; %s", err.Error()))
+		}
+		if len(requests) == 0 {
+			return nil, batch, jsonRPCErrorResponse(nil, jsonRPCErrorInvalidRequest, "invalid request; empty batch")
+		}
+		if len(requests) > maxRPCProxyBatchSize {
+			return nil, batch, jsonRPCErrorResponse(nil, jsonRPCErrorInvalidRequest, fmt.Sprintf("invalid request; batch exceeds max size of %d", maxRPCProxyBatchSize))
+		}
+		return requests, batch, nil
+	}
+
+	var request *JSONRPCRequest
+	err := json.Unmarshal(body, &request)
+	if err != nil || request == nil {
+		msg := "parse error"
+		if err != nil {
+			msg = fmt.Sprintf("%s; %s", msg, err.Error())
+		}
+		return nil, batch, jsonRPCErrorResponse(nil, jsonRPCErrorParse, msg)
+	}
+
+	return []*JSONRPCRequest{request}, batch, nil
+}
+
+// RPCMethodAllowed returns true if the given JSON-RPC method can be invoked using the JSON-RPC proxy
+func (n *Network) RPCMethodAllowed(method string) bool {
+	cfg := n.ParseConfig()
+	allowlist, allowlistOk := cfg[networkConfigRPCMethodAllowlist].([]interface{})
+	if !allowlistOk {
+		return defaultRPCMethodAllowlist[method]
+	}
+
+	for _, item := range allowlist {
+		allowed, allowedOk := item.(string)
+		if !allowedOk {
+			continue
+		}
+		if allowed == method || (strings.HasSuffix(allowed, "*") && strings.HasPrefix(method, strings.TrimSuffix(allowed, "*"))) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RPCProxyThrottleKey returns the key for the given network id and tenant, which is guaranteed to be
+// unique-per-tenant-per-network; the key represents the namespace where the JSON-RPC proxy rate limit
+// state for the tenant is cached
+func RPCProxyThrottleKey(networkID uuid.UUID, subject string) string {
+	return fmt.Sprintf("network.%s.rpc.proxy.%s.throttle", networkID.String(), subject)
+}
+
+// AcquireRPCProxyCalls takes the given number of calls from the JSON-RPC proxy rate limit of the given
+// tenant; if the calls are not permitted, the amount of time until they are is returned
+func (n *Network) AcquireRPCProxyCalls(subject string, calls int) (time.Duration, error) {
+	cfg := n.ParseConfig()
+
+	rate, rateOk := cfg[networkConfigRPCProxyRateLimit].(float64)
+	if !rateOk || rate <= 0 {
+		rate = defaultRPCProxyRateLimit
+	}
+
+	burst, burstOk := cfg[networkConfigRPCProxyBurst].(float64)
+	if !burstOk || burst < 1 {
+		burst = defaultRPCProxyBurst
+	}
+
+	return acquireThrottleTokens(RPCProxyThrottleKey(n.ID, subject), rate, burst, float64(calls))
+}
+
+// getLogsBlockRange returns the number of blocks queried by the given eth_getLogs params; the latest
+// block is resolved using the given function when the range is not bounded by block numbers
+func getLogsBlockRange(params *json.RawMessage, latestBlock func() (uint64, error)) (uint64, error) {
+	var filters []map[string]interface{}
+	if params == nil || json.Unmarshal(*params, &filters) != nil || len(filters) != 1 {
+		return 0, fmt.Errorf("eth_getLogs requires a single filter object")
+	}
+
+	filter := filters[0]
+	if blockHash, ok := filter["blockHash"].(string); ok && blockHash != "" {
+		return 1, nil
+	}
+
+	var latest *uint64
+	resolveBlock := func(param string) (uint64, error) {
+		tag, ok := filter[param].(string)
+		if !ok && filter[param] != nil {
+			return 0, fmt.Errorf("invalid %s", param)
+		}
+
+		switch tag {
+		case "earliest":
+			return 0, nil
+		case "", "latest", "pending", "safe", "finalized":
+			if latest == nil {
+				block, err := latestBlock()
+				if err != nil {
+					return 0, fmt.Errorf("failed to resolve latest block; %s", err.Error())
+				}
+				latest = &block
+			}
+			return *latest, nil
+		}
+
+		block, err := hexutil.DecodeUint64(tag)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s: %s", param, tag)
+		}
+		return block, nil
+	}
+
+	fromBlock, err := resolveBlock("fromBlock")
+	if err != nil {
+		return 0, err
+	}
+
+	toBlock, err := resolveBlock("toBlock")
+	if err != nil {
+		return 0, err
+	}
+
+	if toBlock < fromBlock {
+		return 0, nil
+	}
+	return toBlock - fromBlock + 1, nil
+}
+
+// validateGetLogsParams returns an error if the given eth_getLogs params query more blocks than
+// the JSON-RPC proxy permits, or if the number of blocks queried cannot be resolved
+func (n *Network) validateGetLogsParams(params *json.RawMessage) error {
+	maxBlockRange := defaultRPCProxyMaxLogsBlockRange
+	if maxRange, ok := n.ParseConfig()[networkConfigRPCProxyMaxLogsBlockRange].(float64); ok && maxRange >= 1 {
+		maxBlockRange = uint64(maxRange)
+	}
+
+	blockRange, err := getLogsBlockRange(params, func() (uint64, error) {
+		stats, err := Stats(n.ID)
+		if err != nil {
+			return 0, err
+		} else if stats.Block == 0 {
+			return 0, fmt.Errorf("latest block not yet known for network: %s", n.ID)
+		}
+		return stats.Block, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if blockRange > maxBlockRange {
+		return fmt.Errorf("eth_getLogs block range of %d blocks exceeds max of %d blocks", blockRange, maxBlockRange)
+	}
+	return nil
+}
+
+// ProxyJSONRPC forwards the given JSON-RPC requests to the healthiest JSON-RPC endpoint of the network and
+// returns the response, or a slice of responses if batch is true; requests for methods which are not allowed,
+// and eth_getLogs requests which exceed the max block range, are not forwarded. No response is returned when
+// every request is a notification
+func (n *Network) ProxyJSONRPC(requests []*JSONRPCRequest, batch bool) (interface{}, error) {
+	responses := make([]*JSONRPCResponse, len(requests))
+	forwarded := make([]*JSONRPCRequest, 0)
+	forwardedIDs := map[int]bool{} // the ids of the forwarded requests, which are their indexes
+
+	for i, request := range requests {
+		if request == nil || request.JSONRPC != jsonRPCVersion || request.Method == "" {
+			var id *json.RawMessage
+			if request != nil {
+				id = request.ID
+			}
+			responses[i] = jsonRPCErrorResponse(id, jsonRPCErrorInvalidRequest, "invalid request")
+			continue
+		}
+
+		if !n.RPCMethodAllowed(request.Method) {
+			if request.ID != nil {
+				responses[i] = jsonRPCErrorResponse(request.ID, jsonRPCErrorMethodNotFound, fmt.Sprintf("method not allowed: %s", request.Method))
+			}
+			continue
+		}
+
+		if request.Method == "eth_getLogs" {
+			err := n.validateGetLogsParams(request.Params)
+			if err != nil {
+				if request.ID != nil {
+					responses[i] = jsonRPCErrorResponse(request.ID, jsonRPCErrorInvalidParams, fmt.Sprintf("invalid params; %s", err.Error()))
+				}
+				continue
+			}
+		}
+
+		// forwarded requests are identified by index, so upstream responses are matched regardless of their order
+		var id *json.RawMessage
+		if request.ID != nil {
+			rawID := json.RawMessage(fmt.Sprintf("%d", i))
+			id = &rawID
+			forwardedIDs[i] = true
+		}
+
+		forwarded = append(forwarded, &JSONRPCRequest{
+			JSONRPC: jsonRPCVersion,
+			ID:      id,
+			Method:  request.Method,
+			Params:  request.Params,
+		})
+	}
+
+	if len(forwarded) > 0 {
+		upstream, err := n.forwardJSONRPC(forwarded)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, response := range upstream {
+			var i int
+			if response.ID == nil || json.Unmarshal(*response.ID, &i) != nil {
+				continue
+			}
+			if !forwardedIDs[i] {
+				continue
+			}
+			response.ID = requests[i].ID
+			responses[i] = response
+		}
+
+		for i := range forwardedIDs {
+			if responses[i] == nil {
+				responses[i] = jsonRPCErrorResponse(requests[i].ID, jsonRPCErrorInternal, "no response from JSON-RPC endpoint")
+			}
+		}
+	}
+
+	results := make([]*JSONRPCResponse, 0)
+	for _, response := range responses {
+		if response != nil {
+			results = append(results, response)
+		}
+	}
+
+	if len(results) == 0 {
+		return nil, nil
+	} else if !batch {
+		return results[0], nil
+	}
+	return results, nil
+}
+
+// forwardJSONRPC posts the given requests to the healthiest JSON-RPC endpoint of the network, failing
+// over to the next healthiest endpoint once if the endpoint is unavailable
+func (n *Network) forwardJSONRPC(requests []*JSONRPCRequest) ([]*JSONRPCResponse, error) {
+	var payload []byte
+	if len(requests) == 1 {
+		payload, _ = json.Marshal(requests[0])
+	} else {
+		payload, _ = json.Marshal(requests)
+	}
+
+	attempts := 1
+	if len(n.RPCEndpoints()) > 1 {
+		attempts = 2
+	}
+
+	var err error
+	for attempt := 0; attempt < attempts; attempt++ {
+		var body []byte
+		body, err = n.postJSONRPC(payload, len(requests))
+		if err != nil {
+			continue
+		}
+
+		responses := make([]*JSONRPCResponse, 0)
+		trimmed := bytes.TrimSpace(body)
+		if len(trimmed) > 0 && trimmed[0] == '[' {
+			err = json.Unmarshal(trimmed, &responses)
+		} else {
+			var response *JSONRPCResponse
+			err = json.Unmarshal(trimmed, &response)
+			if response != nil && response.ID == nil && response.Error != nil {
+				// the endpoint rejected the request as a whole
+				for _, request := range requests {
+					if request.ID != nil {
+						responses = append(responses, &JSONRPCResponse{JSONRPC: jsonRPCVersion, ID: request.ID, Error: response.Error})
+					}
+				}
+			} else if response != nil {
+				responses = append(responses, response)
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to unmarshal JSON-RPC response; %s", err.Error())
+		}
+
+		return responses, nil
+	}
+
+	return nil, err
+}
+
+// postJSONRPC posts the given payload of the given number of calls to the healthiest JSON-RPC endpoint
+// of the network once its rate limit permits the calls; the endpoint is recorded as failed if it is unavailable
+func (n *Network) postJSONRPC(payload []byte, calls int) ([]byte, error) {
+	cfg := n.ParseConfig()
+	err := n.awaitRPCCalls(cfg, calls)
+	if err != nil {
+		return nil, err
+	}
+
+	rpcURL := n.rpcURL(cfg)
+	if rpcURL == "" {
+		return nil, fmt.Errorf("JSON-RPC invocation not supported by network %s", n.ID)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, rpcURL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("content-type", "application/json")
+
+	if rpcAPIUser, rpcAPIUserOk := cfg[networkConfigRPCAPIUser].(string); rpcAPIUserOk {
+		rpcAPIKey, _ := cfg[networkConfigRPCAPIKey].(string)
+		req.SetBasicAuth(rpcAPIUser, rpcAPIKey)
+	}
+
+	client := &http.Client{Timeout: rpcProxyTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		n.RecordEndpointFailure(rpcURL)
+		return nil, fmt.Errorf("failed to invoke JSON-RPC endpoint for network %s; %s", n.ID, err.Error())
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		n.RecordEndpointFailure(rpcURL)
+		return nil, fmt.Errorf("failed to read JSON-RPC response for network %s; %s", n.ID, err.Error())
+	}
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		n.RecordEndpointFailure(rpcURL)
+		return nil, fmt.Errorf("JSON-RPC endpoint for network %s responded with status: %d", n.ID, resp.StatusCode)
+	}
+
+	common.Log.Tracef("proxied %d-byte JSON-RPC request to network: %s", len(payload), n.ID)
+	return body, nil
+}
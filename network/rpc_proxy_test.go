@@ -0,0 +1,144 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package network
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/internal/testutil"
+)
+
+func rawParams(params string) *json.RawMessage {
+	raw := json.RawMessage(params)
+	return &raw
+}
+
+func TestGetLogsBlockRange(t *testing.T) {
+	latestBlock := func() (uint64, error) {
+		return 5000, nil
+	}
+
+	cases := []struct {
+		params string
+		blocks uint64
+	}{
+		{`[{"fromBlock":"0x1","toBlock":"0xa"}]`, 10},
+		{`[{"fromBlock":"0x1388"}]`, 1},
+		{`[{"fromBlock":"earliest","toBlock":"latest"}]`, 5001},
+		{`[{}]`, 1},
+		{`[{"blockHash":"0x01","fromBlock":"earliest"}]`, 1},
+		{`[{"fromBlock":"0xa","toBlock":"0x1"}]`, 0},
+	}
+
+	for _, c := range cases {
+		blocks, err := getLogsBlockRange(rawParams(c.params), latestBlock)
+		if err != nil {
+			t.Errorf("failed to resolve block range of %s; %s", c.params, err.Error())
+			continue
+		}
+		if blocks != c.blocks {
+			t.Errorf("expected block range of %s to be %d; got %d", c.params, c.blocks, blocks)
+		}
+	}
+}
+
+func TestGetLogsBlockRangeRequiresResolvableRange(t *testing.T) {
+	unknownLatestBlock := func() (uint64, error) {
+		return 0, errors.New("latest block unknown")
+	}
+
+	for _, params := range []string{`[{"fromBlock":"0x1"}]`, `[]`, `[{"fromBlock":"one"}]`, `{"fromBlock":"0x1"}`} {
+		_, err := getLogsBlockRange(rawParams(params), unknownLatestBlock)
+		if err == nil {
+			t.Errorf("expected block range of %s to be rejected", params)
+		}
+	}
+
+	_, err := getLogsBlockRange(nil, unknownLatestBlock)
+	if err == nil {
+		t.Error("expected eth_getLogs without params to be rejected")
+	}
+}
+
+func TestProxyJSONRPCRejectsGetLogsExceedingMaxBlockRange(t *testing.T) {
+	n := &Network{}
+	n.ID, _ = uuid.NewV4()
+
+	id := json.RawMessage(`1`)
+	response, err := n.ProxyJSONRPC([]*JSONRPCRequest{
+		{
+			JSONRPC: jsonRPCVersion,
+			ID:      &id,
+			Method:  "eth_getLogs",
+			Params:  rawParams(`[{"fromBlock":"0x0","toBlock":"0x3e8"}]`),
+		},
+	}, false)
+	if err != nil {
+		t.Fatalf("failed to proxy JSON-RPC request; %s", err.Error())
+	}
+
+	resp, ok := response.(*JSONRPCResponse)
+	if !ok || resp.Error == nil {
+		t.Fatalf("expected error response; got %v", response)
+	}
+	if resp.Error.Code != jsonRPCErrorInvalidParams {
+		t.Errorf("expected invalid params error; got %d", resp.Error.Code)
+	}
+}
+
+func TestAcquireRPCProxyCallsRejectsBatchExceedingBurst(t *testing.T) {
+	testutil.NewMockRedis(t)
+	n := &Network{}
+	n.ID, _ = uuid.NewV4()
+
+	_, err := n.AcquireRPCProxyCalls("application.test", int(defaultRPCProxyBurst)+1)
+	if _, ok := err.(*ThrottleBurstExceededError); !ok {
+		t.Fatalf("expected batch exceeding the burst to be rejected; got %v", err)
+	}
+
+	delay, err := n.AcquireRPCProxyCalls("application.test", int(defaultRPCProxyBurst))
+	if err != nil || delay != 0 {
+		t.Fatalf("expected batch within the burst to be permitted; got delay %v; %v", delay, err)
+	}
+
+	delay, err = n.AcquireRPCProxyCalls("application.test", 1)
+	if err != nil {
+		t.Fatalf("failed to acquire JSON-RPC proxy call; %s", err.Error())
+	}
+	if delay == 0 {
+		t.Error("expected the batch to be charged fully against the rate limit")
+	}
+}
+
+func TestAcquireRPCProxyCallsFailsWithoutCache(t *testing.T) {
+	server := testutil.NewMockRedis(t)
+	server.Close()
+
+	n := &Network{}
+	n.ID, _ = uuid.NewV4()
+
+	_, err := n.AcquireRPCProxyCalls("application.test", 1)
+	if err == nil {
+		t.Error("expected rate limit to fail closed when the cache is unavailable")
+	}
+}
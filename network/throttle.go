@@ -0,0 +1,349 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package network
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	redisutil "github.com/kthomas/go-redisutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+)
+
+// networkConfigRPCRateLimit is the max sustained number of JSON-RPC calls per second made to the network
+const networkConfigRPCRateLimit = "rpc_rate_limit"
+
+// networkConfigRPCBurst is the max number of JSON-RPC calls which can be made to the network at once;
+// it defaults to the rate limit
+const networkConfigRPCBurst = "rpc_burst"
+
+// rpcThrottleMaxWait is the max amount of time a JSON-RPC call waits on the rate limit before it fails
+const rpcThrottleMaxWait = time.Second * 5
+
+// rpcThrottleStateTTL is the amount of time the rate limit state of an idle network is retained
+const rpcThrottleStateTTL = time.Hour
+
+const rpcThrottleMetricAcquired = "acquired"
+const rpcThrottleMetricDelayed = "delayed"
+const rpcThrottleMetricThrottled = "throttled"
+
+// rpcThrottleState is the token bucket shared by every replica making JSON-RPC calls to a network;
+// it is cached as a hash so it can be updated atomically by rpcThrottleAcquireScript
+type rpcThrottleState struct {
+	Tokens    float64   `json:"tokens"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// rpcThrottleAcquireScript refills the token bucket at KEYS[1] at rate ARGV[1] up to burst ARGV[2] and
+// takes ARGV[3] tokens if they are available, as of the unix time ARGV[4] (in seconds); it returns the
+// number of milliseconds until the tokens are available, or 0 if they were taken. The bucket expires
+// after ARGV[5] milliseconds without calls
+const rpcThrottleAcquireScript = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local requested = tonumber(ARGV[3])
+local now = tonumber(ARGV[4])
+
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'updated_at')
+local tokens = tonumber(state[1])
+local updated = tonumber(state[2])
+if tokens == nil or updated == nil then
+	tokens = burst
+	updated = now
+end
+
+if now > updated then
+	tokens = math.min(burst, tokens + (now - updated) * rate)
+	updated = now
+end
+
+local delay = 0
+if tokens >= requested then
+	tokens = tokens - requested
+else
+	delay = math.ceil((requested - tokens) / rate * 1000)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'updated_at', tostring(updated))
+redis.call('PEXPIRE', KEYS[1], ARGV[5])
+return delay
+`
+
+// RPCThrottleStats are the JSON-RPC rate limit and throttling metrics for a network
+type RPCThrottleStats struct {
+	RateLimit float64 `json:"rate_limit"`
+	Burst     float64 `json:"burst"`
+	Tokens    float64 `json:"tokens"`
+	Acquired  int64   `json:"acquired"`  // JSON-RPC calls permitted by the rate limit
+	Throttled int64   `json:"throttled"` // JSON-RPC calls which waited on the rate limit
+	Delayed   int64   `json:"delayed"`   // NATS messages redelivered later because the rate limit was reached
+}
+
+// ThrottleBurstExceededError is returned when more tokens than the burst of a rate limit are requested at once
+type ThrottleBurstExceededError struct {
+	Tokens float64
+	Burst  float64
+}
+
+func (e *ThrottleBurstExceededError) Error() string {
+	return fmt.Sprintf("%v call(s) exceed the rate limit burst of %v call(s)", e.Tokens, e.Burst)
+}
+
+// RPCThrottleKey returns the key for the given network id, which is guaranteed to be unique-per-network;
+// the key represents the namespace where the JSON-RPC rate limit state for the network is cached
+func RPCThrottleKey(networkID uuid.UUID) string {
+	return fmt.Sprintf("network.%s.rpc.throttle", networkID.String())
+}
+
+// RPCThrottleMetricKey returns the key of the counter for the given JSON-RPC throttling metric
+func RPCThrottleMetricKey(networkID uuid.UUID, metric string) string {
+	return fmt.Sprintf("%s.%s", RPCThrottleKey(networkID), metric)
+}
+
+// rpcRateLimit returns the configured JSON-RPC rate limit and burst; ok is false if the network is not rate limited
+func rpcRateLimit(cfg map[string]interface{}) (rate, burst float64, ok bool) {
+	if cfg == nil {
+		return 0, 0, false
+	}
+
+	rate, ok = cfg[networkConfigRPCRateLimit].(float64)
+	if !ok || rate <= 0 {
+		return 0, 0, false
+	}
+
+	burst, burstOk := cfg[networkConfigRPCBurst].(float64)
+	if !burstOk || burst < 1 {
+		burst = math.Max(math.Ceil(rate), 1)
+	}
+
+	return rate, burst, true
+}
+
+// redisEval evaluates the given Lua script using the configured redis client or cluster client
+func redisEval(script string, keys []string, args ...interface{}) (interface{}, error) {
+	if redisutil.RedisClusterClient != nil {
+		return redisutil.RedisClusterClient.Eval(script, keys, args...).Result()
+	} else if redisutil.RedisClient != nil {
+		return redisutil.RedisClient.Eval(script, keys, args...).Result()
+	}
+
+	return nil, errors.New("redis not configured")
+}
+
+// readRPCThrottleState returns the cached rate limit state for the given key, or nil if no state is cached
+func readRPCThrottleState(key string) *rpcThrottleState {
+	var vals []interface{}
+	var err error
+
+	if redisutil.RedisClusterClient != nil {
+		vals, err = redisutil.RedisClusterClient.HMGet(key, "tokens", "updated_at").Result()
+	} else if redisutil.RedisClient != nil {
+		vals, err = redisutil.RedisClient.HMGet(key, "tokens", "updated_at").Result()
+	}
+	if err != nil || len(vals) != 2 {
+		return nil
+	}
+
+	rawtokens, tokensOk := vals[0].(string)
+	rawupdated, updatedOk := vals[1].(string)
+	if !tokensOk || !updatedOk {
+		return nil
+	}
+
+	tokens, err := strconv.ParseFloat(rawtokens, 64)
+	if err != nil {
+		common.Log.Warningf("failed to parse cached rpc throttle tokens from key: %s; %s", key, err.Error())
+		return nil
+	}
+
+	updated, err := strconv.ParseFloat(rawupdated, 64)
+	if err != nil {
+		common.Log.Warningf("failed to parse cached rpc throttle state timestamp from key: %s; %s", key, err.Error())
+		return nil
+	}
+
+	return &rpcThrottleState{
+		Tokens:    tokens,
+		UpdatedAt: time.Unix(0, int64(updated*float64(time.Second))),
+	}
+}
+
+// refill adds the tokens accrued since the state was last updated, up to the given burst
+func (s *rpcThrottleState) refill(rate, burst float64, now time.Time) {
+	elapsed := now.Sub(s.UpdatedAt).Seconds()
+	if elapsed > 0 {
+		s.Tokens = math.Min(burst, s.Tokens+elapsed*rate)
+	}
+	s.UpdatedAt = now
+}
+
+// delay returns the amount of time until the given number of tokens is available, or 0 if they are available now
+func (s *rpcThrottleState) delay(rate, tokens float64) time.Duration {
+	if s.Tokens >= tokens {
+		return 0
+	}
+	return time.Duration((tokens - s.Tokens) / rate * float64(time.Second))
+}
+
+// acquireThrottleTokens atomically takes the given number of tokens from the bucket cached at the given key;
+// if they are not available, the amount of time until they are is returned. An error is returned if more
+// tokens than the burst are requested, as they can never be taken at once
+func acquireThrottleTokens(key string, rate, burst, tokens float64) (time.Duration, error) {
+	if tokens > burst {
+		return 0, &ThrottleBurstExceededError{Tokens: tokens, Burst: burst}
+	}
+
+	now := float64(time.Now().UnixNano()) / float64(time.Second)
+
+	result, err := redisEval(rpcThrottleAcquireScript, []string{key},
+		strconv.FormatFloat(rate, 'f', -1, 64),
+		strconv.FormatFloat(burst, 'f', -1, 64),
+		strconv.FormatFloat(tokens, 'f', -1, 64),
+		strconv.FormatFloat(now, 'f', 6, 64),
+		rpcThrottleStateTTL.Milliseconds(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to acquire %v rpc throttle token(s) from key: %s; %s", tokens, key, err.Error())
+	}
+
+	delay, ok := result.(int64)
+	if !ok {
+		return 0, fmt.Errorf("failed to acquire %v rpc throttle token(s) from key: %s; unexpected result: %v", tokens, key, result)
+	}
+
+	return time.Duration(delay) * time.Millisecond, nil
+}
+
+// acquireRPC atomically takes the given number of tokens from the network's bucket; if they are not
+// available, the amount of time until they are is returned
+func (n *Network) acquireRPC(rate, burst float64, calls int) (time.Duration, error) {
+	return acquireThrottleTokens(RPCThrottleKey(n.ID), rate, burst, float64(calls))
+}
+
+// awaitRPC blocks until the network's rate limit permits another JSON-RPC call; see awaitRPCCalls
+func (n *Network) awaitRPC(cfg map[string]interface{}) error {
+	return n.awaitRPCCalls(cfg, 1)
+}
+
+// awaitRPCCalls blocks until the network's rate limit permits the given number of JSON-RPC calls; an
+// error is returned if the calls are not permitted within rpcThrottleMaxWait or exceed the burst. The
+// calls are permitted if the limit cannot be resolved, so a misconfigured limit or unavailable cache
+// never prevents them
+func (n *Network) awaitRPCCalls(cfg map[string]interface{}, calls int) error {
+	rate, burst, ok := rpcRateLimit(cfg)
+	if !ok {
+		return nil
+	}
+
+	deadline := time.Now().Add(rpcThrottleMaxWait)
+	throttled := false
+
+	for {
+		delay, err := n.acquireRPC(rate, burst, calls)
+		if err != nil {
+			if burstErr, burstErrOk := err.(*ThrottleBurstExceededError); burstErrOk {
+				return fmt.Errorf("JSON-RPC calls not permitted on network %s; %s", n.ID, burstErr.Error())
+			}
+			common.Log.Warningf("failed to acquire rate-limited JSON-RPC call(s) on network: %s; %s", n.ID, err.Error())
+			return nil
+		}
+
+		if delay == 0 {
+			n.incrementRPCThrottleMetric(rpcThrottleMetricAcquired)
+			return nil
+		}
+
+		if !throttled {
+			throttled = true
+			n.incrementRPCThrottleMetric(rpcThrottleMetricThrottled)
+			common.Log.Debugf("%d JSON-RPC call(s) on network %s throttled for %v by rate limit of %v call(s) per second", calls, n.ID, delay, rate)
+		}
+
+		if time.Now().Add(delay).After(deadline) {
+			return fmt.Errorf("JSON-RPC rate limit of %v call(s) per second on network %s exhausted; call(s) not permitted within %v", rate, n.ID, rpcThrottleMaxWait)
+		}
+
+		time.Sleep(delay)
+	}
+}
+
+// RPCThrottleDelay returns the amount of time until the network's rate limit permits another
+// JSON-RPC call, or 0 if a call is permitted now (or the network is not rate limited); no token
+// is taken, so callers use it to delay work which would otherwise be throttled
+func (n *Network) RPCThrottleDelay() time.Duration {
+	rate, burst, ok := rpcRateLimit(n.ParseConfig())
+	if !ok {
+		return 0
+	}
+
+	state := readRPCThrottleState(RPCThrottleKey(n.ID))
+	if state == nil {
+		return 0
+	}
+
+	state.refill(rate, burst, time.Now())
+	return state.delay(rate, 1)
+}
+
+// RecordRPCThrottleDelay records work which was delayed because the network's rate limit was reached
+func (n *Network) RecordRPCThrottleDelay() {
+	n.incrementRPCThrottleMetric(rpcThrottleMetricDelayed)
+}
+
+// RPCThrottleStats returns the JSON-RPC rate limit and throttling metrics for the network
+func (n *Network) RPCThrottleStats() *RPCThrottleStats {
+	stats := &RPCThrottleStats{
+		Acquired:  n.readRPCThrottleMetric(rpcThrottleMetricAcquired),
+		Delayed:   n.readRPCThrottleMetric(rpcThrottleMetricDelayed),
+		Throttled: n.readRPCThrottleMetric(rpcThrottleMetricThrottled),
+	}
+
+	rate, burst, ok := rpcRateLimit(n.ParseConfig())
+	if ok {
+		stats.RateLimit = rate
+		stats.Burst = burst
+		stats.Tokens = burst
+
+		state := readRPCThrottleState(RPCThrottleKey(n.ID))
+		if state != nil {
+			state.refill(rate, burst, time.Now())
+			stats.Tokens = state.Tokens
+		}
+	}
+
+	return stats
+}
+
+func (n *Network) incrementRPCThrottleMetric(metric string) {
+	_, err := redisutil.Increment(RPCThrottleMetricKey(n.ID, metric))
+	if err != nil {
+		common.Log.Debugf("failed to increment %s rpc throttle metric for network: %s; %s", metric, n.ID, err.Error())
+	}
+}
+
+func (n *Network) readRPCThrottleMetric(metric string) int64 {
+	raw, err := redisutil.Get(RPCThrottleMetricKey(n.ID, metric))
+	if err != nil || raw == nil {
+		return 0
+	}
+
+	val, _ := strconv.ParseInt(*raw, 10, 64)
+	return val
+}
@@ -0,0 +1,186 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package network
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/internal/testutil"
+)
+
+func TestAcquireThrottleTokensEnforcesBurst(t *testing.T) {
+	testutil.NewMockRedis(t)
+	networkID, _ := uuid.NewV4()
+	key := RPCThrottleKey(networkID)
+
+	for i := 0; i < 3; i++ {
+		delay, err := acquireThrottleTokens(key, 1, 3, 1)
+		if err != nil {
+			t.Fatalf("failed to acquire rpc throttle token; %s", err.Error())
+		}
+		if delay != 0 {
+			t.Errorf("expected token %d within burst to be acquired; got delay %v", i, delay)
+		}
+	}
+
+	delay, err := acquireThrottleTokens(key, 1, 3, 1)
+	if err != nil {
+		t.Fatalf("failed to acquire rpc throttle token; %s", err.Error())
+	}
+	if delay <= 0 || delay > time.Second {
+		t.Errorf("expected exhausted bucket to delay up to 1s; got %v", delay)
+	}
+
+	state := readRPCThrottleState(key)
+	if state == nil {
+		t.Fatal("expected rpc throttle state to be cached")
+	}
+	if state.Tokens >= 1 {
+		t.Errorf("expected exhausted bucket; got %v token(s)", state.Tokens)
+	}
+}
+
+func TestAcquireThrottleTokensIsAtomic(t *testing.T) {
+	testutil.NewMockRedis(t)
+	networkID, _ := uuid.NewV4()
+	key := RPCThrottleKey(networkID)
+
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	acquired := 0
+
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			delay, err := acquireThrottleTokens(key, 0.001, 10, 1)
+			if err == nil && delay == 0 {
+				mutex.Lock()
+				acquired++
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if acquired != 10 {
+		t.Errorf("expected exactly the burst of 10 tokens to be acquired concurrently; got %d", acquired)
+	}
+}
+
+func TestAwaitRPCFailsWhenRateLimitExhausted(t *testing.T) {
+	testutil.NewMockRedis(t)
+	n := &Network{}
+	n.ID, _ = uuid.NewV4()
+
+	cfg := map[string]interface{}{
+		networkConfigRPCRateLimit: 0.01,
+		networkConfigRPCBurst:     float64(1),
+	}
+
+	err := n.awaitRPC(cfg)
+	if err != nil {
+		t.Fatalf("expected first call to be permitted; %s", err.Error())
+	}
+
+	started := time.Now()
+	err = n.awaitRPC(cfg)
+	if err == nil {
+		t.Error("expected call to fail when the rate limit is exhausted")
+	}
+	if time.Since(started) >= rpcThrottleMaxWait {
+		t.Error("expected call to fail without waiting when the rate limit cannot permit it within the max wait")
+	}
+	if acquired := n.readRPCThrottleMetric(rpcThrottleMetricAcquired); acquired != 1 {
+		t.Errorf("expected only the permitted call to be recorded as acquired; got %d", acquired)
+	}
+}
+
+func TestAwaitRPCWithoutRateLimit(t *testing.T) {
+	n := &Network{}
+	n.ID, _ = uuid.NewV4()
+
+	err := n.awaitRPC(map[string]interface{}{})
+	if err != nil {
+		t.Errorf("expected call on network without rate limit to be permitted; %s", err.Error())
+	}
+}
+
+func TestRPCEndpointFailsWhenRateLimitExhausted(t *testing.T) {
+	testutil.NewMockRedis(t)
+	config := json.RawMessage(`{"json_rpc_url": "http://localhost:8545", "rpc_rate_limit": 0.01, "rpc_burst": 1}`)
+	n := &Network{Config: &config}
+	n.ID, _ = uuid.NewV4()
+
+	err := n.awaitRPC(n.ParseConfig())
+	if err != nil {
+		t.Fatalf("expected first call to be permitted; %s", err.Error())
+	}
+
+	key, url, err := n.RPCEndpoint()
+	if err == nil {
+		t.Error("expected rpc endpoint to not be resolved when the rate limit is exhausted")
+	}
+	if key != "" || url != "" {
+		t.Errorf("expected no rpc endpoint when the rate limit is exhausted; got %s", url)
+	}
+}
+
+func TestAwaitRPCCallsTakesATokenPerCall(t *testing.T) {
+	testutil.NewMockRedis(t)
+	n := &Network{}
+	n.ID, _ = uuid.NewV4()
+
+	cfg := map[string]interface{}{
+		networkConfigRPCRateLimit: 0.01,
+		networkConfigRPCBurst:     float64(3),
+	}
+
+	err := n.awaitRPCCalls(cfg, 3)
+	if err != nil {
+		t.Fatalf("expected batch of 3 calls to be permitted; %s", err.Error())
+	}
+
+	err = n.awaitRPC(cfg)
+	if err == nil {
+		t.Error("expected call to fail once a batch has exhausted the rate limit")
+	}
+}
+
+func TestPostJSONRPCRejectsBatchExceedingBurst(t *testing.T) {
+	testutil.NewMockRedis(t)
+	config := json.RawMessage(`{"json_rpc_url": "http://localhost:8545", "rpc_rate_limit": 10, "rpc_burst": 3}`)
+	n := &Network{Config: &config}
+	n.ID, _ = uuid.NewV4()
+
+	_, err := n.postJSONRPC([]byte(`[]`), 4)
+	if err == nil {
+		t.Error("expected batch exceeding the rate limit burst to be rejected")
+	}
+
+	state := readRPCThrottleState(RPCThrottleKey(n.ID))
+	if state != nil && state.Tokens < 3 {
+		t.Errorf("expected no tokens to be taken by the rejected batch; got %v token(s)", state.Tokens)
+	}
+}
@@ -0,0 +1,19 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+ALTER TABLE ONLY transactions ADD COLUMN replaces_id uuid;
+ALTER TABLE ONLY transactions ADD CONSTRAINT transactions_replaces_id_transactions_id_foreign FOREIGN KEY (replaces_id) REFERENCES public.transactions(id) ON UPDATE CASCADE ON DELETE SET NULL;
+CREATE INDEX idx_transactions_replaces_id ON public.transactions USING btree (replaces_id);
@@ -0,0 +1,63 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+CREATE TABLE public.webhooks (
+    id uuid DEFAULT public.uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    application_id uuid,
+    organization_id uuid,
+    network_id uuid,
+    url text NOT NULL,
+    secret text NOT NULL,
+    events json NOT NULL
+);
+
+ALTER TABLE public.webhooks OWNER TO current_user;
+
+ALTER TABLE ONLY public.webhooks
+    ADD CONSTRAINT webhooks_pkey PRIMARY KEY (id);
+
+CREATE INDEX idx_webhooks_application_id ON public.webhooks USING btree (application_id);
+CREATE INDEX idx_webhooks_organization_id ON public.webhooks USING btree (organization_id);
+CREATE INDEX idx_webhooks_network_id ON public.webhooks USING btree (network_id);
+
+ALTER TABLE ONLY public.webhooks
+    ADD CONSTRAINT webhooks_network_id_networks_id_foreign FOREIGN KEY (network_id) REFERENCES public.networks(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+CREATE TABLE public.webhook_deliveries (
+    id uuid DEFAULT public.uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    webhook_id uuid NOT NULL,
+    event text NOT NULL,
+    payload json NOT NULL,
+    status text DEFAULT 'pending' NOT NULL,
+    attempts integer DEFAULT 0 NOT NULL,
+    response_status integer,
+    error text,
+    next_attempt_at timestamp with time zone,
+    delivered_at timestamp with time zone
+);
+
+ALTER TABLE public.webhook_deliveries OWNER TO current_user;
+
+ALTER TABLE ONLY public.webhook_deliveries
+    ADD CONSTRAINT webhook_deliveries_pkey PRIMARY KEY (id);
+
+CREATE INDEX idx_webhook_deliveries_webhook_id ON public.webhook_deliveries USING btree (webhook_id);
+CREATE INDEX idx_webhook_deliveries_status ON public.webhook_deliveries USING btree (status);
+
+ALTER TABLE ONLY public.webhook_deliveries
+    ADD CONSTRAINT webhook_deliveries_webhook_id_webhooks_id_foreign FOREIGN KEY (webhook_id) REFERENCES public.webhooks(id) ON UPDATE CASCADE ON DELETE CASCADE;
@@ -0,0 +1,83 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+CREATE TABLE public.safe_proposals (
+    id uuid DEFAULT public.uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    application_id uuid,
+    organization_id uuid,
+    network_id uuid NOT NULL,
+    contract_id uuid,
+    address text NOT NULL,
+    "to" text NOT NULL,
+    value text DEFAULT '0' NOT NULL,
+    data text,
+    operation smallint DEFAULT 0 NOT NULL,
+    safe_tx_gas text DEFAULT '0' NOT NULL,
+    base_gas text DEFAULT '0' NOT NULL,
+    gas_price text DEFAULT '0' NOT NULL,
+    gas_token text,
+    refund_receiver text,
+    nonce bigint NOT NULL,
+    safe_tx_hash text NOT NULL,
+    threshold bigint NOT NULL,
+    status text DEFAULT 'pending' NOT NULL,
+    description text,
+    executor_account_id uuid,
+    executor_wallet_id uuid,
+    executor_path text,
+    transaction_id uuid
+);
+
+ALTER TABLE public.safe_proposals OWNER TO current_user;
+
+ALTER TABLE ONLY public.safe_proposals
+    ADD CONSTRAINT safe_proposals_pkey PRIMARY KEY (id);
+
+CREATE INDEX idx_safe_proposals_application_id ON public.safe_proposals USING btree (application_id);
+CREATE INDEX idx_safe_proposals_organization_id ON public.safe_proposals USING btree (organization_id);
+CREATE INDEX idx_safe_proposals_network_id_address ON public.safe_proposals USING btree (network_id, address);
+CREATE INDEX idx_safe_proposals_status ON public.safe_proposals USING btree (status);
+
+ALTER TABLE ONLY public.safe_proposals
+    ADD CONSTRAINT safe_proposals_network_id_networks_id_foreign FOREIGN KEY (network_id) REFERENCES public.networks(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+ALTER TABLE ONLY public.safe_proposals
+    ADD CONSTRAINT safe_proposals_contract_id_contracts_id_foreign FOREIGN KEY (contract_id) REFERENCES public.contracts(id) ON UPDATE CASCADE ON DELETE SET NULL;
+
+ALTER TABLE ONLY public.safe_proposals
+    ADD CONSTRAINT safe_proposals_transaction_id_transactions_id_foreign FOREIGN KEY (transaction_id) REFERENCES public.transactions(id) ON UPDATE CASCADE ON DELETE SET NULL;
+
+CREATE TABLE public.safe_confirmations (
+    id uuid DEFAULT public.uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    proposal_id uuid NOT NULL,
+    owner text NOT NULL,
+    signature text NOT NULL,
+    account_id uuid,
+    wallet_id uuid,
+    hd_derivation_path text
+);
+
+ALTER TABLE public.safe_confirmations OWNER TO current_user;
+
+ALTER TABLE ONLY public.safe_confirmations
+    ADD CONSTRAINT safe_confirmations_pkey PRIMARY KEY (id);
+
+CREATE UNIQUE INDEX idx_safe_confirmations_proposal_id_owner ON public.safe_confirmations USING btree (proposal_id, owner);
+
+ALTER TABLE ONLY public.safe_confirmations
+    ADD CONSTRAINT safe_confirmations_proposal_id_safe_proposals_id_foreign FOREIGN KEY (proposal_id) REFERENCES public.safe_proposals(id) ON UPDATE CASCADE ON DELETE CASCADE;
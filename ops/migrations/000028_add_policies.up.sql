@@ -0,0 +1,78 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+CREATE TABLE public.policies (
+    id uuid DEFAULT public.uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    application_id uuid,
+    organization_id uuid,
+    account_id uuid,
+    wallet_id uuid,
+    network_id uuid,
+    name text NOT NULL,
+    description text,
+    enabled boolean DEFAULT true NOT NULL,
+    rules json NOT NULL
+);
+
+ALTER TABLE public.policies OWNER TO current_user;
+
+ALTER TABLE ONLY public.policies
+    ADD CONSTRAINT policies_pkey PRIMARY KEY (id);
+
+CREATE INDEX idx_policies_application_id ON public.policies USING btree (application_id);
+CREATE INDEX idx_policies_organization_id ON public.policies USING btree (organization_id);
+CREATE INDEX idx_policies_account_id ON public.policies USING btree (account_id);
+CREATE INDEX idx_policies_wallet_id ON public.policies USING btree (wallet_id);
+
+ALTER TABLE ONLY public.policies
+    ADD CONSTRAINT policies_account_id_accounts_id_foreign FOREIGN KEY (account_id) REFERENCES public.accounts(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+ALTER TABLE ONLY public.policies
+    ADD CONSTRAINT policies_wallet_id_wallets_id_foreign FOREIGN KEY (wallet_id) REFERENCES public.wallets(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+ALTER TABLE ONLY public.policies
+    ADD CONSTRAINT policies_network_id_networks_id_foreign FOREIGN KEY (network_id) REFERENCES public.networks(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+CREATE TABLE public.policy_decisions (
+    id uuid DEFAULT public.uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    policy_id uuid NOT NULL,
+    application_id uuid,
+    organization_id uuid,
+    account_id uuid,
+    wallet_id uuid,
+    network_id uuid NOT NULL,
+    "to" text,
+    value numeric DEFAULT 0 NOT NULL,
+    selector text,
+    recipient text,
+    token text,
+    token_amount numeric,
+    allowed boolean NOT NULL,
+    rule text,
+    reason text
+);
+
+ALTER TABLE public.policy_decisions OWNER TO current_user;
+
+ALTER TABLE ONLY public.policy_decisions
+    ADD CONSTRAINT policy_decisions_pkey PRIMARY KEY (id);
+
+CREATE INDEX idx_policy_decisions_policy_id_created_at ON public.policy_decisions USING btree (policy_id, created_at);
+
+ALTER TABLE ONLY public.policy_decisions
+    ADD CONSTRAINT policy_decisions_policy_id_policies_id_foreign FOREIGN KEY (policy_id) REFERENCES public.policies(id) ON UPDATE CASCADE ON DELETE CASCADE;
@@ -0,0 +1,74 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+CREATE TABLE public.approval_rules (
+    id uuid DEFAULT public.uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    application_id uuid,
+    organization_id uuid,
+    account_id uuid,
+    wallet_id uuid,
+    network_id uuid,
+    name text NOT NULL,
+    description text,
+    enabled boolean DEFAULT true NOT NULL,
+    criteria json NOT NULL,
+    approvers json NOT NULL,
+    required_approvals integer DEFAULT 1 NOT NULL
+);
+
+ALTER TABLE public.approval_rules OWNER TO current_user;
+
+ALTER TABLE ONLY public.approval_rules
+    ADD CONSTRAINT approval_rules_pkey PRIMARY KEY (id);
+
+CREATE INDEX idx_approval_rules_application_id ON public.approval_rules USING btree (application_id);
+CREATE INDEX idx_approval_rules_organization_id ON public.approval_rules USING btree (organization_id);
+CREATE INDEX idx_approval_rules_account_id ON public.approval_rules USING btree (account_id);
+CREATE INDEX idx_approval_rules_wallet_id ON public.approval_rules USING btree (wallet_id);
+
+ALTER TABLE ONLY public.approval_rules
+    ADD CONSTRAINT approval_rules_account_id_accounts_id_foreign FOREIGN KEY (account_id) REFERENCES public.accounts(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+ALTER TABLE ONLY public.approval_rules
+    ADD CONSTRAINT approval_rules_wallet_id_wallets_id_foreign FOREIGN KEY (wallet_id) REFERENCES public.wallets(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+ALTER TABLE ONLY public.approval_rules
+    ADD CONSTRAINT approval_rules_network_id_networks_id_foreign FOREIGN KEY (network_id) REFERENCES public.networks(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+ALTER TABLE ONLY public.transactions ADD COLUMN approval_rule_id uuid;
+ALTER TABLE ONLY public.transactions ADD COLUMN pending_params json;
+
+CREATE INDEX idx_transactions_approval_rule_id ON public.transactions USING btree (approval_rule_id);
+
+CREATE TABLE public.tx_approvals (
+    id uuid DEFAULT public.uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    transaction_id uuid NOT NULL,
+    user_id uuid NOT NULL,
+    approved boolean NOT NULL,
+    reason text
+);
+
+ALTER TABLE public.tx_approvals OWNER TO current_user;
+
+ALTER TABLE ONLY public.tx_approvals
+    ADD CONSTRAINT tx_approvals_pkey PRIMARY KEY (id);
+
+CREATE UNIQUE INDEX idx_tx_approvals_transaction_id_user_id ON public.tx_approvals USING btree (transaction_id, user_id);
+
+ALTER TABLE ONLY public.tx_approvals
+    ADD CONSTRAINT tx_approvals_transaction_id_transactions_id_foreign FOREIGN KEY (transaction_id) REFERENCES public.transactions(id) ON UPDATE CASCADE ON DELETE CASCADE;
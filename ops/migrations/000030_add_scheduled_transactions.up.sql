@@ -0,0 +1,53 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+CREATE TABLE public.scheduled_transactions (
+    id uuid DEFAULT public.uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    application_id uuid,
+    organization_id uuid,
+    user_id uuid,
+    network_id uuid NOT NULL,
+    contract_id uuid,
+    kind text NOT NULL,
+    payload json NOT NULL,
+    description text,
+    run_at timestamp with time zone,
+    cron text,
+    block bigint,
+    status text DEFAULT 'scheduled' NOT NULL,
+    next_run_at timestamp with time zone,
+    last_run_at timestamp with time zone,
+    runs bigint DEFAULT 0 NOT NULL,
+    error text
+);
+
+ALTER TABLE public.scheduled_transactions OWNER TO current_user;
+
+ALTER TABLE ONLY public.scheduled_transactions
+    ADD CONSTRAINT scheduled_transactions_pkey PRIMARY KEY (id);
+
+CREATE INDEX idx_scheduled_transactions_application_id ON public.scheduled_transactions USING btree (application_id);
+CREATE INDEX idx_scheduled_transactions_organization_id ON public.scheduled_transactions USING btree (organization_id);
+CREATE INDEX idx_scheduled_transactions_user_id ON public.scheduled_transactions USING btree (user_id);
+CREATE INDEX idx_scheduled_transactions_status_next_run_at ON public.scheduled_transactions USING btree (status, next_run_at);
+CREATE INDEX idx_scheduled_transactions_status_network_id_block ON public.scheduled_transactions USING btree (status, network_id, block);
+
+ALTER TABLE ONLY public.scheduled_transactions
+    ADD CONSTRAINT scheduled_transactions_network_id_networks_id_foreign FOREIGN KEY (network_id) REFERENCES public.networks(id) ON UPDATE CASCADE ON DELETE CASCADE;
+
+ALTER TABLE ONLY public.scheduled_transactions
+    ADD CONSTRAINT scheduled_transactions_contract_id_contracts_id_foreign FOREIGN KEY (contract_id) REFERENCES public.contracts(id) ON UPDATE CASCADE ON DELETE CASCADE;
@@ -0,0 +1,46 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+CREATE TABLE public.tx_batches (
+    id uuid DEFAULT public.uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    application_id uuid,
+    organization_id uuid,
+    user_id uuid,
+    contract_id uuid,
+    multicall boolean DEFAULT false NOT NULL,
+    size integer NOT NULL,
+    transaction_ids json NOT NULL
+);
+
+ALTER TABLE public.tx_batches OWNER TO current_user;
+
+ALTER TABLE ONLY public.tx_batches
+    ADD CONSTRAINT tx_batches_pkey PRIMARY KEY (id);
+
+CREATE INDEX idx_tx_batches_application_id ON public.tx_batches USING btree (application_id);
+CREATE INDEX idx_tx_batches_organization_id ON public.tx_batches USING btree (organization_id);
+CREATE INDEX idx_tx_batches_user_id ON public.tx_batches USING btree (user_id);
+
+ALTER TABLE ONLY public.tx_batches
+    ADD CONSTRAINT tx_batches_contract_id_contracts_id_foreign FOREIGN KEY (contract_id) REFERENCES public.contracts(id) ON UPDATE CASCADE ON DELETE SET NULL;
+
+ALTER TABLE ONLY public.transactions ADD COLUMN batch_id uuid;
+
+CREATE INDEX idx_transactions_batch_id ON public.transactions USING btree (batch_id);
+
+ALTER TABLE ONLY public.transactions
+    ADD CONSTRAINT transactions_batch_id_tx_batches_id_foreign FOREIGN KEY (batch_id) REFERENCES public.tx_batches(id) ON UPDATE CASCADE ON DELETE SET NULL;
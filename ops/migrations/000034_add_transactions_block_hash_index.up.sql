@@ -0,0 +1,19 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+-- txs included in blocks orphaned by a reorg are rolled back by block hash; the index is built
+-- concurrently, which requires this migration to contain a single statement
+CREATE INDEX CONCURRENTLY IF NOT EXISTS idx_transactions_block_hash ON public.transactions USING btree (block_hash);
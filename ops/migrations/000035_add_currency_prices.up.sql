@@ -0,0 +1,32 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+-- observed prices of each currency pair; the fiat fees of exported txs are computed using the price
+-- observed at the block timestamp of each tx
+CREATE TABLE public.currency_prices (
+    id uuid DEFAULT public.uuid_generate_v4() NOT NULL,
+    created_at timestamp with time zone DEFAULT now() NOT NULL,
+    currency_pair varchar(16) NOT NULL,
+    price numeric NOT NULL,
+    observed_at timestamp with time zone NOT NULL
+);
+
+ALTER TABLE public.currency_prices OWNER TO current_user;
+
+ALTER TABLE ONLY public.currency_prices
+    ADD CONSTRAINT currency_prices_pkey PRIMARY KEY (id);
+
+CREATE INDEX idx_currency_prices_currency_pair_observed_at ON public.currency_prices USING btree (currency_pair, observed_at);
@@ -0,0 +1,27 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+-- the substring search indexes of txs use pg_trgm. Creating an extension requires superuser
+-- privileges prior to PostgreSQL 13, and the CREATE privilege on the database thereafter, so
+-- pg_trgm may need to be created by a superuser (i.e., CREATE EXTENSION pg_trgm) before migrating
+DO $$
+BEGIN
+    CREATE EXTENSION IF NOT EXISTS pg_trgm;
+EXCEPTION
+    WHEN insufficient_privilege THEN
+        RAISE EXCEPTION 'the pg_trgm extension must be created by a superuser before migrating: CREATE EXTENSION pg_trgm;';
+END
+$$;
@@ -0,0 +1,332 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+// ApprovalRule instances hold txs which match the rule criteria in the awaiting_approval status until
+// they are approved by the required number of approvers; as with policies, an approval rule which is
+// not associated with an account or HD wallet applies to every account and HD wallet of the application
+// or organization.
+type ApprovalRule struct {
+	provide.Model
+	ApplicationID     *uuid.UUID       `sql:"type:uuid" json:"application_id,omitempty"`
+	OrganizationID    *uuid.UUID       `sql:"type:uuid" json:"organization_id,omitempty"`
+	AccountID         *uuid.UUID       `sql:"type:uuid" json:"account_id,omitempty"`
+	WalletID          *uuid.UUID       `sql:"type:uuid" json:"wallet_id,omitempty"`
+	NetworkID         *uuid.UUID       `sql:"type:uuid" json:"network_id,omitempty"` // the rule applies to all networks if nil
+	Name              *string          `sql:"not null" json:"name"`
+	Description       *string          `json:"description,omitempty"`
+	Enabled           bool             `sql:"not null" json:"enabled"`
+	Criteria          *json.RawMessage `sql:"type:json;not null" json:"criteria"`
+	Approvers         *json.RawMessage `sql:"type:json;not null" json:"approvers"` // user ids
+	RequiredApprovals int              `sql:"not null" json:"required_approvals"`
+}
+
+// ApprovalCriteria of an approval rule; a tx requires approval when it matches every given criterion
+type ApprovalCriteria struct {
+	ValueThreshold *string  `json:"value_threshold,omitempty"` // native value, in wei, above which txs require approval
+	Contracts      []string `json:"contracts,omitempty"`       // contracts called by txs which require approval
+	Methods        []string `json:"methods,omitempty"`         // method selectors or signatures called by txs which require approval
+}
+
+// TableName returns the table name of approval rules
+func (ApprovalRule) TableName() string {
+	return "approval_rules"
+}
+
+// MatchApprovalRule returns the first enabled approval rule, ordered by id, which the given intent
+// matches, or nil if the intent does not require approval; an error is returned if the approval
+// rules cannot be resolved, in which case the intent must not be signed. A rule whose criteria
+// cannot be parsed matches every intent, so a malformed rule never allows a tx to skip approval.
+func MatchApprovalRule(db *gorm.DB, intent *Intent) (*ApprovalRule, error) {
+	if intent.Value == nil {
+		intent.Value = big.NewInt(0)
+	}
+
+	rules, err := applicableApprovalRules(db, intent)
+	if err != nil {
+		return nil, err
+	}
+
+	c := decodeCall(intent)
+	for _, rule := range rules {
+		criteria, err := rule.ParseCriteria()
+		if err != nil {
+			common.Log.Warningf("failed to parse criteria of approval rule %s; intent requires approval; %s", rule.ID, err.Error())
+			return rule, nil
+		}
+		if criteria.matches(intent, c) {
+			return rule, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// Create and persist a new approval rule
+func (r *ApprovalRule) Create() bool {
+	if !r.Validate() {
+		return false
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	if db.NewRecord(r) {
+		result := db.Create(&r)
+		rowsAffected := result.RowsAffected
+		errors := result.GetErrors()
+		if len(errors) > 0 {
+			for _, err := range errors {
+				r.Errors = append(r.Errors, &provide.Error{
+					Message: common.StringOrNil(err.Error()),
+				})
+			}
+		}
+		if !db.NewRecord(r) {
+			return rowsAffected > 0
+		}
+	}
+	return false
+}
+
+// Update an existing approval rule; txs already awaiting approval are subject to the updated approvers
+func (r *ApprovalRule) Update() bool {
+	if !r.Validate() {
+		return false
+	}
+
+	db := dbconf.DatabaseConnection()
+	result := db.Save(&r)
+	errors := result.GetErrors()
+	if len(errors) > 0 {
+		for _, err := range errors {
+			r.Errors = append(r.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+		}
+	}
+	return len(r.Errors) == 0
+}
+
+// Delete an approval rule
+func (r *ApprovalRule) Delete() bool {
+	db := dbconf.DatabaseConnection()
+	result := db.Delete(r)
+	errors := result.GetErrors()
+	if len(errors) > 0 {
+		for _, err := range errors {
+			r.Errors = append(r.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+		}
+	}
+	return len(r.Errors) == 0
+}
+
+// Validate an approval rule for persistence
+func (r *ApprovalRule) Validate() bool {
+	r.Errors = make([]*provide.Error, 0)
+
+	if r.ApplicationID == nil && r.OrganizationID == nil {
+		r.Errors = append(r.Errors, &provide.Error{
+			Message: common.StringOrNil("approval rule must be associated with an application or organization"),
+		})
+	}
+
+	if r.AccountID != nil && r.WalletID != nil {
+		r.Errors = append(r.Errors, &provide.Error{
+			Message: common.StringOrNil("only an account OR HD wallet identifier should be provided"),
+		})
+	}
+
+	if r.Name == nil || *r.Name == "" {
+		r.Errors = append(r.Errors, &provide.Error{
+			Message: common.StringOrNil("approval rule name required"),
+		})
+	}
+
+	criteria, err := r.ParseCriteria()
+	if err != nil {
+		r.Errors = append(r.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+	} else if err := criteria.validate(); err != nil {
+		r.Errors = append(r.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+	}
+
+	approvers, err := r.ParseApprovers()
+	if err != nil {
+		r.Errors = append(r.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+	} else if len(approvers) == 0 {
+		r.Errors = append(r.Errors, &provide.Error{
+			Message: common.StringOrNil("at least one approver required"),
+		})
+	} else if r.RequiredApprovals < 1 || r.RequiredApprovals > len(approvers) {
+		r.Errors = append(r.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("required approvals must be between 1 and the number of approvers (%d)", len(approvers))),
+		})
+	}
+
+	return len(r.Errors) == 0
+}
+
+// ParseCriteria returns the criteria of the approval rule
+func (r *ApprovalRule) ParseCriteria() (*ApprovalCriteria, error) {
+	if r.Criteria == nil {
+		return nil, fmt.Errorf("approval rule criteria required")
+	}
+
+	criteria := &ApprovalCriteria{}
+	err := json.Unmarshal(*r.Criteria, &criteria)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse approval rule criteria; %s", err.Error())
+	}
+	return criteria, nil
+}
+
+// ParseApprovers returns the user ids of the approvers of the approval rule
+func (r *ApprovalRule) ParseApprovers() ([]uuid.UUID, error) {
+	if r.Approvers == nil {
+		return nil, fmt.Errorf("approval rule approvers required")
+	}
+
+	var ids []string
+	err := json.Unmarshal(*r.Approvers, &ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse approval rule approvers; %s", err.Error())
+	}
+
+	approvers := make([]uuid.UUID, 0)
+	for _, id := range ids {
+		approver, err := uuid.FromString(id)
+		if err != nil {
+			return nil, fmt.Errorf("invalid approver user id: %s", id)
+		}
+		approvers = append(approvers, approver)
+	}
+	return approvers, nil
+}
+
+// IsApprover returns true if the given user is an approver of the approval rule
+func (r *ApprovalRule) IsApprover(userID uuid.UUID) bool {
+	approvers, err := r.ParseApprovers()
+	if err != nil {
+		return false
+	}
+
+	for _, approver := range approvers {
+		if approver == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// validate the criteria
+func (c *ApprovalCriteria) validate() error {
+	if c.ValueThreshold == nil && len(c.Contracts) == 0 && len(c.Methods) == 0 {
+		return fmt.Errorf("at least one approval rule criterion required")
+	}
+
+	if _, err := parseAmount(c.ValueThreshold); err != nil {
+		return err
+	}
+
+	for _, addr := range c.Contracts {
+		if !ethcommon.IsHexAddress(addr) {
+			return fmt.Errorf("invalid address: %s", addr)
+		}
+	}
+
+	for _, method := range c.Methods {
+		if _, err := common.ParseMethodSelector(method); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// matches returns true if the given intent matches every criterion
+func (c *ApprovalCriteria) matches(intent *Intent, call *call) bool {
+	if c.ValueThreshold != nil {
+		threshold, _ := parseAmount(c.ValueThreshold)
+		if intent.Value.Cmp(threshold) <= 0 {
+			return false
+		}
+	}
+
+	if len(c.Contracts) > 0 {
+		if call.selector == nil || intent.To == nil || !containsAddress(c.Contracts, *intent.To) {
+			return false
+		}
+	}
+
+	if len(c.Methods) > 0 && !containsSelector(c.Methods, call.selector) {
+		return false
+	}
+
+	return true
+}
+
+// applicableApprovalRules returns the enabled approval rules which apply to the given intent, ordered by id
+func applicableApprovalRules(db *gorm.DB, intent *Intent) ([]*ApprovalRule, error) {
+	query := db.Where("enabled = ?", true)
+	query = query.Where("network_id IS NULL OR network_id = ?", intent.NetworkID)
+
+	if intent.ApplicationID != nil {
+		query = query.Where("application_id = ?", intent.ApplicationID)
+	} else if intent.OrganizationID != nil {
+		query = query.Where("organization_id = ?", intent.OrganizationID)
+	} else {
+		return nil, nil
+	}
+
+	scope := "(account_id IS NULL AND wallet_id IS NULL)"
+	args := make([]interface{}, 0)
+	if intent.AccountID != nil {
+		scope = fmt.Sprintf("%s OR account_id = ?", scope)
+		args = append(args, intent.AccountID)
+	}
+	if intent.WalletID != nil {
+		scope = fmt.Sprintf("%s OR wallet_id = ?", scope)
+		args = append(args, intent.WalletID)
+	}
+	query = query.Where(scope, args...)
+
+	var rules []*ApprovalRule
+	err := query.Order("id ASC").Find(&rules).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve applicable approval rules; %s", err.Error())
+	}
+	return rules, nil
+}
@@ -0,0 +1,92 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package policy
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/internal/testutil"
+)
+
+func expectApprovalRules(mock sqlmock.Sqlmock, criteria ...string) []uuid.UUID {
+	rows := sqlmock.NewRows([]string{"id", "enabled", "criteria", "approvers", "required_approvals"})
+	ids := make([]uuid.UUID, 0)
+	for _, c := range criteria {
+		id, _ := uuid.NewV4()
+		ids = append(ids, id)
+		rows.AddRow(id, true, []byte(c), []byte(`[]`), 1)
+	}
+	mock.ExpectQuery(`SELECT \* FROM "approval_rules" WHERE \(enabled = \$1\)`).WillReturnRows(rows)
+	return ids
+}
+
+func TestMatchApprovalRuleReturnsResolutionError(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	mock.ExpectQuery(`SELECT \* FROM "approval_rules"`).WillReturnError(errors.New("connection refused"))
+
+	rule, err := MatchApprovalRule(db, testIntent())
+	if err == nil || rule != nil {
+		t.Error("expected approval rule resolution failure to be returned")
+	}
+}
+
+func TestMatchApprovalRule(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	ids := expectApprovalRules(mock, `{"value_threshold": "1000"}`, `{"value_threshold": "50"}`)
+
+	rule, err := MatchApprovalRule(db, testIntent())
+	if err != nil {
+		t.Fatalf("failed to match approval rule; %s", err.Error())
+	}
+	if rule == nil || rule.ID != ids[1] {
+		t.Errorf("expected intent to match approval rule %s", ids[1])
+	}
+}
+
+func TestMatchApprovalRuleWithoutMatch(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	expectApprovalRules(mock, `{"value_threshold": "1000"}`)
+
+	intent := testIntent()
+	intent.Value = big.NewInt(1000)
+	rule, err := MatchApprovalRule(db, intent)
+	if err != nil || rule != nil {
+		t.Errorf("expected intent at the value threshold to not require approval; got %v; %v", rule, err)
+	}
+}
+
+func TestMatchApprovalRuleWithMalformedCriteria(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	ids := expectApprovalRules(mock, `{"value_threshold": 1000}`)
+
+	intent := testIntent()
+	intent.Value = big.NewInt(0)
+	rule, err := MatchApprovalRule(db, intent)
+	if err != nil {
+		t.Fatalf("failed to match approval rule; %s", err.Error())
+	}
+	if rule == nil || rule.ID != ids[0] {
+		t.Error("expected intent to require approval by the approval rule whose criteria cannot be parsed")
+	}
+}
@@ -0,0 +1,449 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package policy
+
+import (
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+)
+
+const erc20TransferSelector = "0xa9059cbb"     // transfer(address,uint256)
+const erc20TransferFromSelector = "0x23b872dd" // transferFrom(address,address,uint256)
+
+// decisionWindow is the trailing window over which daily caps are enforced
+const decisionWindow = "24 hours"
+
+// Intent describes a tx which nchain has been asked to sign using an account or HD wallet
+type Intent struct {
+	TransactionID  *uuid.UUID // the tx for which the decisions are recorded, if any
+	ApplicationID  *uuid.UUID
+	OrganizationID *uuid.UUID
+	AccountID      *uuid.UUID
+	WalletID       *uuid.UUID
+	NetworkID      uuid.UUID
+	To             *string
+	Value          *big.Int
+	Data           *string
+}
+
+// Violation is returned when a tx is rejected by a policy; it names the rule which failed
+type Violation struct {
+	PolicyID uuid.UUID
+	Rule     string
+	Reason   string
+}
+
+// Error implements the error interface
+func (v *Violation) Error() string {
+	return fmt.Sprintf("tx rejected by policy %s; rule %s failed; %s", v.PolicyID, v.Rule, v.Reason)
+}
+
+// pendingSpend is the native value and ERC-20 token amounts, keyed by policy, which were allowed for
+// the intents evaluated earlier as part of the same unit; it counts towards the daily caps of later
+// intents, whether or not the decisions are recorded
+type pendingSpend map[string]*big.Int
+
+// key returns the key of the spend of the given policy, optionally of the given ERC-20 token
+func (s pendingSpend) key(policyID uuid.UUID, token *string) string {
+	if token == nil {
+		return policyID.String()
+	}
+	return fmt.Sprintf("%s:%s", policyID, strings.ToLower(*token))
+}
+
+// get returns the pending spend of the given policy, optionally of the given ERC-20 token
+func (s pendingSpend) get(policyID uuid.UUID, token *string) *big.Int {
+	if amount, ok := s[s.key(policyID, token)]; ok {
+		return amount
+	}
+	return big.NewInt(0)
+}
+
+// add the given amount to the pending spend of the given policy, optionally of the given ERC-20 token
+func (s pendingSpend) add(policyID uuid.UUID, token *string, amount *big.Int) {
+	if amount == nil || amount.Sign() <= 0 {
+		return
+	}
+	s[s.key(policyID, token)] = new(big.Int).Add(s.get(policyID, token), amount)
+}
+
+// call is the decoded calldata of an intent
+type call struct {
+	selector    *string
+	recipient   *string  // recipient of native value or of the decoded ERC-20 transfer, if any
+	token       *string  // ERC-20 token, if the calldata is a transfer or transferFrom
+	tokenAmount *big.Int // amount of the decoded ERC-20 transfer, if any
+}
+
+// decodeCall decodes the recipient, method selector and ERC-20 transfer, if any, of the given intent
+func decodeCall(intent *Intent) *call {
+	c := &call{}
+
+	var data []byte
+	if intent.Data != nil {
+		data = ethcommon.FromHex(*intent.Data)
+	}
+
+	if len(data) == 0 {
+		c.recipient = intent.To
+		return c
+	}
+
+	if len(data) >= 4 {
+		selector := fmt.Sprintf("0x%x", data[0:4])
+		c.selector = &selector
+
+		if selector == erc20TransferSelector && len(data) == 4+32*2 {
+			recipient := ethcommon.BytesToAddress(data[4:36]).Hex()
+			c.recipient = &recipient
+			c.tokenAmount = new(big.Int).SetBytes(data[36:68])
+			c.token = intent.To
+		} else if selector == erc20TransferFromSelector && len(data) == 4+32*3 {
+			recipient := ethcommon.BytesToAddress(data[36:68]).Hex()
+			c.recipient = &recipient
+			c.tokenAmount = new(big.Int).SetBytes(data[68:100])
+			c.token = intent.To
+		}
+	}
+
+	if c.recipient == nil && intent.Value != nil && intent.Value.Sign() > 0 {
+		c.recipient = intent.To
+	}
+
+	return c
+}
+
+// Evaluate evaluates the policies which apply to the given intent, and records each decision in
+// the audit log unless dryRun is true; a *Violation is returned if any policy rejects the intent,
+// and an error is returned if the policies cannot be evaluated, in which case the intent is rejected
+func Evaluate(db *gorm.DB, intent *Intent, dryRun bool) error {
+	return EvaluateAll(db, []*Intent{intent}, dryRun)
+}
+
+// EvaluateAll evaluates the policies which apply to each of the given intents, i.e., the calls
+// of a single multicall tx or the txs of a batch, as one unit; the spend allowed for earlier intents
+// counts towards the daily caps of later intents, even when dryRun is true, and if any intent is rejected only the rejecting decision is recorded;
+// the decisions allowing the intents of a tx are recorded once, so a redelivered tx is not counted twice
+func EvaluateAll(db *gorm.DB, intents []*Intent, dryRun bool) error {
+	applicable := make([][]*Policy, len(intents))
+	policyIDs := make([]string, 0)
+	resolved := map[string]bool{}
+	for i, intent := range intents {
+		if intent.Value == nil {
+			intent.Value = big.NewInt(0)
+		}
+
+		policies, err := applicablePolicies(db, intent)
+		if err != nil {
+			return err
+		}
+		applicable[i] = policies
+
+		for _, policy := range policies {
+			if !resolved[policy.ID.String()] {
+				resolved[policy.ID.String()] = true
+				policyIDs = append(policyIDs, policy.ID.String())
+			}
+		}
+	}
+	if len(policyIDs) == 0 {
+		return nil
+	}
+
+	dbtx := db.Begin()
+	committed := false
+	defer func() {
+		if !committed {
+			dbtx.Rollback()
+		}
+	}()
+
+	if !dryRun {
+		// serialize evaluation of each policy so daily caps cannot be exceeded by concurrent txs;
+		// the locks are always acquired in the order of the policy ids
+		sort.Strings(policyIDs)
+		for _, policyID := range policyIDs {
+			err := dbtx.Exec("SELECT pg_advisory_xact_lock(hashtext(?))", policyID).Error
+			if err != nil {
+				return fmt.Errorf("failed to evaluate policy %s; %s", policyID, err.Error())
+			}
+		}
+
+		recorded, err := decisionsRecorded(dbtx, intents)
+		if err != nil {
+			return fmt.Errorf("failed to resolve recorded policy decisions; %s", err.Error())
+		}
+		if recorded {
+			common.Log.Debugf("policy decisions already recorded for tx %s", intents[0].TransactionID)
+			return nil
+		}
+	}
+
+	pending := pendingSpend{}
+	decisions := make([]*Decision, 0)
+	for i, intent := range intents {
+		c := decodeCall(intent)
+
+		for _, policy := range applicable[i] {
+			violation, err := policy.evaluate(dbtx, intent, c, pending)
+			if err != nil {
+				return fmt.Errorf("failed to evaluate policy %s; %s", policy.ID, err.Error())
+			}
+
+			decision := policy.decision(intent, c, violation)
+			if violation != nil {
+				// the decisions allowing earlier intents are discarded
+				dbtx.Rollback()
+				committed = true
+				return decision.reject(db, dryRun)
+			}
+			decisions = append(decisions, decision)
+		}
+
+		for _, policy := range applicable[i] {
+			pending.add(policy.ID, nil, intent.Value)
+			if c.token != nil {
+				pending.add(policy.ID, c.token, c.tokenAmount)
+			}
+		}
+	}
+
+	for _, decision := range decisions {
+		decision.log(dryRun)
+		if !dryRun {
+			err := dbtx.Create(&decision).Error
+			if err != nil {
+				return fmt.Errorf("failed to record decision of policy %s; %s", decision.PolicyID, err.Error())
+			}
+		}
+	}
+
+	if !dryRun {
+		err := dbtx.Commit().Error
+		if err != nil {
+			return fmt.Errorf("failed to record policy decisions; %s", err.Error())
+		}
+		committed = true
+	}
+
+	return nil
+}
+
+// decisionsRecorded returns true if decisions allowing the tx of the given intents have been recorded
+func decisionsRecorded(db *gorm.DB, intents []*Intent) (bool, error) {
+	if len(intents) == 0 || intents[0].TransactionID == nil {
+		return false, nil
+	}
+
+	var count int
+	err := db.Raw("SELECT COUNT(*) FROM policy_decisions WHERE transaction_id = ? AND allowed = true", intents[0].TransactionID).Row().Scan(&count)
+	if err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// reject records the rejecting decision unless dryRun is true, and returns its violation
+func (d *Decision) reject(db *gorm.DB, dryRun bool) error {
+	d.log(dryRun)
+	if !dryRun {
+		err := db.Create(&d).Error
+		if err != nil {
+			return fmt.Errorf("failed to record decision of policy %s; %s", d.PolicyID, err.Error())
+		}
+	}
+
+	return &Violation{
+		PolicyID: d.PolicyID,
+		Rule:     *d.Rule,
+		Reason:   *d.Reason,
+	}
+}
+
+// evaluate the rules of the policy against the given intent and its decoded calldata; the given
+// pending spend of earlier intents counts towards the daily caps
+func (p *Policy) evaluate(db *gorm.DB, intent *Intent, c *call, pending pendingSpend) (*Violation, error) {
+	rules, err := p.ParseRules()
+	if err != nil {
+		return p.violation("rules", err.Error()), nil
+	}
+
+	if max, _ := parseAmount(rules.MaxValuePerTx); max != nil && intent.Value.Cmp(max) > 0 {
+		return p.violation("max_value_per_tx", fmt.Sprintf("value %s exceeds %s per tx", intent.Value, max)), nil
+	}
+
+	if max, _ := parseAmount(rules.MaxValuePerDay); max != nil && intent.Value.Sign() > 0 {
+		spent, err := p.spent(db, "value", nil)
+		if err != nil {
+			return nil, err
+		}
+		spent.Add(spent, pending.get(p.ID, nil))
+		if total := new(big.Int).Add(spent, intent.Value); total.Cmp(max) > 0 {
+			return p.violation("max_value_per_day", fmt.Sprintf("value %s exceeds the remaining %s of %s per day", intent.Value, new(big.Int).Sub(max, spent), max)), nil
+		}
+	}
+
+	if c.recipient != nil {
+		if len(rules.RecipientAllowlist) > 0 && !containsAddress(rules.RecipientAllowlist, *c.recipient) {
+			return p.violation("recipient_allowlist", fmt.Sprintf("recipient %s is not allowed", *c.recipient)), nil
+		}
+		if containsAddress(rules.RecipientDenylist, *c.recipient) {
+			return p.violation("recipient_denylist", fmt.Sprintf("recipient %s is denied", *c.recipient)), nil
+		}
+	}
+
+	if intent.Data != nil && len(ethcommon.FromHex(*intent.Data)) > 0 {
+		if intent.To == nil {
+			if len(rules.ContractAllowlist) > 0 {
+				return p.violation("contract_allowlist", "contract deployment is not allowed"), nil
+			}
+		} else {
+			if len(rules.ContractAllowlist) > 0 && !containsAddress(rules.ContractAllowlist, *intent.To) {
+				return p.violation("contract_allowlist", fmt.Sprintf("contract %s is not allowed", *intent.To)), nil
+			}
+			if containsAddress(rules.ContractDenylist, *intent.To) {
+				return p.violation("contract_denylist", fmt.Sprintf("contract %s is denied", *intent.To)), nil
+			}
+			if len(rules.MethodAllowlist) > 0 && !p.allowsMethod(rules, c.selector) {
+				selector := "(none)"
+				if c.selector != nil {
+					selector = *c.selector
+				}
+				return p.violation("method_allowlist", fmt.Sprintf("method %s is not allowed", selector)), nil
+			}
+		}
+	}
+
+	if c.token != nil {
+		for _, limit := range rules.TokenLimits {
+			if !strings.EqualFold(limit.Token, *c.token) {
+				continue
+			}
+
+			rule := fmt.Sprintf("token_limits[%s]", limit.Token)
+			if max, _ := parseAmount(limit.MaxAmountPerTx); max != nil && c.tokenAmount.Cmp(max) > 0 {
+				return p.violation(fmt.Sprintf("%s.max_amount_per_tx", rule), fmt.Sprintf("transfer of %s exceeds %s per tx", c.tokenAmount, max)), nil
+			}
+
+			if max, _ := parseAmount(limit.MaxAmountPerDay); max != nil {
+				spent, err := p.spent(db, "token_amount", c.token)
+				if err != nil {
+					return nil, err
+				}
+				spent.Add(spent, pending.get(p.ID, c.token))
+				if total := new(big.Int).Add(spent, c.tokenAmount); total.Cmp(max) > 0 {
+					return p.violation(fmt.Sprintf("%s.max_amount_per_day", rule), fmt.Sprintf("transfer of %s exceeds the remaining %s of %s per day", c.tokenAmount, new(big.Int).Sub(max, spent), max)), nil
+				}
+			}
+		}
+	}
+
+	return nil, nil
+}
+
+// allowsMethod returns true if the given selector is in the method allowlist
+func (p *Policy) allowsMethod(rules *Rules, selector *string) bool {
+	return containsSelector(rules.MethodAllowlist, selector)
+}
+
+// spent returns the sum of the given column of the decisions which the policy allowed within
+// the trailing decision window, optionally for the given ERC-20 token; failed txs are not counted
+func (p *Policy) spent(db *gorm.DB, column string, token *string) (*big.Int, error) {
+	query := fmt.Sprintf("SELECT COALESCE(SUM(%s), 0)::text FROM policy_decisions WHERE policy_id = ? AND allowed = true AND created_at > NOW() - INTERVAL '%s' AND NOT EXISTS (SELECT 1 FROM transactions WHERE transactions.id = policy_decisions.transaction_id AND transactions.status = 'failed')", column, decisionWindow)
+	args := []interface{}{p.ID}
+	if token != nil {
+		query = fmt.Sprintf("%s AND LOWER(token) = LOWER(?)", query)
+		args = append(args, *token)
+	}
+
+	var sum string
+	err := db.Raw(query, args...).Row().Scan(&sum)
+	if err != nil {
+		return nil, err
+	}
+
+	spent, ok := new(big.Int).SetString(sum, 10)
+	if !ok {
+		return nil, fmt.Errorf("failed to parse sum of %s: %s", column, sum)
+	}
+	return spent, nil
+}
+
+// violation returns a violation of the given rule of the policy
+func (p *Policy) violation(rule, reason string) *Violation {
+	return &Violation{
+		PolicyID: p.ID,
+		Rule:     rule,
+		Reason:   reason,
+	}
+}
+
+// decision returns the decision of the policy for the given intent
+func (p *Policy) decision(intent *Intent, c *call, violation *Violation) *Decision {
+	decision := &Decision{
+		PolicyID:       p.ID,
+		TransactionID:  intent.TransactionID,
+		ApplicationID:  intent.ApplicationID,
+		OrganizationID: intent.OrganizationID,
+		AccountID:      intent.AccountID,
+		WalletID:       intent.WalletID,
+		NetworkID:      intent.NetworkID,
+		To:             intent.To,
+		Value:          common.StringOrNil(intent.Value.String()),
+		Selector:       c.selector,
+		Recipient:      c.recipient,
+		Token:          c.token,
+		Allowed:        violation == nil,
+	}
+
+	if c.tokenAmount != nil {
+		decision.TokenAmount = common.StringOrNil(c.tokenAmount.String())
+	}
+
+	if violation != nil {
+		decision.Rule = common.StringOrNil(violation.Rule)
+		decision.Reason = common.StringOrNil(violation.Reason)
+	}
+
+	return decision
+}
+
+// log writes the decision to the audit log
+func (d *Decision) log(dryRun bool) {
+	to := "(contract creation)"
+	if d.To != nil {
+		to = *d.To
+	}
+
+	mode := ""
+	if dryRun {
+		mode = " (dry run)"
+	}
+
+	if d.Allowed {
+		common.Log.Infof("policy %s allowed tx%s on network %s to %s; value: %s", d.PolicyID, mode, d.NetworkID, to, *d.Value)
+	} else {
+		common.Log.Infof("policy %s rejected tx%s on network %s to %s; value: %s; rule %s failed; %s", d.PolicyID, mode, d.NetworkID, to, *d.Value, *d.Rule, *d.Reason)
+	}
+}
@@ -0,0 +1,354 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/internal/testutil"
+)
+
+const testRecipient = "0x0000000000000000000000000000000000000002"
+const testToken = "0x0000000000000000000000000000000000000003"
+
+var policiesQuery = regexp.QuoteMeta(`SELECT * FROM "policies" WHERE (enabled = $1) AND (network_id IS NULL OR network_id = $2) AND (application_id = $3)`)
+
+func testIntent() *Intent {
+	appID, _ := uuid.NewV4()
+	accountID, _ := uuid.NewV4()
+	networkID, _ := uuid.NewV4()
+	return &Intent{
+		ApplicationID: &appID,
+		AccountID:     &accountID,
+		NetworkID:     networkID,
+		To:            common.StringOrNil(testRecipient),
+		Value:         big.NewInt(100),
+	}
+}
+
+func expectPolicies(mock sqlmock.Sqlmock, rules ...string) []uuid.UUID {
+	rows := sqlmock.NewRows([]string{"id", "name", "enabled", "rules"})
+	ids := make([]uuid.UUID, 0)
+	for i, r := range rules {
+		id, _ := uuid.NewV4()
+		ids = append(ids, id)
+		rows.AddRow(id, fmt.Sprintf("policy %d", i), true, []byte(r))
+	}
+	mock.ExpectQuery(policiesQuery).WillReturnRows(rows)
+	return ids
+}
+
+// transferData returns the calldata of an ERC-20 transfer of the given amount to testRecipient
+func transferData(amount int64) *string {
+	return common.StringOrNil(fmt.Sprintf("%s%064s%064x", erc20TransferSelector, testRecipient[2:], amount))
+}
+
+func TestEvaluateRejectsIntentWhenPoliciesCannotBeResolved(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	mock.ExpectQuery(policiesQuery).WillReturnError(errors.New("connection refused"))
+
+	err := Evaluate(db, testIntent(), true)
+	if err == nil {
+		t.Fatal("expected intent to be rejected when the applicable policies cannot be resolved")
+	}
+	if _, ok := err.(*Violation); ok {
+		t.Errorf("expected resolution failure to not be reported as a violation; got %s", err.Error())
+	}
+}
+
+func TestEvaluateWithoutApplicablePolicies(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	expectPolicies(mock)
+
+	if err := Evaluate(db, testIntent(), true); err != nil {
+		t.Errorf("expected intent without applicable policies to be allowed; %s", err.Error())
+	}
+}
+
+func TestEvaluateWithoutApplicationOrOrganization(t *testing.T) {
+	db, _ := testutil.NewMockDB(t)
+	intent := testIntent()
+	intent.ApplicationID = nil
+
+	if err := Evaluate(db, intent, true); err != nil {
+		t.Errorf("expected intent without application or organization to be allowed; %s", err.Error())
+	}
+}
+
+func TestEvaluateRules(t *testing.T) {
+	cases := []struct {
+		name  string
+		rules string
+		data  *string
+		rule  string // the rule expected to reject the intent, if any
+	}{
+		{"value within cap", `{"max_value_per_tx": "100"}`, nil, ""},
+		{"value exceeds cap", `{"max_value_per_tx": "99"}`, nil, "max_value_per_tx"},
+		{"recipient allowed", `{"recipient_allowlist": ["` + testRecipient + `"]}`, nil, ""},
+		{"recipient not allowed", `{"recipient_allowlist": ["0x0000000000000000000000000000000000000009"]}`, nil, "recipient_allowlist"},
+		{"recipient denied", `{"recipient_denylist": ["` + testRecipient + `"]}`, nil, "recipient_denylist"},
+		{"contract not allowed", `{"contract_allowlist": ["0x0000000000000000000000000000000000000009"]}`, transferData(1), "contract_allowlist"},
+		{"contract denied", `{"contract_denylist": ["` + testToken + `"]}`, transferData(1), "contract_denylist"},
+		{"method allowed by signature", `{"method_allowlist": ["transfer(address,uint256)"]}`, transferData(1), ""},
+		{"method not allowed", `{"method_allowlist": ["0x095ea7b3"]}`, transferData(1), "method_allowlist"},
+		{"token transfer within cap", `{"token_limits": [{"token": "` + testToken + `", "max_amount_per_tx": "10"}]}`, transferData(10), ""},
+		{"token transfer exceeds cap", `{"token_limits": [{"token": "` + testToken + `", "max_amount_per_tx": "10"}]}`, transferData(11), "token_limits[" + testToken + "].max_amount_per_tx"},
+		{"invalid rules", `{"max_value_per_tx": 100}`, nil, "rules"},
+	}
+
+	for _, c := range cases {
+		db, mock := testutil.NewMockDB(t)
+		ids := expectPolicies(mock, c.rules)
+
+		intent := testIntent()
+		if c.data != nil {
+			intent.To = common.StringOrNil(testToken)
+			intent.Value = big.NewInt(0)
+			intent.Data = c.data
+		}
+
+		err := Evaluate(db, intent, true)
+		if c.rule == "" {
+			if err != nil {
+				t.Errorf("%s: expected intent to be allowed; %s", c.name, err.Error())
+			}
+			continue
+		}
+
+		violation, ok := err.(*Violation)
+		if !ok {
+			t.Errorf("%s: expected intent to be rejected by rule %s; got %v", c.name, c.rule, err)
+			continue
+		}
+		if violation.Rule != c.rule || violation.PolicyID != ids[0] {
+			t.Errorf("%s: expected intent to be rejected by rule %s of policy %s; got rule %s of policy %s", c.name, c.rule, ids[0], violation.Rule, violation.PolicyID)
+		}
+	}
+}
+
+func TestEvaluateRequiresEveryApplicablePolicy(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	ids := expectPolicies(mock, `{"max_value_per_tx": "1000"}`, `{"recipient_denylist": ["`+testRecipient+`"]}`)
+
+	err := Evaluate(db, testIntent(), true)
+	violation, ok := err.(*Violation)
+	if !ok || violation.PolicyID != ids[1] {
+		t.Errorf("expected intent to be rejected by the second applicable policy; got %v", err)
+	}
+}
+
+func TestEvaluateRecordsDecisions(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	ids := expectPolicies(mock, `{"max_value_per_day": "150"}`)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock(hashtext($1))`)).
+		WithArgs(ids[0].String()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT COALESCE\(SUM\(value\), 0\)::text FROM policy_decisions WHERE policy_id = \$1 AND allowed = true`).
+		WithArgs(ids[0]).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("60"))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "policy_decisions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.Nil))
+	mock.ExpectCommit()
+
+	err := Evaluate(db, testIntent(), false)
+	violation, ok := err.(*Violation)
+	if !ok || violation.Rule != "max_value_per_day" {
+		t.Errorf("expected intent which exceeds the remaining daily cap to be rejected; got %v", err)
+	}
+}
+
+func TestEvaluateRejectsIntentWhenSpendCannotBeResolved(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	ids := expectPolicies(mock, `{"max_value_per_day": "150"}`)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock(hashtext($1))`)).
+		WithArgs(ids[0].String()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT COALESCE`).WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	err := Evaluate(db, testIntent(), false)
+	if err == nil {
+		t.Fatal("expected intent to be rejected when the daily spend cannot be resolved")
+	}
+	if _, ok := err.(*Violation); ok {
+		t.Errorf("expected spend resolution failure to not be reported as a violation; got %s", err.Error())
+	}
+}
+
+func TestEvaluateAllCountsEarlierIntentsTowardsDailyCaps(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	policyID, _ := uuid.NewV4()
+	for i := 0; i < 2; i++ {
+		rows := sqlmock.NewRows([]string{"id", "name", "enabled", "rules"}).
+			AddRow(policyID, "policy", true, []byte(`{"max_value_per_day": "150"}`))
+		mock.ExpectQuery(policiesQuery).WillReturnRows(rows)
+	}
+
+	spentQuery := `SELECT COALESCE\(SUM\(value\), 0\)::text FROM policy_decisions WHERE policy_id = \$1 AND allowed = true`
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock(hashtext($1))`)).
+		WithArgs(policyID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(spentQuery).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("0"))
+	mock.ExpectQuery(spentQuery).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("0"))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "policy_decisions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.Nil))
+	mock.ExpectCommit()
+
+	first := testIntent()
+	second := testIntent()
+	second.ApplicationID = first.ApplicationID
+	second.NetworkID = first.NetworkID
+
+	err := EvaluateAll(db, []*Intent{first, second}, false)
+	violation, ok := err.(*Violation)
+	if !ok || violation.Rule != "max_value_per_day" {
+		t.Errorf("expected second intent to exceed the daily cap remaining after the first intent; got %v", err)
+	}
+}
+
+func TestEvaluateAllWithinDailyCaps(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	policyID, _ := uuid.NewV4()
+	for i := 0; i < 2; i++ {
+		rows := sqlmock.NewRows([]string{"id", "name", "enabled", "rules"}).
+			AddRow(policyID, "policy", true, []byte(`{"max_value_per_day": "250"}`))
+		mock.ExpectQuery(policiesQuery).WillReturnRows(rows)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock(hashtext($1))`)).
+		WithArgs(policyID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery(`SELECT COALESCE`).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("0"))
+	}
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery(`INSERT INTO "policy_decisions"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.Nil))
+	}
+	mock.ExpectCommit()
+
+	first := testIntent()
+	second := testIntent()
+	second.ApplicationID = first.ApplicationID
+	second.NetworkID = first.NetworkID
+
+	err := EvaluateAll(db, []*Intent{first, second}, false)
+	if err != nil {
+		t.Errorf("expected intents within the daily cap to be allowed; got %s", err.Error())
+	}
+}
+
+func TestEvaluateRecordsDecisionsOncePerTx(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	policyIDs := expectPolicies(mock, `{"max_value_per_day": "150"}`)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock(hashtext($1))`)).
+		WithArgs(policyIDs[0].String()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM policy_decisions WHERE transaction_id = $1 AND allowed = true`)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectRollback()
+
+	intent := testIntent()
+	txID, _ := uuid.NewV4()
+	intent.TransactionID = &txID
+
+	err := Evaluate(db, intent, false)
+	if err != nil {
+		t.Errorf("expected redelivered tx to be allowed; got %s", err.Error())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expected decisions of redelivered tx to not be recorded again; %s", err.Error())
+	}
+}
+
+func TestEvaluateExcludesFailedTxsFromDailyCaps(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	policyIDs := expectPolicies(mock, `{"max_value_per_day": "150"}`)
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(`SELECT pg_advisory_xact_lock(hashtext($1))`)).
+		WithArgs(policyIDs[0].String()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(`SELECT COUNT(*) FROM policy_decisions`)).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta(`AND NOT EXISTS (SELECT 1 FROM transactions WHERE transactions.id = policy_decisions.transaction_id AND transactions.status = 'failed')`)).
+		WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("0"))
+	mock.ExpectQuery(`INSERT INTO "policy_decisions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.Nil))
+	mock.ExpectCommit()
+
+	intent := testIntent()
+	txID, _ := uuid.NewV4()
+	intent.TransactionID = &txID
+
+	err := Evaluate(db, intent, false)
+	if err != nil {
+		t.Errorf("expected tx within the daily cap to be allowed; got %s", err.Error())
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expected daily spend to exclude failed txs; %s", err.Error())
+	}
+}
+
+func TestEvaluateAllDryRunCountsEarlierIntentsTowardsDailyCaps(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	policyID, _ := uuid.NewV4()
+	for i := 0; i < 2; i++ {
+		rows := sqlmock.NewRows([]string{"id", "name", "enabled", "rules"}).
+			AddRow(policyID, "policy", true, []byte(`{"max_value_per_day": "150"}`))
+		mock.ExpectQuery(policiesQuery).WillReturnRows(rows)
+	}
+
+	mock.ExpectBegin()
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery(`SELECT COALESCE`).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("0"))
+	}
+	mock.ExpectRollback()
+
+	first := testIntent()
+	second := testIntent()
+	second.ApplicationID = first.ApplicationID
+	second.NetworkID = first.NetworkID
+
+	err := EvaluateAll(db, []*Intent{first, second}, true)
+	violation, ok := err.(*Violation)
+	if !ok || violation.Rule != "max_value_per_day" {
+		t.Errorf("expected the total of the intents to exceed the daily cap; got %v", err)
+	}
+}
@@ -0,0 +1,382 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package policy
+
+import (
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	uuid "github.com/kthomas/go.uuid"
+	provide "github.com/provideplatform/provide-go/common"
+	util "github.com/provideplatform/provide-go/common/util"
+)
+
+// InstallPoliciesAPI installs the handlers using the given gin Engine
+func InstallPoliciesAPI(r *gin.Engine) {
+	r.GET("/api/v1/policies", policiesListHandler)
+	r.POST("/api/v1/policies", createPolicyHandler)
+	r.GET("/api/v1/policies/:id", policyDetailsHandler)
+	r.PUT("/api/v1/policies/:id", updatePolicyHandler)
+	r.DELETE("/api/v1/policies/:id", deletePolicyHandler)
+
+	r.GET("/api/v1/policies/:id/decisions", policyDecisionsListHandler)
+
+	r.GET("/api/v1/approval_rules", approvalRulesListHandler)
+	r.POST("/api/v1/approval_rules", createApprovalRuleHandler)
+	r.GET("/api/v1/approval_rules/:id", approvalRuleDetailsHandler)
+	r.PUT("/api/v1/approval_rules/:id", updateApprovalRuleHandler)
+	r.DELETE("/api/v1/approval_rules/:id", deleteApprovalRuleHandler)
+}
+
+// authorizedPolicyQuery scopes the given query to policies owned by the authorized application or organization
+func authorizedPolicyQuery(db *gorm.DB, appID, orgID *uuid.UUID) *gorm.DB {
+	if appID != nil {
+		db = db.Where("policies.application_id = ?", appID)
+	}
+	if orgID != nil {
+		db = db.Where("policies.organization_id = ?", orgID)
+	}
+	return db
+}
+
+// resolvePolicy resolves the policy with the given id on behalf of the authorized application or organization
+func resolvePolicy(c *gin.Context, appID, orgID *uuid.UUID) *Policy {
+	policy := &Policy{}
+	authorizedPolicyQuery(dbconf.DatabaseConnection(), appID, orgID).Where("policies.id = ?", c.Param("id")).Find(&policy)
+	if policy == nil || policy.ID == uuid.Nil {
+		provide.RenderError("policy not found", 404, c)
+		return nil
+	}
+	return policy
+}
+
+// authorizedApprovalRuleQuery scopes the given query to approval rules owned by the authorized application or organization
+func authorizedApprovalRuleQuery(db *gorm.DB, appID, orgID *uuid.UUID) *gorm.DB {
+	if appID != nil {
+		db = db.Where("approval_rules.application_id = ?", appID)
+	}
+	if orgID != nil {
+		db = db.Where("approval_rules.organization_id = ?", orgID)
+	}
+	return db
+}
+
+// resolveApprovalRule resolves the approval rule with the given id on behalf of the authorized application or organization
+func resolveApprovalRule(c *gin.Context, appID, orgID *uuid.UUID) *ApprovalRule {
+	rule := &ApprovalRule{}
+	authorizedApprovalRuleQuery(dbconf.DatabaseConnection(), appID, orgID).Where("approval_rules.id = ?", c.Param("id")).Find(&rule)
+	if rule == nil || rule.ID == uuid.Nil {
+		provide.RenderError("approval rule not found", 404, c)
+		return nil
+	}
+	return rule
+}
+
+func policiesListHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && orgID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	query := authorizedPolicyQuery(dbconf.DatabaseConnection(), appID, orgID)
+
+	if c.Query("account_id") != "" {
+		query = query.Where("policies.account_id = ?", c.Query("account_id"))
+	}
+
+	if c.Query("wallet_id") != "" {
+		query = query.Where("policies.wallet_id = ?", c.Query("wallet_id"))
+	}
+
+	if c.Query("network_id") != "" {
+		query = query.Where("policies.network_id = ?", c.Query("network_id"))
+	}
+
+	var policies []*Policy
+	query = query.Order("policies.created_at ASC")
+	provide.Paginate(c, query, &Policy{}).Find(&policies)
+	provide.Render(policies, 200, c)
+}
+
+func policyDetailsHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && orgID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	policy := resolvePolicy(c, appID, orgID)
+	if policy == nil {
+		return
+	}
+
+	provide.Render(policy, 200, c)
+}
+
+func createPolicyHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && orgID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	policy := &Policy{Enabled: true}
+	err = json.Unmarshal(buf, policy)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+	policy.ApplicationID = appID
+	policy.OrganizationID = orgID
+
+	if policy.Create() {
+		provide.Render(policy, 201, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = policy.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+func updatePolicyHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && orgID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	policy := resolvePolicy(c, appID, orgID)
+	if policy == nil {
+		return
+	}
+
+	policyID := policy.ID
+	err = json.Unmarshal(buf, policy)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+	policy.ID = policyID
+	policy.ApplicationID = appID
+	policy.OrganizationID = orgID
+
+	if policy.Update() {
+		provide.Render(nil, 204, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = policy.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+func deletePolicyHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && orgID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	policy := resolvePolicy(c, appID, orgID)
+	if policy == nil {
+		return
+	}
+
+	if !policy.Delete() {
+		provide.RenderError("policy not deleted", 500, c)
+		return
+	}
+	provide.Render(nil, 204, c)
+}
+
+func policyDecisionsListHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && orgID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	policy := resolvePolicy(c, appID, orgID)
+	if policy == nil {
+		return
+	}
+
+	query := dbconf.DatabaseConnection().Where("policy_decisions.policy_id = ?", policy.ID)
+
+	if c.Query("allowed") != "" {
+		query = query.Where("policy_decisions.allowed = ?", c.Query("allowed") == "true")
+	}
+
+	var decisions []*Decision
+	query = query.Order("policy_decisions.created_at DESC")
+	provide.Paginate(c, query, &Decision{}).Find(&decisions)
+	provide.Render(decisions, 200, c)
+}
+
+func approvalRulesListHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && orgID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	query := authorizedApprovalRuleQuery(dbconf.DatabaseConnection(), appID, orgID)
+
+	if c.Query("account_id") != "" {
+		query = query.Where("approval_rules.account_id = ?", c.Query("account_id"))
+	}
+
+	if c.Query("wallet_id") != "" {
+		query = query.Where("approval_rules.wallet_id = ?", c.Query("wallet_id"))
+	}
+
+	if c.Query("network_id") != "" {
+		query = query.Where("approval_rules.network_id = ?", c.Query("network_id"))
+	}
+
+	var rules []*ApprovalRule
+	query = query.Order("approval_rules.created_at ASC")
+	provide.Paginate(c, query, &ApprovalRule{}).Find(&rules)
+	provide.Render(rules, 200, c)
+}
+
+func approvalRuleDetailsHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && orgID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	rule := resolveApprovalRule(c, appID, orgID)
+	if rule == nil {
+		return
+	}
+
+	provide.Render(rule, 200, c)
+}
+
+func createApprovalRuleHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && orgID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	rule := &ApprovalRule{Enabled: true, RequiredApprovals: 1}
+	err = json.Unmarshal(buf, rule)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+	rule.ApplicationID = appID
+	rule.OrganizationID = orgID
+
+	if rule.Create() {
+		provide.Render(rule, 201, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = rule.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+func updateApprovalRuleHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && orgID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	rule := resolveApprovalRule(c, appID, orgID)
+	if rule == nil {
+		return
+	}
+
+	ruleID := rule.ID
+	err = json.Unmarshal(buf, rule)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+	rule.ID = ruleID
+	rule.ApplicationID = appID
+	rule.OrganizationID = orgID
+
+	if rule.Update() {
+		provide.Render(nil, 204, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = rule.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+func deleteApprovalRuleHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && orgID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	rule := resolveApprovalRule(c, appID, orgID)
+	if rule == nil {
+		return
+	}
+
+	if !rule.Delete() {
+		provide.RenderError("approval rule not deleted", 500, c)
+		return
+	}
+	provide.Render(nil, 204, c)
+}
@@ -0,0 +1,319 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+// Policy instances restrict the txs which nchain signs using an account or HD wallet; a policy
+// which is not associated with an account or HD wallet applies to every account and HD wallet
+// of the application or organization. Every applicable policy must allow a tx for it to be signed.
+type Policy struct {
+	provide.Model
+	ApplicationID  *uuid.UUID       `sql:"type:uuid" json:"application_id,omitempty"`
+	OrganizationID *uuid.UUID       `sql:"type:uuid" json:"organization_id,omitempty"`
+	AccountID      *uuid.UUID       `sql:"type:uuid" json:"account_id,omitempty"`
+	WalletID       *uuid.UUID       `sql:"type:uuid" json:"wallet_id,omitempty"`
+	NetworkID      *uuid.UUID       `sql:"type:uuid" json:"network_id,omitempty"` // the policy applies to all networks if nil
+	Name           *string          `sql:"not null" json:"name"`
+	Description    *string          `json:"description,omitempty"`
+	Enabled        bool             `sql:"not null" json:"enabled"`
+	Rules          *json.RawMessage `sql:"type:json;not null" json:"rules"`
+}
+
+// Rules of a policy; native values and token amounts are base-10 integer strings
+// denominated in the smallest unit (i.e., wei)
+type Rules struct {
+	MaxValuePerTx  *string `json:"max_value_per_tx,omitempty"`
+	MaxValuePerDay *string `json:"max_value_per_day,omitempty"` // trailing 24 hours
+
+	// ERC-20 transfer caps, enforced using the amount decoded from transfer and transferFrom calldata
+	TokenLimits []*TokenLimit `json:"token_limits,omitempty"`
+
+	// Recipients of native value or ERC-20 transfers
+	RecipientAllowlist []string `json:"recipient_allowlist,omitempty"`
+	RecipientDenylist  []string `json:"recipient_denylist,omitempty"`
+
+	// Contracts called by txs with calldata; an allowlist also prevents contract deployment
+	ContractAllowlist []string `json:"contract_allowlist,omitempty"`
+	ContractDenylist  []string `json:"contract_denylist,omitempty"`
+
+	// Method selectors (i.e., 0xa9059cbb) or signatures (i.e., transfer(address,uint256)) which may be called
+	MethodAllowlist []string `json:"method_allowlist,omitempty"`
+}
+
+// TokenLimit caps the amount of an ERC-20 token which may be transferred
+type TokenLimit struct {
+	Token           string  `json:"token"`
+	MaxAmountPerTx  *string `json:"max_amount_per_tx,omitempty"`
+	MaxAmountPerDay *string `json:"max_amount_per_day,omitempty"` // trailing 24 hours
+}
+
+// Decision is the audit record of the evaluation of a policy against a tx
+type Decision struct {
+	provide.Model
+	PolicyID       uuid.UUID  `sql:"not null;type:uuid" json:"policy_id"`
+	TransactionID  *uuid.UUID `sql:"type:uuid" json:"transaction_id,omitempty"`
+	ApplicationID  *uuid.UUID `sql:"type:uuid" json:"application_id,omitempty"`
+	OrganizationID *uuid.UUID `sql:"type:uuid" json:"organization_id,omitempty"`
+	AccountID      *uuid.UUID `sql:"type:uuid" json:"account_id,omitempty"`
+	WalletID       *uuid.UUID `sql:"type:uuid" json:"wallet_id,omitempty"`
+	NetworkID      uuid.UUID  `sql:"not null;type:uuid" json:"network_id"`
+	To             *string    `json:"to,omitempty"`
+	Value          *string    `sql:"type:numeric" json:"value"`
+	Selector       *string    `json:"selector,omitempty"`
+	Recipient      *string    `json:"recipient,omitempty"`
+	Token          *string    `json:"token,omitempty"`
+	TokenAmount    *string    `sql:"type:numeric" json:"token_amount,omitempty"`
+	Allowed        bool       `sql:"not null" json:"allowed"`
+	Rule           *string    `json:"rule,omitempty"`   // the rule which rejected the tx, if any
+	Reason         *string    `json:"reason,omitempty"` // description of the rejection, if any
+}
+
+// TableName returns the table name of the policy decision audit log
+func (Decision) TableName() string {
+	return "policy_decisions"
+}
+
+// Create and persist a new policy
+func (p *Policy) Create() bool {
+	if !p.Validate() {
+		return false
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	if db.NewRecord(p) {
+		result := db.Create(&p)
+		rowsAffected := result.RowsAffected
+		errors := result.GetErrors()
+		if len(errors) > 0 {
+			for _, err := range errors {
+				p.Errors = append(p.Errors, &provide.Error{
+					Message: common.StringOrNil(err.Error()),
+				})
+			}
+		}
+		if !db.NewRecord(p) {
+			return rowsAffected > 0
+		}
+	}
+	return false
+}
+
+// Update an existing policy
+func (p *Policy) Update() bool {
+	if !p.Validate() {
+		return false
+	}
+
+	db := dbconf.DatabaseConnection()
+	result := db.Save(&p)
+	errors := result.GetErrors()
+	if len(errors) > 0 {
+		for _, err := range errors {
+			p.Errors = append(p.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+		}
+	}
+	return len(p.Errors) == 0
+}
+
+// Delete a policy
+func (p *Policy) Delete() bool {
+	db := dbconf.DatabaseConnection()
+	result := db.Delete(p)
+	errors := result.GetErrors()
+	if len(errors) > 0 {
+		for _, err := range errors {
+			p.Errors = append(p.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+		}
+	}
+	return len(p.Errors) == 0
+}
+
+// Validate a policy for persistence
+func (p *Policy) Validate() bool {
+	p.Errors = make([]*provide.Error, 0)
+
+	if p.ApplicationID == nil && p.OrganizationID == nil {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil("policy must be associated with an application or organization"),
+		})
+	}
+
+	if p.AccountID != nil && p.WalletID != nil {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil("only an account OR HD wallet identifier should be provided"),
+		})
+	}
+
+	if p.Name == nil || *p.Name == "" {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil("policy name required"),
+		})
+	}
+
+	rules, err := p.ParseRules()
+	if err != nil {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+	} else if err := rules.validate(); err != nil {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+	}
+
+	return len(p.Errors) == 0
+}
+
+// ParseRules returns the rules of the policy
+func (p *Policy) ParseRules() (*Rules, error) {
+	if p.Rules == nil {
+		return nil, fmt.Errorf("policy rules required")
+	}
+
+	rules := &Rules{}
+	err := json.Unmarshal(*p.Rules, &rules)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse policy rules; %s", err.Error())
+	}
+	return rules, nil
+}
+
+// validate the rules
+func (r *Rules) validate() error {
+	for _, limit := range []*string{r.MaxValuePerTx, r.MaxValuePerDay} {
+		if _, err := parseAmount(limit); err != nil {
+			return err
+		}
+	}
+
+	for _, limit := range r.TokenLimits {
+		if !ethcommon.IsHexAddress(limit.Token) {
+			return fmt.Errorf("invalid token address: %s", limit.Token)
+		}
+		for _, amount := range []*string{limit.MaxAmountPerTx, limit.MaxAmountPerDay} {
+			if _, err := parseAmount(amount); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, list := range [][]string{r.RecipientAllowlist, r.RecipientDenylist, r.ContractAllowlist, r.ContractDenylist} {
+		for _, addr := range list {
+			if !ethcommon.IsHexAddress(addr) {
+				return fmt.Errorf("invalid address: %s", addr)
+			}
+		}
+	}
+
+	for _, method := range r.MethodAllowlist {
+		if _, err := common.ParseMethodSelector(method); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseAmount parses the given base-10 integer string; nil is returned if no amount is given
+func parseAmount(amount *string) (*big.Int, error) {
+	if amount == nil {
+		return nil, nil
+	}
+
+	i, ok := new(big.Int).SetString(*amount, 10)
+	if !ok || i.Sign() < 0 {
+		return nil, fmt.Errorf("invalid amount: %s", *amount)
+	}
+	return i, nil
+}
+
+// containsAddress returns true if the given list contains the given address
+func containsAddress(list []string, address string) bool {
+	for _, addr := range list {
+		if strings.EqualFold(addr, address) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsSelector returns true if the given list of method selectors or signatures contains the given selector
+func containsSelector(methods []string, selector *string) bool {
+	if selector == nil {
+		return false
+	}
+
+	for _, method := range methods {
+		sel, err := common.ParseMethodSelector(method)
+		if err == nil && sel == *selector {
+			return true
+		}
+	}
+	return false
+}
+
+// applicablePolicies returns the enabled policies which apply to the given intent, ordered by id;
+// an error is returned if the policies cannot be resolved, in which case the intent must be rejected
+func applicablePolicies(db *gorm.DB, intent *Intent) ([]*Policy, error) {
+	query := db.Where("enabled = ?", true)
+	query = query.Where("network_id IS NULL OR network_id = ?", intent.NetworkID)
+
+	if intent.ApplicationID != nil {
+		query = query.Where("application_id = ?", intent.ApplicationID)
+	} else if intent.OrganizationID != nil {
+		query = query.Where("organization_id = ?", intent.OrganizationID)
+	} else {
+		return nil, nil
+	}
+
+	scope := "(account_id IS NULL AND wallet_id IS NULL)"
+	args := make([]interface{}, 0)
+	if intent.AccountID != nil {
+		scope = fmt.Sprintf("%s OR account_id = ?", scope)
+		args = append(args, intent.AccountID)
+	}
+	if intent.WalletID != nil {
+		scope = fmt.Sprintf("%s OR wallet_id = ?", scope)
+		args = append(args, intent.WalletID)
+	}
+	query = query.Where(scope, args...)
+
+	var policies []*Policy
+	err := query.Order("id ASC").Find(&policies).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve applicable policies; %s", err.Error())
+	}
+	return policies, nil
+}
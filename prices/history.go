@@ -0,0 +1,68 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package prices
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/kthomas/go.uuid"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+// maxPriceAge is the maximum age of an observed price which is used as the price at a given time
+const maxPriceAge = time.Hour
+
+// CurrencyPrice is the price of a currency pair observed at a point in time
+type CurrencyPrice struct {
+	provide.Model
+	CurrencyPair string    `sql:"not null" json:"currency_pair"`
+	Price        float64   `sql:"not null" json:"price"`
+	ObservedAt   time.Time `sql:"not null" json:"observed_at"`
+}
+
+// RecordPrice records the price of the given currency pair observed at the given time
+func RecordPrice(db *gorm.DB, currencyPair string, price float64, observedAt time.Time) error {
+	err := db.Create(&CurrencyPrice{
+		CurrencyPair: currencyPair,
+		Price:        price,
+		ObservedAt:   observedAt,
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to record %s price; %s", currencyPair, err.Error())
+	}
+	return nil
+}
+
+// PriceAt returns the most recent price of the given currency pair observed at or before the given
+// time, or nil if no price was observed within maxPriceAge of the given time
+func PriceAt(db *gorm.DB, currencyPair string, at time.Time) (*float64, error) {
+	var prices []*CurrencyPrice
+	err := db.Where("currency_pair = ? AND observed_at <= ? AND observed_at > ?", currencyPair, at, at.Add(-maxPriceAge)).
+		Order("observed_at DESC").
+		Limit(1).
+		Find(&prices).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s price at %s; %s", currencyPair, at.UTC().Format(time.RFC3339), err.Error())
+	}
+
+	if len(prices) == 0 || prices[0].ID == uuid.Nil {
+		return nil, nil
+	}
+	return &prices[0].Price, nil
+}
@@ -19,7 +19,9 @@ package prices
 import (
 	"errors"
 	"fmt"
+	"time"
 
+	dbconf "github.com/kthomas/go-db-config"
 	"github.com/provideplatform/nchain/common"
 )
 
@@ -104,5 +106,11 @@ func SetPrice(currencyPair string, seq uint64, price float64) error {
 		common.Log.Warning(msg)
 		return errors.New(msg)
 	}
+
+	// the price history is used to compute the fiat fees of txs at their block timestamps
+	err = RecordPrice(dbconf.DatabaseConnection(), currencyPair, price, time.Now())
+	if err != nil {
+		common.Log.Warning(err.Error())
+	}
 	return nil
 }
@@ -0,0 +1,105 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package safe
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	dbconf "github.com/kthomas/go-db-config"
+	natsutil "github.com/kthomas/go-natsutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/deadletter"
+)
+
+const defaultNatsStream = "nchain"
+
+// natsTxFailedSubject is the subject on which the tx package publishes the ids of failed txs; Safe
+// proposals consume the subject using a dedicated durable consumer, so a proposal whose execTransaction
+// tx failed can be executed again
+const natsTxFailedSubject = "nchain.tx.failed"
+const natsSafeTxFailedConsumer = "nchain.safe.tx.failed"
+const natsSafeTxFailedMaxInFlight = 1024
+const natsSafeTxFailedMaxDeliveries = 10
+const safeTxFailedAckWait = time.Second * 30
+
+type natsTxFailedMsg struct {
+	TransactionID *uuid.UUID `json:"transaction_id"`
+}
+
+var waitGroup sync.WaitGroup
+
+func init() {
+	if !common.ConsumeNATSStreamingSubscriptions {
+		common.Log.Debug("Safe package consumer configured to skip NATS streaming subscription setup")
+		return
+	}
+
+	natsutil.EstablishSharedNatsConnection(nil)
+	natsutil.NatsCreateStream(defaultNatsStream, []string{
+		fmt.Sprintf("%s.>", defaultNatsStream),
+	})
+
+	createNatsTxFailedSubscriptions(&waitGroup)
+}
+
+func createNatsTxFailedSubscriptions(wg *sync.WaitGroup) {
+	for i := uint64(0); i < natsutil.GetNatsConsumerConcurrency(); i++ {
+		natsutil.RequireNatsJetstreamSubscription(wg,
+			safeTxFailedAckWait,
+			natsTxFailedSubject,
+			natsSafeTxFailedConsumer,
+			natsSafeTxFailedConsumer,
+			consumeTxFailedMsg,
+			safeTxFailedAckWait,
+			natsSafeTxFailedMaxInFlight,
+			natsSafeTxFailedMaxDeliveries,
+			nil,
+		)
+	}
+}
+
+func consumeTxFailedMsg(msg *nats.Msg) {
+	common.Log.Tracef("consuming %d-byte NATS tx failed message on subject: %s", len(msg.Data), msg.Subject)
+
+	failedMsg := &natsTxFailedMsg{}
+	err := json.Unmarshal(msg.Data, &failedMsg)
+	if err != nil {
+		common.Log.Warningf("failed to unmarshal tx failed message; %s", err.Error())
+		deadletter.Term(msg, fmt.Sprintf("failed to unmarshal tx failed message; %s", err.Error()))
+		return
+	}
+
+	if failedMsg.TransactionID == nil {
+		common.Log.Warningf("parsed %d-byte NATS tx failed message did not contain tx id", len(msg.Data))
+		deadletter.Term(msg, fmt.Sprintf("parsed %d-byte NATS tx failed message did not contain tx id", len(msg.Data)))
+		return
+	}
+
+	err = resetFailedExecution(dbconf.DatabaseConnection(), *failedMsg.TransactionID)
+	if err != nil {
+		common.Log.Warning(err.Error())
+		msg.Nak()
+		return
+	}
+
+	msg.Ack()
+}
@@ -0,0 +1,331 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package safe
+
+import (
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/wallet"
+	provide "github.com/provideplatform/provide-go/common"
+	util "github.com/provideplatform/provide-go/common/util"
+)
+
+// signerParams identify the account or HD wallet used to confirm or execute a proposal
+type signerParams struct {
+	AccountID *uuid.UUID `json:"account_id"`
+	WalletID  *uuid.UUID `json:"wallet_id"`
+	Path      *string    `json:"hd_derivation_path"`
+}
+
+// InstallSafeAPI installs the handlers using the given gin Engine
+func InstallSafeAPI(r *gin.Engine) {
+	r.GET("/api/v1/safe/proposals", proposalsListHandler)
+	r.POST("/api/v1/safe/proposals", createProposalHandler)
+	r.GET("/api/v1/safe/proposals/:id", proposalDetailsHandler)
+	r.POST("/api/v1/safe/proposals/:id/execute", executeProposalHandler)
+
+	r.GET("/api/v1/safe/proposals/:id/confirmations", confirmationsListHandler)
+	r.POST("/api/v1/safe/proposals/:id/confirmations", createConfirmationHandler)
+	r.GET("/api/v1/safe/proposals/:id/confirmations/:confirmationId", confirmationDetailsHandler)
+}
+
+// authorizedProposalQuery scopes the given query to proposals owned by the authorized application or organization
+func authorizedProposalQuery(db *gorm.DB, appID, orgID *uuid.UUID) *gorm.DB {
+	if appID != nil {
+		db = db.Where("safe_proposals.application_id = ?", appID)
+	}
+	if orgID != nil {
+		db = db.Where("safe_proposals.organization_id = ?", orgID)
+	}
+	return db
+}
+
+// resolveProposal resolves the proposal with the given id on behalf of the authorized application or organization
+func resolveProposal(c *gin.Context, appID, orgID *uuid.UUID) *Proposal {
+	proposal := &Proposal{}
+	authorizedProposalQuery(dbconf.DatabaseConnection(), appID, orgID).Where("safe_proposals.id = ?", c.Param("id")).Find(&proposal)
+	if proposal == nil || proposal.ID == uuid.Nil {
+		provide.RenderError("Safe proposal not found", 404, c)
+		return nil
+	}
+	return proposal
+}
+
+// resolveSigner resolves the account or HD wallet owned by the authorized application or organization
+func resolveSigner(c *gin.Context, params *signerParams, appID, orgID *uuid.UUID) (*wallet.Account, *wallet.Wallet, bool) {
+	db := dbconf.DatabaseConnection()
+	if appID != nil {
+		db = db.Where("application_id = ?", appID)
+	}
+	if orgID != nil {
+		db = db.Where("organization_id = ?", orgID)
+	}
+
+	if params.AccountID != nil {
+		acct := &wallet.Account{}
+		db.Where("id = ?", params.AccountID).Find(&acct)
+		if acct == nil || acct.ID == uuid.Nil {
+			provide.RenderError("account not found", 404, c)
+			return nil, nil, false
+		}
+		return acct, nil, true
+	}
+
+	if params.WalletID != nil {
+		wllt := &wallet.Wallet{}
+		db.Where("id = ?", params.WalletID).Find(&wllt)
+		if wllt == nil || wllt.ID == uuid.Nil {
+			provide.RenderError("wallet not found", 404, c)
+			return nil, nil, false
+		}
+		return nil, wllt, true
+	}
+
+	return nil, nil, true
+}
+
+func proposalsListHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && orgID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	query := authorizedProposalQuery(dbconf.DatabaseConnection(), appID, orgID)
+
+	if c.Query("network_id") != "" {
+		query = query.Where("safe_proposals.network_id = ?", c.Query("network_id"))
+	}
+
+	if c.Query("address") != "" {
+		query = query.Where("LOWER(safe_proposals.address) = LOWER(?)", c.Query("address"))
+	}
+
+	if c.Query("status") != "" {
+		query = query.Where("safe_proposals.status = ?", c.Query("status"))
+	}
+
+	var proposals []*Proposal
+	query = query.Order("safe_proposals.created_at DESC")
+	provide.Paginate(c, query, &Proposal{}).Find(&proposals)
+	provide.Render(proposals, 200, c)
+}
+
+func proposalDetailsHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && orgID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	proposal := resolveProposal(c, appID, orgID)
+	if proposal == nil {
+		return
+	}
+
+	proposal.loadConfirmations(dbconf.DatabaseConnection())
+	provide.Render(proposal, 200, c)
+}
+
+func createProposalHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && orgID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	proposal := &Proposal{}
+	err = json.Unmarshal(buf, proposal)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+	proposal.ApplicationID = appID
+	proposal.OrganizationID = orgID
+
+	executor := &signerParams{
+		AccountID: proposal.ExecutorAccountID,
+		WalletID:  proposal.ExecutorWalletID,
+	}
+	if _, _, ok := resolveSigner(c, executor, appID, orgID); !ok {
+		return
+	}
+
+	if proposal.Create(dbconf.DatabaseConnection()) {
+		provide.Render(proposal, 201, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = proposal.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+func executeProposalHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && orgID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	params := &signerParams{}
+	if len(buf) > 0 {
+		err = json.Unmarshal(buf, params)
+		if err != nil {
+			provide.RenderError(err.Error(), 422, c)
+			return
+		}
+	}
+
+	proposal := resolveProposal(c, appID, orgID)
+	if proposal == nil {
+		return
+	}
+
+	if params.AccountID == nil && params.WalletID == nil {
+		params.AccountID = proposal.ExecutorAccountID
+		params.WalletID = proposal.ExecutorWalletID
+		params.Path = proposal.ExecutorPath
+	}
+
+	if _, _, ok := resolveSigner(c, params, appID, orgID); !ok {
+		return
+	}
+
+	if proposal.Execute(dbconf.DatabaseConnection(), params.AccountID, params.WalletID, params.Path) {
+		provide.Render(proposal, 202, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = proposal.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+func confirmationsListHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && orgID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	proposal := resolveProposal(c, appID, orgID)
+	if proposal == nil {
+		return
+	}
+
+	query := dbconf.DatabaseConnection().Where("safe_confirmations.proposal_id = ?", proposal.ID)
+
+	var confirmations []*Confirmation
+	query = query.Order("safe_confirmations.created_at ASC")
+	provide.Paginate(c, query, &Confirmation{}).Find(&confirmations)
+	provide.Render(confirmations, 200, c)
+}
+
+func createConfirmationHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && orgID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	confirmation := &Confirmation{}
+	err = json.Unmarshal(buf, confirmation)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	proposal := resolveProposal(c, appID, orgID)
+	if proposal == nil {
+		return
+	}
+
+	acct, wllt, ok := resolveSigner(c, &signerParams{
+		AccountID: confirmation.AccountID,
+		WalletID:  confirmation.WalletID,
+	}, appID, orgID)
+	if !ok {
+		return
+	}
+
+	if acct != nil || wllt != nil {
+		// the confirmation is signed custodially using the given account or HD wallet
+		err = confirmation.sign(proposal, acct, wllt)
+		if err != nil {
+			provide.RenderError(err.Error(), 500, c)
+			return
+		}
+	}
+
+	if proposal.Confirm(dbconf.DatabaseConnection(), confirmation) {
+		provide.Render(confirmation, 201, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = proposal.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+func confirmationDetailsHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && orgID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	proposal := resolveProposal(c, appID, orgID)
+	if proposal == nil {
+		return
+	}
+
+	confirmation := &Confirmation{}
+	dbconf.DatabaseConnection().Where("proposal_id = ? AND id = ?", proposal.ID, c.Param("confirmationId")).Find(&confirmation)
+	if confirmation == nil || confirmation.ID == uuid.Nil {
+		provide.RenderError("Safe confirmation not found", 404, c)
+		return
+	}
+
+	provide.Render(confirmation, 200, c)
+}
@@ -0,0 +1,386 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package safe
+
+import (
+	"fmt"
+	"strings"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/contract"
+	"github.com/provideplatform/nchain/network"
+	"github.com/provideplatform/nchain/tx"
+	"github.com/provideplatform/nchain/wallet"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+// Create and persist a new proposal; the Safe tx hash and confirmation threshold are read from the Safe
+func (p *Proposal) Create(db *gorm.DB) bool {
+	if p.ContractID != nil {
+		query := db.Where("id = ?", p.ContractID)
+		if p.ApplicationID != nil {
+			query = query.Where("application_id = ?", p.ApplicationID)
+		}
+		if p.OrganizationID != nil {
+			query = query.Where("organization_id = ?", p.OrganizationID)
+		}
+
+		safeContract := &contract.Contract{}
+		query.Find(&safeContract)
+		if safeContract == nil || safeContract.ID == uuid.Nil {
+			p.Errors = append(p.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("Safe contract not found: %s", p.ContractID)),
+			})
+			return false
+		}
+		p.Address = safeContract.Address
+		p.NetworkID = safeContract.NetworkID
+	}
+
+	if !p.Validate() {
+		return false
+	}
+
+	ntwrk := &network.Network{}
+	db.Where("id = ?", p.NetworkID).Find(&ntwrk)
+	if ntwrk == nil || ntwrk.ID == uuid.Nil || !ntwrk.IsEthereumNetwork() {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("Safe proposals not supported by network: %s", p.NetworkID)),
+		})
+		return false
+	}
+
+	err := p.resolveSafeTxHash(ntwrk)
+	if err != nil {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+		return false
+	}
+
+	p.Status = common.StringOrNil(ProposalStatusPending)
+
+	if db.NewRecord(p) {
+		result := db.Create(&p)
+		rowsAffected := result.RowsAffected
+		errors := result.GetErrors()
+		if len(errors) > 0 {
+			for _, err := range errors {
+				p.Errors = append(p.Errors, &provide.Error{
+					Message: common.StringOrNil(err.Error()),
+				})
+			}
+		}
+		if !db.NewRecord(p) {
+			return rowsAffected > 0
+		}
+	}
+	return false
+}
+
+// Validate a proposal for persistence
+func (p *Proposal) Validate() bool {
+	p.Errors = make([]*provide.Error, 0)
+
+	if p.ApplicationID == nil && p.OrganizationID == nil {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil("Safe proposal must be associated with an application or organization"),
+		})
+	}
+
+	if p.NetworkID == uuid.Nil {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil("network id required"),
+		})
+	}
+
+	if p.Address == nil || !ethcommon.IsHexAddress(*p.Address) {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil("valid Safe address required"),
+		})
+	}
+
+	if p.To == nil || !ethcommon.IsHexAddress(*p.To) {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil("valid to address required"),
+		})
+	}
+
+	for _, addr := range []*string{p.GasToken, p.RefundReceiver} {
+		if addr != nil && !ethcommon.IsHexAddress(*addr) {
+			p.Errors = append(p.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("invalid address: %s", *addr)),
+			})
+		}
+	}
+
+	if p.Operation != operationCall && p.Operation != operationDelegateCall {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("unsupported Safe operation: %d", p.Operation)),
+		})
+	}
+
+	if _, err := p.safeTxParams(); err != nil {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+	}
+
+	if p.ExecutorAccountID != nil && p.ExecutorWalletID != nil {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil("only an executor account OR HD wallet should be provided"),
+		})
+	}
+
+	return len(p.Errors) == 0
+}
+
+// sign signs the Safe tx hash of the given proposal using the given account or HD wallet
+func (c *Confirmation) sign(p *Proposal, acct *wallet.Account, wllt *wallet.Wallet) error {
+	var resp *wallet.SignatureResponse
+	var err error
+
+	if acct != nil {
+		resp, err = acct.SignDigest(p.parseSafeTxHash())
+		c.AccountID = &acct.ID
+	} else if wllt != nil {
+		resp, err = wllt.SignDigest(c.Path, p.parseSafeTxHash())
+		c.WalletID = &wllt.ID
+		if resp != nil {
+			c.Path = resp.HDDerivationPath
+		}
+	} else {
+		err = fmt.Errorf("no account or HD wallet to confirm Safe proposal: %s", p.ID)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	c.Owner = common.StringOrNil(resp.Address)
+	c.Signature = common.StringOrNil(resp.Signature)
+	return nil
+}
+
+// Confirm validates and persists the given owner confirmation of the proposal; once the
+// threshold is met, the execTransaction tx is submitted if the proposal has an executor
+func (p *Proposal) Confirm(db *gorm.DB, confirmation *Confirmation) bool {
+	p.Errors = make([]*provide.Error, 0)
+
+	if p.Status == nil || (*p.Status != ProposalStatusPending && *p.Status != ProposalStatusReady) {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("Safe proposal %s cannot be confirmed; status: %s", p.ID, p.status())),
+		})
+		return false
+	}
+
+	if confirmation.Owner == nil || !ethcommon.IsHexAddress(*confirmation.Owner) || confirmation.Signature == nil {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil("owner and signature required"),
+		})
+		return false
+	}
+
+	signer, err := wallet.RecoverSigner(p.parseSafeTxHash(), *confirmation.Signature)
+	if err != nil || !strings.EqualFold(signer, *confirmation.Owner) {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("signature not signed by owner: %s", *confirmation.Owner)),
+		})
+		return false
+	}
+
+	// the Safe requires the recovery id of ECDSA signatures to be 27 or 28
+	sig, _ := hexutil.Decode(*confirmation.Signature)
+	if sig[64] < 27 {
+		sig[64] += 27
+	}
+	confirmation.Signature = common.StringOrNil(hexutil.Encode(sig))
+	confirmation.Owner = common.StringOrNil(ethcommon.HexToAddress(*confirmation.Owner).Hex())
+
+	ntwrk := &network.Network{}
+	db.Where("id = ?", p.NetworkID).Find(&ntwrk)
+
+	owners, err := readOwners(ntwrk, *p.Address)
+	if err != nil {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+		return false
+	}
+
+	if !isOwner(owners, *confirmation.Owner) {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("%s is not an owner of Safe: %s", *confirmation.Owner, *p.Address)),
+		})
+		return false
+	}
+
+	p.loadConfirmations(db)
+	for _, existing := range p.Confirmations {
+		if strings.EqualFold(*existing.Owner, *confirmation.Owner) {
+			p.Errors = append(p.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("Safe proposal %s already confirmed by owner: %s", p.ID, *confirmation.Owner)),
+			})
+			return false
+		}
+	}
+
+	confirmation.ProposalID = p.ID
+	result := db.Create(&confirmation)
+	if len(result.GetErrors()) > 0 {
+		for _, err := range result.GetErrors() {
+			p.Errors = append(p.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+		}
+		return false
+	}
+
+	p.Confirmations = append(p.Confirmations, confirmation)
+	common.Log.Debugf("Safe proposal %s confirmed by owner %s; %d of %d confirmation(s)", p.ID, *confirmation.Owner, len(p.Confirmations), p.Threshold)
+
+	if uint64(len(p.Confirmations)) >= p.Threshold && p.transition(db, ProposalStatusPending, ProposalStatusReady) {
+		if p.ExecutorAccountID != nil || p.ExecutorWalletID != nil {
+			if !p.Execute(db, p.ExecutorAccountID, p.ExecutorWalletID, p.ExecutorPath) {
+				common.Log.Warningf("failed to execute Safe proposal %s after threshold was met; %s", p.ID, *p.Errors[0].Message)
+				p.Errors = make([]*provide.Error, 0)
+			}
+		}
+	}
+
+	return true
+}
+
+// Execute submits the execTransaction tx of a proposal which has met its threshold, signed
+// and paid for by the given account or HD wallet
+func (p *Proposal) Execute(db *gorm.DB, accountID, walletID *uuid.UUID, path *string) bool {
+	p.Errors = make([]*provide.Error, 0)
+
+	if p.Status == nil || *p.Status != ProposalStatusReady {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("Safe proposal %s cannot be executed; status: %s", p.ID, p.status())),
+		})
+		return false
+	}
+
+	if accountID == nil && walletID == nil {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil("account or HD wallet required to execute Safe proposal"),
+		})
+		return false
+	}
+
+	p.loadConfirmations(db)
+	if uint64(len(p.Confirmations)) < p.Threshold {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("Safe proposal %s has %d of %d required confirmation(s)", p.ID, len(p.Confirmations), p.Threshold)),
+		})
+		return false
+	}
+
+	data, err := p.execTransactionData(p.Confirmations)
+	if err != nil {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+		return false
+	}
+
+	params := map[string]interface{}{
+		"gas": 0,
+	}
+	if path != nil {
+		params["hd_derivation_path"] = *path
+	}
+
+	execTx := &tx.Transaction{
+		ApplicationID:  p.ApplicationID,
+		OrganizationID: p.OrganizationID,
+		NetworkID:      p.NetworkID,
+		AccountID:      accountID,
+		WalletID:       walletID,
+		Path:           path,
+		To:             p.Address,
+		Value:          tx.NewTxValue(0),
+		Data:           data,
+		Params:         marshalParams(params),
+		Description:    common.StringOrNil(fmt.Sprintf("execTransaction for Safe proposal: %s", p.ID)),
+	}
+
+	// the proposal is claimed for execution so it cannot be submitted more than once
+	if !p.transition(db, ProposalStatusReady, ProposalStatusSubmitted) {
+		p.Errors = append(p.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("Safe proposal %s is already being executed", p.ID)),
+		})
+		return false
+	}
+
+	if !execTx.Create(db) {
+		p.transition(db, ProposalStatusSubmitted, ProposalStatusReady)
+		for _, err := range execTx.Errors {
+			p.Errors = append(p.Errors, err)
+		}
+		if len(p.Errors) == 0 {
+			p.Errors = append(p.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("failed to submit execTransaction for Safe proposal: %s", p.ID)),
+			})
+		}
+		return false
+	}
+
+	p.TransactionID = &execTx.ID
+	db.Model(p).Update("transaction_id", p.TransactionID)
+
+	common.Log.Debugf("submitted execTransaction tx %s for Safe proposal: %s", execTx.ID, p.ID)
+	return true
+}
+
+// status returns the status of the proposal, or an empty string if it has no status
+func (p *Proposal) status() string {
+	if p.Status == nil {
+		return ""
+	}
+	return *p.Status
+}
+
+// resetFailedExecution returns the submitted proposal whose execTransaction tx has the given id
+// to ready, so it can be executed again; the Safe nonce is not consumed by a failed execTransaction
+func resetFailedExecution(db *gorm.DB, txID uuid.UUID) error {
+	result := db.Model(&Proposal{}).Where("transaction_id = ? AND status = ?", txID, ProposalStatusSubmitted).Update("status", ProposalStatusReady)
+	if result.Error != nil {
+		return fmt.Errorf("failed to reset Safe proposal with failed execTransaction tx: %s; %s", txID, result.Error.Error())
+	}
+	if result.RowsAffected > 0 {
+		common.Log.Debugf("execTransaction tx %s failed; Safe proposal is ready to be executed again", txID)
+	}
+	return nil
+}
+
+// transition atomically updates the status of the proposal from the given status; returns
+// false if the proposal is no longer in the given status
+func (p *Proposal) transition(db *gorm.DB, from, to string) bool {
+	result := db.Model(&Proposal{}).Where("id = ? AND status = ?", p.ID, from).Update("status", to)
+	if result.RowsAffected == 0 {
+		return false
+	}
+	p.Status = common.StringOrNil(to)
+	return true
+}
@@ -0,0 +1,368 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package safe
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/network"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+// ProposalStatusPending indicates the proposal is awaiting confirmations
+const ProposalStatusPending = "pending"
+
+// ProposalStatusReady indicates the confirmation threshold is met and the proposal can be executed
+const ProposalStatusReady = "ready"
+
+// ProposalStatusSubmitted indicates the execTransaction tx was submitted; see Proposal.TransactionID;
+// the proposal returns to ready if the tx fails
+const ProposalStatusSubmitted = "submitted"
+
+// safeSignatureLength is the length of each packed owner signature, i.e., {bytes32 r}{bytes32 s}{uint8 v}
+const safeSignatureLength = 65
+
+// operationCall and operationDelegateCall are the supported Safe tx operations
+const operationCall = uint8(0)
+const operationDelegateCall = uint8(1)
+
+// safeABI is the subset of the Safe contract ABI used to propose and execute Safe txs
+const safeABI = `[
+	{"type":"function","name":"nonce","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"uint256"}]},
+	{"type":"function","name":"getThreshold","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"uint256"}]},
+	{"type":"function","name":"getOwners","stateMutability":"view","inputs":[],"outputs":[{"name":"","type":"address[]"}]},
+	{"type":"function","name":"getTransactionHash","stateMutability":"view","inputs":[{"name":"to","type":"address"},{"name":"value","type":"uint256"},{"name":"data","type":"bytes"},{"name":"operation","type":"uint8"},{"name":"safeTxGas","type":"uint256"},{"name":"baseGas","type":"uint256"},{"name":"gasPrice","type":"uint256"},{"name":"gasToken","type":"address"},{"name":"refundReceiver","type":"address"},{"name":"_nonce","type":"uint256"}],"outputs":[{"name":"","type":"bytes32"}]},
+	{"type":"function","name":"execTransaction","stateMutability":"payable","inputs":[{"name":"to","type":"address"},{"name":"value","type":"uint256"},{"name":"data","type":"bytes"},{"name":"operation","type":"uint8"},{"name":"safeTxGas","type":"uint256"},{"name":"baseGas","type":"uint256"},{"name":"gasPrice","type":"uint256"},{"name":"gasToken","type":"address"},{"name":"refundReceiver","type":"address"},{"name":"signatures","type":"bytes"}],"outputs":[{"name":"success","type":"bool"}]}
+]`
+
+var parsedSafeABI abi.ABI
+
+func init() {
+	var err error
+	parsedSafeABI, err = abi.JSON(strings.NewReader(safeABI))
+	if err != nil {
+		common.Log.Panicf("failed to parse Safe ABI; %s", err.Error())
+	}
+}
+
+// Proposal is a Safe tx proposed for M-of-N confirmation by the owners of a Safe
+type Proposal struct {
+	provide.Model
+	ApplicationID  *uuid.UUID `sql:"type:uuid" json:"application_id,omitempty"`
+	OrganizationID *uuid.UUID `sql:"type:uuid" json:"organization_id,omitempty"`
+	NetworkID      uuid.UUID  `sql:"not null;type:uuid" json:"network_id"`
+	ContractID     *uuid.UUID `sql:"type:uuid" json:"contract_id,omitempty"` // the Safe contract, if it is known to nchain
+	Address        *string    `sql:"not null" json:"address"`                // address of the Safe
+
+	// Safe tx fields
+	To             *string `sql:"not null" json:"to"`
+	Value          *string `sql:"not null;default:'0'" json:"value"`
+	Data           *string `json:"data,omitempty"`
+	Operation      uint8   `sql:"not null;default:0" json:"operation"`
+	SafeTxGas      *string `sql:"not null;default:'0'" json:"safe_tx_gas"`
+	BaseGas        *string `sql:"not null;default:'0'" json:"base_gas"`
+	GasPrice       *string `sql:"not null;default:'0'" json:"gas_price"`
+	GasToken       *string `json:"gas_token,omitempty"`
+	RefundReceiver *string `json:"refund_receiver,omitempty"`
+	Nonce          *uint64 `sql:"not null" json:"nonce"`
+
+	SafeTxHash  *string `sql:"not null" json:"safe_tx_hash"` // EIP-712 hash of the Safe tx which is confirmed by the owners
+	Threshold   uint64  `sql:"not null" json:"threshold"`    // number of confirmations required by the Safe at the time of the proposal
+	Status      *string `sql:"not null;default:'pending'" json:"status"`
+	Description *string `json:"description,omitempty"`
+
+	// Optional account or HD wallet which submits the execTransaction tx as soon as the threshold is met
+	ExecutorAccountID *uuid.UUID `sql:"type:uuid" json:"executor_account_id,omitempty"`
+	ExecutorWalletID  *uuid.UUID `sql:"type:uuid" json:"executor_wallet_id,omitempty"`
+	ExecutorPath      *string    `json:"executor_hd_derivation_path,omitempty"`
+
+	TransactionID *uuid.UUID `sql:"type:uuid" json:"transaction_id,omitempty"` // the execTransaction tx, once submitted
+
+	Confirmations []*Confirmation `sql:"-" json:"confirmations,omitempty"`
+}
+
+// TableName returns the table name of Safe proposals
+func (Proposal) TableName() string {
+	return "safe_proposals"
+}
+
+// Confirmation is the signature of a Safe owner over the Safe tx hash of a proposal
+type Confirmation struct {
+	provide.Model
+	ProposalID uuid.UUID `sql:"not null;type:uuid" json:"proposal_id"`
+	Owner      *string   `sql:"not null" json:"owner"`
+	Signature  *string   `sql:"not null" json:"signature"`
+
+	// Account or HD wallet which custodially signed the confirmation, if any
+	AccountID *uuid.UUID `sql:"type:uuid" json:"account_id,omitempty"`
+	WalletID  *uuid.UUID `sql:"type:uuid" json:"wallet_id,omitempty"`
+	Path      *string    `gorm:"column:hd_derivation_path" json:"hd_derivation_path,omitempty"`
+}
+
+// TableName returns the table name of Safe confirmations
+func (Confirmation) TableName() string {
+	return "safe_confirmations"
+}
+
+// safeCall invokes the given read-only Safe method on the given network and returns the unpacked outputs
+func safeCall(ntwrk *network.Network, address, method string, params ...interface{}) ([]interface{}, error) {
+	data, err := parsedSafeABI.Pack(method, params...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode Safe %s call; %s", method, err.Error())
+	}
+
+	client, err := ntwrk.EVMDialJSONRPC()
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial JSON-RPC for network: %s; %s", ntwrk.ID, err.Error())
+	}
+
+	to := ethcommon.HexToAddress(address)
+	result, err := client.CallContract(context.TODO(), ethereum.CallMsg{
+		To:   &to,
+		Data: data,
+	}, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call Safe %s on Safe: %s; %s", method, address, err.Error())
+	}
+
+	outputs, err := parsedSafeABI.Methods[method].Outputs.UnpackValues(result)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode Safe %s response from Safe: %s; %s", method, address, err.Error())
+	}
+
+	if len(outputs) != 1 {
+		return nil, fmt.Errorf("unexpected Safe %s response from Safe: %s", method, address)
+	}
+
+	return outputs, nil
+}
+
+// readNonce reads the current nonce of the Safe
+func readNonce(ntwrk *network.Network, address string) (uint64, error) {
+	outputs, err := safeCall(ntwrk, address, "nonce")
+	if err != nil {
+		return 0, err
+	}
+	return outputs[0].(*big.Int).Uint64(), nil
+}
+
+// readThreshold reads the number of confirmations required by the Safe
+func readThreshold(ntwrk *network.Network, address string) (uint64, error) {
+	outputs, err := safeCall(ntwrk, address, "getThreshold")
+	if err != nil {
+		return 0, err
+	}
+	return outputs[0].(*big.Int).Uint64(), nil
+}
+
+// readOwners reads the owners of the Safe
+func readOwners(ntwrk *network.Network, address string) ([]ethcommon.Address, error) {
+	outputs, err := safeCall(ntwrk, address, "getOwners")
+	if err != nil {
+		return nil, err
+	}
+	return outputs[0].([]ethcommon.Address), nil
+}
+
+// parseBigInt parses the given decimal or 0x-prefixed hex string; nil is parsed as zero
+func parseBigInt(val *string) (*big.Int, error) {
+	if val == nil || *val == "" {
+		return big.NewInt(0), nil
+	}
+
+	i, ok := new(big.Int).SetString(*val, 0)
+	if !ok || i.Sign() < 0 {
+		return nil, fmt.Errorf("invalid integer value: %s", *val)
+	}
+	return i, nil
+}
+
+// parseAddress parses the given address; nil is parsed as the zero address
+func parseAddress(addr *string) ethcommon.Address {
+	if addr == nil {
+		return ethcommon.Address{}
+	}
+	return ethcommon.HexToAddress(*addr)
+}
+
+// safeTxParams returns the ABI-encodable Safe tx fields, in the order in which they are passed
+// to getTransactionHash and execTransaction
+func (p *Proposal) safeTxParams() ([]interface{}, error) {
+	value, err := parseBigInt(p.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	safeTxGas, err := parseBigInt(p.SafeTxGas)
+	if err != nil {
+		return nil, err
+	}
+
+	baseGas, err := parseBigInt(p.BaseGas)
+	if err != nil {
+		return nil, err
+	}
+
+	gasPrice, err := parseBigInt(p.GasPrice)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	if p.Data != nil {
+		data, err = hexutil.Decode(*p.Data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid data; %s", err.Error())
+		}
+	}
+
+	return []interface{}{
+		ethcommon.HexToAddress(*p.To),
+		value,
+		data,
+		p.Operation,
+		safeTxGas,
+		baseGas,
+		gasPrice,
+		parseAddress(p.GasToken),
+		parseAddress(p.RefundReceiver),
+	}, nil
+}
+
+// resolveSafeTxHash reads the nonce, if none was given, and threshold of the Safe and resolves
+// the Safe tx hash which is confirmed by the owners
+func (p *Proposal) resolveSafeTxHash(ntwrk *network.Network) error {
+	if p.Nonce == nil {
+		nonce, err := readNonce(ntwrk, *p.Address)
+		if err != nil {
+			return err
+		}
+		p.Nonce = &nonce
+	}
+
+	threshold, err := readThreshold(ntwrk, *p.Address)
+	if err != nil {
+		return err
+	}
+	p.Threshold = threshold
+
+	params, err := p.safeTxParams()
+	if err != nil {
+		return err
+	}
+	params = append(params, new(big.Int).SetUint64(*p.Nonce))
+
+	outputs, err := safeCall(ntwrk, *p.Address, "getTransactionHash", params...)
+	if err != nil {
+		return err
+	}
+
+	hash := outputs[0].([32]byte)
+	p.SafeTxHash = common.StringOrNil(hexutil.Encode(hash[:]))
+	return nil
+}
+
+// execTransactionData returns the calldata of the execTransaction call which executes the
+// proposal using the given confirmations
+func (p *Proposal) execTransactionData(confirmations []*Confirmation) (*string, error) {
+	params, err := p.safeTxParams()
+	if err != nil {
+		return nil, err
+	}
+
+	signatures, err := packSignatures(confirmations)
+	if err != nil {
+		return nil, err
+	}
+	params = append(params, signatures)
+
+	data, err := parsedSafeABI.Pack("execTransaction", params...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode execTransaction; %s", err.Error())
+	}
+
+	return common.StringOrNil(hexutil.Encode(data)), nil
+}
+
+// packSignatures concatenates the signatures of the given confirmations ordered by ascending
+// owner address, as required by the Safe; an owner may only confirm once
+func packSignatures(confirmations []*Confirmation) ([]byte, error) {
+	sorted := make([]*Confirmation, len(confirmations))
+	copy(sorted, confirmations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(ethcommon.HexToAddress(*sorted[i].Owner).Bytes(), ethcommon.HexToAddress(*sorted[j].Owner).Bytes()) < 0
+	})
+
+	signatures := make([]byte, 0)
+	for i, confirmation := range sorted {
+		if i > 0 && ethcommon.HexToAddress(*confirmation.Owner) == ethcommon.HexToAddress(*sorted[i-1].Owner) {
+			return nil, fmt.Errorf("duplicate confirmation for owner: %s", *confirmation.Owner)
+		}
+
+		sig, err := hexutil.Decode(*confirmation.Signature)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signature for owner: %s; %s", *confirmation.Owner, err.Error())
+		}
+		if len(sig) != safeSignatureLength {
+			return nil, fmt.Errorf("invalid %d-byte signature for owner: %s", len(sig), *confirmation.Owner)
+		}
+		signatures = append(signatures, sig...)
+	}
+
+	return signatures, nil
+}
+
+// loadConfirmations loads the confirmations of the proposal
+func (p *Proposal) loadConfirmations(db *gorm.DB) {
+	p.Confirmations = make([]*Confirmation, 0)
+	db.Where("proposal_id = ?", p.ID).Order("created_at ASC").Find(&p.Confirmations)
+}
+
+// isOwner returns true if the given address is an owner of the Safe
+func isOwner(owners []ethcommon.Address, address string) bool {
+	for _, owner := range owners {
+		if strings.EqualFold(owner.Hex(), address) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseSafeTxHash returns the Safe tx hash as bytes
+func (p *Proposal) parseSafeTxHash() []byte {
+	return ethcommon.FromHex(*p.SafeTxHash)
+}
+
+// marshalParams returns the given tx params as raw JSON
+func marshalParams(params map[string]interface{}) *json.RawMessage {
+	paramsJSON, _ := json.Marshal(params)
+	rawParams := json.RawMessage(paramsJSON)
+	return &rawParams
+}
@@ -0,0 +1,144 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package safe
+
+import (
+	"bytes"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/internal/testutil"
+)
+
+func testConfirmation(owner string, fill byte) *Confirmation {
+	sig := bytes.Repeat([]byte{fill}, safeSignatureLength)
+	return &Confirmation{
+		Owner:     common.StringOrNil(owner),
+		Signature: common.StringOrNil(hexutil.Encode(sig)),
+	}
+}
+
+func TestPackSignaturesOrdersByAscendingOwner(t *testing.T) {
+	confirmations := []*Confirmation{
+		testConfirmation("0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359", 0x03),
+		testConfirmation("0x0000000000000000000000000000000000000002", 0x01),
+		testConfirmation("0xAb5801a7D398351b8bE11C439e05C5B3259aeC9B", 0x02),
+	}
+
+	packed, err := packSignatures(confirmations)
+	if err != nil {
+		t.Fatalf("failed to pack signatures; %s", err.Error())
+	}
+
+	if len(packed) != len(confirmations)*safeSignatureLength {
+		t.Fatalf("expected %d-byte packed signatures; got %d bytes", len(confirmations)*safeSignatureLength, len(packed))
+	}
+
+	// mixed-case (checksummed) owners are ordered by address rather than by string
+	for i, fill := range []byte{0x01, 0x02, 0x03} {
+		sig := packed[i*safeSignatureLength : (i+1)*safeSignatureLength]
+		if !bytes.Equal(sig, bytes.Repeat([]byte{fill}, safeSignatureLength)) {
+			t.Errorf("expected signature %d to be that of owner %d; got %x", i, fill, sig)
+		}
+	}
+
+	if *confirmations[0].Owner != "0xfb6916095ca1df60bb79ce92ce3ea74c37c5d359" {
+		t.Error("expected given confirmations to be left unsorted")
+	}
+}
+
+func TestPackSignaturesRejectsDuplicateOwners(t *testing.T) {
+	confirmations := []*Confirmation{
+		testConfirmation("0xAb5801a7D398351b8bE11C439e05C5B3259aeC9B", 0x01),
+		testConfirmation("0xab5801a7d398351b8be11c439e05c5b3259aec9b", 0x02),
+	}
+
+	if _, err := packSignatures(confirmations); err == nil {
+		t.Error("expected duplicate owner confirmations to be rejected")
+	}
+}
+
+func TestPackSignaturesRejectsInvalidSignatures(t *testing.T) {
+	confirmations := []*Confirmation{
+		{Owner: common.StringOrNil("0x0000000000000000000000000000000000000001"), Signature: common.StringOrNil("0xdeadbeef")},
+	}
+	if _, err := packSignatures(confirmations); err == nil {
+		t.Error("expected signature which is not 65 bytes to be rejected")
+	}
+
+	confirmations[0].Signature = common.StringOrNil("not hex")
+	if _, err := packSignatures(confirmations); err == nil {
+		t.Error("expected signature which is not hex-encoded to be rejected")
+	}
+}
+
+func TestPackSignaturesWithoutConfirmations(t *testing.T) {
+	packed, err := packSignatures([]*Confirmation{})
+	if err != nil || len(packed) != 0 {
+		t.Errorf("expected no packed signatures; got %x; %v", packed, err)
+	}
+}
+
+func TestConfirmAndExecuteProposalWithoutStatus(t *testing.T) {
+	p := &Proposal{}
+	p.ID, _ = uuid.NewV4()
+
+	if p.Confirm(nil, testConfirmation("0x0000000000000000000000000000000000000002", 0x01)) || len(p.Errors) == 0 {
+		t.Error("expected proposal without a status to not be confirmed")
+	}
+
+	accountID, _ := uuid.NewV4()
+	if p.Execute(nil, &accountID, nil, nil) || len(p.Errors) == 0 {
+		t.Error("expected proposal without a status to not be executed")
+	}
+}
+
+func TestCreateProposalScopesSafeContractToCaller(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	appID, _ := uuid.NewV4()
+	contractID, _ := uuid.NewV4()
+
+	mock.ExpectQuery(`SELECT \* FROM "contracts" WHERE .*\(id = \$1\) AND \(application_id = \$2\)`).
+		WithArgs(contractID, appID).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	p := &Proposal{ApplicationID: &appID, ContractID: &contractID}
+	if p.Create(db) {
+		t.Error("expected proposal for a Safe contract not owned by the caller to be rejected")
+	}
+}
+
+func TestResetFailedExecutionReturnsSubmittedProposalToReady(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	txID, _ := uuid.NewV4()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "safe_proposals" SET "status" = \$1 WHERE \(transaction_id = \$2 AND status = \$3\)`).
+		WithArgs(ProposalStatusReady, txID, ProposalStatusSubmitted).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := resetFailedExecution(db, txID); err != nil {
+		t.Errorf("failed to reset Safe proposal with failed execTransaction tx; %s", err.Error())
+	}
+}
@@ -0,0 +1,208 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package schedule
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	dbconf "github.com/kthomas/go-db-config"
+	natsutil "github.com/kthomas/go-natsutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/deadletter"
+)
+
+const defaultNatsStream = "nchain"
+
+// natsBlockFinalizedSubject is the subject on which the stats daemon publishes finalized blocks; scheduled
+// txs consume the subject using a dedicated durable consumer, independently of the network package
+const natsBlockFinalizedSubject = "nchain.block.finalized"
+const natsScheduleBlockFinalizedConsumer = "nchain.schedule.block.finalized"
+const natsScheduleBlockFinalizedMaxInFlight = 1024
+const natsScheduleBlockFinalizedMaxDeliveries = 10
+const scheduleBlockFinalizedAckWait = time.Second * 30
+
+// natsScheduledTxFailedSubject is the subject on which the tx package publishes failures to create
+// the txs published by scheduled txs
+const natsScheduledTxFailedSubject = "nchain.schedule.failed"
+const natsScheduledTxFailedMaxInFlight = 1024
+const natsScheduledTxFailedMaxDeliveries = 10
+const scheduledTxFailedAckWait = time.Second * 30
+
+// scheduleTickerInterval is the interval at which time-triggered txs which are due are fired
+const scheduleTickerInterval = time.Second * 15
+
+// scheduleTickerBatchSize is the maximum number of time-triggered txs fired per tick
+const scheduleTickerBatchSize = 256
+
+type natsBlockFinalizedMsg struct {
+	NetworkID *string `json:"network_id"`
+	Block     uint64  `json:"block"`
+}
+
+type natsScheduledTxFailedMsg struct {
+	ScheduledTransactionID *uuid.UUID `json:"scheduled_transaction_id"`
+	Run                    uint64     `json:"run"`
+	Error                  string     `json:"error"`
+}
+
+var waitGroup sync.WaitGroup
+
+func init() {
+	if !common.ConsumeNATSStreamingSubscriptions {
+		common.Log.Debug("Schedule package consumer configured to skip NATS streaming subscription setup")
+		return
+	}
+
+	natsutil.EstablishSharedNatsConnection(nil)
+	natsutil.NatsCreateStream(defaultNatsStream, []string{
+		fmt.Sprintf("%s.>", defaultNatsStream),
+	})
+
+	createNatsBlockFinalizedSubscriptions(&waitGroup)
+	createNatsScheduledTxFailedSubscriptions(&waitGroup)
+	runScheduleTicker()
+}
+
+func createNatsBlockFinalizedSubscriptions(wg *sync.WaitGroup) {
+	for i := uint64(0); i < natsutil.GetNatsConsumerConcurrency(); i++ {
+		natsutil.RequireNatsJetstreamSubscription(wg,
+			scheduleBlockFinalizedAckWait,
+			natsBlockFinalizedSubject,
+			natsScheduleBlockFinalizedConsumer,
+			natsScheduleBlockFinalizedConsumer,
+			consumeBlockFinalizedMsg,
+			scheduleBlockFinalizedAckWait,
+			natsScheduleBlockFinalizedMaxInFlight,
+			natsScheduleBlockFinalizedMaxDeliveries,
+			nil,
+		)
+	}
+}
+
+func createNatsScheduledTxFailedSubscriptions(wg *sync.WaitGroup) {
+	for i := uint64(0); i < natsutil.GetNatsConsumerConcurrency(); i++ {
+		natsutil.RequireNatsJetstreamSubscription(wg,
+			scheduledTxFailedAckWait,
+			natsScheduledTxFailedSubject,
+			natsScheduledTxFailedSubject,
+			natsScheduledTxFailedSubject,
+			consumeScheduledTxFailedMsg,
+			scheduledTxFailedAckWait,
+			natsScheduledTxFailedMaxInFlight,
+			natsScheduledTxFailedMaxDeliveries,
+			nil,
+		)
+	}
+}
+
+// runScheduleTicker periodically fires the time-triggered txs which are due
+func runScheduleTicker() {
+	ticker := time.NewTicker(scheduleTickerInterval)
+	go func() {
+		for range ticker.C {
+			fireDueScheduledTransactions()
+		}
+	}()
+}
+
+// fireDueScheduledTransactions fires the time-triggered txs for which the next run is due
+func fireDueScheduledTransactions() {
+	db := dbconf.DatabaseConnection()
+
+	var scheduled []*ScheduledTransaction
+	db.Where("status = ? AND next_run_at <= ?", statusScheduled, time.Now()).Order("next_run_at ASC").Limit(scheduleTickerBatchSize).Find(&scheduled)
+
+	for _, s := range scheduled {
+		err := s.fire(db)
+		if err != nil {
+			common.Log.Warningf("failed to fire scheduled tx %s; %s", s.ID, err.Error())
+		}
+	}
+}
+
+func consumeBlockFinalizedMsg(msg *nats.Msg) {
+	common.Log.Tracef("consuming %d-byte NATS block finalized message on subject: %s", len(msg.Data), msg.Subject)
+
+	blockFinalizedMsg := &natsBlockFinalizedMsg{}
+	err := json.Unmarshal(msg.Data, &blockFinalizedMsg)
+	if err != nil {
+		common.Log.Warningf("failed to unmarshal block finalized message; %s", err.Error())
+		deadletter.Term(msg, fmt.Sprintf("failed to unmarshal block finalized message; %s", err.Error()))
+		return
+	}
+
+	if blockFinalizedMsg.NetworkID == nil {
+		common.Log.Warningf("parsed %d-byte NATS block finalized message did not contain network id", len(msg.Data))
+		deadletter.Term(msg, fmt.Sprintf("parsed %d-byte NATS block finalized message did not contain network id", len(msg.Data)))
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	var scheduled []*ScheduledTransaction
+	db.Where("status = ? AND network_id = ? AND block <= ?", statusScheduled, blockFinalizedMsg.NetworkID, blockFinalizedMsg.Block).Order("block ASC").Find(&scheduled)
+
+	failed := false
+	for _, s := range scheduled {
+		err := s.fire(db)
+		if err != nil {
+			common.Log.Warningf("failed to fire scheduled tx %s at block %d; %s", s.ID, blockFinalizedMsg.Block, err.Error())
+			failed = true
+		}
+	}
+
+	if failed {
+		msg.Nak()
+		return
+	}
+	msg.Ack()
+}
+
+func consumeScheduledTxFailedMsg(msg *nats.Msg) {
+	common.Log.Tracef("consuming %d-byte NATS scheduled tx failed message on subject: %s", len(msg.Data), msg.Subject)
+
+	failedMsg := &natsScheduledTxFailedMsg{}
+	err := json.Unmarshal(msg.Data, &failedMsg)
+	if err != nil {
+		common.Log.Warningf("failed to unmarshal scheduled tx failed message; %s", err.Error())
+		deadletter.Term(msg, fmt.Sprintf("failed to unmarshal scheduled tx failed message; %s", err.Error()))
+		return
+	}
+
+	if failedMsg.ScheduledTransactionID == nil {
+		common.Log.Warningf("parsed %d-byte NATS scheduled tx failed message did not contain scheduled tx id", len(msg.Data))
+		deadletter.Term(msg, fmt.Sprintf("parsed %d-byte NATS scheduled tx failed message did not contain scheduled tx id", len(msg.Data)))
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	s := &ScheduledTransaction{}
+	s.ID = *failedMsg.ScheduledTransactionID
+	err = s.recordFailure(db, failedMsg.Run, failedMsg.Error)
+	if err != nil {
+		common.Log.Warning(err.Error())
+		msg.Nak()
+		return
+	}
+
+	msg.Ack()
+}
@@ -0,0 +1,94 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	cases := []struct {
+		expr     string
+		after    string
+		expected string // empty if the expression never matches
+	}{
+		{"*/15 * * * *", "2024-01-01T00:07:00Z", "2024-01-01T00:15:00Z"},
+		{"30 9 * * 1-5", "2024-01-05T10:00:00Z", "2024-01-08T09:30:00Z"},
+		{"0 12 * * 7", "2024-01-01T00:00:00Z", "2024-01-07T12:00:00Z"},
+		{"5 4 31 * *", "2024-02-01T00:00:00Z", "2024-03-31T04:05:00Z"},
+		{"0 0 1 1 *", "2024-06-01T00:00:00Z", "2025-01-01T00:00:00Z"},
+		{"@monthly", "2024-01-15T00:00:00Z", "2024-02-01T00:00:00Z"},
+		{"@hourly", "2024-01-15T10:59:59Z", "2024-01-15T11:00:00Z"},
+		{"0 0 29 2 *", "2024-03-01T00:00:00Z", "2028-02-29T00:00:00Z"},
+		{"0 0 30 2 *", "2024-01-01T00:00:00Z", ""},
+
+		// when both the day of month and day of week are restricted, a day matching either is matched
+		{"0 0 1,15 * 1", "2024-01-02T00:00:00Z", "2024-01-08T00:00:00Z"},
+		{"0 0 13 * 5", "2024-09-01T00:00:00Z", "2024-09-06T00:00:00Z"},
+
+		// a stepped asterisk is unrestricted, so a day must match both the day of month and day of week
+		{"0 0 */2 * 1", "2024-01-01T00:00:00Z", "2024-01-15T00:00:00Z"},
+		{"0 0 1 * */7", "2024-01-02T00:00:00Z", "2024-09-01T00:00:00Z"},
+	}
+
+	for _, c := range cases {
+		cron, err := parseCron(c.expr)
+		if err != nil {
+			t.Errorf("%s: failed to parse cron expression; %s", c.expr, err.Error())
+			continue
+		}
+
+		after, _ := time.Parse(time.RFC3339, c.after)
+		next := cron.next(after)
+		if c.expected == "" {
+			if next != nil {
+				t.Errorf("%s: expected cron expression to never match; got %s", c.expr, next.Format(time.RFC3339))
+			}
+			continue
+		}
+
+		if next == nil {
+			t.Errorf("%s: expected next run after %s at %s; got none", c.expr, c.after, c.expected)
+		} else if next.Format(time.RFC3339) != c.expected {
+			t.Errorf("%s: expected next run after %s at %s; got %s", c.expr, c.after, c.expected, next.Format(time.RFC3339))
+		}
+	}
+}
+
+func TestParseCronRejectsInvalidExpressions(t *testing.T) {
+	for _, expr := range []string{
+		"* * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"a * * * *",
+		"@every 5m",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("expected invalid cron expression to be rejected: %s", expr)
+		}
+	}
+}
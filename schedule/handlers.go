@@ -0,0 +1,240 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package schedule
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/contract"
+	provide "github.com/provideplatform/provide-go/common"
+	util "github.com/provideplatform/provide-go/common/util"
+)
+
+// InstallScheduledTransactionsAPI installs the handlers using the given gin Engine
+func InstallScheduledTransactionsAPI(r *gin.Engine) {
+	r.GET("/api/v1/scheduled_transactions", scheduledTransactionsListHandler)
+	r.POST("/api/v1/scheduled_transactions", createScheduledTransactionHandler)
+	r.GET("/api/v1/scheduled_transactions/:id", scheduledTransactionDetailsHandler)
+	r.POST("/api/v1/scheduled_transactions/:id/cancel", cancelScheduledTransactionHandler)
+	r.POST("/api/v1/scheduled_transactions/:id/reschedule", rescheduleScheduledTransactionHandler)
+}
+
+// authorizedScheduledTransactionQuery scopes the given query to scheduled txs owned by the authorized subject
+func authorizedScheduledTransactionQuery(db *gorm.DB, appID, orgID, userID *uuid.UUID) *gorm.DB {
+	if appID != nil {
+		db = db.Where("scheduled_transactions.application_id = ?", appID)
+	} else if orgID != nil {
+		db = db.Where("scheduled_transactions.organization_id = ?", orgID)
+	} else if userID != nil {
+		db = db.Where("scheduled_transactions.user_id = ?", userID)
+	}
+	return db
+}
+
+// resolveScheduledTransaction resolves the scheduled tx with the given id on behalf of the authorized subject
+func resolveScheduledTransaction(c *gin.Context, appID, orgID, userID *uuid.UUID) *ScheduledTransaction {
+	scheduled := &ScheduledTransaction{}
+	authorizedScheduledTransactionQuery(dbconf.DatabaseConnection(), appID, orgID, userID).Where("scheduled_transactions.id = ?", c.Param("id")).Find(&scheduled)
+	if scheduled == nil || scheduled.ID == uuid.Nil {
+		provide.RenderError("scheduled transaction not found", 404, c)
+		return nil
+	}
+	return scheduled
+}
+
+func scheduledTransactionsListHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	userID := util.AuthorizedSubjectID(c, "user")
+	if appID == nil && orgID == nil && userID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	query := authorizedScheduledTransactionQuery(dbconf.DatabaseConnection(), appID, orgID, userID)
+
+	if c.Query("status") != "" {
+		query = query.Where("scheduled_transactions.status = ?", c.Query("status"))
+	}
+
+	if c.Query("kind") != "" {
+		query = query.Where("scheduled_transactions.kind = ?", c.Query("kind"))
+	}
+
+	if c.Query("network_id") != "" {
+		query = query.Where("scheduled_transactions.network_id = ?", c.Query("network_id"))
+	}
+
+	if c.Query("contract_id") != "" {
+		query = query.Where("scheduled_transactions.contract_id = ?", c.Query("contract_id"))
+	}
+
+	var scheduled []*ScheduledTransaction
+	query = query.Order("scheduled_transactions.created_at DESC")
+	provide.Paginate(c, query, &ScheduledTransaction{}).Find(&scheduled)
+	provide.Render(scheduled, 200, c)
+}
+
+func scheduledTransactionDetailsHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	userID := util.AuthorizedSubjectID(c, "user")
+	if appID == nil && orgID == nil && userID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	scheduled := resolveScheduledTransaction(c, appID, orgID, userID)
+	if scheduled == nil {
+		return
+	}
+
+	provide.Render(scheduled, 200, c)
+}
+
+func createScheduledTransactionHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	userID := util.AuthorizedSubjectID(c, "user")
+	if appID == nil && orgID == nil && userID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	scheduled := &ScheduledTransaction{}
+	err = json.Unmarshal(buf, scheduled)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+	scheduled.ID = uuid.Nil
+	scheduled.ApplicationID = appID
+	scheduled.OrganizationID = orgID
+	scheduled.UserID = userID
+	scheduled.Status = nil
+	scheduled.LastRunAt = nil
+	scheduled.Runs = 0
+	scheduled.Error = nil
+
+	if scheduled.ContractID != nil {
+		cntract := &contract.Contract{}
+		dbconf.DatabaseConnection().Where("id = ?", scheduled.ContractID).Find(&cntract)
+		if cntract == nil || cntract.ID == uuid.Nil {
+			provide.RenderError("contract not found", 404, c)
+			return
+		}
+
+		validApp := appID != nil && cntract.ApplicationID != nil && *cntract.ApplicationID == *appID
+		validOrg := orgID != nil && cntract.OrganizationID != nil && *cntract.OrganizationID == *orgID
+		if !validApp && !validOrg {
+			provide.RenderError("forbidden", 403, c)
+			return
+		}
+
+		if scheduled.NetworkID != uuid.Nil && scheduled.NetworkID != cntract.NetworkID {
+			provide.RenderError(fmt.Sprintf("contract %s is not deployed on network %s", cntract.ID, scheduled.NetworkID), 422, c)
+			return
+		}
+		scheduled.NetworkID = cntract.NetworkID
+	}
+
+	if scheduled.Create() {
+		provide.Render(scheduled, 201, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = scheduled.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+func cancelScheduledTransactionHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	userID := util.AuthorizedSubjectID(c, "user")
+	if appID == nil && orgID == nil && userID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	scheduled := resolveScheduledTransaction(c, appID, orgID, userID)
+	if scheduled == nil {
+		return
+	}
+
+	if !scheduled.Cancel() {
+		provide.RenderError("only scheduled transactions which have not fired can be cancelled", 409, c)
+		return
+	}
+
+	provide.Render(scheduled, 200, c)
+}
+
+func rescheduleScheduledTransactionHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	userID := util.AuthorizedSubjectID(c, "user")
+	if appID == nil && orgID == nil && userID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	trigger := &struct {
+		RunAt *time.Time `json:"run_at"`
+		Cron  *string    `json:"cron"`
+		Block *uint64    `json:"block"`
+	}{}
+	err = json.Unmarshal(buf, trigger)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	scheduled := resolveScheduledTransaction(c, appID, orgID, userID)
+	if scheduled == nil {
+		return
+	}
+
+	if scheduled.Status == nil || *scheduled.Status != statusScheduled {
+		provide.RenderError("only scheduled transactions which have not fired can be rescheduled", 409, c)
+		return
+	}
+
+	if scheduled.Reschedule(trigger.RunAt, trigger.Cron, trigger.Block) {
+		provide.Render(scheduled, 200, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = scheduled.Errors
+		provide.Render(obj, 422, c)
+	}
+}
@@ -0,0 +1,387 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package schedule
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	natsutil "github.com/kthomas/go-natsutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/contract"
+	"github.com/provideplatform/nchain/tx"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+const natsTxSubject = "nchain.tx"
+const natsTxCreateSubject = "nchain.tx.create"
+
+// KindTransaction is a scheduled tx which is created as if it were posted to the transactions API
+const KindTransaction = "transaction"
+
+// KindExecution is a scheduled contract execution
+const KindExecution = "execution"
+
+const statusScheduled = "scheduled"
+const statusFired = "fired"
+const statusCancelled = "cancelled"
+const statusFailed = "failed"
+
+// ScheduledTransaction is a tx or contract execution which is held by nchain and published to the
+// tx pipeline when its trigger fires; the trigger is exactly one of a wall-clock time, a cron
+// expression for recurring txs or a block height
+type ScheduledTransaction struct {
+	provide.Model
+	ApplicationID  *uuid.UUID `sql:"type:uuid" json:"application_id,omitempty"`
+	OrganizationID *uuid.UUID `sql:"type:uuid" json:"organization_id,omitempty"`
+	UserID         *uuid.UUID `sql:"type:uuid" json:"user_id,omitempty"`
+	NetworkID      uuid.UUID  `sql:"not null;type:uuid" json:"network_id"`
+	ContractID     *uuid.UUID `sql:"type:uuid" json:"contract_id,omitempty"` // contract to execute, when kind is execution
+
+	Kind        *string          `sql:"not null" json:"kind"`
+	Payload     *json.RawMessage `sql:"type:json;not null" json:"payload"` // the tx, or the contract execution, as it would be posted to the API
+	Description *string          `json:"description,omitempty"`
+
+	// Trigger
+	RunAt *time.Time `json:"run_at,omitempty"`
+	Cron  *string    `json:"cron,omitempty"`  // standard 5-field cron expression, evaluated in UTC
+	Block *uint64    `json:"block,omitempty"` // block height at or after which the trigger fires
+
+	Status    *string    `sql:"not null;default:'scheduled'" json:"status"`
+	NextRunAt *time.Time `json:"next_run_at,omitempty"`
+	LastRunAt *time.Time `json:"last_run_at,omitempty"`
+	Runs      uint64     `sql:"not null" json:"runs"`
+	Error     *string    `json:"error,omitempty"` // error which occurred when the trigger last fired, if any
+}
+
+// Create and persist a new scheduled tx
+func (s *ScheduledTransaction) Create() bool {
+	if !s.Validate() {
+		return false
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	if db.NewRecord(s) {
+		result := db.Create(&s)
+		rowsAffected := result.RowsAffected
+		errors := result.GetErrors()
+		if len(errors) > 0 {
+			for _, err := range errors {
+				s.Errors = append(s.Errors, &provide.Error{
+					Message: common.StringOrNil(err.Error()),
+				})
+			}
+		}
+		if !db.NewRecord(s) {
+			return rowsAffected > 0
+		}
+	}
+	return false
+}
+
+// Validate a scheduled tx for persistence; the next run of a time-triggered tx is resolved
+func (s *ScheduledTransaction) Validate() bool {
+	s.Errors = make([]*provide.Error, 0)
+
+	if s.ApplicationID == nil && s.OrganizationID == nil && s.UserID == nil {
+		s.Errors = append(s.Errors, &provide.Error{
+			Message: common.StringOrNil("scheduled tx must be associated with an application, organization or user"),
+		})
+	}
+
+	if s.NetworkID == uuid.Nil {
+		s.Errors = append(s.Errors, &provide.Error{
+			Message: common.StringOrNil("unable to schedule tx on unspecified network"),
+		})
+	}
+
+	if s.Kind == nil || (*s.Kind != KindTransaction && *s.Kind != KindExecution) {
+		s.Errors = append(s.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("kind must be one of %s or %s", KindTransaction, KindExecution)),
+		})
+	} else if *s.Kind == KindExecution && s.ContractID == nil {
+		s.Errors = append(s.Errors, &provide.Error{
+			Message: common.StringOrNil("contract_id required to schedule a contract execution"),
+		})
+	}
+
+	if payload, err := s.parsePayload(); err != nil {
+		s.Errors = append(s.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+	} else if s.Kind != nil && *s.Kind == KindTransaction && len(s.Errors) == 0 {
+		// the tx is validated as it will be created when the trigger fires, so an invalid tx is never scheduled
+		raw, _ := json.Marshal(s.transactionPayload(payload))
+		for _, err := range tx.ValidateRequest(raw) {
+			s.Errors = append(s.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("invalid scheduled tx; %s", *err.Message)),
+			})
+		}
+	} else if s.Kind != nil && *s.Kind == KindExecution {
+		execution := &contract.Execution{}
+		err := json.Unmarshal(*s.Payload, &execution)
+		if err != nil {
+			s.Errors = append(s.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("invalid contract execution payload; %s", err.Error())),
+			})
+		} else if execution.Method == "" {
+			s.Errors = append(s.Errors, &provide.Error{
+				Message: common.StringOrNil("contract execution method required"),
+			})
+		}
+	}
+
+	if err := s.resolveNextRun(time.Now()); err != nil {
+		s.Errors = append(s.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+	}
+
+	return len(s.Errors) == 0
+}
+
+// parsePayload returns the payload of the scheduled tx
+func (s *ScheduledTransaction) parsePayload() (map[string]interface{}, error) {
+	if s.Payload == nil {
+		return nil, fmt.Errorf("scheduled tx payload required")
+	}
+
+	var payload map[string]interface{}
+	err := json.Unmarshal(*s.Payload, &payload)
+	if err != nil || payload == nil {
+		return nil, fmt.Errorf("scheduled tx payload must be a JSON object")
+	}
+	return payload, nil
+}
+
+// resolveNextRun validates the trigger and sets the time at which a time-triggered tx next runs
+func (s *ScheduledTransaction) resolveNextRun(after time.Time) error {
+	triggers := 0
+	for _, set := range []bool{s.RunAt != nil, s.Cron != nil, s.Block != nil} {
+		if set {
+			triggers++
+		}
+	}
+	if triggers != 1 {
+		return fmt.Errorf("exactly one of run_at, cron or block required")
+	}
+
+	s.NextRunAt = nil
+	if s.RunAt != nil {
+		s.NextRunAt = s.RunAt
+	} else if s.Cron != nil {
+		cron, err := parseCron(*s.Cron)
+		if err != nil {
+			return err
+		}
+		s.NextRunAt = cron.next(after)
+		if s.NextRunAt == nil {
+			return fmt.Errorf("cron expression never matches: %s", *s.Cron)
+		}
+	}
+
+	return nil
+}
+
+// Reschedule replaces the trigger of a scheduled tx which has not fired
+func (s *ScheduledTransaction) Reschedule(runAt *time.Time, cron *string, block *uint64) bool {
+	s.Errors = make([]*provide.Error, 0)
+
+	s.RunAt = runAt
+	s.Cron = cron
+	s.Block = block
+	if err := s.resolveNextRun(time.Now()); err != nil {
+		s.Errors = append(s.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+		return false
+	}
+
+	db := dbconf.DatabaseConnection()
+	result := db.Model(&ScheduledTransaction{}).Where("id = ? AND status = ? AND runs = ?", s.ID, statusScheduled, s.Runs).Updates(map[string]interface{}{
+		"run_at":      s.RunAt,
+		"cron":        s.Cron,
+		"block":       s.Block,
+		"next_run_at": s.NextRunAt,
+	})
+	if result.Error != nil {
+		s.Errors = append(s.Errors, &provide.Error{
+			Message: common.StringOrNil(result.Error.Error()),
+		})
+		return false
+	} else if result.RowsAffected == 0 {
+		s.Errors = append(s.Errors, &provide.Error{
+			Message: common.StringOrNil("scheduled tx fired or was cancelled before it was rescheduled"),
+		})
+		return false
+	}
+
+	return true
+}
+
+// Cancel a scheduled tx which has not fired; returns false if it fired or was already cancelled
+func (s *ScheduledTransaction) Cancel() bool {
+	db := dbconf.DatabaseConnection()
+	result := db.Model(&ScheduledTransaction{}).Where("id = ? AND status = ?", s.ID, statusScheduled).Updates(map[string]interface{}{
+		"status":      statusCancelled,
+		"next_run_at": nil,
+	})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false
+	}
+
+	s.Status = common.StringOrNil(statusCancelled)
+	s.NextRunAt = nil
+	return true
+}
+
+// fire publishes the scheduled tx to the tx pipeline. The scheduled tx is claimed prior to being
+// published, so it fires at most once per run even when several consumers observe the trigger; a
+// recurring tx remains scheduled until its next run.
+func (s *ScheduledTransaction) fire(db *gorm.DB) error {
+	subject, msg, err := s.message()
+	if err != nil {
+		s.fail(db, err)
+		return err
+	}
+
+	status := statusFired
+	var nextRunAt *time.Time
+	if s.Cron != nil {
+		cron, err := parseCron(*s.Cron)
+		if err == nil {
+			nextRunAt = cron.next(time.Now())
+		}
+		if nextRunAt != nil {
+			status = statusScheduled
+		}
+	}
+
+	now := time.Now()
+	result := db.Model(&ScheduledTransaction{}).Where("id = ? AND status = ? AND runs = ?", s.ID, statusScheduled, s.Runs).Updates(map[string]interface{}{
+		"status":      status,
+		"next_run_at": nextRunAt,
+		"last_run_at": now,
+		"runs":        s.Runs + 1,
+		"error":       nil,
+	})
+	if result.Error != nil {
+		return fmt.Errorf("failed to claim scheduled tx %s; %s", s.ID, result.Error.Error())
+	} else if result.RowsAffected == 0 {
+		common.Log.Debugf("scheduled tx %s was fired, rescheduled or cancelled concurrently", s.ID)
+		return nil
+	}
+
+	_, err = natsutil.NatsJetstreamPublish(subject, msg)
+	if err != nil {
+		// release the claim so the trigger fires again
+		db.Model(&ScheduledTransaction{}).Where("id = ? AND runs = ?", s.ID, s.Runs+1).Updates(map[string]interface{}{
+			"status":      statusScheduled,
+			"next_run_at": s.NextRunAt,
+			"last_run_at": s.LastRunAt,
+			"runs":        s.Runs,
+			"error":       err.Error(),
+		})
+		return fmt.Errorf("failed to publish scheduled tx %s; %s", s.ID, err.Error())
+	}
+
+	common.Log.Debugf("fired scheduled %s %s; published %d-byte message on subject: %s", *s.Kind, s.ID, len(msg), subject)
+	s.Status = common.StringOrNil(status)
+	s.NextRunAt = nextRunAt
+	s.LastRunAt = &now
+	s.Runs++
+	return nil
+}
+
+// fail records the given error, which prevents the scheduled tx from ever being published
+func (s *ScheduledTransaction) fail(db *gorm.DB, err error) {
+	common.Log.Warningf("scheduled tx %s cannot be fired; %s", s.ID, err.Error())
+	db.Model(&ScheduledTransaction{}).Where("id = ? AND status = ?", s.ID, statusScheduled).Updates(map[string]interface{}{
+		"status":      statusFailed,
+		"next_run_at": nil,
+		"error":       err.Error(),
+	})
+}
+
+// message returns the subject and message which are published to the tx pipeline when the trigger
+// fires; a tx is published to nchain.tx.create and a contract execution is published to nchain.tx
+func (s *ScheduledTransaction) message() (string, []byte, error) {
+	payload, err := s.parsePayload()
+	if err != nil {
+		return "", nil, err
+	}
+
+	publishedAt := time.Now()
+
+	if *s.Kind == KindExecution {
+		execution := &contract.Execution{}
+		raw, _ := json.Marshal(payload)
+		err := json.Unmarshal(raw, &execution)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to unmarshal scheduled contract execution; %s", err.Error())
+		}
+
+		execution.ContractID = s.ContractID
+		execution.PublishedAt = &publishedAt
+		if execution.Ref == nil {
+			ref, _ := uuid.NewV4()
+			execution.Ref = common.StringOrNil(ref.String())
+		}
+
+		msg, err := json.Marshal(execution)
+		return natsTxSubject, msg, err
+	}
+
+	msg, err := json.Marshal(map[string]interface{}{
+		"transaction":              s.transactionPayload(payload),
+		"published_at":             publishedAt,
+		"scheduled_transaction_id": s.ID,
+		"run":                      s.Runs + 1,
+	})
+	return natsTxCreateSubject, msg, err
+}
+
+// transactionPayload returns the given payload of a scheduled tx as it is created when the
+// trigger fires, i.e., on behalf of the owner of the scheduled tx on its network
+func (s *ScheduledTransaction) transactionPayload(payload map[string]interface{}) map[string]interface{} {
+	payload["application_id"] = s.ApplicationID
+	payload["organization_id"] = s.OrganizationID
+	payload["user_id"] = s.UserID
+	payload["network_id"] = s.NetworkID
+	return payload
+}
+
+// recordFailure records the given error which prevented the tx published by the given run from
+// being created; a scheduled tx which does not recur is failed
+func (s *ScheduledTransaction) recordFailure(db *gorm.DB, run uint64, errmsg string) error {
+	result := db.Model(&ScheduledTransaction{}).Where("id = ? AND runs = ?", s.ID, run).Update("error", errmsg)
+	if result.Error != nil {
+		return fmt.Errorf("failed to record failure of scheduled tx %s; %s", s.ID, result.Error.Error())
+	}
+
+	result = db.Model(&ScheduledTransaction{}).Where("id = ? AND runs = ? AND status = ?", s.ID, run, statusFired).Update("status", statusFailed)
+	if result.Error != nil {
+		return fmt.Errorf("failed to record failure of scheduled tx %s; %s", s.ID, result.Error.Error())
+	}
+
+	common.Log.Warningf("run %d of scheduled tx %s failed; %s", run, s.ID, errmsg)
+	return nil
+}
@@ -0,0 +1,103 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package schedule
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/internal/testutil"
+)
+
+func TestMessageIdentifiesScheduledTxRun(t *testing.T) {
+	appID, _ := uuid.NewV4()
+	networkID, _ := uuid.NewV4()
+	payload := json.RawMessage(`{"to": "0x0000000000000000000000000000000000000001", "application_id": "spoofed"}`)
+
+	s := &ScheduledTransaction{
+		ApplicationID: &appID,
+		NetworkID:     networkID,
+		Kind:          common.StringOrNil(KindTransaction),
+		Payload:       &payload,
+		Runs:          2,
+	}
+	s.ID, _ = uuid.NewV4()
+
+	subject, msg, err := s.message()
+	if err != nil {
+		t.Fatalf("failed to build scheduled tx message; %s", err.Error())
+	}
+	if subject != natsTxCreateSubject {
+		t.Errorf("expected scheduled tx to be published on %s; got %s", natsTxCreateSubject, subject)
+	}
+
+	var published struct {
+		Transaction            map[string]interface{} `json:"transaction"`
+		ScheduledTransactionID string                 `json:"scheduled_transaction_id"`
+		Run                    uint64                 `json:"run"`
+	}
+	json.Unmarshal(msg, &published)
+
+	if published.ScheduledTransactionID != s.ID.String() || published.Run != 3 {
+		t.Errorf("expected message to identify run 3 of scheduled tx %s; got run %d of %s", s.ID, published.Run, published.ScheduledTransactionID)
+	}
+	if published.Transaction["application_id"] != appID.String() || published.Transaction["network_id"] != networkID.String() {
+		t.Errorf("expected tx to be created on behalf of the owner of the scheduled tx; got %v", published.Transaction)
+	}
+}
+
+func TestRecordFailureFailsScheduledTxRun(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	s := &ScheduledTransaction{}
+	s.ID, _ = uuid.NewV4()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "scheduled_transactions" SET "error" = \$1 WHERE \(id = \$2 AND runs = \$3\)`).
+		WithArgs("tx rejected by policy", s.ID, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "scheduled_transactions" SET "status" = \$1 WHERE \(id = \$2 AND runs = \$3 AND status = \$4\)`).
+		WithArgs(statusFailed, s.ID, 1, statusFired).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := s.recordFailure(db, 1, "tx rejected by policy"); err != nil {
+		t.Errorf("failed to record failure of scheduled tx; %s", err.Error())
+	}
+}
+
+func TestRecordFailureReturnsDBError(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	s := &ScheduledTransaction{}
+	s.ID, _ = uuid.NewV4()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "scheduled_transactions"`).WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+
+	if err := s.recordFailure(db, 1, "tx rejected by policy"); err == nil {
+		t.Error("expected failure to record the failure of a scheduled tx to be returned")
+	}
+}
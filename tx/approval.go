@@ -0,0 +1,251 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package tx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	natsutil "github.com/kthomas/go-natsutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/policy"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+const txStatusAwaitingApproval = "awaiting_approval"
+const txStatusApproved = "approved"
+const txStatusRejected = "rejected"
+
+// errApprovalConflict is returned when an approver attempts to approve or reject a tx more than once
+var errApprovalConflict = errors.New("approver has already approved or rejected the tx")
+
+// errApprovalForbidden is returned when a user who is not an approver attempts to approve or reject a tx
+var errApprovalForbidden = errors.New("user is not an approver of the tx")
+
+// errApprovalByCreator is returned when the user who created a tx attempts to approve it
+var errApprovalByCreator = errors.New("tx cannot be approved by the user who created it")
+
+// Approval is the decision of an approver to approve or reject a tx which is awaiting approval
+type Approval struct {
+	provide.Model
+	TransactionID uuid.UUID `sql:"not null;type:uuid" json:"transaction_id"`
+	UserID        uuid.UUID `sql:"not null;type:uuid" json:"user_id"`
+	Approved      bool      `sql:"not null" json:"approved"`
+	Reason        *string   `json:"reason,omitempty"`
+}
+
+// TableName returns the table name of tx approvals
+func (Approval) TableName() string {
+	return "tx_approvals"
+}
+
+// requiresApproval returns true if the tx matches an approval rule and has not been approved;
+// the matched approval rule is resolved once per tx. An error is returned if the approval rules
+// cannot be resolved, in which case the tx must not be signed.
+func (t *Transaction) requiresApproval(db *gorm.DB) (bool, error) {
+	if t.approved || (t.AccountID == nil && t.WalletID == nil) {
+		return false, nil
+	}
+
+	if !t.approvalRuleResolved {
+		var value *big.Int
+		if t.Value != nil {
+			value = t.Value.BigInt()
+		}
+
+		rule, err := policy.MatchApprovalRule(db, &policy.Intent{
+			ApplicationID:  t.ApplicationID,
+			OrganizationID: t.OrganizationID,
+			AccountID:      t.AccountID,
+			WalletID:       t.WalletID,
+			NetworkID:      t.NetworkID,
+			To:             t.To,
+			Value:          value,
+			Data:           t.Data,
+		})
+		if err != nil {
+			return false, err
+		}
+
+		t.approvalRule = rule
+		t.approvalRuleResolved = true
+	}
+
+	return t.approvalRule != nil, nil
+}
+
+// awaitApproval persists the tx in the awaiting_approval status instead of signing it; the tx params
+// are persisted so the tx can be signed as requested once it is approved. A nonce reserved for the
+// tx (i.e., when it was queued in a batch) is released, since the tx may never be signed.
+func (t *Transaction) awaitApproval(db *gorm.DB) bool {
+	if t.AccountID != nil && *t.AccountID == uuid.Nil {
+		t.AccountID = nil
+	}
+	if t.WalletID != nil && *t.WalletID == uuid.Nil {
+		t.WalletID = nil
+	}
+
+	t.releaseNonce()
+
+	t.Status = common.StringOrNil(txStatusAwaitingApproval)
+	t.ApprovalRuleID = &t.approvalRule.ID
+	t.PendingParams = t.Params
+
+	var result *gorm.DB
+	if t.held {
+		result = db.Save(&t)
+	} else {
+		result = db.Create(&t)
+	}
+	errors := result.GetErrors()
+	if len(errors) > 0 {
+		for _, err := range errors {
+			t.Errors = append(t.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+		}
+		t.retryable = true
+		return false
+	}
+
+	common.Log.Debugf("tx %s matched approval rule %s; awaiting approval", t.ID, t.approvalRule.ID)
+	return !db.NewRecord(t)
+}
+
+// isApprover returns true if the given user is an approver of the approval rule which the tx matched
+func (t *Transaction) isApprover(db *gorm.DB, userID uuid.UUID) bool {
+	if t.ApprovalRuleID == nil {
+		return false
+	}
+
+	rule := &policy.ApprovalRule{}
+	db.Where("id = ?", t.ApprovalRuleID).Find(&rule)
+	return rule.ID != uuid.Nil && rule.IsApprover(userID)
+}
+
+// approve records the decision of the given approver; the tx is rejected as soon as any approver
+// rejects it, and is published to be signed and broadcast once the required approvals are given
+func (t *Transaction) approve(db *gorm.DB, userID uuid.UUID, approved bool, reason *string) (*Approval, error) {
+	if t.Status == nil || *t.Status != txStatusAwaitingApproval {
+		return nil, fmt.Errorf("tx %s is not awaiting approval", t.ID)
+	}
+
+	rule := &policy.ApprovalRule{}
+	if t.ApprovalRuleID != nil {
+		db.Where("id = ?", t.ApprovalRuleID).Find(&rule)
+	}
+	if rule == nil || rule.ID == uuid.Nil {
+		return nil, fmt.Errorf("failed to resolve approval rule of tx %s", t.ID)
+	}
+
+	if !rule.IsApprover(userID) {
+		return nil, errApprovalForbidden
+	}
+
+	// separation of duties; the creator of a tx may reject, but never approve, it
+	if approved && t.UserID != nil && *t.UserID == userID {
+		return nil, errApprovalByCreator
+	}
+
+	approval := &Approval{
+		TransactionID: t.ID,
+		UserID:        userID,
+		Approved:      approved,
+		Reason:        reason,
+	}
+
+	result := db.Create(&approval)
+	if result.Error != nil {
+		if strings.Contains(result.Error.Error(), "duplicate key") {
+			return nil, errApprovalConflict
+		}
+		return nil, fmt.Errorf("failed to persist approval of tx %s; %s", t.ID, result.Error.Error())
+	}
+
+	if !approved {
+		desc := fmt.Sprintf("rejected by approver %s", userID)
+		if reason != nil {
+			desc = fmt.Sprintf("%s; %s", desc, *reason)
+		}
+		if t.transition(db, txStatusAwaitingApproval, txStatusRejected, &desc) {
+			common.Log.Debugf("tx %s rejected by approver %s", t.ID, userID)
+		}
+		return approval, nil
+	}
+
+	// only the approvals given by the current approvers of the rule count towards the required approvals
+	approvers, err := rule.ParseApprovers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve approvers of tx %s; %s", t.ID, err.Error())
+	}
+
+	var approvals int
+	db.Model(&Approval{}).Where("transaction_id = ? AND approved = ? AND user_id IN (?)", t.ID, true, approvers).Count(&approvals)
+	if approvals < rule.RequiredApprovals {
+		common.Log.Debugf("tx %s approved by approver %s; %d of %d required approval(s) given", t.ID, userID, approvals, rule.RequiredApprovals)
+		return approval, nil
+	}
+
+	if !t.transition(db, txStatusAwaitingApproval, txStatusApproved, nil) {
+		// the tx was rejected, or the required approvals were given, concurrently
+		return approval, nil
+	}
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"transaction_id": t.ID.String(),
+		"published_at":   time.Now(),
+	})
+	_, err = natsutil.NatsJetstreamPublish(natsTxCreateSubject, payload)
+	if err != nil {
+		t.transition(db, txStatusApproved, txStatusAwaitingApproval, nil)
+		return nil, fmt.Errorf("failed to publish approved tx %s; %s", t.ID, err.Error())
+	}
+
+	common.Log.Debugf("tx %s approved by %d approver(s); published for signing", t.ID, approvals)
+	return approval, nil
+}
+
+// transition atomically updates the status of the tx from the given status; returns false
+// if the tx was not in the given status. The description is left unchanged when nil.
+func (t *Transaction) transition(db *gorm.DB, from, to string, description *string) bool {
+	updates := map[string]interface{}{
+		"status": to,
+	}
+	if description != nil {
+		updates["description"] = description
+	}
+
+	result := db.Model(&Transaction{}).Where("id = ? AND status = ?", t.ID, from).Updates(updates)
+	if result.Error != nil {
+		common.Log.Warningf("failed to update status of tx %s from %s to %s; %s", t.ID, from, to, result.Error.Error())
+		return false
+	}
+	if result.RowsAffected == 0 {
+		return false
+	}
+
+	t.Status = common.StringOrNil(to)
+	if description != nil {
+		t.Description = description
+	}
+	return true
+}
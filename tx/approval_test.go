@@ -0,0 +1,202 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tx
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/internal/testutil"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+func awaitingApprovalTx(creatorID uuid.UUID) *Transaction {
+	ruleID, _ := uuid.NewV4()
+	tx := &Transaction{
+		UserID:         &creatorID,
+		Status:         common.StringOrNil(txStatusAwaitingApproval),
+		ApprovalRuleID: &ruleID,
+	}
+	tx.ID, _ = uuid.NewV4()
+	return tx
+}
+
+func expectApprovalRule(mock sqlmock.Sqlmock, tx *Transaction, approvers ...uuid.UUID) {
+	approversJSON, _ := json.Marshal(approvers)
+	mock.ExpectQuery(`SELECT \* FROM "approval_rules" WHERE \(id = \$1\)`).
+		WithArgs(*tx.ApprovalRuleID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "approvers", "required_approvals"}).AddRow(*tx.ApprovalRuleID, approversJSON, 1))
+}
+
+func TestApproveRejectsApprovalByCreator(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	creatorID, _ := uuid.NewV4()
+	tx := awaitingApprovalTx(creatorID)
+
+	expectApprovalRule(mock, tx, creatorID)
+
+	_, err := tx.approve(db, creatorID, true, nil)
+	if err != errApprovalByCreator {
+		t.Errorf("expected approval by the creator of the tx to be forbidden; got %v", err)
+	}
+}
+
+func TestApproveAllowsRejectionByCreator(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	creatorID, _ := uuid.NewV4()
+	tx := awaitingApprovalTx(creatorID)
+
+	expectApprovalRule(mock, tx, creatorID)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "tx_approvals"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.Nil))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "transactions" SET .* WHERE \(id = \$\d+ AND status = \$\d+\)`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	approval, err := tx.approve(db, creatorID, false, nil)
+	if err != nil {
+		t.Fatalf("expected creator to be able to reject the tx; %s", err.Error())
+	}
+	if approval.Approved || *tx.Status != txStatusRejected {
+		t.Errorf("expected tx to be rejected; status: %s", *tx.Status)
+	}
+}
+
+func TestApproveRejectsApprovalByNonApprover(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	creatorID, _ := uuid.NewV4()
+	approverID, _ := uuid.NewV4()
+	userID, _ := uuid.NewV4()
+	tx := awaitingApprovalTx(creatorID)
+
+	expectApprovalRule(mock, tx, approverID)
+
+	_, err := tx.approve(db, userID, true, nil)
+	if err != errApprovalForbidden {
+		t.Errorf("expected approval by a user who is not an approver to be forbidden; got %v", err)
+	}
+}
+
+func TestRequiresApprovalPropagatesResolutionError(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	appID, _ := uuid.NewV4()
+	accountID, _ := uuid.NewV4()
+	tx := &Transaction{ApplicationID: &appID, AccountID: &accountID}
+
+	mock.ExpectQuery(`SELECT \* FROM "approval_rules"`).WillReturnError(errors.New("connection refused"))
+
+	requiresApproval, err := tx.requiresApproval(db)
+	if err == nil || requiresApproval {
+		t.Fatal("expected approval rule resolution failure to be returned")
+	}
+	if tx.approvalRuleResolved {
+		t.Error("expected approval rule to remain unresolved")
+	}
+}
+
+func TestResolveHeldCreateFailureRetriesRetryableFailures(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	tx := &Transaction{retryable: true}
+	tx.ID, _ = uuid.NewV4()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "transactions" SET .* WHERE \(id = \$\d+ AND status = \$\d+\)`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if !tx.resolveHeldCreateFailure(db, txStatusApproved) {
+		t.Error("expected held tx which failed due to a retryable error to be retried")
+	}
+}
+
+func TestResolveHeldCreateFailureFailsDeterministicFailures(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	tx := &Transaction{}
+	tx.ID, _ = uuid.NewV4()
+	tx.Errors = []*provide.Error{{Message: common.StringOrNil("tx rejected by policy")}}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "transactions" SET .* WHERE \(id = \$\d+ AND status = \$\d+\)`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if tx.resolveHeldCreateFailure(db, txStatusApproved) {
+		t.Error("expected held tx which failed deterministically to not be retried")
+	}
+	if tx.Status == nil || *tx.Status != "failed" || *tx.Description != "tx rejected by policy" {
+		t.Errorf("expected held tx to be failed with the description of its first error; got %v", tx.Status)
+	}
+}
+
+func TestTransitionLeavesDescriptionUnchangedWhenNil(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	tx := &Transaction{Description: common.StringOrNil("payroll")}
+	tx.ID, _ = uuid.NewV4()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "transactions" SET "status" = \$1 WHERE \(id = \$2 AND status = \$3\)`).
+		WithArgs("pending", tx.ID, txStatusApproved).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if !tx.transition(db, txStatusApproved, "pending", nil) {
+		t.Fatal("expected tx to transition")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expected the description to not be updated; %s", err.Error())
+	}
+	if tx.Description == nil || *tx.Description != "payroll" {
+		t.Errorf("expected the description of the tx to be unchanged; got %v", tx.Description)
+	}
+}
+
+func TestApproveCountsOnlyApprovalsOfCurrentApprovers(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	creatorID, _ := uuid.NewV4()
+	approverID, _ := uuid.NewV4()
+	tx := awaitingApprovalTx(creatorID)
+
+	expectApprovalRule(mock, tx, approverID)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`INSERT INTO "tx_approvals"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.Nil))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "tx_approvals" WHERE \(transaction_id = \$1 AND approved = \$2 AND user_id IN \(\$3\)\)`).
+		WithArgs(tx.ID, true, approverID).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	approval, err := tx.approve(db, approverID, true, nil)
+	if err != nil {
+		t.Fatalf("expected approver to be able to approve the tx; %s", err.Error())
+	}
+	if !approval.Approved || *tx.Status != txStatusAwaitingApproval {
+		t.Errorf("expected tx to await the approvals of its current approvers; status: %s", *tx.Status)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expected only approvals of the current approvers to be counted; %s", err.Error())
+	}
+}
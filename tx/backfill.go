@@ -0,0 +1,137 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package tx
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/network"
+)
+
+// receiptFeesBackfillInterval is the interval at which the fees of finalized txs are backfilled
+const receiptFeesBackfillInterval = time.Minute
+
+// receiptFeesBackfillBatchSize is the maximum number of txs per network backfilled per tick
+const receiptFeesBackfillBatchSize = 100
+
+// receiptFeesBackfillFailed are the txs whose fees could not be backfilled
+var receiptFeesBackfillFailed = map[uuid.UUID]bool{}
+
+// runReceiptFeesBackfillTicker periodically backfills the gas used and effective gas price of the
+// EVM txs which were finalized before the fees of txs were recorded by the receipt consumer
+func runReceiptFeesBackfillTicker() {
+	ticker := time.NewTicker(receiptFeesBackfillInterval)
+	go func() {
+		for range ticker.C {
+			backfillFinalizedTxFees(dbconf.DatabaseConnection())
+		}
+	}()
+}
+
+// backfillFinalizedTxFees backfills the fees of a batch of finalized txs on each enabled EVM network
+func backfillFinalizedTxFees(db *gorm.DB) {
+	var networks []*network.Network
+	db.Where("enabled = ?", true).Find(&networks)
+
+	for _, ntwrk := range networks {
+		if !ntwrk.IsEthereumNetwork() {
+			continue
+		}
+
+		query := db.Where("network_id = ? AND gas_used IS NULL AND hash IS NOT NULL AND block IS NOT NULL", ntwrk.ID)
+		if len(receiptFeesBackfillFailed) > 0 {
+			failed := make([]uuid.UUID, 0)
+			for txID := range receiptFeesBackfillFailed {
+				failed = append(failed, txID)
+			}
+			query = query.Where("id NOT IN (?)", failed)
+		}
+
+		var txs []*Transaction
+		query.Order("block ASC").Limit(receiptFeesBackfillBatchSize).Find(&txs)
+
+		for _, tx := range txs {
+			err := backfillGasUsed(db, tx, ntwrk)
+			if err != nil {
+				// the tx is not backfilled again until the consumer is restarted
+				receiptFeesBackfillFailed[tx.ID] = true
+				common.Log.Warningf("failed to backfill gas used by tx %s; %s", tx.ID, err.Error())
+			}
+		}
+	}
+}
+
+// backfillGasUsed fetches the tx receipt of an EVM tx which was included in a block before the gas
+// used by txs was recorded, and persists its gas used and effective gas price
+func backfillGasUsed(db *gorm.DB, tx *Transaction, ntwrk *network.Network) error {
+	var receipt map[string]interface{}
+	err := ntwrk.EVMRPCClientCall(&receipt, "eth_getTransactionReceipt", *tx.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to fetch tx receipt; %s", err.Error())
+	} else if receipt == nil {
+		return errors.New("tx receipt not found")
+	}
+
+	var effectiveGasPrice *big.Int
+	if tx.EffectiveGasPrice == nil || tx.EffectiveGasPrice.BigInt() == nil {
+		effectiveGasPrice, err = fetchEffectiveGasPrice(ntwrk, *tx.Hash)
+		if err != nil {
+			common.Log.Debugf("failed to backfill effective gas price of tx %s; %s", tx.ID, err.Error())
+		}
+	}
+
+	return backfillReceiptFees(db, tx, receipt, effectiveGasPrice)
+}
+
+// backfillReceiptFees sets and persists the gas used by the tx according to the given tx receipt,
+// and the given effective gas price, if any
+func backfillReceiptFees(db *gorm.DB, tx *Transaction, receipt map[string]interface{}, effectiveGasPrice *big.Int) error {
+	rawGasUsed, ok := receipt["gasUsed"].(string)
+	if !ok {
+		return errors.New("tx receipt does not include gas used")
+	}
+
+	gasUsed, err := hexutil.DecodeUint64(rawGasUsed)
+	if err != nil {
+		return fmt.Errorf("invalid gas used in tx receipt: %s", rawGasUsed)
+	}
+
+	updates := map[string]interface{}{
+		"gas_used": gasUsed,
+	}
+	if effectiveGasPrice != nil {
+		updates["effective_gas_price"] = effectiveGasPrice.String()
+	}
+
+	err = db.Model(&Transaction{}).Where("id = ?", tx.ID).Updates(updates).Error
+	if err != nil {
+		return err
+	}
+
+	tx.GasUsed = &gasUsed
+	if effectiveGasPrice != nil {
+		tx.EffectiveGasPrice = &TxValue{value: effectiveGasPrice}
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tx
+
+import (
+	"math/big"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/internal/testutil"
+)
+
+func TestBackfillReceiptFees(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	tx := &Transaction{}
+	tx.ID, _ = uuid.NewV4()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "transactions" SET "effective_gas_price" = \$1, "gas_used" = \$2 WHERE \(id = \$3\)`).
+		WithArgs("50000000000", uint64(21000), tx.ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err := backfillReceiptFees(db, tx, map[string]interface{}{"gasUsed": "0x5208"}, big.NewInt(50000000000))
+	if err != nil {
+		t.Fatalf("failed to backfill gas used; %s", err.Error())
+	}
+	if tx.GasUsed == nil || *tx.GasUsed != 21000 || tx.EffectiveGasPrice.BigInt().Int64() != 50000000000 {
+		t.Errorf("expected gas used 21000 at 50 gwei to be backfilled; got %v", tx.GasUsed)
+	}
+}
+
+func TestBackfillReceiptFeesRequiresGasUsed(t *testing.T) {
+	db, _ := testutil.NewMockDB(t)
+	tx := &Transaction{}
+
+	err := backfillReceiptFees(db, tx, map[string]interface{}{}, nil)
+	if err == nil || tx.GasUsed != nil {
+		t.Error("expected tx receipt without gas used to not be backfilled")
+	}
+}
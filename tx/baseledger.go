@@ -0,0 +1,307 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package tx
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/network"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const baseledgerMsgSendTypeURL = "/cosmos.bank.v1beta1.MsgSend"
+const baseledgerPubKeyTypeURL = "/cosmos.crypto.secp256k1.PubKey"
+const baseledgerSignModeDirect = uint64(1)
+const defaultBaseledgerGasLimit = uint64(200000)
+
+// baseledgerTx is a signed cosmos sdk tx, i.e., the protobuf-encoded TxRaw
+type baseledgerTx struct {
+	raw []byte
+}
+
+// Hash returns the tendermint hash of the signed tx
+func (tx *baseledgerTx) Hash() string {
+	hash := sha256.Sum256(tx.raw)
+	return strings.ToUpper(hex.EncodeToString(hash[:]))
+}
+
+// appendProtoBytes appends the given length-delimited field; empty values are omitted
+func appendProtoBytes(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+// appendProtoVarint appends the given varint field; zero values are omitted
+func appendProtoVarint(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+// baseledgerAny encodes a google.protobuf.Any with the given type url and value
+func baseledgerAny(typeURL string, value []byte) []byte {
+	var b []byte
+	b = appendProtoBytes(b, 1, []byte(typeURL))
+	b = appendProtoBytes(b, 2, value)
+	return b
+}
+
+// baseledgerCoin encodes a cosmos.base.v1beta1.Coin
+func baseledgerCoin(denom string, amount *big.Int) []byte {
+	var b []byte
+	b = appendProtoBytes(b, 1, []byte(denom))
+	b = appendProtoBytes(b, 2, []byte(amount.String()))
+	return b
+}
+
+// baseledgerMsgs returns the Any-encoded messages of the given tx; messages are given explicitly
+// using the messages param (i.e., a list of base64-encoded messages and their type urls), a proof is
+// anchored when the baseledger_transaction_id param is given, or the tx value is sent to the recipient.
+// Explicit messages are restricted to MsgSend and the proof message of the network
+func baseledgerMsgs(ntwrk *network.Network, tx *Transaction, sender string, params map[string]interface{}) ([][]byte, error) {
+	msgs := make([][]byte, 0)
+
+	if rawMsgs, rawMsgsOk := params["messages"].([]interface{}); rawMsgsOk {
+		for i, rawMsg := range rawMsgs {
+			msg, msgOk := rawMsg.(map[string]interface{})
+			typeURL, typeURLOk := msg["type_url"].(string)
+			value, valueOk := msg["value"].(string)
+			if !msgOk || !typeURLOk || !valueOk {
+				return nil, fmt.Errorf("invalid message at index %d; type_url and base64-encoded value are required", i)
+			}
+
+			if typeURL != baseledgerMsgSendTypeURL && typeURL != ntwrk.BaseledgerProofMsgTypeURL() {
+				return nil, fmt.Errorf("invalid message at index %d; unsupported type_url: %s; only %s and %s messages are supported", i, typeURL, baseledgerMsgSendTypeURL, ntwrk.BaseledgerProofMsgTypeURL())
+			}
+
+			msgValue, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid message at index %d; %s", i, err.Error())
+			}
+
+			msgs = append(msgs, baseledgerAny(typeURL, msgValue))
+		}
+	} else if baseledgerTransactionID, baseledgerTransactionIDOk := params["baseledger_transaction_id"].(string); baseledgerTransactionIDOk {
+		payload, payloadOk := params["payload"].(string)
+		if !payloadOk && tx.Data != nil {
+			payload = *tx.Data
+		}
+		if payload == "" {
+			return nil, errors.New("proof anchoring requires a payload")
+		}
+
+		// MsgCreateBaseledgerTransaction { string creator = 1; string baseledgerTransactionId = 2; string payload = 3; }
+		var msg []byte
+		msg = appendProtoBytes(msg, 1, []byte(sender))
+		msg = appendProtoBytes(msg, 2, []byte(baseledgerTransactionID))
+		msg = appendProtoBytes(msg, 3, []byte(payload))
+		msgs = append(msgs, baseledgerAny(ntwrk.BaseledgerProofMsgTypeURL(), msg))
+	} else {
+		amount := tx.Value.BigInt()
+		if tx.To == nil || amount == nil || amount.Sign() <= 0 {
+			return nil, errors.New("baseledger txs require messages, a proof to anchor or a recipient and positive value")
+		}
+
+		// MsgSend { string from_address = 1; string to_address = 2; repeated Coin amount = 3; }
+		var msg []byte
+		msg = appendProtoBytes(msg, 1, []byte(sender))
+		msg = appendProtoBytes(msg, 2, []byte(*tx.To))
+		msg = appendProtoBytes(msg, 3, baseledgerCoin(ntwrk.BaseledgerDenom(), amount))
+		msgs = append(msgs, baseledgerAny(baseledgerMsgSendTypeURL, msg))
+	}
+
+	return msgs, nil
+}
+
+// baseledgerTxBody encodes the TxBody containing the given messages and the memo and timeout height params
+func baseledgerTxBody(msgs [][]byte, params map[string]interface{}) []byte {
+	// TxBody { repeated Any messages = 1; string memo = 2; uint64 timeout_height = 3; }
+	var body []byte
+	for _, msg := range msgs {
+		b := protowire.AppendTag(body, 1, protowire.BytesType)
+		body = protowire.AppendBytes(b, msg)
+	}
+	if memo, memoOk := params["memo"].(string); memoOk {
+		body = appendProtoBytes(body, 2, []byte(memo))
+	}
+	if timeoutHeight, timeoutHeightOk := params["timeout_height"].(float64); timeoutHeightOk {
+		body = appendProtoVarint(body, 3, uint64(timeoutHeight))
+	}
+	return body
+}
+
+// baseledgerAuthInfo encodes the AuthInfo of a tx signed (using SIGN_MODE_DIRECT) by the given compressed
+// public key at the given sequence, paying the fee param in the given denomination
+func baseledgerAuthInfo(pubkey []byte, sequence uint64, denom string, gasLimit uint64, params map[string]interface{}) []byte {
+	// SignerInfo { Any public_key = 1; ModeInfo mode_info = 2; uint64 sequence = 3; }
+	var signerInfo []byte
+	signerInfo = appendProtoBytes(signerInfo, 1, baseledgerAny(baseledgerPubKeyTypeURL, appendProtoBytes(nil, 1, pubkey)))
+	signerInfo = appendProtoBytes(signerInfo, 2, appendProtoBytes(nil, 1, appendProtoVarint(nil, 1, baseledgerSignModeDirect)))
+	signerInfo = appendProtoVarint(signerInfo, 3, sequence)
+
+	// Fee { repeated Coin amount = 1; uint64 gas_limit = 2; }
+	var fee []byte
+	if feeAmount, feeAmountOk := params["fee"].(float64); feeAmountOk && feeAmount > 0 {
+		fee = appendProtoBytes(fee, 1, baseledgerCoin(denom, new(big.Int).SetUint64(uint64(feeAmount))))
+	}
+	fee = appendProtoVarint(fee, 2, gasLimit)
+
+	// AuthInfo { repeated SignerInfo signer_infos = 1; Fee fee = 2; }
+	var authInfo []byte
+	authInfo = appendProtoBytes(authInfo, 1, signerInfo)
+	authInfo = appendProtoBytes(authInfo, 2, fee)
+	return authInfo
+}
+
+// baseledgerSignDoc encodes the SignDoc signed using SIGN_MODE_DIRECT
+func baseledgerSignDoc(body, authInfo []byte, chainID string, accountNumber uint64) []byte {
+	// SignDoc { bytes body_bytes = 1; bytes auth_info_bytes = 2; string chain_id = 3; uint64 account_number = 4; }
+	var signDoc []byte
+	signDoc = appendProtoBytes(signDoc, 1, body)
+	signDoc = appendProtoBytes(signDoc, 2, authInfo)
+	signDoc = appendProtoBytes(signDoc, 3, []byte(chainID))
+	signDoc = appendProtoVarint(signDoc, 4, accountNumber)
+	return signDoc
+}
+
+// signBaseledgerTx builds and signs (using SIGN_MODE_DIRECT) a cosmos sdk tx on behalf of the signing
+// account; the account sequence is reserved in the same manner as the nonce of an EVM tx
+func (txs *TransactionSigner) signBaseledgerTx(tx *Transaction, custodian digestSigner) (signedTx interface{}, hash []byte, err error) {
+	if txs.Account == nil || txs.Account.VaultID == nil || txs.Account.KeyID == nil || txs.Account.PublicKey == nil {
+		return nil, nil, errors.New("baseledger txs must be signed using a vault-backed account")
+	}
+
+	pubkey, err := network.ParseSecp256k1PublicKey(*txs.Account.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sender, err := txs.Network.BaseledgerAddress(*txs.Account.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	params := tx.ParseParams()
+	msgs, err := baseledgerMsgs(txs.Network, tx, *sender, params)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	accountNumber, sequence, err := txs.Network.BaseledgerAccount(*sender)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.releaseNonce()
+		}
+	}()
+
+	if nonce, nonceOk := params["nonce"].(float64); nonceOk {
+		sequence = uint64(nonce)
+	} else if reserved := txs.reserveNonce(tx, *sender); reserved != nil {
+		sequence = *reserved
+	}
+
+	chainID, err := txs.Network.TendermintChainID()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	gasLimit := defaultBaseledgerGasLimit
+	if gas, gasOk := params["gas"].(float64); gasOk && gas > 0 {
+		gasLimit = uint64(gas)
+	}
+
+	body := baseledgerTxBody(msgs, params)
+	authInfo := baseledgerAuthInfo(pubkey.SerializeCompressed(), sequence, txs.Network.BaseledgerDenom(), gasLimit, params)
+	signDoc := baseledgerSignDoc(body, authInfo, chainID, accountNumber)
+
+	digest := sha256.Sum256(signDoc)
+	_sig, err := custodian.signAccountDigest(digest[:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to sign baseledger tx using signing account %s; %s", *sender, err.Error())
+	}
+
+	if len(_sig) < 64 {
+		return nil, nil, fmt.Errorf("failed to sign baseledger tx using signing account %s; invalid %d-byte signature", *sender, len(_sig))
+	}
+
+	sig := &btcec.Signature{
+		R: new(big.Int).SetBytes(_sig[0:32]),
+		S: new(big.Int).SetBytes(_sig[32:64]),
+	}
+	if !sig.Verify(digest[:], pubkey) {
+		return nil, nil, fmt.Errorf("failed to verify signature of baseledger tx using signing account %s", *sender)
+	}
+
+	// cosmos sdk requires a 64-byte R || S signature with a canonical low S value
+	halfOrder := new(big.Int).Rsh(btcec.S256().N, 1)
+	if sig.S.Cmp(halfOrder) > 0 {
+		sig.S = new(big.Int).Sub(btcec.S256().N, sig.S)
+	}
+	rawSig := make([]byte, 64)
+	sig.R.FillBytes(rawSig[0:32])
+	sig.S.FillBytes(rawSig[32:64])
+
+	// TxRaw { bytes body_bytes = 1; bytes auth_info_bytes = 2; repeated bytes signatures = 3; }
+	var raw []byte
+	raw = appendProtoBytes(raw, 1, body)
+	raw = appendProtoBytes(raw, 2, authInfo)
+	raw = appendProtoBytes(raw, 3, rawSig)
+
+	signed := &baseledgerTx{raw: raw}
+	hash, _ = hex.DecodeString(signed.Hash())
+
+	common.Log.Debugf("signed %d-message baseledger tx %s; signer: %s; sequence: %d", len(msgs), signed.Hash(), *sender, sequence)
+	return signed, hash, nil
+}
+
+// broadcastBaseledgerTx emits the given signed tx for inclusion in a block; the tx is rejected if it fails CheckTx
+func broadcastBaseledgerTx(ntwrk *network.Network, signedTx *baseledgerTx) error {
+	var result struct {
+		Code      uint32 `json:"code"`
+		Log       string `json:"log"`
+		Codespace string `json:"codespace"`
+		Hash      string `json:"hash"`
+	}
+	err := ntwrk.TendermintRPCCall(&result, "broadcast_tx_sync", map[string]interface{}{
+		"tx": base64.StdEncoding.EncodeToString(signedTx.raw),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to transmit signed tx to JSON-RPC host; %s", err.Error())
+	}
+
+	if result.Code != 0 {
+		return fmt.Errorf("signed tx rejected with code %d (codespace: %s); %s", result.Code, result.Codespace, result.Log)
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tx
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/network"
+	provideapi "github.com/provideplatform/provide-go/api/nchain"
+)
+
+func baseledgerNetwork() *network.Network {
+	cfg := json.RawMessage(`{"is_baseledger_network":true,"native_currency":"TOKEN"}`)
+	return &network.Network{Config: &cfg}
+}
+
+func TestBaseledgerSignDocKnownVector(t *testing.T) {
+	tx := &Transaction{
+		To:    common.StringOrNil("baseledger1recipient"),
+		Value: &TxValue{value: big.NewInt(1000)},
+	}
+	params := map[string]interface{}{
+		"memo":           "memo",
+		"timeout_height": float64(100),
+		"fee":            float64(5000),
+	}
+
+	msgs, err := baseledgerMsgs(baseledgerNetwork(), tx, "baseledger1sender", params)
+	if err != nil {
+		t.Fatalf("failed to build baseledger msgs; %s", err.Error())
+	}
+
+	pubkey, _ := hex.DecodeString("021111111111111111111111111111111111111111111111111111111111111111")
+	body := baseledgerTxBody(msgs, params)
+	authInfo := baseledgerAuthInfo(pubkey, 7, "token", defaultBaseledgerGasLimit, params)
+	signDoc := baseledgerSignDoc(body, authInfo, "baseledger-1", 42)
+
+	expectedBody := "0a580a1c2f636f736d6f732e62616e6b2e763162657461312e4d736753656e6412380a11626173656c65646765723173656e6465721214626173656c656467657231726563697069656e741a0d0a05746f6b656e12043130303012046d656d6f1864"
+	expectedAuthInfo := "0a500a460a1f2f636f736d6f732e63727970746f2e736563703235366b312e5075624b657912230a2102111111111111111111111111111111111111111111111111111111111111111112040a020801180712130a0d0a05746f6b656e12043530303010c09a0c"
+	expectedSignDoc := "0a620a580a1c2f636f736d6f732e62616e6b2e763162657461312e4d736753656e6412380a11626173656c65646765723173656e6465721214626173656c656467657231726563697069656e741a0d0a05746f6b656e12043130303012046d656d6f186412670a500a460a1f2f636f736d6f732e63727970746f2e736563703235366b312e5075624b657912230a2102111111111111111111111111111111111111111111111111111111111111111112040a020801180712130a0d0a05746f6b656e12043530303010c09a0c1a0c626173656c65646765722d31202a"
+
+	if hex.EncodeToString(body) != expectedBody {
+		t.Errorf("unexpected TxBody encoding; got %s", hex.EncodeToString(body))
+	}
+	if hex.EncodeToString(authInfo) != expectedAuthInfo {
+		t.Errorf("unexpected AuthInfo encoding; got %s", hex.EncodeToString(authInfo))
+	}
+	if hex.EncodeToString(signDoc) != expectedSignDoc {
+		t.Errorf("unexpected SignDoc encoding; got %s", hex.EncodeToString(signDoc))
+	}
+}
+
+func TestBaseledgerMsgsRestrictsMessageTypes(t *testing.T) {
+	ntwrk := baseledgerNetwork()
+	value := base64.StdEncoding.EncodeToString([]byte{0x0a, 0x01, 0x61})
+
+	for _, typeURL := range []string{baseledgerMsgSendTypeURL, ntwrk.BaseledgerProofMsgTypeURL()} {
+		msgs, err := baseledgerMsgs(ntwrk, &Transaction{}, "baseledger1sender", map[string]interface{}{
+			"messages": []interface{}{map[string]interface{}{"type_url": typeURL, "value": value}},
+		})
+		if err != nil || len(msgs) != 1 {
+			t.Errorf("expected %s message to be permitted; %v", typeURL, err)
+		}
+	}
+
+	for _, typeURL := range []string{"/cosmos.authz.v1beta1.MsgExec", "/cosmos.staking.v1beta1.MsgDelegate"} {
+		_, err := baseledgerMsgs(ntwrk, &Transaction{}, "baseledger1sender", map[string]interface{}{
+			"messages": []interface{}{map[string]interface{}{"type_url": typeURL, "value": value}},
+		})
+		if err == nil {
+			t.Errorf("expected %s message to be rejected", typeURL)
+		}
+	}
+}
+
+func TestHandleFailedTxReceiptSkipsRevertReplayOnNonEVMNetworks(t *testing.T) {
+	tx := &Transaction{Hash: common.StringOrNil("ABCDEF")}
+
+	err := tx.handleFailedTxReceipt(nil, baseledgerNetwork(), "baseledger1sender", &provideapi.TxReceipt{
+		BlockNumber: big.NewInt(10),
+	})
+	if err != nil {
+		t.Fatalf("failed to handle failed tx receipt; %s", err.Error())
+	}
+	if tx.RevertError != nil {
+		t.Error("expected no revert to be resolved")
+	}
+	if tx.Description == nil || *tx.Description != "tx failed in block 10" {
+		t.Errorf("expected failed tx description; got %v", tx.Description)
+	}
+}
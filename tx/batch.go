@@ -0,0 +1,753 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package tx
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/jinzhu/gorm"
+	natsutil "github.com/kthomas/go-natsutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/contract"
+	"github.com/provideplatform/nchain/network"
+	"github.com/provideplatform/nchain/policy"
+	provide "github.com/provideplatform/provide-go/api"
+	providecrypto "github.com/provideplatform/provide-go/crypto"
+)
+
+// txStatusQueued is the status of a tx which was persisted as part of a batch and has not yet been signed
+const txStatusQueued = "queued"
+
+const batchStatusPending = "pending"
+const batchStatusSuccess = "success"
+const batchStatusFailed = "failed"
+const batchStatusPartial = "partial"
+
+// maxBatchSize is the maximum number of items which may be submitted in a single batch
+const maxBatchSize = 1000
+
+// defaultMulticall3Address is the deterministic deployment address of Multicall3 on most EVM
+// networks; it can be overridden using the multicall3_address network config
+const defaultMulticall3Address = "0xcA11bde05977b3631167028862bE2a173976CA11"
+
+const multicall3ABI = `[{"inputs":[{"components":[{"internalType":"address","name":"target","type":"address"},{"internalType":"bool","name":"allowFailure","type":"bool"},{"internalType":"uint256","name":"value","type":"uint256"},{"internalType":"bytes","name":"callData","type":"bytes"}],"internalType":"struct Multicall3.Call3Value[]","name":"calls","type":"tuple[]"}],"name":"aggregate3Value","outputs":[{"components":[{"internalType":"bool","name":"success","type":"bool"},{"internalType":"bytes","name":"returnData","type":"bytes"}],"internalType":"struct Multicall3.Result[]","name":"returnData","type":"tuple[]"}],"stateMutability":"payable","type":"function"}]`
+
+// multicall3Aggregate3ValueSelector is the selector of aggregate3Value((address,bool,uint256,bytes)[])
+const multicall3Aggregate3ValueSelector = "0x174dea71"
+
+// multicallRejectedSelectors are the ERC-20 methods which act on the tokens or allowances of
+// msg.sender; msg.sender of a call packed into a multicall tx is the Multicall3 contract rather
+// than the signer, so such calls would act on behalf of Multicall3
+var multicallRejectedSelectors = map[string]string{
+	"0xa9059cbb": "transfer(address,uint256)",
+	"0x23b872dd": "transferFrom(address,address,uint256)",
+	"0x095ea7b3": "approve(address,uint256)",
+	"0x39509351": "increaseAllowance(address,uint256)",
+	"0xa457c2d7": "decreaseAllowance(address,uint256)",
+}
+
+// multicall3Call is a call packed into a Multicall3 aggregate3Value tx
+type multicall3Call struct {
+	Target       ethcommon.Address
+	AllowFailure bool
+	Value        *big.Int
+	CallData     []byte
+}
+
+// Batch of txs which are validated together and published for signing in order; the nonces of
+// the txs signed by each account or HD wallet address are reserved sequentially, in item order.
+// When Multicall is true, the items are packed into a single Multicall3 tx which calls each item
+// target on behalf of the signer; the calls are made by the Multicall3 contract, so msg.sender
+// of each call is the Multicall3 address rather than the signer.
+type Batch struct {
+	provide.Model
+	ApplicationID  *uuid.UUID       `sql:"type:uuid" json:"application_id,omitempty"`
+	OrganizationID *uuid.UUID       `sql:"type:uuid" json:"organization_id,omitempty"`
+	UserID         *uuid.UUID       `sql:"type:uuid" json:"user_id,omitempty"`
+	ContractID     *uuid.UUID       `sql:"type:uuid" json:"contract_id,omitempty"` // contract executed by the items of a contract execution batch
+	Multicall      bool             `sql:"not null" json:"multicall"`
+	Size           int              `sql:"not null" json:"size"`
+	TransactionIDs *json.RawMessage `sql:"type:json;not null" json:"-"` // id of the tx of each item, in item order
+
+	// Aggregate status of the txs of the batch; see Batch.resolveStatus
+	Status   *string        `sql:"-" json:"status,omitempty"`
+	Statuses map[string]int `sql:"-" json:"statuses,omitempty"`
+	Items    []*BatchItem   `sql:"-" json:"items,omitempty"`
+
+	txs          []*Transaction       // txs of the batch, in item order
+	signers      []*TransactionSigner // signer of each tx of the batch
+	reservations []*nonceReservation  // nonces reserved for the txs of the batch; released if the batch is not persisted
+}
+
+// BatchItem is the tx of an item of a batch; the items of a multicall batch share a single tx
+type BatchItem struct {
+	Index         int       `json:"index"`
+	TransactionID uuid.UUID `json:"transaction_id"`
+	Status        *string   `json:"status,omitempty"`
+	Hash          *string   `json:"hash,omitempty"`
+}
+
+// TableName returns the table name of tx batches
+func (Batch) TableName() string {
+	return "tx_batches"
+}
+
+// batchRequest is the body of a batch request; the items may also be given as a JSON array
+type batchRequest struct {
+	Items     []json.RawMessage `json:"items"`
+	Multicall bool              `json:"multicall"`
+}
+
+// parseBatchRequest parses the given batch request body
+func parseBatchRequest(buf []byte) (*batchRequest, error) {
+	req := &batchRequest{}
+	if strings.HasPrefix(strings.TrimSpace(string(buf)), "[") {
+		err := json.Unmarshal(buf, &req.Items)
+		if err != nil {
+			return nil, err
+		}
+		return req, nil
+	}
+
+	err := json.Unmarshal(buf, req)
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// NewBatch returns a new, empty batch
+func NewBatch(multicall bool) *Batch {
+	return &Batch{
+		Multicall: multicall,
+		txs:       make([]*Transaction, 0),
+	}
+}
+
+// add the given tx to the batch as its next item
+func (b *Batch) add(tx *Transaction) {
+	b.txs = append(b.txs, tx)
+	b.Size = len(b.txs)
+}
+
+// Create validates every tx of the batch, reserves the nonces of the txs and persists the batch
+// and its txs in the queued status; the txs are then published for signing in item order. No tx
+// is persisted unless every tx of the batch is valid.
+func (b *Batch) Create(db *gorm.DB) bool {
+	if !b.Validate(db) {
+		return false
+	}
+
+	if b.Multicall {
+		err := b.pack(db)
+		if err != nil {
+			b.Errors = append(b.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+			return false
+		}
+	}
+
+	err := b.reserveNonces(db)
+	if err != nil {
+		b.Errors = append(b.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+		return false
+	}
+
+	err = b.persist(db)
+	if err != nil {
+		b.releaseNonces()
+		b.Errors = append(b.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+		return false
+	}
+
+	b.publish(db)
+	b.resolveStatus(db)
+	return true
+}
+
+// Validate every tx of the batch and resolve its signer; policies are evaluated without recording
+// decisions, since each tx is evaluated again when it is signed, and the daily caps are enforced
+// on the total of the batch
+func (b *Batch) Validate(db *gorm.DB) bool {
+	b.Errors = make([]*provide.Error, 0)
+	b.signers = make([]*TransactionSigner, len(b.txs))
+
+	if len(b.txs) == 0 {
+		b.Errors = append(b.Errors, &provide.Error{
+			Message: common.StringOrNil("batch must contain at least one item"),
+		})
+	} else if len(b.txs) > maxBatchSize {
+		b.Errors = append(b.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("batch must not contain more than %d items", maxBatchSize)),
+		})
+	}
+
+	evaluated := make([]*Transaction, 0)
+	for i, tx := range b.txs {
+		if !tx.Validate() {
+			for _, err := range tx.Errors {
+				b.itemError(i, *err.Message)
+			}
+			continue
+		}
+
+		signer, err := tx.signerFactory(db)
+		if err != nil {
+			b.itemError(i, err.Error())
+			continue
+		}
+		b.signers[i] = signer
+
+		requiresApproval, err := tx.requiresApproval(db)
+		if err != nil {
+			b.itemError(i, err.Error())
+			continue
+		}
+
+		if !requiresApproval {
+			err = tx.enforcePolicies(db)
+			if err != nil {
+				b.itemError(i, err.Error())
+				continue
+			}
+			evaluated = append(evaluated, tx)
+		} else if b.Multicall {
+			b.itemError(i, "tx requires approval and cannot be packed into a multicall tx")
+			continue
+		}
+
+		if b.Multicall {
+			err = b.validateMulticallItem(tx, signer)
+			if err != nil {
+				b.itemError(i, err.Error())
+			}
+		}
+	}
+
+	if len(b.Errors) == 0 && len(evaluated) > 1 {
+		err := enforceBatchPolicies(db, evaluated)
+		if err != nil {
+			b.Errors = append(b.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("batch not allowed; %s", err.Error())),
+			})
+		}
+	}
+
+	return len(b.Errors) == 0
+}
+
+// enforceBatchPolicies evaluates the signing policies which apply to the given txs as one unit, so
+// the daily caps are enforced on the total of the txs rather than on each tx
+func enforceBatchPolicies(db *gorm.DB, txs []*Transaction) error {
+	intents := make([]*policy.Intent, 0)
+	for _, tx := range txs {
+		if tx.AccountID == nil && tx.WalletID == nil {
+			continue
+		}
+
+		txIntents, err := tx.policyIntents(db, tx.policyValue())
+		if err != nil {
+			return err
+		}
+		intents = append(intents, txIntents...)
+	}
+
+	return policy.EvaluateAll(db, intents, true)
+}
+
+// itemError appends an error for the item at the given index
+func (b *Batch) itemError(index int, msg string) {
+	b.Errors = append(b.Errors, &provide.Error{
+		Message: common.StringOrNil(fmt.Sprintf("items[%d]: %s", index, msg)),
+	})
+}
+
+// validateMulticallItem ensures the given tx can be packed into a multicall tx with the first tx of the batch
+func (b *Batch) validateMulticallItem(tx *Transaction, signer *TransactionSigner) error {
+	if !signer.Network.IsEthereumNetwork() {
+		return fmt.Errorf("multicall not supported by network %s", signer.Network.ID)
+	}
+
+	if tx.Signer != nil || tx.Signature != nil {
+		return errors.New("self-custody signed txs cannot be packed into a multicall tx")
+	}
+
+	if tx.To == nil {
+		return errors.New("contract creation txs cannot be packed into a multicall tx")
+	}
+
+	if tx.Data != nil {
+		if data := ethcommon.FromHex(*tx.Data); len(data) >= 4 {
+			if method, rejected := multicallRejectedSelectors[fmt.Sprintf("0x%x", data[0:4])]; rejected {
+				return fmt.Errorf("ERC-20 %s cannot be packed into a multicall tx, since it would be called on behalf of the Multicall3 contract rather than the signer", method)
+			}
+		}
+	}
+
+	first := b.txs[0]
+	if tx.NetworkID != first.NetworkID || !uuidPtrEqual(tx.AccountID, first.AccountID) || !uuidPtrEqual(tx.WalletID, first.WalletID) || !stringPtrEqual(tx.Path, first.Path) {
+		return errors.New("every item of a multicall batch must be signed by the same signer on the same network")
+	}
+
+	return nil
+}
+
+// multicall3Address returns the address of the Multicall3 contract on the given network
+func multicall3Address(ntwrk *network.Network) string {
+	if address, ok := ntwrk.ParseConfig()["multicall3_address"].(string); ok && address != "" {
+		return address
+	}
+	return defaultMulticall3Address
+}
+
+// unpackMulticall3Calls returns the calls of the given Multicall3 aggregate3Value calldata, or nil
+// if the calldata is not an aggregate3Value call
+func unpackMulticall3Calls(data []byte) ([]multicall3Call, error) {
+	if len(data) < 4 || fmt.Sprintf("0x%x", data[0:4]) != multicall3Aggregate3ValueSelector {
+		return nil, nil
+	}
+
+	_abi, err := abi.JSON(strings.NewReader(multicall3ABI))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse Multicall3 ABI; %s", err.Error())
+	}
+
+	calls := make([]multicall3Call, 0)
+	err = _abi.Methods["aggregate3Value"].Inputs.Unpack(&calls, data[4:])
+	if err != nil {
+		return nil, fmt.Errorf("failed to unpack Multicall3 aggregate3Value calls; %s", err.Error())
+	}
+	return calls, nil
+}
+
+// pack replaces the txs of the batch with a single tx which calls each tx using Multicall3
+// aggregate3Value; the calls are atomic, so the multicall tx reverts if any call reverts
+func (b *Batch) pack(db *gorm.DB) error {
+	first := b.txs[0]
+	signer := b.signers[0]
+
+	address := multicall3Address(signer.Network)
+
+	_abi, err := abi.JSON(strings.NewReader(multicall3ABI))
+	if err != nil {
+		return fmt.Errorf("failed to parse Multicall3 ABI; %s", err.Error())
+	}
+
+	value := big.NewInt(0)
+	calls := make([]multicall3Call, 0)
+	for _, tx := range b.txs {
+		callValue := big.NewInt(0)
+		if tx.Value != nil && tx.Value.BigInt() != nil {
+			callValue = tx.Value.BigInt()
+		}
+		value = new(big.Int).Add(value, callValue)
+
+		var callData []byte
+		if tx.Data != nil {
+			callData = ethcommon.FromHex(*tx.Data)
+		}
+
+		calls = append(calls, multicall3Call{
+			Target:       ethcommon.HexToAddress(*tx.To),
+			AllowFailure: false,
+			Value:        callValue,
+			CallData:     callData,
+		})
+	}
+
+	data, err := _abi.Pack("aggregate3Value", calls)
+	if err != nil {
+		return fmt.Errorf("failed to pack %d call(s) into Multicall3 tx; %s", len(calls), err.Error())
+	}
+
+	// the gas and fee params of the first item are used for the multicall tx
+	params := first.ParseParams()
+	delete(params, "to")
+	delete(params, "nonce")
+
+	packed := &Transaction{
+		ApplicationID:  first.ApplicationID,
+		OrganizationID: first.OrganizationID,
+		UserID:         first.UserID,
+		NetworkID:      first.NetworkID,
+		AccountID:      first.AccountID,
+		WalletID:       first.WalletID,
+		Path:           first.Path,
+		To:             common.StringOrNil(address),
+		Value:          &TxValue{value: value},
+		Data:           common.StringOrNil(fmt.Sprintf("0x%s", ethcommon.Bytes2Hex(data))),
+		Description:    common.StringOrNil(fmt.Sprintf("Multicall3 aggregate3Value of %d call(s)", len(calls))),
+	}
+	packed.setParams(params)
+
+	if !packed.Validate() {
+		return fmt.Errorf("failed to validate multicall tx; %s", *packed.Errors[0].Message)
+	}
+
+	requiresApproval, err := packed.requiresApproval(db)
+	if err != nil {
+		return fmt.Errorf("failed to validate multicall tx; %s", err.Error())
+	} else if requiresApproval {
+		return errors.New("multicall tx requires approval and cannot be submitted in a batch")
+	}
+
+	err = packed.enforcePolicies(db)
+	if err != nil {
+		return fmt.Errorf("multicall tx not allowed; %s", err.Error())
+	}
+
+	b.txs = []*Transaction{packed}
+	b.signers = []*TransactionSigner{signer}
+	return nil
+}
+
+// reserveNonces reserves sequential nonces, in item order, for the txs of the batch which are signed
+// using an account or HD wallet on an EVM network and which do not specify a nonce; txs which require
+// approval are signed once approved, so no nonce is reserved for them
+func (b *Batch) reserveNonces(db *gorm.DB) error {
+	type signerTxs struct {
+		signer  *TransactionSigner
+		address string
+		txs     []*Transaction
+	}
+
+	groups := make([]*signerTxs, 0)
+	groupsByKey := map[string]*signerTxs{}
+
+	for i, tx := range b.txs {
+		signer := b.signers[i]
+		if !signer.Network.IsEthereumNetwork() || (signer.Account == nil && signer.Wallet == nil) {
+			continue
+		}
+
+		if _, nonceOk := tx.ParseParams()["nonce"]; nonceOk {
+			continue
+		}
+
+		requiresApproval, err := tx.requiresApproval(db)
+		if err != nil {
+			return fmt.Errorf("failed to resolve approval rules of tx %d of batch; %s", i, err.Error())
+		} else if requiresApproval {
+			continue
+		}
+
+		address, derivationPath, err := signer.GetSignerDetails()
+		if err != nil {
+			return fmt.Errorf("failed to resolve signer address of tx %d of batch; %s", i, err.Error())
+		}
+
+		if signer.Wallet != nil && tx.Path == nil {
+			// pin the derivation path so the tx is signed using the address for which the nonce was reserved
+			tx.Path = derivationPath
+			signer.Wallet.Path = derivationPath
+		}
+
+		key := fmt.Sprintf("%s:%s", signer.Network.ID, strings.ToLower(*address))
+		group, ok := groupsByKey[key]
+		if !ok {
+			group = &signerTxs{signer: signer, address: *address}
+			groupsByKey[key] = group
+			groups = append(groups, group)
+		}
+		group.txs = append(group.txs, tx)
+	}
+
+	b.reservations = make([]*nonceReservation, 0)
+	for _, group := range groups {
+		reservations, err := reserveNonces(group.signer.Network, group.address, len(group.txs))
+		if err != nil {
+			b.releaseNonces()
+			return fmt.Errorf("failed to reserve %d nonce(s) for signer: %s; %s", len(group.txs), group.address, err.Error())
+		}
+
+		for i, tx := range group.txs {
+			tx.nonceReservation = reservations[i]
+			params := tx.ParseParams()
+			params["nonce"] = reservations[i].nonce
+			tx.setParams(params)
+		}
+		b.reservations = append(b.reservations, reservations...)
+	}
+
+	return nil
+}
+
+// releaseNonces releases the nonces reserved for the txs of the batch
+func (b *Batch) releaseNonces() {
+	for _, tx := range b.txs {
+		tx.releaseNonce()
+	}
+	b.reservations = nil
+}
+
+// persist the batch and its txs, in the queued status, using a single db transaction
+func (b *Batch) persist(db *gorm.DB) error {
+	dbtx := db.Begin()
+
+	txIDs := json.RawMessage("[]")
+	b.TransactionIDs = &txIDs
+
+	result := dbtx.Create(&b)
+	if result.Error != nil {
+		dbtx.Rollback()
+		return fmt.Errorf("failed to persist batch; %s", result.Error.Error())
+	}
+
+	ids := make([]string, 0)
+	for i, tx := range b.txs {
+		if tx.AccountID != nil && *tx.AccountID == uuid.Nil {
+			tx.AccountID = nil
+		}
+		if tx.WalletID != nil && *tx.WalletID == uuid.Nil {
+			tx.WalletID = nil
+		}
+
+		tx.BatchID = &b.ID
+		tx.Status = common.StringOrNil(txStatusQueued)
+		tx.PendingParams = tx.Params
+
+		result := dbtx.Create(&tx)
+		if result.Error != nil {
+			dbtx.Rollback()
+			return fmt.Errorf("failed to persist tx %d of batch; %s", i, result.Error.Error())
+		}
+		ids = append(ids, tx.ID.String())
+	}
+
+	if b.Multicall {
+		// every item of a multicall batch is executed by the single multicall tx
+		for len(ids) < b.Size {
+			ids = append(ids, ids[0])
+		}
+	}
+
+	idsJSON, _ := json.Marshal(ids)
+	txIDs = json.RawMessage(idsJSON)
+	b.TransactionIDs = &txIDs
+
+	result = dbtx.Model(b).Update("transaction_ids", b.TransactionIDs)
+	if result.Error != nil {
+		dbtx.Rollback()
+		return fmt.Errorf("failed to persist batch; %s", result.Error.Error())
+	}
+
+	result = dbtx.Commit()
+	if result.Error != nil {
+		return fmt.Errorf("failed to persist batch; %s", result.Error.Error())
+	}
+
+	return nil
+}
+
+// publish the txs of the batch for signing, in item order; when a tx cannot be published, it and
+// every subsequent tx of the batch are failed, since the txs must be signed in order
+func (b *Batch) publish(db *gorm.DB) {
+	var publishErr error
+
+	for _, tx := range b.txs {
+		if publishErr == nil {
+			msg := map[string]interface{}{
+				"transaction_id": tx.ID.String(),
+				"published_at":   time.Now(),
+			}
+			if tx.nonceReservation != nil {
+				msg["signer_address"] = tx.nonceReservation.address
+			}
+
+			payload, _ := json.Marshal(msg)
+			_, publishErr = natsutil.NatsJetstreamPublish(natsTxCreateSubject, payload)
+			if publishErr == nil {
+				continue
+			}
+			common.Log.Warningf("failed to publish tx %s of batch %s; %s", tx.ID, b.ID, publishErr.Error())
+		}
+
+		desc := fmt.Sprintf("failed to publish tx of batch %s", b.ID)
+		tx.releaseNonce()
+		tx.transition(db, txStatusQueued, "failed", &desc)
+	}
+
+	common.Log.Debugf("published %d tx(s) of batch %s", len(b.txs), b.ID)
+}
+
+// abandonBatchNonces fails the txs of the batch of the given failed tx which are still queued to be
+// signed by the same signer, and invalidates the nonce state of the signer; the nonces reserved for
+// the txs of a batch are sequential, so the queued txs could never be mined past the nonce of the
+// failed tx, which is reserved again once the nonce state is resynchronized with the network
+func abandonBatchNonces(db *gorm.DB, failed *Transaction, signerAddress string) {
+	if failed.BatchID == nil {
+		return
+	}
+
+	txs := make([]*Transaction, 0)
+	db.Where("batch_id = ? AND network_id = ? AND status = ?", failed.BatchID, failed.NetworkID, txStatusQueued).Find(&txs)
+
+	desc := fmt.Sprintf("tx %s of batch %s failed", failed.ID, failed.BatchID)
+	for _, tx := range txs {
+		if tx.ID == failed.ID || !uuidPtrEqual(tx.AccountID, failed.AccountID) || !uuidPtrEqual(tx.WalletID, failed.WalletID) || !stringPtrEqual(tx.Path, failed.Path) {
+			continue
+		}
+
+		if tx.transition(db, txStatusQueued, "failed", &desc) {
+			common.Log.Debugf("failed queued tx %s of batch %s; %s", tx.ID, failed.BatchID, desc)
+		}
+	}
+
+	reservation := &nonceReservation{
+		networkID: failed.NetworkID,
+		address:   signerAddress,
+	}
+	err := reservation.invalidate()
+	if err != nil {
+		common.Log.Warningf("failed to invalidate nonce state for signer: %s of batch %s; %s", signerAddress, failed.BatchID, err.Error())
+	}
+}
+
+// ParseTransactionIDs returns the id of the tx of each item of the batch, in item order
+func (b *Batch) ParseTransactionIDs() []uuid.UUID {
+	ids := make([]uuid.UUID, 0)
+	if b.TransactionIDs == nil {
+		return ids
+	}
+
+	var idStrs []string
+	err := json.Unmarshal(*b.TransactionIDs, &idStrs)
+	if err != nil {
+		common.Log.Warningf("failed to parse tx ids of batch %s; %s", b.ID, err.Error())
+		return ids
+	}
+
+	for _, idStr := range idStrs {
+		id, err := uuid.FromString(idStr)
+		if err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// resolveStatus populates the items of the batch and the aggregate status of its txs; the batch
+// is pending until every tx is settled, and is partial if some, but not all, of its txs succeeded
+func (b *Batch) resolveStatus(db *gorm.DB) {
+	var txs []*Transaction
+	db.Where("batch_id = ?", b.ID).Find(&txs)
+
+	txsByID := map[uuid.UUID]*Transaction{}
+	b.Statuses = map[string]int{}
+	for _, tx := range txs {
+		txsByID[tx.ID] = tx
+		if tx.Status != nil {
+			b.Statuses[*tx.Status]++
+		}
+	}
+
+	b.Items = make([]*BatchItem, 0)
+	for i, txID := range b.ParseTransactionIDs() {
+		item := &BatchItem{
+			Index:         i,
+			TransactionID: txID,
+		}
+		if tx, ok := txsByID[txID]; ok {
+			item.Status = tx.Status
+			item.Hash = tx.Hash
+		}
+		b.Items = append(b.Items, item)
+	}
+
+	succeeded := b.Statuses["success"]
+	failed := b.Statuses["failed"] + b.Statuses[txStatusRejected]
+
+	status := batchStatusPending
+	if len(txs) > 0 && succeeded+failed == len(txs) {
+		if failed == 0 {
+			status = batchStatusSuccess
+		} else if succeeded == 0 {
+			status = batchStatusFailed
+		} else {
+			status = batchStatusPartial
+		}
+	}
+	b.Status = common.StringOrNil(status)
+}
+
+// addExecutions adds the tx which executes each of the given contract executions to the batch;
+// every execution must invoke a non-constant method of the contract ABI
+func (b *Batch) addExecutions(c *contract.Contract, executions []*contract.Execution) {
+	_abi, err := c.ReadEthereumContractAbi()
+	if err != nil {
+		b.Errors = append(b.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("failed to resolve ABI of contract: %s; %s", c.ID, err.Error())),
+		})
+		return
+	}
+
+	for i, execution := range executions {
+		abiMethod, methodDescriptor := resolveExecutionMethod(_abi, execution.Method)
+		if abiMethod == nil || execution.Method == "" {
+			b.itemError(i, fmt.Sprintf("%s not found in ABI of contract: %s", methodDescriptor, c.ID))
+			continue
+		}
+
+		if abiMethod.IsConstant() {
+			b.itemError(i, fmt.Sprintf("read-only %s cannot be executed in a batch", methodDescriptor))
+			continue
+		}
+
+		invocationSig, err := providecrypto.EVMEncodeABI(abiMethod, execution.Params...)
+		if err != nil {
+			b.itemError(i, fmt.Sprintf("failed to encode %d parameters of %s; %s", len(execution.Params), methodDescriptor, err.Error()))
+			continue
+		}
+
+		if execution.Value == nil {
+			execution.Value = big.NewInt(0)
+		}
+
+		tx := executionTxFactory(c, execution)
+		tx.UserID = b.UserID
+		tx.Data = common.StringOrNil(fmt.Sprintf("0x%s", ethcommon.Bytes2Hex(invocationSig)))
+		b.add(tx)
+	}
+}
+
+// uuidPtrEqual returns true if the given uuids are both nil or equal
+func uuidPtrEqual(a, b *uuid.UUID) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+// stringPtrEqual returns true if the given strings are both nil or equal
+func stringPtrEqual(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,230 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tx
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/internal/testutil"
+	"github.com/provideplatform/nchain/network"
+	"github.com/provideplatform/nchain/policy"
+)
+
+const testMulticallTarget = "0x0000000000000000000000000000000000000004"
+
+func ethereumNetwork() *network.Network {
+	config := json.RawMessage(`{"is_ethereum_network": true}`)
+	ntwrk := &network.Network{Config: &config}
+	ntwrk.ID, _ = uuid.NewV4()
+	return ntwrk
+}
+
+func multicallBatch(ntwrk *network.Network) (*Batch, *TransactionSigner) {
+	accountID, _ := uuid.NewV4()
+	batch := NewBatch(true)
+	batch.add(&Transaction{NetworkID: ntwrk.ID, AccountID: &accountID, To: common.StringOrNil(testMulticallTarget)})
+	return batch, &TransactionSigner{Network: ntwrk}
+}
+
+func packMulticall3Calls(t *testing.T, calls []multicall3Call) *string {
+	_abi, err := abi.JSON(strings.NewReader(multicall3ABI))
+	if err != nil {
+		t.Fatalf("failed to parse Multicall3 ABI; %s", err.Error())
+	}
+	data, err := _abi.Pack("aggregate3Value", calls)
+	if err != nil {
+		t.Fatalf("failed to pack Multicall3 calls; %s", err.Error())
+	}
+	return common.StringOrNil(fmt.Sprintf("0x%s", ethcommon.Bytes2Hex(data)))
+}
+
+func TestMulticall3Aggregate3ValueSelector(t *testing.T) {
+	_abi, _ := abi.JSON(strings.NewReader(multicall3ABI))
+	if selector := fmt.Sprintf("0x%x", _abi.Methods["aggregate3Value"].ID); selector != multicall3Aggregate3ValueSelector {
+		t.Errorf("expected aggregate3Value selector %s; got %s", selector, multicall3Aggregate3ValueSelector)
+	}
+}
+
+func TestValidateMulticallItemRejectsERC20Methods(t *testing.T) {
+	ntwrk := ethereumNetwork()
+	batch, signer := multicallBatch(ntwrk)
+	first := batch.txs[0]
+
+	for selector := range multicallRejectedSelectors {
+		tx := &Transaction{
+			NetworkID: ntwrk.ID,
+			AccountID: first.AccountID,
+			To:        common.StringOrNil(testMulticallTarget),
+			Data:      common.StringOrNil(fmt.Sprintf("%s%064x", selector, 1)),
+		}
+		if err := batch.validateMulticallItem(tx, signer); err == nil {
+			t.Errorf("expected ERC-20 call with selector %s to be rejected", selector)
+		}
+	}
+
+	tx := &Transaction{
+		NetworkID: ntwrk.ID,
+		AccountID: first.AccountID,
+		To:        common.StringOrNil(testMulticallTarget),
+		Data:      common.StringOrNil("0xd0e30db0"), // deposit()
+	}
+	if err := batch.validateMulticallItem(tx, signer); err != nil {
+		t.Errorf("expected call which does not act on behalf of msg.sender to be packed; %s", err.Error())
+	}
+}
+
+func TestPolicyIntentsOfMulticallTxAreItsCalls(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	ntwrk := ethereumNetwork()
+	accountID, _ := uuid.NewV4()
+
+	tx := &Transaction{
+		NetworkID: ntwrk.ID,
+		AccountID: &accountID,
+		To:        common.StringOrNil(defaultMulticall3Address),
+		Data: packMulticall3Calls(t, []multicall3Call{
+			{Target: ethcommon.HexToAddress(testMulticallTarget), Value: big.NewInt(3)},
+			{Target: ethcommon.HexToAddress(testMulticallTarget), Value: big.NewInt(0), CallData: ethcommon.FromHex("0xd0e30db0")},
+		}),
+	}
+
+	mock.ExpectQuery(`SELECT \* FROM "networks" WHERE \(id = \$1\)`).
+		WithArgs(ntwrk.ID).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "config"}).AddRow(ntwrk.ID, []byte(*ntwrk.Config)))
+
+	intents, err := tx.policyIntents(db, big.NewInt(3))
+	if err != nil {
+		t.Fatalf("failed to resolve policy intents of multicall tx; %s", err.Error())
+	}
+	if len(intents) != 2 {
+		t.Fatalf("expected an intent for each call of the multicall tx; got %d", len(intents))
+	}
+	if !strings.EqualFold(*intents[0].To, testMulticallTarget) || intents[0].Value.Int64() != 3 || intents[0].Data != nil {
+		t.Errorf("expected first intent to transfer 3 wei to %s; got %+v", testMulticallTarget, intents[0])
+	}
+	if intents[1].Data == nil || *intents[1].Data != "0xd0e30db0" || *intents[1].AccountID != accountID {
+		t.Errorf("expected second intent to call deposit() on behalf of the signer; got %+v", intents[1])
+	}
+}
+
+func TestPolicyIntentsOfAggregate3ValueCallToOtherContract(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	ntwrk := ethereumNetwork()
+	accountID, _ := uuid.NewV4()
+
+	tx := &Transaction{
+		NetworkID: ntwrk.ID,
+		AccountID: &accountID,
+		To:        common.StringOrNil(testMulticallTarget),
+		Data: packMulticall3Calls(t, []multicall3Call{
+			{Target: ethcommon.HexToAddress(testMulticallTarget), Value: big.NewInt(3)},
+		}),
+	}
+
+	mock.ExpectQuery(`SELECT \* FROM "networks" WHERE \(id = \$1\)`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "config"}).AddRow(ntwrk.ID, []byte(*ntwrk.Config)))
+
+	intents, err := tx.policyIntents(db, big.NewInt(0))
+	if err != nil {
+		t.Fatalf("failed to resolve policy intents; %s", err.Error())
+	}
+	if len(intents) != 1 || intents[0].Data != tx.Data {
+		t.Errorf("expected tx which does not call Multicall3 to be evaluated as a single intent; got %d intent(s)", len(intents))
+	}
+}
+
+func TestAbandonBatchNoncesFailsQueuedTxsOfSigner(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	server := testutil.NewMockRedis(t)
+
+	ntwrk := ethereumNetwork()
+	address := "0x0000000000000000000000000000000000000005"
+	server.Set(NonceKey(ntwrk.ID, address), `{"next": 12, "pending": 9}`)
+
+	batchID, _ := uuid.NewV4()
+	accountID, _ := uuid.NewV4()
+	otherAccountID, _ := uuid.NewV4()
+	failed := &Transaction{BatchID: &batchID, NetworkID: ntwrk.ID, AccountID: &accountID}
+	failed.ID, _ = uuid.NewV4()
+	queuedID, _ := uuid.NewV4()
+	otherID, _ := uuid.NewV4()
+
+	mock.ExpectQuery(`SELECT \* FROM "transactions" WHERE \(batch_id = \$1 AND network_id = \$2 AND status = \$3\)`).
+		WithArgs(batchID, ntwrk.ID, txStatusQueued).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "batch_id", "network_id", "account_id", "status"}).
+			AddRow(queuedID, batchID, ntwrk.ID, accountID, txStatusQueued).
+			AddRow(otherID, batchID, ntwrk.ID, otherAccountID, txStatusQueued))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "transactions" SET .* WHERE \(id = \$\d+ AND status = \$\d+\)`).
+		WithArgs(sqlmock.AnyArg(), "failed", queuedID, txStatusQueued).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	abandonBatchNonces(db, failed, address)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expected only the queued tx of the signer to be failed; %s", err.Error())
+	}
+	if state := readNonceState(NonceKey(ntwrk.ID, address)); state == nil || state.Next != 0 {
+		t.Errorf("expected nonce state of the signer to be invalidated; got %v", state)
+	}
+}
+
+func TestEnforceBatchPoliciesEnforcesDailyCapsOnBatchTotal(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	policyID, _ := uuid.NewV4()
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery(`SELECT \* FROM "policies"`).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "enabled", "rules"}).
+				AddRow(policyID, "policy", true, []byte(`{"max_value_per_day": "150"}`)))
+	}
+	mock.ExpectBegin()
+	for i := 0; i < 2; i++ {
+		mock.ExpectQuery(`SELECT COALESCE`).WillReturnRows(sqlmock.NewRows([]string{"sum"}).AddRow("0"))
+	}
+	mock.ExpectRollback()
+
+	appID, _ := uuid.NewV4()
+	accountID, _ := uuid.NewV4()
+	networkID, _ := uuid.NewV4()
+	txs := make([]*Transaction, 0)
+	for i := 0; i < 2; i++ {
+		txs = append(txs, &Transaction{
+			ApplicationID: &appID,
+			AccountID:     &accountID,
+			NetworkID:     networkID,
+			Value:         &TxValue{value: big.NewInt(100)},
+		})
+	}
+
+	err := enforceBatchPolicies(db, txs)
+	if _, ok := err.(*policy.Violation); !ok {
+		t.Errorf("expected batch total to exceed the daily cap of the policy; got %v", err)
+	}
+}
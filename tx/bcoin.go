@@ -0,0 +1,681 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package tx
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"time"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/go-redis/redis"
+	"github.com/jinzhu/gorm"
+	redisutil "github.com/kthomas/go-redisutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/contract"
+	"github.com/provideplatform/nchain/network"
+	provideapi "github.com/provideplatform/provide-go/api/nchain"
+)
+
+// bcoinCoinReservationTTL is the amount of time a coin selected as the input of a tx is excluded
+// from subsequent coin selection while the tx is signed and broadcast
+const bcoinCoinReservationTTL = time.Minute * 10
+
+// bcoinBroadcastCoinReservationTTL is the amount of time the coins spent by a broadcast tx remain
+// reserved; the coins are released once the receipt of the tx is resolved, so the TTL only bounds
+// the reservations of txs which are never included in a block
+const bcoinBroadcastCoinReservationTTL = time.Hour * 24 * 7
+
+// bcoinCoinbaseMaturity is the number of blocks which must be mined on top of a coinbase tx before it can be spent
+const bcoinCoinbaseMaturity = 100
+
+// bcoinDustThreshold is the smallest output value, in satoshis, relayed by the network; change
+// below the threshold is added to the fee
+const bcoinDustThreshold = int64(546)
+
+// bcoinFeeRateTargetBlocks is the number of blocks within which a tx should be included, used to estimate the fee rate
+const bcoinFeeRateTargetBlocks = 6
+
+// serialized sizes of the components of a tx spending P2PKH outputs
+const bcoinTxOverheadSize = int64(10)
+const bcoinP2PKHInputSize = int64(148)
+const bcoinOutputSize = int64(34)
+
+// psbt (BIP-174) encoding
+var psbtMagic = []byte{0x70, 0x73, 0x62, 0x74, 0xff}
+
+const psbtGlobalUnsignedTx = byte(0x00)
+const psbtInNonWitnessUTXO = byte(0x00)
+const psbtInPartialSig = byte(0x02)
+const psbtInFinalScriptSig = byte(0x07)
+const psbtSeparator = byte(0x00)
+
+// bcoinTx is a signed tx spending the P2PKH outputs of the signer on a bcoin network
+type bcoinTx struct {
+	tx          *wire.MsgTx
+	psbt        *bcoinPSBT
+	fee         int64
+	reservation *coinReservation
+}
+
+// bcoinPSBT is a partially signed bitcoin transaction (BIP-174) spending P2PKH outputs
+type bcoinPSBT struct {
+	unsignedTx      *wire.MsgTx
+	prevTxs         []*wire.MsgTx       // the txs which created the outputs spent by each input
+	partialSigs     []map[string][]byte // the signatures of each input, keyed by compressed public key
+	finalScriptSigs [][]byte
+}
+
+// coinReservation is the set of coins reserved as the inputs of a tx on behalf of a signer
+type coinReservation struct {
+	networkID uuid.UUID
+	address   string
+	outpoints []string
+}
+
+// heldCoinReservation is the cached reservation of the coins spent by a broadcast tx
+type heldCoinReservation struct {
+	Address   string   `json:"address"`
+	Outpoints []string `json:"outpoints"`
+}
+
+// CoinReservationKey returns the key for the given network id and signer address, which is guaranteed to be
+// unique-per-signer-per-network; the key represents the namespace where the coins reserved by the signer are cached
+func CoinReservationKey(networkID uuid.UUID, address string) string {
+	return fmt.Sprintf("network.%s.signer.%s.coins", networkID.String(), address)
+}
+
+// CoinReservationMutexKey returns a key for the given network id and signer address, which represents
+// the distributed lock used to select and reserve coins for the signer
+func CoinReservationMutexKey(networkID uuid.UUID, address string) string {
+	return fmt.Sprintf("%s.mutex", CoinReservationKey(networkID, address))
+}
+
+// CoinReservationTxKey returns the key for the given network id and tx hash, which represents the
+// namespace where the reservation of the coins spent by the broadcast tx is cached
+func CoinReservationTxKey(networkID uuid.UUID, hash string) string {
+	return fmt.Sprintf("network.%s.tx.%s.coins", networkID.String(), hash)
+}
+
+// readReservedCoins returns the unexpired coin reservations cached at the given key, mapping each reserved
+// outpoint to its expiry; an error is returned if the reservations cannot be read, as writing the reservations
+// of a signer without them would release the coins reserved by its other txs
+func readReservedCoins(key string) (map[string]time.Time, error) {
+	reserved := map[string]time.Time{}
+
+	rawreserved, err := redisutil.Get(key)
+	if err == redis.Nil || (err == nil && rawreserved == nil) {
+		return reserved, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read cached coin reservations from key: %s; %s", key, err.Error())
+	}
+
+	err = json.Unmarshal([]byte(*rawreserved), &reserved)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal cached coin reservations from key: %s; %s", key, err.Error())
+	}
+
+	for outpoint, expiresAt := range reserved {
+		if time.Now().After(expiresAt) {
+			delete(reserved, outpoint)
+		}
+	}
+
+	return reserved, nil
+}
+
+// writeReservedCoins caches the given coin reservations at the given key until the last of them expires
+func writeReservedCoins(key string, reserved map[string]time.Time) error {
+	ttl := bcoinCoinReservationTTL
+	for _, expiresAt := range reserved {
+		if remaining := time.Until(expiresAt); remaining > ttl {
+			ttl = remaining
+		}
+	}
+
+	payload, _ := json.Marshal(reserved)
+	return redisutil.Set(key, string(payload), &ttl)
+}
+
+// bcoinOutpoint returns the outpoint of the given coin, i.e., <txid>:<index>
+func bcoinOutpoint(coin *network.BcoinCoin) string {
+	return fmt.Sprintf("%s:%d", coin.Hash, coin.Index)
+}
+
+// bcoinTxSize returns the estimated serialized size of a tx with the given number of P2PKH inputs and outputs
+func bcoinTxSize(inputs, outputs int) int64 {
+	return bcoinTxOverheadSize + int64(inputs)*bcoinP2PKHInputSize + int64(outputs)*bcoinOutputSize
+}
+
+// selectCoins selects, largest first, the spendable coins which fund the given amount and the
+// fee at the given fee rate; returns the selected coins, the fee and the change. Only P2PKH
+// outputs paying the hash of the compressed public key of the signer (i.e., the given script)
+// are spendable; outputs paying its uncompressed public key hash, or segwit or P2SH outputs,
+// are not selected
+func selectCoins(coins []*network.BcoinCoin, script []byte, amount int64, feeRate uint64, height uint64) ([]*network.BcoinCoin, int64, int64, error) {
+	spendable := make([]*network.BcoinCoin, 0)
+	unsupported := 0
+	for _, coin := range coins {
+		if coin.Script != hex.EncodeToString(script) {
+			unsupported++
+			continue // only P2PKH outputs paying the compressed public key hash of the signer can be spent
+		}
+		if coin.Coinbase && (coin.Height < 0 || uint64(coin.Height)+bcoinCoinbaseMaturity > height) {
+			continue
+		}
+		spendable = append(spendable, coin)
+	}
+	sort.Slice(spendable, func(i, j int) bool { return spendable[i].Value > spendable[j].Value })
+
+	selected := make([]*network.BcoinCoin, 0)
+	var total int64
+	for _, coin := range spendable {
+		selected = append(selected, coin)
+		total += coin.Value
+
+		fee := bcoinTxSize(len(selected), 2) * int64(feeRate)
+		if total < amount+fee {
+			continue
+		}
+
+		change := total - amount - fee
+		if change < bcoinDustThreshold {
+			// drop the change output and add the change to the fee
+			return selected, total - amount, 0, nil
+		}
+		return selected, fee, change, nil
+	}
+
+	if unsupported > 0 {
+		return nil, 0, 0, fmt.Errorf("insufficient funds; %d satoshis spendable in %d coin(s); %d coin(s) not spendable, as only P2PKH outputs paying the compressed public key hash of the signer are supported", total, len(spendable), unsupported)
+	}
+	return nil, 0, 0, fmt.Errorf("insufficient funds; %d satoshis spendable in %d coin(s)", total, len(spendable))
+}
+
+// reserveCoins atomically selects and reserves coins owned by the given signer address on the given network
+func reserveCoins(ntwrk *network.Network, address string, script []byte, amount int64, feeRate uint64) (*coinReservation, []*network.BcoinCoin, int64, int64, error) {
+	var reservation *coinReservation
+	var selected []*network.BcoinCoin
+	var fee, change int64
+
+	err := redisutil.WithRedlock(CoinReservationMutexKey(ntwrk.ID, address), func() error {
+		coins, err := ntwrk.BcoinCoins(address)
+		if err != nil {
+			return err
+		}
+
+		height, err := ntwrk.BcoinChainHeight()
+		if err != nil {
+			return fmt.Errorf("failed to resolve chain height; %s", err.Error())
+		}
+
+		key := CoinReservationKey(ntwrk.ID, address)
+		reserved, err := readReservedCoins(key)
+		if err != nil {
+			return err
+		}
+
+		available := make([]*network.BcoinCoin, 0)
+		for _, coin := range coins {
+			if _, isReserved := reserved[bcoinOutpoint(coin)]; !isReserved {
+				available = append(available, coin)
+			}
+		}
+
+		selected, fee, change, err = selectCoins(available, script, amount, feeRate, height)
+		if err != nil {
+			return err
+		}
+
+		reservation = &coinReservation{
+			networkID: ntwrk.ID,
+			address:   address,
+			outpoints: make([]string, 0),
+		}
+
+		expiresAt := time.Now().Add(bcoinCoinReservationTTL)
+		for _, coin := range selected {
+			outpoint := bcoinOutpoint(coin)
+			reserved[outpoint] = expiresAt
+			reservation.outpoints = append(reservation.outpoints, outpoint)
+		}
+
+		err = writeReservedCoins(key, reserved)
+		if err != nil {
+			return fmt.Errorf("failed to cache coin reservations for signer: %s; %s", address, err.Error())
+		}
+
+		common.Log.Debugf("reserved %d coin(s) for signer %s on network: %s", len(selected), address, ntwrk.ID)
+		return nil
+	})
+
+	if err != nil {
+		return nil, nil, 0, 0, err
+	}
+
+	return reservation, selected, fee, change, nil
+}
+
+// hold the reserved coins until the receipt of the broadcast tx with the given hash is resolved
+func (r *coinReservation) hold(hash string) error {
+	payload, _ := json.Marshal(&heldCoinReservation{
+		Address:   r.address,
+		Outpoints: r.outpoints,
+	})
+	ttl := bcoinBroadcastCoinReservationTTL
+	err := redisutil.Set(CoinReservationTxKey(r.networkID, hash), string(payload), &ttl)
+	if err != nil {
+		return err
+	}
+
+	return redisutil.WithRedlock(CoinReservationMutexKey(r.networkID, r.address), func() error {
+		key := CoinReservationKey(r.networkID, r.address)
+		reserved, err := readReservedCoins(key)
+		if err != nil {
+			return err
+		}
+
+		expiresAt := time.Now().Add(bcoinBroadcastCoinReservationTTL)
+		for _, outpoint := range r.outpoints {
+			reserved[outpoint] = expiresAt
+		}
+
+		common.Log.Debugf("holding %d coin(s) reserved for signer %s on network: %s until receipt of tx %s is resolved", len(r.outpoints), r.address, r.networkID, hash)
+		return writeReservedCoins(key, reserved)
+	})
+}
+
+// releaseHeldCoins releases the coins held for the broadcast tx with the given hash, if any; releasing
+// the coins more than once is a no-op, as the spent coins cannot be selected again
+func releaseHeldCoins(networkID uuid.UUID, hash string) error {
+	raw, err := redisutil.Get(CoinReservationTxKey(networkID, hash))
+	if err != nil || raw == nil {
+		return nil
+	}
+
+	var held *heldCoinReservation
+	err = json.Unmarshal([]byte(*raw), &held)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal coins held for tx: %s; %s", hash, err.Error())
+	}
+
+	reservation := &coinReservation{
+		networkID: networkID,
+		address:   held.Address,
+		outpoints: held.Outpoints,
+	}
+	return reservation.release()
+}
+
+// release the reserved coins so they can be selected by a subsequent tx
+func (r *coinReservation) release() error {
+	return redisutil.WithRedlock(CoinReservationMutexKey(r.networkID, r.address), func() error {
+		key := CoinReservationKey(r.networkID, r.address)
+		reserved, err := readReservedCoins(key)
+		if err != nil {
+			return err
+		}
+
+		for _, outpoint := range r.outpoints {
+			delete(reserved, outpoint)
+		}
+
+		common.Log.Debugf("released %d coin(s) reserved for signer %s on network: %s", len(r.outpoints), r.address, r.networkID)
+		return writeReservedCoins(key, reserved)
+	})
+}
+
+// fetchBcoinTx returns the tx with the given hash; the bcoin node must be run with the tx index enabled (i.e., --index-tx)
+func fetchBcoinTx(ntwrk *network.Network, hash string) (*wire.MsgTx, error) {
+	var rawTx string
+	err := ntwrk.BcoinRPCClientCall(&rawTx, "getrawtransaction", hash, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tx: %s; %s", hash, err.Error())
+	}
+
+	raw, err := hex.DecodeString(rawTx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode tx: %s; %s", hash, err.Error())
+	}
+
+	tx := &wire.MsgTx{}
+	err = tx.Deserialize(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize tx: %s; %s", hash, err.Error())
+	}
+
+	return tx, nil
+}
+
+// signBcoinTx builds, signs and returns a tx which sends the tx value, in satoshis, to the tx recipient
+// using coins owned by the signing account; coin selection is largest-first and the fee rate is
+// estimated by the network unless a fee_rate (in satoshis per byte) is provided in the tx params
+func (txs *TransactionSigner) signBcoinTx(tx *Transaction, custodian digestSigner) (signedTx interface{}, hash []byte, err error) {
+	if txs.Account == nil || txs.Account.VaultID == nil || txs.Account.KeyID == nil || txs.Account.PublicKey == nil {
+		return nil, nil, errors.New("bcoin txs must be signed using a vault-backed account")
+	}
+
+	if tx.To == nil {
+		return nil, nil, errors.New("bcoin txs require a recipient address")
+	}
+
+	amount := tx.Value.BigInt()
+	if amount == nil || amount.Sign() <= 0 || !amount.IsInt64() {
+		return nil, nil, errors.New("bcoin txs require a positive value, in satoshis")
+	} else if amount.Int64() < bcoinDustThreshold {
+		return nil, nil, fmt.Errorf("bcoin tx value of %d satoshis is below the dust threshold of %d satoshis", amount.Int64(), bcoinDustThreshold)
+	}
+
+	params, err := txs.Network.BcoinChainParams()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	recipient, err := btcutil.DecodeAddress(*tx.To, params)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid recipient address: %s; %s", *tx.To, err.Error())
+	}
+
+	recipientScript, err := txscript.PayToAddrScript(recipient)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid recipient address: %s; %s", *tx.To, err.Error())
+	}
+
+	pubkey, err := network.ParseSecp256k1PublicKey(*txs.Account.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sender, err := btcutil.NewAddressPubKeyHash(btcutil.Hash160(pubkey.SerializeCompressed()), params)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	senderScript, err := txscript.PayToAddrScript(sender)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	txParams := tx.ParseParams()
+	feeRate, feeRateOk := txParams["fee_rate"].(float64)
+	if !feeRateOk || feeRate <= 0 {
+		feeRate = float64(txs.Network.BcoinFeeRate(bcoinFeeRateTargetBlocks))
+	}
+
+	reservation, coins, fee, change, err := reserveCoins(txs.Network, sender.EncodeAddress(), senderScript, amount.Int64(), uint64(feeRate))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to select coins for signer: %s; %s", sender.EncodeAddress(), err.Error())
+	}
+
+	defer func() {
+		if err != nil {
+			releaseErr := reservation.release()
+			if releaseErr != nil {
+				common.Log.Warningf("failed to release coins reserved for signer: %s; %s", sender.EncodeAddress(), releaseErr.Error())
+			}
+		}
+	}()
+
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	psbt := &bcoinPSBT{
+		prevTxs:         make([]*wire.MsgTx, 0),
+		partialSigs:     make([]map[string][]byte, 0),
+		finalScriptSigs: make([][]byte, 0),
+	}
+
+	for _, coin := range coins {
+		prevHash, err := chainhash.NewHashFromStr(coin.Hash)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid coin hash: %s; %s", coin.Hash, err.Error())
+		}
+
+		prevTx, err := fetchBcoinTx(txs.Network, coin.Hash)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(prevHash, coin.Index), nil, nil))
+		psbt.prevTxs = append(psbt.prevTxs, prevTx)
+		psbt.partialSigs = append(psbt.partialSigs, map[string][]byte{})
+		psbt.finalScriptSigs = append(psbt.finalScriptSigs, nil)
+	}
+
+	msgTx.AddTxOut(wire.NewTxOut(amount.Int64(), recipientScript))
+	if change > 0 {
+		msgTx.AddTxOut(wire.NewTxOut(change, senderScript))
+	}
+	psbt.unsignedTx = msgTx.Copy()
+
+	for i := range msgTx.TxIn {
+		digest, err := txscript.CalcSignatureHash(senderScript, txscript.SigHashAll, msgTx, i)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to calculate signature hash for input %d; %s", i, err.Error())
+		}
+
+		_sig, err := custodian.signAccountDigest(digest)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to sign input %d using signing account %s; %s", i, txs.Account.Address, err.Error())
+		}
+
+		if len(_sig) < 64 {
+			return nil, nil, fmt.Errorf("failed to sign input %d using signing account %s; invalid %d-byte signature", i, txs.Account.Address, len(_sig))
+		}
+
+		sig := &btcec.Signature{
+			R: new(big.Int).SetBytes(_sig[0:32]),
+			S: new(big.Int).SetBytes(_sig[32:64]),
+		}
+		if !sig.Verify(digest, pubkey) {
+			return nil, nil, fmt.Errorf("failed to verify signature of input %d using signing account %s", i, txs.Account.Address)
+		}
+
+		// DER-encoded, with a canonical low S value
+		derSig := append(sig.Serialize(), byte(txscript.SigHashAll))
+		psbt.partialSigs[i][string(pubkey.SerializeCompressed())] = derSig
+
+		scriptSig, err := txscript.NewScriptBuilder().AddData(derSig).AddData(pubkey.SerializeCompressed()).Script()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to build signature script for input %d; %s", i, err.Error())
+		}
+		msgTx.TxIn[i].SignatureScript = scriptSig
+		psbt.finalScriptSigs[i] = scriptSig
+	}
+
+	encodedPSBT, err := psbt.encode()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	txParams["fee"] = fee
+	txParams["fee_rate"] = feeRate
+	txParams["psbt"] = encodedPSBT
+	tx.setParams(txParams)
+
+	hash, err = hex.DecodeString(msgTx.TxHash().String())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	common.Log.Debugf("signed %d-input bcoin tx %s paying %d satoshi fee; signer: %s", len(msgTx.TxIn), msgTx.TxHash().String(), fee, sender.EncodeAddress())
+	return &bcoinTx{
+		tx:          msgTx,
+		psbt:        psbt,
+		fee:         fee,
+		reservation: reservation,
+	}, hash, nil
+}
+
+// writePSBTKeyValue writes a single psbt key-value pair
+func writePSBTKeyValue(buf *bytes.Buffer, keyType byte, keyData, value []byte) error {
+	err := wire.WriteVarBytes(buf, 0, append([]byte{keyType}, keyData...))
+	if err != nil {
+		return err
+	}
+	return wire.WriteVarBytes(buf, 0, value)
+}
+
+// encode returns the base64-encoded psbt; inputs which have been finalized include their
+// final signature script in place of their partial signatures
+func (p *bcoinPSBT) encode() (string, error) {
+	buf := &bytes.Buffer{}
+	buf.Write(psbtMagic)
+
+	unsignedTx := &bytes.Buffer{}
+	err := p.unsignedTx.SerializeNoWitness(unsignedTx)
+	if err != nil {
+		return "", fmt.Errorf("failed to serialize unsigned tx; %s", err.Error())
+	}
+
+	err = writePSBTKeyValue(buf, psbtGlobalUnsignedTx, nil, unsignedTx.Bytes())
+	if err != nil {
+		return "", err
+	}
+	buf.WriteByte(psbtSeparator)
+
+	for i, prevTx := range p.prevTxs {
+		rawPrevTx := &bytes.Buffer{}
+		err := prevTx.Serialize(rawPrevTx)
+		if err != nil {
+			return "", fmt.Errorf("failed to serialize tx spent by input %d; %s", i, err.Error())
+		}
+
+		err = writePSBTKeyValue(buf, psbtInNonWitnessUTXO, nil, rawPrevTx.Bytes())
+		if err != nil {
+			return "", err
+		}
+
+		if p.finalScriptSigs[i] != nil {
+			err = writePSBTKeyValue(buf, psbtInFinalScriptSig, nil, p.finalScriptSigs[i])
+		} else {
+			for pubkey, sig := range p.partialSigs[i] {
+				err = writePSBTKeyValue(buf, psbtInPartialSig, []byte(pubkey), sig)
+				if err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+		buf.WriteByte(psbtSeparator)
+	}
+
+	for range p.unsignedTx.TxOut {
+		buf.WriteByte(psbtSeparator)
+	}
+
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// broadcastBcoinTx emits the given signed tx for inclusion in a block; the coins spent by
+// the tx are released if the tx is rejected, and otherwise held until its receipt is resolved
+func broadcastBcoinTx(ntwrk *network.Network, signedTx *bcoinTx) error {
+	raw := &bytes.Buffer{}
+	err := signedTx.tx.Serialize(raw)
+	if err != nil {
+		return fmt.Errorf("failed to serialize signed bcoin tx; %s", err.Error())
+	}
+
+	var hash string
+	err = ntwrk.BcoinRPCClientCall(&hash, "sendrawtransaction", hex.EncodeToString(raw.Bytes()))
+	if err != nil {
+		if signedTx.reservation != nil {
+			releaseErr := signedTx.reservation.release()
+			if releaseErr != nil {
+				common.Log.Warningf("failed to release coins reserved for signer: %s; %s", signedTx.reservation.address, releaseErr.Error())
+			}
+		}
+		return fmt.Errorf("failed to transmit signed tx to JSON-RPC host; %s", err.Error())
+	}
+
+	if signedTx.reservation != nil {
+		err = signedTx.reservation.hold(signedTx.tx.TxHash().String())
+		if err != nil {
+			common.Log.Warningf("failed to hold coins reserved for signer: %s until receipt of tx %s is resolved; %s", signedTx.reservation.address, signedTx.tx.TxHash().String(), err.Error())
+		}
+	}
+
+	return nil
+}
+
+// fetchBcoinReceipt resolves the receipt of the tx once it has been included in a block and has
+// reached the configured number of confirmations, releasing the coins held for the tx; bcoin txs
+// have no traces
+func (t *Transaction) fetchBcoinReceipt(db *gorm.DB, ntwrk *network.Network, signerAddress string) error {
+	if t.Hash == nil {
+		return fmt.Errorf("unable to fetch tx receipt for nil tx hash; tx id: %s", t.ID)
+	}
+
+	var rawTx struct {
+		BlockHash     string `json:"blockhash"`
+		Confirmations uint64 `json:"confirmations"`
+	}
+	err := ntwrk.BcoinRPCClientCall(&rawTx, "getrawtransaction", *t.Hash, true)
+	if err != nil {
+		return err
+	}
+
+	if rawTx.BlockHash == "" {
+		return fmt.Errorf("tx %s has not been included in a block", *t.Hash)
+	}
+
+	confirmations := ntwrk.Confirmations()
+	if confirmations == 0 {
+		confirmations = 1
+	}
+	if rawTx.Confirmations < confirmations {
+		return fmt.Errorf("tx %s has not reached %d confirmation(s)", *t.Hash, confirmations)
+	}
+
+	height, err := ntwrk.BcoinBlockHeight(rawTx.BlockHash)
+	if err != nil {
+		return err
+	}
+
+	err = releaseHeldCoins(ntwrk.ID, *t.Hash)
+	if err != nil {
+		common.Log.Warningf("failed to release coins held for tx: %s; %s", *t.Hash, err.Error())
+	}
+
+	txHash, _ := hex.DecodeString(*t.Hash)
+	blockHash, _ := hex.DecodeString(rawTx.BlockHash)
+	receipt := &provideapi.TxReceipt{
+		TxHash:      txHash,
+		BlockHash:   blockHash,
+		BlockNumber: new(big.Int).SetUint64(height),
+		Status:      1,
+		Logs:        make([]interface{}, 0),
+	}
+
+	common.Log.Debugf("fetched bcoin tx receipt for tx hash: %s", *t.Hash)
+	t.Response = &contract.ExecutionResponse{
+		Receipt:     receipt,
+		Transaction: t,
+	}
+
+	return t.handleTxReceipt(db, ntwrk, signerAddress, receipt)
+}
@@ -0,0 +1,297 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tx
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/internal/testutil"
+	"github.com/provideplatform/nchain/network"
+	"github.com/provideplatform/nchain/wallet"
+)
+
+var bcoinSignerScript = []byte{0x76, 0xa9, 0x14, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11, 0x12, 0x13, 0x14, 0x88, 0xac}
+
+func bcoinCoin(hash string, value int64) *network.BcoinCoin {
+	return &network.BcoinCoin{
+		Height: 10,
+		Value:  value,
+		Script: hex.EncodeToString(bcoinSignerScript),
+		Hash:   hash,
+	}
+}
+
+func TestBcoinTxSize(t *testing.T) {
+	if size := bcoinTxSize(1, 2); size != 226 {
+		t.Errorf("expected 1-input, 2-output tx to be 226 bytes; got %d", size)
+	}
+	if size := bcoinTxSize(3, 1); size != 488 {
+		t.Errorf("expected 3-input, 1-output tx to be 488 bytes; got %d", size)
+	}
+}
+
+func TestSelectCoinsLargestFirstWithChange(t *testing.T) {
+	coins := []*network.BcoinCoin{
+		bcoinCoin("aa", 10000),
+		bcoinCoin("bb", 50000),
+		bcoinCoin("cc", 20000),
+	}
+
+	selected, fee, change, err := selectCoins(coins, bcoinSignerScript, 40000, 10, 1000)
+	if err != nil {
+		t.Fatalf("failed to select coins; %s", err.Error())
+	}
+	if len(selected) != 1 || selected[0].Hash != "bb" {
+		t.Fatalf("expected largest coin to be selected; got %v", selected)
+	}
+	if fee != 2260 {
+		t.Errorf("expected fee of 226 bytes at 10 satoshis per byte; got %d", fee)
+	}
+	if change != 50000-40000-2260 {
+		t.Errorf("expected change of %d; got %d", 50000-40000-2260, change)
+	}
+}
+
+func TestSelectCoinsAddsDustChangeToFee(t *testing.T) {
+	coins := []*network.BcoinCoin{bcoinCoin("aa", 42500)}
+
+	_, fee, change, err := selectCoins(coins, bcoinSignerScript, 40000, 10, 1000)
+	if err != nil {
+		t.Fatalf("failed to select coins; %s", err.Error())
+	}
+	if change != 0 {
+		t.Errorf("expected dust change to be dropped; got %d", change)
+	}
+	if fee != 2500 {
+		t.Errorf("expected dust change to be added to the fee; got %d", fee)
+	}
+}
+
+func TestSelectCoinsSkipsUnspendableCoins(t *testing.T) {
+	immature := bcoinCoin("aa", 100000)
+	immature.Coinbase = true
+	immature.Height = 950
+
+	uncompressed := bcoinCoin("bb", 100000)
+	uncompressed.Script = "76a914ffffffffffffffffffffffffffffffffffffffff88ac"
+
+	_, _, _, err := selectCoins([]*network.BcoinCoin{immature, uncompressed, bcoinCoin("cc", 1000)}, bcoinSignerScript, 40000, 10, 1000)
+	if err == nil {
+		t.Fatal("expected insufficient funds")
+	}
+	if !strings.Contains(err.Error(), "compressed public key hash") {
+		t.Errorf("expected error to document that only compressed P2PKH outputs are spendable; got %s", err.Error())
+	}
+}
+
+func TestBcoinPSBTEncoding(t *testing.T) {
+	prevTx := wire.NewMsgTx(wire.TxVersion)
+	prevTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{}, 0), nil, nil))
+	prevTx.AddTxOut(wire.NewTxOut(50000, bcoinSignerScript))
+	prevHash := prevTx.TxHash()
+
+	unsignedTx := wire.NewMsgTx(wire.TxVersion)
+	unsignedTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prevHash, 0), nil, nil))
+	unsignedTx.AddTxOut(wire.NewTxOut(40000, bcoinSignerScript))
+
+	scriptSig := []byte{0x01, 0x02, 0x03}
+	psbt := &bcoinPSBT{
+		unsignedTx:      unsignedTx,
+		prevTxs:         []*wire.MsgTx{prevTx},
+		partialSigs:     []map[string][]byte{{}},
+		finalScriptSigs: [][]byte{scriptSig},
+	}
+
+	encoded, err := psbt.encode()
+	if err != nil {
+		t.Fatalf("failed to encode psbt; %s", err.Error())
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatalf("failed to decode psbt; %s", err.Error())
+	}
+	if !bytes.HasPrefix(raw, psbtMagic) {
+		t.Fatal("expected psbt magic")
+	}
+
+	// read the key-value maps; each map is terminated by a separator
+	r := bytes.NewReader(raw[len(psbtMagic):])
+	maps := make([]map[byte][]byte, 0)
+	current := map[byte][]byte{}
+	for r.Len() > 0 {
+		key, err := wire.ReadVarBytes(r, 0, 1<<20, "key")
+		if err != nil {
+			t.Fatalf("failed to read psbt key; %s", err.Error())
+		}
+		if len(key) == 0 {
+			maps = append(maps, current)
+			current = map[byte][]byte{}
+			continue
+		}
+		value, err := wire.ReadVarBytes(r, 0, 1<<20, "value")
+		if err != nil {
+			t.Fatalf("failed to read psbt value; %s", err.Error())
+		}
+		current[key[0]] = value
+	}
+
+	if len(maps) != 3 {
+		t.Fatalf("expected global, input and output maps; got %d map(s)", len(maps))
+	}
+
+	rawUnsignedTx := &bytes.Buffer{}
+	unsignedTx.SerializeNoWitness(rawUnsignedTx)
+	if !bytes.Equal(maps[0][psbtGlobalUnsignedTx], rawUnsignedTx.Bytes()) {
+		t.Error("expected global unsigned tx")
+	}
+
+	rawPrevTx := &bytes.Buffer{}
+	prevTx.Serialize(rawPrevTx)
+	if !bytes.Equal(maps[1][psbtInNonWitnessUTXO], rawPrevTx.Bytes()) {
+		t.Error("expected non-witness utxo of the input")
+	}
+	if !bytes.Equal(maps[1][psbtInFinalScriptSig], scriptSig) {
+		t.Error("expected final script sig of the input")
+	}
+	if _, ok := maps[1][psbtInPartialSig]; ok {
+		t.Error("expected finalized input to omit partial sigs")
+	}
+}
+
+func TestHeldCoinsRemainReservedUntilReleased(t *testing.T) {
+	testutil.NewMockRedis(t)
+	networkID, _ := uuid.NewV4()
+	address := "mjSk1Ny9spzU2fouzYgLqGUD8U41iR35QN"
+
+	reservation := &coinReservation{
+		networkID: networkID,
+		address:   address,
+		outpoints: []string{"aa:0", "bb:1"},
+	}
+
+	err := reservation.hold("cc")
+	if err != nil {
+		t.Fatalf("failed to hold coins; %s", err.Error())
+	}
+
+	reserved, err := readReservedCoins(CoinReservationKey(networkID, address))
+	if err != nil {
+		t.Fatalf("failed to read reserved coins; %s", err.Error())
+	}
+	for _, outpoint := range reservation.outpoints {
+		expiresAt, ok := reserved[outpoint]
+		if !ok {
+			t.Fatalf("expected %s to be reserved", outpoint)
+		}
+		if time.Until(expiresAt) <= bcoinCoinReservationTTL {
+			t.Errorf("expected %s to be held beyond the selection TTL", outpoint)
+		}
+	}
+
+	err = releaseHeldCoins(networkID, "cc")
+	if err != nil {
+		t.Fatalf("failed to release held coins; %s", err.Error())
+	}
+
+	reserved, err = readReservedCoins(CoinReservationKey(networkID, address))
+	if err != nil {
+		t.Fatalf("failed to read reserved coins; %s", err.Error())
+	}
+	if len(reserved) != 0 {
+		t.Errorf("expected held coins to be released; got %v", reserved)
+	}
+
+	err = releaseHeldCoins(networkID, "dd")
+	if err != nil {
+		t.Errorf("expected release of tx without held coins to be a no-op; %s", err.Error())
+	}
+}
+
+func TestReleaseFailsWhenReservedCoinsCannotBeRead(t *testing.T) {
+	server := testutil.NewMockRedis(t)
+	networkID, _ := uuid.NewV4()
+	address := "mtXWDB6k5yC5v7TcwKZHB89SUp85yCKshy"
+
+	reservation := &coinReservation{
+		networkID: networkID,
+		address:   address,
+		outpoints: []string{"aa:0", "bb:1"},
+	}
+
+	err := reservation.hold("cc")
+	if err != nil {
+		t.Fatalf("failed to hold coins; %s", err.Error())
+	}
+
+	key := CoinReservationKey(networkID, address)
+	server.SetError("READONLY unavailable")
+	_, err = readReservedCoins(key)
+	if err == nil {
+		t.Error("expected reserved coins to not be read when the cache is unavailable")
+	}
+
+	released := &coinReservation{
+		networkID: networkID,
+		address:   address,
+		outpoints: []string{"aa:0"},
+	}
+	err = released.release()
+	if err == nil {
+		t.Error("expected release to fail when the reserved coins cannot be read")
+	}
+
+	server.SetError("")
+	reserved, err := readReservedCoins(key)
+	if err != nil {
+		t.Fatalf("failed to read reserved coins; %s", err.Error())
+	}
+	if len(reserved) != 2 {
+		t.Errorf("expected the existing reservations to be retained; got %v", reserved)
+	}
+}
+
+func TestSignBcoinTxRejectsDustValue(t *testing.T) {
+	vaultID, _ := uuid.NewV4()
+	keyID, _ := uuid.NewV4()
+	publicKey := "02a1633cafcc01ebfb6d78e39f687a1f0995c62fc95f51ead10a02ee0be551b5dc"
+	to := "mtXWDB6k5yC5v7TcwKZHB89SUp85yCKshy"
+
+	txs := &TransactionSigner{
+		Account: &wallet.Account{
+			VaultID:   &vaultID,
+			KeyID:     &keyID,
+			PublicKey: &publicKey,
+		},
+	}
+
+	_, _, err := txs.signBcoinTx(&Transaction{To: &to, Value: NewTxValue(bcoinDustThreshold - 1)}, nil)
+	if err == nil || !strings.Contains(err.Error(), "dust threshold") {
+		t.Errorf("expected value below the dust threshold to be rejected before coins are reserved; got %v", err)
+	}
+}
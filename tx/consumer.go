@@ -24,6 +24,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/jinzhu/gorm"
 	dbconf "github.com/kthomas/go-db-config"
 	natsutil "github.com/kthomas/go-natsutil"
@@ -31,11 +32,15 @@ import (
 	"github.com/nats-io/nats.go"
 	"github.com/provideplatform/nchain/common"
 	"github.com/provideplatform/nchain/contract"
+	"github.com/provideplatform/nchain/deadletter"
+	"github.com/provideplatform/nchain/network"
 	"github.com/provideplatform/nchain/wallet"
+	"github.com/provideplatform/nchain/webhook"
 	api "github.com/provideplatform/provide-go/api"
 	bookie "github.com/provideplatform/provide-go/api/bookie"
 	provide "github.com/provideplatform/provide-go/api/nchain"
 	util "github.com/provideplatform/provide-go/common/util"
+	providecrypto "github.com/provideplatform/provide-go/crypto"
 )
 
 const defaultNatsStream = "nchain"
@@ -52,6 +57,18 @@ const natsTxCreateMaxInFlight = 1024 * 30
 const natsTxCreateMaxDeliveries = 5
 const txCreateAckWait = time.Second * 60
 
+// txCreateRetryDelay is the redelivery delay of a tx create message, or held tx create message,
+// which failed due to an error which may not recur (i.e., a db error)
+const txCreateRetryDelay = time.Second * 30
+
+// natsScheduledTxFailedSubject is the subject on which failures to create txs published by scheduled
+// txs are published; see the schedule package
+const natsScheduledTxFailedSubject = "nchain.schedule.failed"
+
+// natsTxFailedSubject is the subject on which the ids of failed txs are published, so packages which
+// submit txs on behalf of other resources (i.e., Safe proposals) can react to the failure
+const natsTxFailedSubject = "nchain.tx.failed"
+
 const natsTxFinalizeSubject = "nchain.tx.finalize"
 const natsTxFinalizeMaxInFlight = 1024 * 30
 const natsTxFinalizedMsgMaxDeliveries = 100
@@ -62,6 +79,15 @@ const natsTxReceiptMaxInFlight = 1024 * 30
 const natsTxReceiptMsgMaxDeliveries = 100
 const txReceiptAckWait = time.Second * 5
 
+// txReceiptConfirmationsDelay is the redelivery delay of a tx receipt message while the
+// block which included the tx has not reached the configured number of confirmations
+const txReceiptConfirmationsDelay = time.Second * 15
+
+const natsBlockReorgSubject = "nchain.block.reorg"
+const natsBlockReorgMaxInFlight = 1024
+const natsBlockReorgMsgMaxDeliveries = 10
+const blockReorgAckWait = time.Second * 30
+
 var waitGroup sync.WaitGroup
 
 func init() {
@@ -79,6 +105,8 @@ func init() {
 	createNatsTxCreateSubscriptions(&waitGroup)
 	createNatsTxFinalizeSubscriptions(&waitGroup)
 	createNatsTxReceiptSubscriptions(&waitGroup)
+	createNatsBlockReorgSubscriptions(&waitGroup)
+	runReceiptFeesBackfillTicker()
 }
 
 func createNatsTxSubscriptions(wg *sync.WaitGroup) {
@@ -145,6 +173,22 @@ func createNatsTxReceiptSubscriptions(wg *sync.WaitGroup) {
 	}
 }
 
+func createNatsBlockReorgSubscriptions(wg *sync.WaitGroup) {
+	for i := uint64(0); i < natsutil.GetNatsConsumerConcurrency(); i++ {
+		natsutil.RequireNatsJetstreamSubscription(wg,
+			blockReorgAckWait,
+			natsBlockReorgSubject,
+			natsBlockReorgSubject,
+			natsBlockReorgSubject,
+			consumeBlockReorgMsg,
+			blockReorgAckWait,
+			natsBlockReorgMaxInFlight,
+			natsBlockReorgMsgMaxDeliveries,
+			nil,
+		)
+	}
+}
+
 func consumeTxCreateMsg(msg *nats.Msg) {
 	common.Log.Debugf("consuming %d-byte NATS tx message on subject: %s", len(msg.Data), msg.Subject)
 
@@ -157,6 +201,17 @@ func consumeTxCreateMsg(msg *nats.Msg) {
 		return
 	}
 
+	if txID, txIDOk := params["transaction_id"].(string); txIDOk {
+		signerAddress, _ := params["signer_address"].(string)
+		consumeHeldTxCreateMsg(msg, txID, common.StringOrNil(signerAddress))
+		return
+	}
+
+	if _, txOk := params["transaction"].(map[string]interface{}); txOk {
+		consumeTransactionCreateMsg(msg)
+		return
+	}
+
 	contractID, contractIDOk := params["contract_id"]
 	data, dataOk := params["data"].(string)
 	accountIDStr, accountIDStrOk := params["account_id"].(string)
@@ -168,37 +223,37 @@ func consumeTxCreateMsg(msg *nats.Msg) {
 
 	if !contractIDOk {
 		common.Log.Warningf("failed to unmarshal contract_id during NATS %v message handling", msg.Subject)
-		msg.Term()
+		deadletter.Term(msg, "failed to unmarshal contract_id")
 		return
 	}
 
 	if !dataOk {
 		common.Log.Warningf("failed to unmarshal data during NATS %v message handling", msg.Subject)
-		msg.Term()
+		deadletter.Term(msg, "failed to unmarshal data")
 		return
 	}
 
 	if !accountIDStrOk && !walletIDStrOk {
 		common.Log.Warningf("failed to unmarshal account_id or wallet_id during NATS %v message handling", msg.Subject)
-		msg.Term()
+		deadletter.Term(msg, "failed to unmarshal account_id or wallet_id")
 		return
 	}
 
 	if !valueOk {
 		common.Log.Warningf("failed to unmarshal value during NATS %v message handling", msg.Subject)
-		msg.Term()
+		deadletter.Term(msg, "failed to unmarshal value")
 		return
 	}
 
 	if !paramsOk {
 		common.Log.Warningf("failed to unmarshal params during NATS %v message handling", msg.Subject)
-		msg.Term()
+		deadletter.Term(msg, "failed to unmarshal params")
 		return
 	}
 
 	if !publishedAtOk {
 		common.Log.Warningf("failed to unmarshal published_at during NATS %v message handling", msg.Subject)
-		msg.Term()
+		deadletter.Term(msg, "failed to unmarshal published_at")
 		return
 	}
 
@@ -221,14 +276,18 @@ func consumeTxCreateMsg(msg *nats.Msg) {
 
 	if accountID == nil && walletID == nil {
 		common.Log.Warningf("failed to unmarshal account_id or wallet_id during NATS %v message handling", msg.Subject)
-		msg.Term()
+		deadletter.Term(msg, "failed to unmarshal account_id or wallet_id")
 		return
 	}
 
 	publishedAtTime, err := time.Parse(time.RFC3339, publishedAt)
 	if err != nil {
 		common.Log.Warningf("failed to parse published_at as RFC3339 timestamp during NATS %v message handling; %s", msg.Subject, err.Error())
-		msg.Term()
+		deadletter.Term(msg, fmt.Sprintf("failed to parse published_at as RFC3339 timestamp; %s", err.Error()))
+		return
+	}
+
+	if deferThrottledMsg(msg, contract.NetworkID, natsTxCreateMaxDeliveries) {
 		return
 	}
 
@@ -249,7 +308,11 @@ func consumeTxCreateMsg(msg *nats.Msg) {
 	if tx.Create(db) {
 		contract.TransactionID = &tx.ID
 		db.Save(&contract)
-		common.Log.Debugf("transaction execution successful: %s", *tx.Hash)
+		if tx.Hash != nil {
+			common.Log.Debugf("transaction execution successful: %s", *tx.Hash)
+		} else {
+			common.Log.Debugf("transaction %s awaiting approval", tx.ID)
+		}
 		msg.Ack()
 	} else {
 		errmsg := fmt.Sprintf("failed to execute transaction; tx failed with %d error(s)", len(tx.Errors))
@@ -292,6 +355,183 @@ func consumeTxCreateMsg(msg *nats.Msg) {
 	}
 }
 
+// consumeTransactionCreateMsg creates the tx in the given message, as if it were posted to the
+// transactions API (i.e., when a scheduled tx fires)
+func consumeTransactionCreateMsg(msg *nats.Msg) {
+	var txCreateMsg struct {
+		Transaction *Transaction `json:"transaction"`
+		PublishedAt *time.Time   `json:"published_at"`
+
+		// scheduled tx which published the tx, if any, and its run
+		ScheduledTransactionID *uuid.UUID `json:"scheduled_transaction_id,omitempty"`
+		Run                    uint64     `json:"run,omitempty"`
+	}
+
+	err := json.Unmarshal(msg.Data, &txCreateMsg)
+	if err != nil {
+		common.Log.Warningf("failed to unmarshal tx during NATS %v message handling; %s", msg.Subject, err.Error())
+		deadletter.Term(msg, fmt.Sprintf("failed to unmarshal tx; %s", err.Error()))
+		return
+	}
+
+	// only the fields which can be posted to the transactions API are used to create the tx
+	tx := requestedTransaction(txCreateMsg.Transaction)
+	tx.PublishedAt = txCreateMsg.PublishedAt
+
+	if deferThrottledMsg(msg, tx.NetworkID, natsTxCreateMaxDeliveries) {
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	if tx.Create(db) {
+		common.Log.Debugf("created tx %s during NATS %v message handling", tx.ID, msg.Subject)
+		msg.Ack()
+		return
+	}
+
+	errmsg := fmt.Sprintf("failed to create transaction; tx failed with %d error(s)", len(tx.Errors))
+	for _, err := range tx.Errors {
+		errmsg = fmt.Sprintf("%s\n\t%s", errmsg, *err.Message)
+	}
+	common.Log.Warning(errmsg)
+
+	if db.NewRecord(tx) && tx.retryable {
+		common.NakWithDelay(msg, txCreateRetryDelay)
+		return
+	}
+
+	// the tx is invalid, or was persisted, so it must not be created again upon redelivery
+	if txCreateMsg.ScheduledTransactionID != nil {
+		publishScheduledTxFailure(*txCreateMsg.ScheduledTransactionID, txCreateMsg.Run, tx.Errors)
+	}
+	deadletter.Term(msg, errmsg)
+}
+
+// publishScheduledTxFailure publishes the errors which prevented the tx published by the given
+// run of a scheduled tx from being created, so the failure is recorded on the scheduled tx
+func publishScheduledTxFailure(scheduledTransactionID uuid.UUID, run uint64, errors []*api.Error) {
+	desc := "failed to create scheduled tx"
+	if len(errors) > 0 && errors[0].Message != nil {
+		desc = *errors[0].Message
+	}
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"scheduled_transaction_id": scheduledTransactionID.String(),
+		"run":                      run,
+		"error":                    desc,
+	})
+	_, err := natsutil.NatsJetstreamPublish(natsScheduledTxFailedSubject, payload)
+	if err != nil {
+		common.Log.Warningf("failed to publish failure of scheduled tx %s; %s", scheduledTransactionID, err.Error())
+	}
+}
+
+// publishTxFailure publishes the id of the given failed tx on the tx failed subject
+func publishTxFailure(t *Transaction) {
+	payload, _ := json.Marshal(map[string]interface{}{
+		"transaction_id": t.ID.String(),
+	})
+	_, err := natsutil.NatsJetstreamPublish(natsTxFailedSubject, payload)
+	if err != nil {
+		common.Log.Warningf("failed to publish failure of tx %s; %s", t.ID, err.Error())
+	}
+}
+
+// consumeHeldTxCreateMsg signs and broadcasts the tx with the given id, which was persisted before it
+// was signed because it was held for approval and approved, or because it was queued in a batch; when
+// a nonce was reserved for a queued tx, the reservation is released if the tx is never broadcast
+func consumeHeldTxCreateMsg(msg *nats.Msg, txID string, signerAddress *string) {
+	db := dbconf.DatabaseConnection()
+
+	tx := &Transaction{}
+	db.Where("id = ?", txID).Find(&tx)
+	if tx == nil || tx.ID == uuid.Nil {
+		common.Log.Warningf("failed to resolve held tx %s during NATS %v message handling", txID, msg.Subject)
+		deadletter.Term(msg, fmt.Sprintf("failed to resolve held tx %s", txID))
+		return
+	}
+
+	if deferThrottledMsg(msg, tx.NetworkID, natsTxCreateMaxDeliveries) {
+		return
+	}
+
+	status := ""
+	if tx.Status != nil {
+		status = *tx.Status
+	}
+
+	// claim the held tx so it is signed at most once, even if the message is redelivered
+	if (status != txStatusApproved && status != txStatusQueued) || !tx.transition(db, status, "pending", nil) {
+		common.Log.Debugf("held tx %s has already been signed; status: %s", tx.ID, status)
+		msg.Ack()
+		return
+	}
+
+	tx.held = true
+	tx.approved = status == txStatusApproved
+	tx.Params = tx.PendingParams
+
+	params := tx.ParseParams()
+	if nonce, nonceOk := params["nonce"].(float64); nonceOk && signerAddress != nil {
+		tx.nonceReservation = &nonceReservation{
+			networkID: tx.NetworkID,
+			address:   *signerAddress,
+			nonce:     uint64(nonce),
+		}
+	}
+
+	if tx.Create(db) {
+		common.Log.Debugf("held transaction execution successful: %s", tx.ID)
+		if tx.Status != nil && *tx.Status == "failed" && signerAddress != nil {
+			// the tx of the batch was not broadcast
+			abandonBatchNonces(db, tx, *signerAddress)
+		}
+		msg.Ack()
+		return
+	}
+
+	errmsg := fmt.Sprintf("failed to execute held transaction %s; tx failed with %d error(s)", tx.ID, len(tx.Errors))
+	for _, err := range tx.Errors {
+		errmsg = fmt.Sprintf("%s\n\t%s", errmsg, *err.Message)
+	}
+	common.Log.Warning(errmsg)
+
+	if tx.resolveHeldCreateFailure(db, status) {
+		common.NakWithDelay(msg, txCreateRetryDelay)
+		return
+	}
+
+	if signerAddress != nil {
+		abandonBatchNonces(db, tx, *signerAddress)
+	}
+	deadletter.Term(msg, errmsg)
+}
+
+// resolveHeldCreateFailure resolves the held tx which was claimed from the given status but not
+// created; the claim is released so the tx is retried if the failure may not recur (i.e., a db
+// error), otherwise the tx is failed, since it would fail upon every redelivery (i.e., it was
+// rejected by a policy). Returns true if the tx should be retried.
+func (t *Transaction) resolveHeldCreateFailure(db *gorm.DB, status string) bool {
+	if t.retryable {
+		// a nonce which was released is no longer reserved for the tx, so it is removed from the persisted params
+		db.Model(&Transaction{}).Where("id = ? AND status = ?", t.ID, "pending").Updates(map[string]interface{}{
+			"status":         status,
+			"pending_params": t.Params,
+		})
+		return true
+	}
+
+	t.releaseNonce()
+
+	desc := "failed to create held tx"
+	if len(t.Errors) > 0 && t.Errors[0].Message != nil {
+		desc = *t.Errors[0].Message
+	}
+	t.transition(db, "pending", "failed", &desc)
+	return false
+}
+
 // subsidize the given beneficiary with a drip equal to the given val
 func subsidize(db *gorm.DB, networkID uuid.UUID, beneficiary string, val, gas int64) error {
 	payment, err := bookie.CreatePayment(util.DefaultVaultAccessJWT, map[string]interface{}{
@@ -319,7 +559,7 @@ func consumeTxExecutionMsg(msg *nats.Msg) {
 
 	if execution.ContractID == nil {
 		common.Log.Errorf("invalid tx message; missing contract_id")
-		msg.Term()
+		deadletter.Term(msg, "invalid tx message; missing contract_id")
 		return
 	}
 
@@ -335,7 +575,7 @@ func consumeTxExecutionMsg(msg *nats.Msg) {
 		}
 		if execution.Account != nil && execution.AccountID != nil && *executionAccountID != *execution.AccountID {
 			common.Log.Errorf("invalid tx message specifying a account_id and account")
-			msg.Term()
+			deadletter.Term(msg, "invalid tx message specifying a account_id and account")
 			return
 		}
 		account := &wallet.Account{}
@@ -355,7 +595,7 @@ func consumeTxExecutionMsg(msg *nats.Msg) {
 		}
 		if execution.Wallet != nil && execution.WalletID != nil && *executionWalletID != *execution.WalletID {
 			common.Log.Errorf("invalid tx message specifying a wallet_id and wallet")
-			msg.Term()
+			deadletter.Term(msg, "invalid tx message specifying a wallet_id and wallet")
 			return
 		}
 		wallet := &wallet.Wallet{}
@@ -372,7 +612,11 @@ func consumeTxExecutionMsg(msg *nats.Msg) {
 	}
 	if cntract == nil || cntract.ID == uuid.Nil {
 		common.Log.Errorf("unable to execute contract; contract not found: %s", cntract.ID)
-		msg.Term()
+		deadletter.Term(msg, fmt.Sprintf("unable to execute contract; contract not found: %s", cntract.ID))
+		return
+	}
+
+	if deferThrottledMsg(msg, cntract.NetworkID, natsTxMsgMaxDeliveries) {
 		return
 	}
 
@@ -428,7 +672,7 @@ func consumeTxFinalizeMsg(msg *nats.Msg) {
 	nack := func(msg *nats.Msg, errmsg string, dropPacket bool) {
 		if dropPacket {
 			common.Log.Tracef("dropping tx packet on the floor; %s", errmsg)
-			msg.Term()
+			deadletter.Term(msg, errmsg)
 			return
 		}
 		msg.Nak()
@@ -441,6 +685,7 @@ func consumeTxFinalizeMsg(msg *nats.Msg) {
 	}
 
 	block, blockOk := params["block"].(float64)
+	blockHash, _ := params["block_hash"].(string)
 	blockTimestampStr, blockTimestampStrOk := params["block_timestamp"].(string)
 	finalizedAtStr, finalizedAtStrOk := params["finalized_at"].(string)
 	hash, hashOk := params["hash"].(string)
@@ -477,21 +722,23 @@ func consumeTxFinalizeMsg(msg *nats.Msg) {
 		return
 	}
 
-	tx := &Transaction{}
 	db := dbconf.DatabaseConnection()
 
 	common.Log.Tracef("checking local db for tx status; tx hash: %s", hash)
 
-	db.Where("hash = ? AND status IN (?, ?)", hash, "pending", "failed").Find(&tx)
+	tx := finalizableTx(db, hash)
 	if tx == nil || tx.ID == uuid.Nil {
 		// TODO: this is integration point to upsert Wallet & Transaction... need to think thru performance implications & implementation details
-		nack(msg, fmt.Sprintf("failed to mark block and finalized_at timestamp on tx: %s; tx not found for given hash", hash), true)
+		// finalize messages are published for every tx in a finalized block, so those not broadcast by nchain are not dead-lettered
+		common.Log.Tracef("dropping tx packet on the floor; tx not found for given hash: %s", hash)
+		msg.Ack()
 		return
 	}
 
 	blockNumber := uint64(block)
 
 	tx.Block = &blockNumber
+	tx.BlockHash = common.StringOrNil(blockHash)
 	tx.BlockTimestamp = &blockTimestamp
 	tx.FinalizedAt = &finalizedAt
 	if tx.BroadcastAt != nil {
@@ -508,6 +755,10 @@ func consumeTxFinalizeMsg(msg *nats.Msg) {
 	}
 
 	tx.updateStatus(db, "success", nil)
+	err = tx.resolveReplacedTx(db)
+	if err != nil {
+		common.Log.Warningf("failed to resolve tx replaced by finalized tx: %s; %s", tx.ID, err.Error())
+	}
 	result := db.Save(&tx)
 	errors := result.GetErrors()
 	if len(errors) > 0 {
@@ -525,11 +776,19 @@ func consumeTxFinalizeMsg(msg *nats.Msg) {
 	msg.Ack()
 }
 
+// finalizableTx returns the pending tx with the given hash; a failed tx (i.e., one which reverted
+// in the finalized block) is never returned so it cannot be overwritten as successful
+func finalizableTx(db *gorm.DB, hash string) *Transaction {
+	tx := &Transaction{}
+	db.Where("hash = ? AND status = ?", hash, "pending").Find(&tx)
+	return tx
+}
+
 func consumeTxReceiptMsg(msg *nats.Msg) {
 	defer func() {
 		if r := recover(); r != nil {
 			common.Log.Warningf("recovered from failed tx receipt message; %s", r)
-			msg.Term()
+			deadletter.Term(msg, fmt.Sprintf("recovered from panic; %s", r))
 		}
 	}()
 
@@ -547,7 +806,7 @@ func consumeTxReceiptMsg(msg *nats.Msg) {
 	transactionID, transactionIDOk := params["transaction_id"].(string)
 	if !transactionIDOk {
 		common.Log.Warningf("failed to consume NATS tx receipt message; no transaction id provided")
-		msg.Term()
+		deadletter.Term(msg, "failed to consume NATS tx receipt message; no transaction id provided")
 		return
 	}
 
@@ -557,7 +816,11 @@ func consumeTxReceiptMsg(msg *nats.Msg) {
 	db.Where("id = ?", transactionID).Find(&tx)
 	if tx == nil || tx.ID == uuid.Nil {
 		common.Log.Tracef("failed to fetch tx receipt; no tx resolved for id: %s", transactionID)
-		msg.Term()
+		deadletter.Term(msg, fmt.Sprintf("failed to fetch tx receipt; no tx resolved for id: %s", transactionID))
+		return
+	}
+
+	if deferThrottledMsg(msg, tx.NetworkID, natsTxReceiptMsgMaxDeliveries) {
 		return
 	}
 
@@ -573,23 +836,171 @@ func consumeTxReceiptMsg(msg *nats.Msg) {
 	err = tx.fetchReceipt(db, signer.Network, signer.Address())
 	if err != nil {
 		common.Log.Debugf(fmt.Sprintf("failed to fetch tx receipt; %s", err.Error()))
+
+		if tx.resolveMinedReplacedTx(db, signer) {
+			// the replaced tx was mined using the nonce of this replacement
+			msg.Ack()
+			return
+		}
+
+		if tx.hasBroadcastReplacement(db) {
+			// the receipt of the replacement is polled until either tx is finalized
+			common.Log.Debugf("stopped polling for receipt of tx %s which was replaced by a broadcast replacement", tx.ID)
+			msg.Ack()
+			return
+		}
+
 		// msg.Nak()
 		return
+	}
+
+	common.Log.Debugf("fetched tx receipt for hash: %s", *tx.Hash)
+	if tx.finalizeReceipt(db, signer.Network) {
+		msg.Ack()
 	} else {
-		common.Log.Debugf("fetched tx receipt for hash: %s", *tx.Hash)
-
-		blockNumber := tx.Response.Receipt.(*provide.TxReceipt).BlockNumber
-		// if we have a block number in the receipt, and the tx has no block
-		// populate the block and finalized timestamp
-		if blockNumber != nil && tx.Block == nil {
-			receiptBlock := blockNumber.Uint64()
-			tx.Block = &receiptBlock
-			receiptFinalized := time.Now()
-			tx.FinalizedAt = &receiptFinalized
-			common.Log.Debugf("tx %s finalized in block %v at %s", *tx.Hash, blockNumber, receiptFinalized.Format("Mon, 02 Jan 2006 15:04:05 MST"))
+		common.NakWithDelay(msg, txReceiptConfirmationsDelay)
+	}
+}
+
+// finalizeReceipt finalizes the tx using its fetched receipt; false is returned if finalization
+// is deferred until the configured number of confirmations have been mined
+func (t *Transaction) finalizeReceipt(db *gorm.DB, ntwrk *network.Network) bool {
+	receipt := t.Response.Receipt.(*provide.TxReceipt)
+	blockNumber := receipt.BlockNumber
+
+	// defer finalization until the configured number of blocks have been mined on top of the receipt block
+	if blockNumber != nil && ntwrk.IsEthereumNetwork() {
+		if confirmations := ntwrk.Confirmations(); confirmations > 0 {
+			rpcClientKey, rpcURL, err := ntwrk.RPCEndpoint()
+			var latestBlock uint64
+			if err == nil {
+				latestBlock, err = providecrypto.EVMGetLatestBlockNumber(rpcClientKey, rpcURL)
+			}
+			if err != nil || blockNumber.Uint64()+confirmations > latestBlock {
+				common.Log.Debugf("tx %s included in block %v has not reached %d confirmation(s)", *t.Hash, blockNumber, confirmations)
+				return false
+			}
 		}
+	}
+	// if we have a block number in the receipt, and the tx has no block
+	// populate the block and finalized timestamp
+	if blockNumber != nil && t.Block == nil {
+		receiptBlock := blockNumber.Uint64()
+		t.Block = &receiptBlock
+		if len(receipt.BlockHash) > 0 {
+			t.BlockHash = common.StringOrNil(hexutil.Encode(receipt.BlockHash))
+		}
+		receiptFinalized := time.Now()
+		t.FinalizedAt = &receiptFinalized
+		common.Log.Debugf("tx %s finalized in block %v at %s", *t.Hash, blockNumber, receiptFinalized.Format("Mon, 02 Jan 2006 15:04:05 MST"))
+	}
 
-		tx.updateStatus(db, "success", nil)
-		msg.Ack()
+	if (ntwrk.IsEthereumNetwork() || ntwrk.IsBaseledgerNetwork() || ntwrk.IsHyperledgerFabricNetwork()) && receipt.Status == 0 {
+		t.updateStatus(db, "failed", t.Description)
+	} else {
+		t.updateStatus(db, "success", nil)
+	}
+
+	err := t.resolveReplacedTx(db)
+	if err != nil {
+		common.Log.Warningf("failed to resolve tx replaced by finalized tx: %s; %s", t.ID, err.Error())
+	}
+
+	return true
+}
+
+func consumeBlockReorgMsg(msg *nats.Msg) {
+	defer func() {
+		if r := recover(); r != nil {
+			common.Log.Warningf("recovered from panic during NATS block reorg message handling; %s", r)
+			deadletter.Term(msg, fmt.Sprintf("recovered from panic; %s", r))
+		}
+	}()
+
+	common.Log.Debugf("consuming %d-byte NATS block reorg message on subject: %s", len(msg.Data), msg.Subject)
+
+	var params map[string]interface{}
+
+	err := json.Unmarshal(msg.Data, &params)
+	if err != nil {
+		common.Log.Warningf("failed to umarshal block reorg message; %s", err.Error())
+		deadletter.Term(msg, fmt.Sprintf("failed to umarshal block reorg message; %s", err.Error()))
+		return
+	}
+
+	networkID, networkIDOk := params["network_id"].(string)
+	orphaned, orphanedOk := params["orphaned"].([]interface{})
+
+	if !networkIDOk {
+		common.Log.Warningf("failed to consume NATS block reorg message; no network id provided")
+		deadletter.Term(msg, "failed to consume NATS block reorg message; no network id provided")
+		return
+	}
+
+	if !orphanedOk || len(orphaned) == 0 {
+		common.Log.Warningf("failed to consume NATS block reorg message; no orphaned blocks provided")
+		deadletter.Term(msg, "failed to consume NATS block reorg message; no orphaned blocks provided")
+		return
+	}
+
+	blocks := make([]uint64, 0)
+	hashes := make([]string, 0)
+	for _, _block := range orphaned {
+		orphanedBlock, _ := _block.(map[string]interface{})
+		if block, blockOk := orphanedBlock["block"].(float64); blockOk {
+			blocks = append(blocks, uint64(block))
+		}
+		if hash, hashOk := orphanedBlock["hash"].(string); hashOk {
+			hashes = append(hashes, hash)
+		}
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	var txs []*Transaction
+	orphanedTxsQuery(db, networkID, blocks, hashes).Find(&txs)
+
+	for _, tx := range txs {
+		block := *tx.Block
+		desc := fmt.Sprintf("block %d was orphaned by a chain reorg", block)
+
+		tx.Block = nil
+		tx.BlockHash = nil
+		tx.BlockTimestamp = nil
+		tx.FinalizedAt = nil
+		tx.QueueLatency = nil
+		tx.NetworkLatency = nil
+		tx.E2ELatency = nil
+		tx.updateStatus(db, "pending", &desc)
+		if len(tx.Errors) > 0 {
+			common.Log.Warningf("failed to roll back tx %s included in orphaned block %d; %s", tx.ID, block, *tx.Errors[0].Message)
+			msg.Nak()
+			return
+		}
+
+		common.Log.Debugf("rolled back tx %s included in orphaned block %d", tx.ID, block)
+
+		payload, _ := json.Marshal(map[string]interface{}{
+			"transaction_id": tx.ID.String(),
+		})
+		_, err = natsutil.NatsJetstreamPublish(natsTxReceiptSubject, payload)
+		if err != nil {
+			common.Log.Warningf("failed to publish tx receipt message for tx %s rolled back by reorg; %s", tx.ID, err.Error())
+		}
+
+		webhook.Dispatch(webhook.EventTxReorg, tx.ApplicationID, tx.OrganizationID, tx.NetworkID, tx)
+	}
+
+	msg.Ack()
+}
+
+// orphanedTxsQuery returns the query for the finalized txs included in the given orphaned blocks;
+// txs are matched by block hash, as the canonical chain has different blocks at the same heights,
+// and by block height only if the hash of the block which included the tx was not recorded
+func orphanedTxsQuery(db *gorm.DB, networkID string, blocks []uint64, hashes []string) *gorm.DB {
+	query := db.Where("network_id = ? AND status IN (?, ?)", networkID, "success", "failed")
+	if len(hashes) == 0 {
+		return query.Where("block IN (?)", blocks)
 	}
+	return query.Where("block_hash IN (?) OR (block_hash IS NULL AND block IN (?))", hashes, blocks)
 }
@@ -0,0 +1,468 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tx
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/network"
+)
+
+const txTypeLegacy = uint8(0x00)
+const txTypeDynamicFee = uint8(0x02)
+
+// defaultMaxPriorityFeePerGas is used when the network does not support eth_maxPriorityFeePerGas
+var defaultMaxPriorityFeePerGas = big.NewInt(1500000000) // 1.5 gwei
+
+// dynamicFeeTx is an EIP-1559 (type 0x02) transaction; the vendored go-ethereum
+// predates the London fork, so the typed envelope is encoded here
+type dynamicFeeTx struct {
+	ChainID              *big.Int
+	Nonce                uint64
+	MaxPriorityFeePerGas *big.Int
+	MaxFeePerGas         *big.Int
+	Gas                  uint64
+	To                   *ethcommon.Address
+	Value                *big.Int
+	Data                 []byte
+
+	// signature values
+	V *big.Int
+	R *big.Int
+	S *big.Int
+}
+
+// dynamicFeeParamKeys are the wei-denominated EIP-1559 fee params; fees may exceed 2^53 wei,
+// so these are carried as decimal strings rather than as (float64) JSON numbers
+var dynamicFeeParamKeys = []string{"max_fee_per_gas", "max_priority_fee_per_gas"}
+
+// maxSafeJSONInteger is the largest integer which survives a round trip through a float64
+const maxSafeJSONInteger = float64(1 << 53)
+
+// dynamicFeeParamsCacheTTL is how long the resolved base fee is reused when the current
+// block of the network cannot be resolved from the cached network stats
+const dynamicFeeParamsCacheTTL = time.Second * 2
+
+// cachedDynamicFeeParams are the dynamic fee params resolved from the block with the given number
+type cachedDynamicFeeParams struct {
+	block     uint64
+	supported bool
+	baseFee   *big.Int
+	fetchedAt time.Time
+}
+
+// dynamicFeeParamsCache caches the resolved dynamic fee params in-process, keyed by network id
+var dynamicFeeParamsCache sync.Map
+
+// dynamicFeeParams resolves the EIP-1559 fee params for the given network; supported
+// is false if the network does not support dynamic fee transactions (i.e., pre-London);
+// the params are resolved once per block and are otherwise read from the in-process cache
+func dynamicFeeParams(ntwrk *network.Network) (supported bool, baseFee *big.Int, err error) {
+	var currentBlock uint64
+	if stats, err := ntwrk.Stats(); err == nil && stats != nil {
+		currentBlock = stats.Block
+	}
+
+	if cached, ok := dynamicFeeParamsCache.Load(ntwrk.ID.String()); ok {
+		params := cached.(*cachedDynamicFeeParams)
+		if params.fresh(currentBlock) {
+			return params.supported, params.baseFee, nil
+		}
+	}
+
+	var block map[string]interface{}
+	err = ntwrk.EVMRPCClientCall(&block, "eth_getBlockByNumber", "latest", false)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to resolve latest block; %s", err.Error())
+	}
+
+	params, err := parseDynamicFeeBlock(block)
+	if err != nil {
+		return false, nil, err
+	}
+
+	dynamicFeeParamsCache.Store(ntwrk.ID.String(), params)
+	return params.supported, params.baseFee, nil
+}
+
+// fresh returns true if the cached params may be used when the network is at the given block;
+// a zero current block indicates the current block is unknown, in which case the ttl applies
+func (p *cachedDynamicFeeParams) fresh(currentBlock uint64) bool {
+	if currentBlock != 0 {
+		return currentBlock <= p.block
+	}
+	return time.Since(p.fetchedAt) < dynamicFeeParamsCacheTTL
+}
+
+// parseDynamicFeeBlock resolves the dynamic fee params from the given JSON-RPC block
+func parseDynamicFeeBlock(block map[string]interface{}) (*cachedDynamicFeeParams, error) {
+	params := &cachedDynamicFeeParams{
+		fetchedAt: time.Now(),
+	}
+
+	if number, numberOk := block["number"].(string); numberOk {
+		blockNumber, err := hexutil.DecodeUint64(number)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode block number; %s", err.Error())
+		}
+		params.block = blockNumber
+	}
+
+	baseFeePerGas, baseFeeOk := block["baseFeePerGas"].(string)
+	if !baseFeeOk {
+		return params, nil
+	}
+
+	baseFee, err := hexutil.DecodeBig(baseFeePerGas)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base fee per gas; %s", err.Error())
+	}
+
+	params.supported = true
+	params.baseFee = baseFee
+	return params, nil
+}
+
+// normalizeDynamicFeeParams rewrites the dynamic fee params of the given raw JSON params as
+// decimal strings; params are decoded using json.Number so fees provided as JSON numbers
+// are not truncated to float64 precision
+func normalizeDynamicFeeParams(raw []byte) ([]byte, error) {
+	params := map[string]interface{}{}
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	err := decoder.Decode(&params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse params; %s", err.Error())
+	}
+
+	normalized := false
+	for _, key := range dynamicFeeParamKeys {
+		val, ok := params[key]
+		if !ok || val == nil {
+			continue
+		}
+
+		fee, err := parseWei(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s; %s", key, err.Error())
+		}
+
+		params[key] = fee.String()
+		normalized = true
+	}
+
+	if !normalized {
+		return raw, nil
+	}
+
+	return json.Marshal(params)
+}
+
+// parseWei parses the given wei-denominated value without loss of precision; the value may be
+// a JSON number or a decimal or 0x-prefixed hex string. float64 values are only accepted when
+// they can be represented exactly.
+func parseWei(val interface{}) (*big.Int, error) {
+	var str string
+
+	switch v := val.(type) {
+	case json.Number:
+		str = v.String()
+	case string:
+		str = v
+	case float64:
+		if v != math.Trunc(v) || math.Abs(v) > maxSafeJSONInteger {
+			return nil, fmt.Errorf("%v cannot be represented exactly; provide the value in wei as a decimal string", v)
+		}
+		str = strconv.FormatInt(int64(v), 10)
+	case *big.Int:
+		if v == nil {
+			return nil, errors.New("nil value")
+		}
+		str = v.String()
+	default:
+		return nil, fmt.Errorf("unsupported value type: %T", val)
+	}
+
+	var wei *big.Int
+	if strings.HasPrefix(str, "0x") || strings.HasPrefix(str, "0X") {
+		i, err := hexutil.DecodeBig(str)
+		if err != nil {
+			return nil, fmt.Errorf("%s is not a valid hex-encoded integer; %s", str, err.Error())
+		}
+		wei = i
+	} else {
+		i, ok := new(big.Int).SetString(str, 10)
+		if !ok {
+			return nil, fmt.Errorf("%s is not an integer value in wei", str)
+		}
+		wei = i
+	}
+
+	if wei.Sign() < 0 {
+		return nil, fmt.Errorf("%s is negative", str)
+	}
+
+	return wei, nil
+}
+
+// suggestMaxPriorityFeePerGas returns the max priority fee per gas suggested by the network
+func suggestMaxPriorityFeePerGas(ntwrk *network.Network) *big.Int {
+	var tip hexutil.Big
+	err := ntwrk.EVMRPCClientCall(&tip, "eth_maxPriorityFeePerGas")
+	if err != nil {
+		common.Log.Debugf("failed to resolve suggested max priority fee per gas on network: %s; using default; %s", ntwrk.ID, err.Error())
+		return new(big.Int).Set(defaultMaxPriorityFeePerGas)
+	}
+	return tip.ToInt()
+}
+
+// dynamicFeeTxFactory builds and returns an unsigned EIP-1559 transaction and its signing hash
+func dynamicFeeTxFactory(
+	ntwrk *network.Network,
+	from string,
+	to,
+	data *string,
+	val *big.Int,
+	nonce *uint64,
+	gasLimit uint64,
+	maxFeePerGas,
+	maxPriorityFeePerGas,
+	baseFee *big.Int,
+) (*dynamicFeeTx, []byte, error) {
+	client, err := ntwrk.EVMDialJSONRPC()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	chainID, err := client.ChainID(context.TODO())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to resolve chain id; %s", err.Error())
+	}
+
+	sender := ethcommon.HexToAddress(from)
+
+	if nonce == nil {
+		pendingNonce, err := client.PendingNonceAt(context.TODO(), sender)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to retrieve next nonce; %s", err.Error())
+		}
+		nonce = &pendingNonce
+	}
+
+	if maxPriorityFeePerGas == nil {
+		maxPriorityFeePerGas = suggestMaxPriorityFeePerGas(ntwrk)
+	}
+
+	if maxFeePerGas == nil {
+		// allow the base fee to double before the tx becomes unmineable
+		maxFeePerGas = new(big.Int).Add(new(big.Int).Mul(baseFee, big.NewInt(2)), maxPriorityFeePerGas)
+	}
+
+	if maxFeePerGas.Cmp(maxPriorityFeePerGas) < 0 {
+		return nil, nil, fmt.Errorf("max fee per gas (%s) is less than max priority fee per gas (%s)", maxFeePerGas, maxPriorityFeePerGas)
+	}
+
+	if val == nil {
+		val = big.NewInt(0)
+	}
+
+	var _data []byte
+	if data != nil {
+		_data = ethcommon.FromHex(*data)
+	}
+
+	var _to *ethcommon.Address
+	if to != nil {
+		addr := ethcommon.HexToAddress(*to)
+		_to = &addr
+	}
+
+	if gasLimit == 0 {
+		gasLimit, err = client.EstimateGas(context.TODO(), ethereum.CallMsg{
+			From:  sender,
+			To:    _to,
+			Value: val,
+			Data:  _data,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to estimate gas for tx; %s", err.Error())
+		}
+		common.Log.Debugf("estimated gas for %d-byte tx: %d", len(_data), gasLimit)
+	}
+
+	balance, err := client.BalanceAt(context.TODO(), sender, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cost := new(big.Int).Mul(new(big.Int).SetUint64(gasLimit), maxFeePerGas)
+	if balance.Cmp(cost) == -1 {
+		return nil, nil, errors.New("insufficient balance in account")
+	}
+
+	tx := &dynamicFeeTx{
+		ChainID:              chainID,
+		Nonce:                *nonce,
+		MaxPriorityFeePerGas: maxPriorityFeePerGas,
+		MaxFeePerGas:         maxFeePerGas,
+		Gas:                  gasLimit,
+		To:                   _to,
+		Value:                val,
+		Data:                 _data,
+	}
+
+	hash, err := tx.signingHash()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tx, hash, nil
+}
+
+// fields returns the RLP-encodable fields of the tx, excluding the signature values
+func (tx *dynamicFeeTx) fields() []interface{} {
+	to := []byte{}
+	if tx.To != nil {
+		to = tx.To.Bytes()
+	}
+
+	return []interface{}{
+		tx.ChainID,
+		tx.Nonce,
+		tx.MaxPriorityFeePerGas,
+		tx.MaxFeePerGas,
+		tx.Gas,
+		to,
+		tx.Value,
+		tx.Data,
+		[]interface{}{}, // access list
+	}
+}
+
+// envelope returns the typed tx envelope for the given fields, i.e., 0x02 || rlp(fields)
+func (tx *dynamicFeeTx) envelope(fields []interface{}) ([]byte, error) {
+	payload, err := rlp.EncodeToBytes(fields)
+	if err != nil {
+		return nil, fmt.Errorf("failed to RLP-encode dynamic fee tx; %s", err.Error())
+	}
+	return append([]byte{txTypeDynamicFee}, payload...), nil
+}
+
+// signingHash returns the hash to be signed by the sender
+func (tx *dynamicFeeTx) signingHash() ([]byte, error) {
+	envelope, err := tx.envelope(tx.fields())
+	if err != nil {
+		return nil, err
+	}
+	return crypto.Keccak256(envelope), nil
+}
+
+// withSignature returns a copy of the tx with the given 65-byte [R || S || V] signature
+func (tx *dynamicFeeTx) withSignature(sig []byte) (*dynamicFeeTx, error) {
+	if len(sig) != crypto.SignatureLength {
+		return nil, fmt.Errorf("invalid %d-byte signature; expected %d bytes", len(sig), crypto.SignatureLength)
+	}
+
+	v := sig[crypto.RecoveryIDOffset]
+	if v >= 27 {
+		v -= 27
+	}
+	if v > 1 {
+		return nil, fmt.Errorf("invalid signature recovery id: %d", v)
+	}
+
+	signed := *tx
+	signed.R = new(big.Int).SetBytes(sig[0:32])
+	signed.S = new(big.Int).SetBytes(sig[32:64])
+	signed.V = new(big.Int).SetUint64(uint64(v))
+	return &signed, nil
+}
+
+// MarshalBinary returns the raw signed tx, suitable for eth_sendRawTransaction
+func (tx *dynamicFeeTx) MarshalBinary() ([]byte, error) {
+	if tx.V == nil || tx.R == nil || tx.S == nil {
+		return nil, errors.New("failed to encode unsigned dynamic fee tx")
+	}
+	return tx.envelope(append(tx.fields(), tx.V, tx.R, tx.S))
+}
+
+// Hash returns the hash of the signed tx
+func (tx *dynamicFeeTx) Hash() ethcommon.Hash {
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		return ethcommon.Hash{}
+	}
+	return ethcommon.BytesToHash(crypto.Keccak256(raw))
+}
+
+// broadcastDynamicFeeTx emits the given signed EIP-1559 tx for inclusion in a block
+func broadcastDynamicFeeTx(ntwrk *network.Network, signedTx *dynamicFeeTx) error {
+	raw, err := signedTx.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	var hash ethcommon.Hash
+	err = ntwrk.EVMRPCClientCall(&hash, "eth_sendRawTransaction", hexutil.Encode(raw))
+	if err != nil {
+		return fmt.Errorf("failed to transmit signed tx to JSON-RPC host; %s", err.Error())
+	}
+
+	return nil
+}
+
+// fetchEffectiveGasPrice returns the effective gas price paid by the tx with the given hash,
+// as reported by its receipt; pre-London receipts fall back to the gas price of the tx
+func fetchEffectiveGasPrice(ntwrk *network.Network, hash string) (*big.Int, error) {
+	var receipt map[string]interface{}
+	err := ntwrk.EVMRPCClientCall(&receipt, "eth_getTransactionReceipt", hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tx receipt for tx hash: %s; %s", hash, err.Error())
+	}
+
+	if effectiveGasPrice, ok := receipt["effectiveGasPrice"].(string); ok {
+		return hexutil.DecodeBig(effectiveGasPrice)
+	}
+
+	var tx map[string]interface{}
+	err = ntwrk.EVMRPCClientCall(&tx, "eth_getTransactionByHash", hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch tx for tx hash: %s; %s", hash, err.Error())
+	}
+
+	if gasPrice, ok := tx["gasPrice"].(string); ok {
+		return hexutil.DecodeBig(gasPrice)
+	}
+
+	return nil, fmt.Errorf("failed to resolve effective gas price for tx hash: %s", hash)
+}
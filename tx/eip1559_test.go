@@ -0,0 +1,247 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tx
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// dynamicFeeTxVectorKey is the private key used to sign the known dynamic fee tx vectors
+const dynamicFeeTxVectorKey = "4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318"
+
+func mustBigInt(t *testing.T, val string) *big.Int {
+	i, ok := new(big.Int).SetString(val, 10)
+	if !ok {
+		t.Fatalf("invalid big int: %s", val)
+	}
+	return i
+}
+
+// dynamicFeeTxVectors were produced by the go-ethereum london signer (types.NewLondonSigner)
+func dynamicFeeTxVectors(t *testing.T) []struct {
+	tx          *dynamicFeeTx
+	signingHash string
+	raw         string
+	hash        string
+} {
+	to := ethcommon.HexToAddress("0x3535353535353535353535353535353535353535")
+	return []struct {
+		tx          *dynamicFeeTx
+		signingHash string
+		raw         string
+		hash        string
+	}{
+		{
+			tx: &dynamicFeeTx{
+				ChainID:              big.NewInt(1),
+				Nonce:                9,
+				MaxPriorityFeePerGas: big.NewInt(1500000000),
+				MaxFeePerGas:         mustBigInt(t, "9007199254740993"), // 2^53 + 1
+				Gas:                  21000,
+				To:                   &to,
+				Value:                mustBigInt(t, "1000000000000000000"),
+			},
+			signingHash: "0xc5510a41ef7a3cd8c6dbffe120766d413f246bf617781db3a2825209694ccd17",
+			raw:         "0x02f87501098459682f008720000000000001825208943535353535353535353535353535353535353535880de0b6b3a764000080c080a0addcbff422870223a37c85bf4227bf988afb0c779a582f7c8bd4814b87748189a01f36c0a28c838f79e7dbf9206ebb3bc7858efc0680695286b79020408d429a29",
+			hash:        "0x2f12d0fd7e209b0ec6fed61bde2ac1afdd025a5adafe451e0af1be196fd5c082",
+		},
+		{
+			tx: &dynamicFeeTx{
+				ChainID:              big.NewInt(5),
+				Nonce:                0,
+				MaxPriorityFeePerGas: big.NewInt(2),
+				MaxFeePerGas:         big.NewInt(30000000000),
+				Gas:                  100000,
+				Value:                big.NewInt(0),
+				Data:                 ethcommon.FromHex("0x6080604052"),
+			},
+			signingHash: "0x2991142154888782692b1cd3c82dedb603d8d6fe3786c3575618fca820fd2629",
+			raw:         "0x02f8590580028506fc23ac00830186a08080856080604052c080a0390a3ff5389d03b47c2a1768ce05499c21f0351dbfe42d08b54939304154b279a066c9ac582c377f70ab4fecc54484dd0b5edab4edd9c5f18a9302dd38e565d21e",
+			hash:        "0xfe645bedcfb261bda6f273844af6b6eedfef9c2e7ccf84c33eac40eb0ba9e938",
+		},
+	}
+}
+
+func TestDynamicFeeTxKnownVectors(t *testing.T) {
+	key, err := crypto.HexToECDSA(dynamicFeeTxVectorKey)
+	if err != nil {
+		t.Fatalf("failed to parse key; %s", err.Error())
+	}
+
+	for i, vector := range dynamicFeeTxVectors(t) {
+		signingHash, err := vector.tx.signingHash()
+		if err != nil {
+			t.Fatalf("vector %d: failed to compute signing hash; %s", i, err.Error())
+		}
+		if hexutil.Encode(signingHash) != vector.signingHash {
+			t.Errorf("vector %d: signing hash %s; expected %s", i, hexutil.Encode(signingHash), vector.signingHash)
+		}
+
+		sig, err := crypto.Sign(signingHash, key)
+		if err != nil {
+			t.Fatalf("vector %d: failed to sign; %s", i, err.Error())
+		}
+
+		signed, err := vector.tx.withSignature(sig)
+		if err != nil {
+			t.Fatalf("vector %d: failed to apply signature; %s", i, err.Error())
+		}
+
+		raw, err := signed.MarshalBinary()
+		if err != nil {
+			t.Fatalf("vector %d: failed to encode signed tx; %s", i, err.Error())
+		}
+		if hexutil.Encode(raw) != vector.raw {
+			t.Errorf("vector %d: raw tx %s; expected %s", i, hexutil.Encode(raw), vector.raw)
+		}
+		if signed.Hash().Hex() != vector.hash {
+			t.Errorf("vector %d: tx hash %s; expected %s", i, signed.Hash().Hex(), vector.hash)
+		}
+	}
+}
+
+func TestDynamicFeeTxWithLegacyRecoveryID(t *testing.T) {
+	vector := dynamicFeeTxVectors(t)[0]
+	sig := hexutil.MustDecode("0xaddcbff422870223a37c85bf4227bf988afb0c779a582f7c8bd4814b877481891f36c0a28c838f79e7dbf9206ebb3bc7858efc0680695286b79020408d429a291b")
+
+	signed, err := vector.tx.withSignature(sig)
+	if err != nil {
+		t.Fatalf("failed to apply signature; %s", err.Error())
+	}
+
+	raw, _ := signed.MarshalBinary()
+	if hexutil.Encode(raw) != vector.raw {
+		t.Errorf("raw tx %s; expected %s", hexutil.Encode(raw), vector.raw)
+	}
+}
+
+func TestDynamicFeeTxUnsignedMarshalFails(t *testing.T) {
+	vector := dynamicFeeTxVectors(t)[0]
+	if _, err := vector.tx.MarshalBinary(); err == nil {
+		t.Error("expected unsigned tx encoding to fail")
+	}
+}
+
+func TestNormalizeDynamicFeeParamsPreservesPrecision(t *testing.T) {
+	raw := []byte(`{"max_fee_per_gas":9007199254740993,"max_priority_fee_per_gas":"0x59682f00","gas":21000,"value":1000000000000000001}`)
+
+	normalized, err := normalizeDynamicFeeParams(raw)
+	if err != nil {
+		t.Fatalf("failed to normalize params; %s", err.Error())
+	}
+
+	params := map[string]interface{}{}
+	json.Unmarshal(normalized, &params)
+
+	if params["max_fee_per_gas"] != "9007199254740993" {
+		t.Errorf("max_fee_per_gas %v; expected 9007199254740993", params["max_fee_per_gas"])
+	}
+	if params["max_priority_fee_per_gas"] != "1500000000" {
+		t.Errorf("max_priority_fee_per_gas %v; expected 1500000000", params["max_priority_fee_per_gas"])
+	}
+	if maxFee := parseBigIntParam(params, "max_fee_per_gas"); maxFee == nil || maxFee.String() != "9007199254740993" {
+		t.Errorf("parsed max fee per gas %v; expected 9007199254740993", maxFee)
+	}
+	if _, ok := params["gas"].(float64); !ok {
+		t.Errorf("expected non-fee params to remain JSON numbers")
+	}
+}
+
+func TestNormalizeDynamicFeeParamsWithoutFees(t *testing.T) {
+	raw := []byte(`{"gas":21000}`)
+	normalized, err := normalizeDynamicFeeParams(raw)
+	if err != nil {
+		t.Fatalf("failed to normalize params; %s", err.Error())
+	}
+	if string(normalized) != string(raw) {
+		t.Errorf("params %s; expected %s", normalized, raw)
+	}
+}
+
+func TestNormalizeDynamicFeeParamsRejectsInvalidFees(t *testing.T) {
+	for _, raw := range []string{
+		`{"max_fee_per_gas":1.5}`,
+		`{"max_fee_per_gas":"1e9"}`,
+		`{"max_fee_per_gas":"-1"}`,
+		`{"max_priority_fee_per_gas":true}`,
+		`{"max_priority_fee_per_gas":"0xzz"}`,
+	} {
+		if _, err := normalizeDynamicFeeParams([]byte(raw)); err == nil {
+			t.Errorf("expected params %s to be rejected", raw)
+		}
+	}
+}
+
+func TestParseWeiRejectsLossyFloat(t *testing.T) {
+	if _, err := parseWei(float64(1 << 60)); err == nil {
+		t.Error("expected float64 beyond 2^53 to be rejected")
+	}
+
+	wei, err := parseWei(float64(30000000000))
+	if err != nil || wei.String() != "30000000000" {
+		t.Errorf("parsed %v (%v); expected 30000000000", wei, err)
+	}
+}
+
+func TestParseDynamicFeeBlock(t *testing.T) {
+	params, err := parseDynamicFeeBlock(map[string]interface{}{
+		"number":        "0x10",
+		"baseFeePerGas": "0x3b9aca00",
+	})
+	if err != nil {
+		t.Fatalf("failed to parse block; %s", err.Error())
+	}
+	if !params.supported || params.baseFee.Cmp(big.NewInt(1000000000)) != 0 || params.block != 16 {
+		t.Errorf("unexpected dynamic fee params: %+v", params)
+	}
+
+	params, err = parseDynamicFeeBlock(map[string]interface{}{"number": "0x10"})
+	if err != nil {
+		t.Fatalf("failed to parse pre-london block; %s", err.Error())
+	}
+	if params.supported {
+		t.Error("expected pre-london block to not support dynamic fee txs")
+	}
+}
+
+func TestCachedDynamicFeeParamsFresh(t *testing.T) {
+	params := &cachedDynamicFeeParams{block: 16, fetchedAt: time.Now()}
+	if !params.fresh(16) {
+		t.Error("expected params to be fresh at the same block")
+	}
+	if params.fresh(17) {
+		t.Error("expected params to be stale at a later block")
+	}
+	if !params.fresh(0) {
+		t.Error("expected params to be fresh within the ttl when the current block is unknown")
+	}
+
+	params.fetchedAt = time.Now().Add(-dynamicFeeParamsCacheTTL)
+	if params.fresh(0) {
+		t.Error("expected params to be stale after the ttl when the current block is unknown")
+	}
+}
@@ -0,0 +1,447 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package tx
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/contract"
+	"github.com/provideplatform/nchain/network"
+	"github.com/provideplatform/nchain/prices"
+)
+
+const exportFormatCSV = "csv"
+const exportFormatNDJSON = "ndjson"
+
+// exportFlushInterval is the number of exported txs written between flushes of the response
+const exportFlushInterval = 100
+
+// nativeCurrencyDecimals of the native currency of EVM networks (i.e., wei per ether)
+const nativeCurrencyDecimals = 18
+
+// exportColumns are the columns of a CSV export, in order
+var exportColumns = []string{
+	"id",
+	"network_id",
+	"account_id",
+	"wallet_id",
+	"hash",
+	"status",
+	"block",
+	"block_timestamp",
+	"created_at",
+	"broadcast_at",
+	"finalized_at",
+	"to",
+	"value",
+	"method",
+	"gas_used",
+	"effective_gas_price",
+	"fee",
+	"fee_native",
+	"native_currency",
+	"fee_usd",
+	"ref",
+}
+
+// ExportedTransaction is a tx as it is exported for accounting; value, effective gas price and fee
+// are base-10 integers denominated in the smallest unit of the native currency (i.e., wei), and
+// fee_native is the fee denominated in the native currency. The fiat fee is computed using the
+// price of the native currency observed at the block timestamp of the tx, when a price is available.
+type ExportedTransaction struct {
+	ID                uuid.UUID  `json:"id"`
+	NetworkID         uuid.UUID  `json:"network_id"`
+	AccountID         *uuid.UUID `json:"account_id,omitempty"`
+	WalletID          *uuid.UUID `json:"wallet_id,omitempty"`
+	Hash              *string    `json:"hash"`
+	Status            *string    `json:"status"`
+	Block             *uint64    `json:"block"`
+	BlockTimestamp    *time.Time `json:"block_timestamp"`
+	CreatedAt         time.Time  `json:"created_at"`
+	BroadcastAt       *time.Time `json:"broadcast_at"`
+	FinalizedAt       *time.Time `json:"finalized_at"`
+	To                *string    `json:"to"`
+	Value             *string    `json:"value"`
+	Method            *string    `json:"method"`
+	GasUsed           *uint64    `json:"gas_used"`
+	EffectiveGasPrice *string    `json:"effective_gas_price"`
+	Fee               *string    `json:"fee"`
+	FeeNative         *string    `json:"fee_native"`
+	NativeCurrency    *string    `json:"native_currency"`
+	FeeUSD            *string    `json:"fee_usd"`
+	Ref               *string    `json:"ref"`
+}
+
+// txExporter streams txs from a db cursor; the networks, contract ABIs and prices used to compute
+// fees and decode methods are cached for the duration of the export
+type txExporter struct {
+	db       *gorm.DB
+	networks map[uuid.UUID]*network.Network
+	abis     map[string]*abi.ABI
+	prices   map[string]*float64
+	unpriced map[string]bool // currency pairs for which a price was not available at the block timestamp of an exported tx
+}
+
+func newTxExporter(db *gorm.DB) *txExporter {
+	return &txExporter{
+		db:       db,
+		networks: map[uuid.UUID]*network.Network{},
+		abis:     map[string]*abi.ABI{},
+		prices:   map[string]*float64{},
+		unpriced: map[string]bool{},
+	}
+}
+
+// exportQuery applies the filters of the given export request to the given query; created_at is
+// filtered using start_date (inclusive) and end_date (exclusive), each of which is an RFC3339
+// timestamp or a date, in which case end_date includes the entire day
+func exportQuery(c *gin.Context, query *gorm.DB) (*gorm.DB, error) {
+	if c.Query("start_date") != "" {
+		startDate, err := parseExportDate(c.Query("start_date"), false)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("transactions.created_at >= ?", startDate)
+	}
+
+	if c.Query("end_date") != "" {
+		endDate, err := parseExportDate(c.Query("end_date"), true)
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("transactions.created_at < ?", endDate)
+	}
+
+	if c.Query("start_block") != "" {
+		startBlock, err := strconv.ParseUint(c.Query("start_block"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start_block: %s", c.Query("start_block"))
+		}
+		query = query.Where("transactions.block >= ?", startBlock)
+	}
+
+	if c.Query("end_block") != "" {
+		endBlock, err := strconv.ParseUint(c.Query("end_block"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid end_block: %s", c.Query("end_block"))
+		}
+		query = query.Where("transactions.block <= ?", endBlock)
+	}
+
+	if c.Query("network_id") != "" {
+		query = query.Where("transactions.network_id = ?", c.Query("network_id"))
+	}
+
+	if c.Query("account_id") != "" {
+		query = query.Where("transactions.account_id = ?", c.Query("account_id"))
+	}
+
+	if c.Query("wallet_id") != "" {
+		query = query.Where("transactions.wallet_id = ?", c.Query("wallet_id"))
+	}
+
+	if c.Query("status") != "" {
+		query = query.Where("transactions.status IN (?)", strings.Split(c.Query("status"), ","))
+	}
+
+	return query, nil
+}
+
+// parseExportDate parses the given RFC3339 timestamp or date; when end is true, a date is
+// parsed as the start of the following day
+func parseExportDate(val string, end bool) (*time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, val); err == nil {
+		return &t, nil
+	}
+
+	t, err := time.Parse("2006-01-02", val)
+	if err != nil {
+		return nil, fmt.Errorf("invalid date: %s; expected RFC3339 timestamp or YYYY-MM-DD", val)
+	}
+	if end {
+		t = t.AddDate(0, 0, 1)
+	}
+	return &t, nil
+}
+
+// export streams the txs matched by the given query to the response using the given format
+func (e *txExporter) export(c *gin.Context, query *gorm.DB, format string) error {
+	rows, err := query.Model(&Transaction{}).Order("transactions.created_at ASC, transactions.id ASC").Rows()
+	if err != nil {
+		return fmt.Errorf("failed to query txs for export; %s", err.Error())
+	}
+	defer rows.Close()
+
+	var csvWriter *csv.Writer
+	var encoder *json.Encoder
+
+	if format == exportFormatNDJSON {
+		c.Header("Content-Type", "application/x-ndjson")
+		c.Header("Content-Disposition", "attachment; filename=transactions.ndjson")
+		encoder = json.NewEncoder(c.Writer)
+	} else {
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", "attachment; filename=transactions.csv")
+		csvWriter = csv.NewWriter(c.Writer)
+	}
+	c.Status(200)
+
+	if csvWriter != nil {
+		csvWriter.Write(exportColumns)
+	}
+
+	exported := 0
+	for rows.Next() {
+		tx := &Transaction{}
+		err := e.db.ScanRows(rows, tx)
+		if err != nil {
+			// the response has been committed, so the export is truncated
+			common.Log.Warningf("failed to scan tx during export; export truncated after %d tx(s); %s", exported, err.Error())
+			break
+		}
+
+		record := e.exportedTransaction(tx)
+		if encoder != nil {
+			err = encoder.Encode(record)
+		} else {
+			err = csvWriter.Write(record.csv())
+		}
+		if err != nil {
+			common.Log.Debugf("failed to write exported tx; export truncated after %d tx(s); %s", exported, err.Error())
+			return nil
+		}
+
+		exported++
+		if exported%exportFlushInterval == 0 {
+			if csvWriter != nil {
+				csvWriter.Flush()
+			}
+			c.Writer.Flush()
+		}
+	}
+
+	if csvWriter != nil {
+		csvWriter.Flush()
+	}
+	c.Writer.Flush()
+
+	common.Log.Debugf("exported %d tx(s)", exported)
+	return nil
+}
+
+// exportedTransaction returns the exported representation of the given tx
+func (e *txExporter) exportedTransaction(tx *Transaction) *ExportedTransaction {
+	record := &ExportedTransaction{
+		ID:             tx.ID,
+		NetworkID:      tx.NetworkID,
+		AccountID:      tx.AccountID,
+		WalletID:       tx.WalletID,
+		Hash:           tx.Hash,
+		Status:         tx.Status,
+		Block:          tx.Block,
+		BlockTimestamp: tx.BlockTimestamp,
+		CreatedAt:      tx.CreatedAt,
+		BroadcastAt:    tx.BroadcastAt,
+		FinalizedAt:    tx.FinalizedAt,
+		To:             tx.To,
+		GasUsed:        tx.GasUsed,
+		Ref:            tx.Ref,
+	}
+
+	if tx.Value != nil && tx.Value.BigInt() != nil {
+		record.Value = common.StringOrNil(tx.Value.BigInt().String())
+	}
+
+	ntwrk := e.network(tx.NetworkID)
+	if ntwrk == nil || !ntwrk.IsEthereumNetwork() {
+		return record
+	}
+
+	record.Method = e.method(tx)
+
+	if nativeCurrency, ok := ntwrk.ParseConfig()["native_currency"].(string); ok && nativeCurrency != "" {
+		record.NativeCurrency = common.StringOrNil(nativeCurrency)
+	}
+
+	// fees are exported using the stored gas used and effective gas price only; see runReceiptFeesBackfillTicker
+	if tx.EffectiveGasPrice == nil || tx.EffectiveGasPrice.BigInt() == nil {
+		return record
+	}
+	record.EffectiveGasPrice = common.StringOrNil(tx.EffectiveGasPrice.BigInt().String())
+
+	if tx.GasUsed == nil {
+		return record
+	}
+
+	fee := new(big.Int).Mul(new(big.Int).SetUint64(*tx.GasUsed), tx.EffectiveGasPrice.BigInt())
+	record.Fee = common.StringOrNil(fee.String())
+
+	feeNative := new(big.Float).Quo(new(big.Float).SetInt(fee), new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(nativeCurrencyDecimals), nil)))
+	record.FeeNative = common.StringOrNil(feeNative.Text('f', nativeCurrencyDecimals))
+
+	if record.NativeCurrency != nil && tx.BlockTimestamp != nil {
+		price := e.price(fmt.Sprintf("%s-USD", strings.ToUpper(*record.NativeCurrency)), *tx.BlockTimestamp)
+		if price != nil && *price > 0 {
+			feeUSD := new(big.Float).Mul(feeNative, big.NewFloat(*price))
+			record.FeeUSD = common.StringOrNil(feeUSD.Text('f', 6))
+		}
+	}
+
+	return record
+}
+
+// price returns the price of the given currency pair observed at the given time; the prices are
+// cached to the minute, and a missing price is logged once per currency pair
+func (e *txExporter) price(currencyPair string, at time.Time) *float64 {
+	key := fmt.Sprintf("%s:%d", currencyPair, at.Truncate(time.Minute).Unix())
+	if price, ok := e.prices[key]; ok {
+		return price
+	}
+
+	price, err := prices.PriceAt(e.db, currencyPair, at)
+	if err != nil || price == nil {
+		if !e.unpriced[currencyPair] {
+			e.unpriced[currencyPair] = true
+			common.Log.Warningf("fiat fees of exported txs are omitted where no %s price is available at the block timestamp", currencyPair)
+		}
+		if err != nil {
+			common.Log.Debugf("failed to resolve price during export; %s", err.Error())
+		}
+	}
+
+	e.prices[key] = price
+	return price
+}
+
+// network returns the cached network with the given id
+func (e *txExporter) network(networkID uuid.UUID) *network.Network {
+	if ntwrk, ok := e.networks[networkID]; ok {
+		return ntwrk
+	}
+
+	ntwrk := &network.Network{}
+	e.db.Where("id = ?", networkID).Find(&ntwrk)
+	if ntwrk.ID == uuid.Nil {
+		ntwrk = nil
+	}
+	e.networks[networkID] = ntwrk
+	return ntwrk
+}
+
+// method returns the name of the ABI method invoked by the tx, or its selector if the ABI of the
+// contract at the tx recipient address is not known
+func (e *txExporter) method(tx *Transaction) *string {
+	if tx.To == nil || tx.Data == nil {
+		return nil
+	}
+
+	data := ethcommon.FromHex(*tx.Data)
+	if len(data) < 4 {
+		return nil
+	}
+
+	key := fmt.Sprintf("%s:%s", tx.NetworkID, strings.ToLower(*tx.To))
+	_abi, ok := e.abis[key]
+	if !ok {
+		c := &contract.Contract{}
+		e.db.Where("network_id = ? AND address = ?", tx.NetworkID, tx.To).Find(&c)
+		if c.ID != uuid.Nil {
+			_abi, _ = c.ReadEthereumContractAbi()
+		}
+		e.abis[key] = _abi
+	}
+
+	if _abi != nil {
+		if method, err := _abi.MethodById(data[0:4]); err == nil {
+			return common.StringOrNil(method.Sig)
+		}
+	}
+
+	return common.StringOrNil(fmt.Sprintf("0x%s", ethcommon.Bytes2Hex(data[0:4])))
+}
+
+// csv returns the exported tx as a CSV record; see exportColumns
+func (t *ExportedTransaction) csv() []string {
+	str := func(val *string) string {
+		if val == nil {
+			return ""
+		}
+		return escapeCSVCell(*val)
+	}
+	id := func(val *uuid.UUID) string {
+		if val == nil {
+			return ""
+		}
+		return val.String()
+	}
+	timestamp := func(val *time.Time) string {
+		if val == nil {
+			return ""
+		}
+		return val.UTC().Format(time.RFC3339)
+	}
+	uint := func(val *uint64) string {
+		if val == nil {
+			return ""
+		}
+		return strconv.FormatUint(*val, 10)
+	}
+
+	return []string{
+		t.ID.String(),
+		t.NetworkID.String(),
+		id(t.AccountID),
+		id(t.WalletID),
+		str(t.Hash),
+		str(t.Status),
+		uint(t.Block),
+		timestamp(t.BlockTimestamp),
+		timestamp(&t.CreatedAt),
+		timestamp(t.BroadcastAt),
+		timestamp(t.FinalizedAt),
+		str(t.To),
+		str(t.Value),
+		str(t.Method),
+		uint(t.GasUsed),
+		str(t.EffectiveGasPrice),
+		str(t.Fee),
+		str(t.FeeNative),
+		str(t.NativeCurrency),
+		str(t.FeeUSD),
+		str(t.Ref),
+	}
+}
+
+// escapeCSVCell prevents the given cell from being evaluated as a formula when the export is
+// opened in a spreadsheet, by prefixing cells which begin with a formula character with a quote
+func escapeCSVCell(val string) string {
+	if val != "" && strings.ContainsRune("=+-@\t\r", rune(val[0])) {
+		return fmt.Sprintf("'%s", val)
+	}
+	return val
+}
@@ -0,0 +1,144 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tx
+
+import (
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/internal/testutil"
+	"github.com/provideplatform/nchain/network"
+)
+
+var currencyPricesQuery = `SELECT \* FROM "currency_prices" WHERE \(currency_pair = \$1 AND observed_at <= \$2 AND observed_at > \$3\) ORDER BY observed_at DESC LIMIT 1`
+
+func exportNetwork(exporter *txExporter) *network.Network {
+	config := json.RawMessage(`{"is_ethereum_network": true, "native_currency": "eth"}`)
+	ntwrk := &network.Network{Config: &config}
+	ntwrk.ID, _ = uuid.NewV4()
+	exporter.networks[ntwrk.ID] = ntwrk
+	return ntwrk
+}
+
+func finalizedTx(ntwrk *network.Network, blockTimestamp time.Time) *Transaction {
+	gasUsed := uint64(21000)
+	block := uint64(100)
+	tx := &Transaction{
+		NetworkID:         ntwrk.ID,
+		Block:             &block,
+		BlockTimestamp:    &blockTimestamp,
+		GasUsed:           &gasUsed,
+		EffectiveGasPrice: &TxValue{value: big.NewInt(50000000000)},
+	}
+	tx.ID, _ = uuid.NewV4()
+	return tx
+}
+
+func TestExportedTransactionFeeUSDUsesPriceAtBlockTimestamp(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	exporter := newTxExporter(db)
+	ntwrk := exportNetwork(exporter)
+	blockTimestamp := time.Date(2021, 6, 1, 12, 30, 15, 0, time.UTC)
+
+	mock.ExpectQuery(currencyPricesQuery).
+		WithArgs("ETH-USD", blockTimestamp, blockTimestamp.Add(-time.Hour)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "currency_pair", "price", "observed_at"}).
+			AddRow(uuid.Must(uuid.NewV4()), "ETH-USD", 2000.0, blockTimestamp.Add(-time.Minute)))
+
+	// the price is resolved once for txs included in blocks within the same minute
+	for _, timestamp := range []time.Time{blockTimestamp, blockTimestamp.Add(time.Second * 30)} {
+		record := exporter.exportedTransaction(finalizedTx(ntwrk, timestamp))
+		if record.Fee == nil || *record.Fee != "1050000000000000" {
+			t.Errorf("expected fee of 1050000000000000 wei; got %v", record.Fee)
+		}
+		if record.FeeUSD == nil || *record.FeeUSD != "2.100000" {
+			t.Errorf("expected fiat fee of 2.100000 at the block timestamp; got %v", record.FeeUSD)
+		}
+	}
+}
+
+func TestExportedTransactionOmitsFeeUSDWithoutPrice(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	exporter := newTxExporter(db)
+	ntwrk := exportNetwork(exporter)
+	blockTimestamp := time.Date(2021, 6, 1, 12, 30, 15, 0, time.UTC)
+
+	mock.ExpectQuery(currencyPricesQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "currency_pair", "price", "observed_at"}))
+	mock.ExpectQuery(currencyPricesQuery).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "currency_pair", "price", "observed_at"}))
+
+	for _, timestamp := range []time.Time{blockTimestamp, blockTimestamp.Add(time.Hour)} {
+		record := exporter.exportedTransaction(finalizedTx(ntwrk, timestamp))
+		if record.Fee == nil || record.FeeUSD != nil {
+			t.Errorf("expected fiat fee to be omitted when no price was observed at the block timestamp; got %v", record.FeeUSD)
+		}
+	}
+	if !exporter.unpriced["ETH-USD"] {
+		t.Error("expected missing ETH-USD price to be recorded for the export")
+	}
+}
+
+func TestExportedTransactionUsesStoredFeesOnly(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	exporter := newTxExporter(db)
+	ntwrk := exportNetwork(exporter)
+
+	tx := finalizedTx(ntwrk, time.Now())
+	tx.Hash = common.StringOrNil("0x1")
+	tx.GasUsed = nil
+
+	record := exporter.exportedTransaction(tx)
+	if record.GasUsed != nil || record.Fee != nil {
+		t.Errorf("expected fee of tx without stored gas used to be omitted; got %v", record.Fee)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expected export to not backfill gas used; %s", err.Error())
+	}
+}
+
+func TestExportedTransactionCSVEscapesFormulas(t *testing.T) {
+	record := &ExportedTransaction{
+		Ref:    common.StringOrNil(`=HYPERLINK("https://example.com","x")`),
+		Status: common.StringOrNil("success"),
+	}
+
+	cells := record.csv()
+	if ref := cells[len(cells)-1]; ref != `'=HYPERLINK("https://example.com","x")` {
+		t.Errorf("expected formula in ref to be escaped; got %s", ref)
+	}
+	if status := cells[5]; status != "success" {
+		t.Errorf("expected status to not be escaped; got %s", status)
+	}
+
+	for _, val := range []string{"+1", "-1", "@SUM(A1)", "\tx", "\rx"} {
+		if escaped := escapeCSVCell(val); escaped != "'"+val {
+			t.Errorf("expected %q to be escaped; got %q", val, escaped)
+		}
+	}
+	if escapeCSVCell("") != "" || escapeCSVCell("0xabc") != "0xabc" {
+		t.Error("expected cells which do not begin with a formula character to not be escaped")
+	}
+}
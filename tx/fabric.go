@@ -0,0 +1,331 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tx
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric-gateway/pkg/client"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/contract"
+	"github.com/provideplatform/nchain/network"
+	"github.com/provideplatform/nchain/network/p2p"
+	"github.com/provideplatform/nchain/wallet"
+	provide "github.com/provideplatform/provide-go/api/nchain"
+)
+
+// fabricTx is a hyperledger fabric chaincode transaction which has been endorsed but not yet submitted
+type fabricTx struct {
+	channel string
+	txID    string
+	raw     []byte                     // the serialized prepared transaction
+	signer  *p2p.FabricSigningIdentity // the identity which signed the proposal, and which signs the tx when it is submitted
+}
+
+// fabricInvocation is a hyperledger fabric chaincode transaction invocation
+type fabricInvocation struct {
+	channel   string
+	chaincode string
+	method    string
+	args      []string
+}
+
+// parseFabricInvocation parses the chaincode invocation from the given tx params
+func parseFabricInvocation(params map[string]interface{}) (*fabricInvocation, error) {
+	channel, _ := params["channel"].(string)
+	chaincode, _ := params["chaincode"].(string)
+	method, _ := params["method"].(string)
+	if channel == "" || chaincode == "" || method == "" {
+		return nil, errors.New("fabric txs require a channel, chaincode and method")
+	}
+
+	var argv []interface{}
+	if args, argsOk := params["args"].([]interface{}); argsOk {
+		argv = args
+	}
+
+	args, err := fabricArgs(argv)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fabricInvocation{
+		channel:   channel,
+		chaincode: chaincode,
+		method:    method,
+		args:      args,
+	}, nil
+}
+
+// fabricArgs converts the given execution params to chaincode args; strings are passed
+// as-is, numbers and booleans are formatted and all other values are JSON-encoded
+func fabricArgs(params []interface{}) ([]string, error) {
+	args := make([]string, 0)
+	for i, param := range params {
+		switch val := param.(type) {
+		case string:
+			args = append(args, val)
+		case float64:
+			args = append(args, strconv.FormatFloat(val, 'f', -1, 64))
+		case bool:
+			args = append(args, strconv.FormatBool(val))
+		default:
+			arg, err := json.Marshal(val)
+			if err != nil {
+				return nil, fmt.Errorf("failed to encode chaincode arg at index %d; %s", i, err.Error())
+			}
+			args = append(args, string(arg))
+		}
+	}
+	return args, nil
+}
+
+// fabricResponse returns the given chaincode response, unmarshaled if it is valid JSON
+func fabricResponse(result []byte) interface{} {
+	var response interface{}
+	if json.Unmarshal(result, &response) == nil {
+		return response
+	}
+	return string(result)
+}
+
+// executeFabricTransaction evaluates or submits a transaction on the chaincode represented by the
+// given contract; queries are evaluated synchronously, while invocations are endorsed and submitted
+// for ordering using the tx lifecycle, with the commit status resolved as the tx receipt
+func executeFabricTransaction(db *gorm.DB, c *contract.Contract, ntwrk *network.Network, execution *contract.Execution) (*contract.ExecutionResponse, error) {
+	cfg, err := ntwrk.FabricConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	chaincode := c.FabricChaincode()
+	if chaincode == nil || *chaincode == "" {
+		return nil, fmt.Errorf("failed to resolve chaincode for contract: %s", c.ID)
+	}
+
+	channel := cfg.DefaultChannel()
+	if c.FabricChannel() != nil {
+		channel = *c.FabricChannel()
+		if !cfg.HasChannel(channel) {
+			return nil, fmt.Errorf("channel %s is not configured for network: %s", channel, ntwrk.ID)
+		}
+	}
+
+	if execution.Method == "" {
+		return nil, fmt.Errorf("failed to execute chaincode %s on channel %s; no method provided", *chaincode, channel)
+	}
+
+	args, err := fabricArgs(execution.Params)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := executionTxFactory(c, execution)
+
+	if execution.Query {
+		signer, err := tx.signerFactory(db)
+		if err != nil {
+			return nil, err
+		}
+
+		fabricSigner, err := signer.fabricSigningIdentity(signer.custodian())
+		if err != nil {
+			return nil, err
+		}
+
+		gw, _, err := ntwrk.FabricGateway(fabricSigner)
+		if err != nil {
+			return nil, err
+		}
+		defer gw.Close()
+
+		common.Log.Debugf("evaluating %s on chaincode %s on channel %s", execution.Method, *chaincode, channel)
+		result, err := gw.GetNetwork(channel).GetContract(*chaincode).EvaluateTransaction(execution.Method, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to evaluate %s on chaincode %s on channel %s; %s", execution.Method, *chaincode, channel, err.Error())
+		}
+
+		accessedAt := time.Now()
+		go func() {
+			c.AccessedAt = &accessedAt
+			db.Save(c)
+		}()
+
+		return &contract.ExecutionResponse{
+			Response: fabricResponse(result),
+			Ref:      execution.Ref,
+			View:     true,
+		}, nil
+	}
+
+	params := tx.ParseParams()
+	delete(params, "gas")
+	params["channel"] = channel
+	params["chaincode"] = *chaincode
+	params["method"] = execution.Method
+	params["args"] = args
+	tx.setParams(params)
+
+	if !tx.Create(db) {
+		err = fmt.Errorf("failed to submit %s on chaincode %s on channel %s", execution.Method, *chaincode, channel)
+		if len(tx.Errors) > 0 {
+			err = fmt.Errorf("%s; %s", err.Error(), *tx.Errors[0].Message)
+		}
+		return nil, err
+	}
+
+	if tx.Hash != nil {
+		common.Log.Debugf("submitted %s on chaincode %s on channel %s; tx id: %s", execution.Method, *chaincode, channel, *tx.Hash)
+	} else {
+		common.Log.Debugf("%s on chaincode %s on channel %s is pending approval; tx: %s", execution.Method, *chaincode, channel, tx.ID)
+	}
+	accessedAt := time.Now()
+	go func() {
+		c.AccessedAt = &accessedAt
+		db.Save(c)
+	}()
+
+	return &contract.ExecutionResponse{
+		Transaction: tx,
+		Ref:         execution.Ref,
+	}, nil
+}
+
+// fabricSigningIdentity returns the identity used to sign fabric proposals and txs on behalf of the
+// signing account, which must hold a secp256r1 key certified by its enrollment certificate, if any;
+// HD wallets and self-custody signatures cannot sign on behalf of a fabric identity
+func (txs *TransactionSigner) fabricSigningIdentity(custodian digestSigner) (*p2p.FabricSigningIdentity, error) {
+	if txs.Account == nil || txs.Account.ID == uuid.Nil {
+		return nil, errors.New("fabric txs must be signed using an account")
+	}
+
+	if !txs.Account.UsesSecp256r1() {
+		return nil, fmt.Errorf("fabric txs must be signed using a %s account; account: %s", wallet.KeySpecSecp256r1, txs.Account.ID)
+	}
+
+	if txs.Account.PublicKey == nil {
+		return nil, fmt.Errorf("failed to resolve public key of signing account: %s", txs.Account.ID)
+	}
+
+	return &p2p.FabricSigningIdentity{
+		PublicKey:   *txs.Account.PublicKey,
+		Certificate: txs.Account.Certificate,
+		Sign:        custodian.signAccountDigest,
+	}, nil
+}
+
+// signFabricTx builds the proposal for the given chaincode invocation and has it endorsed by the
+// peers required by the endorsement policy; the proposal and the endorsed tx are signed using the
+// signing account, which must hold the key certified by its enrollment certificate or, if it has
+// none, by that of the fabric identity configured for the network
+func (txs *TransactionSigner) signFabricTx(tx *Transaction, custodian digestSigner) (signedTx interface{}, hash []byte, err error) {
+	invocation, err := parseFabricInvocation(tx.ParseParams())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signer, err := txs.fabricSigningIdentity(custodian)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	gw, _, err := txs.Network.FabricGateway(signer)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer gw.Close()
+
+	chaincode := gw.GetNetwork(invocation.channel).GetContract(invocation.chaincode)
+	proposal, err := chaincode.NewProposal(invocation.method, client.WithArguments(invocation.args...))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to build proposal for %s on chaincode %s; %s", invocation.method, invocation.chaincode, err.Error())
+	}
+
+	endorsed, err := proposal.Endorse()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to endorse %s on chaincode %s; %s", invocation.method, invocation.chaincode, err.Error())
+	}
+
+	raw, err := endorsed.Bytes()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to serialize endorsed fabric tx; %s", err.Error())
+	}
+
+	hash, err = hex.DecodeString(endorsed.TransactionID())
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to decode fabric tx id: %s; %s", endorsed.TransactionID(), err.Error())
+	}
+
+	common.Log.Debugf("endorsed %s on chaincode %s on channel %s; tx id: %s", invocation.method, invocation.chaincode, invocation.channel, endorsed.TransactionID())
+	return &fabricTx{
+		channel: invocation.channel,
+		txID:    endorsed.TransactionID(),
+		raw:     raw,
+		signer:  signer,
+	}, hash, nil
+}
+
+// broadcastFabricTx submits the given endorsed tx to the orderer on behalf of the identity which
+// signed it; the commit status is not awaited, but is instead resolved when the tx receipt is fetched
+func broadcastFabricTx(ntwrk *network.Network, signedTx *fabricTx) error {
+	gw, _, err := ntwrk.FabricGateway(signedTx.signer)
+	if err != nil {
+		return err
+	}
+	defer gw.Close()
+
+	transaction, err := gw.NewTransaction(signedTx.raw)
+	if err != nil {
+		return fmt.Errorf("failed to deserialize endorsed fabric tx: %s; %s", signedTx.txID, err.Error())
+	}
+
+	_, err = transaction.Submit()
+	if err != nil {
+		return fmt.Errorf("failed to submit fabric tx: %s to orderer; %s", signedTx.txID, err.Error())
+	}
+
+	return nil
+}
+
+// fetchFabricTxReceipt fetches the receipt of the tx by querying the ledger on behalf of the
+// account which signed the tx
+func (t *Transaction) fetchFabricTxReceipt(db *gorm.DB, ntwrk *network.Network) (*provide.TxReceipt, error) {
+	signer, err := t.signerFactory(db)
+	if err != nil {
+		return nil, err
+	}
+
+	fabricSigner, err := signer.fabricSigningIdentity(signer.custodian())
+	if err != nil {
+		return nil, err
+	}
+
+	gw, cfg, err := ntwrk.FabricGateway(fabricSigner)
+	if err != nil {
+		return nil, err
+	}
+	defer gw.Close()
+
+	return p2p.FetchFabricTxReceipt(gw, cfg.Channels, *t.Hash)
+}
@@ -0,0 +1,199 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tx
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/internal/testutil"
+	"github.com/provideplatform/nchain/network"
+	"github.com/provideplatform/nchain/network/p2p"
+	"github.com/provideplatform/nchain/wallet"
+)
+
+func fabricNetwork() *network.Network {
+	config := json.RawMessage(`{"is_hyperledger_fabric_network": true, "channels": ["mychannel"]}`)
+	ntwrk := &network.Network{Config: &config}
+	ntwrk.ID, _ = uuid.NewV4()
+	return ntwrk
+}
+
+func TestSignerFactoryRequiresSigningIdentityForFabricTx(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	ntwrk := fabricNetwork()
+
+	mock.ExpectQuery(`SELECT \* FROM "networks"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "config"}).AddRow(ntwrk.ID, []byte(*ntwrk.Config)))
+
+	tx := &Transaction{NetworkID: ntwrk.ID}
+	if _, err := tx.signerFactory(db); err == nil {
+		t.Error("expected fabric tx without an account, HD wallet or self-custody signing identity to be rejected")
+	}
+}
+
+func TestFabricSigningIdentityIsBoundToSigningAccount(t *testing.T) {
+	walletID, _ := uuid.NewV4()
+	txs := &TransactionSigner{
+		Network: fabricNetwork(),
+		Wallet:  &wallet.Wallet{},
+	}
+	txs.Wallet.ID = walletID
+	if _, err := txs.fabricSigningIdentity(txs.custodian()); err == nil {
+		t.Error("expected fabric tx to not be signed using an HD wallet")
+	}
+
+	accountID, _ := uuid.NewV4()
+	txs = &TransactionSigner{
+		Network: fabricNetwork(),
+		Account: &wallet.Account{},
+	}
+	txs.Account.ID = accountID
+	if _, err := txs.fabricSigningIdentity(txs.custodian()); err == nil {
+		t.Error("expected fabric tx to not be signed using an account without a public key")
+	}
+
+	txs.Account.PublicKey = common.StringOrNil("0x04abcdef")
+	if _, err := txs.fabricSigningIdentity(txs.custodian()); err == nil {
+		t.Errorf("expected fabric tx to not be signed using an account without a %s key", wallet.KeySpecSecp256r1)
+	}
+
+	txs.Account.Spec = common.StringOrNil(wallet.KeySpecSecp256r1)
+	signer, err := txs.fabricSigningIdentity(txs.custodian())
+	if err != nil {
+		t.Fatalf("failed to resolve fabric signing identity of account; %s", err.Error())
+	}
+	if signer.PublicKey != "0x04abcdef" {
+		t.Errorf("expected fabric signing identity to use the public key of the signing account; got %s", signer.PublicKey)
+	}
+	if _, err := signer.Sign(make([]byte, 32)); err == nil {
+		t.Error("expected signing account without vault-held key material to not sign on behalf of the fabric identity")
+	}
+}
+
+// keystoreFabricAccount returns a secp256r1 account whose key is held in the local keystore and
+// certified by its enrollment certificate
+func keystoreFabricAccount(t *testing.T) (*wallet.Account, *ecdsa.PrivateKey) {
+	passphrase := common.KeystorePassphrase
+	common.KeystorePassphrase = "test passphrase"
+	t.Cleanup(func() {
+		common.KeystorePassphrase = passphrase
+	})
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate secp256r1 key; %s", err.Error())
+	}
+
+	cryptoJSON, err := keystore.EncryptDataV3(key.D.FillBytes(make([]byte, 32)), []byte(common.KeystorePassphrase), keystore.LightScryptN, keystore.LightScryptP)
+	if err != nil {
+		t.Fatalf("failed to encrypt keystore; %s", err.Error())
+	}
+	raw, _ := json.Marshal(map[string]interface{}{
+		"crypto":  cryptoJSON,
+		"id":      "keystore",
+		"version": 3,
+	})
+	keystoreJSON := json.RawMessage(raw)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "user1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create enrollment certificate; %s", err.Error())
+	}
+
+	account := &wallet.Account{
+		Keystore:    &keystoreJSON,
+		Spec:        common.StringOrNil(wallet.KeySpecSecp256r1),
+		PublicKey:   common.StringOrNil("0x" + hex.EncodeToString(elliptic.Marshal(elliptic.P256(), key.X, key.Y))),
+		Certificate: common.StringOrNil(string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))),
+	}
+	account.ID, _ = uuid.NewV4()
+	return account, key
+}
+
+func TestFabricSigningIdentitySignsUsingKeystoreSecp256r1Account(t *testing.T) {
+	account, key := keystoreFabricAccount(t)
+	txs := &TransactionSigner{
+		Network: fabricNetwork(),
+		Account: account,
+	}
+
+	signer, ok := txs.signer().(*KeystoreSigner)
+	if !ok {
+		t.Fatalf("expected keystore account to be signed by KeystoreSigner; got %T", txs.signer())
+	}
+
+	fabricSigner, err := signer.fabricSigningIdentity(signer)
+	if err != nil {
+		t.Fatalf("failed to resolve fabric signing identity of account; %s", err.Error())
+	}
+
+	// the network does not configure a network-wide certificate; the account is certified by its own
+	id, sign, err := fabricSigner.Identity(&p2p.FabricConfig{
+		Channels: []string{"mychannel"},
+		Identity: &p2p.FabricIdentity{MspID: "Org1MSP"},
+	})
+	if err != nil {
+		t.Fatalf("expected account key to be certified by its enrollment certificate; %s", err.Error())
+	}
+	if id.MspID() != "Org1MSP" {
+		t.Errorf("expected identity of msp Org1MSP; got %s", id.MspID())
+	}
+
+	digest := sha256.Sum256([]byte("proposal"))
+	der, err := sign(digest[:])
+	if err != nil {
+		t.Fatalf("failed to sign digest on behalf of fabric identity; %s", err.Error())
+	}
+
+	var sig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		t.Fatalf("expected DER-encoded signature; %s", err.Error())
+	}
+	if sig.S.Cmp(new(big.Int).Rsh(elliptic.P256().Params().N, 1)) == 1 {
+		t.Error("expected signature to have a low s value")
+	}
+	if !ecdsa.Verify(&key.PublicKey, digest[:], sig.R, sig.S) {
+		t.Error("expected signature to verify using the certified account key")
+	}
+}
@@ -0,0 +1,88 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tx
+
+import (
+	"math/big"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/internal/testutil"
+)
+
+func TestFinalizableTxOnlyMatchesPendingTxs(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	txID, _ := uuid.NewV4()
+
+	mock.ExpectQuery(`SELECT \* FROM "transactions" WHERE \(hash = \$1 AND status = \$2\)`).
+		WithArgs("0xabc", "pending").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}).AddRow(txID, "pending"))
+
+	tx := finalizableTx(db, "0xabc")
+	if tx.ID != txID {
+		t.Errorf("expected pending tx %s to be finalized; got %s", txID, tx.ID)
+	}
+}
+
+func TestFinalizableTxNeverMatchesFailedTxs(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+
+	// the failed tx is excluded by the status filter
+	mock.ExpectQuery(`SELECT \* FROM "transactions" WHERE \(hash = \$1 AND status = \$2\)`).
+		WithArgs("0xabc", "pending").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "status"}))
+
+	tx := finalizableTx(db, "0xabc")
+	if tx.ID != uuid.Nil {
+		t.Errorf("expected failed tx to not be finalized; got %s", tx.ID)
+	}
+}
+
+func TestRevertReplayBlockUsesParentBlock(t *testing.T) {
+	cases := []struct {
+		block    *big.Int
+		expected *big.Int
+	}{
+		{nil, nil},
+		{big.NewInt(0), big.NewInt(0)},
+		{big.NewInt(1), big.NewInt(0)},
+		{big.NewInt(12965000), big.NewInt(12964999)},
+	}
+
+	for _, c := range cases {
+		replayBlock := revertReplayBlock(c.block)
+		if c.expected == nil {
+			if replayBlock != nil {
+				t.Errorf("expected nil block to replay at the latest block; got %s", replayBlock)
+			}
+			continue
+		}
+		if replayBlock == nil || replayBlock.Cmp(c.expected) != 0 {
+			t.Errorf("expected tx included in block %s to be replayed at block %s; got %s", c.block, c.expected, replayBlock)
+		}
+	}
+
+	block := big.NewInt(100)
+	revertReplayBlock(block)
+	if block.Int64() != 100 {
+		t.Errorf("expected receipt block number to be left unmodified; got %s", block)
+	}
+}
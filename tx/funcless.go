@@ -34,18 +34,19 @@ import (
 	providecrypto "github.com/provideplatform/provide-go/crypto"
 )
 
-func executeTransaction(c *contract.Contract, execution *contract.Execution) (*contract.ExecutionResponse, error) {
-	db := dbconf.DatabaseConnection()
+// executionTxFactory builds the tx which executes the given contract execution
+func executionTxFactory(c *contract.Contract, execution *contract.Execution) *Transaction {
 	hdDerivationPath := execution.HDPath
 	publishedAt := execution.PublishedAt
-	method := execution.Method
-	params := execution.Params
 	ref := execution.Ref
 	value := execution.Value
 	walletID := execution.WalletID
 	accountID := execution.AccountID
 	gas := execution.Gas
 	gasPrice := execution.GasPrice
+	maxFeePerGas := execution.MaxFeePerGas
+	maxPriorityFeePerGas := execution.MaxPriorityFeePerGas
+	txType := execution.Type
 	nonce := execution.Nonce
 	path := execution.HDPath
 
@@ -65,10 +66,34 @@ func executeTransaction(c *contract.Contract, execution *contract.Execution) (*c
 		txParams["gas_price"] = gasPrice
 	}
 
+	if maxFeePerGas != nil {
+		txParams["max_fee_per_gas"] = maxFeePerGas.String()
+	}
+
+	if maxPriorityFeePerGas != nil {
+		txParams["max_priority_fee_per_gas"] = maxPriorityFeePerGas.String()
+	}
+
+	if txType != nil {
+		txParams["type"] = *txType
+	}
+
 	if nonce != nil {
 		txParams["nonce"] = *nonce
 	}
 
+	if len(execution.PrivateFor) > 0 {
+		txParams["private_for"] = execution.PrivateFor
+	}
+
+	if execution.PrivateFrom != nil {
+		txParams["private_from"] = *execution.PrivateFrom
+	}
+
+	if execution.PrivacyFlag != nil {
+		txParams["privacy_flag"] = *execution.PrivacyFlag
+	}
+
 	if path != nil {
 		txParams["hd_derivation_path"] = *path
 	}
@@ -94,19 +119,16 @@ func executeTransaction(c *contract.Contract, execution *contract.Execution) (*c
 		tx.PublishedAt = publishedAt
 	}
 
-	var err error
-	_abi, err := c.ReadEthereumContractAbi()
-	if err != nil {
-		return nil, fmt.Errorf("Failed to execute contract method %s on contract: %s; no ABI resolved: %s", method, c.ID, err.Error())
-	}
-	var methodDescriptor = fmt.Sprintf("method %s", method)
-	var abiMethod *abi.Method
-	if mthd, ok := _abi.Methods[method]; ok {
-		abiMethod = &mthd
-	} else if method == "" {
-		abiMethod = &_abi.Constructor
-		methodDescriptor = "constructor"
-	}
+	return tx
+}
+
+func executeTransaction(c *contract.Contract, execution *contract.Execution) (*contract.ExecutionResponse, error) {
+	db := dbconf.DatabaseConnection()
+	method := execution.Method
+	params := execution.Params
+	ref := execution.Ref
+
+	tx := executionTxFactory(c, execution)
 
 	// let's take in a network id, and use it to get a network:
 	var n *network.Network
@@ -115,8 +137,35 @@ func executeTransaction(c *contract.Contract, execution *contract.Execution) (*c
 		db.Model(tx).Related(&n)
 	}
 
+	if n.IsHyperledgerFabricNetwork() {
+		return executeFabricTransaction(db, c, n, execution)
+	}
+
+	var err error
+	_abi, err := c.ReadEthereumContractAbi()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to execute contract method %s on contract: %s; no ABI resolved: %s", method, c.ID, err.Error())
+	}
+	abiMethod, methodDescriptor := resolveExecutionMethod(_abi, method)
+
 	var response map[string]interface{}
 
+	if n.IsEthereumNetwork() && abiMethod != nil && !abiMethod.IsConstant() {
+		// signing policies are enforced using the encoded calldata before the tx signer is resolved
+		invocationSig, err := providecrypto.EVMEncodeABI(abiMethod, params...)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to encode %d parameters prior to attempting execution of %s on contract: %s; %s", len(params), methodDescriptor, c.ID, err.Error())
+		}
+
+		data := fmt.Sprintf("0x%s", ethcommon.Bytes2Hex(invocationSig))
+		tx.Data = &data
+
+		err = tx.enforcePolicies(db)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to execute %s on contract: %s; %s", methodDescriptor, c.ID, err.Error())
+		}
+	}
+
 	if n.IsEthereumNetwork() {
 		response, err = getTransactionResponse(tx, c, n, methodDescriptor, method, abiMethod, params)
 	} else {
@@ -162,6 +211,19 @@ func executeTransaction(c *contract.Contract, execution *contract.Execution) (*c
 	return &resp, nil
 }
 
+// resolveExecutionMethod resolves the named ABI method, or the constructor if no method is named
+func resolveExecutionMethod(_abi *abi.ABI, method string) (*abi.Method, string) {
+	var methodDescriptor = fmt.Sprintf("method %s", method)
+	var abiMethod *abi.Method
+	if mthd, ok := _abi.Methods[method]; ok {
+		abiMethod = &mthd
+	} else if method == "" {
+		abiMethod = &_abi.Constructor
+		methodDescriptor = "constructor"
+	}
+	return abiMethod, methodDescriptor
+}
+
 func getTransactionResponse(tx *Transaction, c *contract.Contract, network *network.Network, methodDescriptor, method string, abiMethod *abi.Method, params []interface{}) (map[string]interface{}, error) {
 	var err error
 	result := make([]byte, 32)
@@ -189,7 +251,7 @@ func getTransactionResponse(tx *Transaction, c *contract.Contract, network *netw
 
 			if abiMethod.IsConstant() {
 				common.Log.Debugf("Attempting to read constant method %s on contract: %s", method, c.ID)
-				client, err := providecrypto.EVMDialJsonRpc(network.ID.String(), network.RPCURL())
+				client, err := network.EVMDialJSONRPC()
 				msg := tx.asEthereumCallMsg(signer.Address(), 0, 0)
 				result, err = client.CallContract(context.TODO(), msg, nil)
 				if err != nil {
@@ -229,7 +291,12 @@ func getTransactionResponse(tx *Transaction, c *contract.Contract, network *netw
 
 					if publicKeyOk && privateKeyOk {
 						common.Log.Debugf("Attempting to execute %s on contract: %s; arbitrarily-provided signer for tx: %s; gas supplied: %v", methodDescriptor, c.ID, publicKey, gas)
-						tx.SignedTx, tx.Hash, err = providecrypto.EVMSignTx(network.ID.String(), network.RPCURL(), publicKey.(string), privateKey.(string), tx.To, tx.Data, tx.Value.BigInt(), nonce, uint64(gas), gasPrice)
+						rpcClientKey, rpcURL, err := network.RPCEndpoint()
+						if err != nil {
+							return nil, err
+						}
+
+						tx.SignedTx, tx.Hash, err = providecrypto.EVMSignTx(rpcClientKey, rpcURL, publicKey.(string), privateKey.(string), tx.To, tx.Data, tx.Value.BigInt(), nonce, uint64(gas), gasPrice)
 						if err != nil {
 							err = fmt.Errorf("Unable to broadcast signed tx; typecast failed for signed tx: %s", tx.SignedTx)
 							common.Log.Warning(err.Error())
@@ -237,7 +304,7 @@ func getTransactionResponse(tx *Transaction, c *contract.Contract, network *netw
 						}
 
 						if signedTx, ok := tx.SignedTx.(*types.Transaction); ok {
-							err = providecrypto.EVMBroadcastSignedTx(network.ID.String(), network.RPCURL(), signedTx)
+							err = providecrypto.EVMBroadcastSignedTx(rpcClientKey, rpcURL, signedTx)
 							return nil, err
 						}
 
@@ -38,13 +38,21 @@ import (
 // InstallTransactionsAPI installs the handlers using the given gin Engine
 func InstallTransactionsAPI(r *gin.Engine) {
 	r.GET("/api/v1/transactions", transactionsListHandler)
+	r.GET("/api/v1/transactions/export", transactionsExportHandler)
 	r.POST("/api/v1/transactions", createTransactionHandler)
 	r.POST("/api/v1/transactions/broadcast", broadcastTransactionHandler)
+	r.POST("/api/v1/transactions/simulate", simulateTransactionHandler)
+	r.POST("/api/v1/transactions/batch", createTransactionBatchHandler)
+	r.GET("/api/v1/transactions/batch/:id", transactionBatchDetailsHandler)
 	r.GET("/api/v1/transactions/:id", transactionDetailsHandler)
+	r.POST("/api/v1/transactions/:id/replace", replaceTransactionHandler)
+	r.GET("/api/v1/transactions/:id/approvals", transactionApprovalsListHandler)
+	r.POST("/api/v1/transactions/:id/approvals", createTransactionApprovalHandler)
 	r.GET("/api/v1/networks/:id/transactions", networkTransactionsListHandler)
 	r.GET("/api/v1/networks/:id/transactions/:transactionId", networkTransactionDetailsHandler)
 
 	r.POST("/api/v1/contracts/:id/execute", contractExecutionHandler)
+	r.POST("/api/v1/contracts/:id/execute/batch", contractExecutionBatchHandler)
 }
 
 func transactionsListHandler(c *gin.Context) {
@@ -56,55 +64,67 @@ func transactionsListHandler(c *gin.Context) {
 		return
 	}
 
+	db := dbconf.DatabaseConnection()
+
 	var query *gorm.DB
 	if appID != nil {
-		query = dbconf.DatabaseConnection().Where("transactions.application_id = ?", appID)
+		query = db.Where("transactions.application_id = ?", appID)
 	} else if orgID != nil {
-		query = dbconf.DatabaseConnection().Where("transactions.organization_id = ?", orgID)
+		query = db.Where("transactions.organization_id = ?", orgID)
 	} else if userID != nil {
-		query = dbconf.DatabaseConnection().Where("transactions.user_id = ?", userID)
+		query = db.Where("transactions.user_id = ?", userID)
 	}
 
-	filterContractCreationTx := strings.ToLower(c.Query("filter_contract_creations")) == "true"
-	if filterContractCreationTx {
-		query = query.Where("transactions.to IS NULL")
+	query, err := filterTransactions(c, db, query)
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
 	}
 
-	if c.Query("status") != "" {
-		query = query.Where("transactions.status IN ?", strings.Split(c.Query("status"), ","))
+	txs, err := paginateTransactions(c, query)
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
 	}
+	provide.Render(txs, 200, c)
+}
 
-	if c.Query("to") != "" {
-		query = query.Where("transactions.to = ?", c.Query("to"))
+func transactionsExportHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	userID := util.AuthorizedSubjectID(c, "user")
+	if appID == nil && orgID == nil && userID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
 	}
 
-	if c.Query("from") != "" {
-		from := &wallet.Account{}
-		db := dbconf.DatabaseConnection()
-		fromQuery := db.Where("address = ?", from)
-		if c.Query("network_id") != "" {
-			fromQuery = fromQuery.Where("network_id = ?", c.Query("network_id"))
-		}
-		fromQuery.Find(&from)
-		if from != nil && from.ID != uuid.Nil {
-			query = query.Where("transactions.account_id= ?", from.ID)
-		} else {
-			provide.RenderError("from address not resolved to a known signer", 404, c)
-		}
+	format := strings.ToLower(c.DefaultQuery("format", exportFormatCSV))
+	if format != exportFormatCSV && format != exportFormatNDJSON {
+		provide.RenderError(fmt.Sprintf("unsupported export format: %s", format), 400, c)
+		return
 	}
 
-	if c.Query("network_id") != "" {
-		query = query.Where("transactions.network_id = ?", c.Query("network_id"))
+	db := dbconf.DatabaseConnection()
+
+	var query *gorm.DB
+	if appID != nil {
+		query = db.Where("transactions.application_id = ?", appID)
+	} else if orgID != nil {
+		query = db.Where("transactions.organization_id = ?", orgID)
+	} else if userID != nil {
+		query = db.Where("transactions.user_id = ?", userID)
 	}
 
-	if c.Query("account_id") != "" {
-		query = query.Where("transactions.account_id= ?", c.Query("account_id"))
+	query, err := exportQuery(c, query)
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
 	}
 
-	var txs []Transaction
-	query = query.Order("created_at DESC")
-	provide.Paginate(c, query, &Transaction{}).Find(&txs)
-	provide.Render(txs, 200, c)
+	err = newTxExporter(db).export(c, query, format)
+	if err != nil {
+		provide.RenderError(err.Error(), 500, c)
+	}
 }
 
 func createTransactionHandler(c *gin.Context) {
@@ -133,10 +153,16 @@ func createTransactionHandler(c *gin.Context) {
 	tx.OrganizationID = orgID
 	tx.UserID = userID
 
+	idempotentReq, handled := beginIdempotentRequest(c, appID, orgID, userID, buf)
+	if handled {
+		return
+	}
+	defer idempotentReq.finish()
+
 	db := dbconf.DatabaseConnection()
 
 	if tx.Create(db) {
-		provide.Render(tx, 201, c)
+		idempotentReq.render(c, tx, 201)
 	} else {
 		obj := map[string]interface{}{}
 		obj["errors"] = tx.Errors
@@ -144,6 +170,100 @@ func createTransactionHandler(c *gin.Context) {
 	}
 }
 
+func createTransactionBatchHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	userID := util.AuthorizedSubjectID(c, "user")
+	if appID == nil && orgID == nil && userID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	req, err := parseBatchRequest(buf)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	batch := NewBatch(req.Multicall)
+	batch.ApplicationID = appID
+	batch.OrganizationID = orgID
+	batch.UserID = userID
+
+	for i, item := range req.Items {
+		requested := &Transaction{}
+		err = json.Unmarshal(item, requested)
+		if err != nil {
+			batch.itemError(i, err.Error())
+			continue
+		}
+
+		tx := requestedTransaction(requested)
+		tx.ApplicationID = appID
+		tx.OrganizationID = orgID
+		tx.UserID = userID
+		batch.add(tx)
+	}
+
+	if len(batch.Errors) > 0 {
+		obj := map[string]interface{}{}
+		obj["errors"] = batch.Errors
+		provide.Render(obj, 422, c)
+		return
+	}
+
+	idempotentReq, handled := beginIdempotentRequest(c, appID, orgID, userID, buf)
+	if handled {
+		return
+	}
+	defer idempotentReq.finish()
+
+	if batch.Create(dbconf.DatabaseConnection()) {
+		idempotentReq.render(c, batch, 201)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = batch.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+func transactionBatchDetailsHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	userID := util.AuthorizedSubjectID(c, "user")
+	if appID == nil && orgID == nil && userID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	batch := &Batch{}
+	db.Where("id = ?", c.Param("id")).Find(&batch)
+	if batch == nil || batch.ID == uuid.Nil {
+		provide.RenderError("batch not found", 404, c)
+		return
+	}
+
+	validApp := appID != nil && (batch.ApplicationID != nil && *batch.ApplicationID == *appID)
+	validOrg := orgID != nil && (batch.OrganizationID != nil && *batch.OrganizationID == *orgID)
+	validUser := userID != nil && (batch.UserID != nil && *batch.UserID == *userID)
+
+	if !validApp && !validOrg && !validUser {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	batch.resolveStatus(db)
+	provide.Render(batch, 200, c)
+}
+
 func broadcastTransactionHandler(c *gin.Context) {
 	appID := util.AuthorizedSubjectID(c, "application")
 	orgID := util.AuthorizedSubjectID(c, "organization")
@@ -170,10 +290,16 @@ func broadcastTransactionHandler(c *gin.Context) {
 	tx.OrganizationID = orgID
 	tx.UserID = userID
 
+	idempotentReq, handled := beginIdempotentRequest(c, appID, orgID, userID, buf)
+	if handled {
+		return
+	}
+	defer idempotentReq.finish()
+
 	db := dbconf.DatabaseConnection()
 
 	if tx.Create(db) {
-		provide.Render(tx, 201, c)
+		idempotentReq.render(c, tx, 201)
 	} else {
 		for _, err := range tx.Errors {
 			if err.Message != nil && tx.Data != nil {
@@ -186,6 +312,53 @@ func broadcastTransactionHandler(c *gin.Context) {
 	}
 }
 
+func simulateTransactionHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	userID := util.AuthorizedSubjectID(c, "user")
+	if appID == nil && orgID == nil && userID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	tx := &Transaction{}
+	err = json.Unmarshal(buf, tx)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	tx.ApplicationID = appID
+	tx.OrganizationID = orgID
+	tx.UserID = userID
+
+	if tx.Value == nil {
+		tx.Value = NewTxValue(0)
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	simulation, err := tx.simulate(db, nil)
+	if err != nil {
+		if len(tx.Errors) == 0 {
+			provide.RenderError(err.Error(), 422, c)
+			return
+		}
+		obj := map[string]interface{}{}
+		obj["errors"] = tx.Errors
+		provide.Render(obj, 422, c)
+		return
+	}
+
+	provide.Render(simulation, 200, c)
+}
+
 func transactionDetailsHandler(c *gin.Context) {
 	appID := util.AuthorizedSubjectID(c, "application")
 	orgID := util.AuthorizedSubjectID(c, "organization")
@@ -224,27 +397,194 @@ func transactionDetailsHandler(c *gin.Context) {
 	provide.Render(tx, 200, c)
 }
 
-func networkTransactionsListHandler(c *gin.Context) {
+func transactionApprovalsListHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	userID := util.AuthorizedSubjectID(c, "user")
+	if appID == nil && orgID == nil && userID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	var tx = &Transaction{}
+	db.Where("id = ?", c.Param("id")).Find(&tx)
+	if tx == nil || tx.ID == uuid.Nil {
+		provide.RenderError("transaction not found", 404, c)
+		return
+	}
+
+	validApp := appID != nil && (tx.ApplicationID != nil && *tx.ApplicationID == *appID)
+	validOrg := orgID != nil && (tx.OrganizationID != nil && *tx.OrganizationID == *orgID)
+	validUser := userID != nil && (tx.UserID != nil && *tx.UserID == *userID)
+	validApprover := userID != nil && tx.isApprover(db, *userID)
+
+	if !validApp && !validOrg && !validUser && !validApprover {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	var approvals []*Approval
+	query := db.Where("tx_approvals.transaction_id = ?", tx.ID).Order("tx_approvals.created_at ASC")
+	provide.Paginate(c, query, &Approval{}).Find(&approvals)
+	provide.Render(approvals, 200, c)
+}
+
+func createTransactionApprovalHandler(c *gin.Context) {
 	userID := util.AuthorizedSubjectID(c, "user")
 	if userID == nil {
 		provide.RenderError("unauthorized", 401, c)
 		return
 	}
 
-	query := dbconf.DatabaseConnection().Where("transactions.network_id = ? AND transactions.application_id IS NULL", c.Param("id"))
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	params := map[string]interface{}{}
+	err = json.Unmarshal(buf, &params)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	approved, approvedOk := params["approved"].(bool)
+	if !approvedOk {
+		provide.RenderError("approved must be true or false", 422, c)
+		return
+	}
+
+	var reason *string
+	if _reason, reasonOk := params["reason"].(string); reasonOk {
+		reason = common.StringOrNil(_reason)
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	var tx = &Transaction{}
+	db.Where("id = ?", c.Param("id")).Find(&tx)
+	if tx == nil || tx.ID == uuid.Nil {
+		provide.RenderError("transaction not found", 404, c)
+		return
+	}
+
+	if tx.Status == nil || *tx.Status != txStatusAwaitingApproval {
+		provide.RenderError("only transactions awaiting approval can be approved or rejected", 409, c)
+		return
+	}
 
-	filterContractCreationTx := strings.ToLower(c.Query("filter_contract_creations")) == "true"
-	if filterContractCreationTx {
-		query = query.Where("transactions.to IS NULL")
+	approval, err := tx.approve(db, *userID, approved, reason)
+	if err == errApprovalForbidden || err == errApprovalByCreator {
+		provide.RenderError(err.Error(), 403, c)
+		return
+	} else if err == errApprovalConflict {
+		provide.RenderError(err.Error(), 409, c)
+		return
+	} else if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
 	}
 
-	if c.Query("status") != "" {
-		query = query.Where("transactions.status IN ?", strings.Split(c.Query("status"), ","))
+	provide.Render(approval, 201, c)
+}
+
+func replaceTransactionHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	userID := util.AuthorizedSubjectID(c, "user")
+	if appID == nil && orgID == nil && userID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
 	}
 
-	var txs []Transaction
-	query = query.Order("created_at DESC")
-	provide.Paginate(c, query, &Transaction{}).Find(&txs)
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	buf, err = normalizeDynamicFeeParams(buf)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	params := map[string]interface{}{}
+	err = json.Unmarshal(buf, &params)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	mode, modeOk := params["mode"].(string)
+	if !modeOk || (mode != txReplacementModeSpeedUp && mode != txReplacementModeCancel) {
+		provide.RenderError(fmt.Sprintf("mode must be one of %s or %s", txReplacementModeSpeedUp, txReplacementModeCancel), 422, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	var tx = &Transaction{}
+	db.Where("id = ?", c.Param("id")).Find(&tx)
+	if tx == nil || tx.ID == uuid.Nil {
+		provide.RenderError("transaction not found", 404, c)
+		return
+	}
+
+	validApp := appID != nil && (tx.ApplicationID != nil && *tx.ApplicationID == *appID)
+	validOrg := orgID != nil && (tx.OrganizationID != nil && *tx.OrganizationID == *orgID)
+	validUser := userID != nil && (tx.UserID != nil && *tx.UserID == *userID)
+
+	if !validApp && !validOrg && !validUser {
+		provide.RenderError("forbidden", 403, c)
+		return
+	}
+
+	if tx.Status == nil || *tx.Status != "pending" {
+		provide.RenderError("only pending transactions can be replaced", 409, c)
+		return
+	}
+
+	replacement, err := tx.replace(db, mode, params)
+	if err != nil {
+		common.Log.Debugf("failed to replace tx %s; %s", tx.ID, err.Error())
+		if replacement != nil && len(replacement.Errors) > 0 {
+			obj := map[string]interface{}{}
+			obj["errors"] = replacement.Errors
+			provide.Render(obj, 422, c)
+			return
+		}
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	provide.Render(replacement, 201, c)
+}
+
+func networkTransactionsListHandler(c *gin.Context) {
+	userID := util.AuthorizedSubjectID(c, "user")
+	if userID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+	query := db.Where("transactions.network_id = ? AND transactions.application_id IS NULL", c.Param("id"))
+
+	query, err := filterTransactions(c, db, query)
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	txs, err := paginateTransactions(c, query)
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
 	provide.Render(txs, 200, c)
 }
 
@@ -404,6 +744,34 @@ func networkTransactionDetailsHandler(c *gin.Context) {
 // 	provide.Render(resp, 200, c)
 // }
 
+// deriveHDWalletAddress derives the address of the given HD wallet using the given derivation path;
+// if no path is given, the key is derived using the default path of the custodian of the wallet
+func deriveHDWalletAddress(wllt *wallet.Wallet, derivationPath *string) (*string, error) {
+	if wllt.UsesKeystore() {
+		_, address, _, err := wllt.KeystoreDerive(derivationPath)
+		if err != nil {
+			return nil, err
+		}
+		return &address, nil
+	}
+
+	if wllt.VaultID == nil || wllt.KeyID == nil {
+		return nil, fmt.Errorf("HD wallet %s key material not held in vault", wllt.ID)
+	}
+
+	params := map[string]interface{}{}
+	if derivationPath != nil {
+		params["hd_derivation_path"] = *derivationPath
+	}
+
+	key, err := vault.DeriveKey(util.DefaultVaultAccessJWT, wllt.VaultID.String(), wllt.KeyID.String(), params)
+	if err != nil {
+		return nil, err
+	}
+
+	return key.Address, nil
+}
+
 func contractExecutionHandler(c *gin.Context) {
 	appID := util.AuthorizedSubjectID(c, "application")
 	orgID := util.AuthorizedSubjectID(c, "organization")
@@ -419,6 +787,12 @@ func contractExecutionHandler(c *gin.Context) {
 		return
 	}
 
+	buf, err = normalizeDynamicFeeParams(buf)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
 	params := map[string]interface{}{}
 	err = json.Unmarshal(buf, &params)
 	if err != nil {
@@ -427,6 +801,17 @@ func contractExecutionHandler(c *gin.Context) {
 		return
 	}
 
+	var idempotentReq *idempotentRequest
+	if dryRun, _ := params["dry_run"].(bool); !dryRun {
+		// dry runs have no side effects, so their responses are not cached using idempotency keys
+		var handled bool
+		idempotentReq, handled = beginIdempotentRequest(c, appID, orgID, userID, buf)
+		if handled {
+			return
+		}
+		defer idempotentReq.finish()
+	}
+
 	db := dbconf.DatabaseConnection()
 
 	contractID := c.Param("id")
@@ -444,44 +829,8 @@ func contractExecutionHandler(c *gin.Context) {
 	// 	return
 	// }
 
-	var contractObj = &contract.Contract{}
-
-	db.Where("id = ?", contractID).Find(&contractObj)
-
-	// if we can't find by ID, attempt to lookup the contract by address
-	// ensure that the contract returned is the valid ID for the provided token data
-	if contractObj == nil || contractObj.ID == uuid.Nil {
-		query := db.Where("address = ?", c.Param("id"))
-		if appID != nil {
-			query = query.Where("contracts.application_id = ?", appID)
-		}
-		if orgID != nil {
-			query = query.Where("contracts.organization_id = ?", orgID)
-		}
-		if userID != nil {
-			query = query.Where("contracts.application_id IS NULL", userID)
-		}
-		query.Find(&contractObj)
-	}
-
-	if contractObj == nil || contractObj.ID == uuid.Nil {
-		//if appID != nil {
-		provide.RenderError("contract not found", 404, c)
-		return
-		//}
-
-		// common.Log.Debugf("Attempting arbitrary, non-permissioned contract execution on behalf of user with id: %s", userID)
-		// contractArbitraryExecutionHandler(c, db, buf)
-		// return
-	}
-
-	if appID != nil && contractObj.ApplicationID != nil && *contractObj.ApplicationID != *appID {
-		provide.RenderError("forbidden", 403, c)
-		return
-	}
-
-	if orgID != nil && contractObj.OrganizationID != nil && *contractObj.OrganizationID != *orgID {
-		provide.RenderError("forbidden", 403, c)
+	contractObj := resolveExecutionContract(c, db, contractID, appID, orgID, userID)
+	if contractObj == nil {
 		return
 	}
 
@@ -541,10 +890,7 @@ func contractExecutionHandler(c *gin.Context) {
 					provide.RenderError(err.Error(), 500, c)
 					return
 				}
-				pathstr := *wallet.Path
-				key, err := vault.DeriveKey(util.DefaultVaultAccessJWT, wllt.VaultID.String(), wllt.KeyID.String(), map[string]interface{}{
-					"hd_derivation_path": pathstr,
-				})
+				address, err := deriveHDWalletAddress(wllt, wallet.Path)
 				if err != nil {
 					err := fmt.Errorf("unable to generate key material for HD wallet; %s", err.Error())
 					common.Log.Warning(err.Error())
@@ -552,29 +898,30 @@ func contractExecutionHandler(c *gin.Context) {
 					return
 				}
 
-				execution.AccountAddress = key.Address
-				common.Log.Debugf("xxx using address: %s, derived from wallet using path %s", *key.Address, pathstr)
+				execution.AccountAddress = address
+				common.Log.Debugf("xxx using address: %s, derived from wallet using path %s", *address, *wallet.Path)
 			}
 
 			if wallet.Path == nil {
-				// we have no path, so derive a key from vault using the default path
-				common.Log.Debugf("vault id: %s", wllt.VaultID.String())
-				common.Log.Debugf("key id: %s", wllt.KeyID.String())
-				key, err := vault.DeriveKey(util.DefaultVaultAccessJWT, wllt.VaultID.String(), wllt.KeyID.String(), map[string]interface{}{})
+				// we have no path, so derive a key using the default path
+				address, err := deriveHDWalletAddress(wllt, nil)
 				if err != nil {
 					err := fmt.Errorf("unable to generate key material for HD wallet; %s", err.Error())
 					common.Log.Warning(err.Error())
 					provide.RenderError(err.Error(), 500, c)
 					return
 				}
-				execution.AccountAddress = key.Address
-				common.Log.Debugf("xxx using address: %s, derived from wallet using DEFAULT path", *key.Address)
+				execution.AccountAddress = address
+				common.Log.Debugf("xxx using address: %s, derived from wallet using DEFAULT path", *address)
 			}
 		}
 
 	}
 	gas, gasOk := params["gas"].(float64)
 	gasPrice, gasPriceOk := params["gas_price"].(float64)
+	maxFeePerGas, maxFeePerGasOk := params["max_fee_per_gas"].(string)
+	maxPriorityFeePerGas, maxPriorityFeePerGasOk := params["max_priority_fee_per_gas"].(string)
+	txType, txTypeOk := params["type"].(float64)
 	nonce, nonceOk := params["nonce"].(float64)
 	subsidize, subsidizeOk := params["subsidize"].(bool)
 
@@ -586,6 +933,21 @@ func contractExecutionHandler(c *gin.Context) {
 		execution.GasPrice = &gasPrice
 	}
 
+	if maxFeePerGasOk {
+		_maxFeePerGas := json.Number(maxFeePerGas)
+		execution.MaxFeePerGas = &_maxFeePerGas
+	}
+
+	if maxPriorityFeePerGasOk {
+		_maxPriorityFeePerGas := json.Number(maxPriorityFeePerGas)
+		execution.MaxPriorityFeePerGas = &_maxPriorityFeePerGas
+	}
+
+	if txTypeOk {
+		txTypeUint := uint8(txType)
+		execution.Type = &txTypeUint
+	}
+
 	if nonceOk {
 		nonceUint := uint64(nonce)
 		execution.Nonce = &nonceUint
@@ -595,6 +957,18 @@ func contractExecutionHandler(c *gin.Context) {
 		execution.Subsidize = subsidize
 	}
 
+	if execution.DryRun {
+		simulation, err := simulateExecution(contractObj, execution)
+		if err != nil {
+			common.Log.Debugf("transaction simulation failed; %s", err.Error())
+			provide.RenderError(err.Error(), 422, c)
+			return
+		}
+
+		provide.Render(simulation, 200, c)
+		return
+	}
+
 	executionResponse, err := executeTransaction(contractObj, execution)
 	if err != nil {
 		common.Log.Debugf("transaction execution failed; %s", err.Error())
@@ -612,7 +986,7 @@ func contractExecutionHandler(c *gin.Context) {
 			"response":   executionResponse.Response,
 		}
 
-		provide.Render(resp, 200, c)
+		idempotentReq.render(c, resp, 200)
 		return
 	}
 
@@ -620,7 +994,136 @@ func contractExecutionHandler(c *gin.Context) {
 		"confidence": confidence,
 		"ref":        executionResponse.Ref,
 	}
-	provide.Render(resp, 202, c)
+	idempotentReq.render(c, resp, 202)
+}
+
+func contractExecutionBatchHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	userID := util.AuthorizedSubjectID(c, "user")
+	if appID == nil && orgID == nil && userID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	req, err := parseBatchRequest(buf)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	contractObj := resolveExecutionContract(c, db, c.Param("id"), appID, orgID, userID)
+	if contractObj == nil {
+		return
+	}
+
+	batch := NewBatch(req.Multicall)
+	batch.ApplicationID = contractObj.ApplicationID
+	batch.OrganizationID = contractObj.OrganizationID
+	batch.UserID = userID
+	batch.ContractID = &contractObj.ID
+
+	executions := make([]*contract.Execution, 0)
+	for i, item := range req.Items {
+		ref, _ := uuid.NewV4()
+		execution := &contract.Execution{
+			Ref: common.StringOrNil(ref.String()),
+		}
+		err = json.Unmarshal(item, &execution)
+		if err != nil {
+			batch.itemError(i, err.Error())
+			continue
+		}
+
+		if execution.Account != nil || execution.Wallet != nil || execution.AccountAddress != nil {
+			batch.itemError(i, "batched executions must be signed using an account_id or wallet_id")
+			continue
+		}
+
+		execution.Contract = contractObj
+		execution.ContractID = &contractObj.ID
+		executions = append(executions, execution)
+	}
+
+	if len(batch.Errors) == 0 {
+		batch.addExecutions(contractObj, executions)
+	}
+
+	if len(batch.Errors) > 0 {
+		obj := map[string]interface{}{}
+		obj["errors"] = batch.Errors
+		provide.Render(obj, 422, c)
+		return
+	}
+
+	idempotentReq, handled := beginIdempotentRequest(c, appID, orgID, userID, buf)
+	if handled {
+		return
+	}
+	defer idempotentReq.finish()
+
+	if batch.Create(db) {
+		idempotentReq.render(c, batch, 201)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = batch.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+// resolveExecutionContract resolves the contract with the given id or address on behalf of the
+// authorized application, organization or user; an error is rendered if it cannot be resolved
+func resolveExecutionContract(c *gin.Context, db *gorm.DB, contractID string, appID, orgID, userID *uuid.UUID) *contract.Contract {
+	var contractObj = &contract.Contract{}
+
+	db.Where("id = ?", contractID).Find(&contractObj)
+
+	// if we can't find by ID, attempt to lookup the contract by address
+	// ensure that the contract returned is the valid ID for the provided token data
+	if contractObj == nil || contractObj.ID == uuid.Nil {
+		query := db.Where("address = ?", contractID)
+		if appID != nil {
+			query = query.Where("contracts.application_id = ?", appID)
+		}
+		if orgID != nil {
+			query = query.Where("contracts.organization_id = ?", orgID)
+		}
+		if userID != nil {
+			query = query.Where("contracts.application_id IS NULL", userID)
+		}
+		query.Find(&contractObj)
+	}
+
+	if contractObj == nil || contractObj.ID == uuid.Nil {
+		//if appID != nil {
+		provide.RenderError("contract not found", 404, c)
+		return nil
+		//}
+
+		// common.Log.Debugf("Attempting arbitrary, non-permissioned contract execution on behalf of user with id: %s", userID)
+		// contractArbitraryExecutionHandler(c, db, buf)
+		// return
+	}
+
+	if appID != nil && contractObj.ApplicationID != nil && *contractObj.ApplicationID != *appID {
+		provide.RenderError("forbidden", 403, c)
+		return nil
+	}
+
+	if orgID != nil && contractObj.OrganizationID != nil && *contractObj.OrganizationID != *orgID {
+		provide.RenderError("forbidden", 403, c)
+		return nil
+	}
+
+	return contractObj
 }
 
 func invokeTxFilters(applicationID *uuid.UUID, payload []byte, db *gorm.DB) *float64 {
@@ -0,0 +1,188 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tx
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	redisutil "github.com/kthomas/go-redisutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	provide "github.com/provideplatform/provide-go/common"
+)
+
+const idempotencyKeyHeader = "Idempotency-Key"
+
+// idempotencyKeyTTL is the amount of time the response to an idempotent request is retained
+const idempotencyKeyTTL = time.Hour * 24
+
+// idempotencyKeyInFlightTTL is the amount of time after which an idempotent request which
+// never completed (i.e., the process handling it died) is considered abandoned
+const idempotencyKeyInFlightTTL = time.Minute * 5
+
+const idempotentRequestStatusInFlight = "in_flight"
+const idempotentRequestStatusCompleted = "completed"
+const idempotentRequestStatusFailed = "failed"
+
+// idempotentRequest is the cached state of a request made using an idempotency key
+type idempotentRequest struct {
+	RequestHash string           `json:"request_hash"`
+	Status      string           `json:"status"`
+	StatusCode  int              `json:"status_code,omitempty"`
+	Response    *json.RawMessage `json:"response,omitempty"`
+
+	key string
+}
+
+// IdempotencyKey returns the key for the given subject, route and client-provided idempotency key;
+// the key represents the namespace where the state of the idempotent request is cached
+func IdempotencyKey(subject, route, key string) string {
+	return fmt.Sprintf("idempotency.%s.%s.%s", subject, strings.Trim(route, "/"), key)
+}
+
+// IdempotencyMutexKey returns the key of the distributed lock used to begin an idempotent request
+func IdempotencyMutexKey(subject, route, key string) string {
+	return fmt.Sprintf("%s.mutex", IdempotencyKey(subject, route, key))
+}
+
+// idempotencySubject returns the authorized subject to which idempotency keys are scoped
+func idempotencySubject(appID, orgID, userID *uuid.UUID) string {
+	if appID != nil {
+		return fmt.Sprintf("application.%s", appID.String())
+	} else if orgID != nil {
+		return fmt.Sprintf("organization.%s", orgID.String())
+	}
+	return fmt.Sprintf("user.%s", userID.String())
+}
+
+// beginIdempotentRequest resolves the idempotency key from the Idempotency-Key header and marks the
+// request in-flight. If a completed request was previously made using the key and the same body, its
+// response is rendered and handled is true; a request made using the key and a different body (even
+// if the original request failed), or while the original request is in-flight, is rejected with 409. If no idempotency key is provided,
+// nil is returned and the request should be handled as usual; the ref of a tx is not used as an
+// idempotency key, as refs are not required to be unique.
+func beginIdempotentRequest(c *gin.Context, appID, orgID, userID *uuid.UUID, body []byte) (req *idempotentRequest, handled bool) {
+	key := common.StringOrNil(c.GetHeader(idempotencyKeyHeader))
+	if key == nil {
+		return nil, false
+	}
+
+	digest := sha256.Sum256(body)
+	requestHash := hex.EncodeToString(digest[:])
+
+	subject := idempotencySubject(appID, orgID, userID)
+	route := c.Request.URL.Path
+
+	var previous *idempotentRequest
+	err := redisutil.WithRedlock(IdempotencyMutexKey(subject, route, *key), func() error {
+		previous = readIdempotentRequest(IdempotencyKey(subject, route, *key))
+		if previous != nil && (previous.Status != idempotentRequestStatusFailed || previous.RequestHash != requestHash) {
+			return nil
+		}
+
+		previous = nil
+		req = &idempotentRequest{
+			RequestHash: requestHash,
+			Status:      idempotentRequestStatusInFlight,
+			key:         IdempotencyKey(subject, route, *key),
+		}
+		return req.write(idempotencyKeyInFlightTTL)
+	})
+
+	if err != nil {
+		common.Log.Warningf("failed to begin idempotent request using idempotency key: %s; %s", *key, err.Error())
+		provide.RenderError("failed to resolve idempotency key", 500, c)
+		return nil, true
+	}
+
+	if previous != nil {
+		if previous.RequestHash != requestHash {
+			provide.RenderError("idempotency key was previously used with a different request body", 409, c)
+		} else if previous.Status == idempotentRequestStatusInFlight {
+			provide.RenderError("request using idempotency key is in progress", 409, c)
+		} else {
+			common.Log.Debugf("replaying response to idempotent request using idempotency key: %s", *key)
+			c.Header("Idempotent-Replayed", "true")
+			provide.Render(previous.Response, previous.StatusCode, c)
+		}
+		return nil, true
+	}
+
+	return req, false
+}
+
+// readIdempotentRequest returns the cached idempotent request for the given key, or nil if none is cached
+func readIdempotentRequest(key string) *idempotentRequest {
+	raw, err := redisutil.Get(key)
+	if err != nil || raw == nil {
+		return nil
+	}
+
+	var req *idempotentRequest
+	err = json.Unmarshal([]byte(*raw), &req)
+	if err != nil {
+		common.Log.Warningf("failed to unmarshal cached idempotent request from key: %s; %s", key, err.Error())
+		return nil
+	}
+
+	return req
+}
+
+// write caches the idempotent request using the given ttl
+func (r *idempotentRequest) write(ttl time.Duration) error {
+	payload, _ := json.Marshal(r)
+	return redisutil.Set(r.key, string(payload), &ttl)
+}
+
+// render renders the given response and, if the request was made using an idempotency key,
+// caches the response so it can be replayed by subsequent requests using the key
+func (r *idempotentRequest) render(c *gin.Context, obj interface{}, status int) {
+	if r != nil {
+		response, err := json.Marshal(obj)
+		if err == nil {
+			rawResponse := json.RawMessage(response)
+			r.Status = idempotentRequestStatusCompleted
+			r.StatusCode = status
+			r.Response = &rawResponse
+			err = r.write(idempotencyKeyTTL)
+		}
+		if err != nil {
+			common.Log.Warningf("failed to cache response to idempotent request; %s", err.Error())
+		}
+	}
+
+	provide.Render(obj, status, c)
+}
+
+// finish releases the idempotency key of a request which did not complete, so the request can be retried
+func (r *idempotentRequest) finish() {
+	if r == nil || r.Status == idempotentRequestStatusCompleted {
+		return
+	}
+
+	r.Status = idempotentRequestStatusFailed
+	err := r.write(idempotencyKeyTTL)
+	if err != nil {
+		common.Log.Warningf("failed to release idempotency key; %s", err.Error())
+	}
+}
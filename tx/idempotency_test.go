@@ -0,0 +1,137 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/internal/testutil"
+)
+
+func idempotencyTestContext(key string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/api/v1/transactions", nil)
+	if key != "" {
+		c.Request.Header.Set(idempotencyKeyHeader, key)
+	}
+	return c, recorder
+}
+
+func TestBeginIdempotentRequestWithoutKey(t *testing.T) {
+	appID, _ := uuid.NewV4()
+	c, _ := idempotencyTestContext("")
+
+	req, handled := beginIdempotentRequest(c, &appID, nil, nil, []byte(`{"ref":"invoice-1"}`))
+	if req != nil || handled {
+		t.Error("expected request without an idempotency key header to be handled as usual")
+	}
+}
+
+func TestBeginIdempotentRequestReplaysCompletedResponse(t *testing.T) {
+	testutil.NewMockRedis(t)
+	appID, _ := uuid.NewV4()
+	body := []byte(`{"ref":"invoice-1"}`)
+
+	c, _ := idempotencyTestContext("key-1")
+	req, handled := beginIdempotentRequest(c, &appID, nil, nil, body)
+	if req == nil || handled {
+		t.Fatal("expected first request using the idempotency key to be handled")
+	}
+	req.render(c, map[string]interface{}{"id": "tx-1"}, 201)
+	req.finish()
+
+	c, recorder := idempotencyTestContext("key-1")
+	req, handled = beginIdempotentRequest(c, &appID, nil, nil, body)
+	if req != nil || !handled {
+		t.Fatal("expected repeated request using the idempotency key to be replayed")
+	}
+	if recorder.Code != 201 || recorder.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("replayed response status %d; expected 201", recorder.Code)
+	}
+}
+
+func TestBeginIdempotentRequestRejectsDifferentBody(t *testing.T) {
+	testutil.NewMockRedis(t)
+	appID, _ := uuid.NewV4()
+
+	c, _ := idempotencyTestContext("key-1")
+	req, _ := beginIdempotentRequest(c, &appID, nil, nil, []byte(`{"ref":"invoice-1"}`))
+	req.render(c, map[string]interface{}{"id": "tx-1"}, 201)
+
+	c, recorder := idempotencyTestContext("key-1")
+	_, handled := beginIdempotentRequest(c, &appID, nil, nil, []byte(`{"ref":"invoice-2"}`))
+	if !handled || recorder.Code != 409 {
+		t.Errorf("expected reuse of the idempotency key with a different body to be rejected; status %d", recorder.Code)
+	}
+}
+
+func TestBeginIdempotentRequestRetriesFailedRequest(t *testing.T) {
+	testutil.NewMockRedis(t)
+	appID, _ := uuid.NewV4()
+	body := []byte(`{"ref":"invoice-1"}`)
+
+	c, _ := idempotencyTestContext("key-1")
+	req, _ := beginIdempotentRequest(c, &appID, nil, nil, body)
+	req.finish() // the request did not complete
+
+	c, _ = idempotencyTestContext("key-1")
+	req, handled := beginIdempotentRequest(c, &appID, nil, nil, body)
+	if req == nil || handled {
+		t.Error("expected request using the idempotency key of a failed request to be retried")
+	}
+}
+
+func TestBeginIdempotentRequestRejectsDifferentBodyForFailedRequest(t *testing.T) {
+	testutil.NewMockRedis(t)
+	appID, _ := uuid.NewV4()
+
+	c, _ := idempotencyTestContext("key-1")
+	req, _ := beginIdempotentRequest(c, &appID, nil, nil, []byte(`{"ref":"invoice-1"}`))
+	req.finish() // the request did not complete
+
+	c, recorder := idempotencyTestContext("key-1")
+	req, handled := beginIdempotentRequest(c, &appID, nil, nil, []byte(`{"ref":"invoice-2"}`))
+	if req != nil || !handled || recorder.Code != 409 {
+		t.Errorf("expected reuse of the idempotency key of a failed request with a different body to be rejected; status %d", recorder.Code)
+	}
+}
+
+func TestBeginIdempotentRequestScopedToSubject(t *testing.T) {
+	testutil.NewMockRedis(t)
+	appID, _ := uuid.NewV4()
+	otherAppID, _ := uuid.NewV4()
+	body := []byte(`{}`)
+
+	c, _ := idempotencyTestContext("key-1")
+	req, _ := beginIdempotentRequest(c, &appID, nil, nil, body)
+	req.render(c, map[string]interface{}{}, 201)
+
+	c, _ = idempotencyTestContext("key-1")
+	req, handled := beginIdempotentRequest(c, &otherAppID, nil, nil, body)
+	if req == nil || handled {
+		t.Error("expected idempotency key to be scoped to the authorized subject")
+	}
+}
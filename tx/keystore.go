@@ -0,0 +1,100 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tx
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/provideplatform/nchain/common"
+)
+
+// digestSigner holds the key material of the account or HD wallet of a TransactionSigner
+// and signs tx digests on its behalf; implemented using vault by TransactionSigner and using
+// the local keystore by KeystoreSigner
+type digestSigner interface {
+	signerDetails() (address, derivationPath *string, err error)
+	signAccountDigest(digest []byte) ([]byte, error)
+	signWalletDigest(derivationPath *string, digest []byte) ([]byte, error)
+}
+
+// KeystoreSigner is an account or HD wallet with key material held in the local, encrypted
+// keystore rather than vault; falls back to vault for key material not held in the keystore.
+// Implements the Signer interface
+type KeystoreSigner struct {
+	*TransactionSigner
+}
+
+// Sign implements the Signer interface
+func (s *KeystoreSigner) Sign(tx *Transaction) (signedTx interface{}, hash []byte, err error) {
+	return s.sign(tx, s)
+}
+
+// GetSignerDetails gets the address and derivation path used for wallet signing
+func (s *KeystoreSigner) GetSignerDetails() (address, derivationPath *string, err error) {
+	return s.signerDetails()
+}
+
+// signerDetails derives the address and derivation path used for wallet signing from the
+// mnemonic held in the local keystore
+func (s *KeystoreSigner) signerDetails() (address, derivationPath *string, err error) {
+	if s.Wallet != nil {
+		if !s.Wallet.UsesKeystore() {
+			return s.TransactionSigner.signerDetails()
+		}
+
+		_, addr, path, err := s.Wallet.KeystoreDerive(s.Wallet.Path)
+		if err != nil {
+			err := fmt.Errorf("unable to derive key material for HD wallet; %s", err.Error())
+			common.Log.Warning(err.Error())
+			return nil, nil, err
+		}
+
+		common.Log.Debugf("address derived from local keystore using derivation path %s: %s", path, addr)
+		return &addr, &path, nil
+	} else if s.Sender != nil && s.Signature != nil {
+		return s.Sender, nil, nil
+	}
+
+	return nil, nil, nil
+}
+
+// signAccountDigest signs the given digest using the account key held in the local keystore
+func (s *KeystoreSigner) signAccountDigest(digest []byte) ([]byte, error) {
+	if s.Account == nil {
+		return nil, errors.New("no account to sign digest")
+	} else if !s.Account.UsesKeystore() {
+		return s.TransactionSigner.signAccountDigest(digest)
+	}
+	return s.Account.KeystoreSign(digest)
+}
+
+// signWalletDigest signs the given digest using the HD wallet key derived at the given
+// derivation path from the mnemonic held in the local keystore
+func (s *KeystoreSigner) signWalletDigest(derivationPath *string, digest []byte) ([]byte, error) {
+	if s.Wallet == nil {
+		return nil, errors.New("no HD wallet to sign digest")
+	} else if !s.Wallet.UsesKeystore() {
+		return s.TransactionSigner.signWalletDigest(derivationPath, digest)
+	}
+	return s.Wallet.KeystoreSign(derivationPath, digest)
+}
+
+// String prints a description of the keystore signer
+func (s *KeystoreSigner) String() string {
+	return fmt.Sprintf("%s (keystore)", s.TransactionSigner.String())
+}
@@ -0,0 +1,136 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tx
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/wallet"
+)
+
+const testKeystoreMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+// testKeystoreAddress is the address at m/44'/60'/0'/0/0 of testKeystoreMnemonic
+const testKeystoreAddress = "0x9858EfFD232B4033E47d90003D41EC34EcaEda94"
+
+// keystoreWallet returns an HD wallet whose mnemonic is held in the local keystore
+func keystoreWallet(t *testing.T) *wallet.Wallet {
+	passphrase := common.KeystorePassphrase
+	common.KeystorePassphrase = "test passphrase"
+	t.Cleanup(func() {
+		common.KeystorePassphrase = passphrase
+	})
+
+	cryptoJSON, err := keystore.EncryptDataV3([]byte(testKeystoreMnemonic), []byte(common.KeystorePassphrase), keystore.LightScryptN, keystore.LightScryptP)
+	if err != nil {
+		t.Fatalf("failed to encrypt keystore; %s", err.Error())
+	}
+	raw, _ := json.Marshal(map[string]interface{}{
+		"crypto":  cryptoJSON,
+		"id":      "keystore",
+		"version": 3,
+	})
+	keystoreJSON := json.RawMessage(raw)
+
+	wllt := &wallet.Wallet{Keystore: &keystoreJSON}
+	wllt.ID, _ = uuid.NewV4()
+	return wllt
+}
+
+func TestSignerUsesKeystoreSignerForKeystoreAccount(t *testing.T) {
+	keystoreJSON := json.RawMessage(`{}`)
+	txs := &TransactionSigner{
+		Account: &wallet.Account{Keystore: &keystoreJSON},
+	}
+
+	if _, ok := txs.signer().(*KeystoreSigner); !ok {
+		t.Errorf("expected account with key material held in the local keystore to be signed by KeystoreSigner; got %T", txs.signer())
+	}
+}
+
+func TestSignerUsesKeystoreSignerForKeystoreWallet(t *testing.T) {
+	keystoreJSON := json.RawMessage(`{}`)
+	txs := &TransactionSigner{
+		Wallet: &wallet.Wallet{Keystore: &keystoreJSON},
+	}
+
+	if _, ok := txs.signer().(*KeystoreSigner); !ok {
+		t.Errorf("expected HD wallet with key material held in the local keystore to be signed by KeystoreSigner; got %T", txs.signer())
+	}
+}
+
+func TestSignerUsesVaultForVaultAccount(t *testing.T) {
+	txs := &TransactionSigner{
+		Account: &wallet.Account{},
+	}
+
+	if signer, ok := txs.signer().(*TransactionSigner); !ok || signer != txs {
+		t.Errorf("expected account with key material held in vault to be signed by TransactionSigner; got %T", txs.signer())
+	}
+}
+
+func TestVaultDigestSignerRejectsKeystoreKeyMaterial(t *testing.T) {
+	keystoreJSON := json.RawMessage(`{}`)
+	txs := &TransactionSigner{
+		Account: &wallet.Account{Keystore: &keystoreJSON},
+		Wallet:  &wallet.Wallet{Keystore: &keystoreJSON},
+	}
+
+	if _, err := txs.signAccountDigest(make([]byte, 32)); err == nil {
+		t.Error("expected vault to not sign using account key material held in the local keystore")
+	}
+	if _, err := txs.signWalletDigest(nil, make([]byte, 32)); err == nil {
+		t.Error("expected vault to not sign using HD wallet key material held in the local keystore")
+	}
+}
+
+func TestDeriveHDWalletAddressUsesKeystoreForKeystoreWallet(t *testing.T) {
+	wllt := keystoreWallet(t)
+
+	address, err := deriveHDWalletAddress(wllt, common.StringOrNil(wallet.DefaultHDDerivationPath))
+	if err != nil {
+		t.Fatalf("failed to derive address of keystore wallet; %s", err.Error())
+	}
+	if !strings.EqualFold(*address, testKeystoreAddress) {
+		t.Errorf("expected address %s; got %s", testKeystoreAddress, *address)
+	}
+
+	address, err = deriveHDWalletAddress(wllt, nil)
+	if err != nil {
+		t.Fatalf("failed to derive address of keystore wallet using the default path; %s", err.Error())
+	}
+	if !strings.EqualFold(*address, testKeystoreAddress) {
+		t.Errorf("expected address %s at the default path; got %s", testKeystoreAddress, *address)
+	}
+}
+
+func TestDeriveHDWalletAddressRequiresVaultKeyForVaultWallet(t *testing.T) {
+	wllt := &wallet.Wallet{}
+	wllt.ID, _ = uuid.NewV4()
+
+	if _, err := deriveHDWalletAddress(wllt, nil); err == nil {
+		t.Error("expected address derivation of HD wallet without vault key material to fail")
+	}
+}
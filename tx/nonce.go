@@ -0,0 +1,349 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tx
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	redisutil "github.com/kthomas/go-redisutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/network"
+)
+
+// nonceDriftTimeout is the amount of time after which a cached nonce which is ahead of the
+// pending nonce reported by the network is considered stale (i.e., nonces were reserved but
+// never broadcast) and is resynchronized with the network; the timeout is measured from the
+// last time the pending nonce reported by the network advanced
+const nonceDriftTimeout = time.Minute * 2
+
+// nonceTTL is the amount of time a cached nonce is retained without being reserved
+const nonceTTL = time.Hour * 24
+
+// nonceState is the cached nonce state for a single signer on a single network
+type nonceState struct {
+	Next      uint64    `json:"next"`               // the next never-before-reserved nonce
+	Released  []uint64  `json:"released,omitempty"` // nonces which were reserved but never broadcast; these gaps are filled first
+	Pending   uint64    `json:"pending"`            // the pending nonce most recently reported by the network
+	SyncedAt  time.Time `json:"synced_at"`          // the time at which the pending nonce reported by the network last advanced
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// nonceReservation is a nonce reserved on behalf of a signer; the reservation
+// is released if the tx it was reserved for is never broadcast
+type nonceReservation struct {
+	networkID uuid.UUID
+	address   string
+	nonce     uint64
+}
+
+// NonceKey returns the key for the given network id and signer address, which is guaranteed to be
+// unique-per-signer-per-network; the nonce key represents the namespace where the nonce state
+// for the signer is cached
+func NonceKey(networkID uuid.UUID, address string) string {
+	return fmt.Sprintf("network.%s.signer.%s.nonce", networkID.String(), strings.ToLower(address))
+}
+
+// NonceMutexKey returns a key for the given network id and signer address, which represents
+// the distributed lock used to reserve and release nonces for the signer
+func NonceMutexKey(networkID uuid.UUID, address string) string {
+	return fmt.Sprintf("%s.mutex", NonceKey(networkID, address))
+}
+
+// readNonceState returns the cached nonce state for the given key, or nil if no state is cached
+func readNonceState(key string) *nonceState {
+	rawstate, err := redisutil.Get(key)
+	if err != nil || rawstate == nil {
+		return nil
+	}
+
+	var state *nonceState
+	err = json.Unmarshal([]byte(*rawstate), &state)
+	if err != nil {
+		common.Log.Warningf("failed to unmarshal cached nonce state from key: %s; %s", key, err.Error())
+		return nil
+	}
+
+	return state
+}
+
+// writeNonceState caches the given nonce state at the given key
+func writeNonceState(key string, state *nonceState) error {
+	payload, _ := json.Marshal(state)
+	ttl := nonceTTL
+	return redisutil.Set(key, string(payload), &ttl)
+}
+
+// pendingNonceAt returns the pending nonce for the given address, as reported by the network
+// (i.e., eth_getTransactionCount using the "pending" block tag, or the account sequence on
+// baseledger networks)
+func pendingNonceAt(ntwrk *network.Network, address string) (uint64, error) {
+	if ntwrk.IsBaseledgerNetwork() {
+		_, sequence, err := ntwrk.BaseledgerAccount(address)
+		if err != nil {
+			return 0, fmt.Errorf("failed to resolve account sequence for signer: %s; %s", address, err.Error())
+		}
+		return sequence, nil
+	}
+
+	client, err := ntwrk.EVMDialJSONRPC()
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve pending nonce for signer: %s; %s", address, err.Error())
+	}
+
+	nonce, err := client.PendingNonceAt(context.TODO(), ethcommon.HexToAddress(address))
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve pending nonce for signer: %s; %s", address, err.Error())
+	}
+
+	return nonce, nil
+}
+
+// reserve returns the next nonce from the given state, resynchronizing it with
+// the given network pending nonce when it has drifted; the state is updated in-place
+func (s *nonceState) reserve(pending uint64) uint64 {
+	if pending != s.Pending || s.SyncedAt.IsZero() {
+		s.Pending = pending
+		s.SyncedAt = time.Now()
+	}
+
+	if pending > s.Next || (s.Next > pending && time.Since(s.SyncedAt) > nonceDriftTimeout) {
+		// the network is ahead of us (i.e., txs were broadcast out-of-band), or reserved
+		// nonces were abandoned without ever being broadcast or released, in which case
+		// the pending nonce has not advanced since the drift timeout elapsed
+		common.Log.Debugf("resynchronizing nonce %d with pending network nonce %d", s.Next, pending)
+		s.Next = pending
+		s.Released = make([]uint64, 0)
+		s.SyncedAt = time.Now()
+	}
+
+	released := make([]uint64, 0)
+	for _, nonce := range s.Released {
+		if nonce >= pending && nonce < s.Next {
+			released = append(released, nonce)
+		}
+	}
+	sort.Slice(released, func(i, j int) bool { return released[i] < released[j] })
+
+	var nonce uint64
+	if len(released) > 0 {
+		nonce = released[0]
+		s.Released = released[1:]
+	} else {
+		nonce = s.Next
+		s.Next++
+		s.Released = released
+	}
+
+	s.UpdatedAt = time.Now()
+	return nonce
+}
+
+// release returns the given nonce to the given state so it can be reserved again
+func (s *nonceState) release(nonce uint64) {
+	if nonce >= s.Next {
+		return
+	}
+
+	if nonce+1 == s.Next {
+		s.Next--
+	} else {
+		for _, released := range s.Released {
+			if released == nonce {
+				return
+			}
+		}
+		s.Released = append(s.Released, nonce)
+	}
+
+	s.UpdatedAt = time.Now()
+}
+
+// reserveNonce atomically reserves the next nonce for the given signer address on the given network
+func reserveNonce(ntwrk *network.Network, address string) (*nonceReservation, error) {
+	var reservation *nonceReservation
+
+	err := redisutil.WithRedlock(NonceMutexKey(ntwrk.ID, address), func() error {
+		pending, err := pendingNonceAt(ntwrk, address)
+		if err != nil {
+			return err
+		}
+
+		key := NonceKey(ntwrk.ID, address)
+		state := readNonceState(key)
+		if state == nil {
+			state = &nonceState{Next: pending, Pending: pending, SyncedAt: time.Now()}
+		}
+
+		nonce := state.reserve(pending)
+		err = writeNonceState(key, state)
+		if err != nil {
+			return fmt.Errorf("failed to cache nonce state for signer: %s; %s", address, err.Error())
+		}
+
+		reservation = &nonceReservation{
+			networkID: ntwrk.ID,
+			address:   address,
+			nonce:     nonce,
+		}
+
+		common.Log.Debugf("reserved nonce %d for signer %s on network: %s", nonce, address, ntwrk.ID)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return reservation, nil
+}
+
+// reserveNonces atomically reserves the given number of nonces, in ascending order, for the given
+// signer address on the given network; no other nonce is reserved for the signer until all of the
+// nonces are reserved, so txs using the nonces are ordered as the reservations are
+func reserveNonces(ntwrk *network.Network, address string, count int) ([]*nonceReservation, error) {
+	reservations := make([]*nonceReservation, 0)
+
+	err := redisutil.WithRedlock(NonceMutexKey(ntwrk.ID, address), func() error {
+		pending, err := pendingNonceAt(ntwrk, address)
+		if err != nil {
+			return err
+		}
+
+		key := NonceKey(ntwrk.ID, address)
+		state := readNonceState(key)
+		if state == nil {
+			state = &nonceState{Next: pending, Pending: pending, SyncedAt: time.Now()}
+		}
+
+		for i := 0; i < count; i++ {
+			reservations = append(reservations, &nonceReservation{
+				networkID: ntwrk.ID,
+				address:   address,
+				nonce:     state.reserve(pending),
+			})
+		}
+
+		err = writeNonceState(key, state)
+		if err != nil {
+			return fmt.Errorf("failed to cache nonce state for signer: %s; %s", address, err.Error())
+		}
+
+		common.Log.Debugf("reserved %d nonce(s) for signer %s on network: %s", count, address, ntwrk.ID)
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return reservations, nil
+}
+
+// rejectedBroadcastErrors are the errors returned when a signed tx is definitively rejected by the
+// network (i.e., it was never accepted into the mempool); any other broadcast error is ambiguous
+// (i.e., a timeout), as the tx may have been accepted regardless
+var rejectedBroadcastErrors = []string{
+	"exceeds block gas limit",
+	"fee cap less than block base fee",
+	"gas limit reached",
+	"insufficient funds",
+	"intrinsic gas too low",
+	"invalid sender",
+	"max fee per gas less than block base fee",
+	"max priority fee per gas higher than max fee per gas",
+	"negative value",
+	"oversized data",
+	"signed tx rejected with code",
+	"transaction underpriced",
+	"tx fee exceeds",
+	"typecast failed",
+	"unsupported network",
+}
+
+// staleNonceBroadcastErrors are the errors returned when the nonce of a signed tx is already used
+// by a mined or pending tx; a replacement which is underpriced is rejected because a pending tx
+// occupies the nonce, so the nonce must not be released for reuse
+var staleNonceBroadcastErrors = []string{
+	"account sequence mismatch",
+	"nonce too low",
+	"replacement transaction underpriced",
+}
+
+// isStaleNonceBroadcast returns true if the given broadcast error indicates the nonce of the signed
+// tx is already taken, in which case the cached nonce state must be resynchronized with the network
+func isStaleNonceBroadcast(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, stale := range staleNonceBroadcastErrors {
+		if strings.Contains(msg, stale) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isRejectedBroadcast returns true if the given broadcast error is a definitive rejection of the
+// signed tx, in which case the nonce reserved for the tx can be safely released
+func isRejectedBroadcast(err error) bool {
+	if err == nil || isStaleNonceBroadcast(err) {
+		return false
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, rejection := range rejectedBroadcastErrors {
+		if strings.Contains(msg, rejection) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// release the reserved nonce so the resulting gap is filled by a subsequent reservation
+func (r *nonceReservation) release() error {
+	return redisutil.WithRedlock(NonceMutexKey(r.networkID, r.address), func() error {
+		key := NonceKey(r.networkID, r.address)
+		state := readNonceState(key)
+		if state == nil {
+			return nil
+		}
+
+		state.release(r.nonce)
+		common.Log.Debugf("released nonce %d for signer %s on network: %s", r.nonce, r.address, r.networkID)
+		return writeNonceState(key, state)
+	})
+}
+
+// invalidate the cached nonce state for the signer of the reserved nonce, forcing it to be
+// resynchronized with the network on the next reservation
+func (r *nonceReservation) invalidate() error {
+	return redisutil.WithRedlock(NonceMutexKey(r.networkID, r.address), func() error {
+		common.Log.Debugf("invalidating cached nonce state for signer %s on network: %s", r.address, r.networkID)
+		return writeNonceState(NonceKey(r.networkID, r.address), &nonceState{})
+	})
+}
@@ -0,0 +1,160 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tx
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNonceStateReserveSequential(t *testing.T) {
+	state := &nonceState{Next: 7}
+	for i := uint64(7); i < 10; i++ {
+		if nonce := state.reserve(7); nonce != i {
+			t.Errorf("reserve() returned nonce %d; expected %d", nonce, i)
+		}
+	}
+	if state.Next != 10 {
+		t.Errorf("expected next nonce 10; got %d", state.Next)
+	}
+}
+
+func TestNonceStateReleaseFillsGap(t *testing.T) {
+	state := &nonceState{Next: 0}
+	for i := 0; i < 3; i++ {
+		state.reserve(0)
+	}
+
+	state.release(1)
+	if nonce := state.reserve(0); nonce != 1 {
+		t.Errorf("expected released nonce 1 to be reserved; got %d", nonce)
+	}
+
+	state.release(2)
+	if state.Next != 2 {
+		t.Errorf("expected release of the latest nonce to rewind next nonce to 2; got %d", state.Next)
+	}
+
+	state.release(5)
+	if state.Next != 2 || len(state.Released) != 0 {
+		t.Errorf("expected release of a never-reserved nonce to be ignored; got %+v", state)
+	}
+}
+
+func TestNonceStateReleaseIsIdempotent(t *testing.T) {
+	state := &nonceState{Next: 5}
+	state.release(2)
+	state.release(2)
+	if len(state.Released) != 1 {
+		t.Errorf("expected nonce 2 to be released once; got %v", state.Released)
+	}
+}
+
+func TestNonceStateResyncWhenNetworkAhead(t *testing.T) {
+	state := &nonceState{Next: 3, Released: []uint64{1}, Pending: 1, SyncedAt: time.Now()}
+	if nonce := state.reserve(8); nonce != 8 {
+		t.Errorf("expected nonce to be resynchronized with pending network nonce 8; got %d", nonce)
+	}
+	if len(state.Released) != 0 {
+		t.Errorf("expected released nonces to be discarded on resync; got %v", state.Released)
+	}
+}
+
+func TestNonceStateReleasedBelowPendingDiscarded(t *testing.T) {
+	state := &nonceState{Next: 10, Released: []uint64{2, 6}, Pending: 5, SyncedAt: time.Now()}
+	if nonce := state.reserve(5); nonce != 6 {
+		t.Errorf("expected released nonce 6 to be reserved; got %d", nonce)
+	}
+	if len(state.Released) != 0 {
+		t.Errorf("expected released nonce 2 (below pending) to be discarded; got %v", state.Released)
+	}
+}
+
+func TestNonceStateResyncAfterDriftUnderLoad(t *testing.T) {
+	// nonces 5-9 were reserved but never broadcast, and reservations continue to refresh the
+	// state; the network pending nonce has not advanced since the drift timeout elapsed
+	state := &nonceState{
+		Next:      10,
+		Pending:   5,
+		SyncedAt:  time.Now().Add(-nonceDriftTimeout * 2),
+		UpdatedAt: time.Now(),
+	}
+	if nonce := state.reserve(5); nonce != 5 {
+		t.Errorf("expected drifted nonce to be resynchronized with pending network nonce 5; got %d", nonce)
+	}
+}
+
+func TestNonceStateNoResyncWhilePendingAdvances(t *testing.T) {
+	state := &nonceState{
+		Next:     10,
+		Pending:  5,
+		SyncedAt: time.Now().Add(-nonceDriftTimeout * 2),
+	}
+	if nonce := state.reserve(6); nonce != 10 {
+		t.Errorf("expected nonce 10 while the pending network nonce advances; got %d", nonce)
+	}
+	if state.Pending != 6 || time.Since(state.SyncedAt) > time.Second {
+		t.Errorf("expected pending network nonce 6 to be synced; got %+v", state)
+	}
+}
+
+func TestIsRejectedBroadcast(t *testing.T) {
+	cases := []struct {
+		err      error
+		rejected bool
+	}{
+		{nil, false},
+		{errors.New("failed to transmit signed tx to JSON-RPC host; insufficient funds for gas * price + value"), true},
+		{errors.New("failed to transmit signed tx to JSON-RPC host; replacement transaction underpriced"), false},
+		{errors.New("failed to transmit signed tx to JSON-RPC host; transaction underpriced"), true},
+		{errors.New("signed tx rejected with code 13 (codespace: sdk); insufficient fee"), true},
+		{errors.New("unable to broadcast signed tx; typecast failed for signed tx"), true},
+		{errors.New("failed to transmit signed tx to JSON-RPC host; context deadline exceeded"), false},
+		{errors.New("Post \"http://localhost:8545\": dial tcp 127.0.0.1:8545: connect: connection refused"), false},
+		{errors.New("failed to transmit signed tx to JSON-RPC host; already known"), false},
+	}
+
+	for _, c := range cases {
+		if rejected := isRejectedBroadcast(c.err); rejected != c.rejected {
+			t.Errorf("isRejectedBroadcast(%v) returned %v; expected %v", c.err, rejected, c.rejected)
+		}
+	}
+}
+
+func TestIsStaleNonceBroadcast(t *testing.T) {
+	cases := []struct {
+		err   error
+		stale bool
+	}{
+		{nil, false},
+		{errors.New("failed to transmit signed tx to JSON-RPC host; nonce too low"), true},
+		{errors.New("failed to transmit signed tx to JSON-RPC host; replacement transaction underpriced"), true},
+		{errors.New("signed tx rejected with code 32 (codespace: sdk); account sequence mismatch, expected 4, got 3"), true},
+		{errors.New("failed to transmit signed tx to JSON-RPC host; transaction underpriced"), false},
+		{errors.New("failed to transmit signed tx to JSON-RPC host; insufficient funds for gas * price + value"), false},
+	}
+
+	for _, c := range cases {
+		if stale := isStaleNonceBroadcast(c.err); stale != c.stale {
+			t.Errorf("isStaleNonceBroadcast(%v) returned %v; expected %v", c.err, stale, c.stale)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package tx
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/contract"
+	provide "github.com/provideplatform/provide-go/common"
+	util "github.com/provideplatform/provide-go/common/util"
+)
+
+// defaultTransactionsPerPage is the number of txs listed per page when rpp is not provided
+const defaultTransactionsPerPage = 25
+
+// maxTransactionsPerPage is the maximum number of txs which may be listed per page
+const maxTransactionsPerPage = 500
+
+// nextCursorHeader is the response header containing the cursor of the next page of txs, if any
+const nextCursorHeader = "x-next-cursor"
+
+// filterTransactions applies the tx list filters of the given request to the given query:
+//   - status: comma-separated list of statuses
+//   - to, from: recipient and signing account address
+//   - network_id, account_id, wallet_id, contract_id
+//   - created_after, created_before: RFC3339 timestamps (exclusive)
+//   - block_from, block_to: block range (inclusive)
+//   - hash: tx hash
+//   - ref, description: case-insensitive substring search
+//   - method: method selector (i.e., 0xa9059cbb) or signature (i.e., transfer(address,uint256))
+func filterTransactions(c *gin.Context, db, query *gorm.DB) (*gorm.DB, error) {
+	if strings.ToLower(c.Query("filter_contract_creations")) == "true" {
+		query = query.Where("transactions.to IS NULL")
+	}
+
+	if c.Query("status") != "" {
+		query = query.Where("transactions.status IN (?)", strings.Split(c.Query("status"), ","))
+	}
+
+	if c.Query("to") != "" {
+		query = query.Where("transactions.to = ?", c.Query("to"))
+	}
+
+	if c.Query("from") != "" {
+		query = query.Where("transactions.account_id IN (SELECT accounts.id FROM accounts WHERE accounts.address = ?)", c.Query("from"))
+	}
+
+	if c.Query("network_id") != "" {
+		query = query.Where("transactions.network_id = ?", c.Query("network_id"))
+	}
+
+	if c.Query("account_id") != "" {
+		query = query.Where("transactions.account_id = ?", c.Query("account_id"))
+	}
+
+	if c.Query("wallet_id") != "" {
+		query = query.Where("transactions.wallet_id = ?", c.Query("wallet_id"))
+	}
+
+	if c.Query("contract_id") != "" {
+		contractObj := &contract.Contract{}
+		appID := util.AuthorizedSubjectID(c, "application")
+		orgID := util.AuthorizedSubjectID(c, "organization")
+		contractQuery(db, appID, orgID).Where("contracts.id = ?", c.Query("contract_id")).Find(&contractObj)
+		if contractObj.ID == uuid.Nil || contractObj.Address == nil {
+			return nil, fmt.Errorf("invalid contract_id: %s", c.Query("contract_id"))
+		}
+		query = query.Where("transactions.network_id = ? AND transactions.to = ?", contractObj.NetworkID, contractObj.Address)
+	}
+
+	if c.Query("created_after") != "" {
+		createdAfter, err := time.Parse(time.RFC3339, c.Query("created_after"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid created_after: %s; expected RFC3339 timestamp", c.Query("created_after"))
+		}
+		query = query.Where("transactions.created_at > ?", createdAfter)
+	}
+
+	if c.Query("created_before") != "" {
+		createdBefore, err := time.Parse(time.RFC3339, c.Query("created_before"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid created_before: %s; expected RFC3339 timestamp", c.Query("created_before"))
+		}
+		query = query.Where("transactions.created_at < ?", createdBefore)
+	}
+
+	if c.Query("block_from") != "" {
+		blockFrom, err := strconv.ParseUint(c.Query("block_from"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid block_from: %s", c.Query("block_from"))
+		}
+		query = query.Where("transactions.block >= ?", blockFrom)
+	}
+
+	if c.Query("block_to") != "" {
+		blockTo, err := strconv.ParseUint(c.Query("block_to"), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid block_to: %s", c.Query("block_to"))
+		}
+		query = query.Where("transactions.block <= ?", blockTo)
+	}
+
+	if c.Query("hash") != "" {
+		query = query.Where("transactions.hash = ?", c.Query("hash"))
+	}
+
+	if c.Query("ref") != "" {
+		query = query.Where("transactions.ref ILIKE ?", substringPattern(c.Query("ref")))
+	}
+
+	if c.Query("description") != "" {
+		query = query.Where("transactions.description ILIKE ?", substringPattern(c.Query("description")))
+	}
+
+	if c.Query("method") != "" {
+		selector, err := common.ParseMethodSelector(c.Query("method"))
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("lower(left(transactions.data, 10)) = ?", selector)
+	}
+
+	return query, nil
+}
+
+// contractQuery returns a query of the contracts of the given application or organization, or of
+// the contracts of neither when both are nil (i.e., when the request is authorized by a user)
+func contractQuery(db *gorm.DB, appID, orgID *uuid.UUID) *gorm.DB {
+	if appID != nil {
+		return db.Where("contracts.application_id = ?", appID)
+	} else if orgID != nil {
+		return db.Where("contracts.organization_id = ?", orgID)
+	}
+	return db.Where("contracts.application_id IS NULL AND contracts.organization_id IS NULL")
+}
+
+// substringPattern returns the LIKE pattern matching the given substring
+func substringPattern(substr string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return fmt.Sprintf("%%%s%%", replacer.Replace(substr))
+}
+
+// keysetPagination is the value of the pagination param which selects keyset pagination, the default
+const keysetPagination = "keyset"
+
+// offsetPagination is the value of the pagination param which falls back to offset pagination
+const offsetPagination = "offset"
+
+// paginateTransactions lists the txs matched by the given query, newest first, using keyset
+// pagination; the cursor of the next page, if any, is returned in the x-next-cursor header and is
+// provided using the cursor param. Keyset pagination does not count the matched txs, so the total
+// is not returned. Offset pagination, which includes the total number of matched txs in the
+// x-total-results-count header, is used only when requested using pagination=offset.
+func paginateTransactions(c *gin.Context, query *gorm.DB) ([]*Transaction, error) {
+	var txs []*Transaction
+
+	pagination := c.DefaultQuery("pagination", keysetPagination)
+	if pagination != keysetPagination && pagination != offsetPagination {
+		return nil, fmt.Errorf("invalid pagination: %s; expected %s or %s", pagination, keysetPagination, offsetPagination)
+	}
+
+	if pagination == offsetPagination {
+		if c.Query("cursor") != "" {
+			return nil, fmt.Errorf("cursor is not supported using %s pagination", offsetPagination)
+		}
+
+		query = query.Order("transactions.created_at DESC, transactions.id DESC")
+		provide.Paginate(c, query, &Transaction{}).Find(&txs)
+		return txs, nil
+	}
+
+	rpp := defaultTransactionsPerPage
+	if c.Query("rpp") != "" {
+		_rpp, err := strconv.Atoi(c.Query("rpp"))
+		if err != nil || _rpp <= 0 {
+			return nil, fmt.Errorf("invalid rpp: %s", c.Query("rpp"))
+		}
+		rpp = _rpp
+	}
+	if rpp > maxTransactionsPerPage {
+		rpp = maxTransactionsPerPage
+	}
+
+	if c.Query("cursor") != "" {
+		createdAt, id, err := parseTransactionsCursor(c.Query("cursor"))
+		if err != nil {
+			return nil, err
+		}
+		query = query.Where("(transactions.created_at, transactions.id) < (?, ?)", createdAt, id)
+	}
+
+	query.Order("transactions.created_at DESC, transactions.id DESC").Limit(rpp + 1).Find(&txs)
+	if len(txs) > rpp {
+		txs = txs[0:rpp]
+		last := txs[rpp-1]
+		c.Header(nextCursorHeader, transactionsCursor(last.CreatedAt, last.ID))
+	}
+
+	return txs, nil
+}
+
+// transactionsCursor returns the opaque cursor of the page of txs following the given tx
+func transactionsCursor(createdAt time.Time, id uuid.UUID) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%s|%s", createdAt.UTC().Format(time.RFC3339Nano), id)))
+}
+
+// parseTransactionsCursor returns the created_at timestamp and id of the tx encoded in the given cursor
+func parseTransactionsCursor(cursor string) (*time.Time, *uuid.UUID, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid cursor: %s", cursor)
+	}
+
+	parts := strings.Split(string(raw), "|")
+	if len(parts) != 2 {
+		return nil, nil, fmt.Errorf("invalid cursor: %s", cursor)
+	}
+
+	createdAt, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid cursor: %s", cursor)
+	}
+
+	id, err := uuid.FromString(parts[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid cursor: %s", cursor)
+	}
+
+	return &createdAt, &id, nil
+}
@@ -0,0 +1,164 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tx
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/contract"
+	"github.com/provideplatform/nchain/internal/testutil"
+)
+
+func queryTestContext(target string) (*gin.Context, *httptest.ResponseRecorder) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+	return c, recorder
+}
+
+func TestPaginateTransactionsUsesOffsetPaginationWhenRequested(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	c, _ := queryTestContext("/api/v1/transactions?rpp=2&pagination=offset")
+
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "transactions"`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(7))
+	mock.ExpectQuery(`SELECT \* FROM "transactions" .*ORDER BY transactions.created_at DESC, transactions.id DESC LIMIT 2 OFFSET 0`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.Must(uuid.NewV4())).AddRow(uuid.Must(uuid.NewV4())))
+
+	txs, err := paginateTransactions(c, db.Where("transactions.network_id IS NOT NULL"))
+	if err != nil {
+		t.Fatalf("failed to paginate txs; %s", err.Error())
+	}
+	if len(txs) != 2 {
+		t.Errorf("expected a page of 2 txs; got %d", len(txs))
+	}
+	if total := c.Writer.Header().Get("x-total-results-count"); total != "7" {
+		t.Errorf("expected total results count header of 7; got %q", total)
+	}
+	if cursor := c.Writer.Header().Get(nextCursorHeader); cursor != "" {
+		t.Errorf("expected no cursor using offset pagination; got %s", cursor)
+	}
+}
+
+func TestPaginateTransactionsUsesKeysetPaginationByDefault(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	c, _ := queryTestContext("/api/v1/transactions?rpp=1")
+
+	createdAt := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	first, _ := uuid.NewV4()
+	mock.ExpectQuery(`SELECT \* FROM "transactions" .*ORDER BY transactions.created_at DESC, transactions.id DESC LIMIT 2`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).
+			AddRow(first, createdAt).
+			AddRow(uuid.Must(uuid.NewV4()), createdAt.Add(-time.Second)))
+
+	txs, err := paginateTransactions(c, db.Where("transactions.network_id IS NOT NULL"))
+	if err != nil {
+		t.Fatalf("failed to paginate txs; %s", err.Error())
+	}
+	if len(txs) != 1 || txs[0].ID != first {
+		t.Fatalf("expected a page containing the newest tx; got %d tx(s)", len(txs))
+	}
+	if cursor := c.Writer.Header().Get(nextCursorHeader); cursor != transactionsCursor(createdAt, first) {
+		t.Errorf("expected cursor of the next page to follow the newest tx; got %q", cursor)
+	}
+
+	c, _ = queryTestContext("/api/v1/transactions?rpp=1&cursor=" + transactionsCursor(createdAt, first))
+	mock.ExpectQuery(`SELECT \* FROM "transactions" WHERE .*\(\(transactions.created_at, transactions.id\) < \(\$1, \$2\)\)`).
+		WithArgs(createdAt, first).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	_, err = paginateTransactions(c, db.Where("transactions.network_id IS NOT NULL"))
+	if err != nil {
+		t.Fatalf("failed to paginate txs using cursor; %s", err.Error())
+	}
+}
+
+func TestPaginateTransactionsRejectsInvalidPagination(t *testing.T) {
+	db, _ := testutil.NewMockDB(t)
+
+	for _, target := range []string{"/api/v1/transactions?pagination=page", "/api/v1/transactions?pagination=offset&cursor=abc"} {
+		c, _ := queryTestContext(target)
+		if _, err := paginateTransactions(c, db); err == nil {
+			t.Errorf("expected %s to be rejected", target)
+		}
+	}
+}
+
+func TestFilterTransactionsScopesContractToUser(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	contractID, _ := uuid.NewV4()
+	c, _ := queryTestContext("/api/v1/transactions?contract_id=" + contractID.String())
+
+	mock.ExpectQuery(`SELECT \* FROM "contracts" WHERE \(contracts.application_id IS NULL AND contracts.organization_id IS NULL\) AND \(contracts.id = \$1\)`).
+		WithArgs(contractID.String()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	_, err := filterTransactions(c, db, db)
+	if err == nil {
+		t.Error("expected contract of an application or organization to be rejected")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expected contract lookup to be scoped to the user; %s", err.Error())
+	}
+}
+
+func TestContractQueryScopesContractToApplication(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	appID, _ := uuid.NewV4()
+
+	mock.ExpectQuery(`SELECT \* FROM "contracts" WHERE \(contracts.application_id = \$1\)`).
+		WithArgs(appID).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	contractQuery(db, &appID, nil).Find(&[]*contract.Contract{})
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("expected contracts to be scoped to the application; %s", err.Error())
+	}
+}
+
+func TestFilterTransactionsByMethod(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+
+	for _, method := range []string{"transfer(address,uint256)", "transfer(address, uint256)", "0xA9059CBB"} {
+		c, _ := queryTestContext("/api/v1/transactions?method=" + url.QueryEscape(method))
+		query, err := filterTransactions(c, db, db.Where("transactions.network_id IS NOT NULL"))
+		if err != nil {
+			t.Fatalf("failed to filter txs by method %s; %s", method, err.Error())
+		}
+
+		mock.ExpectQuery(`SELECT \* FROM "transactions" WHERE .*\(lower\(left\(transactions.data, 10\)\) = \$1\)`).
+			WithArgs("0xa9059cbb").
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		query.Find(&[]*Transaction{})
+	}
+
+	c, _ := queryTestContext("/api/v1/transactions?method=transfer")
+	if _, err := filterTransactions(c, db, db); err == nil {
+		t.Error("expected invalid method signature to be rejected")
+	}
+}
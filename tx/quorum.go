@@ -0,0 +1,359 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package tx
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"time"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/network"
+	providecrypto "github.com/provideplatform/provide-go/crypto"
+)
+
+// privateTxVOffset is added to the recovery id of the signature of a quorum private tx;
+// a v of 37 or 38 marks the tx as private
+const privateTxVOffset = 37
+
+// privacyFlagStandardPrivate is the default privacy flag of a quorum private tx
+const privacyFlagStandardPrivate = uint64(0)
+
+// privacyFlagPartyProtection restricts interactions with the private contract to its parties
+const privacyFlagPartyProtection = uint64(1)
+
+// privacyFlagPrivateStateValidation additionally enforces that all parties share the same private state
+const privacyFlagPrivateStateValidation = uint64(3)
+
+const transactionManagerTimeout = time.Second * 30
+
+// errPrivateTxGasRequired is returned when a quorum private tx does not specify its gas limit; gas cannot be
+// estimated for a private tx, as the public state against which it would be estimated does not reflect the private state
+var errPrivateTxGasRequired = errors.New("private tx requires gas; gas cannot be estimated against the private state")
+
+// privateTx is a quorum private transaction; its payload is stored by the private transaction
+// manager (i.e., tessera) and replaced by the hash of the encrypted payload, which is signed
+// as a homestead tx and broadcast along with the recipients which can decrypt the payload
+type privateTx struct {
+	Nonce    uint64
+	GasPrice *big.Int
+	Gas      uint64
+	To       *ethcommon.Address
+	Value    *big.Int
+	Data     []byte // hash of the encrypted payload
+
+	// signature values
+	V *big.Int
+	R *big.Int
+	S *big.Int
+
+	// privacy params
+	PrivateFor  []string
+	PrivacyFlag uint64
+}
+
+// privateTxParams are the privacy params of a quorum private tx
+type privateTxParams struct {
+	PrivateFrom *string
+	PrivateFor  []string
+	PrivacyFlag uint64
+}
+
+// parsePrivateTxParams returns the privacy params from the given tx params, or nil if
+// the tx is not private (i.e., no private_for recipients were given)
+func parsePrivateTxParams(params map[string]interface{}) (*privateTxParams, error) {
+	rawPrivateFor, privateForOk := params["private_for"]
+	if !privateForOk || rawPrivateFor == nil {
+		if _, privateFromOk := params["private_from"]; privateFromOk {
+			return nil, errors.New("private_from requires private_for")
+		} else if _, privacyFlagOk := params["privacy_flag"]; privacyFlagOk {
+			return nil, errors.New("privacy_flag requires private_for")
+		}
+		return nil, nil
+	}
+
+	items, itemsOk := rawPrivateFor.([]interface{})
+	if !itemsOk {
+		return nil, errors.New("private_for must be a list of base64-encoded transaction manager public keys")
+	}
+
+	privateFor := make([]string, 0)
+	for _, item := range items {
+		publicKey, publicKeyOk := item.(string)
+		if !publicKeyOk || publicKey == "" {
+			return nil, errors.New("private_for must be a list of base64-encoded transaction manager public keys")
+		}
+		privateFor = append(privateFor, publicKey)
+	}
+
+	if len(privateFor) == 0 {
+		return nil, errors.New("private_for must include at least one recipient")
+	}
+
+	if gas, gasOk := params["gas"].(float64); !gasOk || gas < 1 {
+		return nil, errPrivateTxGasRequired
+	}
+
+	privateTxParams := &privateTxParams{
+		PrivateFor:  privateFor,
+		PrivacyFlag: privacyFlagStandardPrivate,
+	}
+
+	if privateFrom, privateFromOk := params["private_from"].(string); privateFromOk && privateFrom != "" {
+		privateTxParams.PrivateFrom = &privateFrom
+	}
+
+	if privacyFlag, privacyFlagOk := params["privacy_flag"].(float64); privacyFlagOk {
+		switch uint64(privacyFlag) {
+		case privacyFlagStandardPrivate, privacyFlagPartyProtection, privacyFlagPrivateStateValidation:
+			privateTxParams.PrivacyFlag = uint64(privacyFlag)
+		default:
+			return nil, fmt.Errorf("unsupported privacy flag: %v", privacyFlag)
+		}
+	}
+
+	return privateTxParams, nil
+}
+
+// storePrivatePayload stores the given payload using the private transaction manager of the given
+// network, on behalf of the given sender, and returns the hash of the encrypted payload
+func storePrivatePayload(ntwrk *network.Network, privateFrom *string, payload []byte) ([]byte, error) {
+	tmURL := ntwrk.TransactionManagerURL()
+	if tmURL == nil {
+		return nil, fmt.Errorf("failed to store private payload; no transaction manager configured for network: %s", ntwrk.ID)
+	}
+
+	params := map[string]interface{}{
+		"payload": base64.StdEncoding.EncodeToString(payload),
+	}
+	if privateFrom != nil {
+		params["from"] = *privateFrom
+	}
+	reqPayload, _ := json.Marshal(params)
+
+	client := &http.Client{Timeout: transactionManagerTimeout}
+	resp, err := client.Post(fmt.Sprintf("%s/storeraw", *tmURL), "application/json", bytes.NewReader(reqPayload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to store %d-byte private payload; %s", len(payload), err.Error())
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read transaction manager response; %s", err.Error())
+	}
+
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("failed to store %d-byte private payload; transaction manager responded with status: %d; %s", len(payload), resp.StatusCode, string(body))
+	}
+
+	var stored struct {
+		Key string `json:"key"`
+	}
+	err = json.Unmarshal(body, &stored)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal transaction manager response; %s", err.Error())
+	}
+
+	hash, err := base64.StdEncoding.DecodeString(stored.Key)
+	if err != nil || len(hash) == 0 {
+		return nil, fmt.Errorf("transaction manager returned invalid private payload hash: %s", stored.Key)
+	}
+
+	common.Log.Debugf("stored %d-byte private payload using transaction manager for network: %s", len(payload), ntwrk.ID)
+	return hash, nil
+}
+
+// privateTxFactory stores the payload of a quorum private tx using the private transaction
+// manager and returns the unsigned private tx and its signing hash; gas is not estimated, as
+// the public state against which it would be estimated does not reflect the private state,
+// so the gas limit must be given
+func privateTxFactory(
+	ntwrk *network.Network,
+	from string,
+	to,
+	data *string,
+	val *big.Int,
+	nonce *uint64,
+	gasLimit uint64,
+	gasPrice *uint64,
+	params *privateTxParams,
+) (*privateTx, []byte, error) {
+	if gasLimit == 0 {
+		return nil, nil, errPrivateTxGasRequired
+	}
+
+	// resolve the nonce and gas price as if the tx were public; the given gas limit is used as-is
+	rpcClientKey, rpcURL, err := ntwrk.RPCEndpoint()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, _tx, _, err := providecrypto.EVMTxFactory(
+		rpcClientKey,
+		rpcURL,
+		from,
+		to,
+		data,
+		val,
+		nonce,
+		gasLimit,
+		gasPrice,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var payload []byte
+	if data != nil {
+		payload = ethcommon.FromHex(*data)
+	}
+	if len(payload) == 0 {
+		return nil, nil, errors.New("private tx requires a payload")
+	}
+
+	privateFrom := params.PrivateFrom
+	if privateFrom == nil {
+		privateFrom = ntwrk.TransactionManagerPublicKey()
+	}
+
+	payloadHash, err := storePrivatePayload(ntwrk, privateFrom, payload)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	value := _tx.Value()
+	if value == nil {
+		value = big.NewInt(0)
+	}
+
+	tx := &privateTx{
+		Nonce:       _tx.Nonce(),
+		GasPrice:    _tx.GasPrice(),
+		Gas:         _tx.Gas(),
+		To:          _tx.To(),
+		Value:       value,
+		Data:        payloadHash,
+		PrivateFor:  params.PrivateFor,
+		PrivacyFlag: params.PrivacyFlag,
+	}
+
+	hash, err := tx.signingHash()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tx, hash, nil
+}
+
+// fields returns the RLP-encodable fields of the tx, excluding the signature values
+func (tx *privateTx) fields() []interface{} {
+	to := []byte{}
+	if tx.To != nil {
+		to = tx.To.Bytes()
+	}
+
+	return []interface{}{
+		tx.Nonce,
+		tx.GasPrice,
+		tx.Gas,
+		to,
+		tx.Value,
+		tx.Data,
+	}
+}
+
+// signingHash returns the homestead hash to be signed by the sender
+func (tx *privateTx) signingHash() ([]byte, error) {
+	payload, err := rlp.EncodeToBytes(tx.fields())
+	if err != nil {
+		return nil, fmt.Errorf("failed to RLP-encode private tx; %s", err.Error())
+	}
+	return crypto.Keccak256(payload), nil
+}
+
+// withSignature returns a copy of the tx with the given 65-byte [R || S || V] signature
+func (tx *privateTx) withSignature(sig []byte) (*privateTx, error) {
+	if len(sig) != crypto.SignatureLength {
+		return nil, fmt.Errorf("invalid %d-byte signature; expected %d bytes", len(sig), crypto.SignatureLength)
+	}
+
+	v := sig[crypto.RecoveryIDOffset]
+	if v >= 27 {
+		v -= 27
+	}
+	if v > 1 {
+		return nil, fmt.Errorf("invalid signature recovery id: %d", v)
+	}
+
+	signed := *tx
+	signed.R = new(big.Int).SetBytes(sig[0:32])
+	signed.S = new(big.Int).SetBytes(sig[32:64])
+	signed.V = new(big.Int).SetUint64(uint64(v) + privateTxVOffset)
+	return &signed, nil
+}
+
+// MarshalBinary returns the raw signed tx, suitable for eth_sendRawPrivateTransaction
+func (tx *privateTx) MarshalBinary() ([]byte, error) {
+	if tx.V == nil || tx.R == nil || tx.S == nil {
+		return nil, errors.New("failed to encode unsigned private tx")
+	}
+
+	raw, err := rlp.EncodeToBytes(append(tx.fields(), tx.V, tx.R, tx.S))
+	if err != nil {
+		return nil, fmt.Errorf("failed to RLP-encode private tx; %s", err.Error())
+	}
+	return raw, nil
+}
+
+// Hash returns the hash of the signed tx
+func (tx *privateTx) Hash() ethcommon.Hash {
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		return ethcommon.Hash{}
+	}
+	return ethcommon.BytesToHash(crypto.Keccak256(raw))
+}
+
+// broadcastPrivateTx emits the given signed quorum private tx for inclusion in a block; the
+// private payload is distributed to the recipients by the private transaction manager
+func broadcastPrivateTx(ntwrk *network.Network, signedTx *privateTx) error {
+	raw, err := signedTx.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	var hash ethcommon.Hash
+	err = ntwrk.EVMRPCClientCall(&hash, "eth_sendRawPrivateTransaction", hexutil.Encode(raw), map[string]interface{}{
+		"privateFor":  signedTx.PrivateFor,
+		"privacyFlag": signedTx.PrivacyFlag,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to transmit signed private tx to JSON-RPC host; %s", err.Error())
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tx
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/provideplatform/nchain/network"
+)
+
+func TestParsePrivateTxParamsRequiresGas(t *testing.T) {
+	_, err := parsePrivateTxParams(map[string]interface{}{
+		"private_for": []interface{}{"ROAZBWtSacxXQrOe3FGAqJDyJjFePR5ce4TSIzmJ0Bc="},
+	})
+	if err != errPrivateTxGasRequired {
+		t.Errorf("expected private tx without gas to be rejected; got %v", err)
+	}
+
+	params, err := parsePrivateTxParams(map[string]interface{}{
+		"gas":          float64(210000),
+		"private_for":  []interface{}{"ROAZBWtSacxXQrOe3FGAqJDyJjFePR5ce4TSIzmJ0Bc="},
+		"privacy_flag": float64(privacyFlagPartyProtection),
+	})
+	if err != nil {
+		t.Fatalf("failed to parse private tx params; %s", err.Error())
+	}
+	if len(params.PrivateFor) != 1 || params.PrivacyFlag != privacyFlagPartyProtection {
+		t.Errorf("expected private tx params to be parsed; got %v", params)
+	}
+}
+
+func TestParsePrivateTxParamsForPublicTx(t *testing.T) {
+	params, err := parsePrivateTxParams(map[string]interface{}{})
+	if err != nil || params != nil {
+		t.Errorf("expected public tx without gas to be permitted; got %v; %v", params, err)
+	}
+}
+
+func TestPrivateTxFactoryDoesNotEstimateGas(t *testing.T) {
+	data := "0x60806040"
+	_, _, err := privateTxFactory(
+		&network.Network{},
+		"0x96f1027AD9E8A5ff9C8A1F5a0ED51ABF5dA6a51C",
+		nil,
+		&data,
+		big.NewInt(0),
+		nil,
+		0,
+		nil,
+		&privateTxParams{PrivateFor: []string{"ROAZBWtSacxXQrOe3FGAqJDyJjFePR5ce4TSIzmJ0Bc="}},
+	)
+	if err != errPrivateTxGasRequired {
+		t.Errorf("expected private tx without gas to be rejected before estimation; got %v", err)
+	}
+}
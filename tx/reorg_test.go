@@ -0,0 +1,49 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tx
+
+import (
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	"github.com/provideplatform/nchain/internal/testutil"
+)
+
+func TestOrphanedTxsQueryMatchesBlockHash(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+
+	mock.ExpectQuery(`SELECT \* FROM "transactions" WHERE \(network_id = \$1 AND status IN \(\$2, \$3\)\) AND \(block_hash IN \(\$4,\$5\) OR \(block_hash IS NULL AND block IN \(\$6,\$7\)\)\)`).
+		WithArgs("network-id", "success", "failed", "0xa", "0xb", 100, 101).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	var txs []*Transaction
+	orphanedTxsQuery(db, "network-id", []uint64{100, 101}, []string{"0xa", "0xb"}).Find(&txs)
+}
+
+func TestOrphanedTxsQueryWithoutBlockHashes(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+
+	mock.ExpectQuery(`SELECT \* FROM "transactions" WHERE \(network_id = \$1 AND status IN \(\$2, \$3\)\) AND \(block IN \(\$4\)\)`).
+		WithArgs("network-id", "success", "failed", 100).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	var txs []*Transaction
+	orphanedTxsQuery(db, "network-id", []uint64{100}, []string{}).Find(&txs)
+}
@@ -0,0 +1,268 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tx
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/jinzhu/gorm"
+	"github.com/provideplatform/nchain/common"
+)
+
+const txReplacementModeCancel = "cancel"
+const txReplacementModeSpeedUp = "speed_up"
+
+// minTxReplacementFeeBumpPercent is the minimum fee bump accepted by geth-based txpools for a replacement tx
+const minTxReplacementFeeBumpPercent = uint64(10)
+
+// defaultTxReplacementFeeBumpPercent is the fee bump used when none is provided
+const defaultTxReplacementFeeBumpPercent = uint64(15)
+
+// cancelTxGas is the gas limit of the zero-value self-transfer used to cancel a pending tx
+const cancelTxGas = float64(21000)
+
+// pendingEVMTx is the subset of a pending tx, as returned by eth_getTransactionByHash, needed to replace it
+type pendingEVMTx struct {
+	BlockNumber          *hexutil.Big      `json:"blockNumber"`
+	From                 ethcommon.Address `json:"from"`
+	Gas                  hexutil.Uint64    `json:"gas"`
+	GasPrice             *hexutil.Big      `json:"gasPrice"`
+	MaxFeePerGas         *hexutil.Big      `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *hexutil.Big      `json:"maxPriorityFeePerGas"`
+	Nonce                hexutil.Uint64    `json:"nonce"`
+	Type                 *hexutil.Uint64   `json:"type"`
+}
+
+// bumpFee returns the given fee increased by the given percentage, rounded up
+func bumpFee(fee *big.Int, percent uint64) *big.Int {
+	bumped := new(big.Int).Mul(fee, new(big.Int).SetUint64(100+percent))
+	bumped.Div(bumped, big.NewInt(100))
+	return bumped.Add(bumped, big.NewInt(1))
+}
+
+// replacementFee returns the fee to use for the named param of a replacement tx; an explicitly
+// provided fee must be at least the bumped fee of the tx being replaced
+func replacementFee(params map[string]interface{}, name string, fee *big.Int, percent uint64) (*big.Int, error) {
+	bumped := bumpFee(fee, percent)
+	if requested := parseBigIntParam(params, name); requested != nil {
+		if requested.Cmp(bumpFee(fee, minTxReplacementFeeBumpPercent)) < 0 {
+			return nil, fmt.Errorf("%s must be at least %d%% greater than %s of the tx being replaced", name, minTxReplacementFeeBumpPercent, fee)
+		}
+		return requested, nil
+	}
+	return bumped, nil
+}
+
+// replace re-signs the pending tx using the same nonce and a bumped fee; in speed_up mode the
+// replacement executes the same payload, and in cancel mode the replacement is a zero-value
+// self-transfer. The replacement is linked to the original, which is marked replaced once the
+// replacement is finalized.
+func (t *Transaction) replace(db *gorm.DB, mode string, params map[string]interface{}) (*Transaction, error) {
+	if mode != txReplacementModeSpeedUp && mode != txReplacementModeCancel {
+		return nil, fmt.Errorf("invalid tx replacement mode: %s", mode)
+	}
+
+	if t.Status == nil || *t.Status != "pending" {
+		return nil, fmt.Errorf("unable to replace tx %s which is not pending", t.ID)
+	}
+
+	if t.Hash == nil || t.BroadcastAt == nil {
+		return nil, fmt.Errorf("unable to replace tx %s which has not been broadcast", t.ID)
+	}
+
+	if t.WalletID != nil && t.Path == nil {
+		return nil, fmt.Errorf("unable to replace tx %s signed by HD wallet without an explicit hd_derivation_path", t.ID)
+	}
+
+	if t.AccountID == nil && t.WalletID == nil {
+		return nil, fmt.Errorf("unable to replace tx %s without a custodial signer", t.ID)
+	}
+
+	ntwrk, err := t.GetNetwork()
+	if err != nil {
+		return nil, err
+	}
+
+	if !ntwrk.IsEthereumNetwork() {
+		return nil, fmt.Errorf("tx replacement not supported by network %s", ntwrk.ID)
+	}
+
+	var pending *pendingEVMTx
+	err = ntwrk.EVMRPCClientCall(&pending, "eth_getTransactionByHash", *t.Hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve pending tx %s; %s", *t.Hash, err.Error())
+	}
+
+	if pending == nil {
+		return nil, fmt.Errorf("failed to resolve pending tx %s; tx not found", *t.Hash)
+	}
+
+	if pending.BlockNumber != nil {
+		return nil, fmt.Errorf("unable to replace tx %s; tx was included in block %s", *t.Hash, pending.BlockNumber.ToInt())
+	}
+
+	percent := defaultTxReplacementFeeBumpPercent
+	if bump, bumpOk := params["fee_bump_percent"].(float64); bumpOk {
+		if uint64(bump) < minTxReplacementFeeBumpPercent {
+			return nil, fmt.Errorf("fee_bump_percent must be at least %d", minTxReplacementFeeBumpPercent)
+		}
+		percent = uint64(bump)
+	}
+
+	txParams := t.ParseParams()
+	if txParams == nil {
+		txParams = map[string]interface{}{}
+	}
+	delete(txParams, "gas_price")
+	delete(txParams, "max_fee_per_gas")
+	delete(txParams, "max_priority_fee_per_gas")
+
+	txParams["nonce"] = uint64(pending.Nonce)
+	txParams["gas"] = float64(pending.Gas)
+
+	if pending.Type != nil && uint8(*pending.Type) == txTypeDynamicFee {
+		if pending.MaxFeePerGas == nil || pending.MaxPriorityFeePerGas == nil {
+			return nil, fmt.Errorf("failed to resolve fees of dynamic fee tx %s", *t.Hash)
+		}
+
+		maxFeePerGas, err := replacementFee(params, "max_fee_per_gas", pending.MaxFeePerGas.ToInt(), percent)
+		if err != nil {
+			return nil, err
+		}
+
+		maxPriorityFeePerGas, err := replacementFee(params, "max_priority_fee_per_gas", pending.MaxPriorityFeePerGas.ToInt(), percent)
+		if err != nil {
+			return nil, err
+		}
+
+		txParams["type"] = txTypeDynamicFee
+		txParams["max_fee_per_gas"] = maxFeePerGas.String()
+		txParams["max_priority_fee_per_gas"] = maxPriorityFeePerGas.String()
+	} else {
+		if pending.GasPrice == nil {
+			return nil, fmt.Errorf("failed to resolve gas price of tx %s", *t.Hash)
+		}
+
+		gasPrice, err := replacementFee(params, "gas_price", pending.GasPrice.ToInt(), percent)
+		if err != nil {
+			return nil, err
+		}
+
+		txParams["type"] = txTypeLegacy
+		txParams["gas_price"] = gasPrice
+	}
+
+	replacement := &Transaction{
+		NetworkID:      t.NetworkID,
+		ApplicationID:  t.ApplicationID,
+		OrganizationID: t.OrganizationID,
+		UserID:         t.UserID,
+		AccountID:      t.AccountID,
+		WalletID:       t.WalletID,
+		Path:           t.Path,
+		To:             t.To,
+		Value:          t.Value,
+		Data:           t.Data,
+		PublishedAt:    t.PublishedAt,
+		ReplacesID:     &t.ID,
+	}
+
+	if mode == txReplacementModeCancel {
+		replacement.To = common.StringOrNil(pending.From.Hex())
+		replacement.Value = NewTxValue(0)
+		replacement.Data = nil
+		txParams["gas"] = cancelTxGas
+	}
+
+	replacement.setParams(txParams)
+
+	if !replacement.Create(db) {
+		errs := make([]string, 0)
+		for _, err := range replacement.Errors {
+			if err.Message != nil {
+				errs = append(errs, *err.Message)
+			}
+		}
+		return replacement, fmt.Errorf("failed to replace tx %s; %s", *t.Hash, strings.Join(errs, "; "))
+	}
+
+	common.Log.Debugf("broadcast %s replacement tx %s for pending tx %s using nonce %d", mode, replacement.ID, t.ID, uint64(pending.Nonce))
+	return replacement, nil
+}
+
+// resolveReplacedTx marks the tx replaced by this finalized tx as replaced; if this finalized tx
+// was itself the subject of a replacement, its pending replacements can never be finalized
+func (t *Transaction) resolveReplacedTx(db *gorm.DB) error {
+	if t.ReplacesID != nil {
+		original := &Transaction{}
+		db.Where("id = ? AND status = ?", t.ReplacesID, "pending").Find(&original)
+		if original != nil && original.ID == *t.ReplacesID {
+			desc := fmt.Sprintf("replaced by tx %s", t.ID)
+			original.updateStatus(db, "replaced", &desc)
+			if len(original.Errors) > 0 {
+				return errors.New(*original.Errors[0].Message)
+			}
+			common.Log.Debugf("marked tx %s replaced by finalized tx %s", original.ID, t.ID)
+		}
+	}
+
+	var replacements []*Transaction
+	db.Where("replaces_id = ? AND status = ?", t.ID, "pending").Find(&replacements)
+	for _, replacement := range replacements {
+		desc := fmt.Sprintf("tx %s was finalized before its replacement", t.ID)
+		replacement.updateStatus(db, "failed", &desc)
+		common.Log.Debugf("marked replacement tx %s failed; replaced tx %s was finalized", replacement.ID, t.ID)
+	}
+
+	return nil
+}
+
+// hasBroadcastReplacement returns true if a pending replacement of this tx has been broadcast
+func (t *Transaction) hasBroadcastReplacement(db *gorm.DB) bool {
+	var count int
+	db.Model(&Transaction{}).Where("replaces_id = ? AND status = ? AND broadcast_at IS NOT NULL", t.ID, "pending").Count(&count)
+	return count > 0
+}
+
+// resolveMinedReplacedTx finalizes the pending tx replaced by this tx if it was mined instead of
+// this replacement; once the replacement is broadcast, the receipt of the replaced tx is only
+// polled on behalf of its replacement. Returns true if the replaced tx was finalized, in which
+// case this replacement has been marked failed.
+func (t *Transaction) resolveMinedReplacedTx(db *gorm.DB, signer *TransactionSigner) bool {
+	if t.ReplacesID == nil {
+		return false
+	}
+
+	original := &Transaction{}
+	db.Where("id = ? AND status = ?", t.ReplacesID, "pending").Find(&original)
+	if original == nil || original.ID != *t.ReplacesID || original.Hash == nil {
+		return false
+	}
+
+	err := original.fetchReceipt(db, signer.Network, signer.Address())
+	if err != nil {
+		return false
+	}
+
+	common.Log.Debugf("replaced tx %s was mined before its replacement %s", original.ID, t.ID)
+	return original.finalizeReceipt(db, signer.Network)
+}
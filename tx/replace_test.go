@@ -0,0 +1,100 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tx
+
+import (
+	"math/big"
+	"testing"
+
+	sqlmock "github.com/DATA-DOG/go-sqlmock"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/internal/testutil"
+)
+
+func TestBumpFeeRoundsUp(t *testing.T) {
+	bumped := bumpFee(big.NewInt(1000000000), minTxReplacementFeeBumpPercent)
+	if bumped.Cmp(big.NewInt(1100000001)) != 0 {
+		t.Errorf("bumped fee %s; expected 1100000001", bumped)
+	}
+}
+
+func TestReplacementFeeRequiresMinimumBump(t *testing.T) {
+	fee := big.NewInt(1000000000)
+
+	_, err := replacementFee(map[string]interface{}{"max_fee_per_gas": "1050000000"}, "max_fee_per_gas", fee, defaultTxReplacementFeeBumpPercent)
+	if err == nil {
+		t.Error("expected replacement fee below the minimum bump to be rejected")
+	}
+
+	requested, err := replacementFee(map[string]interface{}{"max_fee_per_gas": "9007199254740993"}, "max_fee_per_gas", fee, defaultTxReplacementFeeBumpPercent)
+	if err != nil || requested.String() != "9007199254740993" {
+		t.Errorf("replacement fee %v (%v); expected the requested 9007199254740993", requested, err)
+	}
+
+	bumped, err := replacementFee(map[string]interface{}{}, "max_fee_per_gas", fee, defaultTxReplacementFeeBumpPercent)
+	if err != nil || bumped.Cmp(bumpFee(fee, defaultTxReplacementFeeBumpPercent)) != 0 {
+		t.Errorf("replacement fee %v (%v); expected the default bump", bumped, err)
+	}
+}
+
+func TestHasBroadcastReplacement(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	tx := &Transaction{}
+	tx.ID, _ = uuid.NewV4()
+
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "transactions" WHERE \(replaces_id = \$1 AND status = \$2 AND broadcast_at IS NOT NULL\)`).
+		WithArgs(tx.ID, "pending").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	if !tx.hasBroadcastReplacement(db) {
+		t.Error("expected tx with a broadcast pending replacement to be reported as replaced")
+	}
+
+	mock.ExpectQuery(`SELECT count\(\*\) FROM "transactions"`).
+		WithArgs(tx.ID, "pending").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+
+	if tx.hasBroadcastReplacement(db) {
+		t.Error("expected tx without a broadcast replacement to continue receipt polling")
+	}
+}
+
+func TestResolveMinedReplacedTxWithoutReplacedTx(t *testing.T) {
+	db, _ := testutil.NewMockDB(t)
+	tx := &Transaction{}
+	if tx.resolveMinedReplacedTx(db, nil) {
+		t.Error("expected tx which replaces no tx to not resolve a replaced tx")
+	}
+}
+
+func TestResolveMinedReplacedTxWhenReplacedTxFinalized(t *testing.T) {
+	db, mock := testutil.NewMockDB(t)
+	replacesID, _ := uuid.NewV4()
+	tx := &Transaction{ReplacesID: &replacesID}
+
+	// the replaced tx is no longer pending
+	mock.ExpectQuery(`SELECT \* FROM "transactions" WHERE \(id = \$1 AND status = \$2\)`).
+		WithArgs(replacesID, "pending").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+
+	if tx.resolveMinedReplacedTx(db, nil) {
+		t.Error("expected replaced tx which is no longer pending to not be resolved")
+	}
+}
@@ -0,0 +1,224 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tx
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/jinzhu/gorm"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/contract"
+	"github.com/provideplatform/nchain/network"
+)
+
+const revertErrorTypeError = "error"
+const revertErrorTypePanic = "panic"
+const revertErrorTypeCustom = "custom"
+const revertErrorTypeUnknown = "unknown"
+
+// revertSelectorError is the selector of the builtin Error(string) revert
+var revertSelectorError = []byte{0x08, 0xc3, 0x79, 0xa0}
+
+// revertSelectorPanic is the selector of the builtin Panic(uint256) revert
+var revertSelectorPanic = []byte{0x4e, 0x48, 0x7b, 0x71}
+
+// panicReasons maps solidity Panic(uint256) codes to a description of the failure
+var panicReasons = map[uint64]string{
+	0x00: "generic compiler inserted panic",
+	0x01: "assertion failed",
+	0x11: "arithmetic underflow or overflow",
+	0x12: "division or modulo by zero",
+	0x21: "enum overflow",
+	0x22: "invalid encoded storage byte array accessed",
+	0x31: "out-of-bounds array access; popping an empty array",
+	0x32: "out-of-bounds access of an array or bytesN",
+	0x41: "out of memory",
+	0x51: "uninitialized function",
+}
+
+// RevertError is the decoded revert of a failed tx
+type RevertError struct {
+	Type      string                 `json:"type"`                // error, panic, custom or unknown
+	Name      *string                `json:"name,omitempty"`      // name of the custom error, if any
+	Signature *string                `json:"signature,omitempty"` // canonical signature of the custom error, if any
+	Reason    *string                `json:"reason,omitempty"`    // decoded Error(string) or Panic(uint256) reason
+	Args      map[string]interface{} `json:"args,omitempty"`      // decoded custom error arguments
+	Data      string                 `json:"data"`                // raw revert data
+}
+
+// revertData returns the revert data carried by the given JSON-RPC error, if any
+func revertData(err error) []byte {
+	if dataErr, ok := err.(rpc.DataError); ok {
+		if data, dataOk := dataErr.ErrorData().(string); dataOk {
+			if decoded, err := hexutil.Decode(data); err == nil {
+				return decoded
+			}
+		}
+	}
+	return nil
+}
+
+// decodeRevertReason decodes the builtin Error(string) and Panic(uint256) reverts; nil is returned
+// if the revert data is empty or does not match either builtin
+func decodeRevertReason(data []byte) *string {
+	if len(data) < 4 {
+		return nil
+	}
+
+	var reason string
+	if bytes.Equal(data[0:4], revertSelectorError) {
+		unpacked, err := abi.UnpackRevert(data)
+		if err != nil {
+			return nil
+		}
+		reason = unpacked
+	} else if bytes.Equal(data[0:4], revertSelectorPanic) && len(data) >= 36 {
+		code := new(big.Int).SetBytes(data[4:36])
+		if desc, ok := panicReasons[code.Uint64()]; ok && code.IsUint64() {
+			reason = fmt.Sprintf("panic: %s (0x%x)", desc, code)
+		} else {
+			reason = fmt.Sprintf("panic: unknown code (0x%x)", code)
+		}
+	} else {
+		return nil
+	}
+
+	return &reason
+}
+
+// decodeRevert decodes the given revert data using the builtin Error(string) and Panic(uint256)
+// reverts and the given custom errors, keyed by selector
+func decodeRevert(data []byte, customErrors map[string]*contract.CustomError) *RevertError {
+	revert := &RevertError{
+		Type: revertErrorTypeUnknown,
+		Data: hexutil.Encode(data),
+	}
+
+	if len(data) < 4 {
+		return revert
+	}
+
+	if reason := decodeRevertReason(data); reason != nil {
+		revert.Reason = reason
+		if bytes.Equal(data[0:4], revertSelectorPanic) {
+			revert.Type = revertErrorTypePanic
+		} else {
+			revert.Type = revertErrorTypeError
+		}
+		return revert
+	}
+
+	if customErr, ok := customErrors[hex.EncodeToString(data[0:4])]; ok {
+		revert.Type = revertErrorTypeCustom
+		revert.Name = common.StringOrNil(customErr.Name)
+		revert.Signature = common.StringOrNil(customErr.Sig)
+
+		args, err := customErr.Unpack(data)
+		if err != nil {
+			common.Log.Debugf("failed to decode custom error revert data; %s", err.Error())
+		} else {
+			revert.Args = args
+		}
+	}
+
+	return revert
+}
+
+// String returns a description of the revert
+func (r *RevertError) String() string {
+	switch r.Type {
+	case revertErrorTypeError, revertErrorTypePanic:
+		return fmt.Sprintf("execution reverted: %s", *r.Reason)
+	case revertErrorTypeCustom:
+		if r.Args != nil {
+			args, _ := json.Marshal(r.Args)
+			return fmt.Sprintf("execution reverted: %s %s", *r.Signature, string(args))
+		}
+		return fmt.Sprintf("execution reverted: %s", *r.Signature)
+	}
+
+	if r.Reason != nil {
+		return fmt.Sprintf("execution reverted: %s", *r.Reason)
+	} else if r.Data == "0x" {
+		return "execution reverted"
+	}
+	return fmt.Sprintf("execution reverted: %s", r.Data)
+}
+
+// customErrors returns the custom errors declared by the contract at the tx recipient address, if any
+func (t *Transaction) customErrors(db *gorm.DB) map[string]*contract.CustomError {
+	c := t.GetContract(db)
+	if c == nil || c.Address == nil {
+		return nil
+	}
+
+	customErrors, err := c.ReadEthereumContractErrors()
+	if err != nil {
+		return nil
+	}
+	return customErrors
+}
+
+// revertReplayBlock returns the block against which a tx included in the given block is replayed;
+// eth_call executes against the post-state of the given block, so the parent block is used
+func revertReplayBlock(block *big.Int) *big.Int {
+	if block == nil || block.Sign() <= 0 {
+		return block
+	}
+	return new(big.Int).Sub(block, big.NewInt(1))
+}
+
+// resolveRevertError replays the failed tx using eth_call against the state of the parent of the
+// block in which it was included and decodes the resulting revert data
+func (t *Transaction) resolveRevertError(db *gorm.DB, ntwrk *network.Network, signerAddress string, block *big.Int) (*RevertError, error) {
+	client, err := ntwrk.EVMDialJSONRPC()
+	if err != nil {
+		return nil, err
+	}
+
+	params := t.ParseParams()
+	gas, _ := params["gas"].(float64)
+
+	msg := t.asEthereumCallMsg(signerAddress, 0, uint64(gas))
+	msg.GasPrice = nil
+
+	replayBlock := revertReplayBlock(block)
+	_, err = client.CallContract(context.TODO(), msg, replayBlock)
+	if err == nil {
+		// the call does not revert when replayed (i.e., the tx ran out of gas or depended on state within its block)
+		return nil, fmt.Errorf("failed tx %s did not revert when replayed at block %s", *t.Hash, replayBlock)
+	}
+
+	data := revertData(err)
+	if data == nil {
+		return &RevertError{
+			Type:   revertErrorTypeUnknown,
+			Reason: common.StringOrNil(err.Error()),
+			Data:   "0x",
+		}, nil
+	}
+
+	return decodeRevert(data, t.customErrors(db)), nil
+}
@@ -0,0 +1,114 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tx
+
+import (
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/provideplatform/nchain/contract"
+)
+
+func mustABIType(t *testing.T, typ string) abi.Type {
+	abiType, err := abi.NewType(typ, "", nil)
+	if err != nil {
+		t.Fatalf("failed to parse abi type: %s; %s", typ, err.Error())
+	}
+	return abiType
+}
+
+func revertErrorData(t *testing.T, reason string) []byte {
+	packed, err := abi.Arguments{{Type: mustABIType(t, "string")}}.Pack(reason)
+	if err != nil {
+		t.Fatalf("failed to pack revert reason; %s", err.Error())
+	}
+	return append(append([]byte{}, revertSelectorError...), packed...)
+}
+
+func revertPanicData(code uint64) []byte {
+	return append(append([]byte{}, revertSelectorPanic...), abiWord(new(big.Int).SetUint64(code))...)
+}
+
+func abiWord(i *big.Int) []byte {
+	buf := make([]byte, 32)
+	return i.FillBytes(buf)
+}
+
+func TestDecodeRevertError(t *testing.T) {
+	revert := decodeRevert(revertErrorData(t, "insufficient balance"), nil)
+	if revert.Type != revertErrorTypeError || revert.Reason == nil || *revert.Reason != "insufficient balance" {
+		t.Errorf("unexpected decoded revert: %+v", revert)
+	}
+	if revert.String() != "execution reverted: insufficient balance" {
+		t.Errorf("revert description %s", revert.String())
+	}
+}
+
+func TestDecodeRevertPanic(t *testing.T) {
+	revert := decodeRevert(revertPanicData(0x11), nil)
+	if revert.Type != revertErrorTypePanic || revert.Reason == nil || *revert.Reason != "panic: arithmetic underflow or overflow (0x11)" {
+		t.Errorf("unexpected decoded revert: %+v", revert)
+	}
+
+	revert = decodeRevert(revertPanicData(0x99), nil)
+	if revert.Reason == nil || *revert.Reason != "panic: unknown code (0x99)" {
+		t.Errorf("unexpected decoded revert: %+v", revert)
+	}
+}
+
+func TestDecodeRevertCustomError(t *testing.T) {
+	customErr := contract.NewCustomError("InsufficientAllowance", abi.Arguments{
+		{Name: "spender", Type: mustABIType(t, "address")},
+		{Name: "needed", Type: mustABIType(t, "uint256")},
+	})
+
+	spender := make([]byte, 32)
+	spender[31] = 0x01
+	data := append(append(append([]byte{}, customErr.ID...), spender...), abiWord(big.NewInt(42))...)
+
+	revert := decodeRevert(data, map[string]*contract.CustomError{
+		hex.EncodeToString(customErr.ID): customErr,
+	})
+
+	if revert.Type != revertErrorTypeCustom || revert.Name == nil || *revert.Name != "InsufficientAllowance" {
+		t.Fatalf("unexpected decoded revert: %+v", revert)
+	}
+	if *revert.Signature != "InsufficientAllowance(address,uint256)" {
+		t.Errorf("custom error signature %s", *revert.Signature)
+	}
+	if needed, ok := revert.Args["needed"].(*big.Int); !ok || needed.Int64() != 42 {
+		t.Errorf("custom error args %v", revert.Args)
+	}
+}
+
+func TestDecodeRevertUnknown(t *testing.T) {
+	revert := decodeRevert(hexutil.MustDecode("0xdeadbeef"), nil)
+	if revert.Type != revertErrorTypeUnknown || revert.String() != "execution reverted: 0xdeadbeef" {
+		t.Errorf("unexpected decoded revert: %+v", revert)
+	}
+
+	revert = decodeRevert([]byte{}, nil)
+	if revert.String() != "execution reverted" {
+		t.Errorf("revert description %s", revert.String())
+	}
+}
@@ -30,6 +30,7 @@ import (
 	"github.com/nats-io/nats.go"
 	"github.com/provideplatform/nchain/common"
 	"github.com/provideplatform/nchain/contract"
+	"github.com/provideplatform/nchain/deadletter"
 	"github.com/provideplatform/nchain/token"
 	provide "github.com/provideplatform/provide-go/api"
 )
@@ -130,31 +131,31 @@ func consumeShuttleContractDeployedMsg(msg *nats.Msg) {
 
 	if !addressOk {
 		common.Log.Warning("failed to handle shuttle.contract.deployed message; contract address required")
-		msg.Term()
+		deadletter.Term(msg, "failed to handle shuttle.contract.deployed message; contract address required")
 		return
 	}
 
 	if !byOk {
 		common.Log.Warning("failed to handle shuttle.contract.deployed message; by address required")
-		msg.Term()
+		deadletter.Term(msg, "failed to handle shuttle.contract.deployed message; by address required")
 		return
 	}
 
 	if !networkIDOk {
 		common.Log.Warning("failed to handle shuttle.contract.deployed message; contract network_id required")
-		msg.Term()
+		deadletter.Term(msg, "failed to handle shuttle.contract.deployed message; contract network_id required")
 		return
 	}
 
 	if !nameOk {
 		common.Log.Warning("failed to handle shuttle.contract.deployed message; contract name required")
-		msg.Term()
+		deadletter.Term(msg, "failed to handle shuttle.contract.deployed message; contract name required")
 		return
 	}
 
 	if !txHashOk {
 		common.Log.Warning("failed to handle shuttle.contract.deployed message; tx hash required")
-		msg.Term()
+		deadletter.Term(msg, "failed to handle shuttle.contract.deployed message; tx hash required")
 		return
 	}
 
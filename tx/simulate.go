@@ -0,0 +1,262 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package tx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	ethcommon "github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/contract"
+	provide "github.com/provideplatform/provide-go/api"
+	providecrypto "github.com/provideplatform/provide-go/crypto"
+)
+
+// Simulation is the result of a tx which was executed using eth_call and eth_estimateGas
+// without being signed or broadcast
+type Simulation struct {
+	From         string       `json:"from"`
+	Success      bool         `json:"success"`
+	Response     interface{}  `json:"response,omitempty"`      // decoded return values, when the ABI method is known
+	ReturnData   *string      `json:"return_data,omitempty"`   // raw return data, or raw revert data when the call reverted
+	RevertReason *string      `json:"revert_reason,omitempty"` // decoded revert reason, when the call reverted
+	RevertError  *RevertError `json:"revert_error,omitempty"`  // decoded revert, including custom errors declared in the contract ABI
+	Error        *string      `json:"error,omitempty"`
+
+	// Fee estimation
+	Gas                  *uint64  `json:"gas,omitempty"`
+	Type                 uint8    `json:"type"`
+	GasPrice             *big.Int `json:"gas_price,omitempty"`
+	BaseFeePerGas        *big.Int `json:"base_fee_per_gas,omitempty"`
+	MaxFeePerGas         *big.Int `json:"max_fee_per_gas,omitempty"`
+	MaxPriorityFeePerGas *big.Int `json:"max_priority_fee_per_gas,omitempty"`
+	EstimatedFee         *big.Int `json:"estimated_fee,omitempty"` // gas * (base fee + priority fee) or gas * gas price, in wei
+	MaxFee               *big.Int `json:"max_fee,omitempty"`       // gas * max fee per gas, in wei
+}
+
+// simulate runs the validation and signer resolution performed when the tx is created, and
+// executes the tx using eth_call and eth_estimateGas instead of signing and broadcasting it;
+// when abiMethod is nil, it is resolved from the contract at the tx recipient address, if any
+func (t *Transaction) simulate(db *gorm.DB, abiMethod *abi.Method) (*Simulation, error) {
+	if !t.Validate() {
+		return nil, errors.New("failed to simulate invalid tx")
+	}
+
+	signer, err := t.signerFactory(db)
+	if err != nil {
+		t.Errors = append(t.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+		return nil, err
+	}
+
+	if !signer.Network.IsEthereumNetwork() {
+		return nil, fmt.Errorf("tx simulation not supported by network %s", signer.Network.ID)
+	}
+
+	client, err := signer.Network.EVMDialJSONRPC()
+	if err != nil {
+		return nil, err
+	}
+
+	params := t.ParseParams()
+	gas, _ := params["gas"].(float64)
+
+	simulation := &Simulation{
+		From: signer.Address(),
+	}
+
+	msg := t.asEthereumCallMsg(simulation.From, 0, uint64(gas))
+	msg.GasPrice = nil
+
+	result, err := client.CallContract(context.TODO(), msg, nil)
+	if err != nil {
+		simulation.Error = common.StringOrNil(err.Error())
+		if data := revertData(err); data != nil {
+			simulation.ReturnData = common.StringOrNil(hexutil.Encode(data))
+			simulation.RevertError = decodeRevert(data, t.customErrors(db))
+			simulation.RevertReason = simulation.RevertError.Reason
+			if simulation.RevertReason == nil && simulation.RevertError.Signature != nil {
+				simulation.RevertReason = simulation.RevertError.Signature
+			}
+		}
+		return simulation, nil
+	}
+
+	simulation.Success = true
+	simulation.ReturnData = common.StringOrNil(hexutil.Encode(result))
+
+	if abiMethod == nil {
+		abiMethod = t.resolveABIMethod(db)
+	}
+
+	if abiMethod != nil && len(abiMethod.Outputs) > 0 {
+		outptr, err := abiMethod.Outputs.UnpackValues(result)
+		if err != nil {
+			common.Log.Debugf("failed to unpack simulated tx return data for method: %s; %s", abiMethod.Name, err.Error())
+		} else if len(outptr) == 1 {
+			simulation.Response = outptr[0]
+		} else {
+			simulation.Response = outptr
+		}
+	}
+
+	msg.Gas = 0
+	estimatedGas, err := client.EstimateGas(context.TODO(), msg)
+	if err != nil {
+		simulation.Success = false
+		simulation.Error = common.StringOrNil(fmt.Sprintf("failed to estimate gas; %s", err.Error()))
+		return simulation, nil
+	}
+
+	if gas > 0 {
+		if uint64(gas) < estimatedGas {
+			simulation.Success = false
+			simulation.Error = common.StringOrNil(fmt.Sprintf("provided gas %d is less than estimated gas %d", uint64(gas), estimatedGas))
+		}
+		estimatedGas = uint64(gas)
+	}
+	simulation.Gas = &estimatedGas
+
+	err = simulation.estimateFee(signer, params)
+	if err != nil {
+		common.Log.Warningf("failed to estimate fee for simulated tx; %s", err.Error())
+	}
+
+	return simulation, nil
+}
+
+// estimateFee populates the fee estimate of the simulation using the tx type which would be signed
+func (s *Simulation) estimateFee(signer *TransactionSigner, params map[string]interface{}) error {
+	gas := new(big.Int).SetUint64(*s.Gas)
+
+	dynamicFee, baseFee, err := signer.resolveTxType(params)
+	if err != nil {
+		return err
+	}
+
+	if dynamicFee {
+		maxPriorityFeePerGas := parseBigIntParam(params, "max_priority_fee_per_gas")
+		if maxPriorityFeePerGas == nil {
+			maxPriorityFeePerGas = suggestMaxPriorityFeePerGas(signer.Network)
+		}
+
+		maxFeePerGas := parseBigIntParam(params, "max_fee_per_gas")
+		if maxFeePerGas == nil {
+			maxFeePerGas = new(big.Int).Add(new(big.Int).Mul(baseFee, big.NewInt(2)), maxPriorityFeePerGas)
+		}
+
+		effectiveGasPrice := new(big.Int).Add(baseFee, maxPriorityFeePerGas)
+		if effectiveGasPrice.Cmp(maxFeePerGas) > 0 {
+			effectiveGasPrice = maxFeePerGas
+		}
+
+		s.Type = txTypeDynamicFee
+		s.BaseFeePerGas = baseFee
+		s.MaxFeePerGas = maxFeePerGas
+		s.MaxPriorityFeePerGas = maxPriorityFeePerGas
+		s.EstimatedFee = new(big.Int).Mul(gas, effectiveGasPrice)
+		s.MaxFee = new(big.Int).Mul(gas, maxFeePerGas)
+		return nil
+	}
+
+	gasPrice := parseBigIntParam(params, "gas_price")
+	if gasPrice == nil {
+		client, err := signer.Network.EVMDialJSONRPC()
+		if err != nil {
+			return err
+		}
+
+		gasPrice, err = client.SuggestGasPrice(context.TODO())
+		if err != nil {
+			return fmt.Errorf("failed to suggest gas price; %s", err.Error())
+		}
+	}
+
+	s.Type = txTypeLegacy
+	s.GasPrice = gasPrice
+	s.EstimatedFee = new(big.Int).Mul(gas, gasPrice)
+	s.MaxFee = s.EstimatedFee
+	return nil
+}
+
+// resolveABIMethod resolves the ABI method invoked by the tx calldata using the contract at
+// the tx recipient address, or nil if the contract or method cannot be resolved
+func (t *Transaction) resolveABIMethod(db *gorm.DB) *abi.Method {
+	if t.To == nil || t.Data == nil {
+		return nil
+	}
+
+	data := ethcommon.FromHex(*t.Data)
+	if len(data) < 4 {
+		return nil
+	}
+
+	c := t.GetContract(db)
+	if c == nil || c.Address == nil {
+		return nil
+	}
+
+	_abi, err := c.ReadEthereumContractAbi()
+	if err != nil {
+		return nil
+	}
+
+	method, err := _abi.MethodById(data[0:4])
+	if err != nil {
+		return nil
+	}
+
+	return method
+}
+
+// simulateExecution simulates the given contract execution without broadcasting a tx
+func simulateExecution(c *contract.Contract, execution *contract.Execution) (*Simulation, error) {
+	db := dbconf.DatabaseConnection()
+	tx := executionTxFactory(c, execution)
+
+	_abi, err := c.ReadEthereumContractAbi()
+	if err != nil {
+		return nil, fmt.Errorf("failed to simulate contract method %s on contract: %s; no ABI resolved: %s", execution.Method, c.ID, err.Error())
+	}
+
+	abiMethod, methodDescriptor := resolveExecutionMethod(_abi, execution.Method)
+	if abiMethod == nil {
+		return nil, fmt.Errorf("failed to simulate method %s on contract: %s; method not found in ABI", methodDescriptor, c.ID)
+	}
+
+	invocationSig, err := providecrypto.EVMEncodeABI(abiMethod, execution.Params...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %d parameters prior to simulating %s on contract: %s; %s", len(execution.Params), methodDescriptor, c.ID, err.Error())
+	}
+
+	data := fmt.Sprintf("0x%s", ethcommon.Bytes2Hex(invocationSig))
+	tx.Data = &data
+
+	simulation, err := tx.simulate(db, abiMethod)
+	if err != nil && len(tx.Errors) > 0 && tx.Errors[0].Message != nil {
+		return nil, fmt.Errorf("%s; %s", err.Error(), *tx.Errors[0].Message)
+	}
+	return simulation, err
+}
@@ -0,0 +1,56 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+package tx
+
+import (
+	"math/rand"
+	"time"
+
+	dbconf "github.com/kthomas/go-db-config"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/network"
+)
+
+// deferThrottledMsg delays redelivery of the given message and returns true if the JSON-RPC
+// rate limit of the given network has been reached; a message which is not redelivered again
+// before it exhausts the given max deliveries is handled anyway, in which case its JSON-RPC
+// calls wait on the rate limit
+func deferThrottledMsg(msg *nats.Msg, networkID uuid.UUID, maxDeliveries int) bool {
+	if meta, err := msg.Metadata(); err == nil && meta.NumDelivered+1 >= uint64(maxDeliveries) {
+		return false
+	}
+
+	ntwrk := &network.Network{}
+	dbconf.DatabaseConnection().Where("id = ?", networkID).Find(&ntwrk)
+	if ntwrk == nil || ntwrk.ID == uuid.Nil {
+		return false
+	}
+
+	delay := ntwrk.RPCThrottleDelay()
+	if delay == 0 {
+		return false
+	}
+
+	// spread the redeliveries so the replicas do not all retry at once
+	delay += time.Duration(rand.Int63n(int64(delay) + 1))
+
+	ntwrk.RecordRPCThrottleDelay()
+	common.Log.Debugf("JSON-RPC rate limit reached on network: %s; delaying NATS message on subject: %s for %v", networkID, msg.Subject, delay)
+	common.NakWithDelay(msg, delay)
+	return true
+}
@@ -37,8 +37,10 @@ import (
 	"github.com/provideplatform/nchain/common"
 	"github.com/provideplatform/nchain/contract"
 	"github.com/provideplatform/nchain/network"
+	"github.com/provideplatform/nchain/policy"
 	"github.com/provideplatform/nchain/token"
 	"github.com/provideplatform/nchain/wallet"
+	"github.com/provideplatform/nchain/webhook"
 	provide "github.com/provideplatform/provide-go/api"
 	provideapi "github.com/provideplatform/provide-go/api/nchain"
 	vault "github.com/provideplatform/provide-go/api/vault"
@@ -85,21 +87,45 @@ type Transaction struct {
 	Ref         *string          `json:"ref"`
 	Description *string          `json:"description"`
 
+	// Pending tx replaced (i.e., sped up or cancelled) by this tx using the same nonce, if any
+	ReplacesID *uuid.UUID `sql:"type:uuid" json:"replaces_id,omitempty"`
+
+	// Decoded revert of a tx which was included in a block but failed, if any; see RevertError
+	RevertError *json.RawMessage `sql:"type:json" json:"revert_error,omitempty"`
+
+	// Approval rule which the tx matched, if any; the tx is not signed until it is approved; see Approval
+	ApprovalRuleID *uuid.UUID       `sql:"type:uuid" json:"approval_rule_id,omitempty"`
+	PendingParams  *json.RawMessage `sql:"type:json" json:"-"` // params of a tx held for approval or queued in a batch, used to sign the tx once it is published
+
+	// Batch in which the tx was submitted, if any; see Batch
+	BatchID *uuid.UUID `sql:"type:uuid" json:"batch_id,omitempty"`
+
 	// Ephemeral fields for managing the tx/rx and tracing lifecycles
 	Response  *contract.ExecutionResponse `sql:"-" json:"-"`
 	SignedTx  interface{}                 `sql:"-" json:"-"`
 	Traces    interface{}                 `sql:"-" json:"traces,omitempty"`
 	Signature *string                     `sql:"-" json:"signature,omitempty"`
 
+	nonceReservation     *nonceReservation    // nonce reserved on behalf of the signer, if any; released if the tx is never broadcast
+	policyEvaluated      bool                 // true once the signing policies which apply to the tx allowed it; see enforcePolicies
+	held                 bool                 // true if the tx was persisted before it was signed (i.e., held for approval or queued in a batch)
+	approved             bool                 // true if the tx was approved by the approvers of the approval rule it matched
+	approvalRule         *policy.ApprovalRule // approval rule which the tx matched, if any; see requiresApproval
+	approvalRuleResolved bool
+	retryable            bool // true if the tx was not created due to an error which may not recur (i.e., a db error)
+
 	// Transaction metadata/instrumentation
-	Block          *uint64    `json:"block"`
-	BlockTimestamp *time.Time `json:"block_timestamp,omitempty"`                       // timestamp when the tx was finalized on-chain, according to its tx receipt
-	BroadcastAt    *time.Time `json:"broadcast_at,omitempty"`                          // timestamp when the tx was broadcast to the network
-	FinalizedAt    *time.Time `json:"finalized_at,omitempty"`                          // timestamp when the tx was finalized on-platform
-	PublishedAt    *time.Time `json:"published_at,omitempty"`                          // timestamp when the tx was published to NATS cluster
-	QueueLatency   *uint64    `json:"queue_latency,omitempty"`                         // broadcast_at - published_at (in millis) -- the amount of time between when a message is enqueued to the NATS broker and when it is broadcast to the network
-	NetworkLatency *uint64    `json:"network_latency,omitempty"`                       // finalized_at - broadcast_at (in millis) -- the amount of time between when a message is broadcast to the network and when it is finalized on-chain
-	E2ELatency     *uint64    `gorm:"column:e2e_latency" json:"e2e_latency,omitempty"` // finalized_at - published_at (in millis) -- the amount of time between when a message is published to the NATS broker and when it is finalized on-chain
+	Block             *uint64    `json:"block"`
+	BlockHash         *string    `json:"block_hash,omitempty"`                            // hash of the block in which the tx was included; used to roll back txs included in blocks orphaned by a reorg
+	EffectiveGasPrice *TxValue   `sql:"type:text" json:"effective_gas_price,omitempty"`   // gas price paid per unit of gas, according to its tx receipt
+	GasUsed           *uint64    `json:"gas_used,omitempty"`                              // gas used by the tx, according to its tx receipt
+	BlockTimestamp    *time.Time `json:"block_timestamp,omitempty"`                       // timestamp when the tx was finalized on-chain, according to its tx receipt
+	BroadcastAt       *time.Time `json:"broadcast_at,omitempty"`                          // timestamp when the tx was broadcast to the network
+	FinalizedAt       *time.Time `json:"finalized_at,omitempty"`                          // timestamp when the tx was finalized on-platform
+	PublishedAt       *time.Time `json:"published_at,omitempty"`                          // timestamp when the tx was published to NATS cluster
+	QueueLatency      *uint64    `json:"queue_latency,omitempty"`                         // broadcast_at - published_at (in millis) -- the amount of time between when a message is enqueued to the NATS broker and when it is broadcast to the network
+	NetworkLatency    *uint64    `json:"network_latency,omitempty"`                       // finalized_at - broadcast_at (in millis) -- the amount of time between when a message is broadcast to the network and when it is finalized on-chain
+	E2ELatency        *uint64    `gorm:"column:e2e_latency" json:"e2e_latency,omitempty"` // finalized_at - published_at (in millis) -- the amount of time between when a message is published to the NATS broker and when it is finalized on-chain
 }
 
 // TransactionSigner is either an account or HD wallet; implements the Signer interface
@@ -139,6 +165,28 @@ func (txs *TransactionSigner) Address() string {
 
 // GetSignerDetails gets the address and derivation path used for wallet signing
 func (txs *TransactionSigner) GetSignerDetails() (address, derivationPath *string, err error) {
+	return txs.custodian().signerDetails()
+}
+
+// signer returns the Signer which signs txs using the key material of the underlying account
+// or HD wallet; a KeystoreSigner is returned when the key material is held in the local keystore
+func (txs *TransactionSigner) signer() Signer {
+	if keystoreSigner, ok := txs.custodian().(*KeystoreSigner); ok {
+		return keystoreSigner
+	}
+	return txs
+}
+
+// custodian returns the digestSigner which holds the key material of the underlying account or HD wallet
+func (txs *TransactionSigner) custodian() digestSigner {
+	if (txs.Account != nil && txs.Account.UsesKeystore()) || (txs.Wallet != nil && txs.Wallet.UsesKeystore()) {
+		return &KeystoreSigner{txs}
+	}
+	return txs
+}
+
+// signerDetails resolves the address and derivation path used for wallet signing using vault
+func (txs *TransactionSigner) signerDetails() (address, derivationPath *string, err error) {
 
 	// first check if we have a provided path
 	// we'll ignore the other branch (no hd wallet path for the moment TODO)
@@ -186,8 +234,68 @@ func (txs *TransactionSigner) GetSignerDetails() (address, derivationPath *strin
 	return address, derivationPath, nil
 }
 
-// Sign implements the Signer interface
+// Sign implements the Signer interface using the key material held in vault
 func (txs *TransactionSigner) Sign(tx *Transaction) (signedTx interface{}, hash []byte, err error) {
+	return txs.sign(tx, txs)
+}
+
+// signAccountDigest signs the given digest using the account key held in vault
+func (txs *TransactionSigner) signAccountDigest(digest []byte) ([]byte, error) {
+	if txs.Account == nil || txs.Account.VaultID == nil || txs.Account.KeyID == nil {
+		return nil, errors.New("account key material not held in vault")
+	}
+
+	sig, err := vault.SignMessage(
+		util.DefaultVaultAccessJWT,
+		txs.Account.VaultID.String(),
+		txs.Account.KeyID.String(),
+		fmt.Sprintf("%x", digest),
+		map[string]interface{}{},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return hex.DecodeString(*sig.Signature)
+}
+
+// signWalletDigest signs the given digest using the HD wallet key held in vault, derived at
+// the given derivation path, if any
+func (txs *TransactionSigner) signWalletDigest(derivationPath *string, digest []byte) ([]byte, error) {
+	if txs.Wallet == nil || txs.Wallet.VaultID == nil || txs.Wallet.KeyID == nil {
+		return nil, errors.New("HD wallet key material not held in vault")
+	}
+
+	// if we were provided, or have generated, a hd derivation path, pass it to the signer
+	opts := map[string]interface{}{}
+	if derivationPath != nil {
+		opts = map[string]interface{}{
+			"hdwallet": map[string]interface{}{
+				"hd_derivation_path": derivationPath,
+			},
+		}
+	}
+
+	common.Log.Debugf("vault to sign tx... hash: %s", fmt.Sprintf("%x", digest))
+	//check if the hash is actually hex. not sure if it is, it looks like bytes returned from the signer function
+	// TODO sometimes the db stores the raw hash (no 0x prefix), not the tx hash (0x prefix) - investigate why this occurs
+
+	sig, err := vault.SignMessage(
+		util.DefaultVaultAccessJWT,
+		txs.Wallet.VaultID.String(),
+		txs.Wallet.KeyID.String(),
+		fmt.Sprintf("%x", digest),
+		opts,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return hex.DecodeString(*sig.Signature)
+}
+
+// sign the given tx using the key material held by the given custodian
+func (txs *TransactionSigner) sign(tx *Transaction, custodian digestSigner) (signedTx interface{}, hash []byte, err error) {
 	if tx == nil {
 		err := errors.New("cannot sign nil transaction payload")
 		common.Log.Warning(err.Error())
@@ -220,16 +328,75 @@ func (txs *TransactionSigner) Sign(tx *Transaction) (signedTx interface{}, hash
 			nonce = &nonceUint
 		}
 
+		defer func() {
+			if err != nil {
+				tx.releaseNonce()
+			}
+		}()
+
+		var privateParams *privateTxParams
+		privateParams, err = parsePrivateTxParams(params)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var dynamicFee bool
+		var baseFee *big.Int
+		if privateParams == nil {
+			// private txs are always signed as homestead txs
+			dynamicFee, baseFee, err = txs.resolveTxType(params)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+
 		var signer types.Signer
 		var _tx *types.Transaction
+		var _dtx *dynamicFeeTx
+		var _ptx *privateTx
+
+		// txFactory builds the unsigned tx for the given sender and returns its signing hash
+		txFactory := func(from string) (hash []byte, err error) {
+			if privateParams != nil {
+				_ptx, hash, err = privateTxFactory(
+					txs.Network,
+					from,
+					tx.To,
+					tx.Data,
+					tx.Value.BigInt(),
+					nonce,
+					uint64(gas),
+					gasPrice,
+					privateParams,
+				)
+				return hash, err
+			}
 
-		if txs.Account != nil && txs.Account.VaultID != nil && txs.Account.KeyID != nil {
-			// we are using an account to sign the transaction
+			if dynamicFee {
+				_dtx, hash, err = dynamicFeeTxFactory(
+					txs.Network,
+					from,
+					tx.To,
+					tx.Data,
+					tx.Value.BigInt(),
+					nonce,
+					uint64(gas),
+					parseBigIntParam(params, "max_fee_per_gas"),
+					parseBigIntParam(params, "max_priority_fee_per_gas"),
+					baseFee,
+				)
+				return hash, err
+			}
+
+			rpcClientKey, rpcURL, err := txs.Network.RPCEndpoint()
+			if err != nil {
+				return nil, err
+			}
 
 			signer, _tx, hash, err = providecrypto.EVMTxFactory(
-				txs.Network.ID.String(),
-				txs.Network.RPCURL(),
-				txs.Account.Address,
+				rpcClientKey,
+				rpcURL,
+				from,
 				tx.To,
 				tx.Data,
 				tx.Value.BigInt(),
@@ -237,33 +404,42 @@ func (txs *TransactionSigner) Sign(tx *Transaction) (signedTx interface{}, hash
 				uint64(gas),
 				gasPrice,
 			)
-			if err != nil {
-				err = fmt.Errorf("failed to sign transaction using signing account %s; %s", txs.Account.Address, err.Error())
-				common.Log.Warning(err.Error())
-				return nil, nil, err
+			return hash, err
+		}
+
+		// withSignature returns the tx built by txFactory with the given signature applied
+		withSignature := func(sig []byte) (interface{}, error) {
+			if _dtx != nil {
+				return _dtx.withSignature(sig)
+			}
+			if _ptx != nil {
+				return _ptx.withSignature(sig)
 			}
+			return _tx.WithSignature(signer, sig)
+		}
 
-			sig, err := vault.SignMessage(
-				util.DefaultVaultAccessJWT,
-				txs.Account.VaultID.String(),
-				txs.Account.KeyID.String(),
-				fmt.Sprintf("%x", hash),
-				map[string]interface{}{},
-			)
+		if txs.Account != nil && (txs.Account.UsesKeystore() || (txs.Account.VaultID != nil && txs.Account.KeyID != nil)) {
+			// we are using an account to sign the transaction
+
+			if nonce == nil {
+				nonce = txs.reserveNonce(tx, txs.Account.Address)
+			}
+
+			hash, err = txFactory(txs.Account.Address)
 			if err != nil {
 				err = fmt.Errorf("failed to sign transaction using signing account %s; %s", txs.Account.Address, err.Error())
 				common.Log.Warning(err.Error())
 				return nil, nil, err
 			}
 
-			_sig, err := hex.DecodeString(*sig.Signature)
+			_sig, err := custodian.signAccountDigest(hash)
 			if err != nil {
 				err = fmt.Errorf("failed to sign transaction using signing account %s; %s", txs.Account.Address, err.Error())
 				common.Log.Warning(err.Error())
 				return nil, nil, err
 			}
 
-			signedTx, err = _tx.WithSignature(signer, _sig)
+			signedTx, err = withSignature(_sig)
 			if err != nil {
 				err = fmt.Errorf("failed to sign transaction using signing account %s; %s", txs.Account.Address, err.Error())
 				common.Log.Warning(err.Error())
@@ -271,8 +447,10 @@ func (txs *TransactionSigner) Sign(tx *Transaction) (signedTx interface{}, hash
 			}
 
 			if err == nil {
-				signedTxJSON, _ := signedTx.(*types.Transaction).MarshalJSON()
-				common.Log.Debugf("signed eth tx: %s", signedTxJSON)
+				if signedEthTx, ok := signedTx.(*types.Transaction); ok {
+					signedTxJSON, _ := signedEthTx.MarshalJSON()
+					common.Log.Debugf("signed eth tx: %s", signedTxJSON)
+				}
 
 				accessedAt := time.Now()
 				go func() {
@@ -282,7 +460,7 @@ func (txs *TransactionSigner) Sign(tx *Transaction) (signedTx interface{}, hash
 			}
 		}
 
-		if txs.Wallet != nil && txs.Wallet.VaultID != nil && txs.Wallet.KeyID != nil {
+		if txs.Wallet != nil && (txs.Wallet.UsesKeystore() || (txs.Wallet.VaultID != nil && txs.Wallet.KeyID != nil)) {
 			// we are using a wallet to sign the transaction
 			// it can include a derivation path to generate a specific address
 			// or with no derivation path, signs using the default address from the hd wallet
@@ -290,64 +468,31 @@ func (txs *TransactionSigner) Sign(tx *Transaction) (signedTx interface{}, hash
 			// which returns a derivation path, which we can use for the transaction creation,
 			// but that seems a little hacky
 
-			txAddress, txDerivationPath, err := txs.GetSignerDetails()
+			txAddress, txDerivationPath, err := custodian.signerDetails()
 			if err != nil {
 				return nil, nil, err
 			}
 
-			signer, _tx, hash, err = providecrypto.EVMTxFactory(
-				txs.Network.ID.String(),
-				txs.Network.RPCURL(),
-				*txAddress,
-				tx.To,
-				tx.Data,
-				tx.Value.BigInt(),
-				nonce,
-				uint64(gas),
-				gasPrice,
-			)
-
-			if err != nil {
-				err = fmt.Errorf("failed to sign %d-byte transaction payload using hardened account for HD wallet: %s; %s", len(hash), txs.Wallet.ID, err.Error())
-				common.Log.Warning(err.Error())
-				return nil, nil, err
+			if nonce == nil {
+				nonce = txs.reserveNonce(tx, *txAddress)
 			}
 
-			// if we were provided, or have generated, a hd derivation path, pass it to the signer
-			opts := map[string]interface{}{}
-			if txDerivationPath != nil {
-				opts = map[string]interface{}{
-					"hdwallet": map[string]interface{}{
-						"hd_derivation_path": txDerivationPath,
-					},
-				}
-			}
+			hash, err = txFactory(*txAddress)
 
-			common.Log.Debugf("vault to sign tx... hash: %s", fmt.Sprintf("%x", hash))
-			//check if the hash is actually hex. not sure if it is, it looks like bytes returned from the signer function
-			// TODO sometimes the db stores the raw hash (no 0x prefix), not the tx hash (0x prefix) - investigate why this occurs
-
-			sig, err := vault.SignMessage(
-				util.DefaultVaultAccessJWT,
-				txs.Wallet.VaultID.String(),
-				txs.Wallet.KeyID.String(),
-				fmt.Sprintf("%x", hash),
-				opts,
-			)
 			if err != nil {
-				err = fmt.Errorf("failed to sign transaction using hardened account for HD wallet: %s; %s", txs.Wallet.ID, err.Error())
+				err = fmt.Errorf("failed to sign %d-byte transaction payload using hardened account for HD wallet: %s; %s", len(hash), txs.Wallet.ID, err.Error())
 				common.Log.Warning(err.Error())
 				return nil, nil, err
 			}
 
-			_sig, err := hex.DecodeString(*sig.Signature)
+			_sig, err := custodian.signWalletDigest(txDerivationPath, hash)
 			if err != nil {
 				err = fmt.Errorf("failed to sign transaction using hardened account for HD wallet: %s; %s", txs.Wallet.ID, err.Error())
 				common.Log.Warning(err.Error())
 				return nil, nil, err
 			}
 
-			signedTx, err = _tx.WithSignature(signer, _sig)
+			signedTx, err = withSignature(_sig)
 			if err != nil {
 				err = fmt.Errorf("failed to sign transaction payload using hardened account for HD wallet: %s; %s", txs.Wallet.ID, err.Error())
 				common.Log.Warning(err.Error())
@@ -363,17 +508,7 @@ func (txs *TransactionSigner) Sign(tx *Transaction) (signedTx interface{}, hash
 				return nil, nil, err
 			}
 
-			signer, _tx, hash, err = providecrypto.EVMTxFactory(
-				txs.Network.ID.String(),
-				txs.Network.RPCURL(),
-				*txAddress,
-				tx.To,
-				tx.Data,
-				tx.Value.BigInt(),
-				nonce,
-				uint64(gas),
-				gasPrice,
-			)
+			hash, err = txFactory(*txAddress)
 
 			if err != nil {
 				err = fmt.Errorf("failed to initialize signed transaction payload; %s", err.Error())
@@ -388,13 +523,19 @@ func (txs *TransactionSigner) Sign(tx *Transaction) (signedTx interface{}, hash
 				return nil, nil, err
 			}
 
-			signedTx, err = _tx.WithSignature(signer, _sig)
+			signedTx, err = withSignature(_sig)
 			if err != nil {
 				err = fmt.Errorf("failed to initialize transaction using given %d-byte signature; %s", len(_sig), err.Error())
 				common.Log.Warning(err.Error())
 				return nil, nil, err
 			}
 		}
+	} else if txs.Network.IsBcoinNetwork() {
+		return txs.signBcoinTx(tx, custodian)
+	} else if txs.Network.IsBaseledgerNetwork() {
+		return txs.signBaseledgerTx(tx, custodian)
+	} else if txs.Network.IsHyperledgerFabricNetwork() {
+		return txs.signFabricTx(tx, custodian)
 	} else {
 		return nil, nil, fmt.Errorf("unable to generate signed tx for unsupported network: %s", *txs.Network.Name)
 	}
@@ -402,6 +543,54 @@ func (txs *TransactionSigner) Sign(tx *Transaction) (signedTx interface{}, hash
 	return signedTx, hash, err
 }
 
+// resolveTxType returns true if the tx should be signed as an EIP-1559 dynamic fee tx, along with
+// the current base fee; legacy txs are signed when explicitly requested using the type param, when
+// only a gas price is provided or when the network does not support dynamic fee txs
+func (txs *TransactionSigner) resolveTxType(params map[string]interface{}) (bool, *big.Int, error) {
+	maxFeeOk := params["max_fee_per_gas"] != nil
+	maxPriorityFeeOk := params["max_priority_fee_per_gas"] != nil
+	_, gasPriceOk := params["gas_price"].(float64)
+
+	if txType, txTypeOk := params["type"].(float64); txTypeOk {
+		if uint8(txType) == txTypeLegacy {
+			return false, nil, nil
+		} else if uint8(txType) != txTypeDynamicFee {
+			return false, nil, fmt.Errorf("unsupported tx type: %v", txType)
+		}
+	} else if gasPriceOk && !maxFeeOk && !maxPriorityFeeOk {
+		return false, nil, nil
+	}
+
+	supported, baseFee, err := dynamicFeeParams(txs.Network)
+	if err != nil {
+		return false, nil, err
+	}
+
+	if !supported {
+		common.Log.Debugf("network %s does not support dynamic fee txs; falling back to legacy tx", txs.Network.ID)
+	}
+
+	return supported, baseFee, nil
+}
+
+// reserveNonce reserves the next nonce for the given signer address; if the nonce cannot
+// be reserved, nil is returned and the pending nonce is resolved by the tx factory
+func (txs *TransactionSigner) reserveNonce(tx *Transaction, address string) *uint64 {
+	reservation, err := reserveNonce(txs.Network, address)
+	if err != nil {
+		common.Log.Warningf("failed to reserve nonce for signer: %s; %s", address, err.Error())
+		return nil
+	}
+
+	tx.nonceReservation = reservation
+
+	params := tx.ParseParams()
+	params["nonce"] = reservation.nonce
+	tx.setParams(params)
+
+	return &reservation.nonce
+}
+
 // String prints a description of the transaction signer
 func (txs *TransactionSigner) String() string {
 	if txs.Account != nil {
@@ -524,6 +713,7 @@ func (t *Transaction) signerFactory(db *gorm.DB) (*TransactionSigner, error) {
 // and/or token instances when the tx represents a contract and/or token creation.
 func (t *Transaction) Create(db *gorm.DB) bool {
 	if !t.Validate() {
+		t.releaseNonce()
 		return false
 	}
 
@@ -532,13 +722,26 @@ func (t *Transaction) Create(db *gorm.DB) bool {
 		t.Errors = append(t.Errors, &provide.Error{
 			Message: common.StringOrNil(err.Error()),
 		})
+		t.releaseNonce()
 		return false
 	}
 
+	requiresApproval, err := t.requiresApproval(db)
+	if err != nil {
+		t.retryable = true
+		t.Errors = append(t.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+		t.releaseNonce()
+		return false
+	} else if requiresApproval {
+		return t.awaitApproval(db)
+	}
+
 	// xxx check what triggers a signingErr here...
-	signingErr := t.sign(db, signer)
+	signingErr := t.sign(db, signer.signer())
 
-	if db.NewRecord(t) {
+	if db.NewRecord(t) || t.held {
 		// last check to make sure we don't violate fk constraints with a nil uuid;
 		// if that happens, a transaction will end up on-chain before it we have a
 		// local record of it...
@@ -549,7 +752,14 @@ func (t *Transaction) Create(db *gorm.DB) bool {
 			t.WalletID = nil
 		}
 
-		result := db.Create(&t)
+		var result *gorm.DB
+		if t.held {
+			// the tx was persisted when it was held for approval or queued in a batch
+			t.PendingParams = nil
+			result = db.Save(&t)
+		} else {
+			result = db.Create(&t)
+		}
 		rowsAffected := result.RowsAffected
 		errors := result.GetErrors()
 		if len(errors) > 0 {
@@ -558,11 +768,24 @@ func (t *Transaction) Create(db *gorm.DB) bool {
 					Message: common.StringOrNil(err.Error()),
 				})
 			}
+			t.releaseNonce()
+			t.retryable = true
 			return false
 		}
 
 		if !db.NewRecord(t) {
 			if rowsAffected > 0 {
+				err := t.recordPolicyDecisions(db)
+				if err != nil {
+					t.Errors = append(t.Errors, &provide.Error{
+						Message: common.StringOrNil(err.Error()),
+					})
+					t.releaseNonce()
+
+					desc := err.Error()
+					t.updateStatus(db, "failed", &desc)
+					return false
+				}
 
 				// if we have a signing error, which might be insufficient funds, try bookie
 				if signingErr != nil {
@@ -594,7 +817,7 @@ func (t *Transaction) Create(db *gorm.DB) bool {
 
 				if signingErr == nil {
 					// if no signing error, try regular broadcast
-					networkBroadcastErr := t.broadcast(db, signer.Network, signer)
+					networkBroadcastErr := t.broadcast(db, signer.Network, signer.signer())
 					// if regular fails, we're out
 					if networkBroadcastErr != nil {
 						payload, _ := json.Marshal(map[string]interface{}{
@@ -642,6 +865,7 @@ func (t *Transaction) Validate() bool {
 		db.Model(t).Related(&wal)
 	}
 	t.Errors = make([]*provide.Error, 0)
+	t.retryable = false
 	if t.ApplicationID != nil && t.UserID != nil {
 		t.Errors = append(t.Errors, &provide.Error{
 			Message: common.StringOrNil("only an application OR user identifier should be provided"),
@@ -677,6 +901,38 @@ func (t *Transaction) Validate() bool {
 		})
 	}
 
+	if t.Params != nil {
+		params, err := normalizeDynamicFeeParams(*t.Params)
+		if err != nil {
+			t.Errors = append(t.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+		} else {
+			rawParams := json.RawMessage(params)
+			t.Params = &rawParams
+		}
+	}
+
+	if t.NetworkID != uuid.Nil {
+		privateParams, err := parsePrivateTxParams(t.ParseParams())
+		if err != nil {
+			t.Errors = append(t.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+		} else if privateParams != nil {
+			ntwrk, err := t.GetNetwork()
+			if err != nil || !ntwrk.IsQuorumNetwork() {
+				t.Errors = append(t.Errors, &provide.Error{
+					Message: common.StringOrNil("private txs are only supported on quorum networks"),
+				})
+			} else if ntwrk.TransactionManagerURL() == nil {
+				t.Errors = append(t.Errors, &provide.Error{
+					Message: common.StringOrNil("private txs require a transaction manager to be configured for the network"),
+				})
+			}
+		}
+	}
+
 	if t.Signer != nil {
 		if t.AccountID != nil {
 			t.Errors = append(t.Errors, &provide.Error{
@@ -696,9 +952,127 @@ func (t *Transaction) Validate() bool {
 		}
 	}
 
+	if len(t.Errors) == 0 {
+		err := t.enforcePolicies(db)
+		if err != nil {
+			if _, violation := err.(*policy.Violation); !violation {
+				// the policies could not be evaluated; the tx is rejected, but may be allowed upon retry
+				t.retryable = true
+			}
+			t.Errors = append(t.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+		}
+	}
+
 	return len(t.Errors) == 0
 }
 
+// enforcePolicies evaluates the signing policies which apply to the account or HD wallet which
+// signs the tx; a *policy.Violation naming the failed rule is returned if the tx is rejected.
+// Decisions are not recorded until the tx is broadcast; see recordPolicyDecisions.
+func (t *Transaction) enforcePolicies(db *gorm.DB) error {
+	if t.policyEvaluated || (t.AccountID == nil && t.WalletID == nil) {
+		return nil
+	}
+
+	intents, err := t.policyIntents(db, t.policyValue())
+	if err != nil {
+		return err
+	}
+
+	err = policy.EvaluateAll(db, intents, true)
+	if err != nil {
+		return err
+	}
+
+	t.policyEvaluated = true
+	return nil
+}
+
+// recordPolicyDecisions evaluates the signing policies which apply to the persisted tx once more,
+// while their daily caps are locked, and records the decisions immediately before the tx is
+// broadcast; the decisions are recorded once per tx, so a redelivered tx is not counted twice
+func (t *Transaction) recordPolicyDecisions(db *gorm.DB) error {
+	if t.AccountID == nil && t.WalletID == nil {
+		return nil
+	}
+
+	intents, err := t.policyIntents(db, t.policyValue())
+	if err != nil {
+		return err
+	}
+
+	for _, intent := range intents {
+		intent.TransactionID = &t.ID
+	}
+	return policy.EvaluateAll(db, intents, false)
+}
+
+// policyValue returns the native value of the tx evaluated by the signing policies, if any
+func (t *Transaction) policyValue() *big.Int {
+	if t.Value == nil {
+		return nil
+	}
+	return t.Value.BigInt()
+}
+
+// policyIntents returns the intents which the signing policies evaluate for the tx; each call of
+// a Multicall3 aggregate3Value tx is evaluated in place of the tx, since the calls are made on
+// behalf of the signer and would otherwise only be evaluated as a call to the Multicall3 contract
+func (t *Transaction) policyIntents(db *gorm.DB, value *big.Int) ([]*policy.Intent, error) {
+	intent := &policy.Intent{
+		ApplicationID:  t.ApplicationID,
+		OrganizationID: t.OrganizationID,
+		AccountID:      t.AccountID,
+		WalletID:       t.WalletID,
+		NetworkID:      t.NetworkID,
+		To:             t.To,
+		Value:          value,
+		Data:           t.Data,
+	}
+
+	if t.To == nil || t.Data == nil {
+		return []*policy.Intent{intent}, nil
+	}
+
+	calls, err := unpackMulticall3Calls(ethcommon.FromHex(*t.Data))
+	if err != nil {
+		return nil, err
+	} else if calls == nil {
+		return []*policy.Intent{intent}, nil
+	}
+
+	ntwrk := &network.Network{}
+	db.Where("id = ?", t.NetworkID).Find(&ntwrk)
+	if ntwrk.ID == uuid.Nil {
+		return nil, fmt.Errorf("failed to resolve network %s of multicall tx", t.NetworkID)
+	}
+	if !strings.EqualFold(*t.To, multicall3Address(ntwrk)) {
+		return []*policy.Intent{intent}, nil
+	}
+
+	intents := make([]*policy.Intent, 0)
+	for _, call := range calls {
+		var data *string
+		if len(call.CallData) > 0 {
+			data = common.StringOrNil(fmt.Sprintf("0x%s", ethcommon.Bytes2Hex(call.CallData)))
+		}
+
+		intents = append(intents, &policy.Intent{
+			ApplicationID:  t.ApplicationID,
+			OrganizationID: t.OrganizationID,
+			AccountID:      t.AccountID,
+			WalletID:       t.WalletID,
+			NetworkID:      t.NetworkID,
+			To:             common.StringOrNil(call.Target.Hex()),
+			Value:          call.Value,
+			Data:           data,
+		})
+	}
+	return intents, nil
+}
+
 // Reload the underlying tx instance
 func (t *Transaction) Reload() {
 	db := dbconf.DatabaseConnection()
@@ -747,6 +1121,22 @@ func (t *Transaction) GetWallet() (*wallet.Wallet, error) {
 	return wallet, nil
 }
 
+// parseBigIntParam parses the named numeric param as a big.Int, or returns nil if it is not present
+// or cannot be parsed without loss of precision
+func parseBigIntParam(params map[string]interface{}, name string) *big.Int {
+	val, ok := params[name]
+	if !ok || val == nil {
+		return nil
+	}
+
+	i, err := parseWei(val)
+	if err != nil {
+		common.Log.Warningf("failed to parse %s param; %s", name, err.Error())
+		return nil
+	}
+	return i
+}
+
 // ParseParams - parse the original JSON params used when the tx was broadcast
 func (t *Transaction) ParseParams() map[string]interface{} {
 	params := map[string]interface{}{}
@@ -769,6 +1159,7 @@ func (t *Transaction) shouldSubsidize() bool {
 }
 
 func (t *Transaction) updateStatus(db *gorm.DB, status string, description *string) {
+	changed := t.Status == nil || *t.Status != status
 	t.Status = common.StringOrNil(status)
 	t.Description = description
 	result := db.Save(&t)
@@ -779,6 +1170,14 @@ func (t *Transaction) updateStatus(db *gorm.DB, status string, description *stri
 				Message: common.StringOrNil(err.Error()),
 			})
 		}
+		return
+	}
+
+	if changed && status == "success" {
+		webhook.Dispatch(webhook.EventTxSuccess, t.ApplicationID, t.OrganizationID, t.NetworkID, t)
+	} else if changed && status == "failed" {
+		webhook.Dispatch(webhook.EventTxFailed, t.ApplicationID, t.OrganizationID, t.NetworkID, t)
+		publishTxFailure(t)
 	}
 }
 
@@ -833,7 +1232,11 @@ func (t *Transaction) broadcast(db *gorm.DB, ntwrk *network.Network, signer Sign
 	} else {
 		if ntwrk.IsEthereumNetwork() {
 			if signedTx, ok := t.SignedTx.(*types.Transaction); ok {
-				err = providecrypto.EVMBroadcastSignedTx(ntwrk.ID.String(), ntwrk.RPCURL(), signedTx)
+				var rpcClientKey, rpcURL string
+				rpcClientKey, rpcURL, err = ntwrk.RPCEndpoint()
+				if err == nil {
+					err = providecrypto.EVMBroadcastSignedTx(rpcClientKey, rpcURL, signedTx)
+				}
 				if err == nil {
 					// we have successfully broadcast the transaction
 					// so update the db with the received transaction hash
@@ -842,6 +1245,55 @@ func (t *Transaction) broadcast(db *gorm.DB, ntwrk *network.Network, signer Sign
 					db.Save(&t)
 					common.Log.Debugf("broadcast tx: %s", *t.Hash)
 				}
+			} else if signedTx, ok := t.SignedTx.(*dynamicFeeTx); ok {
+				err = broadcastDynamicFeeTx(ntwrk, signedTx)
+				if err == nil {
+					common.Log.Debugf("signed dynamic fee tx returned hash: %s", signedTx.Hash().String())
+					t.Hash = common.StringOrNil(signedTx.Hash().String())
+					db.Save(&t)
+					common.Log.Debugf("broadcast tx: %s", *t.Hash)
+				}
+			} else if signedTx, ok := t.SignedTx.(*privateTx); ok {
+				err = broadcastPrivateTx(ntwrk, signedTx)
+				if err == nil {
+					common.Log.Debugf("signed private tx returned hash: %s", signedTx.Hash().String())
+					t.Hash = common.StringOrNil(signedTx.Hash().String())
+					db.Save(&t)
+					common.Log.Debugf("broadcast private tx: %s", *t.Hash)
+				}
+			} else {
+				err = fmt.Errorf("unable to broadcast signed tx; typecast failed for signed tx: %s", t.SignedTx)
+			}
+		} else if ntwrk.IsBcoinNetwork() {
+			if signedTx, ok := t.SignedTx.(*bcoinTx); ok {
+				err = broadcastBcoinTx(ntwrk, signedTx)
+				if err == nil {
+					t.Hash = common.StringOrNil(signedTx.tx.TxHash().String())
+					db.Save(&t)
+					common.Log.Debugf("broadcast bcoin tx: %s", *t.Hash)
+				}
+			} else {
+				err = fmt.Errorf("unable to broadcast signed tx; typecast failed for signed tx: %s", t.SignedTx)
+			}
+		} else if ntwrk.IsBaseledgerNetwork() {
+			if signedTx, ok := t.SignedTx.(*baseledgerTx); ok {
+				err = broadcastBaseledgerTx(ntwrk, signedTx)
+				if err == nil {
+					t.Hash = common.StringOrNil(signedTx.Hash())
+					db.Save(&t)
+					common.Log.Debugf("broadcast baseledger tx: %s", *t.Hash)
+				}
+			} else {
+				err = fmt.Errorf("unable to broadcast signed tx; typecast failed for signed tx: %s", t.SignedTx)
+			}
+		} else if ntwrk.IsHyperledgerFabricNetwork() {
+			if signedTx, ok := t.SignedTx.(*fabricTx); ok {
+				err = broadcastFabricTx(ntwrk, signedTx)
+				if err == nil {
+					t.Hash = common.StringOrNil(signedTx.txID)
+					db.Save(&t)
+					common.Log.Debugf("broadcast fabric tx: %s", *t.Hash)
+				}
 			} else {
 				err = fmt.Errorf("unable to broadcast signed tx; typecast failed for signed tx: %s", t.SignedTx)
 			}
@@ -852,6 +1304,17 @@ func (t *Transaction) broadcast(db *gorm.DB, ntwrk *network.Network, signer Sign
 
 	if err != nil {
 		common.Log.Warningf("failed to broadcast %s tx using %s; %s", *ntwrk.Name, signer.String(), err.Error())
+		if isStaleNonceBroadcast(err) {
+			t.invalidateNonce()
+		} else if isRejectedBroadcast(err) {
+			t.releaseNonce()
+		} else if t.nonceReservation != nil {
+			// the tx may have been accepted regardless of the error, so releasing the nonce could
+			// result in a replacement or duplicate; an abandoned nonce is resynchronized once the
+			// drift timeout elapses
+			common.Log.Debugf("retaining nonce %d reserved for signer: %s after ambiguous broadcast error", t.nonceReservation.nonce, t.nonceReservation.address)
+			t.nonceReservation = nil
+		}
 		t.Errors = append(t.Errors, &provide.Error{
 			Message: common.StringOrNil(err.Error()),
 		})
@@ -860,11 +1323,46 @@ func (t *Transaction) broadcast(db *gorm.DB, ntwrk *network.Network, signer Sign
 	} else {
 		broadcastAt := time.Now()
 		t.BroadcastAt = &broadcastAt
+		webhook.Dispatch(webhook.EventTxBroadcast, t.ApplicationID, t.OrganizationID, t.NetworkID, t)
 	}
 
 	return err
 }
 
+// releaseNonce releases the nonce reserved on behalf of the signer, if any
+func (t *Transaction) releaseNonce() {
+	if t.nonceReservation == nil {
+		return
+	}
+
+	err := t.nonceReservation.release()
+	if err != nil {
+		common.Log.Warningf("failed to release nonce %d reserved for signer: %s; %s", t.nonceReservation.nonce, t.nonceReservation.address, err.Error())
+	}
+	t.clearNonceReservation()
+}
+
+// invalidateNonce forces the nonce state of the signer to be resynchronized with the network
+func (t *Transaction) invalidateNonce() {
+	if t.nonceReservation == nil {
+		return
+	}
+
+	err := t.nonceReservation.invalidate()
+	if err != nil {
+		common.Log.Warningf("failed to invalidate nonce state for signer: %s; %s", t.nonceReservation.address, err.Error())
+	}
+	t.clearNonceReservation()
+}
+
+// clearNonceReservation removes the reserved nonce from the tx params
+func (t *Transaction) clearNonceReservation() {
+	params := t.ParseParams()
+	delete(params, "nonce")
+	t.setParams(params)
+	t.nonceReservation = nil
+}
+
 func (t *Transaction) sign(db *gorm.DB, signer Signer) error {
 	var err error
 	var hash []byte
@@ -893,6 +1391,10 @@ func (t *Transaction) sign(db *gorm.DB, signer Signer) error {
 }
 
 func (t *Transaction) fetchReceipt(db *gorm.DB, network *network.Network, signerAddress string) error {
+	if network.IsBcoinNetwork() {
+		return t.fetchBcoinReceipt(db, network, signerAddress)
+	}
+
 	p2pAPI, err := network.P2PAPIClient()
 	if err != nil {
 		return err
@@ -902,12 +1404,22 @@ func (t *Transaction) fetchReceipt(db *gorm.DB, network *network.Network, signer
 		return fmt.Errorf("unable to fetch tx receipt for nil tx hash; tx id: %s", t.ID)
 	}
 
-	receipt, err := p2pAPI.FetchTxReceipt(signerAddress, *t.Hash)
+	var receipt *provideapi.TxReceipt
+	if network.IsHyperledgerFabricNetwork() {
+		receipt, err = t.fetchFabricTxReceipt(db, network)
+	} else {
+		receipt, err = p2pAPI.FetchTxReceipt(signerAddress, *t.Hash)
+	}
 	if err != nil {
 		return err
 	}
 
 	common.Log.Debugf("Fetched tx receipt for tx hash: %s", *t.Hash)
+	if network.IsEthereumNetwork() {
+		gasUsed := receipt.GasUsed
+		t.GasUsed = &gasUsed
+	}
+
 	t.Response = &contract.ExecutionResponse{
 		Receipt:     receipt,
 		Transaction: t,
@@ -919,6 +1431,15 @@ func (t *Transaction) fetchReceipt(db *gorm.DB, network *network.Network, signer
 		return err
 	}
 
+	if network.IsEthereumNetwork() {
+		effectiveGasPrice, err := fetchEffectiveGasPrice(network, *t.Hash)
+		if err != nil {
+			common.Log.Warningf("failed to resolve effective gas price for tx hash: %s; %s", *t.Hash, err.Error())
+		} else {
+			t.EffectiveGasPrice = &TxValue{value: effectiveGasPrice}
+		}
+	}
+
 	traces, traceErr := p2pAPI.FetchTxTraces(*t.Hash)
 	if traceErr != nil {
 		common.Log.Warningf("failed to fetch tx trace for tx hash: %s; %s", *t.Hash, traceErr.Error())
@@ -937,7 +1458,11 @@ func (t *Transaction) handleTxReceipt(
 	signerAddress string,
 	receipt *provideapi.TxReceipt,
 ) error {
-	if t.To == nil {
+	if (network.IsEthereumNetwork() || network.IsBaseledgerNetwork()) && receipt.Status == 0 {
+		return t.handleFailedTxReceipt(db, network, signerAddress, receipt)
+	}
+
+	if t.To == nil && network.IsEthereumNetwork() {
 		var contractAddress *string
 		if network.IsEthereumNetwork() {
 			ethCommonAddress := ethcommon.BytesToAddress(receipt.ContractAddress)
@@ -989,6 +1514,7 @@ func (t *Transaction) handleTxReceipt(
 			if kontract.Create() {
 				common.Log.Debugf("created contract %s for %s contract creation tx: %s", kontract.ID, *network.Name, *t.Hash)
 				kontract.ResolveTokenContract(db, network, signerAddress, receipt, tokenCreateFn)
+				webhook.Dispatch(webhook.EventContractDeployed, t.ApplicationID, t.OrganizationID, t.NetworkID, kontract)
 			} else {
 				common.Log.Warningf("failed to create contract for %s contract creation tx %s", *network.Name, *t.Hash)
 			}
@@ -997,12 +1523,42 @@ func (t *Transaction) handleTxReceipt(
 			kontract.Address = contractAddress
 			db.Save(&kontract)
 			kontract.ResolveTokenContract(db, network, signerAddress, receipt, tokenCreateFn)
+			webhook.Dispatch(webhook.EventContractDeployed, t.ApplicationID, t.OrganizationID, t.NetworkID, kontract)
 		}
 	}
 
 	return nil
 }
 
+// handleFailedTxReceipt resolves and persists the decoded revert of a tx which was included in a block but failed;
+// the revert is only resolved on EVM networks, as it is resolved by replaying the tx
+func (t *Transaction) handleFailedTxReceipt(
+	db *gorm.DB,
+	network *network.Network,
+	signerAddress string,
+	receipt *provideapi.TxReceipt,
+) error {
+	if !network.IsEthereumNetwork() {
+		t.Description = common.StringOrNil(fmt.Sprintf("tx failed in block %s", receipt.BlockNumber))
+		return nil
+	}
+
+	revert, err := t.resolveRevertError(db, network, signerAddress, receipt.BlockNumber)
+	if err != nil {
+		common.Log.Debugf("failed to resolve revert of failed tx: %s; %s", *t.Hash, err.Error())
+		t.Description = common.StringOrNil(fmt.Sprintf("tx failed in block %s", receipt.BlockNumber))
+		return nil
+	}
+
+	revertJSON, _ := json.Marshal(revert)
+	_revertJSON := json.RawMessage(revertJSON)
+	t.RevertError = &_revertJSON
+	t.Description = common.StringOrNil(revert.String())
+
+	common.Log.Debugf("resolved revert of failed tx: %s; %s", *t.Hash, *t.Description)
+	return nil
+}
+
 func (t *Transaction) handleTxTraces(
 	db *gorm.DB,
 	network *network.Network,
@@ -1123,6 +1679,48 @@ func (t *Transaction) RefreshDetails() error {
 	return nil
 }
 
+// ValidateRequest validates the given tx, as it would be posted to the transactions API, without
+// creating it or recording the decisions of the signing policies which apply to it
+func ValidateRequest(raw []byte) []*provide.Error {
+	requested := &Transaction{}
+	err := json.Unmarshal(raw, &requested)
+	if err != nil {
+		return []*provide.Error{{
+			Message: common.StringOrNil(fmt.Sprintf("invalid tx; %s", err.Error())),
+		}}
+	}
+
+	tx := requestedTransaction(requested)
+	tx.Validate()
+	return tx.Errors
+}
+
+// requestedTransaction returns a new tx using only the fields of the given tx which can be
+// posted to the transactions API
+func requestedTransaction(requested *Transaction) *Transaction {
+	tx := &Transaction{
+		ApplicationID:  requested.ApplicationID,
+		OrganizationID: requested.OrganizationID,
+		UserID:         requested.UserID,
+		NetworkID:      requested.NetworkID,
+		AccountID:      requested.AccountID,
+		WalletID:       requested.WalletID,
+		Path:           requested.Path,
+		Signer:         requested.Signer,
+		Signature:      requested.Signature,
+		To:             requested.To,
+		Value:          requested.Value,
+		Data:           requested.Data,
+		Params:         requested.Params,
+		Ref:            requested.Ref,
+		Description:    requested.Description,
+	}
+	if tx.Value == nil {
+		tx.Value = NewTxValue(0)
+	}
+	return tx
+}
+
 // setParams sets the tx params in-memory
 func (t *Transaction) setParams(params map[string]interface{}) {
 	paramsJSON, _ := json.Marshal(params)
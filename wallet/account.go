@@ -17,9 +17,11 @@
 package wallet
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"math/big"
+	"strings"
 	"time"
 
 	"github.com/jinzhu/gorm"
@@ -27,6 +29,7 @@ import (
 	uuid "github.com/kthomas/go.uuid"
 	"github.com/provideplatform/nchain/common"
 	"github.com/provideplatform/nchain/network"
+	"github.com/provideplatform/nchain/network/p2p"
 	"github.com/provideplatform/nchain/token"
 	provide "github.com/provideplatform/provide-go/api"
 	vault "github.com/provideplatform/provide-go/api/vault"
@@ -34,6 +37,13 @@ import (
 	providecrypto "github.com/provideplatform/provide-go/crypto"
 )
 
+// KeySpecSecp256k1 is the key spec of accounts on networks other than hyperledger fabric networks
+const KeySpecSecp256k1 = vault.KeySpecECCSecp256k1
+
+// KeySpecSecp256r1 is the key spec (i.e., P-256) of accounts on hyperledger fabric networks, as
+// fabric identities are certified for ECDSA keys on the NIST curves
+const KeySpecSecp256r1 = "secp256r1"
+
 // Account represents a single address associated with a specific network and application or user
 type Account struct {
 	provide.Model
@@ -46,7 +56,15 @@ type Account struct {
 	VaultID *uuid.UUID `sql:"type:uuid" json:"vault_id,omitempty"`
 	KeyID   *uuid.UUID `sql:"type:uuid" json:"key_id,omitempty"`
 
+	// Key material held in the local keystore (i.e., when vault is not used); see KeyStorage
+	KeyStorage *string          `sql:"-" json:"key_storage,omitempty"`
+	Keystore   *json.RawMessage `sql:"type:json" json:"-"`
+
 	Type *string `json:"type,omitempty"`
+	Spec *string `json:"spec,omitempty"` // key spec; secp256k1 or secp256r1, defaulting to the key spec of the network
+
+	// PEM-encoded x509 enrollment certificate issued by a fabric MSP for the account key; see KeySpecSecp256r1
+	Certificate *string `json:"certificate,omitempty"`
 
 	HDDerivationPath *string `json:"hd_derivation_path,omitempty"` // i.e. m/44'/60'/0'/0
 	PublicKey        *string `sql:"type:bytea" json:"public_key,omitempty"`
@@ -68,6 +86,39 @@ func (a *Account) SetID(accountID uuid.UUID) {
 	a.ID = accountID
 }
 
+// resolveKeySpec returns the given key spec, or the key spec of the given network if none is given;
+// fabric identities are only certified for secp256r1 keys, while all other networks use secp256k1 keys
+func resolveKeySpec(spec *string, ntwrk *network.Network) (string, error) {
+	isFabric := ntwrk != nil && ntwrk.IsHyperledgerFabricNetwork()
+
+	keySpec := KeySpecSecp256k1
+	if spec != nil {
+		keySpec = strings.ToLower(*spec)
+	} else if isFabric {
+		keySpec = KeySpecSecp256r1
+	}
+
+	switch keySpec {
+	case KeySpecSecp256k1:
+		if isFabric {
+			return "", fmt.Errorf("unsupported key spec for hyperledger fabric network: %s", keySpec)
+		}
+	case KeySpecSecp256r1:
+		if !isFabric {
+			return "", fmt.Errorf("key spec %s is only supported on hyperledger fabric networks", keySpec)
+		}
+	default:
+		return "", fmt.Errorf("unsupported key spec: %s", keySpec)
+	}
+
+	return keySpec, nil
+}
+
+// UsesSecp256r1 returns true if the account key is a secp256r1 (P-256) key
+func (a *Account) UsesSecp256r1() bool {
+	return a.Spec != nil && strings.ToLower(*a.Spec) == KeySpecSecp256r1
+}
+
 func (a *Account) generate(db *gorm.DB) error {
 	network, _ := a.GetNetwork()
 
@@ -77,10 +128,33 @@ func (a *Account) generate(db *gorm.DB) error {
 		return err
 	}
 
+	keyStorage, err := resolveKeyStorage(a.KeyStorage)
+	if err != nil {
+		common.Log.Warning(err.Error())
+		return err
+	}
+
+	keySpec, err := resolveKeySpec(a.Spec, network)
+	if err != nil {
+		common.Log.Warning(err.Error())
+		return err
+	}
+	a.Spec = &keySpec
+
+	if keyStorage == common.KeyStorageKeystore {
+		err := a.generateKeystore(network)
+		if err != nil {
+			err := fmt.Errorf("unable to generate key material for account; %s", err.Error())
+			common.Log.Warning(err.Error())
+			return err
+		}
+		return nil
+	}
+
 	key, err := vault.CreateKey(util.DefaultVaultAccessJWT, common.DefaultVault.ID.String(), map[string]interface{}{
 		"type":  "asymmetric",
 		"usage": "sign/verify",
-		"spec":  "secp256k1",
+		"spec":  keySpec,
 		"name":  fmt.Sprintf("nchain account for network: %s", network.ID.String()),
 	})
 
@@ -90,7 +164,32 @@ func (a *Account) generate(db *gorm.DB) error {
 		return err
 	}
 
-	if key.Address != nil {
+	if network != nil && network.IsBcoinNetwork() && key.PublicKey != nil {
+		// the vault-derived address is an EVM address; derive the P2PKH address from the public key
+		addr, err := network.BcoinAddress(*key.PublicKey)
+		if err != nil {
+			err := fmt.Errorf("unable to generate bcoin address for account; %s", err.Error())
+			common.Log.Warning(err.Error())
+			return err
+		}
+		a.Address = *addr
+	} else if network != nil && network.IsHyperledgerFabricNetwork() && key.PublicKey != nil {
+		addr, err := network.FabricAddress(*key.PublicKey)
+		if err != nil {
+			err := fmt.Errorf("unable to generate fabric address for account; %s", err.Error())
+			common.Log.Warning(err.Error())
+			return err
+		}
+		a.Address = *addr
+	} else if network != nil && network.IsBaseledgerNetwork() && key.PublicKey != nil {
+		addr, err := network.BaseledgerAddress(*key.PublicKey)
+		if err != nil {
+			err := fmt.Errorf("unable to generate baseledger address for account; %s", err.Error())
+			common.Log.Warning(err.Error())
+			return err
+		}
+		a.Address = *addr
+	} else if key.Address != nil {
 		a.Address = *key.Address
 	}
 
@@ -162,7 +261,14 @@ func (a *Account) GetNetwork() (*network.Network, error) {
 func (a *Account) Create() bool {
 	db := dbconf.DatabaseConnection()
 
-	a.generate(db)
+	err := a.generate(db)
+	if err != nil {
+		a.Errors = append(a.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+		return false
+	}
+
 	if !a.Validate() {
 		return false
 	}
@@ -190,15 +296,32 @@ func (a *Account) Validate() bool {
 	a.Errors = make([]*provide.Error, 0)
 	var network = &network.Network{}
 	dbconf.DatabaseConnection().Model(a).Related(&network)
-	if a.VaultID == nil || *a.VaultID == uuid.Nil {
-		a.Errors = append(a.Errors, &provide.Error{
-			Message: common.StringOrNil("vault id required"),
-		})
+	if a.Keystore == nil {
+		if a.VaultID == nil || *a.VaultID == uuid.Nil {
+			a.Errors = append(a.Errors, &provide.Error{
+				Message: common.StringOrNil("vault id required"),
+			})
+		}
+		if a.KeyID == nil || *a.KeyID == uuid.Nil {
+			a.Errors = append(a.Errors, &provide.Error{
+				Message: common.StringOrNil("vault key id required"),
+			})
+		}
 	}
-	if a.KeyID == nil || *a.KeyID == uuid.Nil {
-		a.Errors = append(a.Errors, &provide.Error{
-			Message: common.StringOrNil("vault key id required"),
-		})
+	if a.Certificate != nil {
+		if a.PublicKey == nil {
+			a.Errors = append(a.Errors, &provide.Error{
+				Message: common.StringOrNil("public key required to certify account"),
+			})
+		} else if !a.UsesSecp256r1() {
+			a.Errors = append(a.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("enrollment certificate requires a %s account", KeySpecSecp256r1)),
+			})
+		} else if err := p2p.VerifyFabricCertificate(*a.Certificate, *a.PublicKey); err != nil {
+			a.Errors = append(a.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("invalid enrollment certificate; %s", err.Error())),
+			})
+		}
 	}
 	if a.NetworkID != nil && *a.NetworkID == uuid.Nil {
 		a.Errors = append(a.Errors, &provide.Error{
@@ -218,6 +341,27 @@ func (a *Account) Validate() bool {
 	return len(a.Errors) == 0
 }
 
+// UpdateCertificate sets the enrollment certificate issued by a fabric MSP for the account key
+func (a *Account) UpdateCertificate(db *gorm.DB, certificate string) bool {
+	a.Certificate = &certificate
+	if !a.Validate() {
+		return false
+	}
+
+	result := db.Model(a).Update("certificate", a.Certificate)
+	errors := result.GetErrors()
+	if len(errors) > 0 {
+		for _, err := range errors {
+			a.Errors = append(a.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+		}
+		return false
+	}
+
+	return result.RowsAffected > 0
+}
+
 // NativeCurrencyBalance retrieves a account's native currency/token balance
 func (a *Account) NativeCurrencyBalance() (*big.Int, error) {
 	var balance *big.Int
@@ -226,10 +370,24 @@ func (a *Account) NativeCurrencyBalance() (*big.Int, error) {
 	var network = &network.Network{}
 	db.Model(a).Related(&network)
 	if network.IsEthereumNetwork() {
-		balance, err = providecrypto.EVMGetNativeBalance(network.ID.String(), network.RPCURL(), a.Address)
+		var rpcClientKey, rpcURL string
+		rpcClientKey, rpcURL, err = network.RPCEndpoint()
 		if err != nil {
 			return nil, err
 		}
+		balance, err = providecrypto.EVMGetNativeBalance(rpcClientKey, rpcURL, a.Address)
+		if err != nil {
+			return nil, err
+		}
+	} else if network.IsBcoinNetwork() {
+		coins, err := network.BcoinCoins(a.Address)
+		if err != nil {
+			return nil, err
+		}
+		balance = big.NewInt(0)
+		for _, coin := range coins {
+			balance.Add(balance, big.NewInt(coin.Value))
+		}
 	} else {
 		common.Log.Warningf("unable to read native currency balance for network: %s", a.NetworkID)
 	}
@@ -249,7 +407,11 @@ func (a *Account) TokenBalance(tokenID string) (*big.Int, error) {
 	}
 	if network.IsEthereumNetwork() {
 		contractAbi, err := token.ReadEthereumContractAbi()
-		balance, err = providecrypto.EVMGetTokenBalance(network.ID.String(), network.RPCURL(), *token.Address, a.Address, contractAbi)
+		rpcClientKey, rpcURL, err := network.RPCEndpoint()
+		if err != nil {
+			return nil, err
+		}
+		balance, err = providecrypto.EVMGetTokenBalance(rpcClientKey, rpcURL, *token.Address, a.Address, contractAbi)
 		if err != nil {
 			return nil, err
 		}
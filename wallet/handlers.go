@@ -23,6 +23,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/gin-gonic/gin"
 	dbconf "github.com/kthomas/go-db-config"
 	uuid "github.com/kthomas/go.uuid"
@@ -41,7 +42,10 @@ func InstallAccountsAPI(r *gin.Engine) {
 	r.GET("/api/v1/accounts", accountsListHandler)
 	r.POST("/api/v1/accounts", createAccountHandler)
 	r.GET("/api/v1/accounts/:id", accountDetailsHandler)
+	r.PUT("/api/v1/accounts/:id", updateAccountHandler)
 	r.GET("/api/v1/accounts/:id/balances/:tokenId", accountBalanceHandler)
+	r.POST("/api/v1/accounts/:id/sign", accountSignHandler)
+	r.POST("/api/v1/accounts/:id/verify", accountVerifyHandler)
 }
 
 // InstallWalletsAPI installs the handlers using the given gin Engine
@@ -50,6 +54,8 @@ func InstallWalletsAPI(r *gin.Engine) {
 	r.POST("/api/v1/wallets", createWalletHandler)
 	r.GET("/api/v1/wallets/:id", walletDetailsHandler)
 	r.GET("/api/v1/wallets/:id/accounts", walletAccountsListHandler)
+	r.POST("/api/v1/wallets/:id/sign", walletSignHandler)
+	r.POST("/api/v1/wallets/:id/verify", walletVerifyHandler)
 }
 
 func createAccountHandler(c *gin.Context) {
@@ -163,7 +169,7 @@ func accountDetailsHandler(c *gin.Context) {
 		return
 	}
 	network, err := account.GetNetwork()
-	if err == nil && network.RPCURL() != "" {
+	if err == nil && network.HasRPCURL() {
 		tokenID := c.Param("tokenId")
 		if tokenID == "" {
 			account.Balance, err = account.NativeCurrencyBalance()
@@ -183,6 +189,49 @@ func accountDetailsHandler(c *gin.Context) {
 	provide.Render(account, 200, c)
 }
 
+// updateAccountHandler sets the enrollment certificate issued by a fabric MSP for the account key;
+// the certificate is issued for the generated key, so it cannot be provided when the account is created
+func updateAccountHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	userID := util.AuthorizedSubjectID(c, "user")
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && userID == nil && organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	params := &Account{}
+	err = json.Unmarshal(buf, params)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	if params.Certificate == nil {
+		provide.RenderError("certificate required", 422, c)
+		return
+	}
+
+	account := resolveSigningAccount(c, appID, userID, organizationID)
+	if account == nil {
+		return
+	}
+
+	if account.UpdateCertificate(dbconf.DatabaseConnection(), *params.Certificate) {
+		provide.Render(account, 200, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = account.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
 func accountBalanceHandler(c *gin.Context) {
 	appID := util.AuthorizedSubjectID(c, "application")
 	userID := util.AuthorizedSubjectID(c, "user")
@@ -430,3 +479,206 @@ func walletAccountsListHandler(c *gin.Context) {
 
 	provide.Render(accounts, 200, c)
 }
+
+// resolveSigningAccount resolves the account with the given id which is owned by the authorized subject
+func resolveSigningAccount(c *gin.Context, appID, userID, organizationID *uuid.UUID) *Account {
+	account := &Account{}
+	dbconf.DatabaseConnection().Where("id = ?", c.Param("id")).Find(&account)
+	if account == nil || account.ID == uuid.Nil {
+		provide.RenderError("account not found", 404, c)
+		return nil
+	} else if appID != nil && (account.ApplicationID == nil || *account.ApplicationID != *appID) {
+		provide.RenderError("forbidden", 403, c)
+		return nil
+	} else if userID != nil && (account.UserID == nil || *account.UserID != *userID) {
+		provide.RenderError("forbidden", 403, c)
+		return nil
+	} else if organizationID != nil && (account.OrganizationID == nil || *account.OrganizationID != *organizationID) {
+		provide.RenderError("forbidden", 403, c)
+		return nil
+	}
+	return account
+}
+
+// resolveSigningWallet resolves the HD wallet with the given id which is owned by the authorized subject
+func resolveSigningWallet(c *gin.Context, appID, userID, organizationID *uuid.UUID) *Wallet {
+	wallet := &Wallet{}
+	dbconf.DatabaseConnection().Where("id = ?", c.Param("id")).Find(&wallet)
+	if wallet == nil || wallet.ID == uuid.Nil {
+		provide.RenderError("wallet not found", 404, c)
+		return nil
+	} else if appID != nil && (wallet.ApplicationID == nil || *wallet.ApplicationID != *appID) {
+		provide.RenderError("forbidden", 403, c)
+		return nil
+	} else if userID != nil && (wallet.UserID == nil || *wallet.UserID != *userID) {
+		provide.RenderError("forbidden", 403, c)
+		return nil
+	} else if organizationID != nil && (wallet.OrganizationID == nil || *wallet.OrganizationID != *organizationID) {
+		provide.RenderError("forbidden", 403, c)
+		return nil
+	}
+	return wallet
+}
+
+// parseSignatureRequest parses the signature request and returns its digest
+func parseSignatureRequest(c *gin.Context) (*SignatureRequest, []byte) {
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return nil, nil
+	}
+
+	req := &SignatureRequest{}
+	err = json.Unmarshal(buf, &req)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return nil, nil
+	}
+
+	digest, err := req.Digest()
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return nil, nil
+	}
+
+	return req, digest
+}
+
+// verifySignature renders the signer recovered from the signature of the given digest and
+// whether it is the given address
+func verifySignature(c *gin.Context, req *SignatureRequest, digest []byte, address string, derivationPath *string) {
+	if req.Signature == nil {
+		provide.RenderError("signature required", 422, c)
+		return
+	}
+
+	signer, err := RecoverSigner(digest, *req.Signature)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+
+	verified := strings.EqualFold(signer, address)
+	provide.Render(&SignatureResponse{
+		Address:          address,
+		Digest:           hexutil.Encode(digest),
+		HDDerivationPath: derivationPath,
+		Signature:        *req.Signature,
+		Signer:           &signer,
+		Verified:         &verified,
+	}, 200, c)
+}
+
+func accountSignHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	userID := util.AuthorizedSubjectID(c, "user")
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && userID == nil && organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	req, digest := parseSignatureRequest(c)
+	if req == nil {
+		return
+	}
+
+	account := resolveSigningAccount(c, appID, userID, organizationID)
+	if account == nil {
+		return
+	}
+
+	resp, err := account.SignDigest(digest)
+	if err != nil {
+		common.Log.Warning(err.Error())
+		provide.RenderError(err.Error(), 500, c)
+		return
+	}
+
+	provide.Render(resp, 200, c)
+}
+
+func accountVerifyHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	userID := util.AuthorizedSubjectID(c, "user")
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && userID == nil && organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	req, digest := parseSignatureRequest(c)
+	if req == nil {
+		return
+	}
+
+	account := resolveSigningAccount(c, appID, userID, organizationID)
+	if account == nil {
+		return
+	}
+
+	verifySignature(c, req, digest, account.Address, nil)
+}
+
+func walletSignHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	userID := util.AuthorizedSubjectID(c, "user")
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && userID == nil && organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	req, digest := parseSignatureRequest(c)
+	if req == nil {
+		return
+	}
+
+	wallet := resolveSigningWallet(c, appID, userID, organizationID)
+	if wallet == nil {
+		return
+	}
+
+	resp, err := wallet.SignDigest(req.HDDerivationPath, digest)
+	if err != nil {
+		common.Log.Warning(err.Error())
+		provide.RenderError(err.Error(), 500, c)
+		return
+	}
+
+	provide.Render(resp, 200, c)
+}
+
+func walletVerifyHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	userID := util.AuthorizedSubjectID(c, "user")
+	organizationID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && userID == nil && organizationID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	req, digest := parseSignatureRequest(c)
+	if req == nil {
+		return
+	}
+
+	wallet := resolveSigningWallet(c, appID, userID, organizationID)
+	if wallet == nil {
+		return
+	}
+
+	path := DefaultHDDerivationPath
+	if req.HDDerivationPath != nil {
+		path = *req.HDDerivationPath
+	}
+
+	address, err := wallet.DeriveSigner(path)
+	if err != nil {
+		common.Log.Warning(err.Error())
+		provide.RenderError(err.Error(), 500, c)
+		return
+	}
+
+	verifySignature(c, req, digest, address, &path)
+}
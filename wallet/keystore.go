@@ -0,0 +1,379 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	bip32 "github.com/FactomProject/go-bip32"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	uuid "github.com/kthomas/go.uuid"
+	hdwallet "github.com/miguelmota/go-ethereum-hdwallet"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/network"
+)
+
+// keystoreVersion is the Web3 Secret Storage version
+const keystoreVersion = 3
+
+// keystoreMnemonicBits is the entropy of mnemonics generated for HD wallets held in the local keystore
+const keystoreMnemonicBits = 256
+
+// DefaultHDDerivationPath is used to sign using an HD wallet held in the local keystore, or to sign
+// messages using an HD wallet, when no HD derivation path is given
+const DefaultHDDerivationPath = "m/44'/60'/0'/0/0"
+
+// encryptedKeyJSON is a Web3 Secret Storage (v3) document; the address is omitted when the
+// encrypted data is an HD wallet mnemonic rather than a private key
+type encryptedKeyJSON struct {
+	Address string              `json:"address,omitempty"`
+	Crypto  keystore.CryptoJSON `json:"crypto"`
+	ID      string              `json:"id"`
+	Version int                 `json:"version"`
+}
+
+// resolveKeyStorage returns the given key storage, or the default key storage if none is given
+func resolveKeyStorage(keyStorage *string) (string, error) {
+	storage := common.DefaultKeyStorage
+	if keyStorage != nil {
+		storage = strings.ToLower(*keyStorage)
+	}
+
+	if storage != common.KeyStorageVault && storage != common.KeyStorageKeystore {
+		return "", fmt.Errorf("unsupported key storage: %s", storage)
+	}
+
+	return storage, nil
+}
+
+// encryptKeystore encrypts the given data using the keystore passphrase and returns the
+// resulting Web3 Secret Storage document
+func encryptKeystore(data []byte, address *string) (*json.RawMessage, error) {
+	if common.KeystorePassphrase == "" {
+		return nil, errors.New("keystore passphrase not configured")
+	}
+
+	cryptoJSON, err := keystore.EncryptDataV3(data, []byte(common.KeystorePassphrase), keystore.StandardScryptN, keystore.StandardScryptP)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt keystore; %s", err.Error())
+	}
+
+	id, _ := uuid.NewV4()
+	doc := &encryptedKeyJSON{
+		Crypto:  cryptoJSON,
+		ID:      id.String(),
+		Version: keystoreVersion,
+	}
+	if address != nil {
+		doc.Address = strings.ToLower(strings.TrimPrefix(*address, "0x"))
+	}
+
+	raw, err := json.Marshal(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal keystore; %s", err.Error())
+	}
+
+	keystoreJSON := json.RawMessage(raw)
+	return &keystoreJSON, nil
+}
+
+// decryptKeystore decrypts the given Web3 Secret Storage document using the keystore passphrase
+func decryptKeystore(keystoreJSON *json.RawMessage) ([]byte, error) {
+	if keystoreJSON == nil {
+		return nil, errors.New("no keystore")
+	}
+
+	if common.KeystorePassphrase == "" {
+		return nil, errors.New("keystore passphrase not configured")
+	}
+
+	var doc *encryptedKeyJSON
+	err := json.Unmarshal(*keystoreJSON, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal keystore; %s", err.Error())
+	}
+
+	if doc.Version != keystoreVersion {
+		return nil, fmt.Errorf("unsupported keystore version: %d", doc.Version)
+	}
+
+	data, err := keystore.DecryptDataV3(doc.Crypto, common.KeystorePassphrase)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt keystore; %s", err.Error())
+	}
+
+	return data, nil
+}
+
+// UsesKeystore returns true if the account key material is held in the local keystore
+func (a *Account) UsesKeystore() bool {
+	return a.Keystore != nil
+}
+
+// generateKeystore generates, or imports the given, private key for the account using its key spec
+// and encrypts it in the local keystore
+func (a *Account) generateKeystore(ntwrk *network.Network) error {
+	if a.UsesSecp256r1() {
+		return a.generateSecp256r1Keystore(ntwrk)
+	}
+
+	var privateKey *ecdsa.PrivateKey
+	var err error
+
+	if a.PrivateKey != nil {
+		privateKey, err = ethcrypto.HexToECDSA(strings.TrimPrefix(*a.PrivateKey, "0x"))
+		if err != nil {
+			return fmt.Errorf("failed to import private key for account; %s", err.Error())
+		}
+	} else {
+		privateKey, err = ethcrypto.GenerateKey()
+		if err != nil {
+			return fmt.Errorf("failed to generate private key for account; %s", err.Error())
+		}
+	}
+
+	address := ethcrypto.PubkeyToAddress(privateKey.PublicKey).Hex()
+	a.Keystore, err = encryptKeystore(ethcrypto.FromECDSA(privateKey), &address)
+	if err != nil {
+		return err
+	}
+
+	a.Address = address
+	a.PublicKey = common.StringOrNil(keystorePublicKey(privateKey))
+	a.PrivateKey = nil
+
+	return nil
+}
+
+// generateSecp256r1Keystore generates, or imports the given, secp256r1 (P-256) private key for the
+// account and encrypts it in the local keystore; the account is addressed by its fabric address
+func (a *Account) generateSecp256r1Keystore(ntwrk *network.Network) error {
+	var privateKey *ecdsa.PrivateKey
+	var err error
+
+	if a.PrivateKey != nil {
+		privateKey, err = toSecp256r1PrivateKey(*a.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("failed to import private key for account; %s", err.Error())
+		}
+	} else {
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return fmt.Errorf("failed to generate private key for account; %s", err.Error())
+		}
+	}
+
+	publicKey := fmt.Sprintf("0x%s", hex.EncodeToString(elliptic.Marshal(elliptic.P256(), privateKey.X, privateKey.Y)))
+	address, err := ntwrk.FabricAddress(publicKey)
+	if err != nil {
+		return err
+	}
+
+	a.Keystore, err = encryptKeystore(privateKey.D.FillBytes(make([]byte, 32)), address)
+	if err != nil {
+		return err
+	}
+
+	a.Address = *address
+	a.PublicKey = &publicKey
+	a.PrivateKey = nil
+
+	return nil
+}
+
+// toSecp256r1PrivateKey parses the given hex-encoded secp256r1 (P-256) private key
+func toSecp256r1PrivateKey(privateKey string) (*ecdsa.PrivateKey, error) {
+	raw, err := hex.DecodeString(strings.TrimPrefix(privateKey, "0x"))
+	if err != nil {
+		return nil, err
+	}
+
+	curve := elliptic.P256()
+	d := new(big.Int).SetBytes(raw)
+	if len(raw) != 32 || d.Sign() <= 0 || d.Cmp(curve.Params().N) >= 0 {
+		return nil, errors.New("invalid secp256r1 private key")
+	}
+
+	key := &ecdsa.PrivateKey{D: d}
+	key.PublicKey.Curve = curve
+	key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(raw)
+	return key, nil
+}
+
+// KeystoreSign signs the given digest using the account private key held in the local keystore;
+// the signature of a secp256k1 key is returned in the [R || S || V] format, where V is 0 or 1,
+// while the signature of a secp256r1 key is returned in the [R || S] format
+func (a *Account) KeystoreSign(digest []byte) ([]byte, error) {
+	data, err := decryptKeystore(a.Keystore)
+	if err != nil {
+		return nil, err
+	}
+
+	if a.UsesSecp256r1() {
+		privateKey, err := toSecp256r1PrivateKey(hex.EncodeToString(data))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read private key from keystore; %s", err.Error())
+		}
+
+		r, s, err := ecdsa.Sign(rand.Reader, privateKey, digest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign digest; %s", err.Error())
+		}
+
+		sig := make([]byte, 64)
+		r.FillBytes(sig[0:32])
+		s.FillBytes(sig[32:])
+		return sig, nil
+	}
+
+	privateKey, err := ethcrypto.ToECDSA(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key from keystore; %s", err.Error())
+	}
+
+	return ethcrypto.Sign(digest, privateKey)
+}
+
+// UsesKeystore returns true if the HD wallet key material is held in the local keystore
+func (w *Wallet) UsesKeystore() bool {
+	return w.Keystore != nil
+}
+
+// generateKeystore generates, or imports the given, BIP39 mnemonic for the HD wallet and
+// encrypts it in the local keystore; the wallet public key is the extended master public key
+func (w *Wallet) generateKeystore() error {
+	var mnemonic string
+	var err error
+
+	if w.Mnemonic != nil {
+		mnemonic = *w.Mnemonic
+	} else {
+		mnemonic, err = hdwallet.NewMnemonic(keystoreMnemonicBits)
+		if err != nil {
+			return fmt.Errorf("failed to generate mnemonic for HD wallet; %s", err.Error())
+		}
+	}
+
+	seed, err := hdwallet.NewSeedFromMnemonic(mnemonic)
+	if err != nil {
+		return fmt.Errorf("failed to import mnemonic for HD wallet; %s", err.Error())
+	}
+
+	masterKey, err := bip32.NewMasterKey(seed)
+	if err != nil {
+		return fmt.Errorf("failed to generate master key for HD wallet; %s", err.Error())
+	}
+
+	w.Keystore, err = encryptKeystore([]byte(mnemonic), nil)
+	if err != nil {
+		return err
+	}
+
+	w.PublicKey = common.StringOrNil(masterKey.PublicKey().String())
+	w.Mnemonic = nil
+
+	return nil
+}
+
+// KeystoreDerive derives the private key and address at the given HD derivation path using
+// the mnemonic held in the local keystore; DefaultHDDerivationPath is used if no path is given
+func (w *Wallet) KeystoreDerive(derivationPath *string) (privateKey *ecdsa.PrivateKey, address, path string, err error) {
+	path = DefaultHDDerivationPath
+	if derivationPath != nil {
+		path = *derivationPath
+	}
+
+	derivation, err := hdwallet.ParseDerivationPath(path)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to parse derivation path: %s; %s", path, err.Error())
+	}
+
+	mnemonic, err := decryptKeystore(w.Keystore)
+	if err != nil {
+		return nil, "", "", err
+	}
+
+	hdw, err := hdwallet.NewFromMnemonic(string(mnemonic))
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to read mnemonic from keystore; %s", err.Error())
+	}
+
+	acct, err := hdw.Derive(derivation, false)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to derive key at derivation path: %s; %s", path, err.Error())
+	}
+
+	privateKey, err = hdw.PrivateKey(acct)
+	if err != nil {
+		return nil, "", "", fmt.Errorf("failed to derive key at derivation path: %s; %s", path, err.Error())
+	}
+
+	return privateKey, acct.Address.Hex(), path, nil
+}
+
+// keystoreHardenedPublicKey derives the extended public key of the hardened child account at
+// `m/purpose'/coin_type'/account'` using the mnemonic held in the local keystore
+func (w *Wallet) keystoreHardenedPublicKey(purpose, coin, account uint32) (string, error) {
+	mnemonic, err := decryptKeystore(w.Keystore)
+	if err != nil {
+		return "", err
+	}
+
+	seed, err := hdwallet.NewSeedFromMnemonic(string(mnemonic))
+	if err != nil {
+		return "", fmt.Errorf("failed to read mnemonic from keystore; %s", err.Error())
+	}
+
+	key, err := bip32.NewMasterKey(seed)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate master key for HD wallet; %s", err.Error())
+	}
+
+	for _, index := range []uint32{purpose, coin, account} {
+		key, err = key.NewChildKey(bip32.FirstHardenedChild + index)
+		if err != nil {
+			return "", fmt.Errorf("failed to derive hardened child key; %s", err.Error())
+		}
+	}
+
+	return key.PublicKey().String(), nil
+}
+
+// KeystoreSign signs the given digest using the key derived at the given HD derivation path;
+// the signature is returned in the [R || S || V] format, where V is 0 or 1
+func (w *Wallet) KeystoreSign(derivationPath *string, digest []byte) ([]byte, error) {
+	privateKey, _, _, err := w.KeystoreDerive(derivationPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return ethcrypto.Sign(digest, privateKey)
+}
+
+// keystorePublicKey returns the hex-encoded uncompressed public key of the given private key
+func keystorePublicKey(privateKey *ecdsa.PrivateKey) string {
+	return fmt.Sprintf("0x%s", hex.EncodeToString(ethcrypto.FromECDSAPub(&privateKey.PublicKey)))
+}
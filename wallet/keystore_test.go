@@ -0,0 +1,221 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package wallet
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	bip32 "github.com/FactomProject/go-bip32"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	uuid "github.com/kthomas/go.uuid"
+	hdwallet "github.com/miguelmota/go-ethereum-hdwallet"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/network"
+)
+
+const testKeystoreMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+// testKeystoreAddress is the address at m/44'/60'/0'/0/0 of testKeystoreMnemonic
+const testKeystoreAddress = "0x9858EfFD232B4033E47d90003D41EC34EcaEda94"
+
+func setupKeystore(t *testing.T) {
+	passphrase := common.KeystorePassphrase
+	common.KeystorePassphrase = "test passphrase"
+	t.Cleanup(func() {
+		common.KeystorePassphrase = passphrase
+	})
+}
+
+func keystoreWallet(t *testing.T) *Wallet {
+	setupKeystore(t)
+
+	purpose := 44
+	w := &Wallet{
+		Mnemonic: common.StringOrNil(testKeystoreMnemonic),
+		Purpose:  &purpose,
+	}
+	w.ID, _ = uuid.NewV4()
+	if err := w.generateKeystore(); err != nil {
+		t.Fatalf("failed to generate keystore; %s", err.Error())
+	}
+	return w
+}
+
+func TestEncryptKeystoreUsesStandardScryptParams(t *testing.T) {
+	setupKeystore(t)
+
+	keystoreJSON, err := encryptKeystore([]byte("secret"), nil)
+	if err != nil {
+		t.Fatalf("failed to encrypt keystore; %s", err.Error())
+	}
+
+	var doc encryptedKeyJSON
+	json.Unmarshal(*keystoreJSON, &doc)
+	if n, _ := doc.Crypto.KDFParams["n"].(float64); int(n) != keystore.StandardScryptN {
+		t.Errorf("expected scrypt n of %d; got %v", keystore.StandardScryptN, doc.Crypto.KDFParams["n"])
+	}
+	if p, _ := doc.Crypto.KDFParams["p"].(float64); int(p) != keystore.StandardScryptP {
+		t.Errorf("expected scrypt p of %d; got %v", keystore.StandardScryptP, doc.Crypto.KDFParams["p"])
+	}
+
+	data, err := decryptKeystore(keystoreJSON)
+	if err != nil {
+		t.Fatalf("failed to decrypt keystore; %s", err.Error())
+	}
+	if string(data) != "secret" {
+		t.Errorf("expected decrypted keystore to match encrypted data; got %s", string(data))
+	}
+}
+
+func TestDeriveHardenedReturnsDerivedWallet(t *testing.T) {
+	w := keystoreWallet(t)
+
+	hardened, err := w.DeriveHardened(nil, 60, 0)
+	if err != nil {
+		t.Fatalf("failed to derive hardened account; %s", err.Error())
+	}
+
+	if hardened == w {
+		t.Fatal("expected hardened account to be a wallet distinct from its parent")
+	}
+	if w.Path != nil {
+		t.Errorf("expected parent wallet path to be left unmodified; got %s", *w.Path)
+	}
+	if *w.PublicKey == *hardened.PublicKey {
+		t.Error("expected hardened account public key to differ from the master public key")
+	}
+	if hardened.Path == nil || *hardened.Path != "m/44'/60'/0'" {
+		t.Errorf("expected hardened account path m/44'/60'/0'; got %v", hardened.Path)
+	}
+	if hardened.WalletID == nil || *hardened.WalletID != w.ID {
+		t.Error("expected hardened account to reference its parent wallet")
+	}
+
+	// the hardened account public key is the extended public key at m/44'/60'/0' from which
+	// the address at m/44'/60'/0'/0/0 is derived
+	seed, _ := hdwallet.NewSeedFromMnemonic(testKeystoreMnemonic)
+	key, _ := bip32.NewMasterKey(seed)
+	for _, index := range []uint32{bip32.FirstHardenedChild + 44, bip32.FirstHardenedChild + 60, bip32.FirstHardenedChild} {
+		key, _ = key.NewChildKey(index)
+	}
+	if *hardened.PublicKey != key.PublicKey().String() {
+		t.Errorf("expected hardened account public key %s; got %s", key.PublicKey().String(), *hardened.PublicKey)
+	}
+	for i := 0; i < 2; i++ {
+		key, _ = key.NewChildKey(0)
+	}
+	privateKey, err := ethcrypto.ToECDSA(key.Key)
+	if err != nil {
+		t.Fatalf("failed to read child private key; %s", err.Error())
+	}
+	if address := ethcrypto.PubkeyToAddress(privateKey.PublicKey).Hex(); address != testKeystoreAddress {
+		t.Errorf("expected address %s derived from hardened account; got %s", testKeystoreAddress, address)
+	}
+
+	chain := uint32(0)
+	acct, err := hardened.DeriveAddress(nil, 0, &chain)
+	if err != nil {
+		t.Fatalf("failed to derive address; %s", err.Error())
+	}
+	if !strings.EqualFold(acct.Address, testKeystoreAddress) {
+		t.Errorf("expected derived address %s; got %s", testKeystoreAddress, acct.Address)
+	}
+	if acct.WalletID == nil || *acct.WalletID != w.ID {
+		t.Error("expected derived account to reference the persisted wallet")
+	}
+}
+
+// fabricNetwork returns a hyperledger fabric network
+func fabricNetwork() *network.Network {
+	config := json.RawMessage(`{"is_hyperledger_fabric_network": true, "channels": ["mychannel"]}`)
+	ntwrk := &network.Network{Config: &config}
+	ntwrk.ID, _ = uuid.NewV4()
+	return ntwrk
+}
+
+func TestResolveKeySpec(t *testing.T) {
+	evmConfig := json.RawMessage(`{"is_ethereum_network": true}`)
+	evm := &network.Network{Config: &evmConfig}
+
+	if spec, err := resolveKeySpec(nil, evm); err != nil || spec != KeySpecSecp256k1 {
+		t.Errorf("expected %s key spec by default; got %s (%v)", KeySpecSecp256k1, spec, err)
+	}
+	if spec, err := resolveKeySpec(nil, fabricNetwork()); err != nil || spec != KeySpecSecp256r1 {
+		t.Errorf("expected %s key spec by default on fabric network; got %s (%v)", KeySpecSecp256r1, spec, err)
+	}
+	if _, err := resolveKeySpec(common.StringOrNil(KeySpecSecp256r1), evm); err == nil {
+		t.Errorf("expected %s key spec to be rejected on network other than a fabric network", KeySpecSecp256r1)
+	}
+	if _, err := resolveKeySpec(common.StringOrNil(KeySpecSecp256k1), fabricNetwork()); err == nil {
+		t.Errorf("expected %s key spec to be rejected on fabric network", KeySpecSecp256k1)
+	}
+	if _, err := resolveKeySpec(common.StringOrNil("Ed25519"), evm); err == nil {
+		t.Error("expected unsupported key spec to be rejected")
+	}
+}
+
+func TestKeystoreSignUsesSecp256r1Key(t *testing.T) {
+	setupKeystore(t)
+
+	privateKey := "0xc9afa9d845ba75166b5c215767b1d6934e50c3db36e89b127b8a622b120f6721"
+	a := &Account{
+		Spec:       common.StringOrNil(KeySpecSecp256r1),
+		PrivateKey: common.StringOrNil(privateKey),
+	}
+	if err := a.generateKeystore(fabricNetwork()); err != nil {
+		t.Fatalf("failed to generate keystore; %s", err.Error())
+	}
+
+	d, _ := new(big.Int).SetString(strings.TrimPrefix(privateKey, "0x"), 16)
+	x, y := elliptic.P256().ScalarBaseMult(d.Bytes())
+	uncompressed := elliptic.Marshal(elliptic.P256(), x, y)
+	if a.PublicKey == nil || *a.PublicKey != "0x"+hex.EncodeToString(uncompressed) {
+		t.Errorf("expected secp256r1 public key of the imported private key; got %v", a.PublicKey)
+	}
+	ski := sha256.Sum256(uncompressed)
+	if a.Address != hex.EncodeToString(ski[:]) {
+		t.Errorf("expected account to be addressed by the subject key identifier of its key; got %s", a.Address)
+	}
+	if a.PrivateKey != nil {
+		t.Error("expected imported private key to be cleared")
+	}
+
+	digest := sha256.Sum256([]byte("proposal"))
+	sig, err := a.KeystoreSign(digest[:])
+	if err != nil {
+		t.Fatalf("failed to sign digest; %s", err.Error())
+	}
+	if len(sig) != 64 {
+		t.Fatalf("expected 64-byte r || s signature; got %d bytes", len(sig))
+	}
+
+	publicKey := &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	if !ecdsa.Verify(publicKey, digest[:], new(big.Int).SetBytes(sig[0:32]), new(big.Int).SetBytes(sig[32:])) {
+		t.Error("expected signature to verify using the secp256r1 public key")
+	}
+}
@@ -0,0 +1,328 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package wallet
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	vault "github.com/provideplatform/provide-go/api/vault"
+	util "github.com/provideplatform/provide-go/common/util"
+)
+
+// eip712DomainType is the EIP-712 type of the domain separator
+const eip712DomainType = "EIP712Domain"
+
+// messageEncodingUTF8 signs the message as UTF-8 text; this is the default message encoding
+const messageEncodingUTF8 = "utf8"
+
+// messageEncodingHex signs the bytes of the hex-encoded message
+const messageEncodingHex = "hex"
+
+// SignatureRequest is a request to sign, or verify the signature of, an EIP-191 (personal_sign)
+// message or EIP-712 typed data using the key material of an account or HD wallet
+type SignatureRequest struct {
+	Message          *string          `json:"message,omitempty"`    // EIP-191 message
+	Encoding         *string          `json:"encoding,omitempty"`   // encoding of the message; utf8 (default) or hex
+	TypedData        *json.RawMessage `json:"typed_data,omitempty"` // EIP-712 typed data, i.e., types, primaryType, domain and message
+	HDDerivationPath *string          `json:"hd_derivation_path,omitempty"`
+	Signature        *string          `json:"signature,omitempty"` // hex-encoded signature to verify
+}
+
+// SignatureResponse is returned after signing, or verifying the signature of, a message or typed data
+type SignatureResponse struct {
+	Address          string  `json:"address"`
+	Digest           string  `json:"digest"`
+	HDDerivationPath *string `json:"hd_derivation_path,omitempty"`
+	Signature        string  `json:"signature"`
+	Signer           *string `json:"signer,omitempty"`   // address recovered from the signature; only rendered upon verification
+	Verified         *bool   `json:"verified,omitempty"` // true if the recovered signer is the address; only rendered upon verification
+}
+
+// Digest returns the EIP-191 or EIP-712 digest to be signed
+func (r *SignatureRequest) Digest() ([]byte, error) {
+	if r.Message != nil && r.TypedData != nil {
+		return nil, errors.New("only a message OR typed data should be provided")
+	}
+
+	if r.Message != nil {
+		data, err := r.messageBytes()
+		if err != nil {
+			return nil, err
+		}
+		return accounts.TextHash(data), nil
+	}
+
+	if r.TypedData != nil {
+		return typedDataDigest(*r.TypedData)
+	}
+
+	return nil, errors.New("message or typed data required")
+}
+
+// messageBytes decodes the message using the requested encoding; the message is never
+// implicitly decoded as hex, so a 0x-prefixed message is signed as text unless hex encoding is requested
+func (r *SignatureRequest) messageBytes() ([]byte, error) {
+	encoding := messageEncodingUTF8
+	if r.Encoding != nil {
+		encoding = strings.ToLower(*r.Encoding)
+	}
+
+	switch encoding {
+	case messageEncodingUTF8:
+		return []byte(*r.Message), nil
+	case messageEncodingHex:
+		data, err := hex.DecodeString(strings.TrimPrefix(*r.Message, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode hex-encoded message; %s", err.Error())
+		}
+		return data, nil
+	}
+
+	return nil, fmt.Errorf("unsupported message encoding: %s", encoding)
+}
+
+// typedDataDigest returns the EIP-712 digest of the given typed data, i.e.,
+// keccak256("\x19\x01" ‖ domainSeparator ‖ hashStruct(message))
+func typedDataDigest(raw json.RawMessage) ([]byte, error) {
+	// numeric values are normalized to strings so integer values are not truncated to float64
+	decoder := json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+
+	var params interface{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse typed data; %s", err.Error())
+	}
+
+	normalized, _ := json.Marshal(normalizeTypedDataNumbers(params))
+
+	var typedData core.TypedData
+	err = json.Unmarshal(normalized, &typedData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse typed data; %s", err.Error())
+	}
+
+	if _, ok := typedData.Types[eip712DomainType]; !ok {
+		return nil, fmt.Errorf("failed to parse typed data; %s type required", eip712DomainType)
+	}
+
+	if _, ok := typedData.Types[typedData.PrimaryType]; !ok {
+		return nil, fmt.Errorf("failed to parse typed data; undefined primary type: %s", typedData.PrimaryType)
+	}
+
+	domainSeparator, err := typedData.HashStruct(eip712DomainType, typedData.Domain.Map())
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash typed data domain; %s", err.Error())
+	}
+
+	structHash, err := typedData.HashStruct(typedData.PrimaryType, typedData.Message)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash typed data message; %s", err.Error())
+	}
+
+	return ethcrypto.Keccak256([]byte("\x19\x01"), domainSeparator, structHash), nil
+}
+
+// normalizeTypedDataNumbers recursively replaces numeric values with their string representation
+func normalizeTypedDataNumbers(val interface{}) interface{} {
+	switch v := val.(type) {
+	case json.Number:
+		return v.String()
+	case map[string]interface{}:
+		for key := range v {
+			v[key] = normalizeTypedDataNumbers(v[key])
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = normalizeTypedDataNumbers(v[i])
+		}
+	}
+	return val
+}
+
+// RecoverSigner recovers the address which signed the given digest from the given hex-encoded signature
+func RecoverSigner(digest []byte, signature string) (string, error) {
+	sig, err := hex.DecodeString(strings.TrimPrefix(signature, "0x"))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode signature; %s", err.Error())
+	}
+
+	if len(sig) != ethcrypto.SignatureLength {
+		return "", fmt.Errorf("invalid %d-byte signature", len(sig))
+	}
+
+	if sig[ethcrypto.RecoveryIDOffset] >= 27 {
+		sig[ethcrypto.RecoveryIDOffset] -= 27
+	}
+
+	pubkey, err := ethcrypto.SigToPub(digest, sig)
+	if err != nil {
+		return "", fmt.Errorf("failed to recover signer; %s", err.Error())
+	}
+
+	return ethcrypto.PubkeyToAddress(*pubkey).Hex(), nil
+}
+
+// encodeSignature returns the hex-encoded signature using the [R || S || V] format, where V is 27 or 28
+func encodeSignature(sig []byte) (string, error) {
+	if len(sig) != ethcrypto.SignatureLength {
+		return "", fmt.Errorf("invalid %d-byte signature", len(sig))
+	}
+
+	if sig[ethcrypto.RecoveryIDOffset] < 27 {
+		sig[ethcrypto.RecoveryIDOffset] += 27
+	}
+
+	return hexutil.Encode(sig), nil
+}
+
+// SignDigest signs the given digest using the account key material
+func (a *Account) SignDigest(digest []byte) (*SignatureResponse, error) {
+	if a.UsesSecp256r1() {
+		return nil, fmt.Errorf("failed to sign digest using account %s; %s accounts cannot sign messages or typed data", a.ID, KeySpecSecp256r1)
+	}
+
+	var sig []byte
+	var err error
+
+	if a.UsesKeystore() {
+		sig, err = a.KeystoreSign(digest)
+	} else if a.VaultID != nil && a.KeyID != nil {
+		sig, err = vaultSign(a.VaultID.String(), a.KeyID.String(), digest, map[string]interface{}{})
+	} else {
+		err = errors.New("no key material for account")
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign digest using account %s; %s", a.ID, err.Error())
+	}
+
+	signature, err := encodeSignature(sig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign digest using account %s; %s", a.ID, err.Error())
+	}
+
+	return &SignatureResponse{
+		Address:   a.Address,
+		Digest:    hexutil.Encode(digest),
+		Signature: signature,
+	}, nil
+}
+
+// SignDigest signs the given digest using the key derived at the given HD derivation path;
+// DefaultHDDerivationPath is used if no path is given
+func (w *Wallet) SignDigest(derivationPath *string, digest []byte) (*SignatureResponse, error) {
+	path := DefaultHDDerivationPath
+	if derivationPath != nil {
+		path = *derivationPath
+	}
+
+	address, err := w.DeriveSigner(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var sig []byte
+	if w.UsesKeystore() {
+		sig, err = w.KeystoreSign(&path, digest)
+	} else {
+		sig, err = vaultSign(w.vaultID(), w.KeyID.String(), digest, map[string]interface{}{
+			"hdwallet": map[string]interface{}{
+				"hd_derivation_path": path,
+			},
+		})
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign digest using HD wallet %s; %s", w.ID, err.Error())
+	}
+
+	signature, err := encodeSignature(sig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign digest using HD wallet %s; %s", w.ID, err.Error())
+	}
+
+	return &SignatureResponse{
+		Address:          address,
+		Digest:           hexutil.Encode(digest),
+		HDDerivationPath: &path,
+		Signature:        signature,
+	}, nil
+}
+
+// vaultID returns the id of the vault which holds the key material of the HD wallet, falling back
+// to the default vault for wallets which do not reference their vault
+func (w *Wallet) vaultID() string {
+	if w.VaultID != nil && *w.VaultID != uuid.Nil {
+		return w.VaultID.String()
+	}
+	return common.DefaultVault.ID.String()
+}
+
+// DeriveSigner returns the address of the key derived at the given HD derivation path
+func (w *Wallet) DeriveSigner(path string) (string, error) {
+	if w.UsesKeystore() {
+		_, address, _, err := w.KeystoreDerive(&path)
+		if err != nil {
+			return "", fmt.Errorf("failed to derive signer for HD wallet %s; %s", w.ID, err.Error())
+		}
+		return address, nil
+	}
+
+	if w.KeyID == nil {
+		return "", fmt.Errorf("failed to derive signer for HD wallet %s; no key material", w.ID)
+	}
+
+	key, err := vault.DeriveKey(util.DefaultVaultAccessJWT, w.vaultID(), w.KeyID.String(), map[string]interface{}{
+		"hd_derivation_path": path,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to derive signer for HD wallet %s; %s", w.ID, err.Error())
+	}
+
+	if key.Address == nil {
+		return "", fmt.Errorf("failed to derive signer for HD wallet %s; no address derived at path: %s", w.ID, path)
+	}
+
+	return *key.Address, nil
+}
+
+// vaultSign signs the given digest using the given vault key
+func vaultSign(vaultID, keyID string, digest []byte, opts map[string]interface{}) ([]byte, error) {
+	resp, err := vault.SignMessage(util.DefaultVaultAccessJWT, vaultID, keyID, hex.EncodeToString(digest), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Signature == nil {
+		return nil, errors.New("no signature returned by vault")
+	}
+
+	common.Log.Debugf("signed %d-byte digest using vault key: %s", len(digest), keyID)
+	return hex.DecodeString(strings.TrimPrefix(*resp.Signature, "0x"))
+}
@@ -0,0 +1,181 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package wallet
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	vault "github.com/provideplatform/provide-go/api/vault"
+)
+
+// testSigningKey is keccak256("cow"), the signing key of the EIP-712 specification example
+const testSigningKey = "0xc85ef7d79691fe79573b1a7064c19c1a9819ebdbd1faaab1a8ec92344438aaf4"
+
+// testSigningAddress is the address of testSigningKey
+const testSigningAddress = "0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"
+
+// testTypedData is the EIP-712 specification example
+const testTypedData = `{
+	"types": {
+		"EIP712Domain": [
+			{"name": "name", "type": "string"},
+			{"name": "version", "type": "string"},
+			{"name": "chainId", "type": "uint256"},
+			{"name": "verifyingContract", "type": "address"}
+		],
+		"Person": [
+			{"name": "name", "type": "string"},
+			{"name": "wallet", "type": "address"}
+		],
+		"Mail": [
+			{"name": "from", "type": "Person"},
+			{"name": "to", "type": "Person"},
+			{"name": "contents", "type": "string"}
+		]
+	},
+	"primaryType": "Mail",
+	"domain": {
+		"name": "Ether Mail",
+		"version": "1",
+		"chainId": 1,
+		"verifyingContract": "0xCcCCccccCCCCcCCCCCCcCcCccCcCCCcCcccccccC"
+	},
+	"message": {
+		"from": {"name": "Cow", "wallet": "0xCD2a3d9F938E13CD947Ec05AbC7FE734Df8DD826"},
+		"to": {"name": "Bob", "wallet": "0xbBbBBBBbbBBBbbbBbbBbbbbBBbBbbbbBbBbbBBbB"},
+		"contents": "Hello, Bob!"
+	}
+}`
+
+var signatureVectors = []struct {
+	name      string
+	req       *SignatureRequest
+	digest    string
+	signature string
+}{
+	{
+		name:      "eip-191 utf8 message",
+		req:       &SignatureRequest{Message: common.StringOrNil("Hello, world!")},
+		digest:    "0xb453bd4e271eed985cbab8231da609c4ce0a9cf1f763b6c1594e76315510e0f1",
+		signature: "0x7149ec9b0c79f94f06c4f0bb07503b63e33582be8316c616b45068c0cf76e5a079eae257e239380fac14043c435e63dae12763d7327cbca6875f8771aa526b831c",
+	},
+	{
+		name:      "eip-191 0x-prefixed utf8 message",
+		req:       &SignatureRequest{Message: common.StringOrNil("0xdeadbeef")},
+		digest:    "0xefedd0a9a0294228c3977d7fbb68c7d40279f8b408cf3e24ef1823b179709e58",
+		signature: "0x8fc4827d6eaf6d2cbfc17f80888b5891194ff3fc08ef215bcf6402ed0cec699f4eff5411888acf0f25ce1a2c217a33e387ab9ba9541f5575facfb63b4df4adf81b",
+	},
+	{
+		name:      "eip-191 hex message",
+		req:       &SignatureRequest{Message: common.StringOrNil("0xdeadbeef"), Encoding: common.StringOrNil("hex")},
+		digest:    "0xd1c7f1a06a4f9a535077e50ad23244ce2c6ae443fcd412965226f3df5d28eaaa",
+		signature: "0x7a962b63cef41a9cc1d3a6805da9f982a2a562b2d7a1ee75c78e5cd4464db9bf6e2b425bae2ce2c74a47631a2ec67c7efcc3dade1201fd5306443b85ec6116071b",
+	},
+	{
+		name:      "eip-712 typed data",
+		req:       &SignatureRequest{TypedData: typedDataOrNil(testTypedData)},
+		digest:    "0xbe609aee343fb3c4b28e1df9e632fca64fcfaede20f02e86244efddf30957bd2",
+		signature: "0x4355c47d63924e8a72e509b65029052eb6c299d53a04e167c5775fd466751c9d07299936d304c153f6443dfa05f40ff007d72911b6f72307f996231605b915621c",
+	},
+}
+
+func typedDataOrNil(raw string) *json.RawMessage {
+	typedData := json.RawMessage(raw)
+	return &typedData
+}
+
+func TestSignatureRequestDigestVectors(t *testing.T) {
+	for _, v := range signatureVectors {
+		digest, err := v.req.Digest()
+		if err != nil {
+			t.Errorf("%s: failed to resolve digest; %s", v.name, err.Error())
+			continue
+		}
+		if hexutil.Encode(digest) != v.digest {
+			t.Errorf("%s: expected digest %s; got %s", v.name, v.digest, hexutil.Encode(digest))
+		}
+	}
+}
+
+func TestSignatureRequestRejectsInvalidEncoding(t *testing.T) {
+	req := &SignatureRequest{Message: common.StringOrNil("0xzz"), Encoding: common.StringOrNil("hex")}
+	if _, err := req.Digest(); err == nil {
+		t.Error("expected invalid hex-encoded message to be rejected")
+	}
+
+	req = &SignatureRequest{Message: common.StringOrNil("hello"), Encoding: common.StringOrNil("base64")}
+	if _, err := req.Digest(); err == nil {
+		t.Error("expected unsupported message encoding to be rejected")
+	}
+}
+
+func TestAccountSignDigestVectors(t *testing.T) {
+	setupKeystore(t)
+
+	acct := &Account{PrivateKey: common.StringOrNil(testSigningKey)}
+	if err := acct.generateKeystore(nil); err != nil {
+		t.Fatalf("failed to generate keystore; %s", err.Error())
+	}
+	if acct.Address != testSigningAddress {
+		t.Fatalf("expected imported account address %s; got %s", testSigningAddress, acct.Address)
+	}
+
+	for _, v := range signatureVectors {
+		digest, _ := v.req.Digest()
+		resp, err := acct.SignDigest(digest)
+		if err != nil {
+			t.Errorf("%s: failed to sign digest; %s", v.name, err.Error())
+			continue
+		}
+		if resp.Signature != v.signature {
+			t.Errorf("%s: expected signature %s; got %s", v.name, v.signature, resp.Signature)
+		}
+
+		signer, err := RecoverSigner(digest, resp.Signature)
+		if err != nil || signer != testSigningAddress {
+			t.Errorf("%s: expected signature to recover signer %s; got %s", v.name, testSigningAddress, signer)
+		}
+	}
+}
+
+func TestWalletVaultIDPrefersWalletVault(t *testing.T) {
+	defaultVault := common.DefaultVault
+	defaultVaultID, _ := uuid.NewV4()
+	common.DefaultVault = &vault.Vault{}
+	common.DefaultVault.ID = defaultVaultID
+	t.Cleanup(func() {
+		common.DefaultVault = defaultVault
+	})
+
+	vaultID, _ := uuid.NewV4()
+	w := &Wallet{VaultID: &vaultID}
+	if w.vaultID() != vaultID.String() {
+		t.Errorf("expected HD wallet key material to be resolved from vault %s; got %s", vaultID, w.vaultID())
+	}
+
+	w = &Wallet{}
+	if w.vaultID() != defaultVaultID.String() {
+		t.Errorf("expected HD wallet without a vault to fall back to the default vault %s; got %s", defaultVaultID, w.vaultID())
+	}
+}
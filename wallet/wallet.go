@@ -17,6 +17,7 @@
 package wallet
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"strconv"
@@ -46,6 +47,10 @@ type Wallet struct {
 	VaultID *uuid.UUID `sql:"type:uuid" json:"vault_id,omitempty"`
 	KeyID   *uuid.UUID `sql:"type:uuid" json:"key_id,omitempty"`
 
+	// Mnemonic held in the local keystore (i.e., when vault is not used); see KeyStorage
+	KeyStorage *string          `sql:"-" json:"key_storage,omitempty"`
+	Keystore   *json.RawMessage `sql:"type:json" json:"-"`
+
 	Path        *string    `sql:"-" json:"path,omitempty"`
 	Purpose     *int       `sql:"not null;default:44" json:"purpose,omitempty"`
 	Mnemonic    *string    `sql:"-" json:"mnemonic,omitempty"`
@@ -62,7 +67,14 @@ type Wallet struct {
 func (w *Wallet) Create() bool {
 	db := dbconf.DatabaseConnection()
 
-	w.generate(db)
+	err := w.generate(db)
+	if err != nil {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+		return false
+	}
+
 	if !w.Validate() {
 		return false
 	}
@@ -111,15 +123,17 @@ func (w *Wallet) Validate() bool {
 			Message: common.StringOrNil("only a user OR organization identifier should be provided"),
 		})
 	}
-	if w.VaultID == nil || *w.VaultID == uuid.Nil {
-		w.Errors = append(w.Errors, &provide.Error{
-			Message: common.StringOrNil("vault id required"),
-		})
-	}
-	if w.KeyID == nil || *w.KeyID == uuid.Nil {
-		w.Errors = append(w.Errors, &provide.Error{
-			Message: common.StringOrNil("vault key id required"),
-		})
+	if w.Keystore == nil {
+		if w.VaultID == nil || *w.VaultID == uuid.Nil {
+			w.Errors = append(w.Errors, &provide.Error{
+				Message: common.StringOrNil("vault id required"),
+			})
+		}
+		if w.KeyID == nil || *w.KeyID == uuid.Nil {
+			w.Errors = append(w.Errors, &provide.Error{
+				Message: common.StringOrNil("vault key id required"),
+			})
+		}
 	}
 	// if w.Purpose == nil {
 	// 	w.Errors = append(w.Errors, &provide.Error{
@@ -142,6 +156,29 @@ func (w *Wallet) Validate() bool {
 func (w *Wallet) DeriveHardened(db *gorm.DB, coin, account uint32) (*Wallet, error) {
 	pathstr := fmt.Sprintf("m/%d'/%d'/%d'", *w.Purpose, coin, account)
 
+	if w.UsesKeystore() {
+		// the hardened account is derived from the mnemonic in the local keystore; the parent wallet is left unmodified
+		publicKey, err := w.keystoreHardenedPublicKey(uint32(*w.Purpose), coin, account)
+		if err != nil {
+			err := fmt.Errorf("unable to generate key material for HD wallet; %s", err.Error())
+			common.Log.Warning(err.Error())
+			return nil, err
+		}
+
+		return &Wallet{
+			Model:          provide.Model{ID: w.ID},
+			WalletID:       &w.ID,
+			ApplicationID:  w.ApplicationID,
+			UserID:         w.UserID,
+			OrganizationID: w.OrganizationID,
+			Keystore:       w.Keystore,
+			Path:           &pathstr,
+			Purpose:        w.Purpose,
+			PublicKey:      common.StringOrNil(publicKey),
+			Wallet:         w,
+		}, nil
+	}
+
 	// FIXME-- this should be audited -- it is probably creating additional HD wallets that aren't persisted within nchain...
 	key, err := vault.CreateKey(util.DefaultVaultAccessJWT, common.DefaultVault.ID.String(), map[string]interface{}{
 		"type":               "asymmetric",
@@ -194,7 +231,27 @@ func (w *Wallet) DeriveAddress(db *gorm.DB, index uint32, chain *uint32) (*Accou
 		return nil, errors.New("failed to derive signing address without hardened HD path")
 	}
 
-	key, err := vault.DeriveKey(util.DefaultVaultAccessJWT, common.DefaultVault.ID.String(), w.KeyID.String(), map[string]interface{}{
+	if w.UsesKeystore() {
+		privateKey, address, _, err := w.KeystoreDerive(&pathstr)
+		if err != nil {
+			err := fmt.Errorf("unable to generate key material for HD wallet; %s", err.Error())
+			common.Log.Warning(err.Error())
+			return nil, err
+		}
+
+		return &Account{
+			ApplicationID:    w.ApplicationID,
+			OrganizationID:   w.OrganizationID,
+			UserID:           w.UserID,
+			WalletID:         &w.ID,
+			Wallet:           w,
+			HDDerivationPath: &pathstr,
+			PublicKey:        common.StringOrNil(keystorePublicKey(privateKey)),
+			Address:          address,
+		}, nil
+	}
+
+	key, err := vault.DeriveKey(util.DefaultVaultAccessJWT, w.vaultID(), w.KeyID.String(), map[string]interface{}{
 		"hd_derivation_path": pathstr,
 	})
 
@@ -229,6 +286,24 @@ func (w *Wallet) generate(db *gorm.DB) error {
 		mnemonic = *w.Mnemonic
 	}
 
+	keyStorage, err := resolveKeyStorage(w.KeyStorage)
+	if err != nil {
+		common.Log.Warning(err.Error())
+		return err
+	}
+
+	if keyStorage == common.KeyStorageKeystore {
+		err := w.generateKeystore()
+		if err != nil {
+			err := fmt.Errorf("unable to generate key material for HD wallet; %s", err.Error())
+			common.Log.Warning(err.Error())
+			return err
+		}
+
+		common.Log.Debugf("generated HD wallet using local keystore; public key: %s", *w.PublicKey)
+		return nil
+	}
+
 	key, err := vault.CreateKey(util.DefaultVaultAccessJWT, common.DefaultVault.ID.String(), map[string]interface{}{
 		"type":     "asymmetric",
 		"usage":    "sign/verify",
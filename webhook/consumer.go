@@ -0,0 +1,318 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	dbconf "github.com/kthomas/go-db-config"
+	natsutil "github.com/kthomas/go-natsutil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/provideplatform/nchain/common"
+	"github.com/provideplatform/nchain/deadletter"
+)
+
+const defaultNatsStream = "nchain"
+
+const natsWebhookDispatchSubject = "nchain.webhook.dispatch"
+const natsWebhookDispatchMaxInFlight = 1024
+const natsWebhookDispatchMaxDeliveries = 10
+const webhookDispatchAckWait = time.Second * 30
+
+// failed webhook deliveries are redelivered once their backoff elapses using a delayed nak
+const natsWebhookDeliverySubject = "nchain.webhook.deliver"
+const natsWebhookDeliveryMaxInFlight = 1024
+const natsWebhookDeliveryMaxDeliveries = 512
+const webhookDeliveryAckWait = time.Second * 30
+
+// webhookDeliveryMaxAttempts is the number of attempts after which a delivery is marked failed
+const webhookDeliveryMaxAttempts = 8
+
+// webhookDeliveryInitialBackoff is doubled after each failed attempt
+const webhookDeliveryInitialBackoff = time.Second * 30
+
+const webhookDeliveryTimeout = time.Second * 10
+
+const webhookHeaderDelivery = "X-Nchain-Delivery"
+const webhookHeaderEvent = "X-Nchain-Event"
+const webhookHeaderSignature = "X-Nchain-Signature"
+const webhookHeaderTimestamp = "X-Nchain-Timestamp"
+
+var waitGroup sync.WaitGroup
+
+// webhookDialer refuses connections to disallowed addresses, so a webhook host which resolved
+// to an allowed address when the webhook was created cannot be rebound to an internal address
+var webhookDialer = &net.Dialer{
+	Timeout: webhookDeliveryTimeout,
+	Control: func(network, address string, c syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+
+		ip := net.ParseIP(host)
+		if ip == nil || isDisallowedWebhookIP(ip) {
+			return errDisallowedWebhookAddress
+		}
+		return nil
+	},
+}
+
+var webhookClient = &http.Client{
+	Timeout: webhookDeliveryTimeout,
+	Transport: &http.Transport{
+		Proxy: nil, // deliveries are not proxied, as the proxy would connect on behalf of the dialer
+		DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return webhookDialer.DialContext(ctx, network, address)
+		},
+		TLSHandshakeTimeout: webhookDeliveryTimeout,
+	},
+}
+
+func init() {
+	if !common.ConsumeNATSStreamingSubscriptions {
+		common.Log.Debug("Webhook package consumer configured to skip NATS streaming subscription setup")
+		return
+	}
+
+	natsutil.EstablishSharedNatsConnection(nil)
+	natsutil.NatsCreateStream(defaultNatsStream, []string{
+		fmt.Sprintf("%s.>", defaultNatsStream),
+	})
+
+	createNatsWebhookDispatchSubscriptions(&waitGroup)
+	createNatsWebhookDeliverySubscriptions(&waitGroup)
+}
+
+func createNatsWebhookDispatchSubscriptions(wg *sync.WaitGroup) {
+	for i := uint64(0); i < natsutil.GetNatsConsumerConcurrency(); i++ {
+		natsutil.RequireNatsJetstreamSubscription(wg,
+			webhookDispatchAckWait,
+			natsWebhookDispatchSubject,
+			natsWebhookDispatchSubject,
+			natsWebhookDispatchSubject,
+			consumeWebhookDispatchMsg,
+			webhookDispatchAckWait,
+			natsWebhookDispatchMaxInFlight,
+			natsWebhookDispatchMaxDeliveries,
+			nil,
+		)
+	}
+}
+
+func createNatsWebhookDeliverySubscriptions(wg *sync.WaitGroup) {
+	for i := uint64(0); i < natsutil.GetNatsConsumerConcurrency(); i++ {
+		natsutil.RequireNatsJetstreamSubscription(wg,
+			webhookDeliveryAckWait,
+			natsWebhookDeliverySubject,
+			natsWebhookDeliverySubject,
+			natsWebhookDeliverySubject,
+			consumeWebhookDeliveryMsg,
+			webhookDeliveryAckWait,
+			natsWebhookDeliveryMaxInFlight,
+			natsWebhookDeliveryMaxDeliveries,
+			nil,
+		)
+	}
+}
+
+func consumeWebhookDispatchMsg(msg *nats.Msg) {
+	defer func() {
+		if r := recover(); r != nil {
+			common.Log.Warningf("recovered from panic during NATS webhook dispatch message handling; %s", r)
+			deadletter.Term(msg, fmt.Sprintf("recovered from panic; %s", r))
+		}
+	}()
+
+	common.Log.Debugf("consuming %d-byte NATS webhook dispatch message on subject: %s", len(msg.Data), msg.Subject)
+
+	dispatched := &dispatchMsg{}
+	err := json.Unmarshal(msg.Data, &dispatched)
+	if err != nil {
+		common.Log.Warningf("failed to umarshal webhook dispatch message; %s", err.Error())
+		deadletter.Term(msg, fmt.Sprintf("failed to umarshal webhook dispatch message; %s", err.Error()))
+		return
+	}
+
+	if dispatched.ApplicationID == nil && dispatched.OrganizationID == nil {
+		deadletter.Term(msg, "failed to consume NATS webhook dispatch message; no application or organization id provided")
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	var webhooks []*Webhook
+	subscribedWebhooksQuery(db, dispatched.ApplicationID, dispatched.OrganizationID, dispatched.NetworkID).Find(&webhooks)
+
+	for _, webhook := range webhooks {
+		if !webhook.subscribes(dispatched.Event) {
+			continue
+		}
+
+		err := webhook.enqueue(db, dispatched)
+		if err != nil {
+			// deliveries are derived from the dispatch, so enqueued deliveries are not duplicated upon redelivery
+			common.Log.Warningf("failed to enqueue %s webhook delivery for webhook: %s; %s", dispatched.Event, webhook.ID, err.Error())
+			msg.Nak()
+			return
+		}
+	}
+
+	msg.Ack()
+}
+
+func consumeWebhookDeliveryMsg(msg *nats.Msg) {
+	defer func() {
+		if r := recover(); r != nil {
+			common.Log.Warningf("recovered from panic during NATS webhook delivery message handling; %s", r)
+			deadletter.Term(msg, fmt.Sprintf("recovered from panic; %s", r))
+		}
+	}()
+
+	common.Log.Debugf("consuming %d-byte NATS webhook delivery message on subject: %s", len(msg.Data), msg.Subject)
+
+	var params map[string]interface{}
+	err := json.Unmarshal(msg.Data, &params)
+	if err != nil {
+		common.Log.Warningf("failed to umarshal webhook delivery message; %s", err.Error())
+		deadletter.Term(msg, fmt.Sprintf("failed to umarshal webhook delivery message; %s", err.Error()))
+		return
+	}
+
+	deliveryID, deliveryIDOk := params["delivery_id"].(string)
+	if !deliveryIDOk {
+		common.Log.Warningf("failed to consume NATS webhook delivery message; no delivery id provided")
+		deadletter.Term(msg, "failed to consume NATS webhook delivery message; no delivery id provided")
+		return
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	delivery := &Delivery{}
+	db.Where("id = ?", deliveryID).Find(&delivery)
+	if delivery == nil || delivery.ID == uuid.Nil {
+		common.Log.Warningf("failed to consume NATS webhook delivery message; no delivery resolved for id: %s", deliveryID)
+		deadletter.Term(msg, fmt.Sprintf("failed to consume NATS webhook delivery message; no delivery resolved for id: %s", deliveryID))
+		return
+	}
+
+	if delivery.Status == nil || *delivery.Status != deliveryStatusPending {
+		msg.Ack()
+		return
+	}
+
+	if delivery.NextAttemptAt != nil && time.Now().Before(*delivery.NextAttemptAt) {
+		common.NakWithDelay(msg, time.Until(*delivery.NextAttemptAt))
+		return
+	}
+
+	webhook := &Webhook{}
+	db.Where("id = ?", delivery.WebhookID).Find(&webhook)
+	if webhook == nil || webhook.ID == uuid.Nil {
+		common.Log.Warningf("failed to deliver webhook; no webhook resolved for delivery: %s", deliveryID)
+		deadletter.Term(msg, fmt.Sprintf("failed to deliver webhook; no webhook resolved for delivery: %s", deliveryID))
+		return
+	}
+
+	webhook.encryptLegacySecret(db)
+
+	delivery.Attempts++
+	err = webhook.deliver(delivery)
+	if err == nil {
+		deliveredAt := time.Now()
+		delivery.Status = common.StringOrNil(deliveryStatusDelivered)
+		delivery.DeliveredAt = &deliveredAt
+		delivery.NextAttemptAt = nil
+		delivery.Error = nil
+		db.Save(&delivery)
+
+		common.Log.Debugf("delivered %s webhook %s to %s after %d attempt(s)", *delivery.Event, delivery.ID, *webhook.URL, delivery.Attempts)
+		msg.Ack()
+		return
+	}
+
+	delivery.Error = common.StringOrNil(err.Error())
+	if delivery.Attempts >= webhookDeliveryMaxAttempts {
+		delivery.Status = common.StringOrNil(deliveryStatusFailed)
+		delivery.NextAttemptAt = nil
+		db.Save(&delivery)
+
+		common.Log.Warningf("failed to deliver %s webhook %s to %s after %d attempt(s); %s", *delivery.Event, delivery.ID, *webhook.URL, delivery.Attempts, err.Error())
+		msg.Ack()
+		return
+	}
+
+	backoff := webhookDeliveryBackoff(delivery.Attempts)
+	nextAttemptAt := time.Now().Add(backoff)
+	delivery.NextAttemptAt = &nextAttemptAt
+	db.Save(&delivery)
+
+	common.Log.Debugf("failed to deliver %s webhook %s to %s; next attempt at %s; %s", *delivery.Event, delivery.ID, *webhook.URL, nextAttemptAt, err.Error())
+	common.NakWithDelay(msg, backoff)
+}
+
+// webhookDeliveryBackoff returns the delay before the next attempt of a delivery which failed
+// the given number of attempts
+func webhookDeliveryBackoff(attempts uint32) time.Duration {
+	return webhookDeliveryInitialBackoff * time.Duration(1<<(attempts-1))
+}
+
+// deliver POSTs the delivery payload to the webhook url, signed using the webhook secret
+func (w *Webhook) deliver(delivery *Delivery) error {
+	payload := []byte(*delivery.Payload)
+	timestamp := time.Now().Unix()
+
+	signature, err := w.Sign(timestamp, payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, *w.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set(webhookHeaderDelivery, delivery.ID.String())
+	req.Header.Set(webhookHeaderEvent, *delivery.Event)
+	req.Header.Set(webhookHeaderTimestamp, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(webhookHeaderSignature, fmt.Sprintf("sha256=%s", signature))
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		delivery.ResponseStatus = nil
+		return fmt.Errorf("failed to deliver webhook; %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	delivery.ResponseStatus = &resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to deliver webhook; received status code %d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,205 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	uuid "github.com/kthomas/go.uuid"
+	provide "github.com/provideplatform/provide-go/common"
+	util "github.com/provideplatform/provide-go/common/util"
+)
+
+// InstallWebhooksAPI installs the handlers using the given gin Engine
+func InstallWebhooksAPI(r *gin.Engine) {
+	r.GET("/api/v1/webhooks", webhooksListHandler)
+	r.POST("/api/v1/webhooks", createWebhookHandler)
+	r.GET("/api/v1/webhooks/:id", webhookDetailsHandler)
+	r.DELETE("/api/v1/webhooks/:id", deleteWebhookHandler)
+
+	r.GET("/api/v1/webhooks/:id/deliveries", webhookDeliveriesListHandler)
+	r.GET("/api/v1/webhooks/:id/deliveries/:deliveryId", webhookDeliveryDetailsHandler)
+}
+
+// authorizedWebhookQuery scopes the given query to webhooks owned by the authorized application or organization
+func authorizedWebhookQuery(db *gorm.DB, appID, orgID *uuid.UUID) *gorm.DB {
+	if appID != nil {
+		db = db.Where("webhooks.application_id = ?", appID)
+	}
+	if orgID != nil {
+		db = db.Where("webhooks.organization_id = ?", orgID)
+	}
+	return db
+}
+
+// resolveWebhook resolves the webhook with the given id on behalf of the authorized application or organization
+func resolveWebhook(c *gin.Context, appID, orgID *uuid.UUID) *Webhook {
+	webhook := &Webhook{}
+	authorizedWebhookQuery(dbconf.DatabaseConnection(), appID, orgID).Where("webhooks.id = ?", c.Param("id")).Find(&webhook)
+	if webhook == nil || webhook.ID == uuid.Nil {
+		provide.RenderError("webhook not found", 404, c)
+		return nil
+	}
+	return webhook
+}
+
+func webhooksListHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && orgID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	query := authorizedWebhookQuery(dbconf.DatabaseConnection(), appID, orgID)
+
+	if c.Query("network_id") != "" {
+		query = query.Where("webhooks.network_id = ?", c.Query("network_id"))
+	}
+
+	var webhooks []*Webhook
+	query = query.Order("webhooks.created_at ASC")
+	provide.Paginate(c, query, &Webhook{}).Find(&webhooks)
+	for _, webhook := range webhooks {
+		webhook.Secret = nil
+	}
+	provide.Render(webhooks, 200, c)
+}
+
+func webhookDetailsHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && orgID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	webhook := resolveWebhook(c, appID, orgID)
+	if webhook == nil {
+		return
+	}
+
+	webhook.Secret = nil
+	provide.Render(webhook, 200, c)
+}
+
+func createWebhookHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && orgID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	buf, err := c.GetRawData()
+	if err != nil {
+		provide.RenderError(err.Error(), 400, c)
+		return
+	}
+
+	webhook := &Webhook{}
+	err = json.Unmarshal(buf, webhook)
+	if err != nil {
+		provide.RenderError(err.Error(), 422, c)
+		return
+	}
+	webhook.ApplicationID = appID
+	webhook.OrganizationID = orgID
+
+	if webhook.Create() {
+		provide.Render(webhook, 201, c)
+	} else {
+		obj := map[string]interface{}{}
+		obj["errors"] = webhook.Errors
+		provide.Render(obj, 422, c)
+	}
+}
+
+func deleteWebhookHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && orgID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	webhook := resolveWebhook(c, appID, orgID)
+	if webhook == nil {
+		return
+	}
+
+	if !webhook.Delete() {
+		provide.RenderError("webhook not deleted", 500, c)
+		return
+	}
+	provide.Render(nil, 204, c)
+}
+
+func webhookDeliveriesListHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && orgID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	webhook := resolveWebhook(c, appID, orgID)
+	if webhook == nil {
+		return
+	}
+
+	query := dbconf.DatabaseConnection().Where("webhook_deliveries.webhook_id = ?", webhook.ID)
+
+	if c.Query("event") != "" {
+		query = query.Where("webhook_deliveries.event = ?", c.Query("event"))
+	}
+
+	if c.Query("status") != "" {
+		query = query.Where("webhook_deliveries.status = ?", c.Query("status"))
+	}
+
+	var deliveries []*Delivery
+	query = query.Order("webhook_deliveries.created_at DESC")
+	provide.Paginate(c, query, &Delivery{}).Find(&deliveries)
+	provide.Render(deliveries, 200, c)
+}
+
+func webhookDeliveryDetailsHandler(c *gin.Context) {
+	appID := util.AuthorizedSubjectID(c, "application")
+	orgID := util.AuthorizedSubjectID(c, "organization")
+	if appID == nil && orgID == nil {
+		provide.RenderError("unauthorized", 401, c)
+		return
+	}
+
+	webhook := resolveWebhook(c, appID, orgID)
+	if webhook == nil {
+		return
+	}
+
+	delivery := &Delivery{}
+	dbconf.DatabaseConnection().Where("webhook_id = ? AND id = ?", webhook.ID, c.Param("deliveryId")).Find(&delivery)
+	if delivery == nil || delivery.ID == uuid.Nil {
+		provide.RenderError("webhook delivery not found", 404, c)
+		return
+	}
+
+	provide.Render(delivery, 200, c)
+}
@@ -0,0 +1,489 @@
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	dbconf "github.com/kthomas/go-db-config"
+	natsutil "github.com/kthomas/go-natsutil"
+	pgputil "github.com/kthomas/go-pgputil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+	provide "github.com/provideplatform/provide-go/api"
+)
+
+// EventTxBroadcast is emitted when a tx is broadcast to the network
+const EventTxBroadcast = "tx.broadcast"
+
+// EventTxSuccess is emitted when a tx is finalized successfully
+const EventTxSuccess = "tx.success"
+
+// EventTxFailed is emitted when a tx fails
+const EventTxFailed = "tx.failed"
+
+// EventTxReorg is emitted when a finalized tx is rolled back because its block was orphaned by a chain reorg
+const EventTxReorg = "tx.reorg"
+
+// EventContractDeployed is emitted when a contract deployed by a tx is resolved from its receipt
+const EventContractDeployed = "contract.deployed"
+
+const deliveryStatusPending = "pending"
+const deliveryStatusDelivered = "delivered"
+const deliveryStatusFailed = "failed"
+
+const webhookSecretLength = 32
+
+// pgpMessageHeader prefixes the armored ciphertext of an encrypted webhook secret
+const pgpMessageHeader = "-----BEGIN PGP MESSAGE-----"
+
+// disallowedWebhookNetworks are the special-purpose networks, not otherwise covered by the
+// loopback, private, link-local and multicast checks, to which webhooks may not be delivered
+var disallowedWebhookNetworks = parseCIDRs(
+	"0.0.0.0/8",     // "this" network
+	"100.64.0.0/10", // carrier-grade NAT; includes cloud metadata endpoints (i.e., 100.100.100.200)
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // benchmarking
+	"240.0.0.0/4",   // reserved
+)
+
+// lookupWebhookHost resolves the addresses of a webhook url host
+var lookupWebhookHost = net.LookupIP
+
+var supportedEvents = map[string]bool{
+	EventTxBroadcast:      true,
+	EventTxSuccess:        true,
+	EventTxFailed:         true,
+	EventTxReorg:          true,
+	EventContractDeployed: true,
+}
+
+// Webhook instances are registered by an application or organization to receive signed
+// notifications of the given events, optionally scoped to a single network
+type Webhook struct {
+	provide.Model
+	ApplicationID  *uuid.UUID       `sql:"type:uuid" json:"application_id,omitempty"`
+	OrganizationID *uuid.UUID       `sql:"type:uuid" json:"organization_id,omitempty"`
+	NetworkID      *uuid.UUID       `sql:"type:uuid" json:"network_id,omitempty"`
+	URL            *string          `sql:"not null" json:"url"`
+	Secret         *string          `sql:"-" json:"secret,omitempty"` // HMAC-SHA256 signing secret; only rendered upon creation
+	Events         *json.RawMessage `sql:"type:json;not null" json:"events"`
+
+	EncryptedSecret *string `gorm:"column:secret" sql:"not null" json:"-"` // PGP-encrypted signing secret
+}
+
+// dispatchMsg is published when an event is dispatched; deliveries of the event are enqueued for
+// each subscribed webhook by the dispatch consumer, outside of the context which emitted the event
+type dispatchMsg struct {
+	ID             uuid.UUID        `json:"id"`
+	Event          string           `json:"event"`
+	ApplicationID  *uuid.UUID       `json:"application_id,omitempty"`
+	OrganizationID *uuid.UUID       `json:"organization_id,omitempty"`
+	NetworkID      uuid.UUID        `json:"network_id"`
+	Timestamp      time.Time        `json:"timestamp"`
+	Data           *json.RawMessage `json:"data"`
+}
+
+// Delivery is a single webhook notification and the log of its delivery attempts
+type Delivery struct {
+	provide.Model
+	WebhookID      uuid.UUID        `sql:"not null;type:uuid" json:"webhook_id"`
+	Event          *string          `sql:"not null" json:"event"`
+	Payload        *json.RawMessage `sql:"type:json;not null" json:"payload"`
+	Status         *string          `sql:"not null;default:'pending'" json:"status"`
+	Attempts       uint32           `sql:"not null;default:0" json:"attempts"`
+	ResponseStatus *int             `json:"response_status,omitempty"` // HTTP status code returned by the most recent attempt
+	Error          *string          `json:"error,omitempty"`           // error returned by the most recent attempt
+	NextAttemptAt  *time.Time       `json:"next_attempt_at,omitempty"`
+	DeliveredAt    *time.Time       `json:"delivered_at,omitempty"`
+}
+
+// TableName returns the table name of the webhook delivery log
+func (Delivery) TableName() string {
+	return "webhook_deliveries"
+}
+
+// Dispatch publishes the given event for delivery to each webhook registered by the given
+// application or organization which subscribes to the event on the given network; the data
+// is marshaled when the event is dispatched, and deliveries are enqueued asynchronously
+func Dispatch(event string, applicationID, organizationID *uuid.UUID, networkID uuid.UUID, data interface{}) {
+	if applicationID == nil && organizationID == nil {
+		return
+	}
+
+	err := dispatch(event, applicationID, organizationID, networkID, data)
+	if err != nil {
+		common.Log.Warningf("failed to dispatch %s webhook event; %s", event, err.Error())
+	}
+}
+
+func dispatch(event string, applicationID, organizationID *uuid.UUID, networkID uuid.UUID, data interface{}) error {
+	dispatchID, err := uuid.NewV4()
+	if err != nil {
+		return err
+	}
+
+	rawData, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_rawData := json.RawMessage(rawData)
+	payload, err := json.Marshal(&dispatchMsg{
+		ID:             dispatchID,
+		Event:          event,
+		ApplicationID:  applicationID,
+		OrganizationID: organizationID,
+		NetworkID:      networkID,
+		Timestamp:      time.Now().UTC(),
+		Data:           &_rawData,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = natsutil.NatsJetstreamPublish(natsWebhookDispatchSubject, payload)
+	return err
+}
+
+// subscribedWebhooksQuery returns the query for the webhooks registered by the given application
+// or organization on the given network
+func subscribedWebhooksQuery(db *gorm.DB, applicationID, organizationID *uuid.UUID, networkID uuid.UUID) *gorm.DB {
+	query := db.Where("network_id IS NULL OR network_id = ?", networkID)
+	if applicationID != nil && organizationID != nil {
+		query = query.Where("application_id = ? OR organization_id = ?", applicationID, organizationID)
+	} else if applicationID != nil {
+		query = query.Where("application_id = ?", applicationID)
+	} else {
+		query = query.Where("organization_id = ?", organizationID)
+	}
+	return query
+}
+
+// Create and persist a new webhook; a signing secret is generated if none is provided
+func (w *Webhook) Create() bool {
+	if !w.Validate() {
+		return false
+	}
+
+	if w.Secret == nil {
+		secret := make([]byte, webhookSecretLength)
+		_, err := rand.Read(secret)
+		if err != nil {
+			w.Errors = append(w.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("failed to generate webhook secret; %s", err.Error())),
+			})
+			return false
+		}
+		w.Secret = common.StringOrNil(hex.EncodeToString(secret))
+	}
+
+	if !w.encryptSecret() {
+		return false
+	}
+
+	db := dbconf.DatabaseConnection()
+
+	if db.NewRecord(w) {
+		result := db.Create(&w)
+		rowsAffected := result.RowsAffected
+		errors := result.GetErrors()
+		if len(errors) > 0 {
+			for _, err := range errors {
+				w.Errors = append(w.Errors, &provide.Error{
+					Message: common.StringOrNil(err.Error()),
+				})
+			}
+		}
+		if !db.NewRecord(w) {
+			return rowsAffected > 0
+		}
+	}
+	return false
+}
+
+// Delete a webhook and its delivery log
+func (w *Webhook) Delete() bool {
+	db := dbconf.DatabaseConnection()
+	result := db.Delete(w)
+	errors := result.GetErrors()
+	if len(errors) > 0 {
+		for _, err := range errors {
+			w.Errors = append(w.Errors, &provide.Error{
+				Message: common.StringOrNil(err.Error()),
+			})
+		}
+	}
+	return len(w.Errors) == 0
+}
+
+// Validate a webhook for persistence
+func (w *Webhook) Validate() bool {
+	w.Errors = make([]*provide.Error, 0)
+
+	if w.ApplicationID == nil && w.OrganizationID == nil {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil("webhook must be associated with an application or organization"),
+		})
+	}
+
+	if w.URL == nil {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil("webhook url is required"),
+		})
+	} else if err := validateWebhookURL(*w.URL); err != nil {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil(err.Error()),
+		})
+	}
+
+	events := w.ParseEvents()
+	if len(events) == 0 {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil("webhook must subscribe to at least one event"),
+		})
+	}
+
+	for _, event := range events {
+		if !supportedEvents[event] {
+			w.Errors = append(w.Errors, &provide.Error{
+				Message: common.StringOrNil(fmt.Sprintf("unsupported webhook event: %s", event)),
+			})
+		}
+	}
+
+	return len(w.Errors) == 0
+}
+
+// ParseEvents returns the events to which the webhook subscribes
+func (w *Webhook) ParseEvents() []string {
+	events := make([]string, 0)
+	if w.Events != nil {
+		err := json.Unmarshal(*w.Events, &events)
+		if err != nil {
+			common.Log.Warningf("failed to unmarshal webhook events; %s", err.Error())
+			return nil
+		}
+	}
+	return events
+}
+
+// Sign returns the hex-encoded HMAC-SHA256 signature of the given timestamp and payload
+func (w *Webhook) Sign(timestamp int64, payload []byte) (string, error) {
+	secret, err := w.decryptedSecret()
+	if err != nil {
+		return "", err
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(fmt.Sprintf("%d.", timestamp)))
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// encryptSecret encrypts the signing secret for persistence
+func (w *Webhook) encryptSecret() bool {
+	if w.Secret == nil {
+		return true
+	}
+
+	encryptedSecret, err := pgputil.PGPPubEncrypt([]byte(*w.Secret))
+	if err != nil {
+		w.Errors = append(w.Errors, &provide.Error{
+			Message: common.StringOrNil(fmt.Sprintf("failed to encrypt webhook secret; %s", err.Error())),
+		})
+		return false
+	}
+
+	w.EncryptedSecret = common.StringOrNil(string(encryptedSecret))
+	return true
+}
+
+// encryptLegacySecret encrypts and persists a signing secret which was persisted before secrets
+// were encrypted
+func (w *Webhook) encryptLegacySecret(db *gorm.DB) {
+	if w.EncryptedSecret == nil || strings.HasPrefix(*w.EncryptedSecret, pgpMessageHeader) {
+		return
+	}
+
+	w.Secret = w.EncryptedSecret
+	if !w.encryptSecret() {
+		common.Log.Warningf("failed to encrypt legacy secret of webhook: %s; %s", w.ID, *w.Errors[0].Message)
+		return
+	}
+
+	result := db.Model(w).Update("secret", *w.EncryptedSecret)
+	if result.Error != nil {
+		common.Log.Warningf("failed to persist encrypted legacy secret of webhook: %s; %s", w.ID, result.Error.Error())
+	}
+}
+
+// decryptedSecret returns the signing secret; secrets persisted before secrets were encrypted
+// are returned as-is
+func (w *Webhook) decryptedSecret() ([]byte, error) {
+	if w.Secret != nil {
+		return []byte(*w.Secret), nil
+	}
+
+	if w.EncryptedSecret == nil {
+		return nil, fmt.Errorf("no secret resolved for webhook: %s", w.ID)
+	}
+
+	if !strings.HasPrefix(*w.EncryptedSecret, pgpMessageHeader) {
+		return []byte(*w.EncryptedSecret), nil
+	}
+
+	secret, err := pgputil.PGPPubDecrypt([]byte(*w.EncryptedSecret))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt webhook secret; %s", err.Error())
+	}
+	return secret, nil
+}
+
+// validateWebhookURL returns an error if the given url is not an http(s) url, or if its host
+// resolves to a loopback, private, link-local or otherwise special-purpose address
+func validateWebhookURL(rawURL string) error {
+	webhookURL, err := url.Parse(rawURL)
+	if err != nil || (webhookURL.Scheme != "http" && webhookURL.Scheme != "https") || webhookURL.Hostname() == "" {
+		return fmt.Errorf("invalid webhook url: %s", rawURL)
+	}
+
+	host := webhookURL.Hostname()
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		ips, err = lookupWebhookHost(host)
+		if err != nil {
+			return fmt.Errorf("failed to resolve webhook url host: %s; %s", host, err.Error())
+		}
+	}
+
+	if len(ips) == 0 {
+		return fmt.Errorf("failed to resolve webhook url host: %s", host)
+	}
+
+	for _, ip := range ips {
+		if isDisallowedWebhookIP(ip) {
+			return fmt.Errorf("invalid webhook url: %s; host resolves to disallowed address %s", rawURL, ip)
+		}
+	}
+
+	return nil
+}
+
+// isDisallowedWebhookIP returns true if webhooks may not be delivered to the given address
+func isDisallowedWebhookIP(ip net.IP) bool {
+	if ip.IsLoopback() ||
+		ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() || // includes the 169.254.169.254 cloud metadata endpoint
+		ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() ||
+		ip.IsUnspecified() {
+		return true
+	}
+
+	for _, network := range disallowedWebhookNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCIDRs parses the given CIDR notation networks
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0)
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(fmt.Sprintf("invalid CIDR: %s; %s", cidr, err.Error()))
+		}
+		networks = append(networks, network)
+	}
+	return networks
+}
+
+// errDisallowedWebhookAddress is returned when a webhook delivery connects to a disallowed address
+var errDisallowedWebhookAddress = errors.New("webhook url resolves to a disallowed address")
+
+// subscribes returns true if the webhook subscribes to the given event
+func (w *Webhook) subscribes(event string) bool {
+	for _, evt := range w.ParseEvents() {
+		if evt == event {
+			return true
+		}
+	}
+	return false
+}
+
+// deliveryID returns the id of the delivery of the given dispatched event to this webhook; the id
+// is derived from the dispatch so redelivered dispatch messages do not enqueue duplicate deliveries
+func (w *Webhook) deliveryID(dispatchID uuid.UUID) uuid.UUID {
+	return uuid.NewV5(dispatchID, w.ID.String())
+}
+
+// enqueue persists a pending delivery of the given dispatched event, unless it was previously
+// persisted, and publishes it for delivery
+func (w *Webhook) enqueue(db *gorm.DB, msg *dispatchMsg) error {
+	deliveryID := w.deliveryID(msg.ID)
+
+	var enqueued int
+	db.Model(&Delivery{}).Where("id = ?", deliveryID).Count(&enqueued)
+	if enqueued == 0 {
+		payload, err := json.Marshal(map[string]interface{}{
+			"id":         deliveryID.String(),
+			"event":      msg.Event,
+			"network_id": msg.NetworkID.String(),
+			"timestamp":  msg.Timestamp,
+			"data":       msg.Data,
+		})
+		if err != nil {
+			return err
+		}
+
+		rawPayload := json.RawMessage(payload)
+		delivery := &Delivery{
+			WebhookID: w.ID,
+			Event:     common.StringOrNil(msg.Event),
+			Payload:   &rawPayload,
+			Status:    common.StringOrNil(deliveryStatusPending),
+		}
+		delivery.ID = deliveryID
+
+		result := db.Create(&delivery)
+		if result.Error != nil {
+			return result.Error
+		}
+	}
+
+	payload, _ := json.Marshal(map[string]interface{}{
+		"delivery_id": deliveryID.String(),
+	})
+	_, err := natsutil.NatsJetstreamPublish(natsWebhookDeliverySubject, payload)
+	return err
+}
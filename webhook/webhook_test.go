@@ -0,0 +1,195 @@
+//go:build unit
+// +build unit
+
+/*
+ * Copyright 2017-2022 Provide Technologies Inc.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package webhook
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	pgputil "github.com/kthomas/go-pgputil"
+	uuid "github.com/kthomas/go.uuid"
+	"github.com/provideplatform/nchain/common"
+)
+
+func stubLookupWebhookHost(t *testing.T, ips map[string][]net.IP) {
+	lookup := lookupWebhookHost
+	lookupWebhookHost = func(host string) ([]net.IP, error) {
+		if resolved, ok := ips[host]; ok {
+			return resolved, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	t.Cleanup(func() {
+		lookupWebhookHost = lookup
+	})
+}
+
+func TestIsDisallowedWebhookIP(t *testing.T) {
+	for addr, disallowed := range map[string]bool{
+		"127.0.0.1":       true,
+		"::1":             true,
+		"10.1.2.3":        true,
+		"172.16.0.1":      true,
+		"192.168.1.1":     true,
+		"169.254.169.254": true, // cloud metadata
+		"100.100.100.200": true, // cloud metadata
+		"0.0.0.0":         true,
+		"fd00:ec2::254":   true, // cloud metadata
+		"fe80::1":         true,
+		"::ffff:10.0.0.1": true,
+		"224.0.0.1":       true,
+		"8.8.8.8":         false,
+		"2606:4700::1111": false,
+	} {
+		if isDisallowedWebhookIP(net.ParseIP(addr)) != disallowed {
+			t.Errorf("address %s disallowed: %v; expected %v", addr, !disallowed, disallowed)
+		}
+	}
+}
+
+func TestValidateWebhookURL(t *testing.T) {
+	stubLookupWebhookHost(t, map[string][]net.IP{
+		"hooks.example.com":        {net.ParseIP("93.184.216.34")},
+		"metadata.google.internal": {net.ParseIP("169.254.169.254")},
+		"mixed.example.com":        {net.ParseIP("93.184.216.34"), net.ParseIP("10.0.0.1")},
+	})
+
+	for rawURL, valid := range map[string]bool{
+		"https://hooks.example.com/nchain":        true,
+		"http://hooks.example.com:8080/nchain":    true,
+		"https://8.8.8.8/nchain":                  true,
+		"https://metadata.google.internal/":       false,
+		"https://mixed.example.com/nchain":        false,
+		"http://127.0.0.1:8080/":                  false,
+		"http://[::1]/":                           false,
+		"http://169.254.169.254/latest/meta-data": false,
+		"https://unresolvable.example.com/":       false,
+		"ftp://hooks.example.com/":                false,
+		"https:///nchain":                         false,
+	} {
+		err := validateWebhookURL(rawURL)
+		if valid && err != nil {
+			t.Errorf("expected webhook url %s to be valid; %s", rawURL, err.Error())
+		} else if !valid && err == nil {
+			t.Errorf("expected webhook url %s to be rejected", rawURL)
+		}
+	}
+}
+
+func TestWebhookDeliveryRefusesDisallowedAddress(t *testing.T) {
+	delivered := false
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		delivered = true
+	}))
+	defer server.Close()
+
+	// the webhook host may have resolved to an allowed address when the webhook was created
+	webhook := &Webhook{
+		URL:    common.StringOrNil(server.URL),
+		Secret: common.StringOrNil("secret"),
+	}
+
+	payload := []byte(`{}`)
+	delivery := &Delivery{
+		Event:   common.StringOrNil(EventTxSuccess),
+		Payload: (*json.RawMessage)(&payload),
+	}
+	delivery.ID, _ = uuid.NewV4()
+
+	err := webhook.deliver(delivery)
+	if err == nil || !strings.Contains(err.Error(), errDisallowedWebhookAddress.Error()) {
+		t.Errorf("expected delivery to a loopback address to be refused; %v", err)
+	}
+	if delivered {
+		t.Error("webhook was delivered to a loopback address")
+	}
+}
+
+func TestWebhookDeliveryBackoff(t *testing.T) {
+	for attempts, backoff := range map[uint32]time.Duration{
+		1: webhookDeliveryInitialBackoff,
+		2: webhookDeliveryInitialBackoff * 2,
+		4: webhookDeliveryInitialBackoff * 8,
+	} {
+		if webhookDeliveryBackoff(attempts) != backoff {
+			t.Errorf("backoff after %d attempt(s) %v; expected %v", attempts, webhookDeliveryBackoff(attempts), backoff)
+		}
+	}
+}
+
+func TestWebhookDeliveryIDIsDerivedFromDispatch(t *testing.T) {
+	webhook := &Webhook{}
+	webhook.ID, _ = uuid.NewV4()
+	dispatchID, _ := uuid.NewV4()
+	otherDispatchID, _ := uuid.NewV4()
+
+	if webhook.deliveryID(dispatchID) != webhook.deliveryID(dispatchID) {
+		t.Error("expected redelivered dispatch to resolve the same delivery id")
+	}
+	if webhook.deliveryID(dispatchID) == webhook.deliveryID(otherDispatchID) {
+		t.Error("expected distinct dispatches to resolve distinct delivery ids")
+	}
+}
+
+func TestWebhookSecretIsEncrypted(t *testing.T) {
+	if os.Getenv("PGP_PUBLIC_KEY") == "" {
+		t.Skip("PGP_PUBLIC_KEY is required to encrypt webhook secrets")
+	}
+	pgputil.RequirePGP()
+
+	webhook := &Webhook{
+		Secret: common.StringOrNil("0123456789abcdef"),
+	}
+	if !webhook.encryptSecret() {
+		t.Fatalf("failed to encrypt webhook secret; %s", *webhook.Errors[0].Message)
+	}
+	if !strings.HasPrefix(*webhook.EncryptedSecret, pgpMessageHeader) || strings.Contains(*webhook.EncryptedSecret, *webhook.Secret) {
+		t.Fatalf("expected persisted webhook secret to be encrypted")
+	}
+
+	signature, _ := webhook.Sign(1700000000, []byte(`{}`))
+
+	persisted := &Webhook{EncryptedSecret: webhook.EncryptedSecret}
+	persistedSignature, err := persisted.Sign(1700000000, []byte(`{}`))
+	if err != nil {
+		t.Fatalf("failed to sign using encrypted webhook secret; %s", err.Error())
+	}
+	if persistedSignature != signature {
+		t.Errorf("signature %s using encrypted secret; expected %s", persistedSignature, signature)
+	}
+}
+
+func TestWebhookSignWithLegacySecret(t *testing.T) {
+	webhook := &Webhook{Secret: common.StringOrNil("legacy")}
+	signature, _ := webhook.Sign(1700000000, []byte(`{}`))
+
+	legacy := &Webhook{EncryptedSecret: common.StringOrNil("legacy")}
+	legacySignature, err := legacy.Sign(1700000000, []byte(`{}`))
+	if err != nil || legacySignature != signature {
+		t.Errorf("signature %s (%v) using legacy plaintext secret; expected %s", legacySignature, err, signature)
+	}
+}